@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.env")
+	data := "\xef\xbb\xbf# written by provisioning\r\nMASTER_URL=http://master:8080\r\n\r\nAGENT_TAGS=win,'lab'\r\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MASTER_URL", "")
+	t.Setenv("AGENT_TAGS", "")
+
+	if err := loadEnvFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("MASTER_URL"); got != "http://master:8080" {
+		t.Errorf("MASTER_URL = %q", got)
+	}
+	if got := os.Getenv("AGENT_TAGS"); got != "win,'lab'" {
+		t.Errorf("AGENT_TAGS = %q, want the value verbatim", got)
+	}
+}
+
+func TestLoadEnvFileRejectsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.env")
+	if err := os.WriteFile(path, []byte("MASTER_URL=http://m\nnot a setting\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MASTER_URL", "")
+
+	err := loadEnvFile(path)
+	if err == nil || !strings.Contains(err.Error(), "agent.env:2") {
+		t.Fatalf("err = %v, want one naming line 2", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemMachineIDPaths are checked in order for an OS-provided machine ID.
+var systemMachineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// loadMachineID returns a stable identifier for this host. It prefers the OS
+// machine ID and otherwise falls back to a UUID persisted under stateDir.
+// An empty result means the master falls back to hostname+IP matching.
+func loadMachineID(stateDir string) string {
+	for _, p := range systemMachineIDPaths {
+		if id := readID(p); id != "" {
+			return id
+		}
+	}
+	p := filepath.Join(stateDir, "machine-id")
+	if id := readID(p); id != "" {
+		return id
+	}
+	id, err := newUUID()
+	if err == nil {
+		err = os.MkdirAll(stateDir, 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(p, []byte(id+"\n"), 0o644)
+	}
+	if err != nil {
+		slog.Warn("no stable machine id, registering by hostname+ip", "err", err)
+		return ""
+	}
+	return id
+}
+
+func readID(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -3,43 +3,160 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	_ "time/tzdata" // task timezones must resolve on hosts without zoneinfo
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/aven/ngoogle/internal/agent/client"
 	"github.com/aven/ngoogle/internal/agent/executor"
 	"github.com/aven/ngoogle/internal/agent/reporter"
+	"github.com/aven/ngoogle/internal/buildinfo"
+	"github.com/aven/ngoogle/internal/logging"
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/tracing"
+	"github.com/aven/ngoogle/pkg/agentrpc"
+	"github.com/aven/ngoogle/pkg/masterclient"
 	"github.com/aven/ngoogle/pkg/ratelimit"
 )
 
-const agentVersion = "1.0.0"
+var tracer = otel.Tracer("github.com/aven/ngoogle/cmd/agent")
 
 func main() {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	envFile := flag.String("env-file", "", "read KEY=VALUE settings from this file before starting")
+	flag.Parse()
+	if *envFile != "" {
+		if err := loadEnvFile(*envFile); err != nil {
+			fmt.Fprintln(os.Stderr, "agent:", err)
+			os.Exit(1)
+		}
+	}
+	if runService(run) {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	run(ctx, os.Stdout)
+}
+
+// run is the agent: it registers with the master, then heartbeats and runs
+// the tasks it is assigned until ctx is cancelled. It logs to logOut.
+func run(ctx context.Context, logOut io.Writer) {
+	logger, err := logging.New(logOut, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		slog.Error("invalid logging configuration", "err", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "ngoogle-agent")
+	if err != nil {
+		slog.Error("tracing setup", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("tracing shutdown", "err", err)
+		}
+	}()
 
 	masterURL := envOr("MASTER_URL", "http://localhost:8080")
 	hostIP := envOr("AGENT_HOST_IP", detectIP())
 	agentPort := 0 // agents don't expose a public port
-
-	slog.Info("agent starting", "master", masterURL, "ip", hostIP)
-
-	mc := client.New(masterURL)
+	build := buildinfo.Get()
+
+	transport := envOr("MASTER_TRANSPORT", "http")
+
+	var mc *client.Client
+	switch transport {
+	case "http":
+		slog.Info("agent starting", "master", masterURL, "ip", hostIP, "version", build.Version, "commit", build.Commit)
+		var opts []masterclient.Option
+		if tracing.Enabled() {
+			opts = append(opts, masterclient.WithHTTPClient(&http.Client{
+				Timeout:   30 * time.Second,
+				Transport: tracing.Transport(nil),
+			}))
+		}
+		mc = client.New(masterURL, opts...)
+	case "grpc":
+		grpcAddr := os.Getenv("MASTER_GRPC_ADDR")
+		if grpcAddr == "" {
+			slog.Error("MASTER_GRPC_ADDR is required when MASTER_TRANSPORT is grpc")
+			os.Exit(1)
+		}
+		creds := insecure.NewCredentials()
+		if v := os.Getenv("MASTER_GRPC_TLS"); v != "" {
+			useTLS, err := strconv.ParseBool(v)
+			if err != nil {
+				slog.Error("invalid MASTER_GRPC_TLS", "value", v)
+				os.Exit(1)
+			}
+			if useTLS {
+				creds = credentials.NewTLS(&tls.Config{})
+			}
+		}
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if tracing.Enabled() {
+			opts = append(opts, tracing.DialOption())
+		}
+		rc, err := agentrpc.Dial(grpcAddr, opts...)
+		if err != nil {
+			slog.Error("grpc dial", "err", err)
+			os.Exit(1)
+		}
+		defer rc.Close()
+		slog.Info("agent starting", "master", grpcAddr, "transport", transport, "ip", hostIP, "version", build.Version, "commit", build.Commit)
+		mc = client.NewWithTransport(rc)
+	default:
+		slog.Error("invalid MASTER_TRANSPORT", "value", transport)
+		os.Exit(1)
+	}
+	mc.SetToken(os.Getenv("AGENT_TOKEN"))
+	mc.SetBootstrapToken(os.Getenv("AGENT_BOOTSTRAP_TOKEN"))
+	mc.SetTags(strings.Split(os.Getenv("AGENT_TAGS"), ","))
+	if v := os.Getenv("AGENT_CAPACITY_MBPS"); v != "" {
+		mbps, err := strconv.ParseFloat(v, 64)
+		if err != nil || mbps < 0 {
+			slog.Error("invalid AGENT_CAPACITY_MBPS", "value", v)
+			os.Exit(1)
+		}
+		mc.SetCapacity(mbps)
+	}
+	mc.SetMachineID(loadMachineID(stateDir()))
 
 	// ─── Register with retry ─────────────────────────────────────────────────
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	hostname, _ := os.Hostname()
 	var regResp *client.RegisterResponse
 	for {
 		var err error
-		regResp, err = mc.Register(ctx, hostname, hostIP, agentPort, agentVersion)
+		regResp, err = mc.Register(ctx, hostname, hostIP, agentPort, build.Version, build.Commit)
 		if err == nil {
 			slog.Info("registered", "agent_id", regResp.ID)
 			break
@@ -52,44 +169,88 @@ func main() {
 		}
 	}
 
-	// ─── Graceful shutdown ────────────────────────────────────────────────────
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-		<-sig
-		slog.Info("agent shutting down...")
-		cancel()
-	}()
-
 	// ─── Task runner ──────────────────────────────────────────────────────────
-	runner := &taskRunner{
-		client:  mc,
-		agentID: regResp.ID,
-		running: make(map[string]context.CancelFunc),
-	}
+	runner := newTaskRunner(mc)
 
-	nic := newNICSampler()
-	slog.Info("nic sampler ready", "iface", nic.iface)
+	nic := newNICSampler(newNICCollector())
+	if nic.iface != "" {
+		slog.Info("nic sampler ready", "iface", nic.iface)
+	} else {
+		slog.Warn("cannot measure network interfaces, reporting task rates only", "os", runtime.GOOS)
+	}
 
 	// ─── Main loop: heartbeat + task pull ────────────────────────────────────
-	heartbeatTicker := time.NewTicker(10 * time.Second)
-	pullTicker := time.NewTicker(5 * time.Second)
+	// The master sets both intervals at registration; the defaults cover
+	// masters that do not.
+	heartbeatInterval, pullInterval := 10*time.Second, 5*time.Second
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	pullTicker := time.NewTicker(pullInterval)
 	defer heartbeatTicker.Stop()
 	defer pullTicker.Stop()
+	applyIntervals := func(resp *client.RegisterResponse) {
+		if d := resp.HeartbeatInterval; d > 0 && d != heartbeatInterval {
+			heartbeatInterval = d
+			heartbeatTicker.Reset(d)
+			slog.Info("heartbeat interval set by master", "interval", d)
+		}
+		if d := resp.PullInterval; d > 0 && d != pullInterval {
+			pullInterval = d
+			pullTicker.Reset(d)
+			slog.Info("pull interval set by master", "interval", d)
+		}
+	}
+	applyIntervals(regResp)
+
+	// Task lists are pushed over the master's task stream; while it is down
+	// the agent pulls them instead. Pulls run in the background so that a
+	// long poll held open by the master does not delay heartbeats, and at
+	// most one is in flight.
+	var streaming atomic.Bool
+	go runner.stream(ctx, &streaming)
+	pullDone := make(chan bool, 1)
+	pulling := false
+	startPull := func() {
+		pulling = true
+		go func() { pullDone <- runner.pull(ctx) }()
+	}
+	startPull()
 
 	for {
 		select {
 		case <-ctx.Done():
+			slog.Info("agent shutting down...")
 			runner.stopAll()
 			return
 
 		case <-heartbeatTicker.C:
-			if err := mc.Heartbeat(ctx, nic.Rate()); err != nil {
+			err := mc.Heartbeat(ctx, heartbeatRates(nic, runner), runner.runningTasks())
+			if errors.Is(err, masterclient.ErrUnauthorized) {
+				// Token rotated or agent removed on the master: re-register
+				// to obtain a token and keep going.
+				slog.Warn("heartbeat rejected, re-registering", "err", err)
+				if resp, err := mc.Register(ctx, hostname, hostIP, agentPort, build.Version, build.Commit); err != nil {
+					slog.Error("re-register failed", "err", err)
+				} else {
+					slog.Info("re-registered", "agent_id", resp.ID)
+					applyIntervals(resp)
+				}
+			} else if err != nil {
 				slog.Warn("heartbeat failed", "err", err)
 			}
 
 		case <-pullTicker.C:
-			runner.pull(ctx)
+			if !pulling && !streaming.Load() {
+				startPull()
+			}
+
+		case longPoll := <-pullDone:
+			// A master that long-polls answers as soon as the task list
+			// changes, so ask again straight away; otherwise (or after an
+			// error) fall back to the pull ticker.
+			pulling = false
+			if longPoll && !streaming.Load() {
+				startPull()
+			}
 		}
 	}
 }
@@ -97,34 +258,110 @@ func main() {
 // ─── Task Runner ──────────────────────────────────────────────────────────────
 
 type taskRunner struct {
-	client  *client.Client
-	agentID string
+	client *client.Client
 
+	// mu guards the maps below. running and meters always hold the same
+	// task IDs: an execution's cancel func and its meter are added and
+	// removed together.
 	mu      sync.Mutex
 	running map[string]context.CancelFunc
 	meters  map[string]*ratelimit.Meter
+	// paused holds the bytes downloaded so far for each task paused while
+	// it ran here, so the task keeps counting from there when resumed.
+	paused map[string]int64
+}
+
+func newTaskRunner(c *client.Client) *taskRunner {
+	return &taskRunner{
+		client:  c,
+		running: make(map[string]context.CancelFunc),
+		meters:  make(map[string]*ratelimit.Meter),
+		paused:  make(map[string]int64),
+	}
+}
+
+// pullWait is how long a pull may be held open by the master waiting for the
+// agent's tasks to change.
+const pullWait = 25 * time.Second
+
+// streamIdle is how long the task stream may stay silent before the agent
+// treats it as broken; the master sends a task list at least every 15s.
+const streamIdle = 45 * time.Second
+
+// stream keeps the agent subscribed to the master's task stream, applying
+// every task list pushed, and reconnects with exponential backoff when the
+// stream drops or the master does not offer one. streaming is set while the
+// stream is up, so that the main loop stops pulling.
+func (r *taskRunner) stream(ctx context.Context, streaming *atomic.Bool) {
+	backoff := time.Second
+	for {
+		connected := false
+		err := r.client.StreamTasks(ctx, streamIdle, func(tasks []*model.Task) {
+			if !connected {
+				connected = true
+				streaming.Store(true)
+				slog.Info("task stream connected")
+			}
+			r.apply(ctx, tasks)
+		})
+		streaming.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			slog.Warn("task stream lost, pulling until it reconnects", "err", err)
+			backoff = time.Second
+		} else {
+			slog.Debug("task stream unavailable", "err", err, "retry_in", backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
 }
 
-func (r *taskRunner) pull(ctx context.Context) {
-	tasks, err := r.client.PullTasks(ctx)
+// pull fetches the agent's tasks and applies them. It reports whether the
+// master long-polled the request.
+func (r *taskRunner) pull(ctx context.Context) bool {
+	tasks, longPoll, err := r.client.WaitTasks(ctx, pullWait)
 	if err != nil {
-		slog.Warn("pull tasks failed", "err", err)
-		return
+		if ctx.Err() == nil {
+			slog.Warn("pull tasks failed", "err", err)
+		}
+		return false
 	}
+	r.apply(ctx, tasks)
+	return longPoll
+}
 
+// apply starts, pauses and stops executions to match tasks, the complete
+// list of tasks assigned to the agent.
+func (r *taskRunner) apply(ctx context.Context, tasks []*model.Task) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	for _, task := range tasks {
+		if task.Status == model.TaskStatusPaused {
+			if cancel, ok := r.running[task.ID]; ok {
+				slog.Info("task paused, stopping execution", "task_id", task.ID)
+				r.paused[task.ID] = r.meters[task.ID].TotalBytes()
+				cancel()
+				delete(r.running, task.ID)
+				delete(r.meters, task.ID)
+			}
+			continue
+		}
 		if _, ok := r.running[task.ID]; ok {
 			continue // already running
 		}
 		taskCtx, cancel := context.WithCancel(ctx)
 		r.running[task.ID] = cancel
-		if r.meters == nil {
-			r.meters = make(map[string]*ratelimit.Meter)
-		}
 		meter := &ratelimit.Meter{}
+		meter.Seed(r.resumeBytes(task))
+		delete(r.paused, task.ID)
 		r.meters[task.ID] = meter
 		go r.execute(taskCtx, task, meter, cancel)
 	}
@@ -139,70 +376,187 @@ func (r *taskRunner) pull(ctx context.Context) {
 			}
 		}
 		if !found {
-			slog.Info("task no longer assigned, stopping", "task", taskID)
+			slog.Info("task no longer assigned, stopping", "task_id", taskID)
 			cancel()
 			delete(r.running, taskID)
 			delete(r.meters, taskID)
 		}
 	}
+	for taskID := range r.paused {
+		found := false
+		for _, t := range tasks {
+			found = found || t.ID == taskID
+		}
+		if !found {
+			delete(r.paused, taskID)
+		}
+	}
+}
+
+// resumeBytes returns the bytes a task had already downloaded on this agent:
+// the count kept when it was paused here or, for a single-agent task the
+// agent has no count for (it restarted meanwhile), the master's
+// total_bytes_done, which only this agent contributes to.
+func (r *taskRunner) resumeBytes(task *model.Task) int64 {
+	if n, ok := r.paused[task.ID]; ok {
+		return n
+	}
+	if task.ExecutionScope == model.TaskExecutionScopeSingleAgent {
+		return task.TotalBytesDone
+	}
+	return 0
 }
 
 func (r *taskRunner) execute(ctx context.Context, task *model.Task, meter *ratelimit.Meter, cancel context.CancelFunc) {
 	defer func() {
 		cancel()
-		r.mu.Lock()
-		delete(r.running, task.ID)
-		if r.meters != nil {
-			delete(r.meters, task.ID)
-		}
-		r.mu.Unlock()
+		r.finished(task.ID, meter)
 	}()
 
-	slog.Info("executing task", "task", task.ID, "type", task.Type, "url", task.TargetURL)
+	log := slog.With("task_id", task.ID, "agent_id", r.client.AgentID())
+
+	// Calls to the master made while the task runs carry this span's trace
+	// context, so the master's side of them joins the same trace.
+	ctx, span := tracer.Start(ctx, "agent.execute_task", trace.WithAttributes(
+		attribute.String("task_id", task.ID),
+		attribute.String("task_type", string(task.Type)),
+	))
+	defer span.End()
+
+	// A resumed task continues from the bytes it had already downloaded; its
+	// byte target covers only what is left, and there is no second warm-up.
+	resumed := meter.TotalBytes()
+	if resumed > 0 {
+		log.Info("resuming task", "bytes_done", resumed)
+		if task.TotalBytesTarget > 0 {
+			if resumed >= task.TotalBytesTarget {
+				if err := r.client.MarkDone(ctx, task.ID); err != nil {
+					log.Warn("mark done failed", "err", err)
+				}
+				return
+			}
+			task.TotalBytesTarget -= resumed
+		}
+	}
+
+	// ${SEQ} is left to the executor, which numbers its requests.
+	task.ExpandURLVars(r.client.URLVars())
+
+	log.Info("executing task", "type", task.Type, "url", task.TargetURL)
 	if err := r.client.MarkRunning(ctx, task.ID); err != nil {
-		slog.Warn("mark running failed", "task", task.ID, "err", err)
+		log.Warn("mark running failed", "err", err)
 	}
 
-	rep := reporter.NewTaskReporter(task.ID, r.agentID, r.client, meter)
-	go rep.Run(ctx)
+	rep := reporter.NewTaskReporter(task.ID, r.client.AgentID(), r.client, meter, log)
+	if resumed == 0 {
+		rep.SetWarmup(time.Duration(task.WarmupSec) * time.Second)
+	}
+	repCtx, stopReporting := context.WithCancel(ctx)
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		rep.Run(repCtx)
+	}()
 
 	progressFn := func(bytesTotal int64) {
 		// metrics are handled by reporter
 	}
 
-	var err error
-	switch task.Type {
-	case model.TaskTypeYoutube:
-		exe := &executor.YoutubeExecutor{}
-		err = exe.Run(ctx, task, rep.Meter(), progressFn)
-	case model.TaskTypeStatic:
-		exe := &executor.StaticExecutor{}
-		err = exe.Run(ctx, task, rep.Meter(), progressFn)
-	case model.TaskTypeMixed:
-		exe := &executor.MixedExecutor{}
+	startedAt := time.Now()
+	exe, err := executor.New(task.Type, executor.Config{Log: log, Latency: rep.Latency(), Requests: rep})
+	if err == nil {
 		err = exe.Run(ctx, task, rep.Meter(), progressFn)
+	}
+
+	// The final metrics report reaches the master before the task ends
+	// there, so the task's result snapshot includes its last bytes.
+	stopReporting()
+	<-reported
+
+	var reason model.StopReason
+	switch {
+	case errors.Is(err, executor.ErrStopped), ctx.Err() != nil:
+		// An error racing the cancellation is not the task's failure.
+		reason = model.StopReasonStopped
+	case err != nil:
+		reason = model.StopReasonFailed
+	case timeUp(task, startedAt, time.Now()):
+		reason = model.StopReasonEndTime
 	default:
-		slog.Error("unknown task type", "type", task.Type)
-		return
+		reason = model.StopReasonCompleted
 	}
 
-	if err != nil {
-		slog.Error("task failed", "task", task.ID, "err", err)
-		if ctx.Err() == nil {
-			if markErr := r.client.MarkFailed(context.Background(), task.ID, err.Error()); markErr != nil {
-				slog.Warn("mark failed failed", "task", task.ID, "err", markErr)
-			}
+	// The result goes before the status change too, and has the exact
+	// final counters.
+	res := rep.Result(reason, time.Since(startedAt))
+	if reason == model.StopReasonFailed {
+		res.Error = err.Error()
+	}
+	if resErr := r.client.ReportResult(context.WithoutCancel(ctx), res); resErr != nil {
+		log.Warn("report result failed", "err", resErr)
+	}
+
+	switch reason {
+	case model.StopReasonStopped:
+		// Paused, stopped or unassigned by the master, which already knows.
+		log.Info("task stopped")
+	case model.StopReasonFailed:
+		log.Error("task failed", "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		if markErr := r.client.MarkFailed(context.WithoutCancel(ctx), task.ID, err.Error()); markErr != nil {
+			log.Warn("mark failed failed", "err", markErr)
 		}
-	} else {
-		slog.Info("task completed", "task", task.ID)
-		if ctx.Err() == nil {
-			if markErr := r.client.MarkDone(context.Background(), task.ID); markErr != nil {
-				slog.Warn("mark done failed", "task", task.ID, "err", markErr)
-			}
+	case model.StopReasonEndTime:
+		// The master's scheduler stops the task itself, as stopped or, if
+		// a target was reached as well, done.
+		log.Info("task reached its end time")
+	default:
+		log.Info("task completed")
+		if markErr := r.client.MarkDone(context.WithoutCancel(ctx), task.ID); markErr != nil {
+			log.Warn("mark done failed", "err", markErr)
 		}
 	}
 }
 
+// timeUp reports whether task's end_at or duration_sec, counted from
+// startedAt when its execution began, had passed at now.
+func timeUp(task *model.Task, startedAt, now time.Time) bool {
+	if task.EndAt != nil && !now.Before(*task.EndAt) {
+		return true
+	}
+	return task.DurationSec > 0 && now.Sub(startedAt) >= time.Duration(task.DurationSec)*time.Second
+}
+
+// finished removes the execution of taskID metered by meter once it has
+// returned. A task paused and resumed meanwhile already runs again with a
+// new meter, and that execution is left alone.
+func (r *taskRunner) finished(taskID string, meter *ratelimit.Meter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.meters[taskID] == meter {
+		delete(r.running, taskID)
+		delete(r.meters, taskID)
+	}
+	if n, ok := r.paused[taskID]; ok {
+		// Paused: keep everything downloaded up to the moment the
+		// executor returned.
+		r.paused[taskID] = max(n, meter.TotalBytes())
+	}
+}
+
+// runningTasks returns the IDs of the tasks executing here, sorted. It is
+// never nil, so that an agent running nothing still reports it.
+func (r *taskRunner) runningTasks() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.running))
+	for id := range r.running {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
 func (r *taskRunner) totalRate() float64 {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -219,6 +573,8 @@ func (r *taskRunner) stopAll() {
 	for _, cancel := range r.running {
 		cancel()
 	}
+	clear(r.running)
+	clear(r.meters)
 }
 
 // ─── Helpers ──────────────────────────────────────────────────────────────────
@@ -230,14 +586,24 @@ func envOr(key, def string) string {
 	return def
 }
 
+// stateDir is where the agent keeps state across restarts, such as its
+// machine ID: AGENT_STATE_DIR, or the platform's default.
+func stateDir() string {
+	if runtime.GOOS == "windows" {
+		return envOr("AGENT_STATE_DIR", filepath.Join(os.Getenv("ProgramData"), "ngoogle-agent"))
+	}
+	return envOr("AGENT_STATE_DIR", "/var/lib/ngoogle-agent")
+}
+
 func detectIP() string {
-	// Try to find the non-loopback IP
+	// Try to find the non-loopback IP. Link-local addresses, which Windows
+	// assigns to interfaces without DHCP, are not reachable either.
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return "127.0.0.1"
 	}
 	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
 			if ipnet.IP.To4() != nil {
 				return ipnet.IP.String()
 			}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/agent/client"
+	"github.com/aven/ngoogle/internal/master/handler"
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+	"github.com/aven/ngoogle/pkg/masterclient"
+	"github.com/aven/ngoogle/pkg/ratelimit"
+)
+
+// fakeMaster is a client.Transport answering pulls with tasks() and
+// accepting every other call. It records the task reports it gets.
+type fakeMaster struct {
+	tasks func() []*model.Task
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeMaster) record(call string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *fakeMaster) recorded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+func (f *fakeMaster) RegisterAgent(context.Context, *masterclient.RegisterRequest) (*masterclient.RegisterResponse, error) {
+	return &masterclient.RegisterResponse{Agent: &model.Agent{ID: "agent1"}, Token: "tok"}, nil
+}
+func (f *fakeMaster) Heartbeat(context.Context, string, string, model.AgentRates, []string) error {
+	return nil
+}
+func (f *fakeMaster) PullTasks(context.Context, string) ([]*model.Task, error) {
+	return f.tasks(), nil
+}
+func (f *fakeMaster) PullTasksWait(context.Context, string, string, time.Duration) ([]*model.Task, string, error) {
+	return f.tasks(), "", nil
+}
+func (f *fakeMaster) StreamTasks(ctx context.Context, _ string, _ time.Duration, _ func([]*model.Task)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+func (f *fakeMaster) ReportTaskMetrics(_ context.Context, _ string, m *model.TaskMetrics) error {
+	return f.record(fmt.Sprintf("metrics %s %d", m.TaskID, m.BytesTotal))
+}
+func (f *fakeMaster) ReportTaskResult(_ context.Context, _ string, r *model.AgentTaskResult) error {
+	return f.record(fmt.Sprintf("result %s %s %d", r.TaskID, r.StopReason, r.BytesTotal))
+}
+func (f *fakeMaster) MarkTaskRunning(_ context.Context, id string) error {
+	return f.record("running " + id)
+}
+func (f *fakeMaster) MarkTaskDone(_ context.Context, id string) error { return f.record("done " + id) }
+func (f *fakeMaster) MarkTaskFailed(_ context.Context, id, reason string) error {
+	return f.record("failed " + id + ": " + reason)
+}
+
+func newTestRunner(t *testing.T, tasks func() []*model.Task) *taskRunner {
+	t.Helper()
+	r, _ := newRecordingRunner(t, tasks)
+	return r
+}
+
+// newRecordingRunner returns a runner and the fake master it reports to.
+func newRecordingRunner(t *testing.T, tasks func() []*model.Task) (*taskRunner, *fakeMaster) {
+	t.Helper()
+	m := &fakeMaster{tasks: tasks}
+	c := client.NewWithTransport(m)
+	if _, err := c.Register(context.Background(), "h", "127.0.0.1", 0, "1.0.0", ""); err != nil {
+		t.Fatal(err)
+	}
+	return newTaskRunner(c), m
+}
+
+// runTask executes task on r as a pull would start it and waits for it.
+func runTask(r *taskRunner, task *model.Task) {
+	ctx, cancel := context.WithCancel(context.Background())
+	meter := &ratelimit.Meter{}
+	r.mu.Lock()
+	r.running[task.ID], r.meters[task.ID] = cancel, meter
+	r.mu.Unlock()
+	r.execute(ctx, task, meter, cancel)
+}
+
+// checkInvariant fails unless running and meters hold the same task IDs.
+func checkInvariant(t *testing.T, r *taskRunner) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.running) != len(r.meters) {
+		t.Fatalf("running has %d tasks, meters %d", len(r.running), len(r.meters))
+	}
+	for id := range r.running {
+		if r.meters[id] == nil {
+			t.Fatalf("task %s runs without a meter", id)
+		}
+	}
+}
+
+// TestTaskRunnerConcurrentPullExecuteRate runs pulls that start, pause and
+// drop tasks while executions finish and the rate and running tasks are
+// read, as heartbeats do; run with -race.
+func TestTaskRunnerConcurrentPullExecuteRate(t *testing.T) {
+	var mu sync.Mutex
+	round := 0
+	tasks := func() []*model.Task {
+		mu.Lock()
+		defer mu.Unlock()
+		round++
+		var out []*model.Task
+		for i := range 8 {
+			status := model.TaskStatusRunning
+			if (round+i)%3 == 0 {
+				status = model.TaskStatusPaused
+			}
+			if (round+i)%4 == 0 {
+				continue // unassigned this round
+			}
+			// An unknown type makes execute return at once, so executions
+			// finish while later pulls are applied.
+			out = append(out, &model.Task{ID: fmt.Sprintf("t%d", i), Type: "noop", Status: status})
+		}
+		return out
+	}
+	r := newTestRunner(t, tasks)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		// The agent keeps at most one pull in flight.
+		defer wg.Done()
+		defer close(done)
+		for range 200 {
+			r.pull(ctx)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = r.totalRate()
+				_ = r.runningTasks()
+			}
+		}
+	}()
+	wg.Wait()
+
+	checkInvariant(t, r)
+	r.stopAll()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.running) != 0 || len(r.meters) != 0 {
+		t.Fatalf("stopAll left %d running, %d meters", len(r.running), len(r.meters))
+	}
+}
+
+func TestFinishedKeepsResumedExecution(t *testing.T) {
+	r := newTestRunner(t, nil)
+	old, resumed := &ratelimit.Meter{}, &ratelimit.Meter{}
+	old.Seed(100)
+	r.running["t1"] = func() {}
+	r.meters["t1"] = resumed
+
+	// The execution paused before the task was resumed returns late.
+	r.finished("t1", old)
+
+	if r.running["t1"] == nil || r.meters["t1"] != resumed {
+		t.Fatal("a stale execution removed the resumed one")
+	}
+	r.finished("t1", resumed)
+	if len(r.running) != 0 || len(r.meters) != 0 {
+		t.Fatalf("finished left %d running, %d meters", len(r.running), len(r.meters))
+	}
+}
+
+func TestFinishedKeepsPausedBytes(t *testing.T) {
+	r := newTestRunner(t, nil)
+	meter := &ratelimit.Meter{}
+	meter.Seed(100)
+	r.paused["t1"] = 40
+
+	r.finished("t1", meter)
+
+	if r.paused["t1"] != 100 {
+		t.Fatalf("paused bytes = %d, want 100", r.paused["t1"])
+	}
+}
+
+func TestExecuteReportsCompletion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(make([]byte, 1000))
+	}))
+	defer srv.Close()
+	r, m := newRecordingRunner(t, nil)
+
+	runTask(r, &model.Task{ID: "t1", Type: model.TaskTypeStatic, TargetURL: srv.URL, TotalRequestsTarget: 3, DurationSec: 60})
+
+	// The last metrics and the result come before the task is marked done,
+	// so the master's result snapshot counts every byte.
+	want := []string{"running t1", "metrics t1 3000", "result t1 completed 3000", "done t1"}
+	if got := m.recorded(); !slices.Equal(got, want) {
+		t.Fatalf("master calls = %q, want %q", got, want)
+	}
+}
+
+func TestExecuteReportsFailure(t *testing.T) {
+	r, m := newRecordingRunner(t, nil)
+
+	runTask(r, &model.Task{ID: "t1", Type: "noop"})
+
+	want := []string{"running t1", "metrics t1 0", "result t1 failed 0", `failed t1: unknown task type "noop"`}
+	if got := m.recorded(); !slices.Equal(got, want) {
+		t.Fatalf("master calls = %q, want %q", got, want)
+	}
+}
+
+func TestExecuteLeavesCancelledTasksToMaster(t *testing.T) {
+	r, m := newRecordingRunner(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	meter := &ratelimit.Meter{}
+
+	r.execute(ctx, &model.Task{ID: "t1", Type: "noop"}, meter, cancel)
+
+	for _, call := range m.recorded() {
+		if strings.HasPrefix(call, "done") || strings.HasPrefix(call, "failed") {
+			t.Fatalf("reported %q for a cancelled task", call)
+		}
+	}
+}
+
+func TestTimeUp(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	tests := []struct {
+		name string
+		task model.Task
+		now  time.Time
+		want bool
+	}{
+		{"no limit", model.Task{}, start.Add(24 * time.Hour), false},
+		{"before end_at", model.Task{EndAt: &end}, end.Add(-time.Second), false},
+		{"at end_at", model.Task{EndAt: &end}, end, true},
+		{"within duration", model.Task{DurationSec: 60}, start.Add(59 * time.Second), false},
+		{"duration elapsed", model.Task{DurationSec: 60}, start.Add(time.Minute), true},
+	}
+	for _, tc := range tests {
+		if got := timeUp(&tc.task, start, tc.now); got != tc.want {
+			t.Errorf("%s: timeUp = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestFailedDownloadSurfacesOnMaster runs a static task against a missing
+// URL with the agent reporting to a real master handler, and reads the
+// executor's error back from GET /api/v1/tasks/{id}.
+func TestFailedDownloadSurfacesOnMaster(t *testing.T) {
+	target := httptest.NewServer(http.NotFoundHandler())
+	defer target.Close()
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", AgentID: "a1", Type: model.TaskTypeStatic, TargetURL: target.URL + "/missing", DurationSec: 60,
+		Status: model.TaskStatusDispatched, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	handler.NewTaskHandler(service.NewTaskService(st), service.NewAgentService(st)).Router(mux)
+	master := httptest.NewServer(mux)
+	defer master.Close()
+
+	runTask(newTaskRunner(client.New(master.URL)), task)
+
+	resp, err := http.Get(master.URL + "/api/v1/tasks/t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got model.Task
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := "GET " + target.URL + "/missing: HTTP 404"
+	if got.Status != model.TaskStatusFailed || got.ErrorMessage != want {
+		t.Fatalf("task is %s with error %q, want failed with %q", got.Status, got.ErrorMessage, want)
+	}
+}
@@ -7,109 +7,142 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/aven/ngoogle/internal/model"
 )
 
-// nicSampler measures real network interface RX throughput.
-type nicSampler struct {
-	mu       sync.Mutex
-	iface    string
-	lastRx   int64
-	lastTime time.Time
-	lastRate float64
+// nicCounters are the cumulative byte counters of one network interface.
+type nicCounters struct {
+	rx, tx int64
 }
 
-func newNICSampler() *nicSampler {
-	iface := detectPrimaryIface()
-	rx, _ := readIfaceRxBytes(iface)
-	return &nicSampler{
-		iface:    iface,
-		lastRx:   rx,
-		lastTime: time.Now(),
-	}
+// nicCollector reads the byte counters of the host's network interfaces,
+// keyed by interface name. Each OS the agent can measure has its own, which
+// newNICCollector returns.
+type nicCollector interface {
+	counters() (map[string]nicCounters, error)
 }
 
-// Rate returns the RX rate in Mbps since the last call.
-func (s *nicSampler) Rate() float64 {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// procNetDev collects counters from the Linux /proc/net/dev file at its path.
+type procNetDev string
 
-	now := time.Now()
-	rx, err := readIfaceRxBytes(s.iface)
+func (p procNetDev) counters() (map[string]nicCounters, error) {
+	data, err := os.ReadFile(string(p))
 	if err != nil {
-		return s.lastRate
+		return nil, err
 	}
-
-	elapsed := now.Sub(s.lastTime).Seconds()
-	if elapsed < 0.5 {
-		return s.lastRate
-	}
-
-	delta := rx - s.lastRx
-	if delta < 0 {
-		delta = 0
-	}
-
-	rate := float64(delta) / elapsed / 1e6 * 8 // Mbps
-	s.lastRx = rx
-	s.lastTime = now
-	s.lastRate = rate
-	return rate
+	return parseProcNetDev(string(data))
 }
 
-// readIfaceRxBytes reads cumulative RX bytes for iface from /proc/net/dev.
-func readIfaceRxBytes(iface string) (int64, error) {
-	data, err := os.ReadFile("/proc/net/dev")
-	if err != nil {
-		return 0, err
-	}
-	for _, line := range strings.Split(string(data), "\n") {
-		line = strings.TrimSpace(line)
-		prefix := iface + ":"
-		if !strings.HasPrefix(line, prefix) {
+// parseProcNetDev parses /proc/net/dev: after two header lines, one line per
+// interface with its name, eight receive counters starting with bytes and
+// eight transmit counters starting with bytes.
+func parseProcNetDev(data string) (map[string]nicCounters, error) {
+	out := map[string]nicCounters{}
+	for _, line := range strings.Split(data, "\n") {
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok {
 			continue
 		}
-		fields := strings.Fields(line[len(prefix):])
-		if len(fields) < 1 {
-			continue
+		fields := strings.Fields(rest)
+		if len(fields) < 9 {
+			continue // header
+		}
+		rx, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: rx bytes: %w", strings.TrimSpace(name), err)
+		}
+		tx, err := strconv.ParseInt(fields[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: tx bytes: %w", strings.TrimSpace(name), err)
 		}
-		return strconv.ParseInt(fields[0], 10, 64)
+		out[strings.TrimSpace(name)] = nicCounters{rx: rx, tx: tx}
 	}
-	return 0, fmt.Errorf("interface %s not found in /proc/net/dev", iface)
+	return out, nil
 }
 
-// detectPrimaryIface returns the non-loopback interface with the most RX traffic.
-func detectPrimaryIface() string {
-	data, err := os.ReadFile("/proc/net/dev")
-	if err != nil {
-		return "eth0"
-	}
+// primaryIface returns the non-loopback interface that has received the
+// most bytes, or "" if there is none.
+func primaryIface(all map[string]nicCounters) string {
 	var best string
-	var bestBytes int64
-	for _, line := range strings.Split(string(data), "\n") {
-		line = strings.TrimSpace(line)
-		colonIdx := strings.Index(line, ":")
-		if colonIdx < 0 {
-			continue
-		}
-		name := strings.TrimSpace(line[:colonIdx])
+	var bestBytes int64 = -1
+	for name, c := range all {
 		if name == "lo" {
 			continue
 		}
-		fields := strings.Fields(line[colonIdx+1:])
-		if len(fields) < 1 {
-			continue
-		}
-		rx, err := strconv.ParseInt(fields[0], 10, 64)
-		if err != nil {
-			continue
-		}
-		if rx > bestBytes {
-			bestBytes = rx
-			best = name
+		if c.rx > bestBytes || c.rx == bestBytes && name < best {
+			best, bestBytes = name, c.rx
 		}
 	}
-	if best == "" {
-		return "eth0"
-	}
 	return best
 }
+
+// nicSampler measures the throughput of the interface carrying the agent's
+// traffic from the change in its counters between samples.
+type nicSampler struct {
+	collector nicCollector
+	iface     string
+
+	mu       sync.Mutex
+	last     nicCounters
+	lastTime time.Time
+	rx, tx   float64
+}
+
+// newNICSampler picks the primary interface among c's, if c can read any.
+func newNICSampler(c nicCollector) *nicSampler {
+	s := &nicSampler{collector: c, lastTime: time.Now()}
+	if c == nil {
+		return s
+	}
+	all, err := c.counters()
+	if err != nil {
+		return s
+	}
+	s.iface = primaryIface(all)
+	s.last = all[s.iface]
+	return s
+}
+
+// rates returns the interface's RX and TX rates in Mbps since the previous
+// call, and false if it cannot be measured. Calls less than half a second
+// apart return the previous rates.
+func (s *nicSampler) rates() (rx, tx float64, ok bool) {
+	if s.iface == "" {
+		return 0, 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	all, err := s.collector.counters()
+	c, found := all[s.iface]
+	if err != nil || !found {
+		return s.rx, s.tx, true
+	}
+	elapsed := now.Sub(s.lastTime).Seconds()
+	if elapsed < 0.5 {
+		return s.rx, s.tx, true
+	}
+
+	// Counters go back when the interface is reset; count nothing then.
+	s.rx = mbps(max(c.rx-s.last.rx, 0), elapsed)
+	s.tx = mbps(max(c.tx-s.last.tx, 0), elapsed)
+	s.last, s.lastTime = c, now
+	return s.rx, s.tx, true
+}
+
+func mbps(bytes int64, seconds float64) float64 {
+	return float64(bytes) / seconds / 1e6 * 8
+}
+
+// heartbeatRates returns the rates the agent reports: the sum of its task
+// meters, and its interface's measured rates where nic can measure them.
+func heartbeatRates(nic *nicSampler, runner *taskRunner) model.AgentRates {
+	rates := model.AgentRates{TaskRateMbps: runner.totalRate()}
+	rates.RateMbps = rates.TaskRateMbps
+	if rx, tx, ok := nic.rates(); ok {
+		rates.RateMbps, rates.TxRateMbps, rates.Measured = rx, tx, true
+	}
+	return rates
+}
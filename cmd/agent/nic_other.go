@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import "runtime"
+
+// newNICCollector returns the collector for the OS the agent runs on, or
+// nil where it has none; the agent then reports its task rates only.
+func newNICCollector() nicCollector {
+	if runtime.GOOS == "linux" {
+		return procNetDev("/proc/net/dev")
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/ratelimit"
+)
+
+const procNetDevSample = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 9000000    1000    0    0    0     0          0         0  9000000    1000    0    0    0     0       0          0
+  eth0: 125000000  90000    0    0    0     0          0         0  2500000    30000    0    0    0     0       0          0
+  eth1:  500000     400    0    0    0     0          0         0     1000       10    0    0    0     0       0          0
+`
+
+func TestParseProcNetDev(t *testing.T) {
+	got, err := parseProcNetDev(procNetDevSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]nicCounters{
+		"lo":   {rx: 9000000, tx: 9000000},
+		"eth0": {rx: 125000000, tx: 2500000},
+		"eth1": {rx: 500000, tx: 1000},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parsed %v, want %v", got, want)
+	}
+	for name, c := range want {
+		if got[name] != c {
+			t.Errorf("%s = %+v, want %+v", name, got[name], c)
+		}
+	}
+	if name := primaryIface(got); name != "eth0" {
+		t.Errorf("primary interface = %q, want eth0", name)
+	}
+}
+
+// fakeNIC is a nicCollector whose counters tests set.
+type fakeNIC struct {
+	mu  sync.Mutex
+	all map[string]nicCounters
+	err error
+}
+
+func (f *fakeNIC) counters() (map[string]nicCounters, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.all, f.err
+}
+
+func (f *fakeNIC) set(name string, c nicCounters) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.all[name] = c
+}
+
+func TestNICSamplerRates(t *testing.T) {
+	nic := &fakeNIC{all: map[string]nicCounters{"lo": {}, "eth0": {rx: 1000, tx: 500}}}
+	s := newNICSampler(nic)
+	if s.iface != "eth0" {
+		t.Fatalf("iface = %q, want eth0", s.iface)
+	}
+	// One second ago, 12.5 MB received and 1.25 MB sent: 100 and 10 Mbps.
+	s.lastTime = time.Now().Add(-time.Second)
+	nic.set("eth0", nicCounters{rx: 1000 + 12_500_000, tx: 500 + 1_250_000})
+
+	rx, tx, ok := s.rates()
+	if !ok || math.Abs(rx-100) > 1 || math.Abs(tx-10) > 0.1 {
+		t.Fatalf("rates = %.2f, %.2f, %v; want about 100, 10, true", rx, tx, ok)
+	}
+
+	// A reset interface counts nothing rather than going negative.
+	s.lastTime = time.Now().Add(-time.Second)
+	nic.set("eth0", nicCounters{})
+	if rx, tx, _ := s.rates(); rx != 0 || tx != 0 {
+		t.Fatalf("rates after reset = %.2f, %.2f, want 0", rx, tx)
+	}
+}
+
+func TestNICSamplerUnmeasurable(t *testing.T) {
+	for name, c := range map[string]nicCollector{
+		"no collector":  nil,
+		"failing":       &fakeNIC{err: errors.New("no /proc")},
+		"loopback only": &fakeNIC{all: map[string]nicCounters{"lo": {rx: 10}}},
+	} {
+		if _, _, ok := newNICSampler(c).rates(); ok {
+			t.Errorf("%s: rates reported as measured", name)
+		}
+	}
+}
+
+func TestHeartbeatRatesFallBackToTaskRates(t *testing.T) {
+	r := newTestRunner(t, nil)
+	meter := &ratelimit.Meter{}
+	meter.Record(1_000_000)
+	r.running["t1"], r.meters["t1"] = func() {}, meter
+
+	got := heartbeatRates(newNICSampler(nil), r)
+
+	if got.Measured || got.TaskRateMbps <= 0 || got.RateMbps != got.TaskRateMbps || got.TxRateMbps != 0 {
+		t.Fatalf("rates = %+v, want the task rate only", got)
+	}
+
+	nic := &fakeNIC{all: map[string]nicCounters{"eth0": {}}}
+	s := newNICSampler(nic)
+	s.lastTime = time.Now().Add(-time.Second)
+	nic.set("eth0", nicCounters{rx: 25_000_000, tx: 125_000})
+	got = heartbeatRates(s, r)
+	want := model.AgentRates{RateMbps: 200, TaskRateMbps: got.TaskRateMbps, TxRateMbps: 1, Measured: true}
+	if !got.Measured || math.Abs(got.RateMbps-want.RateMbps) > 2 || math.Abs(got.TxRateMbps-want.TxRateMbps) > 0.1 {
+		t.Fatalf("rates = %+v, want about %+v", got, want)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// newNICCollector returns the IP Helper collector: Windows has no
+// /proc/net/dev and keeps interface counters in its interface table.
+func newNICCollector() nicCollector {
+	return ipHelper{}
+}
+
+// ipHelper collects the counters of every interface that is up and not a
+// loopback with GetIfEntry2Ex, keyed by the interface's name.
+type ipHelper struct{}
+
+func (ipHelper) counters() (map[string]nicCounters, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]nicCounters{}
+	for _, ifc := range ifaces {
+		if ifc.Flags&net.FlagLoopback != 0 || ifc.Flags&net.FlagUp == 0 {
+			continue
+		}
+		row := windows.MibIfRow2{InterfaceIndex: uint32(ifc.Index)}
+		if err := windows.GetIfEntry2Ex(windows.MibIfEntryNormal, &row); err != nil {
+			continue // removed since it was listed
+		}
+		out[ifc.Name] = nicCounters{rx: int64(row.InOctets), tx: int64(row.OutOctets)}
+	}
+	return out, nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// runService reports whether the agent was started by the system's service
+// manager and, if so, has run it under it. Service managers other than
+// Windows' start the agent as a plain process, which stops on a signal.
+func runService(func(context.Context, io.Writer)) bool {
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// runService reports whether the agent was started by the Windows service
+// control manager and, if so, has run it as a service until it was stopped.
+func runService(run func(context.Context, io.Writer)) bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	// The name is ignored for services that run in their own process.
+	if err := svc.Run("", &agentService{run: run}); err != nil {
+		fmt.Fprintln(os.Stderr, "agent: run as service:", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// agentService runs the agent while the service control manager keeps the
+// service running, and stops it on Stop or at shutdown.
+type agentService struct {
+	run func(context.Context, io.Writer)
+}
+
+func (s *agentService) Execute(_ []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logOut := serviceLog()
+	if c, ok := logOut.(io.Closer); ok {
+		defer c.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, logOut)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			// The agent only returns once cancelled; anything else is a
+			// failure, which makes the service manager restart it.
+			return false, 1
+		case c := <-req:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// serviceLog opens agent.log in the state directory: a service has no
+// console for the agent to log to.
+func serviceLog() io.Writer {
+	dir := stateDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return io.Discard
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "agent.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return io.Discard
+	}
+	return f
+}
@@ -1,146 +1,229 @@
-// Package client provides the HTTP client for communicating with the Master.
+// Package client provides the agent-side client for communicating with the Master.
 package client
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"sync"
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/masterclient"
 )
 
-// Client is the Master API client used by agents.
+// Transport carries an agent's calls to the Master: *masterclient.Client
+// over REST, or *agentrpc.Client over gRPC.
+type Transport interface {
+	RegisterAgent(ctx context.Context, req *masterclient.RegisterRequest) (*masterclient.RegisterResponse, error)
+	Heartbeat(ctx context.Context, agentID, token string, rates model.AgentRates, running []string) error
+	PullTasks(ctx context.Context, agentID string) ([]*model.Task, error)
+	PullTasksWait(ctx context.Context, agentID, since string, wait time.Duration) ([]*model.Task, string, error)
+	StreamTasks(ctx context.Context, agentID string, idle time.Duration, fn func(tasks []*model.Task)) error
+	ReportTaskMetrics(ctx context.Context, token string, m *model.TaskMetrics) error
+	ReportTaskResult(ctx context.Context, token string, r *model.AgentTaskResult) error
+	MarkTaskRunning(ctx context.Context, id string) error
+	MarkTaskDone(ctx context.Context, id string) error
+	MarkTaskFailed(ctx context.Context, id, reason string) error
+}
+
+// Client is the Master API client used by agents. It wraps a Transport and
+// keeps the agent's identity between calls.
 type Client struct {
-	baseURL    string
-	agentID    string
-	token      string
-	httpClient *http.Client
-}
-
-// New creates a new Client.
-func New(baseURL string) *Client {
-	return &Client{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}
+	api Transport
+
+	mu       sync.RWMutex // guards the fields below, which change on re-registration
+	agentID  string
+	token    string
+	hostname string
+	ip       string
+
+	bootstrapToken string
+	machineID      string
+	tags           []string
+	capacityMbps   float64
+
+	// tasksVersion is the task list version of the last long-poll pull.
+	tasksVersion string
 }
 
-// RegisterResponse is returned by the register endpoint.
+// New creates a new Client talking REST to the Master at baseURL.
+func New(baseURL string, opts ...masterclient.Option) *Client {
+	opts = append([]masterclient.Option{masterclient.WithBaseURL(baseURL)}, opts...)
+	return NewWithTransport(masterclient.New(opts...))
+}
+
+// NewWithTransport creates a new Client on t.
+func NewWithTransport(t Transport) *Client {
+	return &Client{api: t}
+}
+
+// SetToken sets the token presented at registration. Provisioned agents get
+// it from their install; unprovisioned agents leave it empty.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// SetBootstrapToken sets the shared registration secret presented by agents
+// that have no issued token.
+func (c *Client) SetBootstrapToken(token string) { c.bootstrapToken = token }
+
+// SetMachineID sets the stable host identifier the master uses to recognise
+// this agent across hostname and IP changes.
+func (c *Client) SetMachineID(id string) { c.machineID = id }
+
+// SetTags sets the labels advertised at registration.
+func (c *Client) SetTags(tags []string) { c.tags = tags }
+
+// SetCapacity sets the bandwidth, in Mbps, advertised at registration as
+// the most the agent can carry; zero advertises none.
+func (c *Client) SetCapacity(mbps float64) { c.capacityMbps = mbps }
+
+// URLVars returns the values this agent gives the variables of target URLs:
+// its identity as last registered and its region tag.
+func (c *Client) URLVars() model.URLVars {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return model.URLVars{AgentID: c.agentID, Hostname: c.hostname, IP: c.ip, Region: model.RegionFromTags(c.tags)}
+}
+
+// RegisterResponse is returned by the register endpoint. The intervals are
+// zero when the master does not set them.
 type RegisterResponse struct {
-	ID    string `json:"id"`
-	Token string `json:"token"`
+	ID                string `json:"id"`
+	Token             string `json:"token"`
+	PullInterval      time.Duration
+	HeartbeatInterval time.Duration
 }
 
 // Register registers this agent with the Master.
-func (c *Client) Register(ctx context.Context, hostname, ip string, port int, version string) (*RegisterResponse, error) {
-	body := map[string]interface{}{
-		"hostname": hostname,
-		"ip":       ip,
-		"port":     port,
-		"version":  version,
+//
+// If the master rejects the token the agent holds, which is what happens
+// after the token was rotated, Register retries once without it so the
+// agent is re-admitted on its bootstrap token and machine ID. With a
+// bootstrap token it receives the token the master now holds for it;
+// otherwise the master issues a fresh one.
+func (c *Client) Register(ctx context.Context, hostname, ip string, port int, version, commit string) (*RegisterResponse, error) {
+	req := &masterclient.RegisterRequest{
+		Hostname:       hostname,
+		IP:             ip,
+		Port:           port,
+		Version:        version,
+		Commit:         commit,
+		MachineID:      c.machineID,
+		Token:          c.currentToken(),
+		BootstrapToken: c.bootstrapToken,
+		Tags:           c.tags,
+		CapacityMbps:   c.capacityMbps,
+	}
+	a, err := c.api.RegisterAgent(ctx, req)
+	if err != nil && req.Token != "" && errors.Is(err, masterclient.ErrUnauthorized) {
+		req.Token = ""
+		a, err = c.api.RegisterAgent(ctx, req)
 	}
-	var resp RegisterResponse
-	if err := c.post(ctx, "/api/v1/agents/register", body, &resp); err != nil {
+	if err != nil {
 		return nil, err
 	}
-	c.agentID = resp.ID
-	c.token = resp.Token
-	return &resp, nil
+	c.mu.Lock()
+	c.agentID = a.ID
+	c.token = a.Token
+	c.hostname, c.ip = hostname, ip
+	c.mu.Unlock()
+	return &RegisterResponse{
+		ID:                a.ID,
+		Token:             a.Token,
+		PullInterval:      time.Duration(a.PullIntervalSec) * time.Second,
+		HeartbeatInterval: time.Duration(a.HeartbeatIntervalSec) * time.Second,
+	}, nil
 }
 
-// Heartbeat sends a heartbeat to the Master.
-func (c *Client) Heartbeat(ctx context.Context, rateMbps float64) error {
-	body := map[string]interface{}{
-		"agent_id":  c.agentID,
-		"token":     c.token,
-		"rate_mbps": rateMbps,
-	}
-	return c.post(ctx, "/api/v1/agents/heartbeat", body, nil)
+// Heartbeat sends a heartbeat to the Master, with the agent's rates and the
+// IDs of the tasks it is running. It returns an error matching masterclient.ErrUnauthorized
+// when the agent's token is no longer valid.
+func (c *Client) Heartbeat(ctx context.Context, rates model.AgentRates, running []string) error {
+	c.mu.RLock()
+	agentID, token := c.agentID, c.token
+	c.mu.RUnlock()
+	return c.api.Heartbeat(ctx, agentID, token, rates, running)
 }
 
 // PullTasks fetches tasks assigned to this agent.
 func (c *Client) PullTasks(ctx context.Context) ([]*model.Task, error) {
-	if c.agentID == "" {
+	agentID := c.AgentID()
+	if agentID == "" {
 		return nil, fmt.Errorf("not registered")
 	}
-	url := fmt.Sprintf("/api/v1/agents/%s/tasks/pull", c.agentID)
-	var tasks []*model.Task
-	if err := c.get(ctx, url, &tasks); err != nil {
-		return nil, err
+	return c.api.PullTasks(ctx, agentID)
+}
+
+// WaitTasks long-polls the tasks assigned to this agent: the master answers
+// once they change since the previous WaitTasks call, or after wait. The
+// returned bool is false when the master does not support long polling, in
+// which case the call returned without waiting.
+func (c *Client) WaitTasks(ctx context.Context, wait time.Duration) ([]*model.Task, bool, error) {
+	agentID := c.AgentID()
+	if agentID == "" {
+		return nil, false, fmt.Errorf("not registered")
 	}
-	return tasks, nil
+	tasks, version, err := c.api.PullTasksWait(ctx, agentID, c.tasksVersion, wait)
+	if err != nil {
+		return nil, false, err
+	}
+	c.tasksVersion = version
+	return tasks, version != "", nil
+}
+
+// StreamTasks subscribes to the task lists the master pushes to this agent
+// and calls fn with each one, until ctx is done or the stream breaks. A
+// stream silent for longer than idle counts as broken.
+func (c *Client) StreamTasks(ctx context.Context, idle time.Duration, fn func(tasks []*model.Task)) error {
+	agentID := c.AgentID()
+	if agentID == "" {
+		return fmt.Errorf("not registered")
+	}
+	return c.api.StreamTasks(ctx, agentID, idle, fn)
 }
 
 // ReportMetrics sends task metrics to the Master.
 func (c *Client) ReportMetrics(ctx context.Context, m *model.TaskMetrics) error {
-	url := fmt.Sprintf("/api/v1/tasks/%s/metrics", m.TaskID)
-	return c.post(ctx, url, m, nil)
+	return c.api.ReportTaskMetrics(ctx, c.currentToken(), m)
+}
+
+// ReportResult sends the agent's final result for a task it has run.
+func (c *Client) ReportResult(ctx context.Context, r *model.AgentTaskResult) error {
+	c.mu.RLock()
+	agentID, token := c.agentID, c.token
+	c.mu.RUnlock()
+	r.AgentID = agentID
+	return c.api.ReportTaskResult(ctx, token, r)
 }
 
 // MarkRunning marks a task as running.
 func (c *Client) MarkRunning(ctx context.Context, taskID string) error {
-	return c.post(ctx, fmt.Sprintf("/api/v1/tasks/%s/run", taskID), nil, nil)
+	return c.api.MarkTaskRunning(ctx, taskID)
 }
 
 // MarkDone marks a task as completed.
 func (c *Client) MarkDone(ctx context.Context, taskID string) error {
-	return c.post(ctx, fmt.Sprintf("/api/v1/tasks/%s/done", taskID), nil, nil)
+	return c.api.MarkTaskDone(ctx, taskID)
 }
 
 // MarkFailed marks a task as failed with a reason.
 func (c *Client) MarkFailed(ctx context.Context, taskID string, reason string) error {
-	body := map[string]string{"reason": reason}
-	return c.post(ctx, fmt.Sprintf("/api/v1/tasks/%s/fail", taskID), body, nil)
+	return c.api.MarkTaskFailed(ctx, taskID, reason)
 }
 
 // AgentID returns the agent's assigned ID.
-func (c *Client) AgentID() string { return c.agentID }
-
-// ─── HTTP helpers ─────────────────────────────────────────────────────────────
-
-func (c *Client) post(ctx context.Context, path string, body, resp interface{}) error {
-	var bodyReader io.Reader
-	if body != nil {
-		data, err := json.Marshal(body)
-		if err != nil {
-			return err
-		}
-		bodyReader = bytes.NewReader(data)
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bodyReader)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	return c.do(req, resp)
-}
-
-func (c *Client) get(ctx context.Context, path string, resp interface{}) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
-	if err != nil {
-		return err
-	}
-	return c.do(req, resp)
+func (c *Client) AgentID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.agentID
 }
 
-func (c *Client) do(req *http.Request, out interface{}) error {
-	res, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("http %s %s: %w", req.Method, req.URL, err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode >= 400 {
-		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("http %d: %s", res.StatusCode, string(body))
-	}
-	if out != nil {
-		return json.NewDecoder(res.Body).Decode(out)
-	}
-	return nil
+func (c *Client) currentToken() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
 }
@@ -0,0 +1,193 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/latency"
+	"github.com/aven/ngoogle/pkg/ratelimit"
+)
+
+func init() {
+	Register(model.TaskTypeDNS, func(cfg Config) Executor {
+		return &DNSExecutor{Latency: cfg.Latency, Requests: cfg.Requests, Log: cfg.Log}
+	})
+}
+
+// dnsQueryTimeout bounds one query, retries by the resolver included.
+const dnsQueryTimeout = 5 * time.Second
+
+// DNSExecutor sends the queries of a dns task (see model.DNSQuery) to their
+// resolvers at the task's request rate.
+type DNSExecutor struct {
+	// Latency, when set, receives the time every query took.
+	Latency *latency.Recorder
+	// Requests, when set, counts the queries sent and those that failed.
+	Requests RequestRecorder
+	// Log receives the executor's log lines; see taskLogger.
+	Log *slog.Logger
+}
+
+// Run sends the task's queries in turn, over staticWorkerCount workers
+// paced by its RPS limiter, until the task deadline or its request target.
+// Failed resolutions, NXDOMAIN included, are counted and do not end the
+// task. The meter counts the bytes of the resolvers' responses.
+func (e *DNSExecutor) Run(ctx context.Context, task *model.Task, meter *ratelimit.Meter, progress func(int64)) error {
+	task.Normalize()
+	urls := task.URLs()
+	if len(urls) == 0 {
+		return fmt.Errorf("target_urls is required for dns task")
+	}
+	if task.TargetRps <= 0 {
+		return fmt.Errorf("target_rps is required for dns task")
+	}
+	queries := make([]model.DNSQuery, len(urls))
+	resolvers := map[string]*net.Resolver{}
+	for i, raw := range urls {
+		q, err := model.ParseDNSQuery(raw)
+		if err != nil {
+			return err
+		}
+		queries[i] = q
+		if resolvers[q.Server] == nil {
+			resolvers[q.Server] = newResolver(q.Server, meter)
+		}
+	}
+
+	log := taskLogger(e.Log, task)
+	startedAt := time.Now()
+	reqCtx, cancel := context.WithDeadline(ctx, computeEndTime(task, startedAt))
+	defer cancel()
+	rps := newRPSLimiter(reqCtx, task)
+	go followDistribution(reqCtx, task, startedAt, func(mult float64) { rps.setRate(task.TargetRps * mult) })
+	pace := newPacer(reqCtx, task)
+
+	var reqCount, doneCount, errCount atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < staticWorkerCount(task); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if !pace.wait(reqCtx) || !rps.wait(reqCtx) {
+					return
+				}
+				slot := reqCount.Add(1)
+				if task.TotalRequestsTarget > 0 && slot > task.TotalRequestsTarget {
+					return
+				}
+				q := queries[int(slot-1)%len(queries)]
+				start := time.Now()
+				err := resolve(reqCtx, resolvers[q.Server], q)
+				if reqCtx.Err() != nil {
+					return // cut short by the deadline, not the resolver
+				}
+				e.record(q, err, time.Since(start), log, &errCount)
+				if progress != nil {
+					progress(meter.TotalBytes())
+				}
+				// Once the last query is counted, workers still waiting for
+				// a permit have nothing left to send.
+				if task.TotalRequestsTarget > 0 && doneCount.Add(1) == task.TotalRequestsTarget {
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := errCount.Load(); n > 0 {
+		log.Info("dns task finished with failed queries", "failed", n)
+	}
+	return stopped(ctx)
+}
+
+// record counts a query that took d and failed with err, if not nil.
+func (e *DNSExecutor) record(q model.DNSQuery, err error, d time.Duration, log *slog.Logger, errCount *atomic.Int64) {
+	if e.Requests != nil {
+		e.Requests.RecordRequest()
+	}
+	if err == nil {
+		if e.Latency != nil {
+			e.Latency.Observe(d, d)
+		}
+		return
+	}
+	if e.Requests != nil {
+		e.Requests.RecordError()
+	}
+	// Only the first failure is logged: a failing resolver fails every query.
+	if errCount.Add(1) == 1 {
+		log.Warn("dns query failed", "server", q.Server, "name", q.Name, "type", q.Type, "err", err)
+	}
+}
+
+// resolve sends q through r.
+func resolve(ctx context.Context, r *net.Resolver, q model.DNSQuery) error {
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+	// A rooted name skips the host's search domains.
+	name := q.Name + "."
+	var err error
+	switch q.Type {
+	case "AAAA":
+		_, err = r.LookupIP(ctx, "ip6", name)
+	case "TXT":
+		_, err = r.LookupTXT(ctx, name)
+	default:
+		_, err = r.LookupIP(ctx, "ip4", name)
+	}
+	return err
+}
+
+// newResolver returns a resolver that sends every query to server, whatever
+// the host's configuration, and records the bytes it receives in meter.
+func newResolver(server string, meter *ratelimit.Meter) *net.Resolver {
+	var d net.Dialer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			c, err := d.DialContext(ctx, network, server)
+			if err != nil {
+				return nil, err
+			}
+			// The resolver tells UDP from TCP by whether the connection is a
+			// PacketConn, so the wrapper must keep that.
+			if _, ok := c.(net.PacketConn); ok {
+				return countingPacketConn{countingConn{c, meter}}, nil
+			}
+			return countingConn{c, meter}, nil
+		},
+	}
+}
+
+// countingConn records the bytes read from a connection in a meter.
+type countingConn struct {
+	net.Conn
+	meter *ratelimit.Meter
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.meter.Record(int64(n))
+	}
+	return n, err
+}
+
+// countingPacketConn is a countingConn over UDP.
+type countingPacketConn struct{ countingConn }
+
+func (c countingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.Conn.(net.PacketConn).ReadFrom(b)
+}
+
+func (c countingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Conn.(net.PacketConn).WriteTo(b, addr)
+}
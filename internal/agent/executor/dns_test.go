@@ -0,0 +1,151 @@
+package executor
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/latency"
+	"github.com/aven/ngoogle/pkg/ratelimit"
+)
+
+// startDNSServer serves DNS over UDP on a local port: ok.test. resolves to
+// 192.0.2.1, every other name is NXDOMAIN. It returns the server's address
+// and the count of questions it answered, by name.
+func startDNSServer(t *testing.T) (string, func(name string) int) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	var mu sync.Mutex
+	asked := map[string]int{}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) != 1 {
+				continue
+			}
+			q := req.Questions[0]
+			mu.Lock()
+			asked[q.Name.String()]++
+			mu.Unlock()
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, RecursionAvailable: true},
+				Questions: req.Questions,
+			}
+			switch {
+			case q.Name.String() != "ok.test.":
+				resp.RCode = dnsmessage.RCodeNameError
+			case q.Type == dnsmessage.TypeA:
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+				}}
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = pc.WriteTo(out, addr)
+		}
+	}()
+	return pc.LocalAddr().String(), func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return asked[name]
+	}
+}
+
+type countingRecorder struct{ requests, errors, content atomic.Int64 }
+
+func (c *countingRecorder) RecordRequest() { c.requests.Add(1) }
+func (c *countingRecorder) RecordError()   { c.errors.Add(1) }
+func (c *countingRecorder) RecordContentError() {
+	c.errors.Add(1)
+	c.content.Add(1)
+}
+
+func TestDNSExecutorCountsQueriesAndFailures(t *testing.T) {
+	addr, asked := startDNSServer(t)
+	task := &model.Task{
+		ID:   "t1",
+		Type: model.TaskTypeDNS,
+		TargetURLs: []string{
+			"dns://" + addr + "/ok.test",
+			"dns://" + addr + "/missing.test?type=a",
+		},
+		TargetRps:           200,
+		TotalRequestsTarget: 6,
+		DurationSec:         10,
+	}
+	meter := &ratelimit.Meter{}
+	lat := &latency.Recorder{}
+	reqs := &countingRecorder{}
+	exe := &DNSExecutor{Latency: lat, Requests: reqs}
+
+	start := time.Now()
+	if err := exe.Run(context.Background(), task, meter, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("run took %s, want it to stop at the request target", time.Since(start))
+	}
+	if got, want := reqs.requests.Load(), int64(6); got != want {
+		t.Errorf("recorded %d queries, want %d", got, want)
+	}
+	if got, want := reqs.errors.Load(), int64(3); got != want {
+		t.Errorf("recorded %d failed queries, want %d", got, want)
+	}
+	if asked("ok.test.") != 3 {
+		t.Errorf("server got %d queries for ok.test., want 3", asked("ok.test."))
+	}
+	if meter.TotalBytes() == 0 {
+		t.Error("meter counted no response bytes")
+	}
+	if _, total := lat.Snapshot(); total.Count != 3 {
+		t.Errorf("latency has %d samples, want one per successful query", total.Count)
+	}
+}
+
+func TestDNSExecutorRequiresRPS(t *testing.T) {
+	task := &model.Task{ID: "t1", Type: model.TaskTypeDNS, TargetURL: "dns://127.0.0.1/ok.test", TargetRateMbps: 10}
+	if err := (&DNSExecutor{}).Run(context.Background(), task, &ratelimit.Meter{}, nil); err == nil {
+		t.Fatal("ran a dns task without target_rps")
+	}
+}
+
+func TestParseDNSQuery(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want model.DNSQuery
+		err  bool
+	}{
+		{raw: "dns://10.0.0.53/example.com", want: model.DNSQuery{Server: "10.0.0.53:53", Name: "example.com", Type: "A"}},
+		{raw: "dns://[2001:db8::53]:5353/example.com?type=aaaa", want: model.DNSQuery{Server: "[2001:db8::53]:5353", Name: "example.com", Type: "AAAA"}},
+		{raw: "dns://ns.example/_spf.example.com?type=TXT", want: model.DNSQuery{Server: "ns.example:53", Name: "_spf.example.com", Type: "TXT"}},
+		{raw: "dns://10.0.0.53/example.com?type=MX", err: true},
+		{raw: "dns://10.0.0.53/", err: true},
+		{raw: "https://10.0.0.53/example.com", err: true},
+	}
+	for _, tc := range tests {
+		got, err := model.ParseDNSQuery(tc.raw)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("ParseDNSQuery(%q) = %+v, %v; want %+v, error %v", tc.raw, got, err, tc.want, tc.err)
+		}
+	}
+}
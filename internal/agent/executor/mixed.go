@@ -3,17 +3,28 @@ package executor
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"strings"
 	"time"
 
 	"github.com/aven/ngoogle/internal/master/scheduler"
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/latency"
 	"github.com/aven/ngoogle/pkg/ratelimit"
 )
 
+func init() {
+	Register(model.TaskTypeMixed, func(cfg Config) Executor { return &MixedExecutor{Latency: cfg.Latency, Log: cfg.Log} })
+}
+
 // MixedExecutor rotates across a mixed pool of YouTube and static URLs.
-type MixedExecutor struct{}
+type MixedExecutor struct {
+	// Latency, when set, receives the timings of static downloads.
+	Latency *latency.Recorder
+	// Log receives the executor's log lines; see taskLogger.
+	Log *slog.Logger
+}
 
 func (e *MixedExecutor) Run(ctx context.Context, task *model.Task, meter *ratelimit.Meter, progress func(int64)) error {
 	task.Normalize()
@@ -22,7 +33,8 @@ func (e *MixedExecutor) Run(ctx context.Context, task *model.Task, meter *rateli
 		return fmt.Errorf("target_urls is required for mixed task")
 	}
 
-	tb := ratelimit.New(task.TargetRateMbps, 2.0)
+	log := taskLogger(e.Log, task)
+	tb := tokenBucket(task, task.TargetRateMbps, 1)
 	startedAt := time.Now()
 	endAt := computeEndTime(task, startedAt)
 	reqCtx, cancel := context.WithDeadline(ctx, endAt)
@@ -32,26 +44,33 @@ func (e *MixedExecutor) Run(ctx context.Context, task *model.Task, meter *rateli
 		jitterWait := scheduler.ApplyJitter(100*time.Millisecond, task.JitterPct)
 		select {
 		case <-reqCtx.Done():
-			return nil
+			return stopped(ctx)
 		case <-time.After(jitterWait):
 		}
 	}
 
+	pace := newPacer(reqCtx, task)
+	client := httpClientFor(task, log)
+	buf := readBuffer(task)
 	var totalBytes int64
 	reqCount := int64(0)
 
 	for {
 		select {
 		case <-reqCtx.Done():
-			return nil
+			return stopped(ctx)
 		default:
 		}
 
 		if task.TotalBytesTarget > 0 && totalBytes >= task.TotalBytesTarget {
-			return nil
+			return stopped(ctx)
 		}
 		if task.TotalRequestsTarget > 0 && reqCount >= task.TotalRequestsTarget {
-			return nil
+			return stopped(ctx)
+		}
+
+		if !pace.wait(reqCtx) {
+			return stopped(ctx)
 		}
 
 		var elapsed time.Duration
@@ -63,34 +82,36 @@ func (e *MixedExecutor) Run(ctx context.Context, task *model.Task, meter *rateli
 		mult := scheduler.RateForTask(task, elapsed, nil)
 		tb.SetRate(task.TargetRateMbps * mult)
 
-		targetURL := urls[int(reqCount)%len(urls)]
+		targetURL := model.ExpandSeq(urls[int(reqCount)%len(urls)], reqCount+1)
 		if isYoutubeURL(targetURL) {
 			sharedTotal := totalBytes
 			cw := newCountingWriter(&sharedTotal, meter, progress)
 			child := task.Clone()
 			child.Type = model.TaskTypeYoutube
 			child.TargetURL = targetURL
-			if err := runYtdlp(reqCtx, buildYtdlpArgs(child, targetURL), cw); err != nil {
+			if err := runYtdlp(reqCtx, log, buildYtdlpArgs(child, targetURL), cw); err != nil {
 				if reqCtx.Err() != nil {
-					return nil
+					return stopped(ctx)
 				}
+				log.Warn("yt-dlp error, retrying", "url", targetURL, "err", err)
 				select {
 				case <-reqCtx.Done():
-					return nil
+					return stopped(ctx)
 				case <-time.After(2 * time.Second):
 				}
 				continue
 			}
 			totalBytes = cw.Total()
 		} else {
-			n, err := downloadOnce(reqCtx, targetURL, tb)
+			n, err := downloadOnce(reqCtx, client, targetURL, task.RangeChunkBytes, task.CacheBustParam, buf, nil, tb, e.Latency)
 			if err != nil {
 				if reqCtx.Err() != nil {
-					return nil
+					return stopped(ctx)
 				}
+				log.Warn("static download err, retrying", "url", targetURL, "err", err)
 				select {
 				case <-reqCtx.Done():
-					return nil
+					return stopped(ctx)
 				case <-time.After(2 * time.Second):
 				}
 				continue
@@ -103,15 +124,6 @@ func (e *MixedExecutor) Run(ctx context.Context, task *model.Task, meter *rateli
 		}
 
 		reqCount++
-		if task.DispatchRateTpm > 0 {
-			interval := scheduler.DispatchInterval(task.DispatchRateTpm, task.DispatchBatchSize)
-			interval = scheduler.ApplyJitter(interval, task.JitterPct)
-			select {
-			case <-reqCtx.Done():
-				return nil
-			case <-time.After(interval):
-			}
-		}
 	}
 }
 
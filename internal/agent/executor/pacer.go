@@ -0,0 +1,137 @@
+package executor
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/scheduler"
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// pacer enforces a task's dispatch rate. Every scheduler.DispatchInterval it
+// releases DispatchBatchSize request permits, so the task issues
+// DispatchRateTpm requests per minute in total no matter how many workers
+// share it. Permits a busy executor does not use are not banked beyond one
+// batch, which keeps a stalled task from bursting when it recovers.
+type pacer struct {
+	permits chan struct{}
+}
+
+// newPacer starts a pacer for task that stops when ctx is done. It returns
+// nil, which never blocks, when the task has no dispatch rate.
+func newPacer(ctx context.Context, task *model.Task) *pacer {
+	if task.DispatchRateTpm <= 0 {
+		return nil
+	}
+	batch := task.DispatchBatchSize
+	if batch < 1 {
+		batch = 1
+	}
+	interval := scheduler.DispatchInterval(task.DispatchRateTpm, batch)
+	p := &pacer{permits: make(chan struct{}, batch)}
+	go func() {
+		for {
+			for i := 0; i < batch; i++ {
+				select {
+				case p.permits <- struct{}{}:
+				default:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(scheduler.ApplyJitter(interval, task.JitterPct)):
+			}
+		}
+	}()
+	return p
+}
+
+// wait blocks until the next request may be sent. It reports false if ctx
+// ended first.
+func (p *pacer) wait(ctx context.Context) bool {
+	if p == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-p.permits:
+		return true
+	}
+}
+
+// rpsLimiter releases requests at a steady rate for tasks in RPS mode
+// (target_rps). Unlike the pacer it spaces every request evenly, and its rate
+// can follow the task's distribution curve. It holds at most one permit, so
+// requests that busy workers could not send are skipped rather than burst
+// later.
+type rpsLimiter struct {
+	permits  chan struct{}
+	interval atomic.Int64 // nanoseconds between requests; 0 pauses
+}
+
+// newRPSLimiter starts a limiter for task that stops when ctx is done. It
+// returns nil, which never blocks, when the task is not in RPS mode.
+func newRPSLimiter(ctx context.Context, task *model.Task) *rpsLimiter {
+	if task.TargetRps <= 0 {
+		return nil
+	}
+	l := &rpsLimiter{permits: make(chan struct{}, 1)}
+	l.setRate(task.TargetRps)
+	go func() {
+		var last time.Time // zero: the first permit is due at once
+		for {
+			// Sleep until the next request is due, waking at least once a
+			// second to pick up rate changes.
+			wait := time.Second
+			interval := time.Duration(l.interval.Load())
+			if interval > 0 {
+				wait = min(wait, time.Until(last.Add(interval)))
+			}
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+				continue
+			}
+			select {
+			case l.permits <- struct{}{}:
+			default:
+			}
+			// Advance from the previous slot so timer latency does not
+			// accumulate, but never catch up on a backlog.
+			last = last.Add(interval)
+			if now := time.Now(); now.Sub(last) > interval {
+				last = now
+			}
+		}
+	}()
+	return l
+}
+
+// setRate changes the request rate; a rate at or below zero pauses requests.
+func (l *rpsLimiter) setRate(rps float64) {
+	var interval time.Duration
+	if rps > 0 {
+		interval = time.Duration(float64(time.Second) / rps)
+	}
+	l.interval.Store(int64(interval))
+}
+
+// wait blocks until the next request may be sent. It reports false if ctx
+// ended first.
+func (l *rpsLimiter) wait(ctx context.Context) bool {
+	if l == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-l.permits:
+		return true
+	}
+}
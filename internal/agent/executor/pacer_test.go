@@ -0,0 +1,40 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+func TestPacerReleasesWholeBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 60 tpm in batches of 3: three requests now, the next three in 3s.
+	p := newPacer(ctx, &model.Task{DispatchRateTpm: 60, DispatchBatchSize: 3})
+
+	for i := 0; i < 3; i++ {
+		waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+		ok := p.wait(waitCtx)
+		waitCancel()
+		if !ok {
+			t.Fatalf("request %d of the first batch was not released", i+1)
+		}
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer waitCancel()
+	if p.wait(waitCtx) {
+		t.Fatal("a fourth request was released before the next batch")
+	}
+}
+
+func TestPacerWithoutRateNeverBlocks(t *testing.T) {
+	p := newPacer(context.Background(), &model.Task{DispatchBatchSize: 3})
+	if p != nil {
+		t.Fatal("expected no pacer without a dispatch rate")
+	}
+	if !p.wait(context.Background()) {
+		t.Fatal("nil pacer should not block")
+	}
+}
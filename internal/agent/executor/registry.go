@@ -0,0 +1,119 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/latency"
+	"github.com/aven/ngoogle/pkg/ratelimit"
+)
+
+// Executor runs one task until it ends, counting the bytes it moves in
+// meter and passing each running total to progress. Run returns nil when the
+// task ends on its own, at its end time or targets, ErrStopped when ctx is
+// cancelled first, and any other error when the task fails.
+type Executor interface {
+	Run(ctx context.Context, task *model.Task, meter *ratelimit.Meter, progress func(int64)) error
+}
+
+// Config is what the agent provides to the executor of one task.
+type Config struct {
+	// Log receives the executor's log lines; see taskLogger.
+	Log *slog.Logger
+	// Latency, when set, receives the timings of HTTP requests and DNS
+	// queries.
+	Latency *latency.Recorder
+	// Requests, when set, counts DNS queries and failed ones, and static
+	// downloads that fail content verification.
+	Requests RequestRecorder
+}
+
+// RequestRecorder counts an executor's requests, failed ones included, and
+// separately those that failed. RecordContentError counts a download whose
+// content did not match the task's expectation, as an error too.
+type RequestRecorder interface {
+	RecordRequest()
+	RecordError()
+	RecordContentError()
+}
+
+// Factory returns an executor for one task.
+type Factory func(Config) Executor
+
+// ErrUnknownType is returned for task types no executor is registered for.
+var ErrUnknownType = errors.New("unknown task type")
+
+// ErrStopped is returned by Run when the task was stopped through its
+// context, by the master pausing, stopping or unassigning it, rather than
+// ending on its own.
+var ErrStopped = errors.New("task stopped")
+
+// stopped returns ErrStopped if ctx, the context a task's Run was given, is
+// done, and nil otherwise.
+func stopped(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ErrStopped
+	}
+	return nil
+}
+
+// Registry maps task types to the factories of their executors.
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[model.TaskType]Factory
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{factories: map[model.TaskType]Factory{}}
+}
+
+// Register makes f the factory for tasks of type t. It panics if t already
+// has one, as two executors for a type is a programming error.
+func (r *Registry) Register(t model.TaskType, f Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.factories[t]; ok {
+		panic(fmt.Sprintf("executor: type %q registered twice", t))
+	}
+	r.factories[t] = f
+}
+
+// New returns an executor for tasks of type t, or an error wrapping
+// ErrUnknownType if none is registered.
+func (r *Registry) New(t model.TaskType, cfg Config) (Executor, error) {
+	r.mu.RLock()
+	f, ok := r.factories[t]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, t)
+	}
+	return f(cfg), nil
+}
+
+// Types returns the registered task types in order.
+func (r *Registry) Types() []model.TaskType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]model.TaskType, 0, len(r.factories))
+	for t := range r.factories {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// Default holds the executors of this package, which register themselves
+// when it is initialized, and those other packages register.
+var Default = NewRegistry()
+
+// Register makes f the factory for tasks of type t in Default.
+func Register(t model.TaskType, f Factory) { Default.Register(t, f) }
+
+// New returns an executor for tasks of type t from Default.
+func New(t model.TaskType, cfg Config) (Executor, error) { return Default.New(t, cfg) }
@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/ratelimit"
+)
+
+type fakeExecutor struct{ cfg Config }
+
+func (fakeExecutor) Run(context.Context, *model.Task, *ratelimit.Meter, func(int64)) error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register("fake", func(cfg Config) Executor { return fakeExecutor{cfg} })
+
+	cfg := Config{Log: taskLogger(nil, &model.Task{ID: "t1"})}
+	exe, err := r.New("fake", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := exe.(fakeExecutor); !ok || got.cfg != cfg {
+		t.Fatalf("New returned %#v, want the factory's executor with the config", exe)
+	}
+
+	_, err = r.New("tcp", cfg)
+	if !errors.Is(err, ErrUnknownType) || err.Error() != `unknown task type "tcp"` {
+		t.Fatalf("New(tcp) error = %v, want unknown task type", err)
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	r := NewRegistry()
+	r.Register("fake", func(Config) Executor { return fakeExecutor{} })
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a type twice did not panic")
+		}
+	}()
+	r.Register("fake", func(Config) Executor { return fakeExecutor{} })
+}
+
+func TestDefaultRegistryHasBuiltinExecutors(t *testing.T) {
+	want := []model.TaskType{model.TaskTypeDNS, model.TaskTypeMixed, model.TaskTypeStatic, model.TaskTypeYoutube}
+	slices.Sort(want)
+	if got := Default.Types(); !slices.Equal(got, want) {
+		t.Fatalf("Default.Types() = %q, want %q", got, want)
+	}
+	for _, typ := range want {
+		if _, err := New(typ, Config{}); err != nil {
+			t.Errorf("New(%s): %v", typ, err)
+		}
+	}
+}
@@ -2,17 +2,29 @@
 package executor
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aven/ngoogle/internal/master/scheduler"
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/latency"
 	"github.com/aven/ngoogle/pkg/ratelimit"
 )
 
@@ -23,10 +35,41 @@ type StaticResult struct {
 	Err             error
 }
 
+// defaultStaticWorkers is the connection count for static tasks that leave
+// concurrent_fragments at its default of 1; a single stream rarely fills a
+// fast link.
+const defaultStaticWorkers = 8
+
+// maxRPSWorkers caps the workers an RPS-mode task starts to keep up with its
+// request rate.
+const maxRPSWorkers = 256
+
+func init() {
+	Register(model.TaskTypeStatic, func(cfg Config) Executor {
+		return &StaticExecutor{Latency: cfg.Latency, Requests: cfg.Requests, Log: cfg.Log}
+	})
+}
+
 // StaticExecutor downloads a static HTTP resource with rate limiting.
-type StaticExecutor struct{}
+type StaticExecutor struct {
+	// Latency, when set, receives the TTFB and response time of every
+	// completed request.
+	Latency *latency.Recorder
+	// Requests, when set, counts the downloads that fail the task's content
+	// verification.
+	Requests RequestRecorder
+	// Log receives the executor's log lines; see taskLogger.
+	Log *slog.Logger
+}
 
-// Run downloads the target URL respecting the rate limit and context.
+// Run downloads the target URLs over staticWorkerCount parallel connections.
+// The workers share the task's token bucket, meter, dispatch pacer or RPS
+// limiter and volume targets, and all stop at the task deadline. Failed
+// requests are retried, except on a permanent HTTP error (see isPermanent),
+// which ends the task with that error. A ${SEQ} in a URL becomes the number
+// of the request's slot. With an expected size or SHA-256 set, every
+// complete download is verified against it; a mismatch is counted as a
+// content error and does not stop the task.
 func (e *StaticExecutor) Run(ctx context.Context, task *model.Task, meter *ratelimit.Meter, progress func(int64)) error {
 	task.Normalize()
 	urls := task.URLs()
@@ -34,58 +77,67 @@ func (e *StaticExecutor) Run(ctx context.Context, task *model.Task, meter *ratel
 		return fmt.Errorf("target_url is required for static task")
 	}
 
-	workers := task.ConcurrentFragments
-	if workers <= 1 {
-		workers = 8
-	}
+	log := taskLogger(e.Log, task)
+	workers := staticWorkerCount(task)
 
-	tb := ratelimit.New(task.TargetRateMbps, 2.0)
+	// In RPS mode the request rate is the only limit; bandwidth is whatever
+	// the responses add up to.
+	rateMbps := task.TargetRateMbps
+	if task.TargetRps > 0 {
+		rateMbps = 0
+	}
+	tb := tokenBucket(task, rateMbps, workers)
 
 	startedAt := time.Now()
 	endAt := computeEndTime(task, startedAt)
 
 	reqCtx, cancel := context.WithDeadline(ctx, endAt)
 	defer cancel()
+	rps := newRPSLimiter(reqCtx, task)
 
 	// Apply jitter to first request
 	if task.JitterPct > 0 {
 		jitterWait := scheduler.ApplyJitter(100*time.Millisecond, task.JitterPct)
 		select {
 		case <-reqCtx.Done():
-			return nil
+			return stopped(ctx)
 		case <-time.After(jitterWait):
 		}
 	}
 
-	// Rate adjustment goroutine
-	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-reqCtx.Done():
-				return
-			case <-ticker.C:
-				var elapsed time.Duration
-				if task.StartedAt != nil {
-					elapsed = time.Since(*task.StartedAt)
-				} else {
-					elapsed = time.Since(startedAt)
-				}
-				mult := scheduler.RateForTask(task, elapsed, nil)
-				tb.SetRate(task.TargetRateMbps * mult)
-			}
+	go followDistribution(reqCtx, task, startedAt, func(mult float64) {
+		if rps != nil {
+			rps.setRate(task.TargetRps * mult)
+		} else {
+			tb.SetRate(task.TargetRateMbps * mult)
 		}
-	}()
+	})
 
+	pace := newPacer(reqCtx, task)
+	client := httpClientFor(task, log)
 	var totalBytes atomic.Int64
 	var reqCount atomic.Int64
+	var mismatches atomic.Int64
+
+	// A permanent error ends the task for all workers.
+	var (
+		failOnce sync.Once
+		failErr  error
+	)
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failErr = err
+			cancel()
+		})
+	}
 
 	var wg sync.WaitGroup
 	for w := 0; w < workers; w++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
+			buf := readBuffer(task)
+			check := newContentCheck(task)
 			for {
 				select {
 				case <-reqCtx.Done():
@@ -97,18 +149,26 @@ func (e *StaticExecutor) Run(ctx context.Context, task *model.Task, meter *ratel
 				if task.TotalBytesTarget > 0 && totalBytes.Load() >= task.TotalBytesTarget {
 					return
 				}
-				if task.TotalRequestsTarget > 0 && reqCount.Load() >= task.TotalRequestsTarget {
+				if !pace.wait(reqCtx) || !rps.wait(reqCtx) {
 					return
 				}
-
-				idx := reqCount.Add(1) - 1
-				targetURL := urls[int(idx)%len(urls)]
-				n, err := downloadOnce(reqCtx, targetURL, tb)
+				// Claim a request slot atomically so that workers together
+				// never exceed the request target.
+				slot := reqCount.Add(1)
+				if task.TotalRequestsTarget > 0 && slot > task.TotalRequestsTarget {
+					return
+				}
+				targetURL := model.ExpandSeq(urls[int(slot-1)%len(urls)], slot)
+				n, err := downloadOnce(reqCtx, client, targetURL, task.RangeChunkBytes, task.CacheBustParam, buf, check.start(), tb, e.Latency)
 				if err != nil {
 					if reqCtx.Err() != nil {
 						return
 					}
-					slog.Warn("static download err, retrying", "worker", workerID, "err", err)
+					if isPermanent(err) {
+						fail(fmt.Errorf("GET %s: %w", targetURL, err))
+						return
+					}
+					log.Warn("static download err, retrying", "worker", workerID, "err", err)
 					select {
 					case <-reqCtx.Done():
 						return
@@ -117,67 +177,376 @@ func (e *StaticExecutor) Run(ctx context.Context, task *model.Task, meter *ratel
 					continue
 				}
 
+				// A download cut short by the deadline is incomplete, not wrong.
+				if check != nil && reqCtx.Err() == nil {
+					if err := check.verify(n); err != nil {
+						if e.Requests != nil {
+							e.Requests.RecordContentError()
+						}
+						// Only the first mismatch is logged: a wrong object is
+						// usually wrong on every request.
+						if mismatches.Add(1) == 1 {
+							log.Warn("static download content mismatch", "url", targetURL, "err", err)
+						}
+					}
+				}
+
 				newTotal := totalBytes.Add(n)
 				meter.Record(n)
 				if progress != nil {
 					progress(newTotal)
 				}
 
-				// Apply inter-request jitter
-				if task.DispatchRateTpm > 0 {
-					interval := scheduler.DispatchInterval(task.DispatchRateTpm, task.DispatchBatchSize)
-					interval = scheduler.ApplyJitter(interval, task.JitterPct)
-					select {
-					case <-reqCtx.Done():
-						return
-					case <-time.After(interval):
-					}
-				}
 			}
 		}(w)
 	}
 
 	wg.Wait()
+	if failErr != nil {
+		return failErr
+	}
+	return stopped(ctx)
+}
+
+// followDistribution passes setRate the multiplier the task's distribution
+// curve gives its target rate, once a second until ctx is done. Time counts
+// from the task's start on the master, or from startedAt before it has one.
+func followDistribution(ctx context.Context, task *model.Task, startedAt time.Time, setRate func(mult float64)) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var elapsed time.Duration
+			if task.StartedAt != nil {
+				elapsed = time.Since(*task.StartedAt)
+			} else {
+				elapsed = time.Since(startedAt)
+			}
+			setRate(scheduler.RateForTask(task, elapsed, nil))
+		}
+	}
+}
+
+// staticWorkerCount returns how many connections a static task downloads
+// over: concurrent_fragments when set above 1, otherwise defaultStaticWorkers.
+// In RPS mode it is raised to one worker per request per second, up to
+// maxRPSWorkers, so responses taking up to a second do not hold the request
+// rate down.
+func staticWorkerCount(task *model.Task) int {
+	n := defaultStaticWorkers
+	if task.ConcurrentFragments > 1 {
+		n = task.ConcurrentFragments
+	}
+	if task.TargetRps > 0 {
+		n = max(n, min(int(math.Ceil(task.TargetRps)), maxRPSWorkers))
+	}
+	return n
+}
+
+// freshConnClient never reuses connections, so every request pays for a new
+// TCP and, for https, TLS handshake. That is the point of the mode, but the
+// handshakes cost the agent CPU as well as the target: a full TLS handshake
+// is dominated by public-key operations, and for small objects it can take
+// more CPU than the transfer itself, cutting the request rate an agent
+// sustains per core by an order of magnitude.
+var freshConnClient = &http.Client{Transport: func() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableKeepAlives = true
+	return t
+}()}
+
+// taskLogger returns log, or when it is nil the default logger tagged with
+// the task's ID, so that every line an executor writes names its task.
+func taskLogger(log *slog.Logger, task *model.Task) *slog.Logger {
+	if log != nil {
+		return log
+	}
+	return slog.With("task_id", task.ID)
+}
+
+// httpClientFor returns the HTTP client matching the task's
+// reuse_connections setting and following redirects by its redirect policy.
+// Each redirect followed is logged to log at debug level.
+func httpClientFor(task *model.Task, log *slog.Logger) *http.Client {
+	c := *freshConnClient
+	if task.ReuseConnections {
+		c = *http.DefaultClient
+	}
+	c.CheckRedirect = checkRedirect(task.Redirects, task.MaxRedirects, log)
+	return &c
+}
+
+// redirectError is a redirect the task's redirect policy refused, in the
+// chain of URLs that led to it.
+type redirectError struct {
+	chain []string
+	msg   string
+}
+
+func (e *redirectError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.msg, strings.Join(e.chain, " -> "))
+}
+
+// checkRedirect returns an http.Client CheckRedirect func enforcing policy
+// and limit, which when zero is model.DefaultMaxRedirects. Under
+// model.RedirectNone the redirect response is returned as is.
+func checkRedirect(policy model.RedirectPolicy, limit int, log *slog.Logger) func(*http.Request, []*http.Request) error {
+	if limit <= 0 {
+		limit = model.DefaultMaxRedirects
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if policy == model.RedirectNone {
+			return http.ErrUseLastResponse
+		}
+		chain := make([]string, 0, len(via)+1)
+		for _, r := range via {
+			chain = append(chain, r.URL.String())
+		}
+		chain = append(chain, req.URL.String())
+		if len(via) > limit {
+			return &redirectError{chain: chain, msg: fmt.Sprintf("stopped after %d redirects", limit)}
+		}
+		if policy == model.RedirectSameHost && !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			return &redirectError{chain: chain, msg: "redirect to another host refused by the same_host policy"}
+		}
+		log.Debug("following redirect", "chain", chain)
+		return nil
+	}
+}
+
+// tokenBucket returns the bucket shaping a task's readers workers to
+// rateMbps together. Its burst is the task's burst_bytes when set, which
+// keeps low rates smooth, and otherwise two seconds of traffic, but at least
+// one read buffer per worker so that all of them make progress.
+func tokenBucket(task *model.Task, rateMbps float64, readers int) *ratelimit.TokenBucket {
+	if task.BurstBytes > 0 {
+		return ratelimit.NewWithBurst(rateMbps, task.BurstBytes)
+	}
+	tb := ratelimit.New(rateMbps, 2.0)
+	tb.SetReaders(readers, readChunk(task))
+	return tb
+}
+
+// readBuffer allocates the buffer a worker reads response bodies into, sized
+// by the task's read_chunk_bytes. Larger buffers mean fewer reads and token
+// bucket waits per byte, which matters at multi-gigabit rates.
+func readBuffer(task *model.Task) []byte {
+	return make([]byte, readChunk(task))
+}
+
+// readChunk returns the size of a task's read buffer.
+func readChunk(task *model.Task) int64 {
+	if task.ReadChunkBytes > 0 {
+		return task.ReadChunkBytes
+	}
+	return model.DefaultReadChunkBytes
+}
+
+// downloadOnce fetches url once. With a positive chunk size it walks the
+// object with sequential Range requests of that many bytes, the way
+// progressive-download and streaming clients do; servers that ignore Range
+// answer the first request with the whole object, which ends the walk.
+// A non-empty bust names a query parameter set to a random value, so that
+// caches in front of the target miss; the Range requests of one walk share
+// it. Bodies are read into buf and copied to w, if not nil, and each
+// request's timings go to lat, which may be nil.
+func downloadOnce(ctx context.Context, client *http.Client, url string, chunk int64, bust string, buf []byte, w io.Writer, tb *ratelimit.TokenBucket, lat *latency.Recorder) (int64, error) {
+	if bust != "" {
+		url = cacheBustURL(url, bust)
+	}
+	if chunk <= 0 {
+		n, _, err := fetch(ctx, client, url, "", buf, w, tb, lat)
+		return n, err
+	}
+	var total int64
+	for start := int64(0); ctx.Err() == nil; start += chunk {
+		n, res, err := fetch(ctx, client, url, fmt.Sprintf("bytes=%d-%d", start, start+chunk-1), buf, w, tb, lat)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		switch {
+		case res.status == http.StatusRequestedRangeNotSatisfiable:
+			return total, nil // the previous chunk ended exactly at the end
+		case res.status != http.StatusPartialContent:
+			return total, nil // Range ignored: the whole object was sent
+		case res.size >= 0 && start+chunk >= res.size, n < chunk:
+			return total, nil
+		}
+	}
+	return total, nil
+}
+
+// cacheBustURL returns raw with the query parameter param set to a random
+// value, or raw itself if it does not parse.
+func cacheBustURL(raw, param string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := param + "=" + strconv.FormatUint(rand.Uint64(), 16)
+	if u.RawQuery != "" {
+		q = u.RawQuery + "&" + q
+	}
+	u.RawQuery = q
+	return u.String()
+}
+
+// contentCheck verifies complete downloads against a static task's expected
+// size and SHA-256. The digest is computed while the body is read, so each
+// worker needs its own.
+type contentCheck struct {
+	size int64
+	sum  []byte
+	hash hash.Hash
+}
+
+// newContentCheck returns the check for task, or nil if the task sets no
+// expectation.
+func newContentCheck(task *model.Task) *contentCheck {
+	if task.ExpectedBytes <= 0 && task.ExpectedSHA256 == "" {
+		return nil
+	}
+	c := &contentCheck{size: task.ExpectedBytes}
+	if task.ExpectedSHA256 != "" {
+		c.sum, _ = hex.DecodeString(task.ExpectedSHA256)
+		c.hash = sha256.New()
+	}
+	return c
+}
+
+// start resets the digest for a new download and returns the writer its
+// body goes to, nil when there is no digest to compute.
+func (c *contentCheck) start() io.Writer {
+	if c == nil || c.hash == nil {
+		return nil
+	}
+	c.hash.Reset()
+	return c.hash
+}
+
+// verify checks a download of n bytes whose body went to the writer from
+// start.
+func (c *contentCheck) verify(n int64) error {
+	if c.size > 0 && n != c.size {
+		return fmt.Errorf("got %d bytes, want %d", n, c.size)
+	}
+	if c.hash != nil {
+		if sum := c.hash.Sum(nil); !bytes.Equal(sum, c.sum) {
+			return fmt.Errorf("got sha256 %x, want %x", sum, c.sum)
+		}
+	}
 	return nil
 }
 
-func downloadOnce(ctx context.Context, url string, tb *ratelimit.TokenBucket) (int64, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// statusError is an HTTP error status the target answered a request with.
+type statusError struct{ code int }
+
+func (e *statusError) Error() string { return fmt.Sprintf("HTTP %d", e.code) }
+
+// isPermanent reports whether err is a response no retry can change: a
+// client error other than a timeout or rate limiting, such as a wrong URL or
+// missing access, or a redirect the task's policy refuses.
+func isPermanent(err error) bool {
+	var re *redirectError
+	if errors.As(err, &re) {
+		return true
+	}
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.code >= 400 && se.code < 500 &&
+		se.code != http.StatusRequestTimeout && se.code != http.StatusTooManyRequests
+}
+
+// fetchResult describes the response to one request.
+type fetchResult struct {
+	status int
+	size   int64 // complete object size from Content-Range, -1 if unknown
+}
+
+// fetch issues one GET, optionally with a Range header, and reads the body
+// through the token bucket, copying it to w if not nil. When the whole body
+// arrives it records the time to first byte and the response time with lat;
+// the response time leaves out time spent waiting on the token bucket, so it
+// reflects the server and the network rather than the task's own rate limit.
+func fetch(ctx context.Context, client *http.Client, url, byteRange string, buf []byte, w io.Writer, tb *ratelimit.TokenBucket, lat *latency.Recorder) (int64, fetchResult, error) {
+	res := fetchResult{size: -1}
+	start := time.Now()
+	var ttfb time.Duration
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { ttfb = time.Since(start) },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, url, nil)
 	if err != nil {
-		return 0, err
+		return 0, res, err
 	}
 	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ngoogle-agent/1.0)")
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return 0, res, err
 	}
 	defer resp.Body.Close()
 
+	res.status = resp.StatusCode
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && byteRange != "" {
+		return 0, res, nil
+	}
 	if resp.StatusCode >= 400 {
-		return 0, fmt.Errorf("HTTP %d", resp.StatusCode)
+		return 0, res, &statusError{code: resp.StatusCode}
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		res.size = contentRangeSize(resp.Header.Get("Content-Range"))
 	}
 
-	// Read with rate limiting
-	buf := make([]byte, 64*1024) // 64 KB chunks
+	// Read with rate limiting, one buffer's worth at a time
 	var total int64
+	var throttled time.Duration
 	for {
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
 			// Wait for token bucket before consuming
+			waitStart := time.Now()
 			if waitErr := tb.Wait(ctx, int64(n)); waitErr != nil {
-				return total, nil // context cancelled
+				return total, res, nil // context cancelled
 			}
+			throttled += time.Since(waitStart)
 			total += int64(n)
+			if w != nil {
+				w.Write(buf[:n])
+			}
 		}
 		if err == io.EOF {
+			lat.Observe(ttfb, time.Since(start)-throttled)
 			break
 		}
 		if err != nil {
-			return total, err
+			return total, res, err
 		}
 	}
-	return total, nil
+	return total, res, nil
+}
+
+// contentRangeSize returns the complete length from a Content-Range header
+// such as "bytes 0-1023/4096", or -1 when it is absent or "*".
+func contentRangeSize(h string) int64 {
+	_, size, ok := strings.Cut(h, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
 }
 
 func computeEndTime(task *model.Task, startedAt time.Time) time.Time {
@@ -0,0 +1,464 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/latency"
+	"github.com/aven/ngoogle/pkg/ratelimit"
+)
+
+func TestStaticExecutorDownloadsOverConcurrentConnections(t *testing.T) {
+	const payload = 4096
+	var (
+		mu                       sync.Mutex
+		inFlight, peak, requests int
+		conns                    = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		requests++
+		conns[r.RemoteAddr] = true
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write(make([]byte, payload))
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	task := &model.Task{
+		Type:                model.TaskTypeStatic,
+		TargetURL:           srv.URL,
+		ConcurrentFragments: 4,
+		TotalRequestsTarget: 8,
+		DurationSec:         10,
+	}
+	meter := &ratelimit.Meter{}
+	if err := (&StaticExecutor{}).Run(context.Background(), task, meter, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 8 {
+		t.Fatalf("expected exactly the 8 requests targeted, got %d", requests)
+	}
+	if peak != 4 {
+		t.Fatalf("expected 4 concurrent requests, peak was %d", peak)
+	}
+	if len(conns) < 4 {
+		t.Fatalf("expected at least 4 client connections, got %d", len(conns))
+	}
+	if got := meter.TotalBytes(); got != 8*payload {
+		t.Fatalf("meter recorded %d bytes, want %d", got, 8*payload)
+	}
+}
+
+func TestStaticExecutorNumbersRequestURLs(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.RawQuery] = true
+		mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	task := &model.Task{
+		Type:                model.TaskTypeStatic,
+		TargetURL:           srv.URL + "/file.bin?n=${SEQ}",
+		ConcurrentFragments: 2,
+		TotalRequestsTarget: 4,
+		DurationSec:         10,
+	}
+	if err := (&StaticExecutor{}).Run(context.Background(), task, &ratelimit.Meter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i <= 4; i++ {
+		if !seen[fmt.Sprintf("n=%d", i)] {
+			t.Errorf("no request for n=%d, got %v", i, seen)
+		}
+	}
+}
+
+func TestStaticExecutorBustsCaches(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Query().Get("cb")] = true
+		mu.Unlock()
+		if r.URL.Query().Get("v") != "1" {
+			t.Errorf("query %q lost the target's own parameter", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	task := &model.Task{
+		Type:                model.TaskTypeStatic,
+		TargetURL:           srv.URL + "/file.bin?v=1",
+		CacheBustParam:      "cb",
+		ConcurrentFragments: 2,
+		TotalRequestsTarget: 10,
+		DurationSec:         10,
+	}
+	if err := (&StaticExecutor{}).Run(context.Background(), task, &ratelimit.Meter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 10 || seen[""] {
+		t.Fatalf("cb values = %v, want 10 distinct ones", seen)
+	}
+}
+
+func TestStaticExecutorVerifiesContent(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	sum := sha256.Sum256(body)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name  string
+		size  int64
+		sha   string
+		chunk int64
+		want  int64
+	}{
+		{"matching sha256", 0, hex.EncodeToString(sum[:]), 0, 0},
+		{"matching sha256 over ranges", int64(len(body)), hex.EncodeToString(sum[:]), 5000, 0},
+		{"wrong sha256", 0, strings.Repeat("0", 64), 0, 4},
+		{"wrong size", int64(len(body)) + 1, "", 0, 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			task := &model.Task{
+				Type:                model.TaskTypeStatic,
+				TargetURL:           srv.URL + "/file.bin",
+				ExpectedBytes:       tc.size,
+				ExpectedSHA256:      tc.sha,
+				RangeChunkBytes:     tc.chunk,
+				ConcurrentFragments: 2,
+				TotalRequestsTarget: 4,
+				DurationSec:         10,
+			}
+			reqs := &countingRecorder{}
+			if err := (&StaticExecutor{Requests: reqs}).Run(context.Background(), task, &ratelimit.Meter{}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := reqs.content.Load(); got != tc.want {
+				t.Fatalf("recorded %d content errors, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStaticWorkerCount(t *testing.T) {
+	if got := staticWorkerCount(&model.Task{ConcurrentFragments: 16}); got != 16 {
+		t.Fatalf("expected concurrent_fragments to set the worker count, got %d", got)
+	}
+	if got := staticWorkerCount(&model.Task{ConcurrentFragments: 1}); got != defaultStaticWorkers {
+		t.Fatalf("expected default worker count, got %d", got)
+	}
+	if got := staticWorkerCount(&model.Task{TargetRps: 100}); got != 100 {
+		t.Fatalf("expected one worker per request per second in RPS mode, got %d", got)
+	}
+	if got := staticWorkerCount(&model.Task{TargetRps: 1e6}); got != maxRPSWorkers {
+		t.Fatalf("expected RPS workers capped at %d, got %d", maxRPSWorkers, got)
+	}
+}
+
+func TestStaticExecutorHoldsTargetRPS(t *testing.T) {
+	const rps, window = 50, 2 * time.Second
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Alternate tiny and large responses: the request rate must not
+		// depend on response size.
+		size := 512
+		if requests.Add(1)%2 == 0 {
+			size = 1 << 20
+		}
+		_, _ = w.Write(make([]byte, size))
+	}))
+	defer srv.Close()
+
+	task := &model.Task{
+		Type:      model.TaskTypeStatic,
+		TargetURL: srv.URL,
+		TargetRps: rps,
+		// Ignored in RPS mode; at 1 Mbps the large responses alone would
+		// cap the rate far below target.
+		TargetRateMbps:   1,
+		ReuseConnections: true,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), window)
+	defer cancel()
+	// Ending the window through ctx stops the task rather than completing it.
+	if err := (&StaticExecutor{}).Run(ctx, task, &ratelimit.Meter{}, nil); !errors.Is(err, ErrStopped) {
+		t.Fatalf("Run returned %v, want ErrStopped", err)
+	}
+
+	got := float64(requests.Load()) / window.Seconds()
+	if got < rps*0.9 || got > rps*1.1 {
+		t.Fatalf("achieved %.1f requests/s, want %d ±10%%", got, rps)
+	}
+}
+
+func TestStaticExecutorConnectionReuse(t *testing.T) {
+	for _, reuse := range []bool{true, false} {
+		var mu sync.Mutex
+		conns := map[string]bool{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			conns[r.RemoteAddr] = true
+			mu.Unlock()
+			_, _ = w.Write(make([]byte, 1024))
+		}))
+
+		task := &model.Task{
+			Type:                model.TaskTypeStatic,
+			TargetURL:           srv.URL,
+			ConcurrentFragments: 2,
+			TotalRequestsTarget: 6,
+			DurationSec:         10,
+			ReuseConnections:    reuse,
+		}
+		err := (&StaticExecutor{}).Run(context.Background(), task, &ratelimit.Meter{}, nil)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reuse && len(conns) > 2 {
+			t.Errorf("reuse: expected at most one connection per worker, got %d", len(conns))
+		}
+		if !reuse && len(conns) != 6 {
+			t.Errorf("fresh: expected a new connection for each of 6 requests, got %d", len(conns))
+		}
+	}
+}
+
+func TestDownloadOnceWalksObjectInRanges(t *testing.T) {
+	object := bytes.Repeat([]byte("x"), 10000)
+	for _, honorRange := range []bool{true, false} {
+		var mu sync.Mutex
+		var ranges []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+			if honorRange {
+				http.ServeContent(w, r, "obj.bin", time.Time{}, bytes.NewReader(object))
+				return
+			}
+			_, _ = w.Write(object)
+		}))
+
+		n, err := downloadOnce(context.Background(), http.DefaultClient, srv.URL, 4096, "", readBuffer(&model.Task{}), nil, ratelimit.New(0, 2), nil)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(object)) {
+			t.Errorf("honorRange=%v: downloaded %d bytes, want %d", honorRange, n, len(object))
+		}
+		want := []string{"bytes=0-4095", "bytes=4096-8191", "bytes=8192-12287"}
+		if !honorRange {
+			want = want[:1] // the full body came back; no further chunks
+		}
+		if strings.Join(ranges, ",") != strings.Join(want, ",") {
+			t.Errorf("honorRange=%v: ranges = %v, want %v", honorRange, ranges, want)
+		}
+	}
+}
+
+func TestDownloadOnceRecordsLatency(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay) // before the first byte
+		w.(http.Flusher).Flush()
+		time.Sleep(delay) // before the last byte
+		_, _ = w.Write([]byte("payload"))
+	}))
+	defer srv.Close()
+
+	lat := &latency.Recorder{}
+	for i := 0; i < 3; i++ {
+		if _, err := downloadOnce(context.Background(), http.DefaultClient, srv.URL, 0, "", readBuffer(&model.Task{}), nil, ratelimit.New(0, 2), lat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ttfb, total := lat.Snapshot()
+	if ttfb.Count != 3 || total.Count != 3 {
+		t.Fatalf("recorded %d/%d samples, want 3", ttfb.Count, total.Count)
+	}
+	if p := ttfb.Quantile(0.5); p < delay || p >= 2*delay {
+		t.Errorf("ttfb p50 = %v, want between %v and %v", p, delay, 2*delay)
+	}
+	if p := total.Quantile(0.5); p < 2*delay {
+		t.Errorf("response time p50 = %v, want at least %v", p, 2*delay)
+	}
+}
+
+func TestStaticExecutorFailsOnPermanentHTTPError(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	task := &model.Task{Type: model.TaskTypeStatic, TargetURL: srv.URL + "/missing", DurationSec: 10}
+	start := time.Now()
+	err := (&StaticExecutor{}).Run(context.Background(), task, &ratelimit.Meter{}, nil)
+
+	if err == nil || err.Error() != "GET "+srv.URL+"/missing: HTTP 404" {
+		t.Fatalf("Run error = %v, want the 404", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("took %s to give up on a 404", time.Since(start))
+	}
+	if n := requests.Load(); n > defaultStaticWorkers {
+		t.Fatalf("retried the 404: %d requests", n)
+	}
+}
+
+func TestStaticExecutorRedirectPolicy(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("elsewhere"))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/local":
+			http.Redirect(w, r, "/file.bin", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/file.bin", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("file"))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path    string
+		policy  model.RedirectPolicy
+		limit   int
+		bytes   int64  // per request, when the task succeeds
+		wantErr string // otherwise
+	}{
+		{"/away", "", 0, int64(len("elsewhere")), ""},
+		{"/local", model.RedirectSameHost, 0, int64(len("file")), ""},
+		{"/away", model.RedirectSameHost, 0, 0, "refused by the same_host policy"},
+		{"/loop", model.RedirectFollow, 3, 0, "stopped after 3 redirects"},
+		{"/local", model.RedirectNone, 0, int64(len("<a href=\"/file.bin\">Found</a>.\n\n")), ""},
+	}
+	for _, tc := range tests {
+		task := &model.Task{
+			Type:                model.TaskTypeStatic,
+			TargetURL:           srv.URL + tc.path,
+			Redirects:           tc.policy,
+			MaxRedirects:        tc.limit,
+			ConcurrentFragments: 1,
+			TotalRequestsTarget: 2,
+			DurationSec:         10,
+		}
+		meter := &ratelimit.Meter{}
+		err := (&StaticExecutor{}).Run(context.Background(), task, meter, nil)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s %q: err = %v, want %q", tc.path, tc.policy, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", tc.path, tc.policy, err)
+		} else if got := meter.TotalBytes(); got != 2*tc.bytes {
+			t.Errorf("%s %q: %d bytes, want %d", tc.path, tc.policy, got, 2*tc.bytes)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&statusError{code: http.StatusNotFound}, true},
+		{&statusError{code: http.StatusForbidden}, true},
+		{&statusError{code: http.StatusTooManyRequests}, false},
+		{&statusError{code: http.StatusRequestTimeout}, false},
+		{&statusError{code: http.StatusBadGateway}, false},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), false},
+		{fmt.Errorf("get: %w", &redirectError{msg: "stopped after 10 redirects"}), true},
+	}
+	for _, tc := range tests {
+		if got := isPermanent(tc.err); got != tc.want {
+			t.Errorf("isPermanent(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkFetchReadChunk downloads the same object with each read buffer
+// size through an unlimited token bucket. Compare MB/s, and ns/op for the
+// CPU spent per object since client and server share the machine:
+//
+//	go test -run '^$' -bench FetchReadChunk -benchmem ./internal/agent/executor
+func BenchmarkFetchReadChunk(b *testing.B) {
+	object := make([]byte, 64<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(object)))
+		_, _ = w.Write(object)
+	}))
+	defer srv.Close()
+
+	for _, size := range []int64{32 << 10, 64 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			buf := readBuffer(&model.Task{ReadChunkBytes: size})
+			tb := ratelimit.New(0, 2)
+			b.SetBytes(int64(len(object)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n, _, err := fetch(context.Background(), http.DefaultClient, srv.URL, "", buf, nil, tb, nil)
+				if err != nil || n != int64(len(object)) {
+					b.Fatalf("fetched %d bytes, err %v", n, err)
+				}
+			}
+		})
+	}
+}
@@ -19,8 +19,15 @@ import (
 	"github.com/aven/ngoogle/pkg/ratelimit"
 )
 
+func init() {
+	Register(model.TaskTypeYoutube, func(cfg Config) Executor { return &YoutubeExecutor{Log: cfg.Log} })
+}
+
 // YoutubeExecutor runs yt-dlp as a managed subprocess.
-type YoutubeExecutor struct{}
+type YoutubeExecutor struct {
+	// Log receives the executor's log lines; see taskLogger.
+	Log *slog.Logger
+}
 
 const (
 	youtubeWorkerTargetMbps     = 500.0
@@ -57,8 +64,8 @@ func (e *YoutubeExecutor) Run(ctx context.Context, task *model.Task, meter *rate
 		perWorkerRate = task.TargetRateMbps / float64(workerCount)
 	}
 
-	slog.Info("youtube executor starting workers",
-		"task", task.ID,
+	log := taskLogger(e.Log, task)
+	log.Info("youtube executor starting workers",
 		"workers", workerCount,
 		"target_rate_mbps", task.TargetRateMbps,
 		"per_worker_rate_mbps", perWorkerRate,
@@ -71,7 +78,7 @@ func (e *YoutubeExecutor) Run(ctx context.Context, task *model.Task, meter *rate
 		workerTask := task.Clone()
 		workerTask.TargetRateMbps = perWorkerRate
 		go func(workerID int, workerTask *model.Task) {
-			errCh <- e.runWorker(loopCtx, workerTask, urls, workerID, workerCount, meter, progress, &totalBytes, errTracker)
+			errCh <- e.runWorker(loopCtx, log.With("worker", workerID), workerTask, urls, workerID, workerCount, meter, progress, &totalBytes, errTracker)
 		}(workerID, workerTask)
 	}
 
@@ -98,11 +105,15 @@ func (e *YoutubeExecutor) Run(ctx context.Context, task *model.Task, meter *rate
 			}
 		}
 	}
-	return firstErr
+	if firstErr != nil {
+		return firstErr
+	}
+	return stopped(ctx)
 }
 
-// runYtdlp runs a single yt-dlp process and blocks until it exits.
-func runYtdlp(ctx context.Context, args []string, cw *countingWriter) error {
+// runYtdlp runs a single yt-dlp process and blocks until it exits, logging
+// its stderr to log at debug level.
+func runYtdlp(ctx context.Context, log *slog.Logger, args []string, cw *countingWriter) error {
 	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
 	cmd.Env = os.Environ()
 	cmd.Stdout = cw
@@ -122,6 +133,7 @@ func runYtdlp(ctx context.Context, args []string, cw *countingWriter) error {
 		defer close(stderrDone)
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
+			log.Debug("yt-dlp", "line", scanner.Text())
 			tail.Add(scanner.Text())
 		}
 		if err := scanner.Err(); err != nil {
@@ -180,6 +192,7 @@ func (w *countingWriter) Total() int64 {
 
 func (e *YoutubeExecutor) runWorker(
 	ctx context.Context,
+	log *slog.Logger,
 	task *model.Task,
 	urls []string,
 	workerID int,
@@ -199,9 +212,9 @@ func (e *YoutubeExecutor) runWorker(
 
 		targetURL := urls[runIndex%len(urls)]
 		args := buildYtdlpArgs(task, targetURL)
-		slog.Info("youtube worker", "task", task.ID, "worker", workerID, "url", targetURL, "args", args)
+		log.Info("youtube worker", "url", targetURL, "args", args)
 
-		err := runYtdlp(ctx, args, cw)
+		err := runYtdlp(ctx, log, args, cw)
 		if err != nil {
 			if ctx.Err() != nil {
 				return nil
@@ -209,7 +222,7 @@ func (e *YoutubeExecutor) runWorker(
 			if errTracker != nil {
 				errTracker.Set(err)
 			}
-			slog.Warn("yt-dlp error, retrying", "task", task.ID, "worker", workerID, "retry_delay", youtubeRetryDelay.String(), "err", err)
+			log.Warn("yt-dlp error, retrying", "retry_delay", youtubeRetryDelay.String(), "err", err)
 			select {
 			case <-ctx.Done():
 				return nil
@@ -220,8 +233,7 @@ func (e *YoutubeExecutor) runWorker(
 
 		runIndex += workerCount
 		sleepSec := youtubeSleepMinSec + rand.Intn(youtubeSleepMaxSec-youtubeSleepMinSec+1)
-		slog.Info("yt-dlp finished, sleeping before next download",
-			"task", task.ID, "worker", workerID,
+		log.Info("yt-dlp finished, sleeping before next download",
 			"total_bytes", cw.Total(), "sleep_sec", sleepSec)
 		select {
 		case <-ctx.Done():
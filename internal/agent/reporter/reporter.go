@@ -3,12 +3,15 @@ package reporter
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/aven/ngoogle/internal/agent/client"
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/latency"
+	"github.com/aven/ngoogle/pkg/masterclient"
 	"github.com/aven/ngoogle/pkg/ratelimit"
 )
 
@@ -18,29 +21,51 @@ type TaskReporter struct {
 	agentID string
 	client  *client.Client
 	meter   *ratelimit.Meter
+	latency latency.Recorder
+	log     *slog.Logger
+
+	warmupUntil time.Time
 
 	mu         sync.Mutex
 	bytesTotal int64
 	reqCount   int64
 	errCount   int64
+	contentErr int64
 }
 
-// NewTaskReporter creates a reporter for a task.
-func NewTaskReporter(taskID, agentID string, c *client.Client, meter *ratelimit.Meter) *TaskReporter {
+// NewTaskReporter creates a reporter for a task. It logs to log, or when log
+// is nil to the default logger tagged with the task and agent IDs.
+func NewTaskReporter(taskID, agentID string, c *client.Client, meter *ratelimit.Meter, log *slog.Logger) *TaskReporter {
 	if meter == nil {
 		meter = &ratelimit.Meter{}
 	}
+	if log == nil {
+		log = slog.With("task_id", taskID, "agent_id", agentID)
+	}
 	return &TaskReporter{
 		taskID:  taskID,
 		agentID: agentID,
 		client:  c,
 		meter:   meter,
+		log:     log,
 	}
 }
 
 // Meter returns the rate meter (for use by executors).
 func (r *TaskReporter) Meter() *ratelimit.Meter { return r.meter }
 
+// Latency returns the request timing recorder (for use by executors). Its
+// cumulative histograms go out with every report.
+func (r *TaskReporter) Latency() *latency.Recorder { return &r.latency }
+
+// SetWarmup marks the samples reported during the next d as warm-up, so the
+// master can leave them out of steady-state figures.
+func (r *TaskReporter) SetWarmup(d time.Duration) {
+	r.mu.Lock()
+	r.warmupUntil = time.Now().Add(d)
+	r.mu.Unlock()
+}
+
 // RecordBytes records downloaded bytes.
 func (r *TaskReporter) RecordBytes(n int64) {
 	r.mu.Lock()
@@ -50,6 +75,14 @@ func (r *TaskReporter) RecordBytes(n int64) {
 	r.meter.Record(n)
 }
 
+// RecordRequest records a request whose bytes executors count in the meter
+// themselves.
+func (r *TaskReporter) RecordRequest() {
+	r.mu.Lock()
+	r.reqCount++
+	r.mu.Unlock()
+}
+
 // RecordError records an error.
 func (r *TaskReporter) RecordError() {
 	r.mu.Lock()
@@ -57,6 +90,15 @@ func (r *TaskReporter) RecordError() {
 	r.mu.Unlock()
 }
 
+// RecordContentError records a download that did not match the task's
+// expected content. It counts as an error too.
+func (r *TaskReporter) RecordContentError() {
+	r.mu.Lock()
+	r.errCount++
+	r.contentErr++
+	r.mu.Unlock()
+}
+
 // Run starts periodic reporting until ctx is cancelled.
 func (r *TaskReporter) Run(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
@@ -64,30 +106,56 @@ func (r *TaskReporter) Run(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			// Final report
-			r.report(context.Background())
+			// Final report, still under ctx's trace.
+			r.report(context.WithoutCancel(ctx), true)
 			return
 		case <-ticker.C:
-			r.report(ctx)
+			r.report(ctx, false)
 		}
 	}
 }
 
-func (r *TaskReporter) report(ctx context.Context) {
+func (r *TaskReporter) report(ctx context.Context, final bool) {
 	r.mu.Lock()
 	m := &model.TaskMetrics{
+		TaskID:            r.taskID,
+		AgentID:           r.agentID,
+		BytesTotal:        r.meter.TotalBytes(),
+		RequestCount:      r.reqCount,
+		ErrorCount:        r.errCount,
+		ContentErrorCount: r.contentErr,
+		RateMbps5s:        r.meter.Rate5s(),
+		RateMbps30s:       r.meter.Rate30s(),
+		Warmup:            time.Now().Before(r.warmupUntil),
+		Final:             final,
+	}
+	r.mu.Unlock()
+	if ttfb, total := r.latency.Snapshot(); total.Count > 0 {
+		m.Latency = &model.LatencyStats{TTFB: ttfb, Total: total}
+	}
+
+	switch err := r.client.ReportMetrics(ctx, m); {
+	case errors.Is(err, masterclient.ErrThrottled):
+		// The master keeps the previous report; the next tick catches up.
+		r.log.Debug("metrics report throttled", "err", err)
+	case err != nil:
+		r.log.Warn("report metrics failed", "err", err)
+	}
+}
+
+// Result returns the task's final counters for an execution that ran for
+// d and returned for reason.
+func (r *TaskReporter) Result(reason model.StopReason, d time.Duration) *model.AgentTaskResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &model.AgentTaskResult{
 		TaskID:       r.taskID,
 		AgentID:      r.agentID,
+		StopReason:   reason,
 		BytesTotal:   r.meter.TotalBytes(),
 		RequestCount: r.reqCount,
 		ErrorCount:   r.errCount,
-		RateMbps5s:   r.meter.Rate5s(),
-		RateMbps30s:  r.meter.Rate30s(),
-	}
-	r.mu.Unlock()
-
-	if err := r.client.ReportMetrics(ctx, m); err != nil {
-		slog.Warn("report metrics failed", "task", r.taskID, "err", err)
+		DurationSec:  d.Seconds(),
 	}
 }
 
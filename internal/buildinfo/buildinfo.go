@@ -0,0 +1,59 @@
+// Package buildinfo identifies the build of the master and agent binaries.
+// Release builds set the variables below with the linker:
+//
+//	go build -ldflags "-X github.com/aven/ngoogle/internal/buildinfo.Version=1.2.0 \
+//		-X github.com/aven/ngoogle/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X github.com/aven/ngoogle/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/master
+//
+// Without them, Commit and Date come from the revision the Go toolchain
+// stamps into binaries built inside a git checkout, if any.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X at build time.
+var (
+	Version = "1.0.0"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes a build. It is what GET /version returns.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns this binary's build info.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if info.Commit != "" {
+		return info
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	var dirty bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if info.Commit != "" && dirty {
+		info.Commit += "-dirty"
+	}
+	return info
+}
@@ -0,0 +1,16 @@
+package buildinfo
+
+import "testing"
+
+func TestGetPrefersLinkerValues(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+	Version, Commit, Date = "1.2.0", "0123abc", "2026-01-02T03:04:05Z"
+
+	got := Get()
+	if got.Version != "1.2.0" || got.Commit != "0123abc" || got.Date != "2026-01-02T03:04:05Z" {
+		t.Fatalf("Get() = %+v, want the linker values", got)
+	}
+	if got.GoVersion == "" {
+		t.Fatal("Get() has no Go version")
+	}
+}
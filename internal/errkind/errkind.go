@@ -0,0 +1,20 @@
+// Package errkind tags errors with a sentinel kind, such as invalid input or
+// a conflict, so that the HTTP and gRPC layers can classify them with
+// errors.Is while the error text stays human readable.
+package errkind
+
+import "fmt"
+
+// Error carries a kind alongside the error it describes.
+type Error struct {
+	kind error
+	err  error
+}
+
+func (e *Error) Error() string   { return e.err.Error() }
+func (e *Error) Unwrap() []error { return []error{e.kind, e.err} }
+
+// Errorf formats an error like fmt.Errorf and tags it with kind.
+func Errorf(kind error, format string, args ...any) error {
+	return &Error{kind: kind, err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,21 @@
+package errkind
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestErrorfMatchesKindAndWrapped(t *testing.T) {
+	kind := errors.New("invalid input")
+	err := Errorf(kind, "reading config: %w", io.ErrUnexpectedEOF)
+	if err.Error() != "reading config: unexpected EOF" {
+		t.Fatalf("text = %q", err)
+	}
+	if !errors.Is(err, kind) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("%v should match both its kind and the wrapped error", err)
+	}
+	if errors.Is(err, io.EOF) {
+		t.Fatal("matched an unrelated error")
+	}
+}
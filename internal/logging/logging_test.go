@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, "DEBUG", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hello", "k", "v")
+	if got := buf.String(); !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, "k=v") {
+		t.Fatalf("text debug output = %q", got)
+	}
+
+	buf.Reset()
+	if l, err = New(&buf, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hidden")
+	l.Info("shown")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.HasPrefix(got, `{"time"`) {
+		t.Fatalf("default output = %q, want JSON at info", got)
+	}
+
+	for _, tc := range [][2]string{{"verbose", ""}, {"info", "xml"}} {
+		if _, err := New(&buf, tc[0], tc[1]); err == nil {
+			t.Errorf("New(%q, %q) succeeded, want an error", tc[0], tc[1])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/spec"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// AdminHandler handles operator endpoints under /api/v1/admin. They require
+// the admin token as a bearer token and are disabled when none is set.
+type AdminHandler struct {
+	store store.Store
+	token string
+}
+
+// NewAdminHandler creates a new AdminHandler accepting token; an empty token
+// disables the admin endpoints.
+func NewAdminHandler(st store.Store, token string) *AdminHandler {
+	return &AdminHandler{store: st, token: token}
+}
+
+// Router registers all admin routes.
+func (h *AdminHandler) Router(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/admin/backup", h.requireToken(h.Backup))
+}
+
+func (h *AdminHandler) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.token == "" {
+			respondErr(w, http.StatusForbidden, "admin endpoints are disabled: ADMIN_TOKEN is not set")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+			respondErr(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Backup handles POST /api/v1/admin/backup
+//
+// Without a path the snapshot is streamed as a download; the file is
+// written to a temporary directory first, since the copy must be complete
+// before any of it is sent.
+func (h *AdminHandler) Backup(w http.ResponseWriter, r *http.Request) {
+	b, ok := h.store.(store.Backuper)
+	if !ok {
+		respondErr(w, http.StatusNotImplemented, "the configured store does not support backups")
+		return
+	}
+	var req spec.BackupRequest
+	if r.ContentLength > 0 {
+		if err := decode(w, r, &req); err != nil {
+			respondErr(w, statusFor(err), err.Error())
+			return
+		}
+	}
+
+	if req.Path != "" {
+		if !filepath.IsAbs(req.Path) {
+			respondErr(w, http.StatusBadRequest, "path must be absolute")
+			return
+		}
+		if _, err := os.Stat(req.Path); err == nil {
+			respondErr(w, http.StatusConflict, fmt.Sprintf("%s already exists", req.Path))
+			return
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			respondErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := b.Backup(r.Context(), req.Path); err != nil {
+			respondErr(w, statusFor(err), err.Error())
+			return
+		}
+		fi, err := os.Stat(req.Path)
+		if err != nil {
+			respondErr(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respond(w, http.StatusOK, spec.BackupResponse{Path: req.Path, SizeBytes: fi.Size()})
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "ngoogle-backup-")
+	if err != nil {
+		respondErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "master.db")
+	if err := b.Backup(r.Context(), path); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		respondErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+	// A large database outlasts the server's write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	name := "ngoogle-" + time.Now().UTC().Format("20060102-150405") + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, f)
+}
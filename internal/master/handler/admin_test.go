@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/spec"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/memory"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func backupRequest(token, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/backup", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// backedUpAgent opens the backup at path and returns agent a1 from it.
+func backedUpAgent(t *testing.T, path string) *model.Agent {
+	t.Helper()
+	st, err := sqlite.New("file:" + path + "?_fk=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	a, err := st.Agents().Get(context.Background(), "a1")
+	if err != nil {
+		t.Fatalf("agent in backup: %v", err)
+	}
+	return a
+}
+
+func TestAdminBackup(t *testing.T) {
+	dir := t.TempDir()
+	st, err := sqlite.New("file:" + filepath.Join(dir, "master.db") + "?_fk=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	now := time.Now()
+	if err := st.Agents().Upsert(context.Background(), &model.Agent{ID: "a1", Hostname: "h1", Status: model.AgentStatusOnline, LastHeartbeat: now, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewAdminHandler(st, "secret").Router(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, backupRequest("wrong", ""))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: status = %d, want 401", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, backupRequest("secret", ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("download: status = %d (%s)", rec.Code, rec.Body)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+	downloaded := filepath.Join(dir, "downloaded.db")
+	if err := os.WriteFile(downloaded, rec.Body.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if a := backedUpAgent(t, downloaded); a.Hostname != "h1" {
+		t.Fatalf("downloaded backup: hostname = %q", a.Hostname)
+	}
+
+	target := filepath.Join(dir, "backup.db")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, backupRequest("secret", `{"path":"`+target+`"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("to path: status = %d (%s)", rec.Code, rec.Body)
+	}
+	var resp spec.BackupResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Path != target || resp.SizeBytes == 0 {
+		t.Fatalf("response = %+v", resp)
+	}
+	if a := backedUpAgent(t, target); a.Hostname != "h1" {
+		t.Fatalf("backup at path: hostname = %q", a.Hostname)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, backupRequest("secret", `{"path":"`+target+`"}`))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("existing path: status = %d, want 409", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, backupRequest("secret", `{"path":"backup.db"}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("relative path: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestAdminBackupDisabledOrUnsupported(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		st    store.Store
+		token string
+		want  int
+	}{
+		{"no admin token", memory.New(), "", http.StatusForbidden},
+		{"store without backups", memory.New(), "secret", http.StatusNotImplemented},
+	} {
+		mux := http.NewServeMux()
+		NewAdminHandler(tc.st, tc.token).Router(mux)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, backupRequest("secret", ""))
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
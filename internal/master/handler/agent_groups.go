@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+)
+
+type AgentGroupHandler struct {
+	svc *service.AgentGroupService
+}
+
+func NewAgentGroupHandler(svc *service.AgentGroupService) *AgentGroupHandler {
+	return &AgentGroupHandler{svc: svc}
+}
+
+func (h *AgentGroupHandler) Router(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/agent-groups", h.Create)
+	mux.HandleFunc("GET /api/v1/agent-groups", h.List)
+	mux.HandleFunc("GET /api/v1/agent-groups/{id}", h.Get)
+	mux.HandleFunc("DELETE /api/v1/agent-groups/{id}", h.Delete)
+	mux.HandleFunc("POST /api/v1/agent-groups/{id}/members", h.AddMembers)
+	mux.HandleFunc("DELETE /api/v1/agent-groups/{id}/members/{agent_id}", h.RemoveMember)
+	mux.HandleFunc("GET /api/v1/agent-groups/{id}/stats", h.Stats)
+	mux.HandleFunc("POST /api/v1/agent-groups/{id}/dispatch", h.Dispatch)
+	mux.HandleFunc("POST /api/v1/agent-groups/{id}/stop", h.Stop)
+}
+
+func (h *AgentGroupHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req service.CreateAgentGroupRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	g, err := h.svc.Create(r.Context(), &req)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respondCreated(w, "/api/v1/agent-groups", g.ID, g)
+}
+
+func (h *AgentGroupHandler) List(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.svc.List(r.Context())
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if groups == nil {
+		groups = []*model.AgentGroup{}
+	}
+	respond(w, http.StatusOK, groups)
+}
+
+func (h *AgentGroupHandler) Get(w http.ResponseWriter, r *http.Request) {
+	g, err := h.svc.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, g)
+}
+
+func (h *AgentGroupHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Delete(r.Context(), r.PathValue("id")); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (h *AgentGroupHandler) AddMembers(w http.ResponseWriter, r *http.Request) {
+	var req service.AgentGroupMembersRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	g, err := h.svc.AddMembers(r.Context(), r.PathValue("id"), req.AgentIDs)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, g)
+}
+
+func (h *AgentGroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	g, err := h.svc.RemoveMember(r.Context(), r.PathValue("id"), r.PathValue("agent_id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, g)
+}
+
+func (h *AgentGroupHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.svc.Stats(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, stats)
+}
+
+func (h *AgentGroupHandler) Dispatch(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Dispatch(r.Context(), r.PathValue("id")); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "dispatched"})
+}
+
+func (h *AgentGroupHandler) Stop(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Stop(r.Context(), r.PathValue("id")); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "stopped"})
+}
@@ -1,9 +1,16 @@
 package handler
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/master/spec"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
 // AgentHandler handles agent-related endpoints.
@@ -18,53 +25,81 @@ func NewAgentHandler(svc *service.AgentService) *AgentHandler {
 
 // Register handles POST /api/v1/agents/register
 func (h *AgentHandler) Register(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Hostname string `json:"hostname"`
-		IP       string `json:"ip"`
-		Port     int    `json:"port"`
-		Version  string `json:"version"`
-	}
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	var req service.RegisterRequest
+	if err := decodeLenient(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
-	agent, err := h.svc.Register(r.Context(), req.Hostname, req.IP, req.Port, req.Version)
+	req.RemoteIP = remoteIP(r)
+	agent, err := h.svc.Register(r.Context(), &req)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
-	respond(w, http.StatusOK, agent)
+	pull, heartbeat := h.svc.Intervals()
+	// Registration also re-admits known agents, so it answers 200 rather
+	// than 201, but still names the agent's URL.
+	w.Header().Set("Location", location("/api/v1/agents", agent.ID))
+	respond(w, http.StatusOK, service.RegisterResponse{
+		Agent:                agent,
+		Token:                agent.Token,
+		PullIntervalSec:      int(pull / time.Second),
+		HeartbeatIntervalSec: int(heartbeat / time.Second),
+	})
 }
 
 // Heartbeat handles POST /api/v1/agents/heartbeat
 func (h *AgentHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		AgentID  string  `json:"agent_id"`
-		Token    string  `json:"token"`
-		RateMbps float64 `json:"rate_mbps"`
-	}
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	var req spec.HeartbeatRequest
+	if err := decodeLenient(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if err := h.svc.ValidateToken(r.Context(), req.AgentID, req.Token); err != nil {
 		respondErr(w, http.StatusUnauthorized, "invalid token")
 		return
 	}
-	if err := h.svc.Heartbeat(r.Context(), req.AgentID, req.RateMbps); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+	if err := h.svc.Heartbeat(r.Context(), req.AgentID, req.AgentRates, req.RunningTasks); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// maxAgentPageSize caps the limit query parameter of the agent list.
+const maxAgentPageSize = 500
+
 // List handles GET /api/v1/agents
+//
+// Optional query parameters: status, tag, limit and offset. The total number
+// of matching agents is returned in the X-Total-Count header.
 func (h *AgentHandler) List(w http.ResponseWriter, r *http.Request) {
-	agents, err := h.svc.List(r.Context())
+	q := r.URL.Query()
+	f := store.AgentFilter{
+		Status: model.AgentStatus(q.Get("status")),
+		Tag:    q.Get("tag"),
+	}
+	var err error
+	if f.Limit, err = queryInt(q.Get("limit")); err != nil {
+		respondErr(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	if f.Offset, err = queryInt(q.Get("offset")); err != nil {
+		respondErr(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	if f.Limit > maxAgentPageSize {
+		f.Limit = maxAgentPageSize
+	}
+	agents, total, err := h.svc.ListFiltered(r.Context(), f)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
+	if agents == nil {
+		agents = []*model.Agent{}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	respond(w, http.StatusOK, agents)
 }
 
@@ -75,6 +110,41 @@ func (h *AgentHandler) Router(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/v1/agents", h.List)
 	mux.HandleFunc("GET /api/v1/agents/{id}", h.agentByID)
 	mux.HandleFunc("DELETE /api/v1/agents/{id}", h.deleteAgent)
+	mux.HandleFunc("POST /api/v1/agents/{id}/rotate-token", h.RotateToken)
+	mux.HandleFunc("POST /api/v1/agents/{id}/disable", h.setEnabled(false))
+	mux.HandleFunc("POST /api/v1/agents/{id}/enable", h.setEnabled(true))
+}
+
+// setEnabled handles POST /api/v1/agents/{id}/disable and /enable, which
+// stop and resume handing the agent new tasks.
+func (h *AgentHandler) setEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agent, err := h.svc.SetEnabled(r.Context(), r.PathValue("id"), enabled)
+		if err != nil {
+			respondErr(w, statusFor(err), err.Error())
+			return
+		}
+		respond(w, http.StatusOK, agent)
+	}
+}
+
+// RotateToken handles POST /api/v1/agents/{id}/rotate-token
+//
+// The new token is returned once. The agent's old token stops working at
+// once; on its next heartbeat the agent re-registers and, once it proves
+// itself with the bootstrap secret, is handed this same token. Without
+// REGISTRATION_SECRET the agent is re-admitted by its machine ID or
+// hostname and IP and gets a token of its own instead. Agents without the
+// bootstrap secret on a master that requires it need it set in their
+// AGENT_TOKEN.
+func (h *AgentHandler) RotateToken(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	token, err := h.svc.RotateToken(r.Context(), id)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, spec.TokenResponse{ID: id, Token: token})
 }
 
 func (h *AgentHandler) deleteAgent(w http.ResponseWriter, r *http.Request) {
@@ -84,7 +154,7 @@ func (h *AgentHandler) deleteAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := h.svc.Delete(r.Context(), id); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -98,8 +168,32 @@ func (h *AgentHandler) agentByID(w http.ResponseWriter, r *http.Request) {
 	}
 	agent, err := h.svc.Get(r.Context(), id)
 	if err != nil {
-		respondErr(w, http.StatusNotFound, err.Error())
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if notModified(w, r, agentETag(agent)) {
 		return
 	}
 	respond(w, http.StatusOK, agent)
 }
+
+// queryInt parses an optional non-negative integer query parameter.
+func queryInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid integer %q", s)
+	}
+	return n, nil
+}
+
+// remoteIP returns the request's source address without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestAgentListScrubsTokensAndFilters(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	svc := service.NewAgentService(st)
+	for _, req := range []*service.RegisterRequest{
+		{Hostname: "h1", IP: "10.0.0.1", MachineID: "m-1", Tags: []string{"eu"}},
+		{Hostname: "h2", IP: "10.0.0.2", Tags: []string{"us"}},
+	} {
+		if _, err := svc.Register(context.Background(), req); err != nil {
+			t.Fatalf("register: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	NewAgentHandler(svc).Router(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents?tag=eu&limit=10", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), `"token"`) || strings.Contains(rec.Body.String(), "m-1") {
+		t.Fatalf("token or machine id leaked in list: %s", rec.Body)
+	}
+	var agents []model.Agent
+	if err := json.Unmarshal(rec.Body.Bytes(), &agents); err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].Hostname != "h1" {
+		t.Fatalf("unexpected agents %+v", agents)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "1" {
+		t.Fatalf("X-Total-Count = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents/"+agents[0].ID, nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), `"token"`) {
+		t.Fatalf("get: status %d body %s", rec.Code, rec.Body)
+	}
+
+	for _, q := range []string{"status=bogus", "limit=-1", "offset=x"} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents?"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", q, rec.Code)
+		}
+	}
+}
+
+func TestRegisterReturnsTokenOnce(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	mux := http.NewServeMux()
+	NewAgentHandler(service.NewAgentService(st)).Router(mux)
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"hostname":"h1","ip":"10.0.0.1","port":9000}`)
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/agents/register", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register status = %d: %s", rec.Code, rec.Body)
+	}
+	var reg struct {
+		ID       string `json:"id"`
+		Hostname string `json:"hostname"`
+		Token    string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reg); err != nil {
+		t.Fatal(err)
+	}
+	if reg.ID == "" || reg.Hostname != "h1" || reg.Token == "" {
+		t.Fatalf("unexpected register payload %s", rec.Body)
+	}
+
+	for _, path := range []string{"/api/v1/agents", "/api/v1/agents/" + reg.ID} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if strings.Contains(rec.Body.String(), reg.Token) {
+			t.Errorf("GET %s leaks the agent token: %s", path, rec.Body)
+		}
+	}
+}
+
+func TestRegisterReturnsIntervals(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	svc := service.NewAgentService(st)
+	if err := svc.SetIntervals(0, 30*time.Second); err == nil {
+		t.Fatal("heartbeat interval at the offline timeout accepted")
+	}
+	if err := svc.SetIntervals(1500*time.Millisecond, 0); err == nil {
+		t.Fatal("fractional interval accepted")
+	}
+	if err := svc.SetIntervals(20*time.Second, 15*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewAgentHandler(svc).Router(mux)
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"hostname":"h1","ip":"10.0.0.1"}`)
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/agents/register", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register status = %d: %s", rec.Code, rec.Body)
+	}
+	var reg service.RegisterResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &reg); err != nil {
+		t.Fatal(err)
+	}
+	if reg.PullIntervalSec != 20 || reg.HeartbeatIntervalSec != 15 {
+		t.Fatalf("intervals = %d/%d, want 20/15", reg.PullIntervalSec, reg.HeartbeatIntervalSec)
+	}
+}
+
+func TestHeartbeatReportsRunningTasks(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	svc := service.NewAgentService(st)
+	var reports [][]string
+	svc.OnHeartbeat = func(_ context.Context, _ string, running []string) {
+		reports = append(reports, running)
+	}
+	reg, err := svc.Register(context.Background(), &service.RegisterRequest{Hostname: "h1", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewAgentHandler(svc).Router(mux)
+
+	for _, running := range []string{`["t1","t2"]`, `[]`, `null`} {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"agent_id":"` + reg.ID + `","token":"` + reg.Token + `","rate_mbps":1,"running_tasks":` + running + `}`)
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/agents/heartbeat", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("heartbeat status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	// Agents that do not report their tasks are not reconciled.
+	if len(reports) != 2 || len(reports[0]) != 2 || reports[0][1] != "t2" || reports[1] == nil || len(reports[1]) != 0 {
+		t.Fatalf("reported running tasks %q, want [[t1 t2] []]", reports)
+	}
+}
+
+func TestHeartbeatStoresRates(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := service.NewAgentService(st)
+	reg, err := svc.Register(ctx, &service.RegisterRequest{Hostname: "h1", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewAgentHandler(svc).Router(mux)
+
+	tests := []struct {
+		rates string
+		want  model.AgentRates
+	}{
+		{`"rate_mbps":120,"task_rate_mbps":100,"tx_rate_mbps":4,"rate_measured":true`,
+			model.AgentRates{RateMbps: 120, TaskRateMbps: 100, TxRateMbps: 4, Measured: true}},
+		// Agents that predate the task and interface rates.
+		{`"rate_mbps":80`, model.AgentRates{RateMbps: 80}},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"agent_id":"` + reg.ID + `","token":"` + reg.Token + `",` + tc.rates + `}`)
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/agents/heartbeat", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("heartbeat status = %d: %s", rec.Code, rec.Body)
+		}
+		a, err := st.Agents().Get(ctx, reg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := model.AgentRates{RateMbps: a.CurrentRateMbps, TaskRateMbps: a.TaskRateMbps, TxRateMbps: a.TxRateMbps, Measured: a.RateMeasured}
+		if got != tc.want {
+			t.Errorf("after %s: rates = %+v, want %+v", tc.rates, got, tc.want)
+		}
+	}
+}
+
+func TestRotateTokenThenReRegister(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	svc := service.NewAgentService(st)
+	svc.SetRegistrationSecret("s3cret")
+	mux := http.NewServeMux()
+	NewAgentHandler(svc).Router(mux)
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+	register := func(token, bootstrap string) *httptest.ResponseRecorder {
+		return post("/api/v1/agents/register", `{"hostname":"h1","ip":"10.0.0.1","machine_id":"m-1","token":"`+token+`","bootstrap_token":"`+bootstrap+`"}`)
+	}
+	heartbeat := func(id, token string) int {
+		return post("/api/v1/agents/heartbeat", `{"agent_id":"`+id+`","token":"`+token+`"}`).Code
+	}
+
+	var reg service.RegisterResponse
+	if err := json.Unmarshal(register("", "s3cret").Body.Bytes(), &reg); err != nil {
+		t.Fatal(err)
+	}
+	rec := post("/api/v1/agents/"+reg.Agent.ID+"/rotate-token", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rotate: status %d: %s", rec.Code, rec.Body)
+	}
+	var rotated struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rotated); err != nil || rotated.Token == "" || rotated.Token == reg.Token {
+		t.Fatalf("rotate returned %q (%v), old token %q", rotated.Token, err, reg.Token)
+	}
+
+	// The agent's next heartbeat is refused, and so is its old token...
+	if code := heartbeat(reg.Agent.ID, reg.Token); code != http.StatusUnauthorized {
+		t.Fatalf("heartbeat with old token: status %d, want 401", code)
+	}
+	if rec := register(reg.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("re-register with old token: status %d, want 401", rec.Code)
+	}
+	// ...as is anyone who knows the machine but not the secret.
+	if rec := register("", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("re-register without secret: status %d, want 401", rec.Code)
+	}
+	// With the secret the agent gets back the rotated token, not another one.
+	var again service.RegisterResponse
+	if err := json.Unmarshal(register("", "s3cret").Body.Bytes(), &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.Agent.ID != reg.Agent.ID || again.Token != rotated.Token {
+		t.Fatalf("re-register: agent %s token %q, want %s and the rotated token", again.Agent.ID, again.Token, reg.Agent.ID)
+	}
+	if code := heartbeat(reg.Agent.ID, rotated.Token); code != http.StatusOK {
+		t.Fatalf("heartbeat with rotated token: status %d", code)
+	}
+}
+
+func TestOpenReRegisterMintsFreshToken(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	svc := service.NewAgentService(st)
+	mux := http.NewServeMux()
+	NewAgentHandler(svc).Router(mux)
+	register := func() service.RegisterResponse {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/agents/register",
+			strings.NewReader(`{"hostname":"h1","ip":"10.0.0.1","machine_id":"m-1"}`)))
+		var reg service.RegisterResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &reg); err != nil {
+			t.Fatalf("register: status %d: %v", rec.Code, err)
+		}
+		return reg
+	}
+
+	first := register()
+	// Without a registration secret, knowing the machine is not enough to
+	// be handed the agent's live token.
+	second := register()
+	if second.Agent.ID != first.Agent.ID {
+		t.Fatalf("re-register created agent %s, want %s", second.Agent.ID, first.Agent.ID)
+	}
+	if second.Token == "" || second.Token == first.Token {
+		t.Fatalf("re-register returned token %q, want a fresh one (old %q)", second.Token, first.Token)
+	}
+}
@@ -1,13 +1,19 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/store"
 )
 
+// maxTopTasks caps the top query parameter of the overview.
+const maxTopTasks = 100
+
 // DashboardHandler handles dashboard endpoints.
 type DashboardHandler struct {
 	svc *service.DashboardService
@@ -22,13 +28,25 @@ func NewDashboardHandler(svc *service.DashboardService) *DashboardHandler {
 func (h *DashboardHandler) Router(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/v1/dashboard/overview", h.Overview)
 	mux.HandleFunc("GET /api/v1/dashboard/bandwidth/history", h.BandwidthHistory)
+	mux.HandleFunc("GET /api/v1/dashboard/bandwidth/total/history", h.BandwidthTotalHistory)
+	mux.HandleFunc("GET /api/v1/agents/{id}/bandwidth/history", h.AgentBandwidthHistory)
+	mux.HandleFunc("GET /api/v1/dashboard/provisioning", h.Provisioning)
 }
 
-// Overview handles GET /api/v1/dashboard/overview
+// Overview handles GET /api/v1/dashboard/overview?top=N
 func (h *DashboardHandler) Overview(w http.ResponseWriter, r *http.Request) {
-	resp, err := h.svc.Overview(r.Context())
+	top := service.DefaultTopTasks
+	if s := r.URL.Query().Get("top"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxTopTasks {
+			respondErr(w, http.StatusBadRequest, "top must be an integer between 0 and "+strconv.Itoa(maxTopTasks))
+			return
+		}
+		top = n
+	}
+	resp, err := h.svc.Overview(r.Context(), top)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, resp)
@@ -36,33 +54,109 @@ func (h *DashboardHandler) Overview(w http.ResponseWriter, r *http.Request) {
 
 // BandwidthHistory handles GET /api/v1/dashboard/bandwidth/history
 func (h *DashboardHandler) BandwidthHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	agg := store.BandwidthAgg(r.URL.Query().Get("agg"))
+	points, stepSec, err := h.svc.BandwidthHistory(r.Context(), q.from, q.to, q.stepSec, q.maxPoints, agg)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	w.Header().Set("X-Step-Seconds", strconv.Itoa(stepSec))
+	respond(w, http.StatusOK, points)
+}
+
+// BandwidthTotalHistory handles GET /api/v1/dashboard/bandwidth/total/history
+func (h *DashboardHandler) BandwidthTotalHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	points, stepSec, err := h.svc.BandwidthTotalHistory(r.Context(), q.from, q.to, q.stepSec, q.maxPoints)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	w.Header().Set("X-Step-Seconds", strconv.Itoa(stepSec))
+	respond(w, http.StatusOK, points)
+}
+
+// AgentBandwidthHistory handles GET /api/v1/agents/{id}/bandwidth/history
+func (h *DashboardHandler) AgentBandwidthHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	points, stepSec, err := h.svc.AgentBandwidthHistory(r.Context(), r.PathValue("id"), q.from, q.to, q.stepSec, q.maxPoints)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if points == nil {
+		points = []store.BandwidthPoint{}
+	}
+	w.Header().Set("X-Step-Seconds", strconv.Itoa(stepSec))
+	respond(w, http.StatusOK, points)
+}
+
+// historyQuery holds the query parameters of the bandwidth history endpoints.
+type historyQuery struct {
+	from, to  time.Time
+	stepSec   int
+	maxPoints int
+}
+
+func parseHistoryQuery(r *http.Request) (historyQuery, error) {
 	q := r.URL.Query()
-	from := parseTime(q.Get("from"), time.Now().Add(-7*24*time.Hour))
-	to := parseTime(q.Get("to"), time.Now())
-	stepSec := 60
+	from, to, err := parseRange(q, 0)
+	if err != nil {
+		return historyQuery{}, err
+	}
+	hq := historyQuery{from: from, to: to, stepSec: 60}
 	if s := q.Get("step"); s != "" {
-		// parse "1m", "5m", "30m", or plain seconds
-		switch s {
-		case "5m":
-			stepSec = 300
-		case "15m":
-			stepSec = 900
-		case "30m":
-			stepSec = 1800
-		case "1h":
-			stepSec = 3600
-		case "1m":
-			stepSec = 60
-		default:
-			if n, err := strconv.Atoi(s); err == nil {
-				stepSec = n
-			}
+		n, err := parseStep(s)
+		if err != nil {
+			return historyQuery{}, err
 		}
+		hq.stepSec = n
 	}
-	points, err := h.svc.BandwidthHistory(r.Context(), from, to, stepSec)
+	if s := q.Get("max_points"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 2 {
+			return historyQuery{}, errors.New("max_points must be an integer of at least 2")
+		}
+		hq.maxPoints = n
+	}
+	return hq, nil
+}
+
+// Provisioning handles GET /api/v1/dashboard/provisioning. Without a range
+// it covers every job.
+func (h *DashboardHandler) Provisioning(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseRange(r.URL.Query(), 0)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	respond(w, http.StatusOK, points)
+	stats, err := h.svc.ProvisioningStats(r.Context(), from, to)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, stats)
+}
+
+// parseStep parses a bucket size with parseDuration and returns it in whole
+// seconds.
+func parseStep(s string) (int, error) {
+	d, err := parseDuration(s)
+	if err != nil || d%time.Second != 0 {
+		return 0, fmt.Errorf("invalid step %q: use a whole number of seconds or a duration such as 10s, 15m or 1h", s)
+	}
+	return int(d / time.Second), nil
 }
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aven/ngoogle/internal/master/provision"
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// statusFor maps a service or store error to the HTTP status clients should
+// see. Errors without a known kind are treated as internal failures.
+func statusFor(err error) int {
+	var tooLarge *http.MaxBytesError
+	var badBody *bodyError
+	switch {
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &badBody):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrInvalidInput), errors.Is(err, provision.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrInvalidAgentToken), errors.Is(err, service.ErrRegistrationDenied):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrNotAssigned):
+		return http.StatusForbidden
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrThrottled):
+		return http.StatusTooManyRequests
+	case errors.Is(err, provision.ErrRemote):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
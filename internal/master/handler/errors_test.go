@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+func TestStatusFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("task %w", store.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w: duplicate id", store.ErrConflict), http.StatusConflict},
+		{service.ErrInvalidInput, http.StatusBadRequest},
+		{service.ErrRegistrationDenied, http.StatusUnauthorized},
+		{service.ErrNotAssigned, http.StatusForbidden},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		if got := statusFor(tc.err); got != tc.want {
+			t.Errorf("statusFor(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
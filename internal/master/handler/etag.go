@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// etag returns a weak entity tag for a resource whose JSON form is fully
+// determined by parts. The tag is weak because it is derived from those
+// fields rather than from the encoded bytes.
+func etag(parts ...any) string {
+	h := sha256.New()
+	for _, p := range parts {
+		if t, ok := p.(time.Time); ok {
+			// Format loses the monotonic reading and the zone name, which
+			// differ between otherwise equal times.
+			p = t.UTC().Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(h, "%v\x00", p)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// taskETag tags a task as returned by TaskService.Get. Besides the task's
+// own updated_at and status, its progress comes from metric reports and its
+// URLs from its pool, neither of which touches the task row.
+func taskETag(t *model.Task) string {
+	var poolUpdated time.Time
+	if t.URLPool != nil {
+		poolUpdated = t.URLPool.UpdatedAt
+	}
+	return etag(t.UpdatedAt, t.Status, t.TotalBytesDone, poolUpdated)
+}
+
+// agentETag tags an agent. Every store write to an agent, heartbeats
+// included, sets its updated_at.
+func agentETag(a *model.Agent) string {
+	return etag(a.UpdatedAt, a.Status)
+}
+
+// notModified sets the response's ETag to tag and, if the request's
+// If-None-Match already names it, writes 304 Not Modified and reports true;
+// the caller then writes nothing else.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+	if !etagMatches(r.Header.Get("If-None-Match"), tag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether the If-None-Match header value header lists
+// tag, using the weak comparison RFC 9110 prescribes for it.
+func etagMatches(header, tag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+// conditionalGet fetches path with If-None-Match set to tag, if any.
+func conditionalGet(mux http.Handler, path, tag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if tag != "" {
+		req.Header.Set("If-None-Match", tag)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTaskGetETag(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", AgentID: "a1", Type: model.TaskTypeYoutube, TargetURL: "https://youtu.be/example",
+		Status: model.TaskStatusPending, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewTaskService(st)
+	mux := http.NewServeMux()
+	NewTaskHandler(svc, service.NewAgentService(st)).Router(mux)
+
+	rec := conditionalGet(mux, "/api/v1/tasks/t1", "")
+	tag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || tag == "" {
+		t.Fatalf("first get: status %d, ETag %q", rec.Code, tag)
+	}
+	rec = conditionalGet(mux, "/api/v1/tasks/t1", tag)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("unchanged: status %d body %q, want 304 and no body", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got != tag {
+		t.Fatalf("304 ETag = %q, want %q", got, tag)
+	}
+
+	// Progress comes from metric reports, which leave the task row alone.
+	if err := st.TaskMetrics().Insert(ctx, &model.TaskMetrics{TaskID: "t1", AgentID: "a1", BytesTotal: 1 << 20, RecordedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	rec = conditionalGet(mux, "/api/v1/tasks/t1", tag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == tag {
+		t.Fatalf("after progress: status %d ETag %q, want 200 and a new tag", rec.Code, rec.Header().Get("ETag"))
+	}
+	tag = rec.Header().Get("ETag")
+
+	if err := svc.Dispatch(ctx, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	rec = conditionalGet(mux, "/api/v1/tasks/t1", `"other", `+tag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == tag {
+		t.Fatalf("after dispatch: status %d ETag %q, want 200 and a new tag", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestAgentGetETag(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := service.NewAgentService(st)
+	agent, err := svc.Register(ctx, &service.RegisterRequest{Hostname: "h1", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewAgentHandler(svc).Router(mux)
+	path := "/api/v1/agents/" + agent.ID
+
+	tag := conditionalGet(mux, path, "").Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("no ETag")
+	}
+	if rec := conditionalGet(mux, path, tag); rec.Code != http.StatusNotModified {
+		t.Fatalf("unchanged: status %d, want 304", rec.Code)
+	}
+	if rec := conditionalGet(mux, path, "*"); rec.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match *: status %d, want 304", rec.Code)
+	}
+	if _, err := svc.SetEnabled(ctx, agent.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if rec := conditionalGet(mux, path, tag); rec.Code != http.StatusOK {
+		t.Fatalf("after disable: status %d, want 200", rec.Code)
+	}
+}
@@ -2,10 +2,59 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"net/url"
 )
 
+// DefaultMaxBodyBytes is the request body limit applied by decode unless
+// Config.MaxBodyBytes overrides it.
+const DefaultMaxBodyBytes = 1 << 20
+
+// Config holds how the handlers read request bodies. A router applies it
+// with Wrap; requests served without it get the defaults.
+type Config struct {
+	// MaxBodyBytes is the request body limit; zero or less means
+	// DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// StrictJSON makes decode reject bodies with fields the target type
+	// does not define, so typos surface as a 400 naming the field.
+	// Agent-facing endpoints stay lenient so agents and master can be
+	// upgraded independently.
+	StrictJSON bool
+}
+
+type configKey struct{}
+
+// Wrap returns next serving its requests under c.
+func (c Config) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), configKey{}, c)))
+	})
+}
+
+// configOf returns the Config r is served under.
+func configOf(r *http.Request) Config {
+	c, _ := r.Context().Value(configKey{}).(Config)
+	return c
+}
+
+// maxBodyBytes returns the request body limit.
+func (c Config) maxBodyBytes() int64 {
+	if c.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// bodyError marks a request body that could not be decoded. It keeps the
+// decoder's message so responses read the same as before.
+type bodyError struct{ err error }
+
+func (e *bodyError) Error() string { return e.err.Error() }
+func (e *bodyError) Unwrap() error { return e.err }
+
 // respond writes a JSON response.
 func respond(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -13,15 +62,47 @@ func respond(w http.ResponseWriter, code int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// respondCreated writes v, a resource just created, with 201 Created and a
+// Location header naming its URL: collection, such as "/api/v1/tasks",
+// followed by its id.
+func respondCreated(w http.ResponseWriter, collection, id string, v any) {
+	w.Header().Set("Location", location(collection, id))
+	respond(w, http.StatusCreated, v)
+}
+
+// location returns the URL of the resource id in collection.
+func location(collection, id string) string {
+	return collection + "/" + url.PathEscape(id)
+}
+
 // respondErr writes a JSON error response.
 func respondErr(w http.ResponseWriter, code int, msg string) {
 	respond(w, code, map[string]string{"error": msg})
 }
 
-// decode decodes JSON request body.
-func decode(r *http.Request, v any) error {
+// decode decodes a JSON request body within the body limit, rejecting
+// unknown fields when Config.StrictJSON is set. Failures are classified by
+// statusFor: 413 for an oversized body, 400 otherwise.
+func decode(w http.ResponseWriter, r *http.Request, v any) error {
+	return decodeBody(w, r, v, configOf(r).StrictJSON)
+}
+
+// decodeLenient is decode without strict mode, for requests sent by agents
+// that may be newer or older than the master.
+func decodeLenient(w http.ResponseWriter, r *http.Request, v any) error {
+	return decodeBody(w, r, v, false)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, strict bool) error {
 	defer r.Body.Close()
-	return json.NewDecoder(r.Body).Decode(v)
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, configOf(r).maxBodyBytes()))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return &bodyError{err: err}
+	}
+	return nil
 }
 
 // pathParam extracts a path segment after the given prefix.
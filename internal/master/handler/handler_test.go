@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestDecodeRejectsOversizedBody(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	mux := http.NewServeMux()
+	NewURLPoolHandler(st).Router(mux)
+	srv := Config{MaxBodyBytes: 1024}.Wrap(mux)
+
+	big := `{"name":"p","type":"static","urls":["https://example.com/` + strings.Repeat("a", 4096) + `"]}`
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/url-pools", strings.NewReader(big)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized body: status = %d, want 413 (%s)", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/url-pools", strings.NewReader(`{"name":`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("malformed body: status = %d, want 400", rec.Code)
+	}
+
+	small := `{"name":"p","type":"static","urls":["https://example.com/a"]}`
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/url-pools", strings.NewReader(small)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("small body: status = %d, want 201 (%s)", rec.Code, rec.Body)
+	}
+}
+
+func TestStrictJSONRejectsUnknownFields(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	mux := http.NewServeMux()
+	NewURLPoolHandler(st).Router(mux)
+	body := `{"name":"p","type":"static","urls":["https://example.com/a"],"descripton":"typo"}`
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/url-pools", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("lenient mode: status = %d, want 201 (%s)", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	Config{StrictJSON: true}.Wrap(mux).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/url-pools", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "descripton") {
+		t.Fatalf("strict mode: status = %d body %s, want 400 naming the field", rec.Code, rec.Body)
+	}
+}
+
+func TestCreateSetsLocation(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	mux := http.NewServeMux()
+	NewURLPoolHandler(st).Router(mux)
+
+	body := `{"name":"p","type":"static","urls":["https://example.com/a"]}`
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/url-pools", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, want 201 (%s)", rec.Code, rec.Body)
+	}
+	loc := rec.Header().Get("Location")
+	if !strings.HasPrefix(loc, "/api/v1/url-pools/") {
+		t.Fatalf("Location = %q, want a URL under /api/v1/url-pools/", loc)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, loc, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET Location: status = %d, want 200 (%s)", rec.Code, rec.Body)
+	}
+}
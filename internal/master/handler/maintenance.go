@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+)
+
+type MaintenanceHandler struct {
+	svc *service.MaintenanceService
+}
+
+func NewMaintenanceHandler(svc *service.MaintenanceService) *MaintenanceHandler {
+	return &MaintenanceHandler{svc: svc}
+}
+
+func (h *MaintenanceHandler) Router(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/maintenance-windows", h.Create)
+	mux.HandleFunc("GET /api/v1/maintenance-windows", h.List)
+	mux.HandleFunc("GET /api/v1/maintenance-windows/{id}", h.Get)
+	mux.HandleFunc("PUT /api/v1/maintenance-windows/{id}", h.Update)
+	mux.HandleFunc("DELETE /api/v1/maintenance-windows/{id}", h.Delete)
+}
+
+func (h *MaintenanceHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req service.MaintenanceWindowRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	t, err := h.svc.Create(r.Context(), &req)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respondCreated(w, "/api/v1/maintenance-windows", t.ID, t)
+}
+
+func (h *MaintenanceHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.List(r.Context())
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if list == nil {
+		list = []*model.MaintenanceWindow{}
+	}
+	respond(w, http.StatusOK, list)
+}
+
+func (h *MaintenanceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	t, err := h.svc.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, t)
+}
+
+func (h *MaintenanceHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req service.MaintenanceWindowRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	t, err := h.svc.Update(r.Context(), r.PathValue("id"), &req)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, t)
+}
+
+func (h *MaintenanceHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Delete(r.Context(), r.PathValue("id")); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
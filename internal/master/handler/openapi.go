@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/aven/ngoogle/internal/master/spec"
+)
+
+// OpenAPIHandler serves the generated OpenAPI document.
+type OpenAPIHandler struct {
+	once sync.Once
+	doc  []byte
+	err  error
+}
+
+// NewOpenAPIHandler creates a new OpenAPIHandler.
+func NewOpenAPIHandler() *OpenAPIHandler {
+	return &OpenAPIHandler{}
+}
+
+// Router registers the spec route.
+func (h *OpenAPIHandler) Router(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/openapi.json", h.Spec)
+}
+
+// Spec handles GET /api/v1/openapi.json
+func (h *OpenAPIHandler) Spec(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() {
+		h.doc, h.err = json.Marshal(spec.Document(spec.Routes))
+	})
+	if h.err != nil {
+		respondErr(w, http.StatusInternalServerError, h.err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(h.doc)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/aven/ngoogle/internal/master/spec"
+)
+
+// TestSpecCoversRoutes keeps spec.Routes in step with the Router methods by
+// scanning this package's sources for registered API patterns.
+func TestSpecCoversRoutes(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`mux\.HandleFunc\("([A-Z]+) (/api/v1/[^"]+)"`)
+	registered := map[string]bool{}
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range re.FindAllStringSubmatch(string(src), -1) {
+			registered[m[1]+" "+m[2]] = true
+		}
+	}
+
+	documented := map[string]bool{}
+	for _, r := range spec.Routes {
+		documented[r.Method+" "+r.Path] = true
+	}
+	for route := range registered {
+		if !documented[route] {
+			t.Errorf("route %s is registered but missing from spec.Routes", route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			t.Errorf("route %s is in spec.Routes but not registered", route)
+		}
+	}
+}
+
+func TestOpenAPIServesDocument(t *testing.T) {
+	mux := http.NewServeMux()
+	NewOpenAPIHandler().Router(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if doc.OpenAPI == "" {
+		t.Fatal("missing openapi version")
+	}
+	if _, ok := doc.Paths["/api/v1/tasks/{id}"]["get"]; !ok {
+		t.Fatal("GET /api/v1/tasks/{id} not documented")
+	}
+	task, ok := doc.Components.Schemas["Task"]
+	if !ok {
+		t.Fatal("Task schema missing")
+	}
+	props, _ := task["properties"].(map[string]any)
+	if _, ok := props["target_rate_mbps"]; !ok {
+		t.Fatalf("Task schema lacks target_rate_mbps: %v", props)
+	}
+}
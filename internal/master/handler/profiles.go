@@ -4,8 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/aven/ngoogle/internal/master/spec"
 	"github.com/aven/ngoogle/internal/model"
 	"github.com/aven/ngoogle/internal/store"
 )
@@ -24,48 +26,62 @@ func NewProfileHandler(st store.Store) *ProfileHandler {
 func (h *ProfileHandler) Router(mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/v1/traffic-profiles", h.Create)
 	mux.HandleFunc("GET /api/v1/traffic-profiles", h.List)
+	mux.HandleFunc("GET /api/v1/traffic-profiles/{id}", h.Get)
 }
 
 // Create handles POST /api/v1/traffic-profiles
 func (h *ProfileHandler) Create(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name         string             `json:"name"`
-		Description  string             `json:"description"`
-		Distribution model.Distribution `json:"distribution"`
-		Points       string             `json:"points"`
-	}
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	var req spec.TrafficProfileRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			respondErr(w, http.StatusBadRequest, "invalid timezone: "+req.Timezone)
+			return
+		}
+	}
 	p := &model.TrafficProfile{
 		ID:           newID(),
 		Name:         req.Name,
+		ExternalID:   strings.TrimSpace(req.ExternalID),
 		Description:  req.Description,
 		Distribution: req.Distribution,
 		Points:       req.Points,
+		Timezone:     req.Timezone,
 		CreatedAt:    time.Now(),
 	}
 	if p.Points == "" {
 		p.Points = "[]"
 	}
 	if err := h.store.TrafficProfiles().Create(r.Context(), p); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
-	respond(w, http.StatusCreated, p)
+	respondCreated(w, "/api/v1/traffic-profiles", p.ID, p)
 }
 
 // List handles GET /api/v1/traffic-profiles
 func (h *ProfileHandler) List(w http.ResponseWriter, r *http.Request) {
 	profiles, err := h.store.TrafficProfiles().List(r.Context())
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, profiles)
 }
 
+// Get handles GET /api/v1/traffic-profiles/{id}
+func (h *ProfileHandler) Get(w http.ResponseWriter, r *http.Request) {
+	p, err := h.store.TrafficProfiles().Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, p)
+}
+
 // newID generates a random hex ID.
 func newID() string {
 	b := make([]byte, 8)
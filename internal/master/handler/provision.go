@@ -22,32 +22,34 @@ func (h *ProvisionHandler) Router(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/v1/agents/provision-jobs", h.ListJobs)
 	mux.HandleFunc("GET /api/v1/agents/provision-jobs/{job_id}", h.GetJob)
 	mux.HandleFunc("POST /api/v1/agents/provision-jobs/{job_id}/retry", h.RetryJob)
+	mux.HandleFunc("POST /api/v1/agents/provision-jobs/{job_id}/uninstall", h.UninstallJob)
 	mux.HandleFunc("DELETE /api/v1/agents/provision-jobs/{job_id}", h.DeleteJob)
 	mux.HandleFunc("POST /api/v1/credentials", h.CreateCredential)
 	mux.HandleFunc("GET /api/v1/credentials", h.ListCredentials)
+	mux.HandleFunc("PUT /api/v1/credentials/{id}", h.UpdateCredential)
 	mux.HandleFunc("DELETE /api/v1/credentials/{id}", h.DeleteCredential)
 }
 
 // StartProvision handles POST /api/v1/agents/provision
 func (h *ProvisionHandler) StartProvision(w http.ResponseWriter, r *http.Request) {
 	var req provision.JobRequest
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	job, err := h.svc.Start(r.Context(), &req)
 	if err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
-	respond(w, http.StatusCreated, job)
+	respondCreated(w, "/api/v1/agents/provision-jobs", job.ID, job)
 }
 
 // ListJobs handles GET /api/v1/agents/provision-jobs
 func (h *ProvisionHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
 	jobs, err := h.svc.ListJobs(r.Context())
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, jobs)
@@ -58,7 +60,7 @@ func (h *ProvisionHandler) GetJob(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("job_id")
 	job, err := h.svc.GetJob(r.Context(), id)
 	if err != nil {
-		respondErr(w, http.StatusNotFound, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, job)
@@ -69,27 +71,38 @@ func (h *ProvisionHandler) RetryJob(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("job_id")
 	job, err := h.svc.Retry(r.Context(), id)
 	if err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, job)
 }
 
+// UninstallJob handles POST /api/v1/agents/provision-jobs/{job_id}/uninstall
+func (h *ProvisionHandler) UninstallJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("job_id")
+	if err := h.svc.Uninstall(r.Context(), id); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "uninstalled"})
+}
+
 // DeleteJob handles DELETE /api/v1/agents/provision-jobs/{job_id}
 func (h *ProvisionHandler) DeleteJob(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("job_id")
 	if err := h.svc.DeleteJob(r.Context(), id); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
-// DeleteCredential handles DELETE /api/v1/credentials/{id}
+// DeleteCredential handles DELETE /api/v1/credentials/{id}. It answers 409
+// while provision jobs reference the credential, unless force=true.
 func (h *ProvisionHandler) DeleteCredential(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	if err := h.svc.DeleteCredential(r.Context(), id); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+	if err := h.svc.DeleteCredential(r.Context(), id, r.URL.Query().Get("force") == "true"); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -98,25 +111,42 @@ func (h *ProvisionHandler) DeleteCredential(w http.ResponseWriter, r *http.Reque
 // CreateCredential handles POST /api/v1/credentials
 func (h *ProvisionHandler) CreateCredential(w http.ResponseWriter, r *http.Request) {
 	var req provision.CredentialRequest
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	cred, err := h.svc.CreateCredential(r.Context(), &req)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	// Do not return payload in response
 	cred.Payload = ""
-	respond(w, http.StatusCreated, cred)
+	respondCreated(w, "/api/v1/credentials", cred.ID, cred)
+}
+
+// UpdateCredential handles PUT /api/v1/credentials/{id}, which rotates a
+// credential in place.
+func (h *ProvisionHandler) UpdateCredential(w http.ResponseWriter, r *http.Request) {
+	var req provision.CredentialRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	cred, err := h.svc.UpdateCredential(r.Context(), r.PathValue("id"), &req)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	cred.Payload = ""
+	respond(w, http.StatusOK, cred)
 }
 
 // ListCredentials handles GET /api/v1/credentials
 func (h *ProvisionHandler) ListCredentials(w http.ResponseWriter, r *http.Request) {
 	creds, err := h.svc.ListCredentials(r.Context())
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	// Scrub payloads
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/aven/ngoogle/internal/master/service"
+)
+
+// TaskConfigHandler serves task configuration import and export.
+type TaskConfigHandler struct {
+	svc *service.TaskConfigService
+}
+
+// NewTaskConfigHandler creates a new TaskConfigHandler.
+func NewTaskConfigHandler(svc *service.TaskConfigService) *TaskConfigHandler {
+	return &TaskConfigHandler{svc: svc}
+}
+
+// Router registers the import and export routes.
+func (h *TaskConfigHandler) Router(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/tasks/import", h.Import)
+	mux.HandleFunc("GET /api/v1/tasks/export", h.Export)
+}
+
+// Import handles POST /api/v1/tasks/import. The body is a YAML or JSON
+// document; dry_run=true reports the changes without applying them.
+func (h *TaskConfigHandler) Import(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, configOf(r).maxBodyBytes()))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	doc, err := service.ParseTaskDocument(data)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	res, err := h.svc.Import(r.Context(), doc, r.URL.Query().Get("dry_run") == "true")
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, res)
+}
+
+// Export handles GET /api/v1/tasks/export?format=json|yaml.
+func (h *TaskConfigHandler) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "yaml" {
+		respondErr(w, http.StatusBadRequest, "format must be json or yaml")
+		return
+	}
+	doc, err := h.svc.Export(r.Context())
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if format != "yaml" {
+		respond(w, http.StatusOK, doc)
+		return
+	}
+	data, err := service.MarshalTaskDocumentYAML(doc)
+	if err != nil {
+		respondErr(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
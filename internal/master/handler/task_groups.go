@@ -27,22 +27,22 @@ func (h *TaskGroupHandler) Router(mux *http.ServeMux) {
 
 func (h *TaskGroupHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req service.CreateTaskGroupRequest
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	group, err := h.svc.Create(r.Context(), &req)
 	if err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
-	respond(w, http.StatusCreated, group)
+	respondCreated(w, "/api/v1/task-groups", group.ID, group)
 }
 
 func (h *TaskGroupHandler) List(w http.ResponseWriter, r *http.Request) {
 	groups, err := h.svc.List(r.Context())
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if groups == nil {
@@ -54,7 +54,7 @@ func (h *TaskGroupHandler) List(w http.ResponseWriter, r *http.Request) {
 func (h *TaskGroupHandler) Get(w http.ResponseWriter, r *http.Request) {
 	group, err := h.svc.Get(r.Context(), r.PathValue("id"))
 	if err != nil {
-		respondErr(w, http.StatusNotFound, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, group)
@@ -62,7 +62,7 @@ func (h *TaskGroupHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 func (h *TaskGroupHandler) Dispatch(w http.ResponseWriter, r *http.Request) {
 	if err := h.svc.Dispatch(r.Context(), r.PathValue("id")); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "dispatched"})
@@ -70,19 +70,21 @@ func (h *TaskGroupHandler) Dispatch(w http.ResponseWriter, r *http.Request) {
 
 func (h *TaskGroupHandler) Stop(w http.ResponseWriter, r *http.Request) {
 	if err := h.svc.Stop(r.Context(), r.PathValue("id")); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "stopped"})
 }
 
 func (h *TaskGroupHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	from := parseTime(q.Get("from"), time.Now().Add(-1*time.Hour))
-	to := parseTime(q.Get("to"), time.Now())
+	from, to, err := parseRange(r.URL.Query(), time.Hour)
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	metrics, err := h.svc.GetMetrics(r.Context(), r.PathValue("id"), from, to)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if metrics == nil {
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// Task stream protocol
+//
+// GET /api/v1/agents/{agent_id}/tasks/stream is a Server-Sent Events stream
+// through which the master pushes an agent's task list. Every message is a
+// "tasks" event whose data is the JSON array PullTasks returns and whose id
+// is the list's X-Tasks-Version:
+//
+//	event: tasks
+//	id: <version>
+//	data: [{"id":"...","status":"running",...}]
+//
+// A message is sent when the stream opens, whenever the list changes, and at
+// least every service.TaskStreamRefresh otherwise, which also keeps the
+// connection alive. Each message is the complete list, so the agent runs
+// exactly the tasks it names: a task that disappears from it is stopped, and
+// one whose status is paused is paused. Agents treat a stream silent for
+// several refresh periods as broken, and poll while they reconnect.
+
+// streamWriteTimeout bounds each write to a task stream, replacing the
+// server's write timeout that would otherwise end the stream.
+const streamWriteTimeout = 10 * time.Second
+
+// StreamTasks handles GET /api/v1/agents/{agent_id}/tasks/stream
+func (h *TaskHandler) StreamTasks(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	started := false
+	err := h.svc.WatchTasks(r.Context(), r.PathValue("agent_id"), func(version string, tasks []*model.Task) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		// Without write deadline support the server's write timeout ends
+		// the stream, and the agent reconnects.
+		_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := writeTasksEvent(w, version, tasks); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err != nil && !started {
+		respondErr(w, statusFor(err), err.Error())
+	}
+}
+
+func writeTasksEvent(w io.Writer, version string, tasks []*model.Task) error {
+	if tasks == nil {
+		tasks = []*model.Task{}
+	}
+	data, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: tasks\nid: %s\ndata: %s\n\n", version, data)
+	return err
+}
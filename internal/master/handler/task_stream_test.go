@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+	"github.com/aven/ngoogle/pkg/masterclient"
+)
+
+func TestStreamTasksPushesChanges(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", AgentID: "a1", Type: model.TaskTypeYoutube, TargetURL: "https://youtu.be/example",
+		Status: model.TaskStatusPending, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewTaskService(st)
+	mux := http.NewServeMux()
+	NewTaskHandler(svc, service.NewAgentService(st)).Router(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	lists := make(chan []*model.Task, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- masterclient.New(masterclient.WithBaseURL(srv.URL)).StreamTasks(ctx, "a1", time.Minute, func(tasks []*model.Task) {
+			lists <- tasks
+		})
+	}()
+	next := func() []*model.Task {
+		t.Helper()
+		select {
+		case l := <-lists:
+			return l
+		case <-time.After(5 * time.Second):
+			t.Fatal("no task list pushed")
+			return nil
+		}
+	}
+
+	if l := next(); len(l) != 0 {
+		t.Fatalf("initial list = %d tasks, want 0", len(l))
+	}
+	if err := svc.Dispatch(ctx, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if l := next(); len(l) != 1 || l[0].ID != "t1" {
+		t.Fatalf("after dispatch got %+v, want t1", l)
+	}
+	if err := svc.Stop(ctx, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if l := next(); len(l) != 0 {
+		t.Fatalf("after stop got %d tasks, want 0", len(l))
+	}
+
+	svc.Shutdown()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("StreamTasks returned nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed on shutdown")
+	}
+}
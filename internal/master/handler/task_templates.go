@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+)
+
+type TaskTemplateHandler struct {
+	svc *service.TaskTemplateService
+}
+
+func NewTaskTemplateHandler(svc *service.TaskTemplateService) *TaskTemplateHandler {
+	return &TaskTemplateHandler{svc: svc}
+}
+
+// Router registers the template routes. Instantiation takes the template id
+// as a query parameter: a /tasks/from-template/{id} pattern would overlap
+// the /tasks/{id}/<action> routes.
+func (h *TaskTemplateHandler) Router(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/task-templates", h.Create)
+	mux.HandleFunc("GET /api/v1/task-templates", h.List)
+	mux.HandleFunc("GET /api/v1/task-templates/{id}", h.Get)
+	mux.HandleFunc("PUT /api/v1/task-templates/{id}", h.Update)
+	mux.HandleFunc("DELETE /api/v1/task-templates/{id}", h.Delete)
+	mux.HandleFunc("POST /api/v1/tasks/from-template", h.Instantiate)
+}
+
+func (h *TaskTemplateHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req service.TaskTemplateRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	t, err := h.svc.Create(r.Context(), &req)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respondCreated(w, "/api/v1/task-templates", t.ID, t)
+}
+
+func (h *TaskTemplateHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.List(r.Context())
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if list == nil {
+		list = []*model.TaskTemplate{}
+	}
+	respond(w, http.StatusOK, list)
+}
+
+func (h *TaskTemplateHandler) Get(w http.ResponseWriter, r *http.Request) {
+	t, err := h.svc.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, t)
+}
+
+func (h *TaskTemplateHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req service.TaskTemplateRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	t, err := h.svc.Update(r.Context(), r.PathValue("id"), &req)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, t)
+}
+
+func (h *TaskTemplateHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Delete(r.Context(), r.PathValue("id")); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// Instantiate handles POST /api/v1/tasks/from-template?template_id=ID. The
+// optional body holds task fields that override the template's.
+func (h *TaskTemplateHandler) Instantiate(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("template_id")
+	if id == "" {
+		respondErr(w, http.StatusBadRequest, "template_id is required")
+		return
+	}
+	var overrides json.RawMessage
+	if r.ContentLength != 0 {
+		if err := decode(w, r, &overrides); err != nil {
+			respondErr(w, statusFor(err), err.Error())
+			return
+		}
+	}
+	task, err := h.svc.Instantiate(r.Context(), id, overrides)
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respondCreated(w, "/api/v1/tasks", task.ID, task)
+}
@@ -1,69 +1,87 @@
 package handler
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/master/spec"
 	"github.com/aven/ngoogle/internal/model"
 )
 
 // TaskHandler handles task-related endpoints.
 type TaskHandler struct {
-	svc *service.TaskService
+	svc    *service.TaskService
+	agents *service.AgentService // authenticates agent reports
 }
 
 // NewTaskHandler creates a new TaskHandler.
-func NewTaskHandler(svc *service.TaskService) *TaskHandler {
-	return &TaskHandler{svc: svc}
+func NewTaskHandler(svc *service.TaskService, agents *service.AgentService) *TaskHandler {
+	return &TaskHandler{svc: svc, agents: agents}
 }
 
 // Router registers all task routes.
 func (h *TaskHandler) Router(mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/v1/tasks", h.Create)
 	mux.HandleFunc("GET /api/v1/tasks", h.List)
+	mux.HandleFunc("GET /api/v1/tasks/compare", h.Compare)
 	mux.HandleFunc("GET /api/v1/tasks/{id}", h.Get)
 	mux.HandleFunc("POST /api/v1/tasks/{id}/dispatch", h.Dispatch)
 	mux.HandleFunc("POST /api/v1/tasks/{id}/stop", h.Stop)
+	mux.HandleFunc("POST /api/v1/tasks/{id}/pause", h.Pause)
+	mux.HandleFunc("POST /api/v1/tasks/{id}/resume", h.Resume)
 	mux.HandleFunc("POST /api/v1/tasks/{id}/run", h.MarkRunning)
 	mux.HandleFunc("POST /api/v1/tasks/{id}/done", h.MarkDone)
 	mux.HandleFunc("POST /api/v1/tasks/{id}/fail", h.MarkFailed)
 	mux.HandleFunc("POST /api/v1/tasks/{id}/metrics", h.ReportMetrics)
 	mux.HandleFunc("GET /api/v1/tasks/{id}/metrics", h.GetMetrics)
+	mux.HandleFunc("GET /api/v1/tasks/{id}/summary", h.Summary)
+	mux.HandleFunc("GET /api/v1/tasks/{id}/result", h.Result)
+	mux.HandleFunc("POST /api/v1/tasks/{id}/result", h.ReportResult)
 	mux.HandleFunc("GET /api/v1/agents/{agent_id}/tasks/pull", h.PullTasks)
+	mux.HandleFunc("GET /api/v1/agents/{agent_id}/tasks/stream", h.StreamTasks)
+	mux.HandleFunc("POST /api/v1/agents/{id}/stop-tasks", h.StopAgentTasks)
 }
 
 // Create handles POST /api/v1/tasks
 func (h *TaskHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var req service.CreateTaskRequest
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	task, err := h.svc.Create(r.Context(), &req)
 	if err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
-	respond(w, http.StatusCreated, task)
+	respondCreated(w, "/api/v1/tasks", task.ID, task)
 }
 
 // List handles GET /api/v1/tasks
 func (h *TaskHandler) List(w http.ResponseWriter, r *http.Request) {
 	tasks, err := h.svc.List(r.Context())
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, tasks)
 }
 
-// Get handles GET /api/v1/tasks/{id}
+// Get handles GET /api/v1/tasks/{id}, answering 304 Not Modified when the
+// request's If-None-Match names the task's current ETag.
 func (h *TaskHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	task, err := h.svc.Get(r.Context(), id)
 	if err != nil {
-		respondErr(w, http.StatusNotFound, err.Error())
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if notModified(w, r, taskETag(task)) {
 		return
 	}
 	respond(w, http.StatusOK, task)
@@ -73,7 +91,7 @@ func (h *TaskHandler) Get(w http.ResponseWriter, r *http.Request) {
 func (h *TaskHandler) Dispatch(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if err := h.svc.Dispatch(r.Context(), id); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "dispatched"})
@@ -83,17 +101,35 @@ func (h *TaskHandler) Dispatch(w http.ResponseWriter, r *http.Request) {
 func (h *TaskHandler) Stop(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if err := h.svc.Stop(r.Context(), id); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "stopped"})
 }
 
+// Pause handles POST /api/v1/tasks/{id}/pause
+func (h *TaskHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Pause(r.Context(), r.PathValue("id")); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "paused"})
+}
+
+// Resume handles POST /api/v1/tasks/{id}/resume
+func (h *TaskHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Resume(r.Context(), r.PathValue("id")); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "resumed"})
+}
+
 // MarkRunning handles POST /api/v1/tasks/{id}/run
 func (h *TaskHandler) MarkRunning(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if err := h.svc.MarkRunning(r.Context(), id); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "running"})
@@ -103,7 +139,7 @@ func (h *TaskHandler) MarkRunning(w http.ResponseWriter, r *http.Request) {
 func (h *TaskHandler) MarkDone(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if err := h.svc.MarkDone(r.Context(), id); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "done"})
@@ -112,73 +148,163 @@ func (h *TaskHandler) MarkDone(w http.ResponseWriter, r *http.Request) {
 // MarkFailed handles POST /api/v1/tasks/{id}/fail
 func (h *TaskHandler) MarkFailed(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	var req struct {
-		Reason string `json:"reason"`
-	}
+	var req spec.FailRequest
 	if r.ContentLength > 0 {
-		if err := decode(r, &req); err != nil {
-			respondErr(w, http.StatusBadRequest, err.Error())
+		if err := decodeLenient(w, r, &req); err != nil {
+			respondErr(w, statusFor(err), err.Error())
 			return
 		}
 	}
 	if err := h.svc.MarkFailed(r.Context(), id, req.Reason); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "failed"})
 }
 
 // ReportMetrics handles POST /api/v1/tasks/{id}/metrics
+//
+// The reporting agent authenticates with its token as a bearer token, and
+// the report must name that agent.
 func (h *TaskHandler) ReportMetrics(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	var m model.TaskMetrics
-	if err := decode(r, &m); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	if err := decodeLenient(w, r, &m); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if !h.authAgent(w, r, m.AgentID) {
 		return
 	}
 	m.TaskID = id
 	if err := h.svc.RecordMetrics(r.Context(), &m); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		var throttled *service.ThrottledError
+		if errors.As(err, &throttled) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		}
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// ReportResult handles POST /api/v1/tasks/{id}/result
+//
+// Like ReportMetrics, it requires the reporting agent's token.
+func (h *TaskHandler) ReportResult(w http.ResponseWriter, r *http.Request) {
+	var res model.AgentTaskResult
+	if err := decodeLenient(w, r, &res); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	if !h.authAgent(w, r, res.AgentID) {
+		return
+	}
+	res.TaskID = r.PathValue("id")
+	if err := h.svc.RecordAgentResult(r.Context(), &res); err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// authAgent checks that the request's bearer token is agentID's, and
+// writes 401 Unauthorized and reports false if not.
+func (h *TaskHandler) authAgent(w http.ResponseWriter, r *http.Request, agentID string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || agentID == "" || h.agents.ValidateToken(r.Context(), agentID, token) != nil {
+		respondErr(w, http.StatusUnauthorized, "invalid agent token")
+		return false
+	}
+	return true
+}
+
 // GetMetrics handles GET /api/v1/tasks/{id}/metrics
 func (h *TaskHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	q := r.URL.Query()
-	from := parseTime(q.Get("from"), time.Now().Add(-1*time.Hour))
-	to := parseTime(q.Get("to"), time.Now())
+	from, to, err := parseRange(q, time.Hour)
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	metrics, err := h.svc.GetMetrics(r.Context(), id, from, to)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, metrics)
 }
 
+// Summary handles GET /api/v1/tasks/{id}/summary
+func (h *TaskHandler) Summary(w http.ResponseWriter, r *http.Request) {
+	sum, err := h.svc.Summary(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, sum)
+}
+
+// Result handles GET /api/v1/tasks/{id}/result
+func (h *TaskHandler) Result(w http.ResponseWriter, r *http.Request) {
+	res, err := h.svc.Result(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, res)
+}
+
+// Compare handles GET /api/v1/tasks/compare?a=ID&b=ID
+func (h *TaskHandler) Compare(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cmp, err := h.svc.Compare(r.Context(), q.Get("a"), q.Get("b"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, cmp)
+}
+
+// StopAgentTasks handles POST /api/v1/agents/{id}/stop-tasks
+func (h *TaskHandler) StopAgentTasks(w http.ResponseWriter, r *http.Request) {
+	n, err := h.svc.StopByAgent(r.Context(), r.PathValue("id"))
+	if err != nil {
+		respondErr(w, statusFor(err), err.Error())
+		return
+	}
+	respond(w, http.StatusOK, spec.StoppedResponse{Stopped: n})
+}
+
 // PullTasks handles GET /api/v1/agents/{agent_id}/tasks/pull
+//
+// With wait, the pull is a long poll: it is held open until the agent's task
+// list changes from the version given in since, or until wait (at most
+// service.MaxTasksWait) elapses. The version of the returned list is sent in
+// X-Tasks-Version.
 func (h *TaskHandler) PullTasks(w http.ResponseWriter, r *http.Request) {
 	agentID := r.PathValue("agent_id")
+	q := r.URL.Query()
+	if s := q.Get("wait"); s != "" {
+		wait, err := parseDuration(s)
+		if err != nil {
+			respondErr(w, http.StatusBadRequest, "wait: "+err.Error())
+			return
+		}
+		h.svc.WaitForTasks(r.Context(), agentID, q.Get("since"), wait)
+	}
+	// Read the version before the list so that a change in between shows up
+	// as a new version on the next pull.
+	version := h.svc.TasksVersion(agentID)
 	tasks, err := h.svc.PullTasks(r.Context(), agentID)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if tasks == nil {
 		tasks = []*model.Task{}
 	}
+	w.Header().Set("X-Tasks-Version", version)
 	respond(w, http.StatusOK, tasks)
 }
-
-func parseTime(s string, def time.Time) time.Time {
-	if s == "" {
-		return def
-	}
-	t, err := time.Parse(time.RFC3339, s)
-	if err != nil {
-		return def
-	}
-	return t
-}
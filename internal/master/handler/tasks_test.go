@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestAgentReportsRequireToken(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	agents := service.NewAgentService(st)
+	owner, err := agents.Register(ctx, &service.RegisterRequest{Hostname: "h1", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := agents.Register(ctx, &service.RegisterRequest{Hostname: "h2", IP: "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", AgentID: owner.ID, Type: model.TaskTypeYoutube, TargetURL: "https://youtu.be/example",
+		Status: model.TaskStatusRunning, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewTaskHandler(service.NewTaskService(st), agents).Router(mux)
+	post := func(path, token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	metrics := `{"agent_id":"` + owner.ID + `","bytes_total":100}`
+	result := `{"agent_id":"` + owner.ID + `","stop_reason":"completed"}`
+
+	for _, tc := range []struct {
+		name, token string
+	}{
+		{"no token", ""},
+		{"forged token", "forged"},
+		// Another agent's valid token cannot report for the task's agent.
+		{"other agent's token", other.Token},
+	} {
+		if code := post("/api/v1/tasks/t1/metrics", tc.token, metrics); code != http.StatusUnauthorized {
+			t.Errorf("metrics with %s: status %d, want 401", tc.name, code)
+		}
+		if code := post("/api/v1/tasks/t1/result", tc.token, result); code != http.StatusUnauthorized {
+			t.Errorf("result with %s: status %d, want 401", tc.name, code)
+		}
+	}
+	if code := post("/api/v1/tasks/t1/metrics", owner.Token, metrics); code != http.StatusOK {
+		t.Fatalf("metrics with the agent's token: status %d", code)
+	}
+	if code := post("/api/v1/tasks/t1/result", owner.Token, result); code != http.StatusOK {
+		t.Fatalf("result with the agent's token: status %d", code)
+	}
+}
+
+func TestThrottledMetricsGet429(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	agents := service.NewAgentService(st)
+	agent, err := agents.Register(ctx, &service.RegisterRequest{Hostname: "h1", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", AgentID: agent.ID, Type: model.TaskTypeYoutube, TargetURL: "https://youtu.be/example",
+		Status: model.TaskStatusRunning, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewTaskHandler(service.NewTaskService(st), agents).Router(mux)
+	report := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks/t1/metrics", strings.NewReader(`{"agent_id":"`+agent.ID+`","bytes_total":100}`))
+		req.Header.Set("Authorization", "Bearer "+agent.Token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := report(); rec.Code != http.StatusOK {
+		t.Fatalf("first report: status %d: %s", rec.Code, rec.Body)
+	}
+	rec := report()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second report: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want 2 (the default minimum interval)", got)
+	}
+}
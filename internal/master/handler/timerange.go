@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// parseDuration parses a positive duration in time.ParseDuration syntax
+// ("90s", "15m", "1h30m") or a plain number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q: must be positive", s)
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: use seconds or a duration such as 90s, 15m or 24h", s)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q: must be positive", s)
+	}
+	return d, nil
+}
+
+// parseRange reads a time range from the from, to and last query parameters.
+// from and to are RFC3339 timestamps; last is a duration counting back from
+// to (or now), and cannot be combined with from. When neither from nor last
+// is given the range covers def before to; a zero def leaves unset bounds
+// zero for the caller to fill in.
+func parseRange(q url.Values, def time.Duration) (from, to time.Time, err error) {
+	if s := q.Get("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			return from, to, fmt.Errorf("invalid to %q: use an RFC3339 timestamp", s)
+		}
+	}
+	span := def
+	if s := q.Get("last"); s != "" {
+		if q.Get("from") != "" {
+			return from, to, fmt.Errorf("use either from or last, not both")
+		}
+		if span, err = parseDuration(s); err != nil {
+			return from, to, fmt.Errorf("last: %w", err)
+		}
+	} else if s := q.Get("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			return from, to, fmt.Errorf("invalid from %q: use an RFC3339 timestamp", s)
+		}
+		span = 0
+	}
+	if span > 0 {
+		if to.IsZero() {
+			to = time.Now()
+		}
+		from = to.Add(-span)
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return from, to, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"90", 90 * time.Second, true},
+		{"15m", 15 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"0", 0, false},
+		{"-5m", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tc := range cases {
+		got, err := parseDuration(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v, ok=%v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	end := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	q := url.Values{"to": {end.Format(time.RFC3339)}, "last": {"24h"}}
+	from, to, err := parseRange(q, time.Hour)
+	if err != nil || !to.Equal(end) || !from.Equal(end.Add(-24*time.Hour)) {
+		t.Fatalf("last=24h: got %v..%v, %v", from, to, err)
+	}
+
+	from, to, err = parseRange(url.Values{"to": {end.Format(time.RFC3339)}}, time.Hour)
+	if err != nil || !from.Equal(end.Add(-time.Hour)) {
+		t.Fatalf("default span: got %v..%v, %v", from, to, err)
+	}
+
+	from, to, err = parseRange(url.Values{}, 0)
+	if err != nil || !from.IsZero() || !to.IsZero() {
+		t.Fatalf("zero default: got %v..%v, %v", from, to, err)
+	}
+
+	for _, bad := range []url.Values{
+		{"from": {"yesterday"}},
+		{"to": {"2026-03-01"}},
+		{"last": {"1d"}},
+		{"from": {end.Format(time.RFC3339)}, "last": {"1h"}},
+		{"from": {end.Format(time.RFC3339)}, "to": {end.Add(-time.Hour).Format(time.RFC3339)}},
+	} {
+		if _, _, err := parseRange(bad, time.Hour); err == nil {
+			t.Errorf("parseRange(%v) succeeded, want error", bad)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aven/ngoogle/internal/master/spec"
 	"github.com/aven/ngoogle/internal/model"
 	"github.com/aven/ngoogle/internal/store"
 )
@@ -31,14 +32,9 @@ func (h *URLPoolHandler) Router(mux *http.ServeMux) {
 }
 
 func (h *URLPoolHandler) Create(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name        string            `json:"name"`
-		Type        model.URLPoolType `json:"type"`
-		Description string            `json:"description"`
-		URLs        []string          `json:"urls"`
-	}
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	var req spec.URLPoolRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if req.Name == "" {
@@ -67,16 +63,16 @@ func (h *URLPoolHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := h.store.URLPools().Create(r.Context(), p); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
-	respond(w, http.StatusCreated, p)
+	respondCreated(w, "/api/v1/url-pools", p.ID, p)
 }
 
 func (h *URLPoolHandler) List(w http.ResponseWriter, r *http.Request) {
 	pools, err := h.store.URLPools().List(r.Context())
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, pools)
@@ -85,7 +81,7 @@ func (h *URLPoolHandler) List(w http.ResponseWriter, r *http.Request) {
 func (h *URLPoolHandler) Get(w http.ResponseWriter, r *http.Request) {
 	pool, err := h.store.URLPools().Get(r.Context(), r.PathValue("id"))
 	if err != nil {
-		respondErr(w, http.StatusNotFound, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, pool)
@@ -95,18 +91,13 @@ func (h *URLPoolHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	existing, err := h.store.URLPools().Get(r.Context(), id)
 	if err != nil {
-		respondErr(w, http.StatusNotFound, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 
-	var req struct {
-		Name        string            `json:"name"`
-		Type        model.URLPoolType `json:"type"`
-		Description string            `json:"description"`
-		URLs        []string          `json:"urls"`
-	}
-	if err := decode(r, &req); err != nil {
-		respondErr(w, http.StatusBadRequest, err.Error())
+	var req spec.URLPoolRequest
+	if err := decode(w, r, &req); err != nil {
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if req.Name == "" {
@@ -120,11 +111,11 @@ func (h *URLPoolHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	referenced, err := h.isPoolReferenced(r.Context(), id)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if referenced && req.Type != existing.Type {
-		respondErr(w, http.StatusBadRequest, "cannot change pool type while it is referenced by tasks")
+		respondErr(w, http.StatusConflict, "cannot change pool type while it is referenced by tasks")
 		return
 	}
 
@@ -142,7 +133,7 @@ func (h *URLPoolHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := h.store.URLPools().Update(r.Context(), existing); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, existing)
@@ -152,15 +143,15 @@ func (h *URLPoolHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	referenced, err := h.isPoolReferenced(r.Context(), id)
 	if err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	if referenced {
-		respondErr(w, http.StatusBadRequest, "url pool is still referenced by tasks")
+		respondErr(w, http.StatusConflict, "url pool is still referenced by tasks")
 		return
 	}
 	if err := h.store.URLPools().Delete(r.Context(), id); err != nil {
-		respondErr(w, http.StatusInternalServerError, err.Error())
+		respondErr(w, statusFor(err), err.Error())
 		return
 	}
 	respond(w, http.StatusOK, map[string]string{"status": "deleted"})
@@ -0,0 +1,22 @@
+package provision
+
+import (
+	"errors"
+
+	"github.com/aven/ngoogle/internal/errkind"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// Error kinds returned by Service. Callers test for them with errors.Is; the
+// error text itself stays human readable.
+var (
+	ErrInvalidRequest = errors.New("invalid provision request")
+	ErrNotFound       = store.ErrNotFound
+	ErrConflict       = store.ErrConflict
+	ErrRemote         = errors.New("remote host error")
+)
+
+// errorf formats an error like fmt.Errorf and tags it with kind.
+func errorf(kind error, format string, args ...any) error {
+	return errkind.Errorf(kind, format, args...)
+}
@@ -0,0 +1,68 @@
+package provision
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// supportedKeyFormats names the private key formats parsePrivateKey accepts,
+// for error messages.
+const supportedKeyFormats = "OpenSSH (BEGIN OPENSSH PRIVATE KEY; ed25519, RSA or ECDSA), " +
+	"PKCS#1 RSA (BEGIN RSA PRIVATE KEY), SEC1 EC (BEGIN EC PRIVATE KEY) and PKCS#8 (BEGIN PRIVATE KEY)"
+
+// parsePrivateKey parses an unencrypted SSH private key. Keys in formats
+// the SSH library cannot read, such as PuTTY's .ppk, get an error saying how
+// to convert them. Errors never contain key material.
+func parsePrivateKey(payload string) (ssh.Signer, error) {
+	key := strings.TrimSpace(strings.ReplaceAll(payload, "\r\n", "\n"))
+	switch {
+	case key == "":
+		return nil, errors.New("private key is empty")
+	case strings.HasPrefix(key, "PuTTY-User-Key-File-"):
+		return nil, errors.New("PuTTY .ppk keys are not supported; convert the key with " +
+			"`puttygen key.ppk -O private-openssh-new -o key` and use the result")
+	case strings.HasPrefix(key, "---- BEGIN SSH2 ENCRYPTED PRIVATE KEY ----"):
+		return nil, errors.New("SSH2 (ssh.com) private keys are not supported; import the key into puttygen " +
+			"and export it with `-O private-openssh-new`")
+	case strings.HasPrefix(key, "ssh-"), strings.HasPrefix(key, "ecdsa-"),
+		strings.HasPrefix(key, "---- BEGIN SSH2 PUBLIC KEY ----"), strings.Contains(key, "BEGIN PUBLIC KEY"):
+		return nil, errors.New("this is a public key; give the private key instead")
+	}
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded; supported formats: %s", supportedKeyFormats)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing):
+		return nil, errors.New("private key is protected by a passphrase; remove it with `ssh-keygen -p -N '' -f key`")
+	case err != nil:
+		return nil, fmt.Errorf("cannot parse %s key (%v); supported formats: %s", block.Type, err, supportedKeyFormats)
+	}
+	return signer, nil
+}
+
+// validateCredential checks a credential's type and payload before it is
+// stored, so that a bad key fails now rather than at a job's ssh_check.
+func validateCredential(typ model.AuthType, payload string) error {
+	switch typ {
+	case model.AuthTypeKey:
+		if _, err := parsePrivateKey(payload); err != nil {
+			return errorf(ErrInvalidRequest, "payload: %w", err)
+		}
+	case model.AuthTypePassword:
+		if payload == "" {
+			return errorf(ErrInvalidRequest, "payload is required")
+		}
+	default:
+		return errorf(ErrInvalidRequest, "unknown credential type %q", typ)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package provision
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// samplePPK is a PuTTY key file with made-up contents; parsePrivateKey
+// rejects it from the header alone.
+const samplePPK = `PuTTY-User-Key-File-3: ssh-ed25519
+Encryption: none
+Comment: ed25519-key-20240101
+Public-Lines: 2
+AAAAC3NzaC1lZDI1NTE5AAAAIBbr1pHkXO3qX2i8sA0rCn8mR3oQf2eDkzWmJ4zz
+Private-Lines: 1
+AAAAIGV4YW1wbGUtb25seS1ub3QtYS1yZWFsLWtleS1tYXRlcmlhbA==
+Private-MAC: 6f0c2a3e0b2f6d8a9c1e4b7d5f3a2c1e0d9b8a7f6e5d4c3b2a1f0e9d8c7b6a5f
+`
+
+func TestParsePrivateKeyFormats(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openssh := func(k crypto.PrivateKey) string {
+		block, err := ssh.MarshalPrivateKey(k, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(block))
+	}
+	encode := func(typ string, der []byte) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+	}
+	edPKCS8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSEC1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(edKey, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		keyType string // signer type when the key parses
+		wantErr string // error substring otherwise
+	}{
+		{name: "openssh ed25519", payload: openssh(edKey), keyType: ssh.KeyAlgoED25519},
+		{name: "openssh rsa", payload: openssh(rsaKey), keyType: ssh.KeyAlgoRSA},
+		{name: "openssh ecdsa", payload: openssh(ecKey), keyType: ssh.KeyAlgoECDSA256},
+		{name: "pkcs1 rsa", payload: encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), keyType: ssh.KeyAlgoRSA},
+		{name: "sec1 ecdsa", payload: encode("EC PRIVATE KEY", ecSEC1), keyType: ssh.KeyAlgoECDSA256},
+		{name: "pkcs8 ed25519", payload: encode("PRIVATE KEY", edPKCS8), keyType: ssh.KeyAlgoED25519},
+		{name: "crlf and padding", payload: "\n  " + strings.ReplaceAll(openssh(edKey), "\n", "\r\n"), keyType: ssh.KeyAlgoED25519},
+		{name: "ppk", payload: samplePPK, wantErr: "puttygen"},
+		{name: "public key", payload: string(ssh.MarshalAuthorizedKey(edSigner.PublicKey())), wantErr: "public key"},
+		{name: "passphrase", payload: string(pem.EncodeToMemory(encrypted)), wantErr: "passphrase"},
+		{name: "not pem", payload: "hunter2", wantErr: "supported formats"},
+		{name: "empty", payload: " \n", wantErr: "empty"},
+		{name: "bad body", payload: encode("OPENSSH PRIVATE KEY", []byte("junk")), wantErr: "supported formats"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := parsePrivateKey(tc.payload)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := signer.PublicKey().Type(); got != tc.keyType {
+				t.Fatalf("key type = %s, want %s", got, tc.keyType)
+			}
+		})
+	}
+}
+
+func TestCreateCredentialValidatesPayload(t *testing.T) {
+	svc, st := newTestService(t)
+	ctx := context.Background()
+	for _, req := range []CredentialRequest{
+		{Name: "ppk", Type: model.AuthTypeKey, Payload: samplePPK},
+		{Name: "empty", Type: model.AuthTypePassword},
+		{Name: "unknown", Type: "token", Payload: "x"},
+	} {
+		if _, err := svc.CreateCredential(ctx, &req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: err = %v, want ErrInvalidRequest", req.Name, err)
+		}
+	}
+	if list, err := st.Credentials().List(ctx); err != nil || len(list) != 0 {
+		t.Fatalf("invalid credentials stored: %d, %v", len(list), err)
+	}
+	if _, err := svc.CreateCredential(ctx, &CredentialRequest{Name: "ed", Type: model.AuthTypeKey, Payload: testKeyPEM(t)}); err != nil {
+		t.Fatalf("ed25519 key: %v", err)
+	}
+}
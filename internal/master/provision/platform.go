@@ -0,0 +1,499 @@
+package provision
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// platform is how the agent is installed as a service on one operating
+// system: how commands run there, where the agent's files go, how the
+// service is defined and how the service manager starts and removes it.
+//
+// Platforms hand out scripts rather than command lines; shell and elevate
+// turn them into commands. Only the first line of an install script is
+// logged, so secrets such as the token never come first.
+type platform interface {
+	// goos is the Go GOOS name, substituted for {os} in download URLs.
+	goos() string
+	// manager names the service manager in job logs.
+	manager() string
+	// validate rejects install settings the platform cannot honor.
+	validate(r *JobRequest) error
+	// shell returns the command running script as the SSH user.
+	shell(script string) string
+	// elevate returns the command running script with administrator
+	// rights, and its stdin. sudo is the job's wrapper for running as root.
+	elevate(script string, sudo func(string) (string, string)) (string, string)
+	// downloadScript fetches the agent binary at url to where the first
+	// install script picks it up.
+	downloadScript(r *JobRequest, url string) string
+	// runtimeScript ensures python3, yt-dlp and node are installed.
+	runtimeScript() string
+	// installScripts put the binary, the env file holding the token and the
+	// service definition in place, then (re)start the service.
+	installScripts(r *JobRequest, masterURL string) []string
+	// removeScript stops the service and deletes its files.
+	removeScript(r *JobRequest) string
+}
+
+// mapOS converts uname -s output to the platform agents are installed on.
+// Windows hosts only have uname through MSYS2 or Cygwin, whose unames name
+// themselves after the Windows version.
+func mapOS(uname string) (platform, error) {
+	switch {
+	case uname == "Linux":
+		return systemdPlatform{}, nil
+	case uname == "FreeBSD":
+		return rcdPlatform{}, nil
+	case uname == "Darwin":
+		return launchdPlatform{}, nil
+	case strings.HasPrefix(uname, "MINGW"), strings.HasPrefix(uname, "MSYS_NT"), strings.HasPrefix(uname, "CYGWIN_NT"):
+		return windowsPlatform{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported operating system: %s", uname)
+	}
+}
+
+// parseUname splits the output of uname -sm into a platform and the GOARCH
+// of the agent binary to download.
+func parseUname(out string) (platform, string, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return nil, "", fmt.Errorf("unexpected uname output %q", strings.TrimSpace(out))
+	}
+	p, err := mapOS(fields[0])
+	if err != nil {
+		return nil, "", err
+	}
+	goArch, err := mapArch(fields[1])
+	if err != nil {
+		return nil, "", err
+	}
+	return p, goArch, nil
+}
+
+// ─── Unix ─────────────────────────────────────────────────────────────────────
+
+// posix is what the Unix platforms share: commands run in sh, as root
+// through sudo, and the binary is fetched with wget or curl.
+type posix struct{}
+
+func (posix) validate(*JobRequest) error { return nil }
+
+func (posix) shell(script string) string { return script }
+
+func (posix) elevate(script string, sudo func(string) (string, string)) (string, string) {
+	return sudo(script)
+}
+
+func (posix) downloadScript(r *JobRequest, url string) string {
+	tmp := posixStagingPath(r)
+	return fmt.Sprintf("wget -q -O %s %s || curl -fsSL -o %s %s", tmp, shellQuote(url), tmp, shellQuote(url))
+}
+
+// posixStagingPath is where the agent binary is downloaded before it is
+// installed.
+func posixStagingPath(r *JobRequest) string { return "/tmp/" + r.ServiceName }
+
+// posixService is how a Unix platform defines the agent's service, which
+// posixInstallScripts installs.
+type posixService interface {
+	// envFilePath is the root-only file holding the agent token.
+	envFilePath(r *JobRequest) string
+	servicePath(r *JobRequest) string
+	// render returns the service definition and the env file contents.
+	render(r *JobRequest, masterURL string) (service, env string)
+	// startCommand enables the service at boot and (re)starts it.
+	startCommand(r *JobRequest) string
+}
+
+// posixInstallScripts installs the downloaded binary and p's env file and
+// service definition with sh, writing files through heredocs.
+func posixInstallScripts(p posixService, r *JobRequest, masterURL string) []string {
+	binPath := r.binaryPath()
+	envFile := p.envFilePath(r)
+	service, env := p.render(r, masterURL)
+	return []string{
+		fmt.Sprintf("mkdir -p %s && mv %s %s && chmod +x %s", r.InstallDir, posixStagingPath(r), binPath, binPath),
+		// The token lives outside the world-readable service file.
+		fmt.Sprintf("umask 077 && mkdir -p %s && rm -f %[2]s && tee %[2]s > /dev/null << 'ENV_EOF'\n%[3]sENV_EOF",
+			path.Dir(envFile), envFile, env),
+		fmt.Sprintf("mkdir -p %s && tee %s > /dev/null << 'UNIT_EOF'\n%sUNIT_EOF", path.Dir(p.servicePath(r)), p.servicePath(r), service),
+		p.startCommand(r),
+	}
+}
+
+// ─── Linux (systemd) ──────────────────────────────────────────────────────────
+
+type systemdPlatform struct{ posix }
+
+func (systemdPlatform) goos() string    { return "linux" }
+func (systemdPlatform) manager() string { return "systemd" }
+
+func (systemdPlatform) runtimeScript() string {
+	return strings.Join([]string{
+		"if ! command -v python3 >/dev/null 2>&1 || ! command -v yt-dlp >/dev/null 2>&1 || ! command -v node >/dev/null 2>&1; then",
+		"  if command -v apt-get >/dev/null 2>&1; then",
+		"    apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y python3 python3-pip nodejs;",
+		"  elif command -v dnf >/dev/null 2>&1; then",
+		"    dnf install -y python3 python3-pip nodejs;",
+		"  elif command -v yum >/dev/null 2>&1; then",
+		"    yum install -y python3 python3-pip nodejs;",
+		"  elif command -v apk >/dev/null 2>&1; then",
+		"    apk add --no-cache python3 py3-pip nodejs;",
+		"  else",
+		"    echo unsupported package manager >&2; exit 1;",
+		"  fi;",
+		"fi",
+		"&& (command -v yt-dlp >/dev/null 2>&1 || python3 -m pip install --upgrade --break-system-packages yt-dlp || python3 -m pip install --upgrade yt-dlp)",
+	}, " ")
+}
+
+func (systemdPlatform) envFilePath(r *JobRequest) string {
+	return "/etc/ngoogle/" + r.ServiceName + ".env"
+}
+
+func (systemdPlatform) servicePath(r *JobRequest) string {
+	return "/etc/systemd/system/" + r.ServiceName + ".service"
+}
+
+// render keeps only the token in the env file; the rest of the environment
+// is in the unit.
+func (p systemdPlatform) render(r *JobRequest, masterURL string) (string, string) {
+	unit := fmt.Sprintf(systemdTemplate, r.binaryPath(), p.envFilePath(r), r.HostIP, masterURL, renderEnvironment(r.AgentEnv))
+	return unit, "AGENT_TOKEN=" + r.agentToken + "\n"
+}
+
+func (p systemdPlatform) installScripts(r *JobRequest, masterURL string) []string {
+	return posixInstallScripts(p, r, masterURL)
+}
+
+func (systemdPlatform) startCommand(r *JobRequest) string {
+	return fmt.Sprintf("systemctl daemon-reload && systemctl enable %[1]s && systemctl restart %[1]s", r.ServiceName)
+}
+
+func (p systemdPlatform) removeScript(r *JobRequest) string {
+	return fmt.Sprintf("systemctl disable --now %s; rm -f %s %s %s && systemctl daemon-reload",
+		r.ServiceName, p.servicePath(r), p.envFilePath(r), r.binaryPath())
+}
+
+// ─── FreeBSD (rc.d) ───────────────────────────────────────────────────────────
+
+type rcdPlatform struct{ posix }
+
+func (rcdPlatform) goos() string    { return "freebsd" }
+func (rcdPlatform) manager() string { return "rc.d" }
+
+func (rcdPlatform) runtimeScript() string {
+	return "if ! command -v python3 >/dev/null 2>&1 || ! command -v yt-dlp >/dev/null 2>&1 || ! command -v node >/dev/null 2>&1; then " +
+		"ASSUME_ALWAYS_YES=yes pkg install -y python3 yt-dlp node; fi"
+}
+
+func (rcdPlatform) envFilePath(r *JobRequest) string {
+	return "/usr/local/etc/ngoogle/" + r.ServiceName + ".env"
+}
+
+func (rcdPlatform) servicePath(r *JobRequest) string {
+	return "/usr/local/etc/rc.d/" + r.ServiceName
+}
+
+// render puts the whole environment in the env file, which rc.subr loads
+// through <name>_env_file.
+func (p rcdPlatform) render(r *JobRequest, masterURL string) (string, string) {
+	name := rcName(r.ServiceName)
+	script := fmt.Sprintf(rcdTemplate, name, p.envFilePath(r), r.ServiceName, r.binaryPath())
+	return script, shellEnvironment(r, masterURL)
+}
+
+func (p rcdPlatform) installScripts(r *JobRequest, masterURL string) []string {
+	return posixInstallScripts(p, r, masterURL)
+}
+
+func (p rcdPlatform) startCommand(r *JobRequest) string {
+	return fmt.Sprintf("chmod 555 %s && sysrc %s_enable=YES && service %s restart",
+		p.servicePath(r), rcName(r.ServiceName), r.ServiceName)
+}
+
+func (p rcdPlatform) removeScript(r *JobRequest) string {
+	return fmt.Sprintf("service %s onestop; sysrc -x %s_enable; rm -f %s %s %s",
+		r.ServiceName, rcName(r.ServiceName), p.servicePath(r), p.envFilePath(r), r.binaryPath())
+}
+
+var rcNameRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// rcName turns a service name into the shell variable prefix rc.d uses for
+// its settings, such as ngoogle_agent_enable.
+func rcName(service string) string { return rcNameRe.ReplaceAllString(service, "_") }
+
+const rcdTemplate = `#!/bin/sh
+#
+# PROVIDE: %[1]s
+# REQUIRE: NETWORKING
+# KEYWORD: shutdown
+
+. /etc/rc.subr
+
+name="%[1]s"
+rcvar="%[1]s_enable"
+%[1]s_env_file="%[2]s"
+pidfile="/var/run/%[3]s.pid"
+command="/usr/sbin/daemon"
+command_args="-r -R 5 -P ${pidfile} -o /var/log/%[3]s.log %[4]s"
+
+load_rc_config $name
+: ${%[1]s_enable:="NO"}
+run_rc_command "$1"
+`
+
+// ─── macOS (launchd) ──────────────────────────────────────────────────────────
+
+type launchdPlatform struct{ posix }
+
+func (launchdPlatform) goos() string    { return "darwin" }
+func (launchdPlatform) manager() string { return "launchd" }
+
+// runtimeScript only checks the dependencies: Homebrew, which provides them
+// on macOS, refuses to run as root.
+func (launchdPlatform) runtimeScript() string {
+	return "PATH=/opt/homebrew/bin:/usr/local/bin:$PATH; for c in python3 yt-dlp node; do " +
+		"command -v $c >/dev/null 2>&1 || { echo \"$c not found: install python3, yt-dlp and node (for example with Homebrew) first\" >&2; exit 1; }; done"
+}
+
+func (launchdPlatform) envFilePath(r *JobRequest) string {
+	return "/usr/local/etc/ngoogle/" + r.ServiceName + ".env"
+}
+
+func (launchdPlatform) servicePath(r *JobRequest) string {
+	return "/Library/LaunchDaemons/" + r.ServiceName + ".plist"
+}
+
+// render puts the whole environment in the env file, which the job's shell
+// loads before starting the agent; launchd itself cannot read one.
+func (p launchdPlatform) render(r *JobRequest, masterURL string) (string, string) {
+	plist := fmt.Sprintf(launchdTemplate, r.ServiceName, p.envFilePath(r), r.binaryPath())
+	return plist, shellEnvironment(r, masterURL)
+}
+
+func (p launchdPlatform) installScripts(r *JobRequest, masterURL string) []string {
+	return posixInstallScripts(p, r, masterURL)
+}
+
+func (p launchdPlatform) startCommand(r *JobRequest) string {
+	return fmt.Sprintf("launchctl bootout system/%s 2>/dev/null; launchctl bootstrap system %s", r.ServiceName, p.servicePath(r))
+}
+
+func (p launchdPlatform) removeScript(r *JobRequest) string {
+	return fmt.Sprintf("launchctl bootout system/%s; rm -f %s %s %s",
+		r.ServiceName, p.servicePath(r), p.envFilePath(r), r.binaryPath())
+}
+
+// Install directories and service names are restricted to characters that
+// need no escaping in the plist.
+const launchdTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%[1]s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>/bin/sh</string>
+		<string>-c</string>
+		<string>set -a; . %[2]s; exec %[3]s</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>/var/log/%[1]s.log</string>
+	<key>StandardErrorPath</key>
+	<string>/var/log/%[1]s.log</string>
+</dict>
+</plist>
+`
+
+// shellEnvironment renders the agent's whole environment as shell
+// assignments, for env files that are sourced by sh. PATH includes
+// /usr/local/bin, where yt-dlp and node are usually installed.
+func shellEnvironment(r *JobRequest, masterURL string) string {
+	env := agentEnvironment(r, masterURL)
+	env["PATH"] = "/opt/homebrew/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	return renderEnvFile(env, shellQuote)
+}
+
+// agentEnvironment is the agent's whole environment: its token, host IP and
+// master URL, and the job's AgentEnv.
+func agentEnvironment(r *JobRequest, masterURL string) map[string]string {
+	env := map[string]string{
+		"AGENT_TOKEN":   r.agentToken,
+		"AGENT_HOST_IP": r.HostIP,
+		"MASTER_URL":    masterURL,
+	}
+	for k, v := range r.AgentEnv {
+		env[k] = v
+	}
+	return env
+}
+
+// renderEnvFile renders env as KEY=VALUE lines in key order, with each
+// value passed through quote.
+func renderEnvFile(env map[string]string, quote func(string) string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + quote(env[k]) + "\n")
+	}
+	return b.String()
+}
+
+// ─── Windows (service control manager) ────────────────────────────────────────
+
+// windowsPlatform installs the agent as a Windows service. Commands run in
+// Windows PowerShell, which Windows OpenSSH can start from either of its
+// default shells; the SSH user must be an administrator, as there is no sudo
+// to elevate with. The agent reads its env file itself, through -env-file.
+type windowsPlatform struct{}
+
+func (windowsPlatform) goos() string    { return "windows" }
+func (windowsPlatform) manager() string { return "Windows service" }
+
+// validate rejects a custom install_dir: install_dir is a Unix path, and
+// the agent always goes under %ProgramFiles%\ngoogle on Windows.
+func (windowsPlatform) validate(r *JobRequest) error {
+	if r.InstallDir != defaultInstallDir {
+		return fmt.Errorf("install_dir is not supported on Windows; the agent is installed under %%ProgramFiles%%\\ngoogle")
+	}
+	return nil
+}
+
+func (windowsPlatform) shell(script string) string { return powershellCommand(script) }
+
+func (windowsPlatform) elevate(script string, _ func(string) (string, string)) (string, string) {
+	return powershellCommand(script), ""
+}
+
+func (windowsPlatform) downloadScript(r *JobRequest, url string) string {
+	return "# Download the agent binary\n" + powershellPrelude +
+		// Windows PowerShell 5.1 still offers TLS 1.0 first.
+		"[Net.ServicePointManager]::SecurityProtocol = [Net.ServicePointManager]::SecurityProtocol -bor [Net.SecurityProtocolType]::Tls12\n" +
+		fmt.Sprintf("Invoke-WebRequest -UseBasicParsing -Uri %s -OutFile %s\n", psQuote(url), windowsStagingPath(r))
+}
+
+// runtimeScript only checks the dependencies: Windows has no package manager
+// that can be relied on to run non-interactively over SSH. They must be on
+// the machine-wide PATH, which is the one the service sees.
+func (windowsPlatform) runtimeScript() string {
+	return "# Check for python, yt-dlp and node\n" +
+		"$missing = @('python', 'yt-dlp', 'node') | Where-Object { -not (Get-Command $_ -ErrorAction SilentlyContinue) }\n" +
+		"if ($missing) { [Console]::Error.WriteLine(($missing -join ', ') + ' not found: install Python, yt-dlp and Node.js (for example with winget) for all users first'); exit 1 }\n"
+}
+
+func (windowsPlatform) installScripts(r *JobRequest, masterURL string) []string {
+	name := psQuote(r.ServiceName)
+	return []string{
+		fmt.Sprintf("# Install the agent binary to %s\n", windowsBinaryPath(r)) + powershellPrelude +
+			fmt.Sprintf("$bin = %s\n", windowsBinaryPath(r)) +
+			"New-Item -ItemType Directory -Force -Path (Split-Path $bin) | Out-Null\n" +
+			// A running agent holds its binary open.
+			fmt.Sprintf("if (Get-Service -Name %[1]s -ErrorAction SilentlyContinue) { Stop-Service -Name %[1]s -Force }\n", name) +
+			fmt.Sprintf("Move-Item -Force -Path %s -Destination $bin\n", windowsStagingPath(r)),
+		// The directory only admits SYSTEM and Administrators, by SID so that
+		// localized group names do not matter, before the token is written.
+		fmt.Sprintf("# Write the env file %s\n", windowsEnvFilePath(r)) + powershellPrelude +
+			fmt.Sprintf("$envFile = %s\n", windowsEnvFilePath(r)) +
+			"$dir = Split-Path $envFile\n" +
+			"New-Item -ItemType Directory -Force -Path $dir | Out-Null\n" +
+			"icacls $dir /inheritance:r /grant:r '*S-1-5-18:(OI)(CI)F' '*S-1-5-32-544:(OI)(CI)F' | Out-Null\n" +
+			"if ($LASTEXITCODE -ne 0) { throw \"icacls exited with $LASTEXITCODE\" }\n" +
+			"Set-Content -Path $envFile -Encoding UTF8 -Value @'\n" +
+			renderEnvFile(agentEnvironment(r, masterURL), func(v string) string { return v }) +
+			"'@\n",
+		fmt.Sprintf("# Register the %s service\n", r.ServiceName) + powershellPrelude +
+			fmt.Sprintf("$cmdLine = '\"{0}\" -env-file \"{1}\"' -f %s, %s\n", windowsBinaryPath(r), windowsEnvFilePath(r)) +
+			fmt.Sprintf("if (Get-Service -Name %s -ErrorAction SilentlyContinue) {\n", name) +
+			fmt.Sprintf("  Set-ItemProperty -Path %s -Name ImagePath -Value $cmdLine\n", psQuote(`HKLM:\SYSTEM\CurrentControlSet\Services\`+r.ServiceName)) +
+			fmt.Sprintf("  Set-Service -Name %s -StartupType Automatic\n", name) +
+			"} else {\n" +
+			fmt.Sprintf("  New-Service -Name %s -BinaryPathName $cmdLine -DisplayName 'ngoogle Agent' -StartupType Automatic | Out-Null\n", name) +
+			"}\n" +
+			fmt.Sprintf("sc.exe failure %s reset= 86400 actions= restart/5000/restart/5000/restart/5000 | Out-Null\n", name) +
+			"if ($LASTEXITCODE -ne 0) { throw \"sc.exe failure exited with $LASTEXITCODE\" }\n",
+		fmt.Sprintf("# Start the %s service\n", r.ServiceName) + powershellPrelude +
+			fmt.Sprintf("Restart-Service -Name %s -Force\n", name),
+	}
+}
+
+func (windowsPlatform) removeScript(r *JobRequest) string {
+	name := psQuote(r.ServiceName)
+	return fmt.Sprintf("# Remove the %s service\n", r.ServiceName) + powershellPrelude +
+		fmt.Sprintf("if (Get-Service -Name %s -ErrorAction SilentlyContinue) {\n", name) +
+		fmt.Sprintf("  Stop-Service -Name %s -Force\n", name) +
+		fmt.Sprintf("  sc.exe delete %s | Out-Null\n", name) +
+		"  if ($LASTEXITCODE -ne 0) { throw \"sc.exe delete exited with $LASTEXITCODE\" }\n" +
+		"}\n" +
+		fmt.Sprintf("Remove-Item -Force -ErrorAction SilentlyContinue -Path %s, %s\n", windowsBinaryPath(r), windowsEnvFilePath(r))
+}
+
+// The agent's paths on Windows, as PowerShell expressions.
+func windowsBinaryPath(r *JobRequest) string {
+	return fmt.Sprintf("(Join-Path $env:ProgramFiles %s)", psQuote(`ngoogle\`+r.ServiceName+".exe"))
+}
+
+func windowsEnvFilePath(r *JobRequest) string {
+	return fmt.Sprintf("(Join-Path $env:ProgramData %s)", psQuote(`ngoogle\`+r.ServiceName+".env"))
+}
+
+func windowsStagingPath(r *JobRequest) string {
+	return fmt.Sprintf("(Join-Path $env:TEMP %s)", psQuote(r.ServiceName+".exe"))
+}
+
+// powershellPrelude makes cmdlet errors fail the script and keeps progress
+// bars out of the output.
+const powershellPrelude = "$ErrorActionPreference = 'Stop'\n$ProgressPreference = 'SilentlyContinue'\n"
+
+// powershellCommand returns a command line running script in Windows
+// PowerShell. The script is passed base64-encoded, as -EncodedCommand
+// expects, so neither cmd.exe nor PowerShell reinterprets its quoting.
+func powershellCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand " + base64.StdEncoding.EncodeToString(b)
+}
+
+// psQuote single-quotes s for PowerShell.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// windowsDetectCommand prints the Windows version banner and the processor
+// architecture. It runs the same under cmd.exe and PowerShell, the shells
+// Windows OpenSSH starts, which have no uname.
+const windowsDetectCommand = `cmd /c "ver & echo %PROCESSOR_ARCHITECTURE%"`
+
+// parseWindowsVer splits the output of windowsDetectCommand into the
+// Windows platform and the GOARCH of the agent binary to download.
+func parseWindowsVer(out string) (platform, string, error) {
+	fields := strings.Fields(out)
+	if !strings.Contains(out, "Microsoft Windows") || len(fields) == 0 {
+		return nil, "", fmt.Errorf("unexpected ver output %q", strings.TrimSpace(out))
+	}
+	goArch, err := mapArch(strings.ToLower(fields[len(fields)-1]))
+	if err != nil {
+		return nil, "", err
+	}
+	return windowsPlatform{}, goArch, nil
+}
@@ -0,0 +1,309 @@
+package provision
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+func TestParseUname(t *testing.T) {
+	tests := []struct {
+		out, goos, goarch, err string
+	}{
+		{out: "Linux x86_64\n", goos: "linux", goarch: "amd64"},
+		{out: "FreeBSD amd64\n", goos: "freebsd", goarch: "amd64"},
+		{out: "FreeBSD arm64\n", goos: "freebsd", goarch: "arm64"},
+		{out: "Darwin arm64\n", goos: "darwin", goarch: "arm64"},
+		{out: "SunOS i86pc\n", err: "unsupported operating system: SunOS"},
+		{out: "CYGWIN_NT-10.0 x86_64\n", goos: "windows", goarch: "amd64"},
+		{out: "MINGW64_NT-10.0-20348 x86_64\n", goos: "windows", goarch: "amd64"},
+		{out: "Linux sparc64\n", err: "unsupported architecture: sparc64"},
+		{out: "x86_64\n", err: "unexpected uname output"},
+	}
+	for _, tc := range tests {
+		p, goArch, err := parseUname(tc.out)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("parseUname(%q) error = %v, want %q", tc.out, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || p.goos() != tc.goos || goArch != tc.goarch {
+			t.Errorf("parseUname(%q) = %v, %q, %v; want %s/%s", tc.out, p, goArch, err, tc.goos, tc.goarch)
+		}
+	}
+}
+
+func TestRunFailsOnUnsupportedOS(t *testing.T) {
+	svc, st := newTestService(t)
+	runner := &fakeRunner{}
+	runner.respond = onlineAfterRestart(t, st, "SunOS i86pc")
+	svc.newRunner = func() SSHRunner { return runner }
+	job, req := createJob(t, st, 22)
+
+	svc.run(job.ID, req)
+
+	got := getJob(t, st, job.ID)
+	if got.Status != model.ProvisionStatusFailed || got.FailedStep != "download_binary" ||
+		!strings.Contains(got.Log, "unsupported operating system: SunOS") {
+		t.Fatalf("expected download_binary failure, got %s/%s (log: %s)", got.Status, got.FailedStep, got.Log)
+	}
+	if runner.ran("wget") {
+		t.Fatalf("downloaded a binary for an unsupported OS: %v", runner.cmds)
+	}
+}
+
+func TestRunInstallsWithPlatformServiceManager(t *testing.T) {
+	tests := []struct {
+		uname string
+		want  []string
+	}{
+		{"FreeBSD amd64", []string{
+			"agent-freebsd-amd64",
+			"pkg install",
+			"tee /usr/local/etc/ngoogle/ngoogle-agent.env",
+			"tee /usr/local/etc/rc.d/ngoogle-agent",
+			"ngoogle_agent_env_file=\"/usr/local/etc/ngoogle/ngoogle-agent.env\"",
+			"sysrc ngoogle_agent_enable=YES && service ngoogle-agent restart",
+		}},
+		{"Darwin arm64", []string{
+			"agent-darwin-arm64",
+			"tee /usr/local/etc/ngoogle/ngoogle-agent.env",
+			"tee /Library/LaunchDaemons/ngoogle-agent.plist",
+			"<string>set -a; . /usr/local/etc/ngoogle/ngoogle-agent.env; exec /usr/local/bin/ngoogle-agent</string>",
+			"launchctl bootstrap system /Library/LaunchDaemons/ngoogle-agent.plist",
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.uname, func(t *testing.T) {
+			svc, st := newTestService(t)
+			runner := &fakeRunner{}
+			runner.respond = onlineAfterRestart(t, st, tc.uname)
+			svc.newRunner = func() SSHRunner { return runner }
+			job, req := createJob(t, st, 22)
+			req.agentToken = "tok123"
+			req.AgentEnv = map[string]string{"AGENT_TAGS": "bsd"}
+
+			svc.run(job.ID, req)
+
+			got := getJob(t, st, job.ID)
+			if got.Status != model.ProvisionStatusSuccess {
+				t.Fatalf("expected success, got %s (log: %s)", got.Status, got.Log)
+			}
+			for _, want := range tc.want {
+				if !runner.ran(want) {
+					t.Errorf("expected a command containing %q, commands: %v", want, runner.cmds)
+				}
+			}
+			// Values are shell-quoted in the env file, and again by sudo sh -c.
+			for _, want := range []string{`AGENT_TOKEN='\''tok123'\''`, `MASTER_URL='\''http://master:8080'\''`, `AGENT_TAGS='\''bsd'\''`} {
+				if !runner.ran(want) {
+					t.Errorf("expected env file to set %s, commands: %v", want, runner.cmds)
+				}
+			}
+			if runner.ran("systemctl") {
+				t.Errorf("used systemd on %s: %v", tc.uname, runner.cmds)
+			}
+			if strings.Contains(got.Log, "tok123") {
+				t.Fatalf("token leaked into job log: %s", got.Log)
+			}
+		})
+	}
+}
+
+func TestRunRequiresOSPlaceholderOffLinux(t *testing.T) {
+	svc, st := newTestService(t)
+	runner := &fakeRunner{}
+	runner.respond = onlineAfterRestart(t, st, "FreeBSD amd64")
+	svc.newRunner = func() SSHRunner { return runner }
+	job, req := createJob(t, st, 22)
+	req.DownloadURL = "https://artifacts.internal/ngoogle/agent-linux-{arch}"
+
+	svc.run(job.ID, req)
+
+	got := getJob(t, st, job.ID)
+	if got.Status != model.ProvisionStatusFailed || got.FailedStep != "download_binary" ||
+		!strings.Contains(got.Log, "no {os} placeholder") {
+		t.Fatalf("expected download_binary failure, got %s/%s (log: %s)", got.Status, got.FailedStep, got.Log)
+	}
+	if runner.ran("wget") {
+		t.Fatalf("downloaded a Linux binary for FreeBSD: %v", runner.cmds)
+	}
+}
+
+func TestUninstallUsesPlatformServiceManager(t *testing.T) {
+	svc, st := newTestService(t)
+	runner := &fakeRunner{respond: onlineAfterRestart(t, st, "FreeBSD amd64")}
+	svc.newRunner = func() SSHRunner { return runner }
+	job, _ := createJob(t, st, 22)
+	if err := st.ProvisionJobs().UpdateStatus(context.Background(), job.ID, model.ProvisionStatusSuccess, "done"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Uninstall(context.Background(), job.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"service ngoogle-agent onestop", "sysrc -x ngoogle_agent_enable", "/usr/local/etc/rc.d/ngoogle-agent"} {
+		if !runner.ran(want) {
+			t.Errorf("expected a command containing %q, commands: %v", want, runner.cmds)
+		}
+	}
+}
+
+func TestParseWindowsVer(t *testing.T) {
+	tests := []struct {
+		out, goarch, err string
+	}{
+		{out: "\r\nMicrosoft Windows [Version 10.0.20348.2340]\r\nAMD64\r\n", goarch: "amd64"},
+		{out: "\r\nMicrosoft Windows [Version 10.0.26100.1]\r\nARM64\r\n", goarch: "arm64"},
+		{out: "\r\nMicrosoft Windows [Version 10.0.19045.1]\r\nx86\r\n", err: "unsupported architecture: x86"},
+		{out: "'ver' is not recognized\r\n", err: "unexpected ver output"},
+	}
+	for _, tc := range tests {
+		p, goArch, err := parseWindowsVer(tc.out)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("parseWindowsVer(%q) error = %v, want %q", tc.out, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || p.goos() != "windows" || goArch != tc.goarch {
+			t.Errorf("parseWindowsVer(%q) = %v, %q, %v; want windows/%s", tc.out, p, goArch, err, tc.goarch)
+		}
+	}
+}
+
+// windowsHost answers like Windows OpenSSH: uname is missing, and the agent
+// comes online once its service is restarted. Scripts it was sent are
+// appended to scripts, decoded.
+func windowsHost(t *testing.T, st store.Store, scripts *[]string) func(cmd string) (string, error) {
+	return func(cmd string) (string, error) {
+		switch {
+		case cmd == "uname -sm":
+			return "'uname' is not recognized as an internal or external command\r\n", errors.New("exit status 1")
+		case cmd == windowsDetectCommand:
+			return "\r\nMicrosoft Windows [Version 10.0.20348.2340]\r\nAMD64\r\n", nil
+		}
+		script := decodePowerShell(t, cmd)
+		*scripts = append(*scripts, script)
+		if strings.Contains(script, "Restart-Service") {
+			now := time.Now()
+			a := &model.Agent{ID: "agent1", Hostname: "h", IP: "127.0.0.1", Status: model.AgentStatusOnline,
+				LastHeartbeat: now, CreatedAt: now, UpdatedAt: now}
+			if err := st.Agents().Upsert(context.Background(), a); err != nil {
+				t.Error(err)
+			}
+		}
+		return "", nil
+	}
+}
+
+// decodePowerShell returns the script a powershellCommand command line runs.
+func decodePowerShell(t *testing.T, cmd string) string {
+	t.Helper()
+	prefix := "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
+	if !strings.HasPrefix(cmd, prefix) {
+		t.Fatalf("command is not an encoded PowerShell script: %q", cmd)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(cmd, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func TestRunInstallsWindowsService(t *testing.T) {
+	svc, st := newTestService(t)
+	var scripts []string
+	runner := &fakeRunner{respond: windowsHost(t, st, &scripts)}
+	svc.newRunner = func() SSHRunner { return runner }
+	job, req := createJob(t, st, 22)
+	req.agentToken = "tok123"
+	req.AgentEnv = map[string]string{"AGENT_TAGS": "win's"}
+
+	svc.run(job.ID, req)
+
+	got := getJob(t, st, job.ID)
+	if got.Status != model.ProvisionStatusSuccess {
+		t.Fatalf("expected success, got %s (log: %s)", got.Status, got.Log)
+	}
+	all := strings.Join(scripts, "\n")
+	for _, want := range []string{
+		"-Uri 'https://github.com/SHIINMASHIRO/New-Google-LF/releases/latest/download/agent-windows-amd64'",
+		"python', 'yt-dlp', 'node'",
+		"Move-Item -Force -Path (Join-Path $env:TEMP 'ngoogle-agent.exe') -Destination $bin",
+		"icacls $dir /inheritance:r",
+		// Env file values are written verbatim, quotes included.
+		"AGENT_TAGS=win's\nAGENT_TOKEN=tok123\nMASTER_URL=http://master:8080\n'@",
+		`$cmdLine = '"{0}" -env-file "{1}"' -f (Join-Path $env:ProgramFiles 'ngoogle\ngoogle-agent.exe'), (Join-Path $env:ProgramData 'ngoogle\ngoogle-agent.env')`,
+		"New-Service -Name 'ngoogle-agent' -BinaryPathName $cmdLine",
+		"sc.exe failure 'ngoogle-agent' reset= 86400 actions= restart/5000",
+		"Restart-Service -Name 'ngoogle-agent' -Force",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected a script containing %q, scripts:\n%s", want, all)
+		}
+	}
+	if runner.ran("sudo") || strings.Contains(all, "sudo") {
+		t.Errorf("used sudo on Windows: %v", scripts)
+	}
+	if strings.Contains(got.Log, "tok123") {
+		t.Fatalf("token leaked into job log: %s", got.Log)
+	}
+	if !strings.Contains(got.Log, "# Register the ngoogle-agent service") {
+		t.Errorf("expected install steps in the job log: %s", got.Log)
+	}
+}
+
+func TestRunRejectsInstallDirOnWindows(t *testing.T) {
+	svc, st := newTestService(t)
+	var scripts []string
+	runner := &fakeRunner{respond: windowsHost(t, st, &scripts)}
+	svc.newRunner = func() SSHRunner { return runner }
+	job, req := createJob(t, st, 22)
+	req.InstallDir = "/opt/ngoogle"
+
+	svc.run(job.ID, req)
+
+	got := getJob(t, st, job.ID)
+	if got.Status != model.ProvisionStatusFailed || got.FailedStep != "download_binary" ||
+		!strings.Contains(got.Log, "install_dir is not supported on Windows") {
+		t.Fatalf("expected download_binary failure, got %s/%s (log: %s)", got.Status, got.FailedStep, got.Log)
+	}
+	if len(scripts) != 0 {
+		t.Fatalf("ran scripts on the host: %v", scripts)
+	}
+}
+
+func TestUninstallRemovesWindowsService(t *testing.T) {
+	svc, st := newTestService(t)
+	var scripts []string
+	runner := &fakeRunner{respond: windowsHost(t, st, &scripts)}
+	svc.newRunner = func() SSHRunner { return runner }
+	job, _ := createJob(t, st, 22)
+	if err := st.ProvisionJobs().UpdateStatus(context.Background(), job.ID, model.ProvisionStatusSuccess, "done"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Uninstall(context.Background(), job.ID); err != nil {
+		t.Fatal(err)
+	}
+	all := strings.Join(scripts, "\n")
+	for _, want := range []string{"Stop-Service -Name 'ngoogle-agent' -Force", "sc.exe delete 'ngoogle-agent'", "'ngoogle\\ngoogle-agent.env'"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected a script containing %q, scripts:\n%s", want, all)
+		}
+	}
+}
@@ -2,34 +2,253 @@
 package provision
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/aven/ngoogle/internal/model"
 	"github.com/aven/ngoogle/internal/store"
 )
 
+// Default provisioning timeouts.
+const (
+	defaultConnectTimeout  = 15 * time.Second
+	defaultCommandTimeout  = 2 * time.Minute
+	defaultDownloadTimeout = 10 * time.Minute
+	defaultRuntimeTimeout  = 15 * time.Minute
+	defaultHealthTimeout   = 60 * time.Second
+	defaultJobTimeout      = 30 * time.Minute
+)
+
+// defaultMaxConcurrent is how many jobs run at once unless SetMaxConcurrent
+// says otherwise. Each holds an SSH session for its whole run.
+const defaultMaxConcurrent = 10
+
+// Default install location on the target host.
+const (
+	defaultInstallDir  = "/usr/local/bin"
+	defaultServiceName = "ngoogle-agent"
+)
+
+var (
+	serviceNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]*$`)
+	installDirRe  = regexp.MustCompile(`^/[A-Za-z0-9_./-]*$`)
+)
+
+var tracer = otel.Tracer("github.com/aven/ngoogle/internal/master/provision")
+
+// agentEnvAllowlist is the set of agent settings that may be passed through
+// JobRequest.AgentEnv. AGENT_HOST_IP and MASTER_URL are always rendered by
+// the unit template and cannot be overridden.
+var agentEnvAllowlist = map[string]bool{
+	"AGENT_TAGS":           true,
+	"YOUTUBE_COOKIES_FILE": true,
+	"HTTP_PROXY":           true,
+	"HTTPS_PROXY":          true,
+	"NO_PROXY":             true,
+	"http_proxy":           true,
+	"https_proxy":          true,
+	"no_proxy":             true,
+	"SSL_CERT_FILE":        true,
+	"SSL_CERT_DIR":         true,
+}
+
 // Service handles SSH-based agent provisioning.
 type Service struct {
 	store       store.Store
 	masterURL   string
-	downloadURL string // GitHub release download URL template with {arch} placeholder
+	downloadURL string // GitHub release download URL template with {os} and {arch} placeholders
+
+	connectTimeout  time.Duration // SSH dial + handshake
+	commandTimeout  time.Duration // short remote commands (uname, mv, service manager)
+	downloadTimeout time.Duration // agent binary download
+	runtimeTimeout  time.Duration // package manager / pip install
+	healthTimeout   time.Duration // wait for the agent to come online
+	jobTimeout      time.Duration // overall deadline for the whole job
+
+	newRunner func() SSHRunner // one runner per job; replaced in tests
+
+	// maxConcurrent bounds the jobs running at once. Jobs launched beyond it
+	// wait in queue, oldest first, and stay pending until a running job
+	// returns. mu guards running and queue.
+	maxConcurrent int
+	mu            sync.Mutex
+	running       int
+	queue         []queuedJob
+
+	// ctx bounds every job; cancelling it interrupts running jobs. wg tracks
+	// job goroutines so shutdown can wait for them to record their state.
+	ctx context.Context
+	wg  sync.WaitGroup
 }
 
 // NewService creates a new provision Service.
 func NewService(st store.Store, masterURL, downloadURL string) *Service {
 	if downloadURL == "" {
-		downloadURL = "https://github.com/SHIINMASHIRO/New-Google-LF/releases/latest/download/agent-linux-{arch}"
+		downloadURL = "https://github.com/SHIINMASHIRO/New-Google-LF/releases/latest/download/agent-{os}-{arch}"
+	}
+	return &Service{
+		store:           st,
+		masterURL:       masterURL,
+		downloadURL:     downloadURL,
+		connectTimeout:  defaultConnectTimeout,
+		commandTimeout:  defaultCommandTimeout,
+		downloadTimeout: defaultDownloadTimeout,
+		runtimeTimeout:  defaultRuntimeTimeout,
+		healthTimeout:   defaultHealthTimeout,
+		jobTimeout:      defaultJobTimeout,
+		newRunner:       func() SSHRunner { return &sshRunner{} },
+		maxConcurrent:   defaultMaxConcurrent,
+		ctx:             context.Background(),
+	}
+}
+
+// SetMaxConcurrent sets how many jobs may run at once; further jobs are
+// queued. Call it before Resume.
+func (s *Service) SetMaxConcurrent(n int) error {
+	if n < 1 {
+		return fmt.Errorf("provision concurrency %d must be at least 1", n)
+	}
+	s.maxConcurrent = n
+	return nil
+}
+
+// Resume binds the service to ctx and recovers jobs a previous master
+// process left unfinished: jobs it interrupted on shutdown, and jobs still
+// pending or running because it crashed. Every step of a job is safe to
+// repeat, so these are re-run from the start; jobs that can no longer run,
+// for instance because their credential was deleted, are marked failed.
+// Jobs started afterwards stop when ctx is cancelled and are marked
+// interrupted. Call it once, before serving.
+func (s *Service) Resume(ctx context.Context) error {
+	s.ctx = ctx
+	jobs, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		switch job.Status {
+		case model.ProvisionStatusInterrupted, model.ProvisionStatusPending, model.ProvisionStatusRunning:
+		default:
+			continue
+		}
+		if err := s.recoverJob(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverJob re-runs an unfinished job, or fails it when it cannot be re-run.
+func (s *Service) recoverJob(ctx context.Context, job *model.ProvisionJob) error {
+	req, err := requestFromJob(job)
+	if err == nil {
+		err = s.checkCredentials(ctx, req)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrInvalidRequest) && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		slog.Warn("provision recover: failing job", "job", job.ID, "status", job.Status, "err", err)
+		reason := "master restarted: " + err.Error()
+		_ = s.store.ProvisionJobs().AppendLog(ctx, job.ID,
+			fmt.Sprintf("[%s] FAILED at %s: %s", time.Now().Format(time.RFC3339), job.CurrentStep, reason))
+		return s.store.ProvisionJobs().SetFailed(ctx, job.ID, job.CurrentStep, reason)
+	}
+	if err := s.ensureToken(ctx, job.ID, req); err != nil {
+		return err
+	}
+	slog.Info("provision recover: resuming job", "job", job.ID, "host", job.HostIP, "status", job.Status, "step", job.CurrentStep)
+	_ = s.store.ProvisionJobs().AppendLog(ctx, job.ID,
+		fmt.Sprintf("[%s] Resuming after master restart (was %s at %s)", time.Now().Format(time.RFC3339), job.Status, job.CurrentStep))
+	if err := s.store.ProvisionJobs().UpdateStatus(ctx, job.ID, model.ProvisionStatusPending, "created"); err != nil {
+		return err
+	}
+	s.launch(job.ID, req)
+	return nil
+}
+
+// Wait blocks until every running job has returned. After the context
+// passed to Resume is cancelled this is how long it takes for jobs to
+// record that they were interrupted.
+func (s *Service) Wait() {
+	s.wg.Wait()
+}
+
+// queuedJob is a job waiting for one of the maxConcurrent slots.
+type queuedJob struct {
+	id  string
+	req *JobRequest
+}
+
+// launch runs a job in the background, or queues it while maxConcurrent
+// jobs are running.
+func (s *Service) launch(jobID string, req *JobRequest) {
+	s.mu.Lock()
+	if s.running < s.maxConcurrent {
+		s.startLocked(jobID, req)
+		s.mu.Unlock()
+		return
+	}
+	s.queue = append(s.queue, queuedJob{id: jobID, req: req})
+	running := s.running
+	s.mu.Unlock()
+	slog.Info("provision queued", "job", jobID, "running", running)
+	_ = s.store.ProvisionJobs().AppendLog(context.Background(), jobID,
+		fmt.Sprintf("[%s] Queued: %d jobs already running (limit %d)", time.Now().Format(time.RFC3339), running, s.maxConcurrent))
+}
+
+// startLocked runs a job in a new slot. When it returns, the slot passes to
+// the oldest queued job. s.mu must be held.
+func (s *Service) startLocked(jobID string, req *JobRequest) {
+	s.running++
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(jobID, req)
+		s.release()
+	}()
+}
+
+// release frees a job's slot for the next queued job. Once the service is
+// shutting down, queued jobs are marked interrupted instead, so that Resume
+// picks them up on the next start.
+func (s *Service) release() {
+	s.mu.Lock()
+	s.running--
+	var dropped []queuedJob
+	switch {
+	case s.ctx.Err() != nil:
+		dropped, s.queue = s.queue, nil
+	case len(s.queue) > 0:
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		s.startLocked(next.id, next.req)
+	}
+	s.mu.Unlock()
+	ctx := context.Background()
+	for _, j := range dropped {
+		_ = s.store.ProvisionJobs().AppendLog(ctx, j.id,
+			fmt.Sprintf("[%s] INTERRUPTED while queued: master shutting down", time.Now().Format(time.RFC3339)))
+		_ = s.store.ProvisionJobs().UpdateStatus(ctx, j.id, model.ProvisionStatusInterrupted, "created")
 	}
-	return &Service{store: st, masterURL: masterURL, downloadURL: downloadURL}
 }
 
 // JobRequest is the input for a provisioning job.
@@ -39,6 +258,77 @@ type JobRequest struct {
 	SSHUser       string         `json:"ssh_user"`
 	AuthType      model.AuthType `json:"auth_type"`
 	CredentialRef string         `json:"credential_ref"`
+	// SudoCredentialRef optionally references a password credential used to
+	// answer sudo prompts on hosts without passwordless sudo.
+	SudoCredentialRef string `json:"sudo_credential_ref,omitempty"`
+	// InstallDir is where the agent binary is placed (default /usr/local/bin).
+	// Windows hosts only accept the default, as the agent goes under
+	// %ProgramFiles% there.
+	InstallDir string `json:"install_dir,omitempty"`
+	// ServiceName names both the service and the binary (default
+	// ngoogle-agent), so several agents can live on one host.
+	ServiceName string `json:"service_name,omitempty"`
+	// AgentEnv is extra environment for the agent, rendered into the systemd
+	// unit as Environment= lines, or into the env file on other systems.
+	// Keys must be in agentEnvAllowlist.
+	AgentEnv map[string]string `json:"agent_env,omitempty"`
+	// DownloadURL overrides the service's agent download URL for this job,
+	// such as an internal mirror. {os} and {arch} are replaced by the
+	// target's GOOS and GOARCH.
+	DownloadURL string `json:"download_url,omitempty"`
+
+	agentToken string // generated per job and presented by the agent at registration
+}
+
+// validateAgentEnv checks AgentEnv keys against the allowlist and rejects
+// values that cannot be represented on a single unit line.
+func (r *JobRequest) validateAgentEnv() error {
+	for k, v := range r.AgentEnv {
+		if !agentEnvAllowlist[k] {
+			return errorf(ErrInvalidRequest, "agent_env: %s is not a supported agent setting", k)
+		}
+		if strings.ContainsAny(v, "\x00\r\n") {
+			return errorf(ErrInvalidRequest, "agent_env: %s must not contain newlines or NUL bytes", k)
+		}
+	}
+	return nil
+}
+
+// normalizeInstallTarget fills in InstallDir and ServiceName defaults and
+// rejects values that are unsafe to splice into shell commands.
+func (r *JobRequest) normalizeInstallTarget() error {
+	if r.InstallDir == "" {
+		r.InstallDir = defaultInstallDir
+	}
+	if !installDirRe.MatchString(r.InstallDir) {
+		return errorf(ErrInvalidRequest, "install_dir must be an absolute path of letters, digits, '_', '.', '-' and '/'")
+	}
+	r.InstallDir = path.Clean(r.InstallDir)
+	r.ServiceName = strings.TrimSuffix(r.ServiceName, ".service")
+	if r.ServiceName == "" {
+		r.ServiceName = defaultServiceName
+	}
+	if !serviceNameRe.MatchString(r.ServiceName) {
+		return errorf(ErrInvalidRequest, "service_name may only contain letters, digits, '_', '.', '@' and '-'")
+	}
+	return nil
+}
+
+// validateDownloadURL checks that DownloadURL, if set, is an http(s) URL.
+func (r *JobRequest) validateDownloadURL() error {
+	if r.DownloadURL == "" {
+		return nil
+	}
+	u, err := url.Parse(r.DownloadURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errorf(ErrInvalidRequest, "download_url must be an http or https URL")
+	}
+	return nil
+}
+
+// binaryPath is the absolute path of the installed agent binary.
+func (r *JobRequest) binaryPath() string {
+	return path.Join(r.InstallDir, r.ServiceName)
 }
 
 // CredentialRequest is the input for creating a credential.
@@ -51,11 +341,23 @@ type CredentialRequest struct {
 // Start creates a provisioning job and runs it asynchronously.
 func (s *Service) Start(ctx context.Context, req *JobRequest) (*model.ProvisionJob, error) {
 	if req.HostIP == "" || req.SSHUser == "" || req.CredentialRef == "" {
-		return nil, fmt.Errorf("host_ip, ssh_user and credential_ref are required")
+		return nil, errorf(ErrInvalidRequest, "host_ip, ssh_user and credential_ref are required")
 	}
 	if req.SSHPort <= 0 {
 		req.SSHPort = 22
 	}
+	if err := req.normalizeInstallTarget(); err != nil {
+		return nil, err
+	}
+	if err := req.validateAgentEnv(); err != nil {
+		return nil, err
+	}
+	if err := req.validateDownloadURL(); err != nil {
+		return nil, err
+	}
+	if err := s.checkCredentials(ctx, req); err != nil {
+		return nil, err
+	}
 	// Check for duplicate IP in existing agents
 	agents, err := s.store.Agents().List(ctx)
 	if err != nil {
@@ -63,7 +365,7 @@ func (s *Service) Start(ctx context.Context, req *JobRequest) (*model.ProvisionJ
 	}
 	for _, a := range agents {
 		if a.IP == req.HostIP {
-			return nil, fmt.Errorf("agent with IP %s already exists (id: %s)", req.HostIP, a.ID)
+			return nil, errorf(ErrConflict, "agent with IP %s already exists (id: %s)", req.HostIP, a.ID)
 		}
 	}
 	// Check for in-progress provision jobs with same IP
@@ -72,142 +374,174 @@ func (s *Service) Start(ctx context.Context, req *JobRequest) (*model.ProvisionJ
 		return nil, fmt.Errorf("check duplicate provision: %w", err)
 	}
 	for _, j := range jobs {
-		if j.HostIP == req.HostIP && (j.Status == model.ProvisionStatusPending || j.Status == model.ProvisionStatusRunning) {
-			return nil, fmt.Errorf("a provisioning job for IP %s is already in progress", req.HostIP)
+		if j.HostIP == req.HostIP && (j.Status == model.ProvisionStatusPending || j.Status == model.ProvisionStatusRunning ||
+			j.Status == model.ProvisionStatusInterrupted) {
+			return nil, errorf(ErrConflict, "a provisioning job for IP %s is already in progress", req.HostIP)
 		}
 	}
 	now := time.Now()
 	job := &model.ProvisionJob{
-		ID:            newID(),
-		HostIP:        req.HostIP,
-		SSHPort:       req.SSHPort,
-		SSHUser:       req.SSHUser,
-		AuthType:      req.AuthType,
-		CredentialRef: req.CredentialRef,
-		Status:        model.ProvisionStatusPending,
-		CurrentStep:   "created",
-		CreatedAt:     now,
-		UpdatedAt:     now,
+		ID:                newID(),
+		HostIP:            req.HostIP,
+		SSHPort:           req.SSHPort,
+		SSHUser:           req.SSHUser,
+		AuthType:          req.AuthType,
+		CredentialRef:     req.CredentialRef,
+		Status:            model.ProvisionStatusPending,
+		CurrentStep:       "created",
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		SudoCredentialRef: req.SudoCredentialRef,
+		InstallDir:        req.InstallDir,
+		ServiceName:       req.ServiceName,
+		DownloadURL:       req.DownloadURL,
+		AgentToken:        newToken(),
 	}
+	job.SetAgentEnv(req.AgentEnv)
+	req.agentToken = job.AgentToken
 	if err := s.store.ProvisionJobs().Create(ctx, job); err != nil {
 		return nil, err
 	}
-	// Run async
-	go s.run(job.ID, req)
+	// Run async, once a slot is free
+	s.launch(job.ID, req)
 	return job, nil
 }
 
 // run executes the full provisioning workflow.
 func (s *Service) run(jobID string, req *JobRequest) {
+	// ctx is used for store writes so they still succeed after the job deadline
+	// or a shutdown; jobCtx bounds every remote operation.
 	ctx := context.Background()
+	jobCtx, cancel := context.WithTimeout(s.ctx, s.jobTimeout)
+	defer cancel()
+
+	// The job gets a span, and each step a child span. A step ends when the
+	// next one starts or the job ends, successfully or not; its duration is
+	// then saved with the job.
+	jobCtx, jobSpan := tracer.Start(jobCtx, "provision.job", trace.WithAttributes(
+		attribute.String("job_id", jobID),
+		attribute.String("host", req.HostIP),
+	))
+	defer jobSpan.End()
+	var (
+		step      string
+		stepStart time.Time
+		stepSpan  trace.Span
+		durations = map[string]float64{}
+	)
+	endStep := func() {
+		if step == "" {
+			return
+		}
+		durations[step] = time.Since(stepStart).Round(time.Millisecond).Seconds()
+		_ = s.store.ProvisionJobs().SetStepDurations(ctx, jobID, durations)
+		stepSpan.End()
+		step = ""
+	}
+	enter := func(name string) {
+		endStep()
+		step, stepStart = name, time.Now()
+		_, stepSpan = tracer.Start(jobCtx, "provision."+name)
+		_ = s.store.ProvisionJobs().UpdateStatus(ctx, jobID, model.ProvisionStatusRunning, name)
+	}
+	defer endStep()
+
 	logLine := func(msg string) {
 		slog.Info("provision", "job", jobID, "msg", msg)
 		_ = s.store.ProvisionJobs().AppendLog(ctx, jobID, fmt.Sprintf("[%s] %s", time.Now().Format(time.RFC3339), msg))
 	}
 	fail := func(step, reason string) {
+		stepSpan.SetStatus(codes.Error, reason)
+		jobSpan.SetStatus(codes.Error, reason)
+		if s.ctx.Err() != nil {
+			// Shutting down: leave the job to be resumed on the next start.
+			logLine(fmt.Sprintf("INTERRUPTED at %s: master shutting down", step))
+			_ = s.store.ProvisionJobs().UpdateStatus(ctx, jobID, model.ProvisionStatusInterrupted, step)
+			return
+		}
+		if jobCtx.Err() != nil {
+			reason = fmt.Sprintf("job timed out after %s: %s", s.jobTimeout, reason)
+		}
 		logLine(fmt.Sprintf("FAILED at %s: %s", step, reason))
 		_ = s.store.ProvisionJobs().SetFailed(ctx, jobID, step, reason)
 	}
 
-	_ = s.store.ProvisionJobs().UpdateStatus(ctx, jobID, model.ProvisionStatusRunning, "ssh_check")
+	enter("ssh_check")
 
-	// Step 1: Load credential
-	logLine("Loading credential...")
-	cred, err := s.store.Credentials().Get(ctx, req.CredentialRef)
-	if err != nil {
-		fail("ssh_check", "credential not found: "+err.Error())
-		return
-	}
-
-	// Step 2: Build SSH config
-	sshCfg, err := buildSSHConfig(req.SSHUser, cred)
-	if err != nil {
-		fail("ssh_check", "SSH config error: "+err.Error())
-		return
-	}
-
-	// Step 3: SSH connectivity check
+	// Step 1: Load credentials and connect
 	logLine(fmt.Sprintf("Connecting to %s:%d...", req.HostIP, req.SSHPort))
-	addr := fmt.Sprintf("%s:%d", req.HostIP, req.SSHPort)
-	client, err := ssh.Dial("tcp", addr, sshCfg)
+	client, sudo, err := s.connect(jobCtx, req)
 	if err != nil {
-		fail("ssh_check", "SSH connect failed: "+err.Error())
+		fail("ssh_check", err.Error())
 		return
 	}
 	defer client.Close()
 	logLine("SSH connectivity OK")
 
-	_ = s.store.ProvisionJobs().UpdateStatus(ctx, jobID, model.ProvisionStatusRunning, "download_binary")
+	enter("download_binary")
 
-	// Step 4: Download agent binary from GitHub Releases
-	logLine("Detecting target architecture...")
-	archOut, err := runSSH(client, "uname -m")
+	// Step 2: Download agent binary from GitHub Releases
+	logLine("Detecting target OS and architecture...")
+	plat, goArch, err := s.detectPlatform(jobCtx, client)
+	if err == nil {
+		err = plat.validate(req)
+	}
 	if err != nil {
-		fail("download_binary", "detect arch: "+err.Error())
+		fail("download_binary", err.Error())
+		return
+	}
+	downloadURL := s.downloadURL
+	if req.DownloadURL != "" {
+		downloadURL = req.DownloadURL
+	}
+	if !strings.Contains(downloadURL, "{os}") && plat.goos() != "linux" {
+		fail("download_binary", fmt.Sprintf("download URL has no {os} placeholder, so it cannot serve a %s agent", plat.goos()))
 		return
 	}
-	goArch := mapArch(strings.TrimSpace(archOut))
-	downloadURL := strings.ReplaceAll(s.downloadURL, "{arch}", goArch)
-	logLine(fmt.Sprintf("Downloading agent binary (%s) from %s", goArch, downloadURL))
+	downloadURL = strings.NewReplacer("{os}", plat.goos(), "{arch}", goArch).Replace(downloadURL)
+	logLine(fmt.Sprintf("Downloading agent binary (%s/%s) from %s", plat.goos(), goArch, downloadURL))
 
-	dlCmd := fmt.Sprintf("wget -q -O /tmp/ngoogle-agent '%s' || curl -fsSL -o /tmp/ngoogle-agent '%s'", downloadURL, downloadURL)
-	if out, err := runSSH(client, dlCmd); err != nil {
+	dlCmd := plat.shell(plat.downloadScript(req, downloadURL))
+	if out, err := client.Run(jobCtx, dlCmd, "", s.downloadTimeout); err != nil {
 		fail("download_binary", fmt.Sprintf("download failed: %s; output: %s", err, out))
 		return
 	}
 	logLine("Agent binary downloaded")
 
-	_ = s.store.ProvisionJobs().UpdateStatus(ctx, jobID, model.ProvisionStatusRunning, "install_runtime")
+	enter("install_runtime")
 
-	// Step 5: Install runtime dependencies needed by the agent's YouTube executor.
+	// Step 3: Install runtime dependencies needed by the agent's YouTube executor.
 	logLine("Ensuring runtime dependencies (python3, yt-dlp, nodejs)...")
-	depsCmd := strings.Join([]string{
-		"if ! command -v python3 >/dev/null 2>&1 || ! command -v yt-dlp >/dev/null 2>&1 || ! command -v node >/dev/null 2>&1; then",
-		"  if command -v apt-get >/dev/null 2>&1; then",
-		"    sudo apt-get update && sudo DEBIAN_FRONTEND=noninteractive apt-get install -y python3 python3-pip nodejs;",
-		"  elif command -v dnf >/dev/null 2>&1; then",
-		"    sudo dnf install -y python3 python3-pip nodejs;",
-		"  elif command -v yum >/dev/null 2>&1; then",
-		"    sudo yum install -y python3 python3-pip nodejs;",
-		"  elif command -v apk >/dev/null 2>&1; then",
-		"    sudo apk add --no-cache python3 py3-pip nodejs;",
-		"  else",
-		"    echo unsupported package manager >&2; exit 1;",
-		"  fi;",
-		"fi",
-		"&& (command -v yt-dlp >/dev/null 2>&1 || sudo python3 -m pip install --upgrade --break-system-packages yt-dlp || sudo python3 -m pip install --upgrade yt-dlp)",
-	}, " ")
-	if out, err := runSSH(client, depsCmd); err != nil {
-		fail("install_runtime", fmt.Sprintf("dependency install failed: %s; output: %s", err, out))
+	depsCmd, depsIn := plat.elevate(plat.runtimeScript(), sudo)
+	if out, err := client.Run(jobCtx, depsCmd, depsIn, s.runtimeTimeout); err != nil {
+		fail("install_runtime", fmt.Sprintf("dependency install failed: %s; output: %s", sudoError(err, out), out))
 		return
 	}
 	logLine("Runtime dependencies ready")
 
-	_ = s.store.ProvisionJobs().UpdateStatus(ctx, jobID, model.ProvisionStatusRunning, "install_service")
+	enter("install_service")
 
-	// Step 6: Install systemd service
-	logLine("Installing systemd service...")
-	unitContent := fmt.Sprintf(systemdTemplate, req.HostIP, s.masterURL)
-	installCmds := []string{
-		"sudo mv /tmp/ngoogle-agent /usr/local/bin/ngoogle-agent && sudo chmod +x /usr/local/bin/ngoogle-agent",
-		fmt.Sprintf("sudo tee /etc/systemd/system/ngoogle-agent.service > /dev/null << 'UNIT_EOF'\n%sUNIT_EOF", unitContent),
-		"sudo systemctl daemon-reload && sudo systemctl enable ngoogle-agent && sudo systemctl restart ngoogle-agent",
-	}
-	for _, cmd := range installCmds {
-		logLine("  $ " + cmd[:min(80, len(cmd))])
-		if out, err := runSSH(client, cmd); err != nil {
-			fail("install_service", fmt.Sprintf("cmd error: %s; output: %s", err, out))
+	// Step 4: Install the service with the target's service manager
+	logLine(fmt.Sprintf("Installing %s service %s...", plat.manager(), req.ServiceName))
+	for _, script := range plat.installScripts(req, s.masterURL) {
+		cmd, stdin := plat.elevate(script, sudo)
+		// Heredoc bodies (unit, token) never reach the log.
+		shown, _, _ := strings.Cut(script, "\n")
+		logLine("  $ " + shown[:min(80, len(shown))])
+		if out, err := client.Run(jobCtx, cmd, stdin, s.commandTimeout); err != nil {
+			fail("install_service", fmt.Sprintf("cmd error: %s; output: %s", sudoError(err, out), out))
 			return
 		}
 	}
 	logLine("Service installed and started")
 
-	_ = s.store.ProvisionJobs().UpdateStatus(ctx, jobID, model.ProvisionStatusRunning, "health_check")
+	enter("health_check")
 
-	// Step 7: Wait for agent to appear online (max 60s)
-	logLine("Waiting for agent to come online (max 60s)...")
-	deadline := time.Now().Add(60 * time.Second)
-	for time.Now().Before(deadline) {
+	// Step 5: Wait for agent to appear online
+	logLine(fmt.Sprintf("Waiting for agent to come online (max %s)...", s.healthTimeout))
+	healthCtx, healthCancel := context.WithTimeout(jobCtx, s.healthTimeout)
+	defer healthCancel()
+	for {
 		agents, err := s.store.Agents().List(ctx)
 		if err == nil {
 			for _, a := range agents {
@@ -219,31 +553,36 @@ func (s *Service) run(jobID string, req *JobRequest) {
 				}
 			}
 		}
-		time.Sleep(5 * time.Second)
+		select {
+		case <-healthCtx.Done():
+			fail("health_check", fmt.Sprintf("agent did not come online within %s", s.healthTimeout))
+			return
+		case <-time.After(5 * time.Second):
+		}
 	}
-	fail("health_check", "agent did not come online within 60s")
 }
 
-// Retry resets a failed provision job and re-runs it.
+// Retry resets a failed or interrupted provision job and re-runs it, queueing
+// it like a new job when the service is at its concurrency limit.
 func (s *Service) Retry(ctx context.Context, jobID string) (*model.ProvisionJob, error) {
 	job, err := s.store.ProvisionJobs().Get(ctx, jobID)
 	if err != nil {
 		return nil, err
 	}
-	if job.Status != model.ProvisionStatusFailed {
-		return nil, fmt.Errorf("only failed jobs can be retried (current: %s)", job.Status)
+	if job.Status != model.ProvisionStatusFailed && job.Status != model.ProvisionStatusInterrupted {
+		return nil, errorf(ErrConflict, "only failed or interrupted jobs can be retried (current: %s)", job.Status)
 	}
-	if err := s.store.ProvisionJobs().ResetForRetry(ctx, jobID); err != nil {
+	req, err := requestFromJob(job)
+	if err != nil {
 		return nil, err
 	}
-	req := &JobRequest{
-		HostIP:        job.HostIP,
-		SSHPort:       job.SSHPort,
-		SSHUser:       job.SSHUser,
-		AuthType:      job.AuthType,
-		CredentialRef: job.CredentialRef,
+	if err := s.ensureToken(ctx, jobID, req); err != nil {
+		return nil, err
+	}
+	if err := s.store.ProvisionJobs().ResetForRetry(ctx, jobID); err != nil {
+		return nil, err
 	}
-	go s.run(jobID, req)
+	s.launch(jobID, req)
 	job.Status = model.ProvisionStatusPending
 	job.CurrentStep = "created"
 	job.Log = ""
@@ -251,19 +590,259 @@ func (s *Service) Retry(ctx context.Context, jobID string) (*model.ProvisionJob,
 	return job, nil
 }
 
-// DeleteCredential deletes a credential by ID.
-func (s *Service) DeleteCredential(ctx context.Context, id string) error {
+// ensureToken issues a token for jobs that have none, such as jobs created
+// before tokens existed or whose token was revoked by a rotation.
+func (s *Service) ensureToken(ctx context.Context, jobID string, req *JobRequest) error {
+	if req.agentToken != "" {
+		return nil
+	}
+	req.agentToken = newToken()
+	return s.store.ProvisionJobs().SetAgentToken(ctx, jobID, req.agentToken)
+}
+
+// requestFromJob rebuilds the request a stored job was started with.
+func requestFromJob(job *model.ProvisionJob) (*JobRequest, error) {
+	req := &JobRequest{
+		HostIP:            job.HostIP,
+		SSHPort:           job.SSHPort,
+		SSHUser:           job.SSHUser,
+		AuthType:          job.AuthType,
+		CredentialRef:     job.CredentialRef,
+		SudoCredentialRef: job.SudoCredentialRef,
+		InstallDir:        job.InstallDir,
+		ServiceName:       job.ServiceName,
+		AgentEnv:          job.AgentEnv,
+		DownloadURL:       job.DownloadURL,
+		agentToken:        job.AgentToken,
+	}
+	// Jobs created before install targets were configurable have neither set.
+	if err := req.normalizeInstallTarget(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Uninstall stops and removes the agent installed by a provisioning job,
+// using the job's install dir and service name. Progress is appended to the
+// job log.
+func (s *Service) Uninstall(ctx context.Context, jobID string) error {
+	job, err := s.store.ProvisionJobs().Get(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status == model.ProvisionStatusPending || job.Status == model.ProvisionStatusRunning {
+		return errorf(ErrConflict, "cannot uninstall while the job is %s", job.Status)
+	}
+	req, err := requestFromJob(job)
+	if err != nil {
+		return err
+	}
+	logLine := func(msg string) {
+		slog.Info("provision uninstall", "job", jobID, "msg", msg)
+		_ = s.store.ProvisionJobs().AppendLog(ctx, jobID, fmt.Sprintf("[%s] %s", time.Now().Format(time.RFC3339), msg))
+	}
+
+	logLine(fmt.Sprintf("Uninstalling %s from %s:%d...", req.ServiceName, req.HostIP, req.SSHPort))
+	client, sudo, err := s.connect(ctx, req)
+	if err != nil {
+		logLine("Uninstall FAILED: " + err.Error())
+		return err
+	}
+	defer client.Close()
+
+	plat, _, err := s.detectPlatform(ctx, client)
+	if err != nil {
+		err = errorf(ErrRemote, "%s", err)
+		logLine("Uninstall FAILED: " + err.Error())
+		return err
+	}
+
+	cmd, stdin := plat.elevate(plat.removeScript(req), sudo)
+	if out, err := client.Run(ctx, cmd, stdin, s.commandTimeout); err != nil {
+		err = errorf(ErrRemote, "uninstall failed: %s; output: %s", sudoError(err, out), out)
+		logLine("Uninstall FAILED: " + err.Error())
+		return err
+	}
+	logLine("Agent service removed")
+	return nil
+}
+
+// detectPlatform identifies the target's platform and the GOARCH of the
+// agent binary it needs: with uname on Unix systems, and on Windows, which
+// has none, from the ver banner and PROCESSOR_ARCHITECTURE.
+func (s *Service) detectPlatform(ctx context.Context, client SSHRunner) (platform, string, error) {
+	out, err := client.Run(ctx, "uname -sm", "", s.commandTimeout)
+	if err == nil {
+		return parseUname(out)
+	}
+	if ctx.Err() == nil {
+		winOut, winErr := client.Run(ctx, windowsDetectCommand, "", s.commandTimeout)
+		if winErr == nil && strings.Contains(winOut, "Microsoft Windows") {
+			return parseWindowsVer(winOut)
+		}
+	}
+	return nil, "", fmt.Errorf("detect platform: %w", err)
+}
+
+// checkCredentials verifies that the credentials a request references exist
+// before a job is queued.
+func (s *Service) checkCredentials(ctx context.Context, req *JobRequest) error {
+	_, err := s.store.Credentials().Get(ctx, req.CredentialRef)
+	if errors.Is(err, ErrNotFound) {
+		return errorf(ErrNotFound, "credential %s not found", req.CredentialRef)
+	}
+	if err != nil {
+		return err
+	}
+	if req.SudoCredentialRef == "" {
+		return nil
+	}
+	sudoCred, err := s.store.Credentials().Get(ctx, req.SudoCredentialRef)
+	if errors.Is(err, ErrNotFound) {
+		return errorf(ErrNotFound, "sudo credential %s not found", req.SudoCredentialRef)
+	}
+	if err != nil {
+		return err
+	}
+	if sudoCred.Type != model.AuthTypePassword {
+		return errorf(ErrInvalidRequest, "sudo credential must be a password credential")
+	}
+	return nil
+}
+
+// connect loads the request's credentials and opens an SSH session to the
+// target. The returned sudo func wraps a command so it runs as root.
+func (s *Service) connect(ctx context.Context, req *JobRequest) (SSHRunner, func(string) (string, string), error) {
+	cred, err := s.store.Credentials().Get(ctx, req.CredentialRef)
+	if err != nil {
+		return nil, nil, fmt.Errorf("credential not found: %w", err)
+	}
+
+	// sudo password is optional; without it we assume passwordless sudo.
+	var sudoPassword string
+	if req.SudoCredentialRef != "" {
+		sudoCred, err := s.store.Credentials().Get(ctx, req.SudoCredentialRef)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sudo credential not found: %w", err)
+		}
+		if sudoCred.Type != model.AuthTypePassword {
+			return nil, nil, errorf(ErrInvalidRequest, "sudo credential must be a password credential")
+		}
+		sudoPassword = sudoCred.Payload
+	}
+	sudo := func(cmd string) (string, string) { return sudoCommand(cmd, sudoPassword) }
+
+	sshCfg, err := buildSSHConfig(req.SSHUser, cred, s.connectTimeout)
+	if err != nil {
+		return nil, nil, errorf(ErrInvalidRequest, "SSH config error: %w", err)
+	}
+	addr := fmt.Sprintf("%s:%d", req.HostIP, req.SSHPort)
+	client := s.newRunner()
+	if err := client.Dial(ctx, addr, sshCfg); err != nil {
+		return nil, nil, errorf(ErrRemote, "SSH connect failed: %w", err)
+	}
+	return client, sudo, nil
+}
+
+// DeleteCredential deletes a credential by ID. A credential that provision
+// jobs still reference, for SSH or sudo, is kept unless force is set: retrying
+// or uninstalling those jobs needs it.
+func (s *Service) DeleteCredential(ctx context.Context, id string, force bool) error {
+	if !force {
+		byCred, err := s.credentialUsers(ctx)
+		if err != nil {
+			return err
+		}
+		if users := byCred[id]; len(users) > 0 {
+			return errorf(ErrConflict, "credential %s is used by provision jobs %s; delete them first or pass force=true",
+				id, strings.Join(users, ", "))
+		}
+	}
 	return s.store.Credentials().Delete(ctx, id)
 }
 
-// ListJobs returns all provisioning jobs.
+// ListJobs returns all provisioning jobs, with the queue position and
+// estimated wait of those queued.
 func (s *Service) ListJobs(ctx context.Context) ([]*model.ProvisionJob, error) {
-	return s.store.ProvisionJobs().List(ctx)
+	jobs, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.annotateQueue(jobs, jobs)
+	return jobs, nil
 }
 
-// GetJob returns a single provisioning job.
+// GetJob returns a single provisioning job, with its queue position and
+// estimated wait if it is queued.
 func (s *Service) GetJob(ctx context.Context, id string) (*model.ProvisionJob, error) {
-	return s.store.ProvisionJobs().Get(ctx, id)
+	job, err := s.store.ProvisionJobs().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != model.ProvisionStatusPending || s.queuePosition(job.ID) == 0 {
+		return job, nil
+	}
+	history, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.annotateQueue([]*model.ProvisionJob{job}, history)
+	return job, nil
+}
+
+// queuePosition returns the 1-based place of the job in the queue, or zero
+// if it is not queued.
+func (s *Service) queuePosition(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, q := range s.queue {
+		if q.id == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// annotateQueue sets QueuePosition and EstimatedWaitSec on the queued jobs
+// among jobs. Queued jobs start in rounds of maxConcurrent, each taking
+// about as long as the median successful job in history, so the job at
+// position p waits about ceil(p/maxConcurrent) such jobs.
+func (s *Service) annotateQueue(jobs, history []*model.ProvisionJob) {
+	s.mu.Lock()
+	positions := make(map[string]int, len(s.queue))
+	for i, q := range s.queue {
+		positions[q.id] = i + 1
+	}
+	limit := s.maxConcurrent
+	s.mu.Unlock()
+	if len(positions) == 0 {
+		return
+	}
+	var installs []float64
+	for _, j := range history {
+		if j.Status != model.ProvisionStatusSuccess || len(j.StepDurations) == 0 {
+			continue
+		}
+		total := 0.0
+		for _, sec := range j.StepDurations {
+			total += sec
+		}
+		installs = append(installs, total)
+	}
+	typical := 0.0
+	if n := len(installs); n > 0 {
+		sort.Float64s(installs)
+		typical = (installs[(n-1)/2] + installs[n/2]) / 2
+	}
+	for _, j := range jobs {
+		pos := positions[j.ID]
+		if pos == 0 || j.Status != model.ProvisionStatusPending {
+			continue
+		}
+		j.QueuePosition = pos
+		rounds := (pos + limit - 1) / limit
+		j.EstimatedWaitSec = int(math.Ceil(float64(rounds) * typical))
+	}
 }
 
 // DeleteJob deletes a provisioning job record.
@@ -271,8 +850,11 @@ func (s *Service) DeleteJob(ctx context.Context, id string) error {
 	return s.store.ProvisionJobs().Delete(ctx, id)
 }
 
-// CreateCredential stores a credential.
+// CreateCredential stores a credential once its key, if it is one, parses.
 func (s *Service) CreateCredential(ctx context.Context, req *CredentialRequest) (*model.Credential, error) {
+	if err := validateCredential(req.Type, req.Payload); err != nil {
+		return nil, err
+	}
 	c := &model.Credential{
 		ID:        newID(),
 		Name:      req.Name,
@@ -283,24 +865,78 @@ func (s *Service) CreateCredential(ctx context.Context, req *CredentialRequest)
 	return c, s.store.Credentials().Create(ctx, c)
 }
 
-// ListCredentials returns all credentials.
+// UpdateCredential replaces a credential's payload, and its name and type
+// when given, keeping its ID so that jobs referencing it keep working. A key
+// must parse before it replaces the old one.
+func (s *Service) UpdateCredential(ctx context.Context, id string, req *CredentialRequest) (*model.Credential, error) {
+	c, err := s.store.Credentials().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != "" {
+		c.Name = req.Name
+	}
+	if req.Type != "" {
+		c.Type = req.Type
+	}
+	if err := validateCredential(c.Type, req.Payload); err != nil {
+		return nil, err
+	}
+	c.Payload = req.Payload
+	if err := s.store.Credentials().Update(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListCredentials returns all credentials, each with the number of
+// provision jobs that reference it.
 func (s *Service) ListCredentials(ctx context.Context) ([]*model.Credential, error) {
-	return s.store.Credentials().List(ctx)
+	creds, err := s.store.Credentials().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byCred, err := s.credentialUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range creds {
+		c.UsedBy = len(byCred[c.ID])
+	}
+	return creds, nil
+}
+
+// credentialUsers maps each referenced credential ID to the IDs of the
+// provision jobs that use it, for SSH or sudo. A job using one credential
+// for both appears once.
+func (s *Service) credentialUsers(ctx context.Context) (map[string][]string, error) {
+	jobs, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byCred := make(map[string][]string)
+	for _, j := range jobs {
+		byCred[j.CredentialRef] = append(byCred[j.CredentialRef], j.ID)
+		if j.SudoCredentialRef != "" && j.SudoCredentialRef != j.CredentialRef {
+			byCred[j.SudoCredentialRef] = append(byCred[j.SudoCredentialRef], j.ID)
+		}
+	}
+	return byCred, nil
 }
 
 // ─── SSH helpers ──────────────────────────────────────────────────────────────
 
-func buildSSHConfig(user string, cred *model.Credential) (*ssh.ClientConfig, error) {
+func buildSSHConfig(user string, cred *model.Credential, timeout time.Duration) (*ssh.ClientConfig, error) {
 	cfg := &ssh.ClientConfig{
 		User:            user,
-		Timeout:         15 * time.Second,
+		Timeout:         timeout,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec
 	}
 	switch cred.Type {
 	case model.AuthTypeKey:
-		signer, err := ssh.ParsePrivateKey([]byte(cred.Payload))
+		signer, err := parsePrivateKey(cred.Payload)
 		if err != nil {
-			return nil, fmt.Errorf("parse private key: %w", err)
+			return nil, err
 		}
 		cfg.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
 	case model.AuthTypePassword:
@@ -311,27 +947,50 @@ func buildSSHConfig(user string, cred *model.Credential) (*ssh.ClientConfig, err
 	return cfg, nil
 }
 
-func runSSH(client *ssh.Client, cmd string) (string, error) {
-	sess, err := client.NewSession()
-	if err != nil {
-		return "", err
+// sudoCommand wraps cmd so it runs as root. With a password, sudo reads it from
+// stdin (-S) and the returned stdin must be fed to the session; the command
+// runs under sh -c so a single prompt covers the whole pipeline.
+func sudoCommand(cmd, password string) (string, string) {
+	if password == "" {
+		return "sudo sh -c " + shellQuote(cmd), ""
 	}
-	defer sess.Close()
-	var buf bytes.Buffer
-	sess.Stdout = &buf
-	sess.Stderr = &buf
-	return buf.String(), sess.Run(cmd)
+	return "sudo -S -p '' sh -c " + shellQuote(cmd), password + "\n"
 }
 
-// mapArch converts uname -m output to Go GOARCH names.
-func mapArch(uname string) string {
+// sudoError replaces the generic exit error with actionable advice when sudo
+// refused to run without a password.
+func sudoError(err error, out string) error {
+	if strings.Contains(out, "a password is required") || strings.Contains(out, "a terminal is required") {
+		return fmt.Errorf("sudo requires a password on this host; set sudo_credential_ref to a password credential")
+	}
+	if strings.Contains(out, "incorrect password") {
+		return fmt.Errorf("sudo rejected the supplied password")
+	}
+	return err
+}
+
+// shellQuote single-quotes s for POSIX sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// mapArch converts uname -m output to the Go GOARCH name of the agent
+// binary to download. Unknown machines are an error rather than a guess, as
+// a binary for the wrong architecture only fails once the service starts.
+func mapArch(uname string) (string, error) {
 	switch uname {
-	case "x86_64":
-		return "amd64"
+	case "x86_64", "amd64":
+		return "amd64", nil
 	case "aarch64", "arm64":
-		return "arm64"
+		return "arm64", nil
+	case "armv7l", "armhf":
+		return "arm", nil
+	case "i686", "i386":
+		return "386", nil
+	case "riscv64":
+		return "riscv64", nil
 	default:
-		return "amd64"
+		return "", fmt.Errorf("unsupported architecture: %s", uname)
 	}
 }
 
@@ -343,10 +1002,11 @@ After=network.target
 
 [Service]
 Type=simple
-ExecStart=/usr/local/bin/ngoogle-agent
+ExecStart=%s
+EnvironmentFile=%s
 Environment=AGENT_HOST_IP=%s
 Environment=MASTER_URL=%s
-Restart=on-failure
+%sRestart=on-failure
 RestartSec=5
 StandardOutput=journal
 StandardError=journal
@@ -355,6 +1015,26 @@ StandardError=journal
 WantedBy=multi-user.target
 `
 
+// renderEnvironment renders env as systemd Environment= lines in key order.
+// Each assignment is double-quoted with backslashes, quotes and % specifiers
+// escaped so values are passed through verbatim.
+func renderEnvironment(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(`Environment="`)
+		b.WriteString(unitEscaper.Replace(k + "=" + env[k]))
+		b.WriteString("\"\n")
+	}
+	return b.String()
+}
+
+var unitEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%")
+
 // ─── Misc helpers ─────────────────────────────────────────────────────────────
 
 func newID() string {
@@ -363,6 +1043,12 @@ func newID() string {
 	return hex.EncodeToString(b)
 }
 
+func newToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,95 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHRunner is the remote shell used by a provisioning job.
+// The real implementation wraps golang.org/x/crypto/ssh; tests substitute a fake.
+type SSHRunner interface {
+	// Dial connects and authenticates to addr.
+	Dial(ctx context.Context, addr string, cfg *ssh.ClientConfig) error
+	// Run executes cmd, feeding it stdin, and returns its combined stdout/stderr.
+	// The command is aborted when timeout elapses or ctx is done.
+	Run(ctx context.Context, cmd, stdin string, timeout time.Duration) (string, error)
+	// Close releases the connection.
+	Close() error
+}
+
+// sshRunner implements SSHRunner over a real SSH connection.
+type sshRunner struct {
+	client *ssh.Client
+}
+
+// Dial connects and performs the SSH handshake, honouring ctx cancellation.
+func (r *sshRunner) Dial(ctx context.Context, addr string, cfg *ssh.ClientConfig) error {
+	d := net.Dialer{Timeout: cfg.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	// Clear the handshake deadline; per-command timeouts take over from here.
+	_ = conn.SetDeadline(time.Time{})
+	r.client = ssh.NewClient(c, chans, reqs)
+	return nil
+}
+
+// Run runs cmd in a new session and returns its combined output.
+func (r *sshRunner) Run(ctx context.Context, cmd, stdin string, timeout time.Duration) (string, error) {
+	if r.client == nil {
+		return "", fmt.Errorf("ssh: not connected")
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	sess, err := r.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+	if stdin != "" {
+		sess.Stdin = strings.NewReader(stdin)
+	}
+
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := sess.CombinedOutput(cmd)
+		done <- result{out, err}
+	}()
+	select {
+	case res := <-done:
+		return string(res.out), res.err
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGKILL)
+		_ = sess.Close()
+		return "", fmt.Errorf("command timed out after %s: %w", timeout, ctx.Err())
+	}
+}
+
+// Close closes the underlying SSH client.
+func (r *sshRunner) Close() error {
+	if r.client == nil {
+		return nil
+	}
+	return r.client.Close()
+}
@@ -0,0 +1,166 @@
+package provision
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// fakeSSHServer is a minimal in-process SSH server. handle decides the output
+// and exit status for each exec request; returning hang=true blocks the command
+// until the client closes the session.
+type fakeSSHServer struct {
+	ln     net.Listener
+	cfg    *ssh.ServerConfig
+	handle func(cmd string) (out string, status uint32, hang bool)
+}
+
+func newFakeSSHServer(t *testing.T, handle func(cmd string) (string, uint32, bool)) *fakeSSHServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	cfg.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeSSHServer{ln: ln, cfg: cfg, handle: handle}
+	go srv.serve()
+	t.Cleanup(func() { ln.Close() })
+	return srv
+}
+
+func (f *fakeSSHServer) port() int { return f.ln.Addr().(*net.TCPAddr).Port }
+
+func (f *fakeSSHServer) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, f.cfg)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for nc := range chans {
+				ch, chReqs, err := nc.Accept()
+				if err != nil {
+					continue
+				}
+				go f.session(ch, chReqs)
+			}
+		}()
+	}
+}
+
+func (f *fakeSSHServer) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		// payload is a uint32 length-prefixed string
+		cmd := string(req.Payload[4:])
+		_ = req.Reply(true, nil)
+		out, status, hang := f.handle(cmd)
+		if hang {
+			// Block until the client closes the channel.
+			for r := range reqs {
+				_ = r.Reply(false, nil)
+			}
+			return
+		}
+		_, _ = io.WriteString(ch, out)
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, status)
+		_, _ = ch.SendRequest("exit-status", false, payload)
+		return
+	}
+}
+
+func TestRunFailsWhenDownloadHangs(t *testing.T) {
+	srv := newFakeSSHServer(t, func(cmd string) (string, uint32, bool) {
+		if cmd == "uname -sm" {
+			return "Linux x86_64\n", 0, false
+		}
+		if strings.Contains(cmd, "wget") {
+			return "", 0, true
+		}
+		return "", 0, false
+	})
+	svc, st := newTestService(t)
+	svc.downloadTimeout = 200 * time.Millisecond
+	job, req := createJob(t, st, srv.port())
+
+	done := make(chan struct{})
+	go func() {
+		svc.run(job.ID, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("provision run did not time out")
+	}
+
+	got, err := st.ProvisionJobs().Get(context.Background(), job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != model.ProvisionStatusFailed {
+		t.Fatalf("expected failed, got %s", got.Status)
+	}
+	if got.FailedStep != "download_binary" {
+		t.Fatalf("expected failed step download_binary, got %s", got.FailedStep)
+	}
+	if !strings.Contains(got.Log, "timed out") {
+		t.Fatalf("expected timeout in log, got %q", got.Log)
+	}
+}
+
+func TestRunFailsAfterJobDeadline(t *testing.T) {
+	srv := newFakeSSHServer(t, func(cmd string) (string, uint32, bool) {
+		if cmd == "uname -sm" {
+			return "Linux x86_64\n", 0, false
+		}
+		return "", 0, true
+	})
+	svc, st := newTestService(t)
+	svc.jobTimeout = 300 * time.Millisecond
+	job, req := createJob(t, st, srv.port())
+
+	svc.run(job.ID, req)
+
+	got, err := st.ProvisionJobs().Get(context.Background(), job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != model.ProvisionStatusFailed {
+		t.Fatalf("expected failed, got %s", got.Status)
+	}
+	if !strings.Contains(got.Log, "job timed out") {
+		t.Fatalf("expected job timeout in log, got %q", got.Log)
+	}
+}
@@ -0,0 +1,179 @@
+// Package rpcserver serves the agent API over gRPC (see pkg/agentrpc), next
+// to the REST handlers and backed by the same services.
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/pkg/agentrpc"
+
+	_ "google.golang.org/grpc/encoding/gzip" // agents compress their calls
+)
+
+// Server implements agentrpc.AgentServiceServer.
+type Server struct {
+	agents *service.AgentService
+	tasks  *service.TaskService
+}
+
+// New creates a Server.
+func New(agents *service.AgentService, tasks *service.TaskService) *Server {
+	return &Server{agents: agents, tasks: tasks}
+}
+
+var _ agentrpc.AgentServiceServer = (*Server)(nil)
+
+// Register registers an agent, like POST /api/v1/agents/register.
+func (s *Server) Register(ctx context.Context, req *agentrpc.RegisterRequest) (*agentrpc.RegisterResponse, error) {
+	req.RemoteIP = remoteIP(ctx)
+	agent, err := s.agents.Register(ctx, req)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	pull, heartbeat := s.agents.Intervals()
+	return &agentrpc.RegisterResponse{
+		Agent:                agent,
+		Token:                agent.Token,
+		PullIntervalSec:      int(pull / time.Second),
+		HeartbeatIntervalSec: int(heartbeat / time.Second),
+	}, nil
+}
+
+// Heartbeat records an agent heartbeat, like POST /api/v1/agents/heartbeat.
+func (s *Server) Heartbeat(ctx context.Context, req *agentrpc.HeartbeatRequest) (*agentrpc.Empty, error) {
+	if err := s.agents.ValidateToken(ctx, req.AgentID, req.Token); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if err := s.agents.Heartbeat(ctx, req.AgentID, req.AgentRates, req.RunningTasks); err != nil {
+		return nil, statusErr(err)
+	}
+	return &agentrpc.Empty{}, nil
+}
+
+// PullTasks returns an agent's tasks, like GET
+// /api/v1/agents/{agent_id}/tasks/pull.
+func (s *Server) PullTasks(ctx context.Context, req *agentrpc.PullRequest) (*agentrpc.TaskList, error) {
+	if req.WaitMillis < 0 {
+		return nil, status.Error(codes.InvalidArgument, "wait_ms must not be negative")
+	}
+	if req.WaitMillis > 0 {
+		s.tasks.WaitForTasks(ctx, req.AgentID, req.Since, time.Duration(req.WaitMillis)*time.Millisecond)
+	}
+	version := s.tasks.TasksVersion(req.AgentID)
+	tasks, err := s.tasks.PullTasks(ctx, req.AgentID)
+	if err != nil {
+		return nil, statusErr(err)
+	}
+	return &agentrpc.TaskList{Tasks: tasks, Version: version}, nil
+}
+
+// StreamTasks streams an agent's task list, like GET
+// /api/v1/agents/{agent_id}/tasks/stream.
+func (s *Server) StreamTasks(req *agentrpc.StreamRequest, stream agentrpc.TaskStream) error {
+	err := s.tasks.WatchTasks(stream.Context(), req.AgentID, func(version string, tasks []*model.Task) error {
+		return stream.Send(&agentrpc.TaskList{Tasks: tasks, Version: version})
+	})
+	if err != nil && stream.Context().Err() == nil {
+		return statusErr(err)
+	}
+	return nil
+}
+
+// ReportMetrics records a metrics sample, like POST /api/v1/tasks/{id}/metrics.
+func (s *Server) ReportMetrics(ctx context.Context, m *model.TaskMetrics) (*agentrpc.Empty, error) {
+	if err := s.authAgent(ctx, m.AgentID); err != nil {
+		return nil, err
+	}
+	if err := s.tasks.RecordMetrics(ctx, m); err != nil {
+		return nil, statusErr(err)
+	}
+	return &agentrpc.Empty{}, nil
+}
+
+// ReportResult records an agent's final result for a task, like
+// POST /api/v1/tasks/{id}/result.
+func (s *Server) ReportResult(ctx context.Context, r *model.AgentTaskResult) (*agentrpc.Empty, error) {
+	if err := s.authAgent(ctx, r.AgentID); err != nil {
+		return nil, err
+	}
+	return &agentrpc.Empty{}, statusErr(s.tasks.RecordAgentResult(ctx, r))
+}
+
+// authAgent checks that the call carries agentID's token, which agents send
+// as a bearer token in the authorization metadata.
+func (s *Server) authAgent(ctx context.Context, agentID string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get(agentrpc.AuthMetadataKey); len(v) > 0 {
+		token, _ = strings.CutPrefix(v[0], "Bearer ")
+	}
+	if agentID == "" || token == "" || s.agents.ValidateToken(ctx, agentID, token) != nil {
+		return status.Error(codes.Unauthenticated, "invalid agent token")
+	}
+	return nil
+}
+
+// MarkRunning marks a task as running, like POST /api/v1/tasks/{id}/run.
+func (s *Server) MarkRunning(ctx context.Context, req *agentrpc.TaskRequest) (*agentrpc.Empty, error) {
+	return &agentrpc.Empty{}, statusErr(s.tasks.MarkRunning(ctx, req.TaskID))
+}
+
+// MarkDone marks a task as done, like POST /api/v1/tasks/{id}/done.
+func (s *Server) MarkDone(ctx context.Context, req *agentrpc.TaskRequest) (*agentrpc.Empty, error) {
+	return &agentrpc.Empty{}, statusErr(s.tasks.MarkDone(ctx, req.TaskID))
+}
+
+// MarkFailed marks a task as failed, like POST /api/v1/tasks/{id}/fail.
+func (s *Server) MarkFailed(ctx context.Context, req *agentrpc.TaskRequest) (*agentrpc.Empty, error) {
+	return &agentrpc.Empty{}, statusErr(s.tasks.MarkFailed(ctx, req.TaskID, req.Reason))
+}
+
+// statusErr maps a service or store error to the gRPC status agents should
+// see, matching the REST handlers' status codes. It returns nil for nil.
+func statusErr(err error) error {
+	var code codes.Code
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, service.ErrInvalidInput):
+		code = codes.InvalidArgument
+	case errors.Is(err, service.ErrInvalidAgentToken), errors.Is(err, service.ErrRegistrationDenied):
+		code = codes.Unauthenticated
+	case errors.Is(err, service.ErrNotAssigned):
+		code = codes.PermissionDenied
+	case errors.Is(err, store.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, store.ErrConflict):
+		code = codes.FailedPrecondition
+	case errors.Is(err, service.ErrThrottled):
+		code = codes.ResourceExhausted
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+// remoteIP returns the address of the calling agent.
+func remoteIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
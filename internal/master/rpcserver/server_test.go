@@ -0,0 +1,111 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+	"github.com/aven/ngoogle/pkg/agentrpc"
+	"github.com/aven/ngoogle/pkg/masterclient"
+)
+
+func TestAgentCallsOverGRPC(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agentSvc, taskSvc := service.NewAgentService(st), service.NewTaskService(st)
+
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	agentrpc.RegisterAgentServiceServer(gs, New(agentSvc, taskSvc))
+	go gs.Serve(lis)
+	defer gs.Stop()
+	c, err := agentrpc.Dial("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	reg, err := c.RegisterAgent(ctx, &agentrpc.RegisterRequest{Hostname: "h1", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if reg.ID == "" || reg.Token == "" || reg.PullIntervalSec != 5 {
+		t.Fatalf("unexpected register response %+v", reg)
+	}
+	if err := c.Heartbeat(ctx, reg.ID, reg.Token, model.AgentRates{RateMbps: 10}, nil); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if err := c.Heartbeat(ctx, reg.ID, "wrong", model.AgentRates{RateMbps: 10}, nil); !errors.Is(err, masterclient.ErrUnauthorized) {
+		t.Fatalf("heartbeat with wrong token: got %v, want ErrUnauthorized", err)
+	}
+	if err := c.MarkTaskRunning(ctx, "missing"); !errors.Is(err, masterclient.ErrNotFound) {
+		t.Fatalf("mark unknown task running: got %v, want ErrNotFound", err)
+	}
+
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", AgentID: reg.ID, Type: model.TaskTypeYoutube, TargetURL: "https://youtu.be/example",
+		Status: model.TaskStatusPending, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	lists := make(chan []*model.Task, 8)
+	go c.StreamTasks(ctx, reg.ID, time.Minute, func(tasks []*model.Task) { lists <- tasks })
+	next := func() []*model.Task {
+		t.Helper()
+		select {
+		case l := <-lists:
+			return l
+		case <-time.After(5 * time.Second):
+			t.Fatal("no task list streamed")
+			return nil
+		}
+	}
+	if l := next(); len(l) != 0 {
+		t.Fatalf("initial list = %d tasks, want 0", len(l))
+	}
+	if err := taskSvc.Dispatch(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if l := next(); len(l) != 1 || l[0].ID != task.ID {
+		t.Fatalf("after dispatch got %+v, want t1", l)
+	}
+
+	tasks, version, err := c.PullTasksWait(ctx, reg.ID, "", time.Second)
+	if err != nil || len(tasks) != 1 || version == "" {
+		t.Fatalf("pull: %d tasks, version %q, err %v", len(tasks), version, err)
+	}
+	if err := c.MarkTaskRunning(ctx, task.ID); err != nil {
+		t.Fatalf("mark running: %v", err)
+	}
+	report := &model.TaskMetrics{TaskID: task.ID, AgentID: reg.ID, BytesTotal: 100, RecordedAt: time.Now()}
+	if err := c.ReportTaskMetrics(ctx, "forged", report); !errors.Is(err, masterclient.ErrUnauthorized) {
+		t.Fatalf("report metrics with a forged token: %v, want unauthorized", err)
+	}
+	if err := c.ReportTaskMetrics(ctx, reg.Token, report); err != nil {
+		t.Fatalf("report metrics: %v", err)
+	}
+	if err := c.MarkTaskDone(ctx, task.ID); err != nil {
+		t.Fatalf("mark done: %v", err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.Status != model.TaskStatusDone {
+		t.Fatalf("status = %s, want done", got.Status)
+	}
+}
@@ -0,0 +1,7 @@
+package scheduler
+
+import "time"
+
+// SetAgentTimeout overrides how long agents may stay silent, or keep a task
+// unreported, before the scheduler acts.
+func SetAgentTimeout(s *Scheduler, d time.Duration) { s.agentTimeout = d }
@@ -1,109 +1,419 @@
-// Package scheduler handles task lifecycle: scheduling, dispatching, stopping.
+// Package scheduler drives the master side of the task lifecycle.
+//
+// The scheduler only moves task records between states: it dispatches tasks
+// whose time window has opened and stops tasks whose window, duration or
+// byte target is exhausted. It never runs or cancels work itself. Agents
+// pull dispatched and running tasks, execute them, report when they start
+// one, which marks it running, and cancel any task that drops out of their
+// pull list, so a state change here is how a task is stopped. Paused tasks
+// stay in the pull list so that agents keep their progress; time spent
+// paused does not count towards a task's duration. The rate curves in this
+// package are shared with the agent executors, which apply them while a
+// task runs.
 package scheduler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"math"
 	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/aven/ngoogle/internal/model"
 	"github.com/aven/ngoogle/internal/store"
 )
 
+var tracer = otel.Tracer("github.com/aven/ngoogle/internal/master/scheduler")
+
 // Scheduler watches pending tasks and dispatches them according to their time windows.
 type Scheduler struct {
-	store  store.Store
-	mu     sync.Mutex
-	active map[string]context.CancelFunc // taskID → cancel
+	store store.Store
+
+	// agentTimeout is how long an agent may go without a heartbeat before
+	// its tasks are reconciled. It matches the master's offline detection.
+	agentTimeout time.Duration
+
+	// OnFinish, when set, is called after the scheduler moves a task to a
+	// terminal status, with the reason it ended.
+	OnFinish func(ctx context.Context, taskID, reason string) error
+
+	// OnChange, when set, is called after the scheduler changes a task's
+	// status, so that agents waiting on their task list can be woken.
+	OnChange func(t *model.Task)
+
+	mu sync.Mutex
+	// maintenance is the ID of the maintenance window the last tick found
+	// active, to log when one begins and ends.
+	maintenance string
+	// missing tracks running tasks their agent reports it is not running,
+	// by task ID; see ReconcileAgent.
+	missing map[string]*missingTask
+}
+
+// missingTask is a running task its agent has not reported running since.
+type missingTask struct {
+	agentID string
+	since   time.Time
+	// redispatched is set once the agent has been sent its task list again.
+	redispatched bool
 }
 
 // New creates a new Scheduler.
 func New(st store.Store) *Scheduler {
 	return &Scheduler{
-		store:  st,
-		active: make(map[string]context.CancelFunc),
+		store:        st,
+		agentTimeout: 30 * time.Second,
+		missing:      make(map[string]*missingTask),
 	}
 }
 
-// Run starts the scheduling loop, blocking until ctx is done.
+// Run starts the scheduling loop, blocking until ctx is done. One heartbeat
+// window after start, once live agents have had a chance to check in, it
+// reconciles tasks left behind by the previous master process.
 func (s *Scheduler) Run(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
+	reconcile := time.NewTimer(s.agentTimeout)
+	defer reconcile.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-reconcile.C:
+			s.Reconcile(ctx)
 		case <-ticker.C:
-			s.tick(ctx)
+			s.Tick(ctx)
+		}
+	}
+}
+
+// Reconcile checks every dispatched or running task against its assigned
+// agent so that tasks orphaned by a master crash do not stay active forever:
+//
+//   - global and agent-group tasks, and tasks whose agent has sent a recent
+//     heartbeat, are kept;
+//   - dispatched tasks whose agent is silent are re-queued to pending;
+//   - running tasks whose agent is silent, and tasks whose agent no longer
+//     exists, are failed.
+func (s *Scheduler) Reconcile(ctx context.Context) {
+	tasks, err := s.store.Tasks().List(ctx)
+	if err != nil {
+		slog.Error("scheduler reconcile list tasks", "err", err)
+		return
+	}
+	threshold := time.Now().Add(-s.agentTimeout)
+	for _, t := range tasks {
+		if t.Status != model.TaskStatusDispatched && t.Status != model.TaskStatusRunning {
+			continue
+		}
+		log := slog.With("task", t.ID, "status", t.Status, "agent", t.AgentID)
+		if t.ExecutionScope == model.TaskExecutionScopeGlobal || t.ExecutionScope == model.TaskExecutionScopeAgentGroup {
+			log.Info("scheduler reconcile: keep shared task", "scope", t.ExecutionScope)
+			continue
+		}
+		agent, err := s.store.Agents().Get(ctx, t.AgentID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			log.Warn("scheduler reconcile: fail task, agent no longer exists")
+			s.markFailed(ctx, t, fmt.Sprintf("agent %s no longer exists", t.AgentID))
+		case err != nil:
+			log.Error("scheduler reconcile get agent", "err", err)
+		case agent.LastHeartbeat.After(threshold):
+			log.Info("scheduler reconcile: keep task, agent is alive")
+		case t.Status == model.TaskStatusDispatched:
+			log.Warn("scheduler reconcile: re-queue task, agent is silent", "last_heartbeat", agent.LastHeartbeat)
+			s.requeue(ctx, t)
+		default:
+			log.Warn("scheduler reconcile: fail task, agent is silent", "last_heartbeat", agent.LastHeartbeat)
+			s.markFailed(ctx, t, fmt.Sprintf("agent %s stopped reporting while the master was down", t.AgentID))
+		}
+	}
+}
+
+// ReconcileAgent compares the running tasks assigned to an agent with the
+// task IDs the agent reported running in a heartbeat. Agents start every
+// task in their task list, so a task may be missing for a moment after it
+// starts; only one missing for longer than the agent timeout is acted on.
+// The agent is first sent its task list again, which makes it start the
+// task; if the task is still missing another agent timeout later, it is
+// failed. Global and agent-group tasks are not checked.
+func (s *Scheduler) ReconcileAgent(ctx context.Context, agentID string, running []string) {
+	tasks, err := s.store.Tasks().ListByAgent(ctx, agentID, []model.TaskStatus{model.TaskStatusRunning})
+	if err != nil {
+		slog.Error("scheduler reconcile agent list tasks", "agent", agentID, "err", err)
+		return
+	}
+	reported := make(map[string]bool, len(running))
+	for _, id := range running {
+		reported[id] = true
+	}
+	now := time.Now()
+	var redispatch, fail []*model.Task
+	s.mu.Lock()
+	for id, m := range s.missing {
+		// Forget tasks that have since stopped running on the agent.
+		if m.agentID == agentID && !slices.ContainsFunc(tasks, func(t *model.Task) bool { return t.ID == id }) {
+			delete(s.missing, id)
+		}
+	}
+	for _, t := range tasks {
+		shared := t.ExecutionScope == model.TaskExecutionScopeGlobal || t.ExecutionScope == model.TaskExecutionScopeAgentGroup
+		if shared || reported[t.ID] {
+			delete(s.missing, t.ID)
+			continue
+		}
+		m, ok := s.missing[t.ID]
+		if !ok {
+			s.missing[t.ID] = &missingTask{agentID: agentID, since: now}
+			continue
+		}
+		if now.Sub(m.since) < s.agentTimeout {
+			continue
+		}
+		if m.redispatched {
+			delete(s.missing, t.ID)
+			fail = append(fail, t)
+		} else {
+			m.since, m.redispatched = now, true
+			redispatch = append(redispatch, t)
 		}
 	}
+	s.mu.Unlock()
+
+	for _, t := range redispatch {
+		slog.Warn("scheduler: agent is not running task, re-dispatching", "task", t.ID, "agent", agentID)
+		s.changed(t)
+	}
+	for _, t := range fail {
+		slog.Warn("scheduler: agent is still not running task, failing it", "task", t.ID, "agent", agentID)
+		s.markFailed(ctx, t, fmt.Sprintf("agent %s is not running the task", agentID))
+	}
 }
 
-func (s *Scheduler) tick(ctx context.Context) {
+// Tick runs one scheduling pass: pending tasks whose start time has come
+// are dispatched, or failed if their window has already passed. While a
+// maintenance window is active, pending tasks are left pending and start on
+// the first tick after it ends. Running tasks that have reached their byte
+// or request target are marked done, and those that have reached their end
+// time or duration are marked stopped. Paused tasks are only stopped once
+// their end time passes, since end_at is a wall-clock deadline.
+//
+// A dispatched task becomes running when an agent reports starting it, so
+// that its duration counts from when it actually runs. If no agent has done
+// so within the agent timeout, the task is marked running anyway, as it
+// would be if the agent's report were lost; ReconcileAgent then catches a
+// task its agent is not running.
+func (s *Scheduler) Tick(ctx context.Context) {
 	tasks, err := s.store.Tasks().List(ctx)
 	if err != nil {
 		slog.Error("scheduler list tasks", "err", err)
 		return
 	}
 	now := time.Now()
+	maintenance, ok := s.maintenanceAt(ctx, now)
 	for _, t := range tasks {
 		switch t.Status {
-		case model.TaskStatusPending, model.TaskStatusDispatched:
-			if shouldStart(t, now) {
+		case model.TaskStatusPending:
+			if t.MissedWindow(now) {
+				slog.Warn("scheduler: task missed its start window", "task", t.ID)
+				s.markFailed(ctx, t, "start window missed")
+			} else if ok && shouldStart(t, now, maintenance) {
+				s.dispatch(ctx, t)
+			}
+		case model.TaskStatusDispatched:
+			if t.MissedWindow(now) {
+				slog.Warn("scheduler: task missed its start window", "task", t.ID)
+				s.markFailed(ctx, t, "start window missed")
+			} else if t.DispatchedAt == nil || now.Sub(*t.DispatchedAt) >= s.agentTimeout {
+				slog.Warn("scheduler: no agent reported starting task, marking it running", "task", t.ID, "agent", t.AgentID)
 				s.markRunning(ctx, t)
 			}
 		case model.TaskStatusRunning:
-			if shouldStop(t, now) {
-				s.markStopped(ctx, t)
+			switch status, reason := s.endReason(ctx, t, now); status {
+			case model.TaskStatusDone:
+				s.markDone(ctx, t, reason)
+			case model.TaskStatusStopped:
+				s.markStopped(ctx, t, reason)
+			}
+		case model.TaskStatusPaused:
+			if t.EndAt != nil && now.After(*t.EndAt) {
+				s.markStopped(ctx, t, "end time reached")
 			}
 		}
 	}
 }
 
-func shouldStart(t *model.Task, now time.Time) bool {
+// shouldStart reports whether pending task t is due to start at now. No
+// task starts during the maintenance window, if one is active.
+func shouldStart(t *model.Task, now time.Time, maintenance *model.MaintenanceWindow) bool {
+	if maintenance != nil {
+		return false
+	}
 	if t.StartAt != nil && now.Before(*t.StartAt) {
 		return false
 	}
 	return true
 }
 
-func shouldStop(t *model.Task, now time.Time) bool {
-	if t.EndAt != nil && now.After(*t.EndAt) {
-		return true
+// maintenanceAt returns the maintenance window active at now, or nil. If
+// the windows cannot be read it returns false, and no task is started.
+func (s *Scheduler) maintenanceAt(ctx context.Context, now time.Time) (*model.MaintenanceWindow, bool) {
+	windows, err := s.store.MaintenanceWindows().List(ctx)
+	if err != nil {
+		slog.Error("scheduler list maintenance windows", "err", err)
+		return nil, false
 	}
-	if t.DurationSec > 0 && t.StartedAt != nil && now.Sub(*t.StartedAt) > time.Duration(t.DurationSec)*time.Second {
-		return true
+	w, until := model.ActiveMaintenance(windows, now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case w != nil && w.ID != s.maintenance:
+		slog.Info("scheduler: maintenance window active, deferring task starts", "window", w.ID, "name", w.Name, "until", until)
+		s.maintenance = w.ID
+	case w == nil && s.maintenance != "":
+		slog.Info("scheduler: maintenance window ended, starting tasks", "window", s.maintenance)
+		s.maintenance = ""
 	}
+	return w, true
+}
+
+// endReason reports whether a running task should end now, and why: it is
+// done once it has reached its byte or request target, and stopped once its
+// end time or duration is up. A task that keeps running gets "", "".
+func (s *Scheduler) endReason(ctx context.Context, t *model.Task, now time.Time) (model.TaskStatus, string) {
 	if t.TotalBytesTarget > 0 && t.TotalBytesDone >= t.TotalBytesTarget {
-		return true
+		return model.TaskStatusDone, "byte target reached"
+	}
+	if t.TotalRequestsTarget > 0 && s.requestsDone(ctx, t) >= t.TotalRequestsTarget {
+		return model.TaskStatusDone, "request target reached"
+	}
+	if t.EndAt != nil && now.After(*t.EndAt) {
+		return model.TaskStatusStopped, "end time reached"
+	}
+	if t.DurationSec > 0 && t.ActiveDuration(now) > time.Duration(t.DurationSec)*time.Second {
+		return model.TaskStatusStopped, "duration reached"
+	}
+	return "", ""
+}
+
+// requestsDone returns the requests t's agents have reported in total, or 0
+// if their metrics cannot be read.
+func (s *Scheduler) requestsDone(ctx context.Context, t *model.Task) int64 {
+	snapshots, err := s.store.TaskMetrics().LatestByTaskAgents(ctx, t.ID)
+	if err != nil {
+		slog.Error("scheduler task metrics", "task", t.ID, "err", err)
+		return 0
 	}
-	return false
+	var n int64
+	for _, m := range snapshots {
+		n += m.RequestCount
+	}
+	return n
+}
+
+// startSpan starts the span of a status change the scheduler makes to t.
+// The hooks it calls run under the span.
+func startSpan(ctx context.Context, name string, t *model.Task, reason string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("task_id", t.ID),
+		attribute.String("task_status", string(t.Status)),
+	}
+	if reason != "" {
+		attrs = append(attrs, attribute.String("reason", reason))
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
 }
 
 func (s *Scheduler) markRunning(ctx context.Context, t *model.Task) {
+	ctx, span := startSpan(ctx, "scheduler.start_task", t, "")
+	defer span.End()
 	now := time.Now()
 	if err := s.store.Tasks().UpdateStatusWithTime(ctx, t.ID, model.TaskStatusRunning, now, "started_at"); err != nil {
 		slog.Error("scheduler mark running", "task", t.ID, "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
 	}
+	s.changed(t)
 }
 
-func (s *Scheduler) markStopped(ctx context.Context, t *model.Task) {
-	now := time.Now()
-	if err := s.store.Tasks().UpdateStatusWithTime(ctx, t.ID, model.TaskStatusStopped, now, "finished_at"); err != nil {
-		slog.Error("scheduler mark stopped", "task", t.ID, "err", err)
+func (s *Scheduler) dispatch(ctx context.Context, t *model.Task) {
+	ctx, span := startSpan(ctx, "scheduler.dispatch_task", t, "")
+	defer span.End()
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, t.ID, model.TaskStatusDispatched, time.Now(), "dispatched_at"); err != nil {
+		slog.Error("scheduler dispatch", "task", t.ID, "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
 	}
+	s.changed(t)
 }
 
-// Stop requests cancellation for a given task.
-func (s *Scheduler) Stop(taskID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if cancel, ok := s.active[taskID]; ok {
-		cancel()
-		delete(s.active, taskID)
+func (s *Scheduler) requeue(ctx context.Context, t *model.Task) {
+	ctx, span := startSpan(ctx, "scheduler.requeue_task", t, "")
+	defer span.End()
+	if err := s.store.Tasks().UpdateStatus(ctx, t.ID, model.TaskStatusPending); err != nil {
+		slog.Error("scheduler reconcile re-queue", "task", t.ID, "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	s.changed(t)
+}
+
+func (s *Scheduler) markStopped(ctx context.Context, t *model.Task, reason string) {
+	s.markEnded(ctx, "scheduler.stop_task", t, model.TaskStatusStopped, reason)
+}
+
+func (s *Scheduler) markDone(ctx context.Context, t *model.Task, reason string) {
+	s.markEnded(ctx, "scheduler.complete_task", t, model.TaskStatusDone, reason)
+}
+
+// markEnded moves t to the terminal status, under a span named name.
+func (s *Scheduler) markEnded(ctx context.Context, name string, t *model.Task, status model.TaskStatus, reason string) {
+	ctx, span := startSpan(ctx, name, t, reason)
+	defer span.End()
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, t.ID, status, time.Now(), "finished_at"); err != nil {
+		slog.Error("scheduler mark "+string(status), "task", t.ID, "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	s.finished(ctx, t, reason)
+}
+
+func (s *Scheduler) markFailed(ctx context.Context, t *model.Task, reason string) {
+	ctx, span := startSpan(ctx, "scheduler.fail_task", t, reason)
+	defer span.End()
+	if err := s.store.Tasks().SetError(ctx, t.ID, reason); err != nil {
+		slog.Error("scheduler set error", "task", t.ID, "err", err)
+	}
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, t.ID, model.TaskStatusFailed, time.Now(), "finished_at"); err != nil {
+		slog.Error("scheduler mark failed", "task", t.ID, "err", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	s.finished(ctx, t, reason)
+}
+
+func (s *Scheduler) changed(t *model.Task) {
+	if s.OnChange != nil {
+		s.OnChange(t)
+	}
+}
+
+func (s *Scheduler) finished(ctx context.Context, t *model.Task, reason string) {
+	s.changed(t)
+	if s.OnFinish == nil {
+		return
+	}
+	if err := s.OnFinish(ctx, t.ID, reason); err != nil {
+		slog.Error("scheduler on finish", "task", t.ID, "err", err)
 	}
 }
 
@@ -118,20 +428,62 @@ type ProfilePoint struct {
 // RateForTask computes the target rate multiplier [0,1] at the given elapsed time
 // based on the task's distribution and profile.
 func RateForTask(t *model.Task, elapsed time.Duration, points []ProfilePoint) float64 {
+	return RateForTaskAt(t, time.Now(), elapsed, points)
+}
+
+// RateForTaskAt is RateForTask evaluated at wall-clock time now.
+//
+// Diurnal tasks with a Timezone follow the local time of day in that zone:
+// profile point offsets are seconds since local midnight, and without points
+// the built-in wall-clock curve is read in that zone. Without a Timezone,
+// point offsets are seconds since the task started and the built-in curve
+// uses the host's local time.
+func RateForTaskAt(t *model.Task, now time.Time, elapsed time.Duration, points []ProfilePoint) float64 {
 	switch t.Distribution {
 	case model.DistributionRamp:
 		return rampMultiplier(t, elapsed)
 	case model.DistributionDiurnal:
+		loc := location(t.Timezone)
+		if loc != nil {
+			now = now.In(loc)
+		}
 		if len(points) > 0 {
-			return diurnalMultiplier(points, elapsed)
+			if loc != nil {
+				return diurnalMultiplier(points, secondsOfDay(now))
+			}
+			return diurnalMultiplier(points, elapsed.Seconds())
 		}
 		// Wall-clock S-curve: based on time of day, not elapsed time
-		return DiurnalWallClock(time.Now())
+		return DiurnalWallClock(now)
 	default:
 		return flatMultiplier(t, elapsed)
 	}
 }
 
+var locations sync.Map // zone name → *time.Location
+
+// location resolves an IANA zone name, caching the result since rates are
+// recomputed every second. It returns nil for "" or an unknown zone.
+func location(name string) *time.Location {
+	if name == "" {
+		return nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Warn("unknown task timezone, using default schedule", "timezone", name, "err", err)
+		loc = nil
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+func secondsOfDay(t time.Time) float64 {
+	return float64(t.Hour()*3600+t.Minute()*60+t.Second()) + float64(t.Nanosecond())/1e9
+}
+
 // DiurnalWallClock returns a rate multiplier [0.5, 1.0] based on time of day.
 // Produces a natural S-curve traffic pattern:
 //
@@ -179,11 +531,12 @@ func rampMultiplier(t *model.Task, elapsed time.Duration) float64 {
 	return flatMultiplier(t, elapsed)
 }
 
-func diurnalMultiplier(points []ProfilePoint, elapsed time.Duration) float64 {
+// diurnalMultiplier interpolates points at sec, which is either seconds
+// since the task started or seconds since local midnight.
+func diurnalMultiplier(points []ProfilePoint, sec float64) float64 {
 	if len(points) == 0 {
 		return 1.0
 	}
-	sec := elapsed.Seconds()
 	// linear interpolation
 	for i := 0; i < len(points)-1; i++ {
 		p0, p1 := points[i], points[i+1]
@@ -204,10 +557,15 @@ func ApplyJitter(d time.Duration, jitterPct float64) time.Duration {
 	return time.Duration(math.Round(float64(d) * factor))
 }
 
-// DispatchInterval returns the interval between dispatch batches.
+// DispatchInterval returns the interval between dispatch batches. A task
+// issues tpm requests per minute in total, released batchSize at a time, so
+// batches are batchSize/tpm minutes apart. A batchSize below 1 means 1.
 func DispatchInterval(tpm int, batchSize int) time.Duration {
-	if tpm <= 0 || batchSize <= 0 {
+	if tpm <= 0 {
 		return 0
 	}
-	return time.Minute / time.Duration(tpm)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return time.Minute * time.Duration(batchSize) / time.Duration(tpm)
 }
@@ -1,11 +1,15 @@
 package scheduler_test
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aven/ngoogle/internal/master/scheduler"
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/sqlite"
 )
 
 func TestRateForTask_Flat(t *testing.T) {
@@ -76,6 +80,39 @@ func TestRateForTask_Diurnal(t *testing.T) {
 	}
 }
 
+func TestRateForTaskAt_Timezone(t *testing.T) {
+	// Midnight at 20%, noon at 100%, local time.
+	points := []scheduler.ProfilePoint{
+		{OffsetSec: 0, RatePct: 20},
+		{OffsetSec: 12 * 3600, RatePct: 100},
+	}
+	// 03:00 UTC is noon in Tokyo; the task has only just started.
+	now := time.Date(2026, 3, 23, 3, 0, 0, 0, time.UTC)
+
+	elapsedBased := &model.Task{Distribution: model.DistributionDiurnal}
+	if mult := scheduler.RateForTaskAt(elapsedBased, now, 0, points); mult < 0.19 || mult > 0.21 {
+		t.Errorf("without timezone: mult=%f, expected 0.2 from elapsed time", mult)
+	}
+
+	tokyo := &model.Task{Distribution: model.DistributionDiurnal, Timezone: "Asia/Tokyo"}
+	if mult := scheduler.RateForTaskAt(tokyo, now, 0, points); mult < 0.99 || mult > 1.01 {
+		t.Errorf("Asia/Tokyo: mult=%f, expected 1.0 at local noon", mult)
+	}
+
+	// Without points the built-in curve is read in the task's zone:
+	// 14:00 UTC is 23:00 in Tokyo, the peak hour.
+	late := time.Date(2026, 3, 23, 14, 0, 0, 0, time.UTC)
+	if mult := scheduler.RateForTaskAt(tokyo, late, 0, nil); mult < 0.99 || mult > 1.01 {
+		t.Errorf("Asia/Tokyo wall clock: mult=%f, expected peak 1.0", mult)
+	}
+
+	// An unknown zone falls back to the elapsed-based curve.
+	bogus := &model.Task{Distribution: model.DistributionDiurnal, Timezone: "Mars/Olympus"}
+	if mult := scheduler.RateForTaskAt(bogus, now, 0, points); mult < 0.19 || mult > 0.21 {
+		t.Errorf("unknown zone: mult=%f, expected elapsed-based 0.2", mult)
+	}
+}
+
 func TestDiurnalWallClock(t *testing.T) {
 	// Helper to create a time at a specific hour:minute
 	at := func(hour, min int) time.Time {
@@ -127,4 +164,393 @@ func TestDispatchInterval(t *testing.T) {
 	if interval != 500*time.Millisecond {
 		t.Errorf("expected 500ms, got %v", interval)
 	}
+	// 60 tpm in batches of 5 = one batch every 5s
+	interval = scheduler.DispatchInterval(60, 5)
+	if interval != 5*time.Second {
+		t.Errorf("expected 5s, got %v", interval)
+	}
+	// no rate limit
+	if interval = scheduler.DispatchInterval(0, 5); interval != 0 {
+		t.Errorf("expected 0, got %v", interval)
+	}
+}
+
+// newTestStore returns an in-memory store and a helper that saves a task
+// with the fields every test shares filled in.
+func newTestStore(t *testing.T) (store.Store, func(*model.Task)) {
+	t.Helper()
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	create := func(task *model.Task) {
+		t.Helper()
+		now := time.Now()
+		task.Type = model.TaskTypeYoutube
+		task.TargetURL = "https://youtu.be/example"
+		task.Distribution = model.DistributionFlat
+		task.CreatedAt, task.UpdatedAt = now, now
+		if err := st.Tasks().Create(context.Background(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return st, create
+}
+
+func assertStatuses(t *testing.T, st store.Store, want map[string]model.TaskStatus) {
+	t.Helper()
+	for id, status := range want {
+		got, err := st.Tasks().Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != status {
+			t.Errorf("%s: status = %s, want %s", id, got.Status, status)
+		}
+	}
+}
+
+func TestTickStartsAndStopsTasks(t *testing.T) {
+	st, create := newTestStore(t)
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	longAgo := now.Add(-2 * time.Minute)
+
+	create(&model.Task{ID: "not-yet", Status: model.TaskStatusPending, StartAt: &future})
+	create(&model.Task{ID: "start-by-time", Status: model.TaskStatusPending, StartAt: &past})
+	create(&model.Task{ID: "awaiting-agent", Status: model.TaskStatusDispatched, DispatchedAt: &now})
+	create(&model.Task{ID: "start-unreported", Status: model.TaskStatusDispatched, DispatchedAt: &past})
+	create(&model.Task{ID: "missed-start", Status: model.TaskStatusDispatched, StartAt: &longAgo, DurationSec: 60})
+	create(&model.Task{ID: "missed-end", Status: model.TaskStatusPending, EndAt: &past})
+	create(&model.Task{ID: "stop-by-duration", Status: model.TaskStatusRunning, DurationSec: 60, StartedAt: &longAgo})
+	create(&model.Task{ID: "stop-by-end", Status: model.TaskStatusRunning, EndAt: &past, StartedAt: &longAgo})
+	create(&model.Task{ID: "done-by-bytes", Status: model.TaskStatusRunning, TotalBytesTarget: 100, TotalBytesDone: 100, StartedAt: &longAgo})
+	create(&model.Task{ID: "keep-running", Status: model.TaskStatusRunning, DurationSec: 3600, EndAt: &future,
+		TotalBytesTarget: 100, TotalBytesDone: 99, StartedAt: &longAgo})
+	create(&model.Task{ID: "already-done", Status: model.TaskStatusDone, EndAt: &past})
+
+	scheduler.New(st).Tick(context.Background())
+
+	assertStatuses(t, st, map[string]model.TaskStatus{
+		"not-yet":          model.TaskStatusPending,
+		"start-by-time":    model.TaskStatusDispatched,
+		"awaiting-agent":   model.TaskStatusDispatched,
+		"start-unreported": model.TaskStatusRunning,
+		"missed-start":     model.TaskStatusFailed,
+		"missed-end":       model.TaskStatusFailed,
+		"stop-by-duration": model.TaskStatusStopped,
+		"stop-by-end":      model.TaskStatusStopped,
+		"done-by-bytes":    model.TaskStatusDone,
+		"keep-running":     model.TaskStatusRunning,
+		"already-done":     model.TaskStatusDone,
+	})
+	if got, _ := st.Tasks().Get(context.Background(), "start-by-time"); got.DispatchedAt == nil || got.StartedAt != nil {
+		t.Error("start-by-time: expected dispatched_at, and no started_at until an agent starts it")
+	}
+	if got, _ := st.Tasks().Get(context.Background(), "start-unreported"); got.StartedAt == nil {
+		t.Error("start-unreported: expected started_at to be set")
+	}
+	if got, _ := st.Tasks().Get(context.Background(), "stop-by-end"); got.FinishedAt == nil {
+		t.Error("stop-by-end: expected finished_at to be set")
+	}
+}
+
+func TestReconcileStuckTasks(t *testing.T) {
+	st, create := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, a := range []*model.Agent{
+		{ID: "alive", Hostname: "alive", Status: model.AgentStatusOnline, LastHeartbeat: now, CreatedAt: now, UpdatedAt: now},
+		// Still marked online: the master crashed before offline detection ran.
+		{ID: "silent", Hostname: "silent", Status: model.AgentStatusOnline, LastHeartbeat: now.Add(-10 * time.Minute), CreatedAt: now, UpdatedAt: now},
+	} {
+		if err := st.Agents().Upsert(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newTask := func(id, agentID string, status model.TaskStatus, scope model.TaskExecutionScope) {
+		create(&model.Task{ID: id, AgentID: agentID, Status: status, ExecutionScope: scope})
+	}
+	newTask("kept", "alive", model.TaskStatusRunning, model.TaskExecutionScopeSingleAgent)
+	newTask("requeued", "silent", model.TaskStatusDispatched, model.TaskExecutionScopeSingleAgent)
+	newTask("failed", "silent", model.TaskStatusRunning, model.TaskExecutionScopeSingleAgent)
+	newTask("orphaned", "deleted", model.TaskStatusDispatched, model.TaskExecutionScopeSingleAgent)
+	newTask("global", "", model.TaskStatusRunning, model.TaskExecutionScopeGlobal)
+	newTask("done", "silent", model.TaskStatusDone, model.TaskExecutionScopeSingleAgent)
+
+	scheduler.New(st).Reconcile(ctx)
+
+	assertStatuses(t, st, map[string]model.TaskStatus{
+		"kept":     model.TaskStatusRunning,
+		"requeued": model.TaskStatusPending,
+		"failed":   model.TaskStatusFailed,
+		"orphaned": model.TaskStatusFailed,
+		"global":   model.TaskStatusRunning,
+		"done":     model.TaskStatusDone,
+	})
+	for _, id := range []string{"failed", "orphaned"} {
+		got, err := st.Tasks().Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got.ErrorMessage, "agent") {
+			t.Errorf("%s: expected an agent-related error message, got %q", id, got.ErrorMessage)
+		}
+	}
+}
+
+func TestTickLeavesPausedTimeOutOfDuration(t *testing.T) {
+	st, create := newTestStore(t)
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	longAgo, pausedAt := now.Add(-10*time.Minute), now.Add(-8*time.Minute)
+
+	// Ran 2 of its 5 minutes, then paused 8 minutes ago.
+	create(&model.Task{ID: "paused", Status: model.TaskStatusPaused, DurationSec: 300, StartedAt: &longAgo, PausedAt: &pausedAt})
+	// Resumed after a 7-minute pause: 3 minutes of run time so far.
+	create(&model.Task{ID: "resumed", Status: model.TaskStatusRunning, DurationSec: 300, StartedAt: &longAgo, PausedSec: 420})
+	// Resumed after a 4-minute pause: 6 minutes of run time, past its duration.
+	create(&model.Task{ID: "resumed-expired", Status: model.TaskStatusRunning, DurationSec: 300, StartedAt: &longAgo, PausedSec: 240})
+	create(&model.Task{ID: "paused-past-end", Status: model.TaskStatusPaused, EndAt: &past, StartedAt: &longAgo, PausedAt: &pausedAt})
+
+	scheduler.New(st).Tick(context.Background())
+
+	assertStatuses(t, st, map[string]model.TaskStatus{
+		"paused":          model.TaskStatusPaused,
+		"resumed":         model.TaskStatusRunning,
+		"resumed-expired": model.TaskStatusStopped,
+		"paused-past-end": model.TaskStatusStopped,
+	})
+}
+
+func TestReconcileAgentRedispatchesThenFails(t *testing.T) {
+	st, create := newTestStore(t)
+	ctx := context.Background()
+	for _, task := range []*model.Task{
+		{ID: "reported", AgentID: "a1", Status: model.TaskStatusRunning},
+		{ID: "missing", AgentID: "a1", Status: model.TaskStatusRunning},
+		{ID: "flaky", AgentID: "a1", Status: model.TaskStatusRunning},
+		{ID: "paused", AgentID: "a1", Status: model.TaskStatusPaused},
+		{ID: "other-agent", AgentID: "a2", Status: model.TaskStatusRunning},
+		{ID: "group", AgentID: "a1", Status: model.TaskStatusRunning, ExecutionScope: model.TaskExecutionScopeAgentGroup},
+	} {
+		if task.ExecutionScope == "" {
+			task.ExecutionScope = model.TaskExecutionScopeSingleAgent
+		}
+		create(task)
+	}
+	s := scheduler.New(st)
+	// Without a grace period, the first heartbeat missing a task only notes
+	// it; the next one acts.
+	scheduler.SetAgentTimeout(s, 0)
+	var changed []string
+	s.OnChange = func(t *model.Task) { changed = append(changed, t.ID) }
+
+	s.ReconcileAgent(ctx, "a1", []string{"reported"})
+	if len(changed) != 0 {
+		t.Fatalf("first heartbeat re-dispatched %v", changed)
+	}
+	s.ReconcileAgent(ctx, "a1", []string{"reported", "flaky"})
+	if len(changed) != 1 || changed[0] != "missing" {
+		t.Fatalf("re-dispatched %v, want [missing]", changed)
+	}
+	assertStatuses(t, st, map[string]model.TaskStatus{"missing": model.TaskStatusRunning})
+
+	changed = nil
+	s.ReconcileAgent(ctx, "a1", []string{"reported"})
+	if len(changed) != 1 || changed[0] != "missing" {
+		t.Fatalf("changed %v, want [missing] failed", changed)
+	}
+	assertStatuses(t, st, map[string]model.TaskStatus{
+		"reported":    model.TaskStatusRunning,
+		"missing":     model.TaskStatusFailed,
+		"flaky":       model.TaskStatusRunning,
+		"paused":      model.TaskStatusPaused,
+		"other-agent": model.TaskStatusRunning,
+		"group":       model.TaskStatusRunning,
+	})
+	got, err := st.Tasks().Get(ctx, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.ErrorMessage, "agent a1 is not running the task") {
+		t.Errorf("error message = %q", got.ErrorMessage)
+	}
+}
+
+func TestReconcileAgentWaitsForTaskToStart(t *testing.T) {
+	st, create := newTestStore(t)
+	ctx := context.Background()
+	create(&model.Task{ID: "starting", AgentID: "a1", Status: model.TaskStatusRunning, ExecutionScope: model.TaskExecutionScopeSingleAgent})
+	s := scheduler.New(st)
+	s.OnChange = func(task *model.Task) { t.Errorf("task %s changed within the grace period", task.ID) }
+
+	for range 3 {
+		s.ReconcileAgent(ctx, "a1", []string{})
+	}
+	assertStatuses(t, st, map[string]model.TaskStatus{"starting": model.TaskStatusRunning})
+}
+
+func TestTickRecordsEndReasons(t *testing.T) {
+	st, create := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now()
+	past, longAgo := now.Add(-time.Minute), now.Add(-2*time.Minute)
+
+	create(&model.Task{ID: "bytes", Status: model.TaskStatusRunning, TotalBytesTarget: 100, TotalBytesDone: 150, StartedAt: &longAgo})
+	create(&model.Task{ID: "requests", Status: model.TaskStatusRunning, TotalRequestsTarget: 50, StartedAt: &longAgo})
+	create(&model.Task{ID: "requests-short", Status: model.TaskStatusRunning, TotalRequestsTarget: 500, StartedAt: &longAgo})
+	// Reaching its target counts even once the window has closed.
+	create(&model.Task{ID: "bytes-late", Status: model.TaskStatusRunning, TotalBytesTarget: 100, TotalBytesDone: 100, EndAt: &past, StartedAt: &longAgo})
+	create(&model.Task{ID: "end", Status: model.TaskStatusRunning, TotalBytesTarget: 100, TotalBytesDone: 10, EndAt: &past, StartedAt: &longAgo})
+	create(&model.Task{ID: "duration", Status: model.TaskStatusRunning, DurationSec: 60, StartedAt: &longAgo})
+	create(&model.Task{ID: "window", Status: model.TaskStatusPending, EndAt: &past})
+	for _, m := range []*model.TaskMetrics{
+		{TaskID: "requests", AgentID: "a1", RequestCount: 30, RecordedAt: now},
+		{TaskID: "requests", AgentID: "a2", RequestCount: 20, RecordedAt: now},
+		{TaskID: "requests-short", AgentID: "a1", RequestCount: 30, RecordedAt: now},
+	} {
+		if err := st.TaskMetrics().Insert(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := scheduler.New(st)
+	reasons := map[string]string{}
+	s.OnFinish = func(_ context.Context, taskID, reason string) error {
+		reasons[taskID] = reason
+		return nil
+	}
+
+	s.Tick(ctx)
+
+	want := map[string]struct {
+		status model.TaskStatus
+		reason string
+	}{
+		"bytes":          {model.TaskStatusDone, "byte target reached"},
+		"requests":       {model.TaskStatusDone, "request target reached"},
+		"requests-short": {model.TaskStatusRunning, ""},
+		"bytes-late":     {model.TaskStatusDone, "byte target reached"},
+		"end":            {model.TaskStatusStopped, "end time reached"},
+		"duration":       {model.TaskStatusStopped, "duration reached"},
+		"window":         {model.TaskStatusFailed, "start window missed"},
+	}
+	for id, w := range want {
+		got, err := st.Tasks().Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != w.status || reasons[id] != w.reason {
+			t.Errorf("%s: ended %s (%q), want %s (%q)", id, got.Status, reasons[id], w.status, w.reason)
+		}
+	}
+}
+
+func TestTickDefersTasksDuringMaintenance(t *testing.T) {
+	st, create := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now()
+	past, later := now.Add(-time.Minute), now.Add(time.Hour)
+
+	create(&model.Task{ID: "due", Status: model.TaskStatusPending})
+	create(&model.Task{ID: "due-by-time", Status: model.TaskStatusPending, StartAt: &past})
+	create(&model.Task{ID: "running", Status: model.TaskStatusRunning, DurationSec: 3600, StartedAt: &past})
+	w := &model.MaintenanceWindow{ID: "freeze", Name: "freeze", StartAt: &past, EndAt: &later, CreatedAt: now, UpdatedAt: now}
+	if err := st.MaintenanceWindows().Create(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+
+	sched := scheduler.New(st)
+	sched.Tick(ctx)
+	assertStatuses(t, st, map[string]model.TaskStatus{
+		"due":         model.TaskStatusPending,
+		"due-by-time": model.TaskStatusPending,
+		"running":     model.TaskStatusRunning,
+	})
+
+	// The window ends: the deferred tasks start on the next tick.
+	w.EndAt = &now
+	if err := st.MaintenanceWindows().Update(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	sched.Tick(ctx)
+	assertStatuses(t, st, map[string]model.TaskStatus{
+		"due":         model.TaskStatusDispatched,
+		"due-by-time": model.TaskStatusDispatched,
+		"running":     model.TaskStatusRunning,
+	})
+}
+
+func TestTickDefersTasksDuringDailyMaintenanceInTimezone(t *testing.T) {
+	st, create := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now()
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	// A daily window around the current time of day in Tokyo, which may
+	// span midnight there.
+	local := now.In(loc)
+	w := &model.MaintenanceWindow{ID: "nightly", Name: "nightly", Timezone: "Asia/Tokyo",
+		DailyStart: local.Add(-time.Hour).Format("15:04"), DailyEnd: local.Add(time.Hour).Format("15:04"),
+		CreatedAt: now, UpdatedAt: now}
+	if err := st.MaintenanceWindows().Create(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	create(&model.Task{ID: "due", Status: model.TaskStatusPending})
+
+	sched := scheduler.New(st)
+	sched.Tick(ctx)
+	assertStatuses(t, st, map[string]model.TaskStatus{"due": model.TaskStatusPending})
+
+	// The same times of day in New York, 13 or 14 hours behind, exclude now.
+	w.Timezone = "America/New_York"
+	if err := st.MaintenanceWindows().Update(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+	sched.Tick(ctx)
+	assertStatuses(t, st, map[string]model.TaskStatus{"due": model.TaskStatusDispatched})
+}
+
+func TestMaintenanceWindowActiveUntil(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	tests := []struct {
+		name      string
+		w         model.MaintenanceWindow
+		now       time.Time
+		wantUntil time.Time // zero if inactive
+	}{
+		{"one-off inside", model.MaintenanceWindow{StartAt: &start, EndAt: &end}, start.Add(time.Minute), end},
+		{"one-off at end", model.MaintenanceWindow{StartAt: &start, EndAt: &end}, end, time.Time{}},
+		{"daily in zone", model.MaintenanceWindow{DailyStart: "09:00", DailyEnd: "17:00", Timezone: "Asia/Tokyo"},
+			time.Date(2026, 3, 1, 10, 0, 0, 0, tokyo), time.Date(2026, 3, 1, 17, 0, 0, 0, tokyo)},
+		{"daily after end", model.MaintenanceWindow{DailyStart: "09:00", DailyEnd: "17:00", Timezone: "Asia/Tokyo"},
+			time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC), time.Time{}},
+		{"daily defaults to UTC", model.MaintenanceWindow{DailyStart: "09:00", DailyEnd: "17:00"},
+			time.Date(2026, 3, 1, 10, 0, 0, 0, tokyo), time.Time{}},
+		{"overnight before midnight", model.MaintenanceWindow{DailyStart: "22:00", DailyEnd: "06:00", Timezone: "Europe/Berlin"},
+			time.Date(2026, 3, 28, 23, 30, 0, 0, berlin), time.Date(2026, 3, 29, 6, 0, 0, 0, berlin)},
+		{"overnight after midnight", model.MaintenanceWindow{DailyStart: "22:00", DailyEnd: "06:00", Timezone: "Europe/Berlin"},
+			time.Date(2026, 3, 29, 5, 0, 0, 0, berlin), time.Date(2026, 3, 29, 6, 0, 0, 0, berlin)},
+		{"overnight daytime", model.MaintenanceWindow{DailyStart: "22:00", DailyEnd: "06:00", Timezone: "Europe/Berlin"},
+			time.Date(2026, 3, 29, 12, 0, 0, 0, berlin), time.Time{}},
+	}
+	for _, tc := range tests {
+		until, ok := tc.w.ActiveUntil(tc.now)
+		if ok != !tc.wantUntil.IsZero() || !until.Equal(tc.wantUntil) {
+			t.Errorf("%s: ActiveUntil = %v, %v; want %v", tc.name, until, ok, tc.wantUntil)
+		}
+	}
 }
@@ -3,9 +3,15 @@ package service
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
@@ -16,68 +22,378 @@ import (
 type AgentService struct {
 	store   store.Store
 	timeout time.Duration // heartbeat timeout for offline detection
+
+	registrationSecret string       // required bootstrap token for new agents; empty disables the check
+	allowedNets        []*net.IPNet // registration source allowlist; empty allows any address
+
+	pullInterval      time.Duration // how often agents pull tasks, sent at registration
+	heartbeatInterval time.Duration // how often agents send heartbeats, sent at registration
+
+	// Bandwidth sample compression, off while sampleMinDelta is zero; see
+	// SetSampleCompression.
+	sampleMinDelta    float64
+	sampleMaxInterval time.Duration
+	sampleMu          sync.Mutex
+	samples           map[string]*sampleState // by agent ID
+
+	// OnHeartbeat, when set, is called with the task IDs an agent reported
+	// running in a heartbeat, if it reported them.
+	OnHeartbeat func(ctx context.Context, agentID string, running []string)
+	// OnEnabledChange, when set, is called after an agent is enabled or
+	// disabled, which changes how shared tasks are split among agents.
+	OnEnabledChange func()
 }
 
+// sampleState tracks an agent's compressed bandwidth series.
+type sampleState struct {
+	stored  *model.BandwidthSample // last sample written
+	skipped *model.BandwidthSample // latest sample held back since
+}
+
+// Default agent polling intervals, used unless SetIntervals overrides them.
+const (
+	DefaultPullInterval      = 5 * time.Second
+	DefaultHeartbeatInterval = 10 * time.Second
+)
+
 // NewAgentService creates a new AgentService.
 func NewAgentService(st store.Store) *AgentService {
-	return &AgentService{store: st, timeout: 30 * time.Second}
+	return &AgentService{
+		store:             st,
+		timeout:           30 * time.Second,
+		pullInterval:      DefaultPullInterval,
+		heartbeatInterval: DefaultHeartbeatInterval,
+	}
+}
+
+// SetIntervals sets the task pull and heartbeat intervals handed to agents
+// when they register; zero keeps the current value. Both are whole seconds
+// of at least one second, and heartbeats must come more often than the
+// offline timeout so that live agents are not marked offline.
+func (s *AgentService) SetIntervals(pull, heartbeat time.Duration) error {
+	for _, d := range []time.Duration{pull, heartbeat} {
+		if d != 0 && (d < time.Second || d%time.Second != 0) {
+			return fmt.Errorf("interval %s must be a whole number of seconds", d)
+		}
+	}
+	if heartbeat >= s.timeout {
+		return fmt.Errorf("heartbeat interval %s must be shorter than the offline timeout %s", heartbeat, s.timeout)
+	}
+	if pull > 0 {
+		s.pullInterval = pull
+	}
+	if heartbeat > 0 {
+		s.heartbeatInterval = heartbeat
+	}
+	return nil
+}
+
+// DefaultSampleMaxInterval is the longest a compressed bandwidth series goes
+// without a sample unless SetSampleCompression says otherwise.
+const DefaultSampleMaxInterval = time.Minute
+
+// SetSampleCompression makes heartbeats store a bandwidth sample only when
+// the rate moved by at least minDelta Mbps since the agent's last stored
+// sample, or maxInterval has passed since it (DefaultSampleMaxInterval when
+// zero). A minDelta of zero stores every heartbeat's sample.
+func (s *AgentService) SetSampleCompression(minDelta float64, maxInterval time.Duration) error {
+	if minDelta < 0 || math.IsNaN(minDelta) {
+		return fmt.Errorf("sample delta %v must not be negative", minDelta)
+	}
+	if maxInterval < 0 {
+		return fmt.Errorf("sample interval %s must not be negative", maxInterval)
+	}
+	if maxInterval == 0 {
+		maxInterval = DefaultSampleMaxInterval
+	}
+	s.sampleMu.Lock()
+	defer s.sampleMu.Unlock()
+	s.sampleMinDelta, s.sampleMaxInterval = minDelta, maxInterval
+	s.samples = make(map[string]*sampleState)
+	return nil
+}
+
+// samplesToStore returns the bandwidth samples to write for a heartbeat
+// reporting rate at now. With compression on, that is none while the rate
+// stays within sampleMinDelta of the last stored sample and sampleMaxInterval
+// has not passed. Once the rate moves, the last held-back sample is written
+// along with the new one, so the series keeps a step instead of ramping
+// across the gap.
+func (s *AgentService) samplesToStore(agentID string, rate float64, now time.Time) []*model.BandwidthSample {
+	sample := &model.BandwidthSample{AgentID: agentID, RateMbps: rate, RecordedAt: now}
+	s.sampleMu.Lock()
+	defer s.sampleMu.Unlock()
+	if s.sampleMinDelta <= 0 {
+		return []*model.BandwidthSample{sample}
+	}
+	st, ok := s.samples[agentID]
+	if !ok {
+		s.samples[agentID] = &sampleState{stored: sample}
+		return []*model.BandwidthSample{sample}
+	}
+	moved := math.Abs(rate-st.stored.RateMbps) >= s.sampleMinDelta
+	if !moved && now.Sub(st.stored.RecordedAt) < s.sampleMaxInterval {
+		st.skipped = sample
+		return nil
+	}
+	out := []*model.BandwidthSample{sample}
+	if moved && st.skipped != nil {
+		out = []*model.BandwidthSample{st.skipped, sample}
+	}
+	st.stored, st.skipped = sample, nil
+	return out
+}
+
+// Intervals returns the task pull and heartbeat intervals agents should use.
+func (s *AgentService) Intervals() (pull, heartbeat time.Duration) {
+	return s.pullInterval, s.heartbeatInterval
+}
+
+// SetRegistrationSecret requires agents without an issued token to present
+// secret as their bootstrap token.
+func (s *AgentService) SetRegistrationSecret(secret string) {
+	s.registrationSecret = secret
+}
+
+// SetRegistrationAllowlist restricts registration to source addresses within
+// the given comma-separated CIDRs or IPs. An empty list allows any address.
+func (s *AgentService) SetRegistrationAllowlist(list string) error {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return fmt.Errorf("invalid registration allowlist entry: %s", item)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("invalid registration allowlist entry: %s", item)
+		}
+		nets = append(nets, n)
+	}
+	s.allowedNets = nets
+	return nil
+}
+
+var (
+	// ErrInvalidAgentToken is returned when an agent registers with a token
+	// the master did not issue.
+	ErrInvalidAgentToken = errors.New("invalid agent token")
+	// ErrRegistrationDenied is returned when a registration lacks the
+	// bootstrap token or comes from an address outside the allowlist.
+	ErrRegistrationDenied = errors.New("agent registration not authorized")
+)
+
+// RegisterRequest is the input for agent registration.
+type RegisterRequest struct {
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Version  string `json:"version"`
+	// Commit is the VCS revision the agent was built from, if known.
+	Commit string `json:"commit,omitempty"`
+	// MachineID is an optional stable host identifier. When present it is
+	// the dedup key, so a renamed or re-IP'd host keeps its agent ID.
+	MachineID string `json:"machine_id,omitempty"`
+	// Token is optional. Provisioned agents present the token issued by their
+	// provisioning job and keep it instead of being handed a fresh one.
+	Token string `json:"token,omitempty"`
+	// BootstrapToken must match the master's registration secret, if one is
+	// configured, for agents without an issued token.
+	BootstrapToken string `json:"bootstrap_token,omitempty"`
+	// Tags are free-form labels the agent advertises (e.g. region), used to
+	// filter the agent list. They replace any previously registered tags.
+	Tags []string `json:"tags,omitempty"`
+	// CapacityMbps is the most bandwidth the agent can carry, if known.
+	CapacityMbps float64 `json:"capacity_mbps,omitempty"`
+	// RemoteIP is the connection's source address, set by the handler.
+	RemoteIP string `json:"-"`
+}
+
+// RegisterResponse is the register payload: the agent record plus the token
+// it must present on heartbeats. It is the only response carrying the token.
+type RegisterResponse struct {
+	*model.Agent
+	Token string `json:"token"`
+	// PullIntervalSec and HeartbeatIntervalSec tell the agent how often to
+	// pull tasks and send heartbeats.
+	PullIntervalSec      int `json:"pull_interval_sec"`
+	HeartbeatIntervalSec int `json:"heartbeat_interval_sec"`
 }
 
 // Register registers a new agent or updates an existing one.
-func (s *AgentService) Register(ctx context.Context, hostname, ip string, port int, version string) (*model.Agent, error) {
-	// Check if agent with same hostname+ip exists
+func (s *AgentService) Register(ctx context.Context, req *RegisterRequest) (*model.Agent, error) {
+	if !s.allowedSource(req.RemoteIP) {
+		return nil, ErrRegistrationDenied
+	}
+	if req.CapacityMbps < 0 {
+		return nil, invalidf("capacity_mbps must not be negative")
+	}
 	agents, err := s.store.Agents().List(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, a := range agents {
-		if a.Hostname == hostname && a.IP == ip {
-			// Re-register: update token + status
-			a.Token = generateToken()
-			a.Status = model.AgentStatusOnline
-			a.LastHeartbeat = time.Now()
-			a.Version = version
-			a.UpdatedAt = time.Now()
-			if err := s.store.Agents().Upsert(ctx, a); err != nil {
-				return nil, err
+	token := generateToken()
+	var existing *model.Agent
+	if req.Token != "" {
+		known, err := s.isIssuedToken(ctx, agents, req.Token)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			return nil, ErrInvalidAgentToken
+		}
+		token = req.Token
+		for _, a := range agents {
+			if a.Token == req.Token {
+				existing = a
+				break
 			}
-			return a, nil
 		}
 	}
+	if req.Token == "" && s.registrationSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(req.BootstrapToken), []byte(s.registrationSecret)) != 1 {
+		return nil, ErrRegistrationDenied
+	}
+	if existing == nil && req.MachineID != "" {
+		for _, a := range agents {
+			if a.MachineID == req.MachineID {
+				existing = a
+				break
+			}
+		}
+	}
+	if existing == nil {
+		// Fall back to hostname+ip, skipping agents known to be other machines.
+		for _, a := range agents {
+			if a.Hostname == req.Hostname && a.IP == req.IP &&
+				(a.MachineID == "" || req.MachineID == "" || a.MachineID == req.MachineID) {
+				existing = a
+				break
+			}
+		}
+	}
+	if existing != nil {
+		// Re-register: update token + status
+		existing.Hostname = req.Hostname
+		if req.MachineID != "" {
+			existing.MachineID = req.MachineID
+		}
+		existing.IP = req.IP
+		existing.Port = req.Port
+		if req.Token == "" && s.registrationSecret != "" && existing.Token != "" {
+			// An agent that proved itself with the bootstrap secret gets
+			// the token it has, so a token the operator just rotated is
+			// the one handed back. Without a secret anyone who knows the
+			// machine could ask, so a fresh token is minted and the real
+			// agent's next heartbeat shows the takeover with a 401.
+			token = existing.Token
+		}
+		existing.Token = token
+		existing.Status = model.AgentStatusOnline
+		existing.LastHeartbeat = time.Now()
+		existing.Version = req.Version
+		existing.Commit = req.Commit
+		existing.CapacityMbps = req.CapacityMbps
+		existing.SetTags(req.Tags)
+		existing.UpdatedAt = time.Now()
+		if err := s.store.Agents().Upsert(ctx, existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
 	// New agent
 	a := &model.Agent{
 		ID:            generateID(),
-		Hostname:      hostname,
-		IP:            ip,
-		Port:          port,
-		Token:         generateToken(),
+		Hostname:      req.Hostname,
+		MachineID:     req.MachineID,
+		IP:            req.IP,
+		Port:          req.Port,
+		Token:         token,
 		Status:        model.AgentStatusOnline,
-		Version:       version,
+		Version:       req.Version,
+		Commit:        req.Commit,
+		CapacityMbps:  req.CapacityMbps,
+		Enabled:       true,
 		LastHeartbeat: time.Now(),
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
+	a.SetTags(req.Tags)
 	if err := s.store.Agents().Upsert(ctx, a); err != nil {
 		return nil, err
 	}
 	return a, nil
 }
 
-// Heartbeat updates agent last-seen and status.
-func (s *AgentService) Heartbeat(ctx context.Context, agentID string, rateMbps float64) error {
+// allowedSource reports whether a registration from ip passes the allowlist.
+func (s *AgentService) allowedSource(ip string) bool {
+	if len(s.allowedNets) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range s.allowedNets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIssuedToken reports whether token belongs to a known agent or was issued
+// by a provisioning job.
+func (s *AgentService) isIssuedToken(ctx context.Context, agents []*model.Agent, token string) (bool, error) {
+	for _, a := range agents {
+		if a.Token == token {
+			return true, nil
+		}
+	}
+	jobs, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, j := range jobs {
+		if j.AgentToken == token {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Heartbeat updates agent last-seen, status and rates. running holds the
+// IDs of the tasks the agent is executing, or is nil if the agent does not
+// report them.
+func (s *AgentService) Heartbeat(ctx context.Context, agentID string, rates model.AgentRates, running []string) error {
 	now := time.Now()
 	if err := s.store.Agents().UpdateStatus(ctx, agentID, model.AgentStatusOnline, now); err != nil {
 		return err
 	}
-	if err := s.store.Agents().UpdateRate(ctx, agentID, rateMbps); err != nil {
+	if err := s.store.Agents().UpdateRate(ctx, agentID, rates); err != nil {
 		return err
 	}
-	// Record bandwidth sample
-	return s.store.Bandwidth().Insert(ctx, &model.BandwidthSample{
-		AgentID:    agentID,
-		RateMbps:   rateMbps,
-		RecordedAt: now,
-	})
+	// Record bandwidth samples
+	for _, sample := range s.samplesToStore(agentID, rates.RateMbps, now) {
+		if err := s.store.Bandwidth().Insert(ctx, sample); err != nil {
+			return err
+		}
+	}
+	if running != nil && s.OnHeartbeat != nil {
+		s.OnHeartbeat(ctx, agentID, running)
+	}
+	return nil
 }
 
 // RunOfflineDetection periodically marks agents that haven't sent heartbeats as offline.
@@ -115,6 +431,17 @@ func (s *AgentService) List(ctx context.Context) ([]*model.Agent, error) {
 	return s.store.Agents().List(ctx)
 }
 
+// ListFiltered returns one page of agents matching f and the total match count.
+func (s *AgentService) ListFiltered(ctx context.Context, f store.AgentFilter) ([]*model.Agent, int, error) {
+	if f.Status != "" && f.Status != model.AgentStatusOnline && f.Status != model.AgentStatusOffline {
+		return nil, 0, invalidf("invalid status %q", f.Status)
+	}
+	if f.Limit < 0 || f.Offset < 0 {
+		return nil, 0, invalidf("limit and offset must not be negative")
+	}
+	return s.store.Agents().ListFiltered(ctx, f)
+}
+
 // Get returns a single agent.
 func (s *AgentService) Get(ctx context.Context, id string) (*model.Agent, error) {
 	return s.store.Agents().Get(ctx, id)
@@ -139,6 +466,56 @@ func generateToken() string {
 	return hex.EncodeToString(b)
 }
 
+// SetEnabled enables or disables an agent and returns it. A disabled agent
+// stays registered, heartbeats and keeps running the tasks it has started,
+// but is handed no new ones; see TaskService.PullTasks.
+func (s *AgentService) SetEnabled(ctx context.Context, id string, enabled bool) (*model.Agent, error) {
+	a, err := s.store.Agents().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.Enabled == enabled {
+		return a, nil
+	}
+	if err := s.store.Agents().SetEnabled(ctx, id, enabled); err != nil {
+		return nil, err
+	}
+	a.Enabled = enabled
+	if s.OnEnabledChange != nil {
+		s.OnEnabledChange()
+	}
+	return a, nil
+}
+
+// RotateToken replaces an agent's token and returns the new one. The old
+// token stops working immediately, and any provisioning job still holding
+// it forgets it so it cannot be replayed at registration. A running agent
+// notices on its next heartbeat and re-registers.
+func (s *AgentService) RotateToken(ctx context.Context, id string) (string, error) {
+	a, err := s.store.Agents().Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	old := a.Token
+	a.Token = generateToken()
+	a.UpdatedAt = time.Now()
+	if err := s.store.Agents().Upsert(ctx, a); err != nil {
+		return "", err
+	}
+	jobs, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, j := range jobs {
+		if j.AgentToken != "" && (j.AgentToken == old || j.AgentID == id) {
+			if err := s.store.ProvisionJobs().SetAgentToken(ctx, j.ID, ""); err != nil {
+				return "", err
+			}
+		}
+	}
+	return a.Token, nil
+}
+
 // ValidateToken checks if the provided token matches the agent's token.
 func (s *AgentService) ValidateToken(ctx context.Context, agentID, token string) error {
 	a, err := s.store.Agents().Get(ctx, agentID)
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// AgentGroupService manages agent groups and the tasks that target them.
+type AgentGroupService struct {
+	store   store.Store
+	taskSvc *TaskService
+}
+
+// NewAgentGroupService creates a new AgentGroupService.
+func NewAgentGroupService(st store.Store, taskSvc *TaskService) *AgentGroupService {
+	return &AgentGroupService{store: st, taskSvc: taskSvc}
+}
+
+// CreateAgentGroupRequest is the input for agent group creation.
+type CreateAgentGroupRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	AgentIDs    []string `json:"agent_ids"`
+}
+
+// AgentGroupMembersRequest is the body for adding agents to a group.
+type AgentGroupMembersRequest struct {
+	AgentIDs []string `json:"agent_ids"`
+}
+
+// AgentGroupStats aggregates the live state of a group's agents.
+type AgentGroupStats struct {
+	GroupID     string  `json:"group_id"`
+	Agents      int     `json:"agents"`
+	Online      int     `json:"online"`
+	RateMbps    float64 `json:"rate_mbps"`
+	ActiveTasks int     `json:"active_tasks"`
+}
+
+// Create creates a group with an optional initial set of members.
+func (s *AgentGroupService) Create(ctx context.Context, req *CreateAgentGroupRequest) (*model.AgentGroup, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, invalidf("name is required")
+	}
+	agentIDs := uniqueStrings(req.AgentIDs)
+	if err := s.checkAgents(ctx, agentIDs); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	g := &model.AgentGroup{
+		ID:          generateID(),
+		Name:        name,
+		Description: req.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	// The group and its members are written together, so a failure adding
+	// members leaves no empty group behind.
+	err := s.store.WithTx(ctx, func(tx store.Store) error {
+		if err := tx.AgentGroups().Create(ctx, g); err != nil {
+			if errors.Is(err, store.ErrConflict) {
+				return conflictf("agent group %q already exists", name)
+			}
+			return err
+		}
+		return tx.AgentGroups().AddMembers(ctx, g.ID, agentIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.store.AgentGroups().Get(ctx, g.ID)
+}
+
+func (s *AgentGroupService) Get(ctx context.Context, id string) (*model.AgentGroup, error) {
+	return s.store.AgentGroups().Get(ctx, id)
+}
+
+func (s *AgentGroupService) List(ctx context.Context) ([]*model.AgentGroup, error) {
+	return s.store.AgentGroups().List(ctx)
+}
+
+// Delete removes a group. Groups still targeted by an active task cannot be
+// deleted.
+func (s *AgentGroupService) Delete(ctx context.Context, id string) error {
+	if _, err := s.store.AgentGroups().Get(ctx, id); err != nil {
+		return err
+	}
+	tasks, err := s.groupTasks(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		if isActive(t.Status) {
+			return conflictf("agent group %s is targeted by active task %s", id, t.ID)
+		}
+	}
+	return s.store.AgentGroups().Delete(ctx, id)
+}
+
+// AddMembers adds agents to a group and returns the updated group.
+func (s *AgentGroupService) AddMembers(ctx context.Context, id string, agentIDs []string) (*model.AgentGroup, error) {
+	if _, err := s.store.AgentGroups().Get(ctx, id); err != nil {
+		return nil, err
+	}
+	agentIDs = uniqueStrings(agentIDs)
+	if len(agentIDs) == 0 {
+		return nil, invalidf("agent_ids is required")
+	}
+	if err := s.checkAgents(ctx, agentIDs); err != nil {
+		return nil, err
+	}
+	if err := s.store.AgentGroups().AddMembers(ctx, id, agentIDs); err != nil {
+		return nil, err
+	}
+	for _, agentID := range agentIDs {
+		s.taskSvc.NotifyAgent(agentID)
+	}
+	return s.store.AgentGroups().Get(ctx, id)
+}
+
+// RemoveMember removes an agent from a group and returns the updated group.
+func (s *AgentGroupService) RemoveMember(ctx context.Context, id, agentID string) (*model.AgentGroup, error) {
+	g, err := s.store.AgentGroups().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, member := range g.AgentIDs {
+		found = found || member == agentID
+	}
+	if !found {
+		return nil, invalidf("agent %s is not in agent group %s", agentID, id)
+	}
+	if err := s.store.AgentGroups().RemoveMember(ctx, id, agentID); err != nil {
+		return nil, err
+	}
+	s.taskSvc.NotifyAgent(agentID)
+	return s.store.AgentGroups().Get(ctx, id)
+}
+
+// Stats returns the group's member and online counts, the members' combined
+// current rate, and the number of active tasks running on the group.
+func (s *AgentGroupService) Stats(ctx context.Context, id string) (*AgentGroupStats, error) {
+	g, err := s.store.AgentGroups().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	stats := &AgentGroupStats{GroupID: id, Agents: len(g.AgentIDs)}
+	for _, agentID := range g.AgentIDs {
+		a, err := s.store.Agents().Get(ctx, agentID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if a.Status == model.AgentStatusOnline {
+			stats.Online++
+			stats.RateMbps += a.CurrentRateMbps
+		}
+	}
+	tasks, err := s.activeTasks(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	stats.ActiveTasks = len(tasks)
+	return stats, nil
+}
+
+// Dispatch dispatches every pending task that targets the group.
+func (s *AgentGroupService) Dispatch(ctx context.Context, id string) error {
+	if _, err := s.store.AgentGroups().Get(ctx, id); err != nil {
+		return err
+	}
+	tasks, err := s.groupTasks(ctx, id)
+	if err != nil {
+		return err
+	}
+	dispatched := 0
+	for _, t := range tasks {
+		if t.Status != model.TaskStatusPending {
+			continue
+		}
+		if err := s.taskSvc.Dispatch(ctx, t.ID); err != nil {
+			return err
+		}
+		dispatched++
+	}
+	if dispatched == 0 {
+		return conflictf("agent group %s has no pending tasks", id)
+	}
+	return nil
+}
+
+// Stop stops every active task running on the group: tasks that target it
+// and single-agent tasks assigned to one of its members.
+func (s *AgentGroupService) Stop(ctx context.Context, id string) error {
+	g, err := s.store.AgentGroups().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	tasks, err := s.activeTasks(ctx, g)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		if err := s.taskSvc.Stop(ctx, t.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupTasks returns the tasks whose execution scope targets group id.
+func (s *AgentGroupService) groupTasks(ctx context.Context, id string) ([]*model.Task, error) {
+	all, err := s.store.Tasks().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*model.Task
+	for _, t := range all {
+		if t.ExecutionScope == model.TaskExecutionScopeAgentGroup && t.AgentGroupID == id {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, nil
+}
+
+// activeTasks returns the dispatched or running tasks that target g or are
+// assigned to one of its members.
+func (s *AgentGroupService) activeTasks(ctx context.Context, g *model.AgentGroup) ([]*model.Task, error) {
+	all, err := s.store.Tasks().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool, len(g.AgentIDs))
+	for _, id := range g.AgentIDs {
+		members[id] = true
+	}
+	var tasks []*model.Task
+	for _, t := range all {
+		if !isActive(t.Status) {
+			continue
+		}
+		switch t.ExecutionScope {
+		case model.TaskExecutionScopeAgentGroup:
+			if t.AgentGroupID == g.ID {
+				tasks = append(tasks, t)
+			}
+		case model.TaskExecutionScopeSingleAgent, "":
+			if members[t.AgentID] {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+	return tasks, nil
+}
+
+func (s *AgentGroupService) checkAgents(ctx context.Context, agentIDs []string) error {
+	for _, id := range agentIDs {
+		_, err := s.store.Agents().Get(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return invalidf("agent %s not found", id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isActive(status model.TaskStatus) bool {
+	return status == model.TaskStatusDispatched || status == model.TaskStatusRunning
+}
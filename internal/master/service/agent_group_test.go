@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestAgentGroupTargetsTasksAtMembers(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	for _, a := range []*model.Agent{
+		{ID: "a1", Status: model.AgentStatusOnline, CurrentRateMbps: 30, LastHeartbeat: now},
+		{ID: "a2", Status: model.AgentStatusOnline, CurrentRateMbps: 20, LastHeartbeat: now},
+		{ID: "a3", Status: model.AgentStatusOffline, LastHeartbeat: now},
+		{ID: "a4", Status: model.AgentStatusOnline, LastHeartbeat: now},
+	} {
+		if err := st.Agents().Upsert(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	taskSvc := NewTaskService(st)
+	svc := NewAgentGroupService(st, taskSvc)
+
+	if _, err := svc.Create(ctx, &CreateAgentGroupRequest{Name: "eu", AgentIDs: []string{"a1", "missing"}}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("unknown member: err = %v, want ErrInvalidInput", err)
+	}
+	g, err := svc.Create(ctx, &CreateAgentGroupRequest{Name: "eu", AgentIDs: []string{"a1", "a2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Create(ctx, &CreateAgentGroupRequest{Name: "eu"}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("duplicate name: err = %v, want ErrConflict", err)
+	}
+	if g, err = svc.AddMembers(ctx, g.ID, []string{"a3", "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.AgentIDs) != 3 {
+		t.Fatalf("members = %v, want a1 a2 a3", g.AgentIDs)
+	}
+
+	task, err := taskSvc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", AgentGroupID: g.ID, TargetRateMbps: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ExecutionScope != model.TaskExecutionScopeAgentGroup {
+		t.Fatalf("scope = %s, want agent_group", task.ExecutionScope)
+	}
+	if err := svc.Dispatch(ctx, g.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	pulled, err := taskSvc.PullTasks(ctx, "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two of the three members are online, so each gets half the rate.
+	if len(pulled) != 1 || pulled[0].TargetRateMbps != 50 {
+		t.Fatalf("member pulled %+v, want the task at 50 Mbps", pulled)
+	}
+	if pulled, err = taskSvc.PullTasks(ctx, "a4"); err != nil || len(pulled) != 0 {
+		t.Fatalf("non-member pulled %d tasks (err %v), want none", len(pulled), err)
+	}
+
+	stats, err := svc.Stats(ctx, g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AgentGroupStats{GroupID: g.ID, Agents: 3, Online: 2, RateMbps: 50, ActiveTasks: 1}
+	if *stats != want {
+		t.Errorf("stats = %+v, want %+v", *stats, want)
+	}
+
+	if err := svc.Delete(ctx, g.ID); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("delete with active task: err = %v, want ErrConflict", err)
+	}
+	if err := svc.Stop(ctx, g.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.Status != model.TaskStatusStopped {
+		t.Fatalf("task status = %s after group stop, want stopped", got.Status)
+	}
+	if err := svc.Delete(ctx, g.ID); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := st.AgentGroups().GroupsOf(ctx, "a1"); len(groups) != 0 {
+		t.Errorf("a1 still in groups %v after delete", groups)
+	}
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestRegisterWithProvisionedToken(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	job := &model.ProvisionJob{ID: "job1", HostIP: "10.0.0.9", AgentToken: "provisioned-token",
+		Status: model.ProvisionStatusRunning, CreatedAt: now, UpdatedAt: now}
+	if err := st.ProvisionJobs().Create(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewAgentService(st)
+	a, err := svc.Register(ctx, &RegisterRequest{Hostname: "h1", IP: "10.0.0.9", Token: "provisioned-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Token != "provisioned-token" {
+		t.Fatalf("expected provisioned token to be kept, got %q", a.Token)
+	}
+
+	// Restart after a re-IP: the token identifies the same agent.
+	again, err := svc.Register(ctx, &RegisterRequest{Hostname: "h1", IP: "10.0.0.10", Token: "provisioned-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != a.ID || again.IP != "10.0.0.10" {
+		t.Fatalf("expected agent %s with new IP, got %s/%s", a.ID, again.ID, again.IP)
+	}
+	if err := svc.ValidateToken(ctx, a.ID, "provisioned-token"); err != nil {
+		t.Fatalf("heartbeat token should validate: %v", err)
+	}
+}
+
+func TestRegisterRejectsUnknownToken(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	svc := NewAgentService(st)
+	_, err = svc.Register(context.Background(), &RegisterRequest{Hostname: "h", IP: "10.0.0.1", Token: "forged"})
+	if !errors.Is(err, ErrInvalidAgentToken) {
+		t.Fatalf("expected ErrInvalidAgentToken, got %v", err)
+	}
+}
+
+func TestRegisterRequiresBootstrapToken(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	svc := NewAgentService(st)
+	svc.SetRegistrationSecret("s3cret")
+	if _, err := svc.Register(ctx, &RegisterRequest{Hostname: "h", IP: "10.0.0.1"}); !errors.Is(err, ErrRegistrationDenied) {
+		t.Fatalf("expected ErrRegistrationDenied without secret, got %v", err)
+	}
+	if _, err := svc.Register(ctx, &RegisterRequest{Hostname: "h", IP: "10.0.0.1", BootstrapToken: "wrong"}); !errors.Is(err, ErrRegistrationDenied) {
+		t.Fatalf("expected ErrRegistrationDenied with wrong secret, got %v", err)
+	}
+	if _, err := svc.Register(ctx, &RegisterRequest{Hostname: "h", IP: "10.0.0.1", BootstrapToken: "s3cret"}); err != nil {
+		t.Fatalf("expected registration with secret to succeed: %v", err)
+	}
+}
+
+func TestRegisterAllowlist(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	svc := NewAgentService(st)
+	if err := svc.SetRegistrationAllowlist("10.0.0.0/24, 192.168.1.7"); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"10.0.0.42":   true,
+		"192.168.1.7": true,
+		"192.168.1.8": false,
+		"":            false,
+	}
+	for ip, want := range tests {
+		_, err := svc.Register(ctx, &RegisterRequest{Hostname: "h" + ip, IP: ip, RemoteIP: ip})
+		if got := err == nil; got != want {
+			t.Errorf("register from %q: allowed=%v, want %v (err: %v)", ip, got, want, err)
+		}
+	}
+	if err := svc.SetRegistrationAllowlist("not-an-ip"); err == nil {
+		t.Fatal("expected error for invalid allowlist entry")
+	}
+}
+
+func TestRegisterDedupesByMachineID(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	svc := NewAgentService(st)
+	first, err := svc.Register(ctx, &RegisterRequest{Hostname: "old-name", IP: "10.0.0.1", MachineID: "m-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := svc.Register(ctx, &RegisterRequest{Hostname: "new-name", IP: "10.0.0.2", MachineID: "m-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ID != first.ID {
+		t.Fatalf("expected same agent after re-IP, got %s and %s", first.ID, moved.ID)
+	}
+	if moved.Hostname != "new-name" || moved.IP != "10.0.0.2" {
+		t.Fatalf("expected updated hostname/ip, got %s/%s", moved.Hostname, moved.IP)
+	}
+
+	// Same hostname+IP but a different machine is a different agent.
+	other, err := svc.Register(ctx, &RegisterRequest{Hostname: "new-name", IP: "10.0.0.2", MachineID: "m-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == first.ID {
+		t.Fatal("expected a distinct agent for a different machine id")
+	}
+
+	// Agents registered before machine IDs existed adopt the first one sent.
+	legacy, err := svc.Register(ctx, &RegisterRequest{Hostname: "legacy", IP: "10.0.0.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adopted, err := svc.Register(ctx, &RegisterRequest{Hostname: "legacy", IP: "10.0.0.3", MachineID: "m-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adopted.ID != legacy.ID || adopted.MachineID != "m-3" {
+		t.Fatalf("expected legacy agent to adopt machine id, got %s/%q", adopted.ID, adopted.MachineID)
+	}
+}
+
+func TestRotateTokenInvalidatesOldToken(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	job := &model.ProvisionJob{ID: "job1", HostIP: "10.0.0.9", AgentToken: "provisioned-token",
+		Status: model.ProvisionStatusSuccess, CreatedAt: now, UpdatedAt: now}
+	if err := st.ProvisionJobs().Create(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewAgentService(st)
+	a, err := svc.Register(ctx, &RegisterRequest{Hostname: "h1", IP: "10.0.0.9", Token: "provisioned-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := svc.RotateToken(ctx, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" || token == "provisioned-token" {
+		t.Fatalf("expected a fresh token, got %q", token)
+	}
+	if err := svc.ValidateToken(ctx, a.ID, "provisioned-token"); err == nil {
+		t.Fatal("old token still validates after rotation")
+	}
+	if err := svc.ValidateToken(ctx, a.ID, token); err != nil {
+		t.Fatalf("new token should validate: %v", err)
+	}
+	// The job no longer vouches for the old token, so it cannot be replayed.
+	if _, err := svc.Register(ctx, &RegisterRequest{Hostname: "h1", IP: "10.0.0.9", Token: "provisioned-token"}); !errors.Is(err, ErrInvalidAgentToken) {
+		t.Fatalf("expected ErrInvalidAgentToken for old token, got %v", err)
+	}
+	again, err := svc.Register(ctx, &RegisterRequest{Hostname: "h1", IP: "10.0.0.9", Token: token})
+	if err != nil || again.ID != a.ID {
+		t.Fatalf("re-register with rotated token: %v (id %v)", err, again)
+	}
+
+	if _, err := svc.RotateToken(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unknown agent, got %v", err)
+	}
+}
+
+func TestSampleCompressionKeepsSteps(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	svc := NewAgentService(st)
+	t0 := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return t0.Add(time.Duration(sec) * time.Second) }
+
+	// Without compression every heartbeat is stored.
+	if got := svc.samplesToStore("a1", 100, at(0)); len(got) != 1 {
+		t.Fatalf("uncompressed: %d samples, want 1", len(got))
+	}
+
+	if err := svc.SetSampleCompression(5, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		sec  int
+		rate float64
+		want []int // seconds of the samples stored
+	}{
+		{0, 100, []int{0}},       // first sample
+		{10, 101, nil},           // within delta
+		{20, 102, nil},           // within delta
+		{30, 120, []int{20, 30}}, // moved: held-back sample keeps the step
+		{40, 121, nil},           // within delta
+		{95, 122, []int{95}},     // max interval passed
+	}
+	for _, s := range steps {
+		got := svc.samplesToStore("a1", s.rate, at(s.sec))
+		if len(got) != len(s.want) {
+			t.Fatalf("t=%ds: stored %d samples, want %d", s.sec, len(got), len(s.want))
+		}
+		for i, sample := range got {
+			if !sample.RecordedAt.Equal(at(s.want[i])) {
+				t.Errorf("t=%ds: sample %d at %s, want t=%ds", s.sec, i, sample.RecordedAt, s.want[i])
+			}
+		}
+	}
+
+	if err := svc.SetSampleCompression(-1, 0); err == nil {
+		t.Error("negative delta accepted")
+	}
+}
+
+func TestDisabledAgentGetsNoNewTasks(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	agentSvc := NewAgentService(st)
+	taskSvc := NewTaskService(st)
+	notified := 0
+	agentSvc.OnEnabledChange = func() { notified++ }
+
+	for _, host := range []string{"h1", "h2"} {
+		if _, err := agentSvc.Register(ctx, &RegisterRequest{Hostname: host, IP: "10.0.0.1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	agents, err := agentSvc.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1, a2 := agents[0].ID, agents[1].ID
+	if !agents[0].Enabled || !agents[1].Enabled {
+		t.Fatal("registered agents are not enabled")
+	}
+
+	// old was running on a1 before it was disabled; fresh was not.
+	old, err := taskSvc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/a.bin", TargetRateMbps: 100, ExecutionScope: model.TaskExecutionScopeGlobal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := taskSvc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/b.bin", TargetRateMbps: 100, ExecutionScope: model.TaskExecutionScopeGlobal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{old.ID, fresh.ID} {
+		if err := st.Tasks().UpdateStatus(ctx, id, model.TaskStatusRunning); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.TaskMetrics().Insert(ctx, &model.TaskMetrics{TaskID: old.ID, AgentID: a1, RecordedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := agentSvc.SetEnabled(ctx, a1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Enabled || notified != 1 {
+		t.Fatalf("after disable: enabled = %v, notified %d times", a.Enabled, notified)
+	}
+	if _, err := agentSvc.SetEnabled(ctx, a1, false); err != nil || notified != 1 {
+		t.Fatalf("disabling again: err = %v, notified %d times", err, notified)
+	}
+	if got, _ := agentSvc.Get(ctx, a1); got.Enabled {
+		t.Fatal("disable was not stored")
+	}
+	// Re-registering, as agents do after a token rotation, keeps it disabled.
+	if _, err := agentSvc.Register(ctx, &RegisterRequest{Hostname: "h1", IP: "10.0.0.1", Token: a.Token}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := agentSvc.Get(ctx, a1); got.Enabled {
+		t.Fatal("re-registering enabled the agent")
+	}
+
+	rates := func(agentID string) map[string]float64 {
+		t.Helper()
+		pulled, err := taskSvc.PullTasks(ctx, agentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := map[string]float64{}
+		for _, task := range pulled {
+			m[task.ID] = task.TargetRateMbps
+		}
+		return m
+	}
+	// a1 keeps its share of the task it runs and gets nothing new; a2
+	// carries the new task alone.
+	if got := rates(a1); len(got) != 1 || got[old.ID] != 50 {
+		t.Fatalf("disabled agent pulled %v, want only %s at 50 Mbps", got, old.ID)
+	}
+	if got := rates(a2); got[old.ID] != 50 || got[fresh.ID] != 100 {
+		t.Fatalf("enabled agent pulled %v, want %s at 50 and %s at 100 Mbps", got, old.ID, fresh.ID)
+	}
+
+	if _, err := agentSvc.SetEnabled(ctx, a1, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := rates(a1); len(got) != 2 || got[fresh.ID] != 50 {
+		t.Fatalf("re-enabled agent pulled %v, want both tasks at 50 Mbps", got)
+	}
+	if _, err := agentSvc.SetEnabled(ctx, "missing", false); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("unknown agent: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionStatus compares the version an agent registered with against the
+// version the master expects agents to run.
+type VersionStatus string
+
+const (
+	VersionCurrent  VersionStatus = "current"
+	VersionOutdated VersionStatus = "outdated"
+	VersionNewer    VersionStatus = "newer"
+	// VersionUnknown is for agents whose version does not parse, such as
+	// development builds.
+	VersionUnknown VersionStatus = "unknown"
+)
+
+// SetExpectedAgentVersion sets the version agents are compared against in
+// the overview. It defaults to the master's own build version.
+func (s *DashboardService) SetExpectedAgentVersion(v string) error {
+	if _, ok := parseVersion(v); !ok {
+		return fmt.Errorf("invalid agent version %q: want MAJOR.MINOR.PATCH", v)
+	}
+	s.expectedAgentVersion = v
+	return nil
+}
+
+// agentVersionStatus returns the status of an agent reporting version when
+// agents are expected to run expected.
+func agentVersionStatus(version, expected string) VersionStatus {
+	v, ok := parseVersion(version)
+	if !ok {
+		return VersionUnknown
+	}
+	want, ok := parseVersion(expected)
+	if !ok {
+		return VersionUnknown
+	}
+	switch c := v.compare(want); {
+	case c < 0:
+		return VersionOutdated
+	case c > 0:
+		return VersionNewer
+	}
+	return VersionCurrent
+}
+
+// semver is a parsed MAJOR.MINOR.PATCH[-PRERELEASE] version. Build
+// metadata after a + is ignored, as is a leading v.
+type semver struct {
+	parts [3]int
+	pre   string
+}
+
+func parseVersion(s string) (semver, bool) {
+	var v semver
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+	fields := strings.Split(s, ".")
+	if len(fields) > len(v.parts) {
+		return v, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.parts[i] = n
+	}
+	return v, true
+}
+
+// compare orders versions like semantic versioning: a pre-release comes
+// before its release. Pre-release labels compare as strings.
+func (v semver) compare(o semver) int {
+	for i := range v.parts {
+		if v.parts[i] != o.parts[i] {
+			if v.parts[i] < o.parts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+	return strings.Compare(v.pre, o.pre)
+}
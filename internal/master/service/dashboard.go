@@ -3,9 +3,13 @@ package service
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/aven/ngoogle/internal/buildinfo"
+	"github.com/aven/ngoogle/internal/model"
 	"github.com/aven/ngoogle/internal/store"
 )
 
@@ -23,11 +27,38 @@ type DashboardService struct {
 	historyCache []store.BandwidthPoint
 	historyKey   string
 	historyAt    time.Time
+
+	historyWindow    time.Duration
+	historyMaxPoints int
+
+	expectedAgentVersion string
 }
 
+// Bandwidth history defaults, overridable with SetHistoryLimits.
+const (
+	DefaultHistoryWindow    = 7 * 24 * time.Hour
+	DefaultHistoryMaxPoints = 1000
+)
+
 // NewDashboardService creates a new DashboardService.
 func NewDashboardService(st store.Store) *DashboardService {
-	return &DashboardService{store: st}
+	return &DashboardService{
+		store:                st,
+		historyWindow:        DefaultHistoryWindow,
+		historyMaxPoints:     DefaultHistoryMaxPoints,
+		expectedAgentVersion: buildinfo.Version,
+	}
+}
+
+// SetHistoryLimits sets the range BandwidthHistory covers when no start is
+// given and the most points it returns. Zero values keep the defaults.
+func (s *DashboardService) SetHistoryLimits(window time.Duration, maxPoints int) {
+	if window > 0 {
+		s.historyWindow = window
+	}
+	if maxPoints > 0 {
+		s.historyMaxPoints = maxPoints
+	}
 }
 
 // RunOverviewRefresh periodically refreshes the overview cache in background.
@@ -57,45 +88,91 @@ func (s *DashboardService) refreshOverview(ctx context.Context) {
 	if err != nil {
 		return
 	}
+	jobs, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return
+	}
+	creds, err := s.store.Credentials().List(ctx)
+	if err != nil {
+		return
+	}
 
 	var totalMbps float64
-	onlineCount := 0
-	type agentStat struct {
-		ID       string  `json:"id"`
-		Hostname string  `json:"hostname"`
-		IP       string  `json:"ip"`
-		RateMbps float64 `json:"rate_mbps"`
-		Status   string  `json:"status"`
-	}
-	agentStats := make([]agentStat, 0, len(agents))
+	onlineCount, outdated := 0, 0
+	agentStats := make([]AgentRate, 0, len(agents))
+	hostnames := make(map[string]string, len(agents))
 	for _, a := range agents {
+		hostnames[a.ID] = a.Hostname
 		if string(a.Status) == "online" {
 			onlineCount++
 			totalMbps += a.CurrentRateMbps
 		}
-		agentStats = append(agentStats, agentStat{
-			ID:       a.ID,
-			Hostname: a.Hostname,
-			IP:       a.IP,
-			RateMbps: a.CurrentRateMbps,
-			Status:   string(a.Status),
+		versionStatus := agentVersionStatus(a.Version, s.expectedAgentVersion)
+		if versionStatus == VersionOutdated {
+			outdated++
+		}
+		agentStats = append(agentStats, AgentRate{
+			ID:            a.ID,
+			Hostname:      a.Hostname,
+			IP:            a.IP,
+			RateMbps:      a.CurrentRateMbps,
+			TaskRateMbps:  a.TaskRateMbps,
+			TxRateMbps:    a.TxRateMbps,
+			Measured:      a.RateMeasured,
+			Status:        string(a.Status),
+			Enabled:       a.Enabled,
+			Version:       a.Version,
+			VersionStatus: versionStatus,
 		})
 	}
 
 	runningTasks := 0
+	taskRates := []TaskRate{}
 	for _, t := range tasks {
-		if t.Status == "running" {
-			runningTasks++
+		if t.Status != "running" {
+			continue
+		}
+		runningTasks++
+		snapshots, err := s.store.TaskMetrics().LatestByTaskAgents(ctx, t.ID)
+		if err != nil {
+			return
+		}
+		tr := TaskRate{TaskID: t.ID, Name: t.Name, AgentID: t.AgentID, Hostname: hostnames[t.AgentID], Agents: len(snapshots)}
+		for _, snap := range snapshots {
+			tr.RateMbps += snap.RateMbps5s
+		}
+		taskRates = append(taskRates, tr)
+	}
+	sort.SliceStable(taskRates, func(i, j int) bool { return taskRates[i].RateMbps > taskRates[j].RateMbps })
+
+	var jobCounts ProvisionJobCounts
+	for _, j := range jobs {
+		switch j.Status {
+		case model.ProvisionStatusPending:
+			jobCounts.Pending++
+		case model.ProvisionStatusRunning:
+			jobCounts.Running++
+		case model.ProvisionStatusSuccess:
+			jobCounts.Success++
+		case model.ProvisionStatusFailed:
+			jobCounts.Failed++
+		case model.ProvisionStatusInterrupted:
+			jobCounts.Interrupted++
 		}
 	}
 
 	resp := &OverviewResponse{
-		TotalAgents:   len(agents),
-		OnlineAgents:  onlineCount,
-		TotalTasks:    len(tasks),
-		RunningTasks:  runningTasks,
-		TotalRateMbps: totalMbps,
-		Agents:        agentStats,
+		TotalAgents:          len(agents),
+		OnlineAgents:         onlineCount,
+		OutdatedAgents:       outdated,
+		ExpectedAgentVersion: s.expectedAgentVersion,
+		TotalTasks:           len(tasks),
+		RunningTasks:         runningTasks,
+		TotalRateMbps:        totalMbps,
+		Agents:               agentStats,
+		ProvisionJobs:        jobCounts,
+		Credentials:          len(creds),
+		TopTasks:             taskRates,
 	}
 
 	s.overviewMu.Lock()
@@ -104,36 +181,232 @@ func (s *DashboardService) refreshOverview(ctx context.Context) {
 	s.overviewMu.Unlock()
 }
 
-// Overview returns the cached overview (never blocks on DB).
-func (s *DashboardService) Overview(_ context.Context) (*OverviewResponse, error) {
+// DefaultTopTasks is the number of tasks Overview lists when the caller
+// does not ask for a specific number.
+const DefaultTopTasks = 5
+
+// Overview returns the cached overview (never blocks on DB), listing at most
+// topN of the running tasks with the highest current rate.
+func (s *DashboardService) Overview(_ context.Context, topN int) (*OverviewResponse, error) {
 	s.overviewMu.RLock()
-	resp := s.overviewCache
+	cached := s.overviewCache
 	s.overviewMu.RUnlock()
-	if resp != nil {
-		return resp, nil
+	if cached == nil {
+		// Fallback: cache not yet populated (first few ms after startup)
+		return &OverviewResponse{TopTasks: []TaskRate{}}, nil
+	}
+	resp := *cached
+	if topN < len(resp.TopTasks) {
+		resp.TopTasks = resp.TopTasks[:topN]
 	}
-	// Fallback: cache not yet populated (first few ms after startup)
-	return &OverviewResponse{}, nil
+	return &resp, nil
 }
 
 // OverviewResponse is the dashboard overview payload.
 type OverviewResponse struct {
-	TotalAgents   int         `json:"total_agents"`
-	OnlineAgents  int         `json:"online_agents"`
-	TotalTasks    int         `json:"total_tasks"`
-	RunningTasks  int         `json:"running_tasks"`
-	TotalRateMbps float64     `json:"total_rate_mbps"`
-	Agents        interface{} `json:"agents"`
+	TotalAgents  int `json:"total_agents"`
+	OnlineAgents int `json:"online_agents"`
+	// OutdatedAgents counts the agents running an older version than
+	// ExpectedAgentVersion; see AgentRate.VersionStatus.
+	OutdatedAgents       int                `json:"outdated_agents"`
+	ExpectedAgentVersion string             `json:"expected_agent_version"`
+	TotalTasks           int                `json:"total_tasks"`
+	RunningTasks         int                `json:"running_tasks"`
+	TotalRateMbps        float64            `json:"total_rate_mbps"`
+	Agents               []AgentRate        `json:"agents"`
+	ProvisionJobs        ProvisionJobCounts `json:"provision_jobs"`
+	Credentials          int                `json:"credentials"`
+	TopTasks             []TaskRate         `json:"top_tasks"`
+}
+
+// AgentRate is an agent's bandwidth as of its last heartbeat. RateMbps is
+// what its network interface received when Measured, and TaskRateMbps what
+// its tasks' meters account for; the difference is traffic outside the
+// tasks, such as proxy and protocol overhead. VersionStatus compares the
+// agent's Version with the version the master expects.
+type AgentRate struct {
+	ID            string        `json:"id"`
+	Hostname      string        `json:"hostname"`
+	IP            string        `json:"ip"`
+	RateMbps      float64       `json:"rate_mbps"`
+	TaskRateMbps  float64       `json:"task_rate_mbps"`
+	TxRateMbps    float64       `json:"tx_rate_mbps"`
+	Measured      bool          `json:"rate_measured"`
+	Status        string        `json:"status"`
+	Enabled       bool          `json:"enabled"`
+	Version       string        `json:"version"`
+	VersionStatus VersionStatus `json:"version_status"`
+}
+
+// TaskRate is a running task's current bandwidth: the sum of the latest
+// 5s rate reported by each of its agents.
+type TaskRate struct {
+	TaskID   string  `json:"task_id"`
+	Name     string  `json:"name"`
+	AgentID  string  `json:"agent_id,omitempty"`
+	Hostname string  `json:"hostname,omitempty"`
+	Agents   int     `json:"agents"`
+	RateMbps float64 `json:"rate_mbps"`
+}
+
+// ProvisionJobCounts counts provision jobs by status. Interrupted jobs are
+// resumed when the master starts.
+type ProvisionJobCounts struct {
+	Pending     int `json:"pending"`
+	Running     int `json:"running"`
+	Success     int `json:"success"`
+	Failed      int `json:"failed"`
+	Interrupted int `json:"interrupted"`
+}
+
+// ProvisioningStats summarizes provision jobs: how many succeeded and
+// failed, how long installs take and which steps fail.
+type ProvisioningStats struct {
+	Jobs ProvisionJobCounts `json:"jobs"`
+	// SuccessRate is the share of finished (succeeded or failed) jobs that
+	// succeeded, or 0 if none finished.
+	SuccessRate float64 `json:"success_rate"`
+	// MedianInstallSec is the median time successful jobs spent in their
+	// steps.
+	MedianInstallSec float64 `json:"median_install_sec"`
+	// MostFailedStep is the step that failed the most jobs, if any failed.
+	MostFailedStep string               `json:"most_failed_step,omitempty"`
+	Steps          []ProvisionStepStats `json:"steps"`
+}
+
+// ProvisionStepStats describes one provision step across jobs. Runs counts
+// the jobs that reached the step, and MedianSec is the median time it took
+// them, failed or not.
+type ProvisionStepStats struct {
+	Step        string  `json:"step"`
+	Runs        int     `json:"runs"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"`
+	MedianSec   float64 `json:"median_sec"`
+}
+
+// ProvisioningStats summarizes the provision jobs created between from and
+// to; a zero bound leaves that side of the range open. Steps are listed in
+// the order they run. Jobs from before step timing was recorded count
+// towards failures but have no durations.
+func (s *DashboardService) ProvisioningStats(ctx context.Context, from, to time.Time) (*ProvisioningStats, error) {
+	jobs, err := s.store.ProvisionJobs().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &ProvisioningStats{Steps: []ProvisionStepStats{}}
+	byStep := map[string]*ProvisionStepStats{}
+	durations := map[string][]float64{}
+	step := func(name string) *ProvisionStepStats {
+		st, ok := byStep[name]
+		if !ok {
+			st = &ProvisionStepStats{Step: name}
+			byStep[name] = st
+		}
+		return st
+	}
+	var installs []float64
+	for _, j := range jobs {
+		if (!from.IsZero() && j.CreatedAt.Before(from)) || (!to.IsZero() && j.CreatedAt.After(to)) {
+			continue
+		}
+		var total float64
+		for name, sec := range j.StepDurations {
+			step(name).Runs++
+			durations[name] = append(durations[name], sec)
+			total += sec
+		}
+		switch j.Status {
+		case model.ProvisionStatusPending:
+			stats.Jobs.Pending++
+		case model.ProvisionStatusRunning:
+			stats.Jobs.Running++
+		case model.ProvisionStatusSuccess:
+			stats.Jobs.Success++
+			if len(j.StepDurations) > 0 {
+				installs = append(installs, total)
+			}
+		case model.ProvisionStatusFailed:
+			stats.Jobs.Failed++
+			if j.FailedStep != "" {
+				st := step(j.FailedStep)
+				st.Failures++
+				if _, timed := j.StepDurations[j.FailedStep]; !timed {
+					st.Runs++
+				}
+			}
+		case model.ProvisionStatusInterrupted:
+			stats.Jobs.Interrupted++
+		}
+	}
+	if finished := stats.Jobs.Success + stats.Jobs.Failed; finished > 0 {
+		stats.SuccessRate = float64(stats.Jobs.Success) / float64(finished)
+	}
+	stats.MedianInstallSec = median(installs)
+
+	// Known steps in run order, then any others by name.
+	names := make([]string, 0, len(byStep))
+	for name := range byStep {
+		names = append(names, name)
+	}
+	order := func(name string) int {
+		if i := slices.Index(model.ProvisionSteps, name); i >= 0 {
+			return i
+		}
+		return len(model.ProvisionSteps)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		oa, ob := order(names[a]), order(names[b])
+		if oa != ob {
+			return oa < ob
+		}
+		return names[a] < names[b]
+	})
+	mostFailures := 0
+	for _, name := range names {
+		st := byStep[name]
+		st.MedianSec = median(durations[name])
+		if st.Runs > 0 {
+			st.FailureRate = float64(st.Failures) / float64(st.Runs)
+		}
+		if st.Failures > mostFailures {
+			stats.MostFailedStep, mostFailures = name, st.Failures
+		}
+		stats.Steps = append(stats.Steps, *st)
+	}
+	return stats, nil
+}
+
+// median returns the median of v, or 0 if v is empty. It sorts v.
+func median(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	sort.Float64s(v)
+	n := len(v)
+	if n%2 == 0 {
+		return (v[n/2-1] + v[n/2]) / 2
+	}
+	return v[n/2]
 }
 
-// BandwidthHistory returns aggregated bandwidth samples (cached).
+// BandwidthHistory returns aggregated bandwidth samples (cached) and the
+// bucket size used. A zero to means now and a zero from means the configured
+// window before to. stepSec is raised as needed to return at most maxPoints
+// buckets; maxPoints <= 0 or above the configured cap means the cap. agg
+// picks how the agents' averages combine into each point's Mbps; empty
+// means the sum, the fleet total, which avg_mbps has always held.
 // Live (step=60) caches for 3s, longer ranges cache for 30s.
-func (s *DashboardService) BandwidthHistory(ctx context.Context, from, to time.Time, stepSec int) ([]store.BandwidthPoint, error) {
-	if stepSec <= 0 {
-		stepSec = 60
+func (s *DashboardService) BandwidthHistory(ctx context.Context, from, to time.Time, stepSec, maxPoints int, agg store.BandwidthAgg) ([]store.BandwidthPoint, int, error) {
+	if agg == "" {
+		agg = store.BandwidthAggSum
+	}
+	if agg.SQL() == "" {
+		return nil, 0, invalidf("agg must be one of sum, avg and max")
 	}
+	from, to, stepSec = s.historyRange(from, to, stepSec, maxPoints)
 
-	key := fmt.Sprintf("%d|%s|%s", stepSec,
+	key := fmt.Sprintf("%d|%s|%s|%s", stepSec, agg,
 		from.Truncate(time.Minute).Format(time.RFC3339),
 		to.Truncate(time.Minute).Format(time.RFC3339))
 
@@ -146,13 +419,13 @@ func (s *DashboardService) BandwidthHistory(ctx context.Context, from, to time.T
 	if s.historyKey == key && time.Since(s.historyAt) < ttl {
 		cached := s.historyCache
 		s.historyMu.RUnlock()
-		return cached, nil
+		return cached, stepSec, nil
 	}
 	s.historyMu.RUnlock()
 
-	points, err := s.store.Bandwidth().AggregateHistory(ctx, from, to, stepSec)
+	points, err := s.store.Bandwidth().AggregateHistory(ctx, from, to, stepSec, agg)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	s.historyMu.Lock()
@@ -161,7 +434,80 @@ func (s *DashboardService) BandwidthHistory(ctx context.Context, from, to time.T
 	s.historyAt = time.Now()
 	s.historyMu.Unlock()
 
-	return points, nil
+	return points, stepSec, nil
+}
+
+// AgentBandwidthHistory returns one agent's bandwidth per bucket and the
+// bucket size used. The range and bucket size default and are capped as in
+// BandwidthHistory.
+func (s *DashboardService) AgentBandwidthHistory(ctx context.Context, agentID string, from, to time.Time, stepSec, maxPoints int) ([]store.BandwidthPoint, int, error) {
+	if _, err := s.store.Agents().Get(ctx, agentID); err != nil {
+		return nil, 0, err
+	}
+	from, to, stepSec = s.historyRange(from, to, stepSec, maxPoints)
+	points, err := s.store.Bandwidth().AgentHistory(ctx, agentID, from, to, stepSec)
+	if err != nil {
+		return nil, 0, err
+	}
+	return points, stepSec, nil
+}
+
+// historyRange applies the defaults and caps of the bandwidth history
+// queries: a zero to means now, a zero from the configured window before
+// to, and the step is raised to return at most maxPoints buckets, itself
+// capped by the configured limit.
+func (s *DashboardService) historyRange(from, to time.Time, stepSec, maxPoints int) (time.Time, time.Time, int) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-s.historyWindow)
+	}
+	if stepSec <= 0 {
+		stepSec = 60
+	}
+	if maxPoints <= 0 || maxPoints > s.historyMaxPoints {
+		maxPoints = s.historyMaxPoints
+	}
+	return from, to, historyStep(from, to, stepSec, maxPoints)
+}
+
+// TotalPoint is one bucket of the fleet's total bandwidth.
+type TotalPoint struct {
+	Ts        time.Time `json:"ts"`
+	TotalMbps float64   `json:"total_mbps"`
+}
+
+// BandwidthTotalHistory returns the fleet's total bandwidth per bucket and
+// the bucket size used, taking the same arguments as BandwidthHistory and
+// sharing its cache. The total is the sum across agents of each agent's
+// average in the bucket, which is what BandwidthHistory reports as avg_mbps;
+// summing the raw samples instead would count an agent once per heartbeat.
+func (s *DashboardService) BandwidthTotalHistory(ctx context.Context, from, to time.Time, stepSec, maxPoints int) ([]TotalPoint, int, error) {
+	points, stepSec, err := s.BandwidthHistory(ctx, from, to, stepSec, maxPoints, store.BandwidthAggSum)
+	if err != nil {
+		return nil, 0, err
+	}
+	totals := make([]TotalPoint, len(points))
+	for i, p := range points {
+		totals[i] = TotalPoint{Ts: p.Ts, TotalMbps: p.Mbps}
+	}
+	return totals, stepSec, nil
+}
+
+// historyStep returns the smallest bucket size of at least stepSec seconds
+// that splits [from, to] into no more than maxPoints buckets. Buckets are
+// aligned to multiples of the step, so a range can touch one more bucket
+// than span/step.
+func historyStep(from, to time.Time, stepSec, maxPoints int) int {
+	span := int(to.Sub(from) / time.Second)
+	if span <= 0 || maxPoints < 2 {
+		return stepSec
+	}
+	if least := (span + maxPoints - 2) / (maxPoints - 1); stepSec < least {
+		stepSec = least
+	}
+	return stepSec
 }
 
 // RunPurge runs a daily purge of bandwidth samples older than 7 days.
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestOverviewCountsProvisioning(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	statuses := []model.ProvisionStatus{
+		model.ProvisionStatusPending,
+		model.ProvisionStatusRunning,
+		model.ProvisionStatusSuccess,
+		model.ProvisionStatusSuccess,
+		model.ProvisionStatusFailed,
+		model.ProvisionStatusInterrupted,
+	}
+	for i, status := range statuses {
+		job := &model.ProvisionJob{ID: fmt.Sprintf("job%d", i), HostIP: "10.0.0.1", Status: status, CreatedAt: now, UpdatedAt: now}
+		if err := st.ProvisionJobs().Create(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Credentials().Create(ctx, &model.Credential{ID: "c1", Name: "key", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewDashboardService(st)
+	svc.refreshOverview(ctx)
+	o, err := svc.Overview(ctx, DefaultTopTasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProvisionJobCounts{Pending: 1, Running: 1, Success: 2, Failed: 1, Interrupted: 1}
+	if o.ProvisionJobs != want {
+		t.Fatalf("provision_jobs = %+v, want %+v", o.ProvisionJobs, want)
+	}
+	if o.Credentials != 1 {
+		t.Fatalf("credentials = %d, want 1", o.Credentials)
+	}
+}
+
+func TestProvisioningStats(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	jobs := []struct {
+		status    model.ProvisionStatus
+		failed    string
+		durations map[string]float64
+		age       time.Duration
+	}{
+		{model.ProvisionStatusSuccess, "", map[string]float64{"ssh_check": 1, "download_binary": 10, "install_runtime": 20, "install_service": 2, "health_check": 7}, 0},
+		{model.ProvisionStatusSuccess, "", map[string]float64{"ssh_check": 1, "download_binary": 20, "install_runtime": 30, "install_service": 2, "health_check": 7}, 0},
+		{model.ProvisionStatusSuccess, "", map[string]float64{"ssh_check": 1, "download_binary": 30, "install_runtime": 50, "install_service": 2, "health_check": 7}, 0},
+		{model.ProvisionStatusFailed, "download_binary", map[string]float64{"ssh_check": 1, "download_binary": 600}, 0},
+		{model.ProvisionStatusFailed, "download_binary", map[string]float64{"ssh_check": 2, "download_binary": 5}, 0},
+		// Recorded before step timing existed.
+		{model.ProvisionStatusFailed, "ssh_check", nil, 0},
+		{model.ProvisionStatusRunning, "", map[string]float64{"ssh_check": 1}, 0},
+		// Outside the range queried below.
+		{model.ProvisionStatusFailed, "install_service", nil, 48 * time.Hour},
+	}
+	for i, j := range jobs {
+		job := &model.ProvisionJob{ID: fmt.Sprintf("job%d", i), HostIP: "10.0.0.1", Status: j.status, FailedStep: j.failed,
+			CreatedAt: now.Add(-j.age), UpdatedAt: now}
+		job.SetStepDurations(j.durations)
+		if err := st.ProvisionJobs().Create(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := NewDashboardService(st)
+	got, err := svc.ProvisioningStats(ctx, now.Add(-24*time.Hour), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ProvisionJobCounts{Running: 1, Success: 3, Failed: 3}); got.Jobs != want {
+		t.Fatalf("jobs = %+v, want %+v", got.Jobs, want)
+	}
+	if got.SuccessRate != 0.5 || got.MedianInstallSec != 60 || got.MostFailedStep != "download_binary" {
+		t.Fatalf("stats = %+v", got)
+	}
+	want := []ProvisionStepStats{
+		{Step: "ssh_check", Runs: 7, Failures: 1, FailureRate: 1.0 / 7, MedianSec: 1},
+		{Step: "download_binary", Runs: 5, Failures: 2, FailureRate: 0.4, MedianSec: 20},
+		{Step: "install_runtime", Runs: 3, MedianSec: 30},
+		{Step: "install_service", Runs: 3, MedianSec: 2},
+		{Step: "health_check", Runs: 3, MedianSec: 7},
+	}
+	if fmt.Sprint(got.Steps) != fmt.Sprint(want) {
+		t.Fatalf("steps = %+v, want %+v", got.Steps, want)
+	}
+
+	all, err := svc.ProvisioningStats(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Jobs.Failed != 4 || all.Steps[len(all.Steps)-2].Failures != 1 {
+		t.Fatalf("unbounded stats = %+v", all)
+	}
+}
+
+func TestOverviewListsTopTasksByRate(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	if err := st.Agents().Upsert(ctx, &model.Agent{ID: "a1", Hostname: "edge-1", Status: model.AgentStatusOnline, LastHeartbeat: now}); err != nil {
+		t.Fatal(err)
+	}
+	// t2 runs on two agents, so its rate is the sum of both.
+	rates := map[string][]float64{"t1": {10}, "t2": {30, 25}, "t3": {40}, "idle": {99}}
+	for id, agentRates := range rates {
+		task := &model.Task{ID: id, Name: id, AgentID: "a1", Status: model.TaskStatusRunning, TargetRateMbps: 100, CreatedAt: now, UpdatedAt: now}
+		if id == "idle" {
+			task.Status = model.TaskStatusDone
+		}
+		if id == "t2" {
+			task.AgentID, task.ExecutionScope = "", model.TaskExecutionScopeGlobal
+		}
+		if err := st.Tasks().Create(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+		for i, r := range agentRates {
+			m := &model.TaskMetrics{TaskID: id, AgentID: fmt.Sprintf("a%d", i+1), RateMbps5s: r, RecordedAt: now}
+			if err := st.TaskMetrics().Insert(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	svc := NewDashboardService(st)
+	svc.refreshOverview(ctx)
+	o, err := svc.Overview(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.TopTasks) != 2 || o.TopTasks[0].TaskID != "t2" || o.TopTasks[1].TaskID != "t3" {
+		t.Fatalf("top tasks = %+v, want t2 then t3", o.TopTasks)
+	}
+	if o.TopTasks[0].RateMbps != 55 || o.TopTasks[0].Agents != 2 {
+		t.Fatalf("t2 = %+v, want 55 Mbps from 2 agents", o.TopTasks[0])
+	}
+	if o.TopTasks[1].Hostname != "edge-1" {
+		t.Fatalf("t3 hostname = %q, want edge-1", o.TopTasks[1].Hostname)
+	}
+	// A smaller N must not shrink the cached list for later callers.
+	if o, _ := svc.Overview(ctx, 10); len(o.TopTasks) != 3 {
+		t.Fatalf("top 10 = %d tasks, want 3 running", len(o.TopTasks))
+	}
+}
+
+func TestOverviewReportsAgentRates(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	for _, id := range []string{"a1", "a2"} {
+		if err := st.Agents().Upsert(ctx, &model.Agent{ID: id, Status: model.AgentStatusOnline, LastHeartbeat: now, CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a1 measures its interface; a2 cannot and reports its task rate.
+	measured := model.AgentRates{RateMbps: 110, TaskRateMbps: 100, TxRateMbps: 3, Measured: true}
+	if err := st.Agents().UpdateRate(ctx, "a1", measured); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Agents().UpdateRate(ctx, "a2", model.AgentRates{RateMbps: 50, TaskRateMbps: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewDashboardService(st)
+	svc.refreshOverview(ctx)
+	o, err := svc.Overview(ctx, DefaultTopTasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.TotalRateMbps != 160 {
+		t.Fatalf("total rate = %v, want 160", o.TotalRateMbps)
+	}
+	byID := map[string]AgentRate{}
+	for _, a := range o.Agents {
+		byID[a.ID] = a
+	}
+	if a := byID["a1"]; a.RateMbps != 110 || a.TaskRateMbps != 100 || a.TxRateMbps != 3 || !a.Measured {
+		t.Fatalf("a1 = %+v, want measured 110 against 100 from tasks", a)
+	}
+	if a := byID["a2"]; a.RateMbps != 50 || a.TaskRateMbps != 50 || a.Measured {
+		t.Fatalf("a2 = %+v, want unmeasured 50", a)
+	}
+}
+
+func TestOverviewFlagsOutdatedAgents(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	versions := map[string]string{
+		"a1": "1.2.0",
+		"a2": "v1.2.0+0123abc",
+		"a3": "1.1.9",
+		"a4": "1.2.0-rc.1",
+		"a5": "1.10.0",
+		"a6": "dev",
+	}
+	for id, v := range versions {
+		if err := st.Agents().Upsert(ctx, &model.Agent{ID: id, Version: v, Status: model.AgentStatusOnline, LastHeartbeat: now, CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := NewDashboardService(st)
+	if err := svc.SetExpectedAgentVersion("latest"); err == nil {
+		t.Fatal("expected an error for a version that does not parse")
+	}
+	if err := svc.SetExpectedAgentVersion("1.2.0"); err != nil {
+		t.Fatal(err)
+	}
+	svc.refreshOverview(ctx)
+	o, err := svc.Overview(ctx, DefaultTopTasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.OutdatedAgents != 2 || o.ExpectedAgentVersion != "1.2.0" {
+		t.Fatalf("outdated_agents = %d against %q, want 2 against 1.2.0", o.OutdatedAgents, o.ExpectedAgentVersion)
+	}
+	want := map[string]VersionStatus{
+		"a1": VersionCurrent,
+		"a2": VersionCurrent,
+		"a3": VersionOutdated,
+		"a4": VersionOutdated,
+		"a5": VersionNewer,
+		"a6": VersionUnknown,
+	}
+	for _, a := range o.Agents {
+		if a.VersionStatus != want[a.ID] || a.Version != versions[a.ID] {
+			t.Errorf("%s at %q: version_status = %q, want %q", a.ID, a.Version, a.VersionStatus, want[a.ID])
+		}
+	}
+}
+
+func TestBandwidthHistoryCapsPoints(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewDashboardService(st)
+	svc.SetHistoryLimits(0, 100)
+
+	to := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+	cases := []struct {
+		name      string
+		stepSec   int
+		maxPoints int
+		want      int
+	}{
+		{"requested step fits", 3600, 0, 3600},
+		{"widened to server cap", 10, 0, 873},
+		{"caller cap", 60, 25, 3600},
+		{"caller cap above server cap", 60, 5000, 873},
+	}
+	for _, tc := range cases {
+		_, step, err := svc.BandwidthHistory(ctx, from, to, tc.stepSec, tc.maxPoints, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step != tc.want {
+			t.Errorf("%s: step = %d, want %d", tc.name, step, tc.want)
+		}
+	}
+}
+
+func TestBandwidthHistoryCombinesAgentAverages(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 12, 30, 0, 0, time.UTC)
+	// a1 heartbeats twice in the minute; it counts once, at its average.
+	for _, s := range []*model.BandwidthSample{
+		{AgentID: "a1", RateMbps: 100, RecordedAt: now},
+		{AgentID: "a1", RateMbps: 200, RecordedAt: now.Add(30 * time.Second)},
+		{AgentID: "a2", RateMbps: 50, RecordedAt: now.Add(10 * time.Second)},
+	} {
+		if err := st.Bandwidth().Insert(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := NewDashboardService(st)
+	points, step, err := svc.BandwidthTotalHistory(ctx, now.Add(-time.Hour), now.Add(time.Hour), 60, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step != 60 || len(points) != 1 || points[0].TotalMbps != 200 || !points[0].Ts.Equal(now) {
+		t.Fatalf("total history = %+v at step %d, want 200 Mbps at %v", points, step, now)
+	}
+
+	for agg, want := range map[store.BandwidthAgg]float64{"": 200, store.BandwidthAggAvg: 100, store.BandwidthAggMax: 150} {
+		points, _, err := svc.BandwidthHistory(ctx, now.Add(-time.Hour), now.Add(time.Hour), 60, 0, agg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != 1 || points[0].Mbps != want || points[0].AvgMbps != 200 {
+			t.Errorf("agg %q: history = %+v, want %v Mbps", agg, points, want)
+		}
+	}
+	if _, _, err := svc.BandwidthHistory(ctx, now.Add(-time.Hour), now, 60, 0, "median"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("agg median: expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestAgentBandwidthHistory(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 12, 30, 0, 0, time.UTC)
+	if err := st.Agents().Upsert(ctx, &model.Agent{ID: "a1", Status: model.AgentStatusOnline, LastHeartbeat: now, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*model.BandwidthSample{
+		{AgentID: "a1", RateMbps: 100, RecordedAt: now},
+		{AgentID: "a1", RateMbps: 300, RecordedAt: now.Add(30 * time.Second)},
+		{AgentID: "a2", RateMbps: 50, RecordedAt: now.Add(10 * time.Second)},
+	} {
+		if err := st.Bandwidth().Insert(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := NewDashboardService(st)
+	points, step, err := svc.AgentBandwidthHistory(ctx, "a1", now.Add(-time.Hour), now.Add(time.Hour), 60, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step != 60 || len(points) != 1 || points[0].Mbps != 200 || points[0].MaxMbps != 300 {
+		t.Fatalf("a1 history = %+v at step %d, want 200 Mbps peaking at 300", points, step)
+	}
+	if _, _, err := svc.AgentBandwidthHistory(ctx, "missing", time.Time{}, time.Time{}, 60, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("unknown agent: expected ErrNotFound, got %v", err)
+	}
+}
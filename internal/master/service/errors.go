@@ -0,0 +1,42 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aven/ngoogle/internal/errkind"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// ErrInvalidInput marks errors caused by a malformed or inconsistent request.
+var ErrInvalidInput = errors.New("invalid input")
+
+// ErrNotAssigned marks an agent's report on a task it was not assigned.
+var ErrNotAssigned = errors.New("task not assigned to agent")
+
+// ErrThrottled marks a report dropped because it followed the previous one
+// too closely.
+var ErrThrottled = errors.New("report throttled")
+
+// ThrottledError is returned for a throttled report and matches
+// ErrThrottled. RetryAfter is how long until the next report is accepted.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("report throttled; the next is accepted in %s", e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *ThrottledError) Is(target error) bool { return target == ErrThrottled }
+
+// invalidf returns a validation error matching ErrInvalidInput.
+func invalidf(format string, args ...any) error {
+	return errkind.Errorf(ErrInvalidInput, format, args...)
+}
+
+// conflictf returns a state error matching store.ErrConflict.
+func conflictf(format string, args ...any) error {
+	return errkind.Errorf(store.ErrConflict, format, args...)
+}
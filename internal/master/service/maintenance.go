@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// MaintenanceService manages maintenance windows, during which the
+// scheduler starts no tasks.
+type MaintenanceService struct {
+	store store.Store
+}
+
+// NewMaintenanceService creates a new MaintenanceService.
+func NewMaintenanceService(st store.Store) *MaintenanceService {
+	return &MaintenanceService{store: st}
+}
+
+// MaintenanceWindowRequest is the body for creating or updating a
+// maintenance window. Give either start_at and end_at for a one-off window,
+// or daily_start and daily_end ("HH:MM", in timezone) for a daily one.
+type MaintenanceWindowRequest struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	StartAt     *time.Time `json:"start_at,omitempty"`
+	EndAt       *time.Time `json:"end_at,omitempty"`
+	DailyStart  string     `json:"daily_start,omitempty"`
+	DailyEnd    string     `json:"daily_end,omitempty"`
+	Timezone    string     `json:"timezone,omitempty"`
+}
+
+// Create saves a new maintenance window.
+func (s *MaintenanceService) Create(ctx context.Context, req *MaintenanceWindowRequest) (*model.MaintenanceWindow, error) {
+	now := time.Now()
+	w := &model.MaintenanceWindow{ID: generateID(), CreatedAt: now, UpdatedAt: now}
+	if err := applyMaintenanceWindow(w, req); err != nil {
+		return nil, err
+	}
+	if err := s.store.MaintenanceWindows().Create(ctx, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *MaintenanceService) Get(ctx context.Context, id string) (*model.MaintenanceWindow, error) {
+	return s.store.MaintenanceWindows().Get(ctx, id)
+}
+
+func (s *MaintenanceService) List(ctx context.Context) ([]*model.MaintenanceWindow, error) {
+	return s.store.MaintenanceWindows().List(ctx)
+}
+
+// Update replaces a window's name, description and times.
+func (s *MaintenanceService) Update(ctx context.Context, id string, req *MaintenanceWindowRequest) (*model.MaintenanceWindow, error) {
+	w, err := s.store.MaintenanceWindows().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyMaintenanceWindow(w, req); err != nil {
+		return nil, err
+	}
+	w.UpdatedAt = time.Now()
+	if err := s.store.MaintenanceWindows().Update(ctx, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *MaintenanceService) Delete(ctx context.Context, id string) error {
+	if _, err := s.store.MaintenanceWindows().Get(ctx, id); err != nil {
+		return err
+	}
+	return s.store.MaintenanceWindows().Delete(ctx, id)
+}
+
+// applyMaintenanceWindow checks req and copies it onto w.
+func applyMaintenanceWindow(w *model.MaintenanceWindow, req *MaintenanceWindowRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return invalidf("name is required")
+	}
+	once := req.StartAt != nil || req.EndAt != nil
+	daily := req.DailyStart != "" || req.DailyEnd != ""
+	switch {
+	case once && daily:
+		return invalidf("give either start_at and end_at or daily_start and daily_end, not both")
+	case once:
+		if req.StartAt == nil || req.EndAt == nil {
+			return invalidf("start_at and end_at are both required")
+		}
+		if !req.EndAt.After(*req.StartAt) {
+			return invalidf("end_at must be after start_at")
+		}
+		if req.Timezone != "" {
+			return invalidf("timezone only applies to daily windows; give start_at and end_at with an offset")
+		}
+	case daily:
+		from, err := model.ParseClock(req.DailyStart)
+		if err != nil {
+			return invalidf("daily_start: %v", err)
+		}
+		to, err := model.ParseClock(req.DailyEnd)
+		if err != nil {
+			return invalidf("daily_end: %v", err)
+		}
+		if from == to {
+			return invalidf("daily_start and daily_end must differ")
+		}
+		if req.Timezone != "" {
+			if _, err := time.LoadLocation(req.Timezone); err != nil {
+				return invalidf("invalid timezone: %s", req.Timezone)
+			}
+		}
+	default:
+		return invalidf("give start_at and end_at, or daily_start and daily_end")
+	}
+	w.Name, w.Description = name, req.Description
+	w.StartAt, w.EndAt = req.StartAt, req.EndAt
+	w.DailyStart, w.DailyEnd, w.Timezone = req.DailyStart, req.DailyEnd, req.Timezone
+	return nil
+}
+
+// activeMaintenance returns the maintenance window active at now that ends
+// last, with its end, or nil if there is none.
+func activeMaintenance(ctx context.Context, st store.Store, now time.Time) (*model.MaintenanceWindow, time.Time, error) {
+	windows, err := st.MaintenanceWindows().List(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	w, until := model.ActiveMaintenance(windows, now)
+	return w, until, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestMaintenanceWindowDefersDispatch(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewMaintenanceService(st)
+	taskSvc := NewTaskService(st)
+
+	now := time.Now()
+	past, later := now.Add(-time.Hour), now.Add(time.Hour)
+	for name, req := range map[string]MaintenanceWindowRequest{
+		"no name":       {StartAt: &past, EndAt: &later},
+		"no times":      {Name: "w"},
+		"no end":        {Name: "w", StartAt: &past},
+		"end first":     {Name: "w", StartAt: &later, EndAt: &past},
+		"both kinds":    {Name: "w", StartAt: &past, EndAt: &later, DailyStart: "01:00", DailyEnd: "02:00"},
+		"one-off zone":  {Name: "w", StartAt: &past, EndAt: &later, Timezone: "UTC"},
+		"bad clock":     {Name: "w", DailyStart: "25:00", DailyEnd: "02:00"},
+		"no daily end":  {Name: "w", DailyStart: "01:00"},
+		"empty daily":   {Name: "w", DailyStart: "01:00", DailyEnd: "01:00"},
+		"bad time zone": {Name: "w", DailyStart: "01:00", DailyEnd: "02:00", Timezone: "Mars/Olympus"},
+	} {
+		if _, err := svc.Create(ctx, &req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: err = %v, want ErrInvalidInput", name, err)
+		}
+	}
+
+	w, err := svc.Create(ctx, &MaintenanceWindowRequest{Name: "freeze", StartAt: &past, EndAt: &later})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := taskSvc.Create(ctx, &CreateTaskRequest{
+		TargetURL: "https://example.com/a.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := taskSvc.Dispatch(ctx, task.ID); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("dispatch during window: err = %v, want ErrConflict", err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.Status != model.TaskStatusPending {
+		t.Fatalf("status = %s, want pending", got.Status)
+	}
+
+	if _, err := svc.Update(ctx, w.ID, &MaintenanceWindowRequest{Name: "freeze", StartAt: &past, EndAt: &now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := taskSvc.Dispatch(ctx, task.ID); err != nil {
+		t.Fatalf("dispatch after window: %v", err)
+	}
+	if err := svc.Delete(ctx, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Delete(ctx, w.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("delete twice: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// RatePolicy bounds the target_rate_mbps of new tasks and task groups, so
+// that a mistyped rate cannot flood the links the fleet shares.
+type RatePolicy struct {
+	// MaxTaskMbps is the highest rate a task or task group may ask for;
+	// zero means no limit.
+	MaxTaskMbps float64
+	// Clamp lowers a rate above a limit to the limit, with a warning in the
+	// log, instead of rejecting the request.
+	Clamp bool
+}
+
+// SetRatePolicy sets the limits Create applies to target_rate_mbps. Besides
+// p.MaxTaskMbps, a task that runs on a single agent may not ask for more than
+// the capacity the agent advertised. Task groups are checked against the
+// same limits through TaskGroupService.
+func (s *TaskService) SetRatePolicy(p RatePolicy) error {
+	if p.MaxTaskMbps < 0 {
+		return fmt.Errorf("maximum task rate %g Mbps must not be negative", p.MaxTaskMbps)
+	}
+	s.ratePolicy = p
+	return nil
+}
+
+// applyRatePolicy checks *rate, the target_rate_mbps of a new task or task
+// group with the given scope and agent, against the rate policy, lowering
+// it to the limit in clamp mode. what names the object in messages. Shared
+// tasks divide their rate among their agents, so only the policy's maximum
+// applies to them.
+func (s *TaskService) applyRatePolicy(ctx context.Context, what string, scope model.TaskExecutionScope, agentID string, rate *float64) error {
+	if *rate <= 0 {
+		return nil
+	}
+	limit, source := s.ratePolicy.MaxTaskMbps, "the maximum task rate"
+	if scope == model.TaskExecutionScopeSingleAgent && agentID != "" {
+		a, err := s.store.Agents().Get(ctx, agentID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+		case err != nil:
+			return err
+		case a.CapacityMbps > 0 && (limit <= 0 || a.CapacityMbps < limit):
+			limit, source = a.CapacityMbps, fmt.Sprintf("agent %s's capacity", agentID)
+		}
+	}
+	if limit <= 0 || *rate <= limit {
+		return nil
+	}
+	if !s.ratePolicy.Clamp {
+		return invalidf("target_rate_mbps %g exceeds %s of %g Mbps", *rate, source, limit)
+	}
+	slog.Warn("clamping "+what+" rate", "requested_mbps", *rate, "limit_mbps", limit, "limit", source)
+	*rate = limit
+	return nil
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestRatePolicyRejectsOrClampsRates(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	agent := &model.Agent{ID: "small", Hostname: "small", Status: model.AgentStatusOnline, CapacityMbps: 200,
+		LastHeartbeat: now, CreatedAt: now, UpdatedAt: now}
+	if err := st.Agents().Upsert(ctx, agent); err != nil {
+		t.Fatal(err)
+	}
+	pool := &model.URLPool{ID: "pool", Name: "Files", Type: model.URLPoolTypeStatic, CreatedAt: now, UpdatedAt: now}
+	pool.SetURLs([]string{"https://example.com/a.bin"})
+	if err := st.URLPools().Create(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewTaskService(st)
+	groupSvc := NewTaskGroupService(st, svc)
+	if err := svc.SetRatePolicy(RatePolicy{MaxTaskMbps: -1}); err == nil {
+		t.Fatal("negative maximum accepted")
+	}
+	if err := svc.SetRatePolicy(RatePolicy{MaxTaskMbps: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	global := func(rate float64) *CreateTaskRequest {
+		return &CreateTaskRequest{TargetURL: "https://example.com/a.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: rate}
+	}
+	single := func(rate float64) *CreateTaskRequest {
+		return &CreateTaskRequest{TargetURL: "https://example.com/a.bin", AgentID: "small", TargetRateMbps: rate}
+	}
+
+	if _, err := svc.Create(ctx, global(1000)); err != nil {
+		t.Fatalf("rate at the maximum: %v", err)
+	}
+	_, err = svc.Create(ctx, global(100000))
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "maximum task rate of 1000 Mbps") {
+		t.Fatalf("rate above the maximum: err = %v, want ErrInvalidInput naming the maximum", err)
+	}
+	_, err = svc.Create(ctx, single(500))
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "agent small's capacity of 200 Mbps") {
+		t.Fatalf("rate above the agent's capacity: err = %v, want ErrInvalidInput naming the capacity", err)
+	}
+	if _, err := groupSvc.Create(ctx, &CreateTaskGroupRequest{PoolIDs: []string{"pool"}, TargetRateMbps: 5000}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("group rate above the maximum: err = %v, want ErrInvalidInput", err)
+	}
+
+	if err := svc.SetRatePolicy(RatePolicy{MaxTaskMbps: 1000, Clamp: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		req  *CreateTaskRequest
+		want float64
+	}{
+		{"global", global(100000), 1000},
+		{"single agent", single(500), 200},
+		{"within limits", single(150), 150},
+	} {
+		task, err := svc.Create(ctx, tc.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if task.TargetRateMbps != tc.want {
+			t.Errorf("%s: rate = %g, want %g", tc.name, task.TargetRateMbps, tc.want)
+		}
+	}
+	group, err := groupSvc.Create(ctx, &CreateTaskGroupRequest{PoolIDs: []string{"pool"}, TargetRateMbps: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.TargetRateMbps != 1000 || group.Children[0].TargetRateMbps != 1000 {
+		t.Fatalf("group rate = %g, child rate = %g; want both clamped to 1000", group.TargetRateMbps, group.Children[0].TargetRateMbps)
+	}
+}
@@ -2,28 +2,99 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"log/slog"
+	"math"
 	"net/url"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/aven/ngoogle/internal/errkind"
 	"github.com/aven/ngoogle/internal/model"
 	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/pkg/latency"
 )
 
 // TaskService handles task CRUD and state transitions.
 type TaskService struct {
-	store store.Store
+	store    store.Store
+	notifier *taskNotifier
+
+	// Metric report throttling; see SetMetricsMinInterval and RecordMetrics.
+	metricsMinInterval time.Duration
+	metricsMu          sync.Mutex
+	metricsSeen        map[metricsKey]metricsReport
+	metricsSwept       time.Time
+
+	// ratePolicy bounds target_rate_mbps; see SetRatePolicy.
+	ratePolicy RatePolicy
+	// taskLimits bounds the byte and request targets; see SetTaskLimits.
+	taskLimits TaskLimits
+}
+
+// metricsKey identifies one agent's metric series for a task.
+type metricsKey struct{ taskID, agentID string }
+
+// metricsReport records the last report RecordMetrics accepted for a series.
+type metricsReport struct {
+	at    time.Time
+	final bool // sent after the task ended
 }
 
+// DefaultMetricsMinInterval is the shortest gap between accepted metric
+// reports for the same task and agent unless SetMetricsMinInterval
+// overrides it. Agents report every 5s.
+const DefaultMetricsMinInterval = 2 * time.Second
+
+// finalMetricsGrace is how long after a task ends its agents may still send
+// their final report. Agents send it once they see the task end, which for
+// a stopped task can take a pull interval.
+const finalMetricsGrace = 5 * time.Minute
+
 // NewTaskService creates a new TaskService.
 func NewTaskService(st store.Store) *TaskService {
-	return &TaskService{store: st}
+	return &TaskService{
+		store:              st,
+		notifier:           newTaskNotifier(),
+		metricsMinInterval: DefaultMetricsMinInterval,
+		metricsSeen:        make(map[metricsKey]metricsReport),
+	}
+}
+
+// SetMetricsMinInterval makes RecordMetrics refuse reports for a task and
+// agent arriving sooner than d after the last one it accepted; zero accepts
+// every report.
+func (s *TaskService) SetMetricsMinInterval(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("metrics interval %s must not be negative", d)
+	}
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	s.metricsMinInterval = d
+	return nil
 }
 
 // Create creates a new task.
 func (s *TaskService) Create(ctx context.Context, req *CreateTaskRequest) (*model.Task, error) {
+	t, err := s.newTask(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Tasks().Create(ctx, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// newTask validates req and builds the task it describes without saving it.
+func (s *TaskService) newTask(ctx context.Context, req *CreateTaskRequest) (*model.Task, error) {
 	pool, urls, taskType, err := s.resolveTaskSource(ctx, req)
 	if err != nil {
 		return nil, err
@@ -34,31 +105,41 @@ func (s *TaskService) Create(ctx context.Context, req *CreateTaskRequest) (*mode
 	}
 	scope := req.ExecutionScope
 	if scope == "" {
-		if pool != nil || len(urls) > 1 {
+		switch {
+		case req.AgentGroupID != "":
+			scope = model.TaskExecutionScopeAgentGroup
+		case pool != nil || len(urls) > 1:
 			scope = model.TaskExecutionScopeGlobal
-		} else {
+		default:
 			scope = model.TaskExecutionScopeSingleAgent
 		}
 	}
-	if scope != model.TaskExecutionScopeSingleAgent && scope != model.TaskExecutionScopeGlobal {
-		return nil, fmt.Errorf("invalid execution_scope: %s", scope)
+	if err := s.checkScope(ctx, scope, &req.AgentID, &req.AgentGroupID, "tasks"); err != nil {
+		return nil, err
 	}
-	if scope == model.TaskExecutionScopeGlobal {
-		req.AgentID = ""
+	if err := req.validate(taskType, s.taskLimits); err != nil {
+		return nil, err
 	}
-	if scope == model.TaskExecutionScopeSingleAgent && req.AgentID == "" {
-		return nil, fmt.Errorf("agent_id is required for single_agent tasks")
+	if err := s.applyRatePolicy(ctx, "task", scope, req.AgentID, &req.TargetRateMbps); err != nil {
+		return nil, err
+	}
+	tz, err := s.resolveTimezone(ctx, req.Timezone, req.TrafficProfileID)
+	if err != nil {
+		return nil, err
 	}
 	now := time.Now()
 	t := &model.Task{
 		ID:                  generateID(),
 		Name:                req.Name,
+		ExternalID:          strings.TrimSpace(req.ExternalID),
 		Type:                taskType,
 		URLPoolID:           req.URLPoolID,
 		AgentID:             req.AgentID,
+		AgentGroupID:        req.AgentGroupID,
 		ExecutionScope:      scope,
 		Status:              model.TaskStatusPending,
 		TargetRateMbps:      req.TargetRateMbps,
+		TargetRps:           req.TargetRps,
 		StartAt:             req.StartAt,
 		EndAt:               req.EndAt,
 		DurationSec:         req.DurationSec,
@@ -71,7 +152,18 @@ func (s *TaskService) Create(ctx context.Context, req *CreateTaskRequest) (*mode
 		RampUpSec:           req.RampUpSec,
 		RampDownSec:         req.RampDownSec,
 		TrafficProfileID:    req.TrafficProfileID,
+		Timezone:            tz,
 		ConcurrentFragments: req.ConcurrentFragments,
+		ReuseConnections:    reuseConnections(req.ReuseConnections),
+		RangeChunkBytes:     req.RangeChunkBytes,
+		ReadChunkBytes:      req.ReadChunkBytes,
+		BurstBytes:          req.BurstBytes,
+		CacheBustParam:      req.CacheBustParam,
+		Redirects:           req.Redirects,
+		MaxRedirects:        req.MaxRedirects,
+		ExpectedBytes:       req.ExpectedBytes,
+		ExpectedSHA256:      req.ExpectedSHA256,
+		WarmupSec:           req.WarmupSec,
 		Retries:             req.Retries,
 		CreatedAt:           now,
 		UpdatedAt:           now,
@@ -87,22 +179,22 @@ func (s *TaskService) Create(ctx context.Context, req *CreateTaskRequest) (*mode
 	if t.ConcurrentFragments <= 0 {
 		t.ConcurrentFragments = 1
 	}
-	if err := s.store.Tasks().Create(ctx, t); err != nil {
-		return nil, err
-	}
 	return t, nil
 }
 
 // CreateTaskRequest is the input for task creation.
 type CreateTaskRequest struct {
 	Name                string                   `json:"name"`
+	ExternalID          string                   `json:"external_id,omitempty"`
 	Type                model.TaskType           `json:"type"`
 	URLPoolID           string                   `json:"url_pool_id"`
 	TargetURL           string                   `json:"target_url"`
 	TargetURLs          []string                 `json:"target_urls"`
 	AgentID             string                   `json:"agent_id"`
+	AgentGroupID        string                   `json:"agent_group_id,omitempty"`
 	ExecutionScope      model.TaskExecutionScope `json:"execution_scope"`
 	TargetRateMbps      float64                  `json:"target_rate_mbps"`
+	TargetRps           float64                  `json:"target_rps,omitempty"`
 	StartAt             *time.Time               `json:"start_at,omitempty"`
 	EndAt               *time.Time               `json:"end_at,omitempty"`
 	DurationSec         int                      `json:"duration_sec"`
@@ -115,10 +207,203 @@ type CreateTaskRequest struct {
 	RampUpSec           int                      `json:"ramp_up_sec"`
 	RampDownSec         int                      `json:"ramp_down_sec"`
 	TrafficProfileID    string                   `json:"traffic_profile_id"`
+	Timezone            string                   `json:"timezone,omitempty"`
 	ConcurrentFragments int                      `json:"concurrent_fragments"`
+	ReuseConnections    *bool                    `json:"reuse_connections,omitempty"`
+	RangeChunkBytes     int64                    `json:"range_chunk_bytes,omitempty"`
+	ReadChunkBytes      int64                    `json:"read_chunk_bytes,omitempty"`
+	BurstBytes          int64                    `json:"burst_bytes,omitempty"`
+	CacheBustParam      string                   `json:"cache_bust_param,omitempty"`
+	Redirects           model.RedirectPolicy     `json:"redirects,omitempty"`
+	MaxRedirects        int                      `json:"max_redirects,omitempty"`
+	ExpectedBytes       int64                    `json:"expected_bytes,omitempty"`
+	ExpectedSHA256      string                   `json:"expected_sha256,omitempty"`
+	WarmupSec           int                      `json:"warmup_sec,omitempty"`
 	Retries             int                      `json:"retries"`
 }
 
+// validate checks the fields of req that need no lookups, for a task of
+// the given type, and normalizes expected_sha256.
+func (req *CreateTaskRequest) validate(taskType model.TaskType, limits TaskLimits) error {
+	if err := validateTaskRate(req, taskType); err != nil {
+		return err
+	}
+	if err := limits.check(req.StartAt, req.EndAt, req.DurationSec, req.RampUpSec, req.RampDownSec,
+		req.TotalBytesTarget, req.TotalRequestsTarget); err != nil {
+		return err
+	}
+	if req.WarmupSec < 0 {
+		return invalidf("warmup_sec must not be negative")
+	}
+	if req.RangeChunkBytes < 0 {
+		return invalidf("range_chunk_bytes must not be negative")
+	}
+	if err := validateReadChunk(req.ReadChunkBytes); err != nil {
+		return err
+	}
+	if err := validateBurst(req.BurstBytes, req.ReadChunkBytes); err != nil {
+		return err
+	}
+	if err := validateCacheBust(req.CacheBustParam); err != nil {
+		return err
+	}
+	if err := validateRedirects(req.Redirects, req.MaxRedirects); err != nil {
+		return err
+	}
+	return validateContentCheck(taskType, req.ExpectedBytes, &req.ExpectedSHA256)
+}
+
+// checkScope validates a task's or task group's execution scope and clears
+// the agent or agent group id the scope does not use. what names the kind of
+// object in error messages.
+func (s *TaskService) checkScope(ctx context.Context, scope model.TaskExecutionScope, agentID, agentGroupID *string, what string) error {
+	switch scope {
+	case model.TaskExecutionScopeSingleAgent, model.TaskExecutionScopeGlobal, model.TaskExecutionScopeAgentGroup:
+	default:
+		return invalidf("invalid execution_scope: %s", scope)
+	}
+	if scope != model.TaskExecutionScopeSingleAgent {
+		*agentID = ""
+	}
+	if scope != model.TaskExecutionScopeAgentGroup {
+		*agentGroupID = ""
+	}
+	switch {
+	case scope == model.TaskExecutionScopeSingleAgent && *agentID == "":
+		return invalidf("agent_id is required for single_agent %s", what)
+	case scope == model.TaskExecutionScopeAgentGroup && *agentGroupID == "":
+		return invalidf("agent_group_id is required for agent_group %s", what)
+	case scope == model.TaskExecutionScopeAgentGroup:
+		_, err := s.store.AgentGroups().Get(ctx, *agentGroupID)
+		if errors.Is(err, store.ErrNotFound) {
+			return invalidf("agent group %s not found", *agentGroupID)
+		}
+		return err
+	}
+	return nil
+}
+
+// validateTaskRate checks that a task runs in exactly one mode: bandwidth
+// (target_rate_mbps) or constant request rate (target_rps). RPS mode paces
+// requests itself, so it excludes dispatch_rate_tpm, and it is only
+// implemented by the static and DNS executors; DNS tasks, whose queries
+// carry next to no bytes, run in no other mode.
+func validateTaskRate(req *CreateTaskRequest, taskType model.TaskType) error {
+	if req.TargetRateMbps < 0 || req.TargetRps < 0 {
+		return invalidf("target_rate_mbps and target_rps must not be negative")
+	}
+	if (req.TargetRateMbps > 0) == (req.TargetRps > 0) {
+		return invalidf("exactly one of target_rate_mbps and target_rps must be set")
+	}
+	if taskType == model.TaskTypeDNS && req.TargetRps <= 0 {
+		return invalidf("dns tasks are paced by target_rps")
+	}
+	if req.TargetRps > 0 {
+		if taskType != model.TaskTypeStatic && taskType != model.TaskTypeDNS {
+			return invalidf("target_rps is only supported for static and dns tasks")
+		}
+		if req.DispatchRateTpm > 0 {
+			return invalidf("target_rps and dispatch_rate_tpm cannot both be set")
+		}
+	}
+	return nil
+}
+
+// validateReadChunk checks read_chunk_bytes: zero leaves the agent's default
+// buffer, anything else must lie within the bounds the agent accepts.
+func validateReadChunk(n int64) error {
+	if n != 0 && (n < model.MinReadChunkBytes || n > model.MaxReadChunkBytes) {
+		return invalidf("read_chunk_bytes must be between %d and %d", model.MinReadChunkBytes, model.MaxReadChunkBytes)
+	}
+	return nil
+}
+
+// validateBurst checks burst_bytes: zero leaves the burst at two seconds of
+// the task's rate, anything else must hold at least one read of readChunk
+// bytes.
+func validateBurst(burst, readChunk int64) error {
+	if readChunk == 0 {
+		readChunk = model.DefaultReadChunkBytes
+	}
+	if burst != 0 && burst < readChunk {
+		return invalidf("burst_bytes must be at least read_chunk_bytes (%d)", readChunk)
+	}
+	return nil
+}
+
+// validateCacheBust checks that a cache_bust_param, if set, can be used as
+// a query parameter name as is.
+func validateCacheBust(param string) error {
+	if param != "" && url.QueryEscape(param) != param {
+		return invalidf("cache_bust_param %q must not need escaping in a query string", param)
+	}
+	return nil
+}
+
+// validateRedirects checks a redirect policy and limit.
+func validateRedirects(policy model.RedirectPolicy, limit int) error {
+	switch policy {
+	case "", model.RedirectFollow, model.RedirectSameHost:
+	case model.RedirectNone:
+		if limit != 0 {
+			return invalidf("max_redirects cannot be set when redirects is none")
+		}
+	default:
+		return invalidf("redirects must be follow, same_host or none, not %q", policy)
+	}
+	if limit < 0 {
+		return invalidf("max_redirects must not be negative")
+	}
+	return nil
+}
+
+// validateContentCheck checks the expected size and digest of a task's
+// downloads and lowercases the digest. Only static tasks verify content.
+func validateContentCheck(taskType model.TaskType, size int64, sha *string) error {
+	*sha = strings.ToLower(strings.TrimSpace(*sha))
+	if size == 0 && *sha == "" {
+		return nil
+	}
+	if taskType != model.TaskTypeStatic {
+		return invalidf("expected_bytes and expected_sha256 are only supported for static tasks")
+	}
+	if size < 0 {
+		return invalidf("expected_bytes must not be negative")
+	}
+	if *sha != "" {
+		if b, err := hex.DecodeString(*sha); err != nil || len(b) != sha256.Size {
+			return invalidf("expected_sha256 must be %d hex digits", 2*sha256.Size)
+		}
+	}
+	return nil
+}
+
+// reuseConnections applies the default for reuse_connections: keep HTTP
+// connections alive between requests unless the request sets it to false.
+func reuseConnections(v *bool) bool {
+	return v == nil || *v
+}
+
+// resolveTimezone validates an IANA zone name. An empty name inherits the
+// zone of the referenced traffic profile, if any.
+func (s *TaskService) resolveTimezone(ctx context.Context, tz, profileID string) (string, error) {
+	if tz == "" && profileID != "" {
+		p, err := s.store.TrafficProfiles().Get(ctx, profileID)
+		switch {
+		case err == nil:
+			tz = p.Timezone
+		case !errors.Is(err, store.ErrNotFound):
+			return "", err
+		}
+	}
+	if tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return "", invalidf("invalid timezone: %s", tz)
+		}
+	}
+	return tz, nil
+}
+
 // Get returns a single task.
 func (s *TaskService) Get(ctx context.Context, id string) (*model.Task, error) {
 	t, err := s.store.Tasks().Get(ctx, id)
@@ -143,17 +428,42 @@ func (s *TaskService) List(ctx context.Context) ([]*model.Task, error) {
 	return tasks, nil
 }
 
-// Dispatch dispatches a task to its assigned agent.
+// Dispatch dispatches a task to its assigned agent. Tasks with a start_at
+// in the future are left to the scheduler, which starts them on time, and
+// dispatching one is invalid input; tasks whose window has already passed
+// are failed rather than run late.
+// During a maintenance window the task is left pending too, and the
+// scheduler starts it once the window ends.
 func (s *TaskService) Dispatch(ctx context.Context, taskID string) error {
 	t, err := s.store.Tasks().Get(ctx, taskID)
 	if err != nil {
 		return err
 	}
 	if t.Status != model.TaskStatusPending {
-		return fmt.Errorf("task %s is not pending (status=%s)", taskID, t.Status)
+		return conflictf("task %s is not pending (status=%s)", taskID, t.Status)
 	}
 	now := time.Now()
-	return s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusDispatched, now, "dispatched_at")
+	if t.StartAt != nil && now.Before(*t.StartAt) {
+		return invalidf("task %s is scheduled to start at %s and will be started then", taskID, t.StartAt.Format(time.RFC3339))
+	}
+	if t.MissedWindow(now) {
+		if err := s.MarkFailed(ctx, taskID, "start window missed"); err != nil {
+			return err
+		}
+		return conflictf("task %s missed its start window and was marked failed", taskID)
+	}
+	mw, until, err := activeMaintenance(ctx, s.store, now)
+	if err != nil {
+		return err
+	}
+	if mw != nil {
+		return conflictf("maintenance window %q is active until %s; task %s will be started then", mw.Name, until.Format(time.RFC3339), taskID)
+	}
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusDispatched, now, "dispatched_at"); err != nil {
+		return err
+	}
+	s.NotifyTask(t)
+	return nil
 }
 
 // Stop stops a running or dispatched task.
@@ -163,15 +473,110 @@ func (s *TaskService) Stop(ctx context.Context, taskID string) error {
 		return err
 	}
 	if t.Status == model.TaskStatusDone || t.Status == model.TaskStatusFailed || t.Status == model.TaskStatusStopped {
-		return fmt.Errorf("task %s is already terminal (status=%s)", taskID, t.Status)
+		return conflictf("task %s is already terminal (status=%s)", taskID, t.Status)
+	}
+	now := time.Now()
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusStopped, now, "finished_at"); err != nil {
+		return err
+	}
+	s.saveResult(ctx, taskID, "stopped")
+	s.NotifyTask(t)
+	return nil
+}
+
+// Pause pauses a dispatched or running task. Agents stop executing it but
+// keep their byte counts, and the time it spends paused does not count
+// towards its duration.
+func (s *TaskService) Pause(ctx context.Context, taskID string) error {
+	t, err := s.store.Tasks().Get(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	if t.Status != model.TaskStatusDispatched && t.Status != model.TaskStatusRunning {
+		return conflictf("task %s is not dispatched or running (status=%s)", taskID, t.Status)
 	}
 	now := time.Now()
-	return s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusStopped, now, "finished_at")
+	if err := s.store.Tasks().UpdatePause(ctx, taskID, model.TaskStatusPaused, &now, t.PausedSec); err != nil {
+		return err
+	}
+	s.NotifyTask(t)
+	return nil
 }
 
-// RecordMetrics saves task metrics from an agent report.
+// Resume returns a paused task to the status it was paused from: running if
+// it had started, dispatched otherwise. Agents carry on from the bytes they
+// had reported.
+func (s *TaskService) Resume(ctx context.Context, taskID string) error {
+	t, err := s.store.Tasks().Get(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	if t.Status != model.TaskStatusPaused {
+		return conflictf("task %s is not paused (status=%s)", taskID, t.Status)
+	}
+	pausedSec := t.PausedSec
+	if t.PausedAt != nil {
+		pausedSec += int(time.Since(*t.PausedAt) / time.Second)
+	}
+	status := model.TaskStatusRunning
+	if t.StartedAt == nil {
+		status = model.TaskStatusDispatched
+	}
+	if err := s.store.Tasks().UpdatePause(ctx, taskID, status, nil, pausedSec); err != nil {
+		return err
+	}
+	s.NotifyTask(t)
+	return nil
+}
+
+// StopByAgent stops every pending, dispatched, running or paused task
+// assigned to an agent and returns how many were stopped. The agent stops
+// pulling them and cancels their execution. Tasks that target an agent
+// group are left alone.
+func (s *TaskService) StopByAgent(ctx context.Context, agentID string) (int, error) {
+	if _, err := s.store.Agents().Get(ctx, agentID); err != nil {
+		return 0, err
+	}
+	tasks, err := s.store.Tasks().ListByAgent(ctx, agentID, []model.TaskStatus{
+		model.TaskStatusPending, model.TaskStatusDispatched, model.TaskStatusRunning, model.TaskStatusPaused,
+	})
+	if err != nil {
+		return 0, err
+	}
+	stopped := 0
+	for _, t := range tasks {
+		if t.ExecutionScope == model.TaskExecutionScopeAgentGroup {
+			continue
+		}
+		if err := s.Stop(ctx, t.ID); err != nil {
+			return stopped, err
+		}
+		stopped++
+	}
+	return stopped, nil
+}
+
+// RecordMetrics saves task metrics from an agent report, which must come
+// from an agent the task runs on. A report arriving within the minimum
+// interval of the last one accepted for its task and agent is dropped
+// with a *ThrottledError, keeping each series at a steady resolution however
+// often an agent reports, unless it is marked final: an agent's last report
+// always counts. Once the task has ended, each agent's final report is
+// still accepted for a grace period, so the task's byte total includes it;
+// any other report is a conflict.
 func (s *TaskService) RecordMetrics(ctx context.Context, m *model.TaskMetrics) error {
-	m.RecordedAt = time.Now()
+	t, err := s.store.Tasks().Get(ctx, m.TaskID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkAssigned(ctx, t, m.AgentID); err != nil {
+		return err
+	}
+	now := time.Now()
+	if ok, err := s.admitMetrics(t, m.AgentID, m.Final, now); !ok {
+		return err
+	}
+	m.RecordedAt = now
 	if err := s.store.TaskMetrics().Insert(ctx, m); err != nil {
 		return err
 	}
@@ -186,7 +591,76 @@ func (s *TaskService) RecordMetrics(ctx context.Context, m *model.TaskMetrics) e
 	return s.store.Tasks().UpdateBytes(ctx, m.TaskID, totalBytes)
 }
 
-// PullTasks returns tasks assigned to an agent that are ready to execute.
+// checkAssigned returns an error matching ErrNotAssigned unless agentID is
+// one of the agents t runs on, as PullTasks hands tasks out: its agent, any
+// registered agent for a global task, or a member of its agent group.
+// Callers authenticate the agent first; agentID is the one its token
+// belongs to.
+func (s *TaskService) checkAssigned(ctx context.Context, t *model.Task, agentID string) error {
+	var assigned bool
+	switch t.ExecutionScope {
+	case model.TaskExecutionScopeGlobal:
+		_, err := s.store.Agents().Get(ctx, agentID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		assigned = err == nil
+	case model.TaskExecutionScopeAgentGroup:
+		groups, err := s.store.AgentGroups().GroupsOf(ctx, agentID)
+		if err != nil {
+			return err
+		}
+		assigned = slices.Contains(groups, t.AgentGroupID)
+	default:
+		assigned = agentID != "" && t.AgentID == agentID
+	}
+	if !assigned {
+		return errkind.Errorf(ErrNotAssigned, "task %s is not assigned to agent %q", t.ID, agentID)
+	}
+	return nil
+}
+
+// admitMetrics reports whether to record a metrics report from agentID for
+// t arriving at now, and claims the series' slot if so. A report marked
+// final is never throttled; others within the minimum interval get a
+// *ThrottledError. It returns a conflict for reports on an ended task other
+// than the final one.
+func (s *TaskService) admitMetrics(t *model.Task, agentID string, final bool, now time.Time) (bool, error) {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	// Forget series quiet for longer than the grace period, such as those
+	// of ended tasks, whose late reports the grace period rejects anyway.
+	if now.Sub(s.metricsSwept) > finalMetricsGrace {
+		for k, r := range s.metricsSeen {
+			if now.Sub(r.at) > finalMetricsGrace {
+				delete(s.metricsSeen, k)
+			}
+		}
+		s.metricsSwept = now
+	}
+	k := metricsKey{t.ID, agentID}
+	last, seen := s.metricsSeen[k]
+	switch t.Status {
+	case model.TaskStatusDone, model.TaskStatusFailed, model.TaskStatusStopped:
+		if last.final || t.FinishedAt == nil || now.Sub(*t.FinishedAt) > finalMetricsGrace {
+			return false, conflictf("task %s has ended (status=%s); metrics are no longer accepted", t.ID, t.Status)
+		}
+		s.metricsSeen[k] = metricsReport{at: now, final: true}
+		return true, nil
+	}
+	if seen && !final && now.Sub(last.at) < s.metricsMinInterval {
+		return false, &ThrottledError{RetryAfter: s.metricsMinInterval - now.Sub(last.at)}
+	}
+	s.metricsSeen[k] = metricsReport{at: now}
+	return true, nil
+}
+
+// PullTasks returns tasks assigned to an agent that are ready to execute,
+// along with its paused tasks so that the agent holds on to their progress.
+//
+// A disabled agent is handed only the tasks it already runs, those it has
+// reported metrics for, and shares their rate with the enabled agents;
+// new tasks are split among the enabled agents alone.
 func (s *TaskService) PullTasks(ctx context.Context, agentID string) ([]*model.Task, error) {
 	tasks, err := s.store.Tasks().List(ctx)
 	if err != nil {
@@ -196,15 +670,72 @@ func (s *TaskService) PullTasks(ctx context.Context, agentID string) ([]*model.T
 	if err != nil {
 		return nil, err
 	}
-	onlineAgents := 0
+	online := map[string]bool{}
+	disabled := map[string]bool{}
+	onlineAgents, onlineDisabled := 0, 0
 	for _, a := range agents {
+		if !a.Enabled {
+			disabled[a.ID] = true
+		}
 		if a.Status == model.AgentStatusOnline {
-			onlineAgents++
+			online[a.ID] = true
+			if a.Enabled {
+				onlineAgents++
+			} else {
+				onlineDisabled++
+			}
 		}
 	}
+	// kept returns the online disabled agents still running task.
+	kept := func(task *model.Task) (map[string]bool, error) {
+		if onlineDisabled == 0 {
+			return nil, nil
+		}
+		snapshots, err := s.store.TaskMetrics().LatestByTaskAgents(ctx, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		ids := map[string]bool{}
+		for _, snap := range snapshots {
+			if disabled[snap.AgentID] && online[snap.AgentID] {
+				ids[snap.AgentID] = true
+			}
+		}
+		return ids, nil
+	}
+	// Group membership is looked up only when a group-scoped task is active.
+	var memberOf map[string]bool
+	groupMembers := map[string][]string{}
 	var runnable []*model.Task
 	for _, task := range tasks {
-		if task.Status != model.TaskStatusDispatched && task.Status != model.TaskStatusRunning {
+		if task.Status != model.TaskStatusDispatched && task.Status != model.TaskStatusRunning && task.Status != model.TaskStatusPaused {
+			continue
+		}
+		switch task.ExecutionScope {
+		case model.TaskExecutionScopeSingleAgent, "":
+			if task.AgentID != agentID {
+				continue
+			}
+		case model.TaskExecutionScopeAgentGroup:
+			if memberOf == nil {
+				ids, err := s.store.AgentGroups().GroupsOf(ctx, agentID)
+				if err != nil {
+					return nil, err
+				}
+				memberOf = make(map[string]bool, len(ids))
+				for _, id := range ids {
+					memberOf[id] = true
+				}
+			}
+			if !memberOf[task.AgentGroupID] {
+				continue
+			}
+		}
+		keep, err := kept(task)
+		if err != nil {
+			return nil, err
+		}
+		if disabled[agentID] && !keep[agentID] {
 			continue
 		}
 		task, err = s.attachURLPool(ctx, task)
@@ -214,27 +745,48 @@ func (s *TaskService) PullTasks(ctx context.Context, agentID string) ([]*model.T
 		task.Normalize()
 		switch task.ExecutionScope {
 		case model.TaskExecutionScopeGlobal:
-			runnable = append(runnable, prepareTaskForAgent(task, agentID, onlineAgents))
+			runnable = append(runnable, prepareTaskForAgent(task, agentID, onlineAgents+len(keep)))
 		case model.TaskExecutionScopeSingleAgent, "":
-			if task.AgentID == agentID {
-				runnable = append(runnable, prepareTaskForAgent(task, agentID, onlineAgents))
+			runnable = append(runnable, prepareTaskForAgent(task, agentID, 1))
+		case model.TaskExecutionScopeAgentGroup:
+			members, ok := groupMembers[task.AgentGroupID]
+			if !ok {
+				g, err := s.store.AgentGroups().Get(ctx, task.AgentGroupID)
+				if err != nil {
+					return nil, err
+				}
+				members = g.AgentIDs
+				groupMembers[task.AgentGroupID] = members
 			}
+			n := 0
+			for _, id := range members {
+				if online[id] && (!disabled[id] || keep[id]) {
+					n++
+				}
+			}
+			runnable = append(runnable, prepareTaskForAgent(task, agentID, n))
 		}
 	}
 	return runnable, nil
 }
 
-// MarkRunning marks a task as running.
+// MarkRunning marks a task as running when an agent starts executing it, so
+// that its duration counts from then. Only the first agent to start a shared
+// task sets started_at.
 func (s *TaskService) MarkRunning(ctx context.Context, taskID string) error {
 	t, err := s.store.Tasks().Get(ctx, taskID)
 	if err != nil {
 		return err
 	}
 	switch t.Status {
-	case model.TaskStatusRunning, model.TaskStatusDone, model.TaskStatusFailed, model.TaskStatusStopped:
+	case model.TaskStatusRunning, model.TaskStatusPaused, model.TaskStatusDone, model.TaskStatusFailed, model.TaskStatusStopped:
 		return nil
 	}
-	return s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusRunning, time.Now(), "started_at")
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusRunning, time.Now(), "started_at"); err != nil {
+		return err
+	}
+	s.NotifyTask(t)
+	return nil
 }
 
 // MarkDone marks a task as done.
@@ -247,7 +799,12 @@ func (s *TaskService) MarkDone(ctx context.Context, taskID string) error {
 	case model.TaskStatusDone, model.TaskStatusFailed, model.TaskStatusStopped:
 		return nil
 	}
-	return s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusDone, time.Now(), "finished_at")
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusDone, time.Now(), "finished_at"); err != nil {
+		return err
+	}
+	s.saveResult(ctx, taskID, "completed")
+	s.NotifyTask(t)
+	return nil
 }
 
 // MarkFailed marks a task as failed with an error.
@@ -261,7 +818,12 @@ func (s *TaskService) MarkFailed(ctx context.Context, taskID string, reason stri
 		return nil
 	}
 	_ = s.store.Tasks().SetError(ctx, taskID, reason)
-	return s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusFailed, time.Now(), "finished_at")
+	if err := s.store.Tasks().UpdateStatusWithTime(ctx, taskID, model.TaskStatusFailed, time.Now(), "finished_at"); err != nil {
+		return err
+	}
+	s.saveResult(ctx, taskID, reason)
+	s.NotifyTask(t)
+	return nil
 }
 
 // GetMetrics returns metrics for a task.
@@ -269,6 +831,349 @@ func (s *TaskService) GetMetrics(ctx context.Context, taskID string, from, to ti
 	return s.store.TaskMetrics().ListByTask(ctx, taskID, from, to)
 }
 
+// TaskSummary aggregates the latest metrics of every agent running a task.
+// Totals cover the whole run, taken from an agent's final result once it has
+// sent one; AvgRateMbps and the percentiles leave out the task's warm-up
+// period.
+type TaskSummary struct {
+	TaskID            string             `json:"task_id"`
+	Agents            int                `json:"agents"`
+	WarmupSec         int                `json:"warmup_sec,omitempty"`
+	BytesTotal        int64              `json:"bytes_total"`
+	RateMbps5s        float64            `json:"rate_mbps_5s"`
+	AvgRateMbps       float64            `json:"avg_rate_mbps"`
+	RequestCount      int64              `json:"request_count"`
+	ErrorCount        int64              `json:"error_count"`
+	ContentErrorCount int64              `json:"content_error_count,omitempty"`
+	TTFB              LatencyPercentiles `json:"ttfb"`
+	Latency           LatencyPercentiles `json:"latency"`
+}
+
+// LatencyPercentiles summarises a latency histogram in milliseconds. Values
+// are bucket upper bounds, accurate to within 10%.
+type LatencyPercentiles struct {
+	Samples int64   `json:"samples"`
+	P50Ms   float64 `json:"p50_ms"`
+	P90Ms   float64 `json:"p90_ms"`
+	P95Ms   float64 `json:"p95_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+}
+
+// Summary returns a task's totals and its TTFB and response-time
+// percentiles, merged across the latest report of every agent.
+//
+// Agents report cumulative counters, so steady-state figures are the
+// difference between an agent's latest sample and its last warm-up sample.
+// An agent with no warm-up sample is measured from the task's start.
+func (s *TaskService) Summary(ctx context.Context, taskID string) (*TaskSummary, error) {
+	task, err := s.store.Tasks().Get(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := s.store.TaskMetrics().LatestByTaskAgents(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	baselines := map[string]*model.TaskMetrics{}
+	if task.WarmupSec > 0 {
+		warm, err := s.store.TaskMetrics().LatestWarmupByTaskAgents(ctx, taskID)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range warm {
+			baselines[m.AgentID] = m
+		}
+	}
+
+	results, err := s.store.TaskResults().ListAgents(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	final := map[string]*model.AgentTaskResult{}
+	for _, r := range results {
+		final[r.AgentID] = r
+	}
+
+	sum := &TaskSummary{TaskID: taskID, Agents: len(snapshots), WarmupSec: task.WarmupSec}
+	var ttfb, total latency.Histogram
+	for _, snap := range snapshots {
+		// A result older than the agent's latest report is from an
+		// execution since resumed.
+		if r := final[snap.AgentID]; r != nil && !r.ReportedAt.Before(snap.RecordedAt) {
+			sum.BytesTotal += r.BytesTotal
+			sum.RequestCount += r.RequestCount
+			sum.ErrorCount += r.ErrorCount
+		} else {
+			sum.BytesTotal += snap.BytesTotal
+			sum.RequestCount += snap.RequestCount
+			sum.ErrorCount += snap.ErrorCount
+		}
+		delete(final, snap.AgentID)
+		sum.ContentErrorCount += snap.ContentErrorCount
+		sum.RateMbps5s += snap.RateMbps5s
+
+		base := baselines[snap.AgentID]
+		if snap.Latency != nil {
+			t, r := snap.Latency.TTFB.Clone(), snap.Latency.Total.Clone()
+			if base != nil && base.Latency != nil {
+				t.Sub(base.Latency.TTFB)
+				r.Sub(base.Latency.Total)
+			}
+			ttfb.Merge(t)
+			total.Merge(r)
+		}
+
+		var baseBytes int64
+		var since time.Time
+		switch {
+		case base != nil:
+			baseBytes, since = base.BytesTotal, base.RecordedAt
+		case task.StartedAt != nil:
+			since = *task.StartedAt
+		}
+		if secs := snap.RecordedAt.Sub(since).Seconds(); !since.IsZero() && secs > 0 {
+			sum.AvgRateMbps += float64(snap.BytesTotal-baseBytes) * 8 / 1e6 / secs
+		}
+	}
+	// Agents whose execution ended before their first report.
+	for _, r := range final {
+		sum.Agents++
+		sum.BytesTotal += r.BytesTotal
+		sum.RequestCount += r.RequestCount
+		sum.ErrorCount += r.ErrorCount
+	}
+	sum.TTFB = percentiles(&ttfb)
+	sum.Latency = percentiles(&total)
+	return sum, nil
+}
+
+// RecordAgentResult saves the summary an agent sends once its execution of
+// a task returns. Its counters replace the agent's last metrics report in
+// the task's totals, and if the task has already ended, as a stopped task
+// has by the time its agents report, its result snapshot is taken again.
+func (s *TaskService) RecordAgentResult(ctx context.Context, r *model.AgentTaskResult) error {
+	t, err := s.store.Tasks().Get(ctx, r.TaskID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkAssigned(ctx, t, r.AgentID); err != nil {
+		return err
+	}
+	switch r.StopReason {
+	case model.StopReasonCompleted, model.StopReasonEndTime, model.StopReasonStopped, model.StopReasonFailed:
+	default:
+		return invalidf("unknown stop_reason %q", r.StopReason)
+	}
+	if r.BytesTotal < 0 || r.RequestCount < 0 || r.ErrorCount < 0 || r.DurationSec < 0 {
+		return invalidf("result counters must not be negative")
+	}
+	r.ReportedAt = time.Now()
+	if err := s.store.TaskResults().UpsertAgent(ctx, r); err != nil {
+		return err
+	}
+	sum, err := s.Summary(ctx, r.TaskID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Tasks().UpdateBytes(ctx, r.TaskID, sum.BytesTotal); err != nil {
+		return err
+	}
+	switch t.Status {
+	case model.TaskStatusDone, model.TaskStatusFailed, model.TaskStatusStopped:
+		prev, err := s.store.TaskResults().Get(ctx, r.TaskID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		return s.RecordResult(ctx, r.TaskID, prev.EndReason)
+	}
+	return nil
+}
+
+// RecordResult saves the result snapshot of a task that has just reached a
+// terminal status. The transition has already happened, so callers outside
+// this service (the scheduler) use it to keep results complete.
+func (s *TaskService) RecordResult(ctx context.Context, taskID, reason string) error {
+	task, err := s.store.Tasks().Get(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	sum, err := s.Summary(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	finished := time.Now()
+	if task.FinishedAt != nil {
+		finished = *task.FinishedAt
+	}
+	res := &model.TaskResult{
+		TaskID:       taskID,
+		Status:       task.Status,
+		EndReason:    reason,
+		BytesTotal:   sum.BytesTotal,
+		RequestCount: sum.RequestCount,
+		ErrorCount:   sum.ErrorCount,
+		AvgRateMbps:  sum.AvgRateMbps,
+		StartedAt:    task.StartedAt,
+		FinishedAt:   finished,
+	}
+	if task.StartedAt != nil && finished.After(*task.StartedAt) {
+		res.DurationSec = finished.Sub(*task.StartedAt).Seconds()
+	}
+	samples, err := s.store.TaskMetrics().ListByTask(ctx, taskID, time.Time{}, time.Now())
+	if err != nil {
+		return err
+	}
+	res.P95RateMbps = p95Rate(samples)
+	return s.store.TaskResults().Upsert(ctx, res)
+}
+
+// saveResult records a task's result after a transition without failing it;
+// metrics stay queryable if the snapshot cannot be written.
+func (s *TaskService) saveResult(ctx context.Context, taskID, reason string) {
+	if err := s.RecordResult(ctx, taskID, reason); err != nil {
+		slog.Error("record task result", "task", taskID, "err", err)
+	}
+}
+
+// Result returns the result snapshot saved when a task finished, with the
+// final reports of its agents.
+func (s *TaskService) Result(ctx context.Context, taskID string) (*model.TaskResult, error) {
+	res, err := s.store.TaskResults().Get(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if res.Agents, err = s.store.TaskResults().ListAgents(ctx, taskID); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TaskComparison sets the results of two finished tasks side by side.
+type TaskComparison struct {
+	A     *model.TaskResult `json:"a"`
+	B     *model.TaskResult `json:"b"`
+	Delta ResultDelta       `json:"delta"`
+}
+
+// ResultDelta describes how task B differs from task A. Percentages are
+// relative to A and are 0 when A's value is 0; error rates are errors per
+// request, so their difference is in percentage points.
+type ResultDelta struct {
+	BytesTotalPct    float64 `json:"bytes_total_pct"`
+	RequestCountPct  float64 `json:"request_count_pct"`
+	AvgRatePct       float64 `json:"avg_rate_pct"`
+	P95RatePct       float64 `json:"p95_rate_pct"`
+	ErrorRateAPct    float64 `json:"error_rate_a_pct"`
+	ErrorRateBPct    float64 `json:"error_rate_b_pct"`
+	ErrorRateDiffPct float64 `json:"error_rate_diff_pct"`
+	DurationDiffSec  float64 `json:"duration_diff_sec"`
+}
+
+// Compare returns the results of tasks a and b with B's change relative to A.
+// Both tasks must have finished.
+func (s *TaskService) Compare(ctx context.Context, a, b string) (*TaskComparison, error) {
+	if a == "" || b == "" {
+		return nil, invalidf("both a and b task ids are required")
+	}
+	ra, err := s.finishedResult(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := s.finishedResult(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	errA, errB := errorRate(ra), errorRate(rb)
+	return &TaskComparison{
+		A: ra,
+		B: rb,
+		Delta: ResultDelta{
+			BytesTotalPct:    pctChange(float64(ra.BytesTotal), float64(rb.BytesTotal)),
+			RequestCountPct:  pctChange(float64(ra.RequestCount), float64(rb.RequestCount)),
+			AvgRatePct:       pctChange(ra.AvgRateMbps, rb.AvgRateMbps),
+			P95RatePct:       pctChange(ra.P95RateMbps, rb.P95RateMbps),
+			ErrorRateAPct:    errA,
+			ErrorRateBPct:    errB,
+			ErrorRateDiffPct: errB - errA,
+			DurationDiffSec:  rb.DurationSec - ra.DurationSec,
+		},
+	}, nil
+}
+
+// finishedResult returns a task's result, telling a task that has not
+// finished yet apart from one that does not exist.
+func (s *TaskService) finishedResult(ctx context.Context, taskID string) (*model.TaskResult, error) {
+	res, err := s.store.TaskResults().Get(ctx, taskID)
+	if !errors.Is(err, store.ErrNotFound) {
+		return res, err
+	}
+	task, err := s.store.Tasks().Get(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return nil, conflictf("task %s has no result yet (status=%s)", taskID, task.Status)
+}
+
+func errorRate(r *model.TaskResult) float64 {
+	if r.RequestCount == 0 {
+		return 0
+	}
+	return float64(r.ErrorCount) / float64(r.RequestCount) * 100
+}
+
+func pctChange(a, b float64) float64 {
+	if a == 0 {
+		return 0
+	}
+	return (b - a) / a * 100
+}
+
+// p95Rate returns the 95th percentile of the task-wide 5s rate. Samples are
+// grouped into 5s windows, keeping each agent's last sample per window, and
+// summed across agents; warm-up samples are left out.
+func p95Rate(samples []*model.TaskMetrics) float64 {
+	windows := map[int64]map[string]float64{}
+	for _, m := range samples {
+		if m.Warmup {
+			continue
+		}
+		w := m.RecordedAt.Unix() / 5
+		if windows[w] == nil {
+			windows[w] = map[string]float64{}
+		}
+		windows[w][m.AgentID] = m.RateMbps5s
+	}
+	if len(windows) == 0 {
+		return 0
+	}
+	rates := make([]float64, 0, len(windows))
+	for _, agents := range windows {
+		var r float64
+		for _, rate := range agents {
+			r += rate
+		}
+		rates = append(rates, r)
+	}
+	sort.Float64s(rates)
+	return rates[int(math.Ceil(0.95*float64(len(rates))))-1]
+}
+
+func percentiles(h *latency.Histogram) LatencyPercentiles {
+	ms := func(q float64) float64 {
+		return float64(h.Quantile(q)) / float64(time.Millisecond)
+	}
+	return LatencyPercentiles{
+		Samples: h.Count,
+		P50Ms:   ms(0.50),
+		P90Ms:   ms(0.90),
+		P95Ms:   ms(0.95),
+		P99Ms:   ms(0.99),
+	}
+}
+
 func (s *TaskService) enrichTask(ctx context.Context, task *model.Task) (*model.Task, error) {
 	task = task.Clone()
 	var err error
@@ -293,29 +1198,34 @@ func (s *TaskService) enrichTask(ctx context.Context, task *model.Task) (*model.
 func (s *TaskService) resolveTaskSource(ctx context.Context, req *CreateTaskRequest) (*model.URLPool, []string, model.TaskType, error) {
 	if req.URLPoolID != "" {
 		pool, err := s.store.URLPools().Get(ctx, req.URLPoolID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil, "", invalidf("url pool %s not found", req.URLPoolID)
+		}
 		if err != nil {
 			return nil, nil, "", err
 		}
 		pool.Normalize()
 		if len(pool.URLs) == 0 {
-			return nil, nil, "", fmt.Errorf("url pool %s has no urls", req.URLPoolID)
+			return nil, nil, "", invalidf("url pool %s has no urls", req.URLPoolID)
 		}
 		taskType := pool.TaskType()
 		if req.Type != "" && req.Type != taskType {
-			return nil, nil, "", fmt.Errorf("task type %s does not match url pool type %s", req.Type, pool.Type)
+			return nil, nil, "", invalidf("task type %s does not match url pool type %s", req.Type, pool.Type)
 		}
 		return pool, pool.URLs, taskType, nil
 	}
 
 	urls := normalizeTaskURLs(req.TargetURLs, req.TargetURL)
 	if len(urls) == 0 {
-		return nil, nil, "", fmt.Errorf("url_pool_id is required")
+		return nil, nil, "", invalidf("url_pool_id is required")
 	}
 	if req.Type == "" {
 		req.Type = inferTaskType(urls)
 	}
-	if req.Type != model.TaskTypeYoutube && req.Type != model.TaskTypeStatic && req.Type != model.TaskTypeMixed {
-		return nil, nil, "", fmt.Errorf("invalid task type: %s", req.Type)
+	switch req.Type {
+	case model.TaskTypeYoutube, model.TaskTypeStatic, model.TaskTypeMixed, model.TaskTypeDNS:
+	default:
+		return nil, nil, "", invalidf("invalid task type: %s", req.Type)
 	}
 	if err := validateTaskURLs(req.Type, urls); err != nil {
 		return nil, nil, "", err
@@ -361,17 +1271,34 @@ func normalizeTaskURLs(urls []string, single string) []string {
 	return out
 }
 
+// sampleURLVars fill in the variables of target URLs for validation.
+var sampleURLVars = model.URLVars{AgentID: "agent", Hostname: "host", IP: "192.0.2.1", Region: "region"}
+
 func validateTaskURLs(taskType model.TaskType, urls []string) error {
+	perRequest := taskType == model.TaskTypeStatic || taskType == model.TaskTypeMixed
 	for _, raw := range urls {
+		if err := model.CheckURLVars(raw, perRequest); err != nil {
+			return invalidf("%s", err)
+		}
+		raw = model.ExpandSeq(sampleURLVars.Expand(raw), 1)
+		if taskType == model.TaskTypeDNS {
+			if _, err := model.ParseDNSQuery(raw); err != nil {
+				return invalidf("%s", err)
+			}
+			continue
+		}
+		if model.IsDNSURL(raw) {
+			return invalidf("%s task contains dns url: %s", taskType, raw)
+		}
 		u, err := url.Parse(raw)
 		if err != nil || u.Scheme == "" || u.Host == "" {
-			return fmt.Errorf("invalid url: %s", raw)
+			return invalidf("invalid url: %s", raw)
 		}
 		if taskType == model.TaskTypeYoutube && !isYoutubeURL(raw) {
-			return fmt.Errorf("youtube task contains non-youtube url: %s", raw)
+			return invalidf("youtube task contains non-youtube url: %s", raw)
 		}
 		if taskType == model.TaskTypeStatic && isYoutubeURL(raw) {
-			return fmt.Errorf("static task contains youtube url: %s", raw)
+			return invalidf("static task contains youtube url: %s", raw)
 		}
 	}
 	return nil
@@ -381,6 +1308,9 @@ func inferTaskType(urls []string) model.TaskType {
 	if len(urls) == 0 {
 		return model.TaskTypeStatic
 	}
+	if model.IsDNSURL(urls[0]) {
+		return model.TaskTypeDNS
+	}
 	firstIsYoutube := isYoutubeURL(urls[0])
 	for _, u := range urls[1:] {
 		if isYoutubeURL(u) != firstIsYoutube {
@@ -405,8 +1335,16 @@ func isYoutubeURL(raw string) bool {
 func prepareTaskForAgent(task *model.Task, agentID string, onlineAgents int) *model.Task {
 	cp := task.Clone()
 	cp.Normalize()
-	if cp.ExecutionScope == model.TaskExecutionScopeGlobal && onlineAgents > 0 && cp.TargetRateMbps > 0 {
+	// Shift the start past earlier pauses so rate curves resume where they
+	// left off.
+	if cp.StartedAt != nil && cp.PausedSec > 0 {
+		started := cp.StartedAt.Add(time.Duration(cp.PausedSec) * time.Second)
+		cp.StartedAt = &started
+	}
+	shared := cp.ExecutionScope == model.TaskExecutionScopeGlobal || cp.ExecutionScope == model.TaskExecutionScopeAgentGroup
+	if shared && onlineAgents > 0 {
 		cp.TargetRateMbps = cp.TargetRateMbps / float64(onlineAgents)
+		cp.TargetRps = cp.TargetRps / float64(onlineAgents)
 	}
 	urls := cp.URLs()
 	if len(urls) > 1 {
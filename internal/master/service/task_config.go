@@ -0,0 +1,468 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// TaskConfigService imports and exports task configuration as a declarative
+// document. Entries are matched to existing tasks and traffic profiles by
+// their external_id, so applying the same document twice changes nothing.
+type TaskConfigService struct {
+	store   store.Store
+	taskSvc *TaskService
+}
+
+// NewTaskConfigService creates a new TaskConfigService.
+func NewTaskConfigService(st store.Store, taskSvc *TaskService) *TaskConfigService {
+	return &TaskConfigService{store: st, taskSvc: taskSvc}
+}
+
+// TaskDocument is the import/export format: the traffic profiles and tasks
+// to create or update.
+type TaskDocument struct {
+	Profiles []ProfileSpec `json:"profiles,omitempty"`
+	Tasks    []TaskSpec    `json:"tasks"`
+}
+
+// ProfileSpec declares a traffic profile.
+type ProfileSpec struct {
+	ExternalID   string             `json:"external_id"`
+	Name         string             `json:"name"`
+	Description  string             `json:"description,omitempty"`
+	Distribution model.Distribution `json:"distribution,omitempty"`
+	Points       string             `json:"points,omitempty"`
+	Timezone     string             `json:"timezone,omitempty"`
+}
+
+// TaskSpec declares a task with the task creation fields. TrafficProfile
+// refers to a profile by external_id, in place of traffic_profile_id.
+type TaskSpec struct {
+	TrafficProfile string `json:"traffic_profile,omitempty"`
+	CreateTaskRequest
+}
+
+// Actions reported by Import.
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+)
+
+// ImportChange reports what an import did, or would do, with one entry.
+type ImportChange struct {
+	ExternalID string `json:"external_id"`
+	ID         string `json:"id"`
+	Action     string `json:"action"`
+}
+
+// ImportResult lists the changes for every entry of an imported document.
+type ImportResult struct {
+	DryRun   bool           `json:"dry_run"`
+	Profiles []ImportChange `json:"profiles"`
+	Tasks    []ImportChange `json:"tasks"`
+}
+
+// ParseTaskDocument reads a YAML or JSON document. Unknown fields are
+// rejected so typos do not silently fall back to defaults.
+func ParseTaskDocument(data []byte) (*TaskDocument, error) {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, invalidf("document: %s", err.Error())
+	}
+	if v == nil {
+		return nil, invalidf("document is empty")
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, invalidf("document: %s", err.Error())
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var doc TaskDocument
+	if err := dec.Decode(&doc); err != nil {
+		return nil, invalidf("document: %s", err.Error())
+	}
+	return &doc, nil
+}
+
+// MarshalTaskDocumentYAML renders doc as YAML, leaving out empty fields.
+func MarshalTaskDocumentYAML(doc *TaskDocument) ([]byte, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(pruneEmpty(v))
+}
+
+// pruneEmpty drops empty strings, zero numbers, nulls and empty lists from
+// JSON objects, as these all decode back to the field's default. Numbers
+// become int64 or float64, which YAML writes unquoted.
+func pruneEmpty(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, e := range x {
+			e = pruneEmpty(e)
+			if isEmptyValue(e) {
+				delete(x, k)
+			} else {
+				x[k] = e
+			}
+		}
+	case []any:
+		for i := range x {
+			x[i] = pruneEmpty(x[i])
+		}
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n
+		}
+		f, _ := x.Float64()
+		return f
+	}
+	return v
+}
+
+func isEmptyValue(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	case int64:
+		return x == 0
+	case float64:
+		return x == 0
+	case []any:
+		return len(x) == 0
+	}
+	return false
+}
+
+// Export returns the profiles and tasks that carry an external_id, ordered
+// by it. Profiles without one are referenced by traffic_profile_id.
+func (s *TaskConfigService) Export(ctx context.Context) (*TaskDocument, error) {
+	profiles, err := s.store.TrafficProfiles().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := s.store.Tasks().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc := &TaskDocument{Tasks: []TaskSpec{}}
+	profileRefs := make(map[string]string)
+	for _, p := range profiles {
+		if p.ExternalID == "" {
+			continue
+		}
+		profileRefs[p.ID] = p.ExternalID
+		doc.Profiles = append(doc.Profiles, profileSpec(p))
+	}
+	for _, t := range tasks {
+		if t.ExternalID == "" {
+			continue
+		}
+		spec := TaskSpec{CreateTaskRequest: taskConfig(t)}
+		if ref, ok := profileRefs[spec.TrafficProfileID]; ok {
+			spec.TrafficProfile, spec.TrafficProfileID = ref, ""
+		}
+		doc.Tasks = append(doc.Tasks, spec)
+	}
+	sort.Slice(doc.Profiles, func(i, j int) bool { return doc.Profiles[i].ExternalID < doc.Profiles[j].ExternalID })
+	sort.Slice(doc.Tasks, func(i, j int) bool { return doc.Tasks[i].ExternalID < doc.Tasks[j].ExternalID })
+	return doc, nil
+}
+
+// Import creates or updates the document's profiles and tasks. The whole
+// document is validated before anything is written. Existing tasks whose
+// configuration differs are updated only while still pending. With dryRun
+// the changes are reported but not applied.
+func (s *TaskConfigService) Import(ctx context.Context, doc *TaskDocument, dryRun bool) (*ImportResult, error) {
+	if err := checkExternalIDs(doc); err != nil {
+		return nil, err
+	}
+	profiles, err := s.planProfiles(ctx, doc.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := s.planTasks(ctx, doc.Tasks, profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ImportResult{DryRun: dryRun, Profiles: []ImportChange{}, Tasks: []ImportChange{}}
+	for _, p := range profiles {
+		res.Profiles = append(res.Profiles, ImportChange{ExternalID: p.ExternalID, ID: p.ID, Action: p.action})
+	}
+	for _, t := range tasks {
+		res.Tasks = append(res.Tasks, ImportChange{ExternalID: t.ExternalID, ID: t.ID, Action: t.action})
+	}
+	if dryRun {
+		return res, nil
+	}
+
+	for _, p := range profiles {
+		switch p.action {
+		case ImportCreated:
+			err = s.store.TrafficProfiles().Create(ctx, p.TrafficProfile)
+		case ImportUpdated:
+			err = s.store.TrafficProfiles().Update(ctx, p.TrafficProfile)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range tasks {
+		switch t.action {
+		case ImportCreated:
+			err = s.store.Tasks().Create(ctx, t.Task)
+		case ImportUpdated:
+			err = s.store.Tasks().Update(ctx, t.Task)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+type plannedProfile struct {
+	*model.TrafficProfile
+	action string
+}
+
+type plannedTask struct {
+	*model.Task
+	action string
+}
+
+func checkExternalIDs(doc *TaskDocument) error {
+	seen := make(map[string]bool)
+	for _, p := range doc.Profiles {
+		if strings.TrimSpace(p.ExternalID) == "" {
+			return invalidf("profile %q: external_id is required", p.Name)
+		}
+		if seen[p.ExternalID] {
+			return invalidf("duplicate profile external_id %q", p.ExternalID)
+		}
+		seen[p.ExternalID] = true
+	}
+	seen = make(map[string]bool)
+	for _, t := range doc.Tasks {
+		if strings.TrimSpace(t.ExternalID) == "" {
+			return invalidf("task %q: external_id is required", t.Name)
+		}
+		if seen[t.ExternalID] {
+			return invalidf("duplicate task external_id %q", t.ExternalID)
+		}
+		seen[t.ExternalID] = true
+	}
+	return nil
+}
+
+// planProfiles builds the profiles declared by specs and decides, for each,
+// whether it is new, changed or unchanged.
+func (s *TaskConfigService) planProfiles(ctx context.Context, specs []ProfileSpec) ([]plannedProfile, error) {
+	existing, err := s.store.TrafficProfiles().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byRef := make(map[string]*model.TrafficProfile)
+	for _, p := range existing {
+		if p.ExternalID != "" {
+			byRef[p.ExternalID] = p
+		}
+	}
+	var planned []plannedProfile
+	for _, spec := range specs {
+		if spec.Timezone != "" {
+			if _, err := time.LoadLocation(spec.Timezone); err != nil {
+				return nil, invalidf("profile %s: invalid timezone: %s", spec.ExternalID, spec.Timezone)
+			}
+		}
+		p := &model.TrafficProfile{
+			ID:           generateID(),
+			Name:         spec.Name,
+			ExternalID:   spec.ExternalID,
+			Description:  spec.Description,
+			Distribution: spec.Distribution,
+			Points:       spec.Points,
+			Timezone:     spec.Timezone,
+			CreatedAt:    time.Now(),
+		}
+		if p.Distribution == "" {
+			p.Distribution = model.DistributionFlat
+		}
+		if p.Points == "" {
+			p.Points = "[]"
+		}
+		action := ImportCreated
+		if cur, ok := byRef[spec.ExternalID]; ok {
+			p.ID, p.CreatedAt = cur.ID, cur.CreatedAt
+			action = ImportUpdated
+			if profileSpec(p) == profileSpec(cur) {
+				action = ImportUnchanged
+			}
+		}
+		planned = append(planned, plannedProfile{TrafficProfile: p, action: action})
+	}
+	return planned, nil
+}
+
+// planTasks builds the tasks declared by specs, resolving profile references
+// against the planned profiles first and the stored ones second.
+func (s *TaskConfigService) planTasks(ctx context.Context, specs []TaskSpec, profiles []plannedProfile) ([]plannedTask, error) {
+	storedProfiles, err := s.store.TrafficProfiles().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profileByRef := make(map[string]*model.TrafficProfile)
+	for _, p := range storedProfiles {
+		if p.ExternalID != "" {
+			profileByRef[p.ExternalID] = p
+		}
+	}
+	for _, p := range profiles {
+		profileByRef[p.ExternalID] = p.TrafficProfile
+	}
+	existing, err := s.store.Tasks().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taskByRef := make(map[string]*model.Task)
+	for _, t := range existing {
+		if t.ExternalID != "" {
+			taskByRef[t.ExternalID] = t
+		}
+	}
+
+	var planned []plannedTask
+	for _, spec := range specs {
+		req := spec.CreateTaskRequest
+		if spec.TrafficProfile != "" {
+			if req.TrafficProfileID != "" {
+				return nil, invalidf("task %s: set traffic_profile or traffic_profile_id, not both", spec.ExternalID)
+			}
+			p, ok := profileByRef[spec.TrafficProfile]
+			if !ok {
+				return nil, invalidf("task %s: traffic profile %q not found", spec.ExternalID, spec.TrafficProfile)
+			}
+			req.TrafficProfileID = p.ID
+			// The profile may not be stored yet, so inherit its zone here
+			// rather than in newTask.
+			if req.Timezone == "" {
+				req.Timezone = p.Timezone
+			}
+		}
+		t, err := s.taskSvc.newTask(ctx, &req)
+		if err != nil {
+			if errors.Is(err, ErrInvalidInput) {
+				return nil, invalidf("task %s: %s", spec.ExternalID, err.Error())
+			}
+			return nil, err
+		}
+		action := ImportCreated
+		if cur, ok := taskByRef[spec.ExternalID]; ok {
+			action = ImportUnchanged
+			if !sameTaskConfig(t, cur) {
+				if cur.Status != model.TaskStatusPending {
+					return nil, conflictf("task %s is %s; only pending tasks can be updated", spec.ExternalID, cur.Status)
+				}
+				action = ImportUpdated
+			}
+			t.ID, t.GroupID, t.Status, t.CreatedAt = cur.ID, cur.GroupID, cur.Status, cur.CreatedAt
+		}
+		planned = append(planned, plannedTask{Task: t, action: action})
+	}
+	return planned, nil
+}
+
+// taskConfig returns the creation fields that describe t. URLs are left out
+// for pool-backed tasks, whose URLs come from the pool.
+func taskConfig(t *model.Task) CreateTaskRequest {
+	req := CreateTaskRequest{
+		Name:                t.Name,
+		ExternalID:          t.ExternalID,
+		URLPoolID:           t.URLPoolID,
+		AgentID:             t.AgentID,
+		AgentGroupID:        t.AgentGroupID,
+		ExecutionScope:      t.ExecutionScope,
+		TargetRateMbps:      t.TargetRateMbps,
+		TargetRps:           t.TargetRps,
+		StartAt:             utcTime(t.StartAt),
+		EndAt:               utcTime(t.EndAt),
+		DurationSec:         t.DurationSec,
+		TotalBytesTarget:    t.TotalBytesTarget,
+		TotalRequestsTarget: t.TotalRequestsTarget,
+		DispatchRateTpm:     t.DispatchRateTpm,
+		DispatchBatchSize:   t.DispatchBatchSize,
+		Distribution:        t.Distribution,
+		JitterPct:           t.JitterPct,
+		RampUpSec:           t.RampUpSec,
+		RampDownSec:         t.RampDownSec,
+		TrafficProfileID:    t.TrafficProfileID,
+		Timezone:            t.Timezone,
+		ConcurrentFragments: t.ConcurrentFragments,
+		RangeChunkBytes:     t.RangeChunkBytes,
+		ReadChunkBytes:      t.ReadChunkBytes,
+		BurstBytes:          t.BurstBytes,
+		CacheBustParam:      t.CacheBustParam,
+		Redirects:           t.Redirects,
+		MaxRedirects:        t.MaxRedirects,
+		ExpectedBytes:       t.ExpectedBytes,
+		ExpectedSHA256:      t.ExpectedSHA256,
+		WarmupSec:           t.WarmupSec,
+		Retries:             t.Retries,
+	}
+	if t.URLPoolID == "" {
+		req.Type = t.Type
+		req.TargetURLs = t.URLs()
+	}
+	if !t.ReuseConnections {
+		reuse := false
+		req.ReuseConnections = &reuse
+	}
+	return req
+}
+
+func sameTaskConfig(a, b *model.Task) bool {
+	ja, errA := json.Marshal(taskConfig(a))
+	jb, errB := json.Marshal(taskConfig(b))
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+func profileSpec(p *model.TrafficProfile) ProfileSpec {
+	return ProfileSpec{
+		ExternalID:   p.ExternalID,
+		Name:         p.Name,
+		Description:  p.Description,
+		Distribution: p.Distribution,
+		Points:       p.Points,
+		Timezone:     p.Timezone,
+	}
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
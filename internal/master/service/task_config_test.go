@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+const taskConfigYAML = `
+profiles:
+  - external_id: evening
+    name: Evening
+    distribution: diurnal
+    timezone: Asia/Tokyo
+tasks:
+  - external_id: eu-static
+    name: EU static
+    target_url: https://example.com/a.bin
+    execution_scope: global
+    target_rate_mbps: 100
+    traffic_profile: evening
+  - external_id: eu-rps
+    target_urls: [https://example.com/b.bin]
+    execution_scope: global
+    target_rps: 20
+`
+
+func TestTaskConfigImportIsIdempotent(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	taskSvc := NewTaskService(st)
+	svc := NewTaskConfigService(st, taskSvc)
+
+	importDoc := func(src string, dryRun bool) (*ImportResult, error) {
+		doc, err := ParseTaskDocument([]byte(src))
+		if err != nil {
+			return nil, err
+		}
+		return svc.Import(ctx, doc, dryRun)
+	}
+	actions := func(changes []ImportChange) map[string]string {
+		out := make(map[string]string)
+		for _, c := range changes {
+			out[c.ExternalID] = c.Action
+		}
+		return out
+	}
+
+	res, err := importDoc(taskConfigYAML, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(res.Tasks); got["eu-static"] != ImportCreated || got["eu-rps"] != ImportCreated {
+		t.Fatalf("dry run actions = %v, want created", got)
+	}
+	if tasks, _ := st.Tasks().List(ctx); len(tasks) != 0 {
+		t.Fatalf("dry run stored %d tasks", len(tasks))
+	}
+
+	res, err = importDoc(taskConfigYAML, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(res.Profiles); got["evening"] != ImportCreated {
+		t.Fatalf("profile actions = %v, want created", got)
+	}
+	task, err := st.Tasks().Get(ctx, res.Tasks[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.TrafficProfileID != res.Profiles[0].ID || task.Timezone != "Asia/Tokyo" {
+		t.Fatalf("task profile = %q tz %q, want %q and the profile's zone", task.TrafficProfileID, task.Timezone, res.Profiles[0].ID)
+	}
+
+	res, err = importDoc(taskConfigYAML, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ref, action := range actions(append(res.Profiles, res.Tasks...)) {
+		if action != ImportUnchanged {
+			t.Errorf("reimport: %s %s, want unchanged", ref, action)
+		}
+	}
+
+	// The exported document round-trips without changes.
+	doc, err := svc.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Profiles) != 1 || len(doc.Tasks) != 2 || doc.Tasks[1].TrafficProfile != "evening" {
+		t.Fatalf("export = %+v", doc)
+	}
+	out, err := MarshalTaskDocumentYAML(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = importDoc(string(out), false)
+	if err != nil {
+		t.Fatalf("reimport export: %v\n%s", err, out)
+	}
+	for ref, action := range actions(append(res.Profiles, res.Tasks...)) {
+		if action != ImportUnchanged {
+			t.Errorf("export round trip: %s %s, want unchanged\n%s", ref, action, out)
+		}
+	}
+
+	changed := `
+tasks:
+  - external_id: eu-rps
+    target_urls: [https://example.com/b.bin]
+    execution_scope: global
+    target_rps: 50
+`
+	res, err = importDoc(changed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Tasks[0].Action != ImportUpdated {
+		t.Fatalf("action = %s, want updated", res.Tasks[0].Action)
+	}
+	task, err = st.Tasks().Get(ctx, res.Tasks[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.TargetRps != 50 || task.Status != model.TaskStatusPending {
+		t.Fatalf("updated task rps = %v status %s", task.TargetRps, task.Status)
+	}
+
+	if err := taskSvc.Dispatch(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importDoc(changed+"    duration_sec: 60\n", false); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("changing a dispatched task: err = %v, want ErrConflict", err)
+	}
+	if _, err := importDoc("tasks:\n  - external_id: x\n    rate: 1\n", false); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("unknown field: err = %v, want ErrInvalidInput", err)
+	}
+	if _, err := importDoc("tasks:\n  - external_id: x\n    target_url: https://example.com/c.bin\n    target_rate_mbps: 1\n    traffic_profile: nope\n", false); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("unknown profile: err = %v, want ErrInvalidInput", err)
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"time"
@@ -24,6 +25,7 @@ type CreateTaskGroupRequest struct {
 	Description         string                   `json:"description"`
 	PoolIDs             []string                 `json:"pool_ids"`
 	AgentID             string                   `json:"agent_id"`
+	AgentGroupID        string                   `json:"agent_group_id,omitempty"`
 	ExecutionScope      model.TaskExecutionScope `json:"execution_scope"`
 	TargetRateMbps      float64                  `json:"target_rate_mbps"`
 	StartAt             *time.Time               `json:"start_at,omitempty"`
@@ -38,19 +40,34 @@ type CreateTaskGroupRequest struct {
 	RampUpSec           int                      `json:"ramp_up_sec"`
 	RampDownSec         int                      `json:"ramp_down_sec"`
 	TrafficProfileID    string                   `json:"traffic_profile_id"`
+	Timezone            string                   `json:"timezone,omitempty"`
 	ConcurrentFragments int                      `json:"concurrent_fragments"`
+	ReuseConnections    *bool                    `json:"reuse_connections,omitempty"`
+	RangeChunkBytes     int64                    `json:"range_chunk_bytes,omitempty"`
+	ReadChunkBytes      int64                    `json:"read_chunk_bytes,omitempty"`
+	BurstBytes          int64                    `json:"burst_bytes,omitempty"`
+	CacheBustParam      string                   `json:"cache_bust_param,omitempty"`
+	Redirects           model.RedirectPolicy     `json:"redirects,omitempty"`
+	MaxRedirects        int                      `json:"max_redirects,omitempty"`
+	ExpectedBytes       int64                    `json:"expected_bytes,omitempty"`
+	ExpectedSHA256      string                   `json:"expected_sha256,omitempty"`
+	WarmupSec           int                      `json:"warmup_sec,omitempty"`
+	StaggerSec          int                      `json:"stagger_sec,omitempty"`
 	Retries             int                      `json:"retries"`
 }
 
 func (s *TaskGroupService) Create(ctx context.Context, req *CreateTaskGroupRequest) (*model.TaskGroup, error) {
 	poolIDs := uniqueStrings(req.PoolIDs)
 	if len(poolIDs) == 0 {
-		return nil, fmt.Errorf("pool_ids is required")
+		return nil, invalidf("pool_ids is required")
 	}
 
 	pools := make([]*model.URLPool, 0, len(poolIDs))
 	for _, id := range poolIDs {
 		pool, err := s.store.URLPools().Get(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, invalidf("url pool %s not found", id)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -58,17 +75,61 @@ func (s *TaskGroupService) Create(ctx context.Context, req *CreateTaskGroupReque
 	}
 
 	scope := req.ExecutionScope
+	if scope == "" && req.AgentGroupID != "" {
+		scope = model.TaskExecutionScopeAgentGroup
+	}
 	if scope == "" {
 		scope = model.TaskExecutionScopeGlobal
 	}
-	if scope != model.TaskExecutionScopeSingleAgent && scope != model.TaskExecutionScopeGlobal {
-		return nil, fmt.Errorf("invalid execution_scope: %s", scope)
+	if err := s.taskSvc.checkScope(ctx, scope, &req.AgentID, &req.AgentGroupID, "task groups"); err != nil {
+		return nil, err
+	}
+	if err := s.taskSvc.applyRatePolicy(ctx, "task group", scope, req.AgentID, &req.TargetRateMbps); err != nil {
+		return nil, err
+	}
+	if err := s.taskSvc.taskLimits.check(req.StartAt, req.EndAt, req.DurationSec, req.RampUpSec, req.RampDownSec,
+		req.TotalBytesTarget, req.TotalRequestsTarget); err != nil {
+		return nil, err
+	}
+
+	if req.RangeChunkBytes < 0 {
+		return nil, invalidf("range_chunk_bytes must not be negative")
+	}
+	if err := validateReadChunk(req.ReadChunkBytes); err != nil {
+		return nil, err
+	}
+	if err := validateBurst(req.BurstBytes, req.ReadChunkBytes); err != nil {
+		return nil, err
+	}
+	if err := validateCacheBust(req.CacheBustParam); err != nil {
+		return nil, err
+	}
+	if err := validateRedirects(req.Redirects, req.MaxRedirects); err != nil {
+		return nil, err
+	}
+	for _, pool := range pools {
+		if err := validateContentCheck(pool.TaskType(), req.ExpectedBytes, &req.ExpectedSHA256); err != nil {
+			return nil, err
+		}
+	}
+	if req.WarmupSec < 0 {
+		return nil, invalidf("warmup_sec must not be negative")
 	}
-	if scope == model.TaskExecutionScopeGlobal {
-		req.AgentID = ""
+	if req.StaggerSec < 0 {
+		return nil, invalidf("stagger_sec must not be negative")
+	}
+	if req.StaggerSec > 0 && req.EndAt != nil {
+		start := time.Now()
+		if req.StartAt != nil {
+			start = *req.StartAt
+		}
+		if last := staggeredStart(start, req.StaggerSec, len(pools)-1); !last.Before(*req.EndAt) {
+			return nil, invalidf("stagger_sec starts the last child task at %s, after end_at", last.Format(time.RFC3339))
+		}
 	}
-	if scope == model.TaskExecutionScopeSingleAgent && req.AgentID == "" {
-		return nil, fmt.Errorf("agent_id is required for single_agent task groups")
+	tz, err := s.taskSvc.resolveTimezone(ctx, req.Timezone, req.TrafficProfileID)
+	if err != nil {
+		return nil, err
 	}
 
 	dist := req.Distribution
@@ -81,6 +142,7 @@ func (s *TaskGroupService) Create(ctx context.Context, req *CreateTaskGroupReque
 		Name:                req.Name,
 		Description:         req.Description,
 		AgentID:             req.AgentID,
+		AgentGroupID:        req.AgentGroupID,
 		ExecutionScope:      scope,
 		TargetRateMbps:      req.TargetRateMbps,
 		StartAt:             req.StartAt,
@@ -95,7 +157,19 @@ func (s *TaskGroupService) Create(ctx context.Context, req *CreateTaskGroupReque
 		RampUpSec:           req.RampUpSec,
 		RampDownSec:         req.RampDownSec,
 		TrafficProfileID:    req.TrafficProfileID,
+		Timezone:            tz,
 		ConcurrentFragments: req.ConcurrentFragments,
+		ReuseConnections:    reuseConnections(req.ReuseConnections),
+		RangeChunkBytes:     req.RangeChunkBytes,
+		ReadChunkBytes:      req.ReadChunkBytes,
+		BurstBytes:          req.BurstBytes,
+		CacheBustParam:      req.CacheBustParam,
+		Redirects:           req.Redirects,
+		MaxRedirects:        req.MaxRedirects,
+		ExpectedBytes:       req.ExpectedBytes,
+		ExpectedSHA256:      req.ExpectedSHA256,
+		WarmupSec:           req.WarmupSec,
+		StaggerSec:          req.StaggerSec,
 		Retries:             req.Retries,
 		CreatedAt:           now,
 		UpdatedAt:           now,
@@ -121,6 +195,15 @@ func (s *TaskGroupService) Create(ctx context.Context, req *CreateTaskGroupReque
 
 	children := make([]*model.Task, 0, len(pools))
 	for i, pool := range pools {
+		startAt := group.StartAt
+		if group.StaggerSec > 0 {
+			base := now
+			if group.StartAt != nil {
+				base = *group.StartAt
+			}
+			at := staggeredStart(base, group.StaggerSec, i)
+			startAt = &at
+		}
 		child := &model.Task{
 			ID:                  generateID(),
 			GroupID:             group.ID,
@@ -129,10 +212,11 @@ func (s *TaskGroupService) Create(ctx context.Context, req *CreateTaskGroupReque
 			URLPoolID:           pool.ID,
 			URLPool:             pool.Clone(),
 			AgentID:             group.AgentID,
+			AgentGroupID:        group.AgentGroupID,
 			ExecutionScope:      group.ExecutionScope,
 			Status:              model.TaskStatusPending,
 			TargetRateMbps:      perTaskRate[i],
-			StartAt:             group.StartAt,
+			StartAt:             startAt,
 			EndAt:               group.EndAt,
 			DurationSec:         group.DurationSec,
 			TotalBytesTarget:    perTaskBytes[i],
@@ -144,7 +228,18 @@ func (s *TaskGroupService) Create(ctx context.Context, req *CreateTaskGroupReque
 			RampUpSec:           group.RampUpSec,
 			RampDownSec:         group.RampDownSec,
 			TrafficProfileID:    group.TrafficProfileID,
+			Timezone:            group.Timezone,
 			ConcurrentFragments: group.ConcurrentFragments,
+			ReuseConnections:    group.ReuseConnections,
+			RangeChunkBytes:     group.RangeChunkBytes,
+			ReadChunkBytes:      group.ReadChunkBytes,
+			BurstBytes:          group.BurstBytes,
+			CacheBustParam:      group.CacheBustParam,
+			Redirects:           group.Redirects,
+			MaxRedirects:        group.MaxRedirects,
+			ExpectedBytes:       group.ExpectedBytes,
+			ExpectedSHA256:      group.ExpectedSHA256,
+			WarmupSec:           group.WarmupSec,
 			Retries:             group.Retries,
 			CreatedAt:           now,
 			UpdatedAt:           now,
@@ -236,10 +331,13 @@ func (s *TaskGroupService) Dispatch(ctx context.Context, id string) error {
 		return err
 	}
 	if len(children) == 0 {
-		return fmt.Errorf("task group %s has no child tasks", id)
+		return conflictf("task group %s has no child tasks", id)
 	}
+	now := time.Now()
 	for _, child := range children {
-		if child.Status == model.TaskStatusPending {
+		// Staggered children still waiting for their start time are left to
+		// the scheduler, which starts each one on time.
+		if child.Status == model.TaskStatusPending && (child.StartAt == nil || !now.Before(*child.StartAt)) {
 			if err := s.taskSvc.Dispatch(ctx, child.ID); err != nil {
 				return err
 			}
@@ -254,7 +352,7 @@ func (s *TaskGroupService) Stop(ctx context.Context, id string) error {
 		return err
 	}
 	if len(children) == 0 {
-		return fmt.Errorf("task group %s has no child tasks", id)
+		return conflictf("task group %s has no child tasks", id)
 	}
 	for _, child := range children {
 		if child.Status != model.TaskStatusDone && child.Status != model.TaskStatusFailed && child.Status != model.TaskStatusStopped {
@@ -388,6 +486,8 @@ func aggregateGroupStatus(children []*model.Task) model.TaskStatus {
 		return model.TaskStatusRunning
 	case counts[model.TaskStatusDispatched] > 0:
 		return model.TaskStatusDispatched
+	case counts[model.TaskStatusPaused] > 0:
+		return model.TaskStatusPaused
 	case counts[model.TaskStatusPending] == len(children):
 		return model.TaskStatusPending
 	case counts[model.TaskStatusFailed] > 0:
@@ -422,6 +522,12 @@ func aggregateGroupType(pools []*model.URLPool, children []*model.Task) model.Ta
 	return model.TaskTypeMixed
 }
 
+// staggeredStart returns the start time of the i-th child of a group whose
+// children start staggerSec seconds apart from base.
+func staggeredStart(base time.Time, staggerSec, i int) time.Time {
+	return base.Add(time.Duration(i*staggerSec) * time.Second)
+}
+
 func uniqueStrings(items []string) []string {
 	out := make([]string, 0, len(items))
 	seen := make(map[string]struct{}, len(items))
@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"testing"
 	"time"
 
@@ -125,3 +128,71 @@ func TestTaskGroupListReturnsSummaries(t *testing.T) {
 		t.Fatalf("expected list payload to omit children, got %d", len(got.Children))
 	}
 }
+
+func TestTaskGroupStaggersChildStarts(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+
+	var poolIDs []string
+	for i := 0; i < 3; i++ {
+		pool := &model.URLPool{ID: fmt.Sprintf("pool-%d", i), Name: fmt.Sprintf("Files %d", i), Type: model.URLPoolTypeStatic, CreatedAt: now, UpdatedAt: now}
+		pool.SetURLs([]string{fmt.Sprintf("https://example.com/%d.bin", i)})
+		if err := st.URLPools().Create(ctx, pool); err != nil {
+			t.Fatal(err)
+		}
+		poolIDs = append(poolIDs, pool.ID)
+	}
+	svc := NewTaskGroupService(st, NewTaskService(st))
+
+	start := now.Add(time.Hour).UTC().Truncate(time.Second)
+	group, err := svc.Create(ctx, &CreateTaskGroupRequest{PoolIDs: poolIDs, TargetRateMbps: 30, StartAt: &start, StaggerSec: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Children) != 3 {
+		t.Fatalf("children = %d, want 3", len(group.Children))
+	}
+	for i, child := range group.Children {
+		want := start.Add(time.Duration(i*10) * time.Second)
+		if child.StartAt == nil || !child.StartAt.Equal(want) {
+			t.Errorf("child %d starts at %v, want %v", i, child.StartAt, want)
+		}
+	}
+
+	// Without start_at the first child starts now and the rest follow; a
+	// dispatch only releases the children whose time has come.
+	group, err = svc.Create(ctx, &CreateTaskGroupRequest{Name: "now", PoolIDs: poolIDs, TargetRateMbps: 30, StaggerSec: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Dispatch(ctx, group.ID); err != nil {
+		t.Fatal(err)
+	}
+	children, err := st.Tasks().ListByGroup(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].StartAt.Before(*children[j].StartAt) })
+	for i, child := range children {
+		want := model.TaskStatusPending
+		if i == 0 {
+			want = model.TaskStatusDispatched
+		}
+		if child.Status != want {
+			t.Errorf("child %d status = %s, want %s", i, child.Status, want)
+		}
+		if i > 0 && child.StartAt.Sub(*children[i-1].StartAt) != 10*time.Second {
+			t.Errorf("child %d starts %v after child %d, want 10s", i, child.StartAt.Sub(*children[i-1].StartAt), i-1)
+		}
+	}
+
+	end := start.Add(15 * time.Second)
+	if _, err := svc.Create(ctx, &CreateTaskGroupRequest{PoolIDs: poolIDs, TargetRateMbps: 30, StartAt: &start, EndAt: &end, StaggerSec: 10}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("stagger past end_at: err = %v, want ErrInvalidInput", err)
+	}
+}
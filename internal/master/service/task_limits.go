@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// TaskLimits caps the byte and request targets of new tasks and task
+// groups. Zero fields mean no cap.
+type TaskLimits struct {
+	MaxTotalBytes    int64
+	MaxTotalRequests int64
+}
+
+// SetTaskLimits sets the caps Create applies to total_bytes_target and
+// total_requests_target, for tasks and, through TaskGroupService, task
+// groups.
+func (s *TaskService) SetTaskLimits(l TaskLimits) error {
+	if l.MaxTotalBytes < 0 || l.MaxTotalRequests < 0 {
+		return fmt.Errorf("task limits must not be negative")
+	}
+	s.taskLimits = l
+	return nil
+}
+
+// check validates the schedule and targets shared by tasks and task groups:
+// none may be negative, end_at must follow start_at, the ramps must fit in
+// the run when its length is known, and the targets must be within l.
+func (l TaskLimits) check(startAt, endAt *time.Time, durationSec, rampUpSec, rampDownSec int, totalBytes, totalRequests int64) error {
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"duration_sec", int64(durationSec)},
+		{"ramp_up_sec", int64(rampUpSec)},
+		{"ramp_down_sec", int64(rampDownSec)},
+		{"total_bytes_target", totalBytes},
+		{"total_requests_target", totalRequests},
+	} {
+		if f.value < 0 {
+			return invalidf("%s must not be negative", f.name)
+		}
+	}
+	if startAt != nil && endAt != nil && !endAt.After(*startAt) {
+		return invalidf("end_at must be after start_at")
+	}
+	// The run lasts duration_sec, or from start_at to end_at without one.
+	length := time.Duration(durationSec) * time.Second
+	if length == 0 && startAt != nil && endAt != nil {
+		length = endAt.Sub(*startAt)
+	}
+	if ramps := time.Duration(rampUpSec+rampDownSec) * time.Second; length > 0 && ramps > length {
+		return invalidf("ramp_up_sec and ramp_down_sec add up to %s, longer than the task's %s", ramps, length)
+	}
+	if l.MaxTotalBytes > 0 && totalBytes > l.MaxTotalBytes {
+		return invalidf("total_bytes_target %d exceeds the maximum of %d", totalBytes, l.MaxTotalBytes)
+	}
+	if l.MaxTotalRequests > 0 && totalRequests > l.MaxTotalRequests {
+		return invalidf("total_requests_target %d exceeds the maximum of %d", totalRequests, l.MaxTotalRequests)
+	}
+	return nil
+}
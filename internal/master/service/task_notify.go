@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// taskNotifier wakes agents that are long-polling for their task list when
+// that list may have changed.
+//
+// Every agent has a version that grows with each change concerning it, so a
+// pull can tell whether anything happened since the agent's previous one.
+// Versions carry a per-process epoch so that they never match across master
+// restarts.
+type taskNotifier struct {
+	epoch string
+
+	mu      sync.Mutex
+	all     uint64                   // changes concerning every agent
+	agents  map[string]uint64        // changes concerning one agent
+	waiters map[string]chan struct{} // closed at the agent's next change
+
+	done      chan struct{} // closed when the master shuts down
+	closeOnce sync.Once
+}
+
+func newTaskNotifier() *taskNotifier {
+	return &taskNotifier{
+		epoch:   generateID(),
+		agents:  make(map[string]uint64),
+		waiters: make(map[string]chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// watch returns the agent's current version and a channel that is closed
+// when the version next changes.
+func (n *taskNotifier) watch(agentID string) (string, <-chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ch, ok := n.waiters[agentID]
+	if !ok {
+		ch = make(chan struct{})
+		n.waiters[agentID] = ch
+	}
+	return n.epoch + "-" + strconv.FormatUint(n.all+n.agents[agentID], 10), ch
+}
+
+// notify bumps the version of agentID, or of every agent when agentID is
+// empty, and wakes their waiters.
+func (n *taskNotifier) notify(agentID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if agentID == "" {
+		n.all++
+		for id, ch := range n.waiters {
+			close(ch)
+			delete(n.waiters, id)
+		}
+		return
+	}
+	n.agents[agentID]++
+	if ch, ok := n.waiters[agentID]; ok {
+		close(ch)
+		delete(n.waiters, agentID)
+	}
+}
+
+// TasksVersion returns the version of an agent's task list. It changes
+// whenever a task the agent pulls, or could pull, changes status.
+func (s *TaskService) TasksVersion(agentID string) string {
+	version, _ := s.notifier.watch(agentID)
+	return version
+}
+
+// MaxTasksWait caps how long WaitForTasks waits, keeping long polls inside
+// the server's and agents' 30s request timeouts.
+const MaxTasksWait = 25 * time.Second
+
+// TaskStreamRefresh is the longest WatchTasks goes without sending the task
+// list. Besides keeping streams alive, it bounds how stale per-agent shares
+// of shared tasks get when agents come and go.
+const TaskStreamRefresh = 15 * time.Second
+
+// WaitForTasks blocks until the agent's task list version differs from
+// since, wait (at most MaxTasksWait) elapses or ctx is done. It returns at
+// once when the version has already moved on. It reports false when ctx is
+// done or the service is shutting down, so that callers holding a stream
+// open can let go of it.
+func (s *TaskService) WaitForTasks(ctx context.Context, agentID, since string, wait time.Duration) bool {
+	select {
+	case <-s.notifier.done:
+		return false
+	default:
+	}
+	version, changed := s.notifier.watch(agentID)
+	if version != since {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(min(wait, MaxTasksWait))
+	defer timer.Stop()
+	select {
+	case <-changed:
+	case <-timer.C:
+	case <-ctx.Done():
+		return false
+	case <-s.notifier.done:
+		return false
+	}
+	return true
+}
+
+// WatchTasks calls send with the agent's task list and its version: once
+// straight away, then after every change and at least every
+// TaskStreamRefresh. It returns when ctx is done, the service shuts down, or
+// pulling or sending the list fails, with that error.
+func (s *TaskService) WatchTasks(ctx context.Context, agentID string, send func(version string, tasks []*model.Task) error) error {
+	for {
+		// Read the version before the list so that a change in between
+		// triggers another send.
+		version := s.TasksVersion(agentID)
+		tasks, err := s.PullTasks(ctx, agentID)
+		if err != nil {
+			return err
+		}
+		if err := send(version, tasks); err != nil {
+			return err
+		}
+		if !s.WaitForTasks(ctx, agentID, version, TaskStreamRefresh) {
+			return ctx.Err()
+		}
+	}
+}
+
+// NotifyTask wakes the agents that run, or are about to run, t: its agent
+// for a single-agent task and every agent otherwise.
+func (s *TaskService) NotifyTask(t *model.Task) {
+	if t.ExecutionScope == model.TaskExecutionScopeSingleAgent || t.ExecutionScope == "" {
+		s.notifier.notify(t.AgentID)
+		return
+	}
+	s.notifier.notify("")
+}
+
+// NotifyAgent wakes agentID, whose task list changed without any task
+// changing status, for example because it joined an agent group.
+func (s *TaskService) NotifyAgent(agentID string) {
+	s.notifier.notify(agentID)
+}
+
+// NotifyAll wakes every agent, for a change to the task lists of all of
+// them, such as an agent leaving or rejoining the shares of shared tasks.
+func (s *TaskService) NotifyAll() {
+	s.notifier.notify("")
+}
+
+// Shutdown releases every waiting pull and task stream, and makes later
+// waits return at once. It is called when the master shuts down.
+func (s *TaskService) Shutdown() {
+	s.notifier.closeOnce.Do(func() { close(s.notifier.done) })
+}
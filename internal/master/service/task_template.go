@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// TaskTemplateService manages task templates and creates tasks from them.
+type TaskTemplateService struct {
+	store   store.Store
+	taskSvc *TaskService
+}
+
+// NewTaskTemplateService creates a new TaskTemplateService.
+func NewTaskTemplateService(st store.Store, taskSvc *TaskService) *TaskTemplateService {
+	return &TaskTemplateService{store: st, taskSvc: taskSvc}
+}
+
+// TaskTemplateRequest is the body for creating or updating a task template.
+// Spec holds any subset of the CreateTaskRequest fields, but must on its
+// own describe a valid task.
+type TaskTemplateRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Spec        json.RawMessage `json:"spec"`
+}
+
+// Create saves a new template after checking that its spec yields a valid task.
+func (s *TaskTemplateService) Create(ctx context.Context, req *TaskTemplateRequest) (*model.TaskTemplate, error) {
+	name, spec, err := s.check(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	t := &model.TaskTemplate{
+		ID:          generateID(),
+		Name:        name,
+		Description: req.Description,
+		Spec:        spec,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.store.TaskTemplates().Create(ctx, t); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return nil, conflictf("task template %q already exists", name)
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *TaskTemplateService) Get(ctx context.Context, id string) (*model.TaskTemplate, error) {
+	return s.store.TaskTemplates().Get(ctx, id)
+}
+
+func (s *TaskTemplateService) List(ctx context.Context) ([]*model.TaskTemplate, error) {
+	return s.store.TaskTemplates().List(ctx)
+}
+
+// Update replaces a template's name, description and spec.
+func (s *TaskTemplateService) Update(ctx context.Context, id string, req *TaskTemplateRequest) (*model.TaskTemplate, error) {
+	t, err := s.store.TaskTemplates().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	name, spec, err := s.check(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	t.Name, t.Description, t.Spec, t.UpdatedAt = name, req.Description, spec, time.Now()
+	if err := s.store.TaskTemplates().Update(ctx, t); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return nil, conflictf("task template %q already exists", name)
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *TaskTemplateService) Delete(ctx context.Context, id string) error {
+	if _, err := s.store.TaskTemplates().Get(ctx, id); err != nil {
+		return err
+	}
+	return s.store.TaskTemplates().Delete(ctx, id)
+}
+
+// Instantiate creates a task from a template. Top-level fields in overrides
+// replace the template's; everything else comes from the template.
+func (s *TaskTemplateService) Instantiate(ctx context.Context, id string, overrides json.RawMessage) (*model.Task, error) {
+	t, err := s.store.TaskTemplates().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := specFields("spec", t.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(overrides)) > 0 {
+		extra, err := specFields("overrides", overrides)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range extra {
+			fields[k] = v
+		}
+	}
+	req, err := decodeSpec(fields)
+	if err != nil {
+		return nil, err
+	}
+	return s.taskSvc.Create(ctx, req)
+}
+
+// check validates a template request and returns its trimmed name and the
+// spec in compact form.
+func (s *TaskTemplateService) check(ctx context.Context, req *TaskTemplateRequest) (string, json.RawMessage, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return "", nil, invalidf("name is required")
+	}
+	fields, err := specFields("spec", req.Spec)
+	if err != nil {
+		return "", nil, err
+	}
+	taskReq, err := decodeSpec(fields)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := s.taskSvc.newTask(ctx, taskReq); err != nil {
+		if errors.Is(err, ErrInvalidInput) {
+			return "", nil, invalidf("spec does not produce a valid task: %s", err.Error())
+		}
+		return "", nil, err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, req.Spec); err != nil {
+		return "", nil, invalidf("spec: %s", err.Error())
+	}
+	return name, compact.Bytes(), nil
+}
+
+// specFields splits a JSON object into its top-level fields; what names it
+// in error messages.
+func specFields(what string, raw json.RawMessage) (map[string]json.RawMessage, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, invalidf("%s is required", what)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, invalidf("%s must be a JSON object", what)
+	}
+	return fields, nil
+}
+
+// decodeSpec turns spec fields into a CreateTaskRequest, rejecting fields the
+// request does not have so typos in a template surface when it is saved.
+func decodeSpec(fields map[string]json.RawMessage) (*CreateTaskRequest, error) {
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var req CreateTaskRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, invalidf("spec: %s", err.Error())
+	}
+	return &req, nil
+}
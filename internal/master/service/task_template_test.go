@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/sqlite"
+)
+
+func TestTaskTemplateInstantiateWithOverrides(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskTemplateService(st, NewTaskService(st))
+
+	for name, spec := range map[string]string{
+		"missing":     ``,
+		"not object":  `[1]`,
+		"invalid":     `{"target_url":"https://example.com/a.bin","execution_scope":"global"}`,
+		"unknown key": `{"target_url":"https://example.com/a.bin","execution_scope":"global","target_rate_mbps":100,"rate":1}`,
+	} {
+		_, err := svc.Create(ctx, &TaskTemplateRequest{Name: "bad", Spec: json.RawMessage(spec)})
+		if !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: err = %v, want ErrInvalidInput", name, err)
+		}
+	}
+
+	spec := `{"target_url":"https://example.com/a.bin","execution_scope":"global","target_rate_mbps":100,"duration_sec":600}`
+	tpl, err := svc.Create(ctx, &TaskTemplateRequest{Name: "nightly", Spec: json.RawMessage(spec)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Create(ctx, &TaskTemplateRequest{Name: "nightly", Spec: json.RawMessage(spec)}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("duplicate name: err = %v, want ErrConflict", err)
+	}
+
+	task, err := svc.Instantiate(ctx, tpl.ID, json.RawMessage(`{"target_rate_mbps":250}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.TargetRateMbps != 250 || task.DurationSec != 600 || task.TargetURL != "https://example.com/a.bin" {
+		t.Fatalf("task = rate %v, duration %d, url %q; want overridden rate and template duration and url",
+			task.TargetRateMbps, task.DurationSec, task.TargetURL)
+	}
+	if task.Status != model.TaskStatusPending {
+		t.Fatalf("status = %s, want pending", task.Status)
+	}
+	if _, err := svc.Instantiate(ctx, tpl.ID, json.RawMessage(`{"target_rps":10}`)); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("conflicting override: err = %v, want ErrInvalidInput", err)
+	}
+	if _, err := svc.Instantiate(ctx, "missing", nil); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("missing template: err = %v, want ErrNotFound", err)
+	}
+}
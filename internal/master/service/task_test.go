@@ -2,10 +2,13 @@ package service
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 	"github.com/aven/ngoogle/internal/store/sqlite"
 )
 
@@ -95,3 +98,1112 @@ func TestMarkRunningAndDoneTransitionTask(t *testing.T) {
 		t.Fatal("expected finished_at to be set")
 	}
 }
+
+func TestDispatchHonorsStartWindow(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	now := time.Now()
+	future, late := now.Add(time.Hour), now.Add(-2*time.Minute)
+
+	for _, task := range []*model.Task{
+		{ID: "future", StartAt: &future},
+		{ID: "late", StartAt: &late, DurationSec: 60},
+		{ID: "now"},
+	} {
+		task.Type = model.TaskTypeYoutube
+		task.TargetURL = "https://youtu.be/example"
+		task.Status = model.TaskStatusPending
+		task.Distribution = model.DistributionFlat
+		task.CreatedAt, task.UpdatedAt = now, now
+		if err := st.Tasks().Create(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		id      string
+		wantErr error
+		status  model.TaskStatus
+	}{
+		{"future", ErrInvalidInput, model.TaskStatusPending},
+		{"late", store.ErrConflict, model.TaskStatusFailed},
+		{"now", nil, model.TaskStatusDispatched},
+	}
+	for _, tc := range cases {
+		err := svc.Dispatch(ctx, tc.id)
+		if (tc.wantErr == nil) != (err == nil) || (err != nil && !errors.Is(err, tc.wantErr)) {
+			t.Fatalf("%s: Dispatch error = %v, want %v", tc.id, err, tc.wantErr)
+		}
+		got, err := st.Tasks().Get(ctx, tc.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != tc.status {
+			t.Fatalf("%s: status = %s, want %s", tc.id, got.Status, tc.status)
+		}
+	}
+}
+
+func TestCreateTaskTimezone(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	profile := &model.TrafficProfile{ID: "p1", Name: "tokyo", Distribution: model.DistributionDiurnal,
+		Points: "[]", Timezone: "Asia/Tokyo", CreatedAt: time.Now()}
+	if err := st.TrafficProfiles().Create(ctx, profile); err != nil {
+		t.Fatal(err)
+	}
+	base := CreateTaskRequest{
+		TargetURL:      "https://example.com/file.bin",
+		ExecutionScope: model.TaskExecutionScopeGlobal,
+		TargetRateMbps: 100,
+		Distribution:   model.DistributionDiurnal,
+	}
+
+	req := base
+	req.Timezone = "Not/AZone"
+	if _, err := svc.Create(ctx, &req); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for unknown zone, got %v", err)
+	}
+
+	req = base
+	req.TrafficProfileID = profile.ID
+	task, err := svc.Create(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Tasks().Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Timezone != "Asia/Tokyo" {
+		t.Fatalf("expected timezone inherited from profile, got %q", got.Timezone)
+	}
+
+	req.Timezone = "Europe/Berlin"
+	task, err = svc.Create(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Timezone != "Europe/Berlin" {
+		t.Fatalf("explicit timezone should win over the profile's, got %q", task.Timezone)
+	}
+}
+
+func TestCreateTaskReusesConnectionsByDefault(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	req := CreateTaskRequest{TargetURL: "https://example.com/file.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: 100}
+	task, err := svc.Create(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); !got.ReuseConnections {
+		t.Fatal("expected reuse_connections to default to true")
+	}
+
+	fresh := false
+	req.ReuseConnections = &fresh
+	task, err = svc.Create(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.ReuseConnections {
+		t.Fatal("expected reuse_connections=false to be stored")
+	}
+}
+
+func TestSummaryMergesAgentLatency(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	for _, id := range []string{"a1", "a2"} {
+		if err := st.Agents().Upsert(ctx, &model.Agent{ID: id, Status: model.AgentStatusOnline, LastHeartbeat: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := func(agentID string, n int, d time.Duration) {
+		stats := &model.LatencyStats{}
+		for i := 0; i < n; i++ {
+			stats.TTFB.Observe(d / 2)
+			stats.Total.Observe(d)
+		}
+		m := &model.TaskMetrics{TaskID: task.ID, AgentID: agentID, BytesTotal: int64(n), RequestCount: int64(n), Latency: stats}
+		if err := svc.RecordMetrics(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report("a1", 90, 20*time.Millisecond)
+	report("a2", 10, 2*time.Second)
+
+	sum, err := svc.Summary(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Agents != 2 || sum.BytesTotal != 100 || sum.RequestCount != 100 {
+		t.Fatalf("unexpected totals %+v", sum)
+	}
+	if sum.Latency.Samples != 100 || sum.TTFB.Samples != 100 {
+		t.Fatalf("samples = %d/%d, want 100", sum.Latency.Samples, sum.TTFB.Samples)
+	}
+	if sum.Latency.P50Ms < 20 || sum.Latency.P50Ms > 22 {
+		t.Errorf("latency p50 = %vms, want about 20ms", sum.Latency.P50Ms)
+	}
+	if sum.Latency.P95Ms < 2000 {
+		t.Errorf("latency p95 = %vms, want the slow agent's 2s", sum.Latency.P95Ms)
+	}
+	if sum.TTFB.P50Ms < 10 || sum.TTFB.P50Ms > 11 {
+		t.Errorf("ttfb p50 = %vms, want about 10ms", sum.TTFB.P50Ms)
+	}
+
+	if _, err := svc.Summary(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unknown task, got %v", err)
+	}
+}
+
+func TestCreateTaskRequiresExactlyOneRateMode(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	cases := []struct {
+		name    string
+		req     CreateTaskRequest
+		wantErr bool
+	}{
+		{"bandwidth", CreateTaskRequest{TargetRateMbps: 100}, false},
+		{"rps", CreateTaskRequest{TargetRps: 50}, false},
+		{"neither", CreateTaskRequest{}, true},
+		{"both", CreateTaskRequest{TargetRateMbps: 100, TargetRps: 50}, true},
+		{"negative rps", CreateTaskRequest{TargetRps: -1}, true},
+		{"rps with tpm", CreateTaskRequest{TargetRps: 50, DispatchRateTpm: 600}, true},
+		{"rps on youtube", CreateTaskRequest{TargetRps: 50, TargetURL: "https://youtu.be/example"}, true},
+		{"rps on dns", CreateTaskRequest{TargetRps: 50, TargetURL: "dns://10.0.0.53/example.com?type=AAAA"}, false},
+		{"bandwidth on dns", CreateTaskRequest{TargetRateMbps: 100, TargetURL: "dns://10.0.0.53/example.com"}, true},
+	}
+	for _, tc := range cases {
+		req := tc.req
+		if req.TargetURL == "" {
+			req.TargetURL = "https://example.com/file.bin"
+		}
+		req.ExecutionScope = model.TaskExecutionScopeGlobal
+		task, err := svc.Create(ctx, &req)
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("%s: expected ErrInvalidInput, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		got, err := st.Tasks().Get(ctx, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.TargetRps != req.TargetRps || got.TargetRateMbps != req.TargetRateMbps {
+			t.Errorf("%s: stored rate %v Mbps / %v rps", tc.name, got.TargetRateMbps, got.TargetRps)
+		}
+	}
+}
+
+func TestCreateDNSTaskValidatesQueries(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	task, err := svc.Create(ctx, &CreateTaskRequest{
+		TargetURLs: []string{"dns://10.0.0.53/example.com", "dns://[2001:db8::53]:5353/example.org?type=txt"}, TargetRps: 100,
+		ExecutionScope: model.TaskExecutionScopeGlobal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Type != model.TaskTypeDNS {
+		t.Fatalf("inferred type %s, want dns", task.Type)
+	}
+
+	for name, req := range map[string]CreateTaskRequest{
+		"unsupported type": {TargetURL: "dns://10.0.0.53/example.com?type=MX"},
+		"no name":          {TargetURL: "dns://10.0.0.53/"},
+		"http url":         {TargetURLs: []string{"dns://10.0.0.53/example.com", "https://example.com/file.bin"}},
+		"dns in static":    {Type: model.TaskTypeStatic, TargetURL: "dns://10.0.0.53/example.com"},
+	} {
+		req.TargetRps = 100
+		req.ExecutionScope = model.TaskExecutionScopeGlobal
+		if _, err := svc.Create(ctx, &req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+}
+
+func TestCreateTaskValidatesURLVars(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	// Variables may stand for the host, which must parse once filled in.
+	req := &CreateTaskRequest{TargetURL: "https://${REGION}.cdn.example.com/${HOSTNAME}/file.bin?n=${SEQ}", TargetRateMbps: 100, ExecutionScope: model.TaskExecutionScopeGlobal}
+	task, err := svc.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Type != model.TaskTypeStatic || task.TargetURL != req.TargetURL {
+		t.Fatalf("task = %s %s, want the static url kept as written", task.Type, task.TargetURL)
+	}
+
+	for name, url := range map[string]string{
+		"unknown":     "https://cdn.example.com/${ZONE}/file.bin",
+		"unclosed":    "https://cdn.example.com/${REGION/file.bin",
+		"seq in dns":  "dns://10.0.0.53/${SEQ}.example.com",
+		"bad expands": "https://${AGENT_IP}:x/file.bin",
+	} {
+		req := &CreateTaskRequest{TargetURL: url, TargetRateMbps: 100, ExecutionScope: model.TaskExecutionScopeGlobal}
+		if model.IsDNSURL(url) {
+			req.TargetRateMbps, req.TargetRps = 0, 100
+		}
+		if _, err := svc.Create(ctx, req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+}
+
+func TestCreateTaskValidatesReadChunk(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	for _, n := range []int64{-1, model.MinReadChunkBytes - 1, model.MaxReadChunkBytes + 1} {
+		req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 100, ReadChunkBytes: n, ExecutionScope: model.TaskExecutionScopeGlobal}
+		if _, err := svc.Create(ctx, req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("read_chunk_bytes %d: expected ErrInvalidInput, got %v", n, err)
+		}
+	}
+
+	req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 10000, ReadChunkBytes: 1 << 20, ExecutionScope: model.TaskExecutionScopeGlobal}
+	task, err := svc.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := svc.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReadChunkBytes != 1<<20 {
+		t.Fatalf("read_chunk_bytes = %d, want %d", got.ReadChunkBytes, 1<<20)
+	}
+}
+
+func TestCreateTaskValidatesBurst(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	// The burst must hold one read: the default 64 KB, or read_chunk_bytes.
+	for _, c := range []struct{ burst, readChunk int64 }{{-1, 0}, {32 << 10, 0}, {4 << 10, 8 << 10}} {
+		req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 0.5, BurstBytes: c.burst, ReadChunkBytes: c.readChunk, ExecutionScope: model.TaskExecutionScopeGlobal}
+		if _, err := svc.Create(ctx, req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("burst_bytes %d, read_chunk_bytes %d: expected ErrInvalidInput, got %v", c.burst, c.readChunk, err)
+		}
+	}
+
+	req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 0.5, BurstBytes: 4 << 10, ReadChunkBytes: 4 << 10, ExecutionScope: model.TaskExecutionScopeGlobal}
+	task, err := svc.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := svc.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BurstBytes != 4<<10 {
+		t.Fatalf("burst_bytes = %d, want %d", got.BurstBytes, 4<<10)
+	}
+}
+
+func TestCreateTaskValidatesCacheBustParam(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	for _, param := range []string{"cb=1", "a b", "x&y"} {
+		req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 100, CacheBustParam: param, ExecutionScope: model.TaskExecutionScopeGlobal}
+		if _, err := svc.Create(ctx, req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("cache_bust_param %q: expected ErrInvalidInput, got %v", param, err)
+		}
+	}
+
+	req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 100, CacheBustParam: "cb", ExecutionScope: model.TaskExecutionScopeGlobal}
+	task, err := svc.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := svc.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CacheBustParam != "cb" {
+		t.Fatalf("cache_bust_param = %q, want cb", got.CacheBustParam)
+	}
+}
+
+func TestCreateTaskValidatesRedirects(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	for _, c := range []struct {
+		policy model.RedirectPolicy
+		limit  int
+	}{{"sometimes", 0}, {model.RedirectFollow, -1}, {model.RedirectNone, 5}} {
+		req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 100, Redirects: c.policy, MaxRedirects: c.limit, ExecutionScope: model.TaskExecutionScopeGlobal}
+		if _, err := svc.Create(ctx, req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("redirects %q, max_redirects %d: expected ErrInvalidInput, got %v", c.policy, c.limit, err)
+		}
+	}
+
+	req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 100, Redirects: model.RedirectSameHost, MaxRedirects: 3, ExecutionScope: model.TaskExecutionScopeGlobal}
+	task, err := svc.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := svc.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Redirects != model.RedirectSameHost || got.MaxRedirects != 3 {
+		t.Fatalf("redirects = %q, max_redirects = %d", got.Redirects, got.MaxRedirects)
+	}
+}
+
+func TestCreateTaskValidatesContentCheck(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	const sum = "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+	for _, req := range []*CreateTaskRequest{
+		{TargetURL: "https://example.com/file.bin", ExpectedBytes: -1},
+		{TargetURL: "https://example.com/file.bin", ExpectedSHA256: "abc"},
+		{TargetURL: "https://example.com/file.bin", ExpectedSHA256: strings.Repeat("g", 64)},
+		{Type: model.TaskTypeYoutube, TargetURL: "https://www.youtube.com/watch?v=abc", ExpectedBytes: 1024},
+	} {
+		req.TargetRateMbps = 100
+		req.ExecutionScope = model.TaskExecutionScopeGlobal
+		if _, err := svc.Create(ctx, req); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("expected_bytes %d, expected_sha256 %q: expected ErrInvalidInput, got %v", req.ExpectedBytes, req.ExpectedSHA256, err)
+		}
+	}
+
+	req := &CreateTaskRequest{TargetURL: "https://example.com/file.bin", TargetRateMbps: 100, ExpectedBytes: 4, ExpectedSHA256: sum, ExecutionScope: model.TaskExecutionScopeGlobal}
+	task, err := svc.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := svc.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ExpectedBytes != 4 || got.ExpectedSHA256 != strings.ToLower(sum) {
+		t.Fatalf("expected_bytes = %d, expected_sha256 = %q", got.ExpectedBytes, got.ExpectedSHA256)
+	}
+
+	if err := st.TaskMetrics().Insert(ctx, &model.TaskMetrics{TaskID: task.ID, AgentID: "a1", RequestCount: 10, ErrorCount: 3, ContentErrorCount: 2, RecordedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := svc.Summary(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.ErrorCount != 3 || summary.ContentErrorCount != 2 {
+		t.Fatalf("error_count = %d, content_error_count = %d, want 3 and 2", summary.ErrorCount, summary.ContentErrorCount)
+	}
+}
+
+func TestCreateTaskRequestValidate(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	limits := TaskLimits{MaxTotalBytes: 1 << 40, MaxTotalRequests: 1e6}
+	tests := []struct {
+		name    string
+		edit    func(r *CreateTaskRequest)
+		wantErr string // "" for valid
+	}{
+		{"valid", func(r *CreateTaskRequest) {}, ""},
+		{"negative bytes target", func(r *CreateTaskRequest) { r.TotalBytesTarget = -1 }, "total_bytes_target must not be negative"},
+		{"negative requests target", func(r *CreateTaskRequest) { r.TotalRequestsTarget = -1 }, "total_requests_target must not be negative"},
+		{"negative duration", func(r *CreateTaskRequest) { r.DurationSec = -60 }, "duration_sec must not be negative"},
+		{"negative ramp up", func(r *CreateTaskRequest) { r.RampUpSec = -1 }, "ramp_up_sec must not be negative"},
+		{"negative ramp down", func(r *CreateTaskRequest) { r.RampDownSec = -1 }, "ramp_down_sec must not be negative"},
+		{"negative warmup", func(r *CreateTaskRequest) { r.WarmupSec = -1 }, "warmup_sec must not be negative"},
+		{"ramps fill duration", func(r *CreateTaskRequest) { r.RampUpSec, r.RampDownSec = 300, 300 }, ""},
+		{"ramps exceed duration", func(r *CreateTaskRequest) { r.RampUpSec, r.RampDownSec = 400, 300 }, "longer than the task's 10m0s"},
+		{"ramps exceed window", func(r *CreateTaskRequest) {
+			r.DurationSec, r.StartAt, r.EndAt, r.RampUpSec = 0, &start, &end, 90
+		}, "longer than the task's 1m0s"},
+		{"ramps without length", func(r *CreateTaskRequest) { r.DurationSec, r.RampUpSec = 0, 3600 }, ""},
+		{"end before start", func(r *CreateTaskRequest) { r.StartAt, r.EndAt = &end, &start }, "end_at must be after start_at"},
+		{"bytes at cap", func(r *CreateTaskRequest) { r.TotalBytesTarget = 1 << 40 }, ""},
+		{"bytes over cap", func(r *CreateTaskRequest) { r.TotalBytesTarget = 1<<40 + 1 }, "total_bytes_target 1099511627777 exceeds the maximum"},
+		{"requests over cap", func(r *CreateTaskRequest) { r.TotalRequestsTarget = 1e6 + 1 }, "total_requests_target 1000001 exceeds the maximum"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &CreateTaskRequest{TargetURL: "https://example.com/a.bin", TargetRateMbps: 100, DurationSec: 600}
+			tc.edit(req)
+			err := req.validate(model.TaskTypeStatic, limits)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("err = %v, want nil", err)
+			case tc.wantErr != "" && (!errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("err = %v, want ErrInvalidInput containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSummaryExcludesWarmup(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: 100, WarmupSec: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := st.Tasks().UpdateStatusWithTime(ctx, task.ID, model.TaskStatusRunning, start, "started_at"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Warm-up: slow requests and 1000 bytes by start+5s.
+	warm := &model.LatencyStats{}
+	for i := 0; i < 10; i++ {
+		warm.TTFB.Observe(time.Second)
+		warm.Total.Observe(time.Second)
+	}
+	// Steady state: 90 fast requests and 1.25 MB (10 Mbit) over the next 10s.
+	steady := &model.LatencyStats{TTFB: warm.TTFB.Clone(), Total: warm.Total.Clone()}
+	for i := 0; i < 90; i++ {
+		steady.TTFB.Observe(10 * time.Millisecond)
+		steady.Total.Observe(20 * time.Millisecond)
+	}
+	samples := []*model.TaskMetrics{
+		{TaskID: task.ID, AgentID: "a1", BytesTotal: 1000, RequestCount: 10, Latency: warm, Warmup: true, RecordedAt: start.Add(5 * time.Second)},
+		{TaskID: task.ID, AgentID: "a1", BytesTotal: 1000 + 1_250_000, RequestCount: 100, Latency: steady, RecordedAt: start.Add(15 * time.Second)},
+	}
+	for _, m := range samples {
+		if err := st.TaskMetrics().Insert(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum, err := svc.Summary(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.BytesTotal != 1_251_000 || sum.RequestCount != 100 {
+		t.Fatalf("totals should include warm-up, got %+v", sum)
+	}
+	if sum.Latency.Samples != 90 || sum.Latency.P99Ms > 22 {
+		t.Errorf("latency = %+v, want 90 steady samples around 20ms", sum.Latency)
+	}
+	if sum.TTFB.Samples != 90 || sum.TTFB.P99Ms > 11 {
+		t.Errorf("ttfb = %+v, want 90 steady samples around 10ms", sum.TTFB)
+	}
+	if sum.AvgRateMbps < 0.99 || sum.AvgRateMbps > 1.01 {
+		t.Errorf("avg rate = %v Mbps, want 1", sum.AvgRateMbps)
+	}
+}
+
+func TestStopSavesTaskResult(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Result(ctx, task.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("result before finish: err = %v, want ErrNotFound", err)
+	}
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := st.Tasks().UpdateStatusWithTime(ctx, task.ID, model.TaskStatusRunning, start, "started_at"); err != nil {
+		t.Fatal(err)
+	}
+	// Two agents over four 5s windows; the task-wide rates are 20, 40, 60 and 80.
+	for i, rate := range []float64{10, 20, 30, 40} {
+		at := start.Add(time.Duration(5*(i+1)) * time.Second)
+		for _, agent := range []string{"a1", "a2"} {
+			m := &model.TaskMetrics{TaskID: task.ID, AgentID: agent, BytesTotal: int64(1000 * (i + 1)), RequestCount: int64(i + 1), ErrorCount: 1, RateMbps5s: rate, RecordedAt: at}
+			if err := st.TaskMetrics().Insert(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := svc.Stop(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	res, err := svc.Result(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != model.TaskStatusStopped || res.EndReason != "stopped" {
+		t.Errorf("status = %s, reason = %q", res.Status, res.EndReason)
+	}
+	if res.BytesTotal != 8000 || res.RequestCount != 8 || res.ErrorCount != 2 {
+		t.Errorf("totals = %+v", res)
+	}
+	if res.P95RateMbps != 80 {
+		t.Errorf("p95 rate = %v, want 80", res.P95RateMbps)
+	}
+	if res.DurationSec < 59 || res.StartedAt == nil || !res.StartedAt.Equal(start) {
+		t.Errorf("duration = %v, started_at = %v", res.DurationSec, res.StartedAt)
+	}
+}
+
+func TestAgentResultUpdatesTaskResult(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	if err := st.Agents().Upsert(ctx, &model.Agent{ID: "a1", Status: model.AgentStatusOnline, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", AgentID: "a1", TargetRateMbps: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Tasks().UpdateStatusWithTime(ctx, task.ID, model.TaskStatusRunning, time.Now().Add(-time.Minute), "started_at"); err != nil {
+		t.Fatal(err)
+	}
+	// The last report is a tick behind the agent's final counters.
+	m := &model.TaskMetrics{TaskID: task.ID, AgentID: "a1", BytesTotal: 1000, RequestCount: 1, RecordedAt: time.Now().Add(-time.Second)}
+	if err := st.TaskMetrics().Insert(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Stop(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := &model.AgentTaskResult{TaskID: task.ID, AgentID: "a1", StopReason: "gone"}
+	if err := svc.RecordAgentResult(ctx, bad); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("unknown stop reason: err = %v, want ErrInvalidInput", err)
+	}
+	other := &model.AgentTaskResult{TaskID: task.ID, AgentID: "a2", StopReason: model.StopReasonStopped}
+	if err := svc.RecordAgentResult(ctx, other); !errors.Is(err, ErrNotAssigned) {
+		t.Fatalf("unassigned agent: err = %v, want ErrNotAssigned", err)
+	}
+
+	final := &model.AgentTaskResult{TaskID: task.ID, AgentID: "a1", StopReason: model.StopReasonStopped, BytesTotal: 1500, RequestCount: 2, DurationSec: 60}
+	if err := svc.RecordAgentResult(ctx, final); err != nil {
+		t.Fatal(err)
+	}
+	res, err := svc.Result(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.BytesTotal != 1500 || res.RequestCount != 2 || res.EndReason != "stopped" {
+		t.Errorf("result = %+v, want the agent's final counters", res)
+	}
+	if len(res.Agents) != 1 || res.Agents[0].StopReason != model.StopReasonStopped {
+		t.Errorf("agent results = %+v", res.Agents)
+	}
+}
+
+func TestCompareTaskResults(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	results := []*model.TaskResult{
+		{TaskID: ids[0], Status: model.TaskStatusDone, BytesTotal: 1000, RequestCount: 100, ErrorCount: 2, AvgRateMbps: 50, P95RateMbps: 80, DurationSec: 60, FinishedAt: now},
+		{TaskID: ids[1], Status: model.TaskStatusDone, BytesTotal: 1500, RequestCount: 100, ErrorCount: 5, AvgRateMbps: 75, P95RateMbps: 80, DurationSec: 50, FinishedAt: now},
+	}
+	for _, r := range results {
+		if err := st.TaskResults().Upsert(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmp, err := svc.Compare(ctx, ids[0], ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ResultDelta{
+		BytesTotalPct:    50,
+		AvgRatePct:       50,
+		ErrorRateAPct:    2,
+		ErrorRateBPct:    5,
+		ErrorRateDiffPct: 3,
+		DurationDiffSec:  -10,
+	}
+	if cmp.Delta != want {
+		t.Errorf("delta = %+v, want %+v", cmp.Delta, want)
+	}
+	if cmp.A.TaskID != ids[0] || cmp.B.TaskID != ids[1] {
+		t.Errorf("a = %s, b = %s", cmp.A.TaskID, cmp.B.TaskID)
+	}
+
+	if _, err := svc.Compare(ctx, ids[0], ids[2]); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("unfinished task: err = %v, want ErrConflict", err)
+	}
+	if _, err := svc.Compare(ctx, ids[0], "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("missing task: err = %v, want ErrNotFound", err)
+	}
+	if _, err := svc.Compare(ctx, ids[0], ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("missing id: err = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestStopByAgentStopsUnfinishedTasks(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	now := time.Now()
+	for _, id := range []string{"a1", "a2"} {
+		if err := st.Agents().Upsert(ctx, &model.Agent{ID: id, Hostname: id, Status: model.AgentStatusOnline, LastHeartbeat: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tasks := []struct {
+		id     string
+		agent  string
+		status model.TaskStatus
+	}{
+		{"pending", "a1", model.TaskStatusPending},
+		{"running", "a1", model.TaskStatusRunning},
+		{"done", "a1", model.TaskStatusDone},
+		{"other", "a2", model.TaskStatusRunning},
+	}
+	for _, tc := range tasks {
+		task := &model.Task{ID: tc.id, Type: model.TaskTypeYoutube, TargetURL: "https://youtu.be/example", AgentID: tc.agent,
+			Status: tc.status, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now}
+		if err := st.Tasks().Create(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := svc.StopByAgent(ctx, "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("stopped = %d, want 2", n)
+	}
+	want := map[string]model.TaskStatus{
+		"pending": model.TaskStatusStopped,
+		"running": model.TaskStatusStopped,
+		"done":    model.TaskStatusDone,
+		"other":   model.TaskStatusRunning,
+	}
+	for id, status := range want {
+		got, err := st.Tasks().Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != status {
+			t.Errorf("task %s: status = %s, want %s", id, got.Status, status)
+		}
+	}
+	if _, err := svc.StopByAgent(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown agent: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPauseAndResumeKeepProgress(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	now := time.Now()
+	if err := st.Agents().Upsert(ctx, &model.Agent{ID: "a1", Status: model.AgentStatusOnline, LastHeartbeat: now}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", AgentID: "a1", TargetRateMbps: 100, DurationSec: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Pause(ctx, task.ID); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("pause pending task: err = %v, want ErrConflict", err)
+	}
+	started := now.Add(-5 * time.Minute).Truncate(time.Second)
+	if err := st.Tasks().UpdateStatusWithTime(ctx, task.ID, model.TaskStatusRunning, started, "started_at"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.TaskMetrics().Insert(ctx, &model.TaskMetrics{TaskID: task.ID, AgentID: "a1", BytesTotal: 1000, RecordedAt: started.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Tasks().UpdateBytes(ctx, task.ID, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Pause(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The paused task stays in the agent's pull list with its progress.
+	pulled, err := svc.PullTasks(ctx, "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 1 || pulled[0].Status != model.TaskStatusPaused || pulled[0].TotalBytesDone != 1000 {
+		t.Fatalf("pulled while paused = %+v", pulled)
+	}
+	if err := svc.MarkRunning(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.Status != model.TaskStatusPaused {
+		t.Fatalf("mark running while paused: status = %s", got.Status)
+	}
+
+	// Pretend the pause began two minutes ago.
+	pausedAt := now.Add(-2 * time.Minute)
+	if err := st.Tasks().UpdatePause(ctx, task.ID, model.TaskStatusPaused, &pausedAt, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Resume(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Resume(ctx, task.ID); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("resume running task: err = %v, want ErrConflict", err)
+	}
+	got, err := st.Tasks().Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != model.TaskStatusRunning || got.PausedAt != nil || got.PausedSec < 119 || got.PausedSec > 121 {
+		t.Fatalf("after resume: status = %s, paused_at = %v, paused_sec = %d", got.Status, got.PausedAt, got.PausedSec)
+	}
+	if !got.StartedAt.Equal(started) {
+		t.Errorf("started_at = %v, want %v", got.StartedAt, started)
+	}
+	if active := got.ActiveDuration(time.Now()); active < 179*time.Second || active > 181*time.Second {
+		t.Errorf("active duration = %v, want about 3m", active)
+	}
+	// Agents see the start shifted past the pause, so rate curves continue.
+	pulled, err = svc.PullTasks(ctx, "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := started.Add(time.Duration(got.PausedSec) * time.Second); len(pulled) != 1 || !pulled[0].StartedAt.Equal(want) {
+		t.Fatalf("pulled after resume = %+v, want started_at %v", pulled, want)
+	}
+
+	// The agent carries on from the bytes it had reported.
+	if err := svc.RecordMetrics(ctx, &model.TaskMetrics{TaskID: task.ID, AgentID: "a1", BytesTotal: 1500}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.TotalBytesDone != 1500 {
+		t.Errorf("total_bytes_done = %d, want 1500", got.TotalBytesDone)
+	}
+}
+
+func TestWaitForTasksWakesOnDispatch(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", AgentID: "a1", Type: model.TaskTypeYoutube, TargetURL: "https://youtu.be/example",
+		Status: model.TaskStatusPending, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale version returns at once.
+	start := time.Now()
+	svc.WaitForTasks(ctx, "a1", "stale", time.Minute)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("stale version waited %s", d)
+	}
+
+	// Nothing changes: the wait runs out.
+	since := svc.TasksVersion("a1")
+	start = time.Now()
+	svc.WaitForTasks(ctx, "a1", since, 50*time.Millisecond)
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("wait returned after %s, before timing out", d)
+	}
+
+	// Dispatching the agent's task wakes it, but not another agent.
+	woken := make(chan string, 2)
+	for _, agentID := range []string{"a1", "a2"} {
+		since := svc.TasksVersion(agentID)
+		go func() {
+			svc.WaitForTasks(ctx, agentID, since, time.Minute)
+			woken <- agentID
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := svc.Dispatch(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-woken:
+		if id != "a1" {
+			t.Fatalf("woke %s, want a1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatch did not wake the agent")
+	}
+	if svc.TasksVersion("a1") == since {
+		t.Error("version did not change on dispatch")
+	}
+
+	svc.Shutdown()
+	select {
+	case <-woken:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not wake the other agent")
+	}
+	if svc.WaitForTasks(ctx, "a2", svc.TasksVersion("a2"), time.Minute) {
+		t.Error("WaitForTasks after Shutdown reported true")
+	}
+}
+
+func TestRecordMetricsThrottlesAndRejectsEndedTasks(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	for _, id := range []string{"a1", "a2"} {
+		if err := st.Agents().Upsert(ctx, &model.Agent{ID: id, Status: model.AgentStatusOnline, LastHeartbeat: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", ExecutionScope: model.TaskExecutionScopeGlobal, TargetRateMbps: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := func(agentID string, bytes int64) error {
+		return svc.RecordMetrics(ctx, &model.TaskMetrics{TaskID: task.ID, AgentID: agentID, BytesTotal: bytes})
+	}
+	stored := func() int {
+		t.Helper()
+		l, err := st.TaskMetrics().ListByTask(ctx, task.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(l)
+	}
+
+	// A burst from one agent keeps only its first report and the rest are
+	// refused as throttled; other agents have series of their own.
+	if err := report("a1", 100); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(2); i <= 5; i++ {
+		var throttled *ThrottledError
+		if err := report("a1", i*100); !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+			t.Fatalf("report %d of a burst: err = %v, want a throttled error with a retry delay", i, err)
+		}
+	}
+	if err := report("a2", 50); err != nil {
+		t.Fatal(err)
+	}
+	if n := stored(); n != 2 {
+		t.Fatalf("stored %d samples after a burst, want 2", n)
+	}
+
+	// The final report after the task ends counts; later ones are refused.
+	if err := svc.MarkDone(ctx, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := report("a1", 900); err != nil {
+		t.Fatalf("final report: %v", err)
+	}
+	if err := report("a1", 1000); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("report after the final one: err = %v, want conflict", err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.TotalBytesDone != 950 {
+		t.Errorf("total_bytes_done = %d, want 950", got.TotalBytesDone)
+	}
+
+	if err := svc.RecordMetrics(ctx, &model.TaskMetrics{TaskID: "missing", AgentID: "a1"}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("unknown task: err = %v, want not found", err)
+	}
+}
+
+func TestRecordMetricsKeepsFinalReportAfterTick(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	svc := NewTaskService(st)
+	if err := st.Agents().Upsert(ctx, &model.Agent{ID: "a1", Status: model.AgentStatusOnline, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := svc.Create(ctx, &CreateTaskRequest{TargetURL: "https://example.com/file.bin", AgentID: "a1", TargetRateMbps: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reporter's periodic tick, then the task stops straight away and
+	// the agent sends its last report while the task is still running.
+	if err := svc.RecordMetrics(ctx, &model.TaskMetrics{TaskID: task.ID, AgentID: "a1", BytesTotal: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RecordMetrics(ctx, &model.TaskMetrics{TaskID: task.ID, AgentID: "a1", BytesTotal: 150, Final: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Tasks().Get(ctx, task.ID); got.TotalBytesDone != 150 {
+		t.Fatalf("total_bytes_done = %d, want the final report's 150", got.TotalBytesDone)
+	}
+}
+
+func TestRecordMetricsRequiresAssignedAgent(t *testing.T) {
+	st, err := sqlite.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	for _, id := range []string{"a1", "a2"} {
+		if err := st.Agents().Upsert(ctx, &model.Agent{ID: id, Status: model.AgentStatusOnline, LastHeartbeat: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := NewTaskService(st)
+	g, err := NewAgentGroupService(st, svc).Create(ctx, &CreateAgentGroupRequest{Name: "eu", AgentIDs: []string{"a2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(req *CreateTaskRequest) string {
+		t.Helper()
+		req.TargetURL, req.TargetRateMbps = "https://example.com/file.bin", 100
+		task, err := svc.Create(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return task.ID
+	}
+	single := create(&CreateTaskRequest{AgentID: "a1"})
+	group := create(&CreateTaskRequest{AgentGroupID: g.ID})
+	global := create(&CreateTaskRequest{ExecutionScope: model.TaskExecutionScopeGlobal})
+
+	for _, tc := range []struct {
+		taskID, agentID string
+		assigned        bool
+	}{
+		{single, "a1", true},
+		{single, "a2", false},
+		{group, "a2", true},
+		{group, "a1", false},
+		{global, "a1", true},
+		{global, "unknown", false},
+	} {
+		err := svc.RecordMetrics(ctx, &model.TaskMetrics{TaskID: tc.taskID, AgentID: tc.agentID, BytesTotal: 100})
+		if tc.assigned && err != nil {
+			t.Errorf("task %s, agent %s: %v", tc.taskID, tc.agentID, err)
+		}
+		if !tc.assigned && !errors.Is(err, ErrNotAssigned) {
+			t.Errorf("task %s, agent %s: err = %v, want ErrNotAssigned", tc.taskID, tc.agentID, err)
+		}
+	}
+	if got, _ := st.Tasks().Get(ctx, single); got.TotalBytesDone != 100 {
+		t.Errorf("total_bytes_done = %d, want only the assigned agent's 100", got.TotalBytesDone)
+	}
+}
@@ -0,0 +1,294 @@
+package spec
+
+import (
+	"github.com/aven/ngoogle/internal/master/provision"
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// HeartbeatRequest is the body of POST /api/v1/agents/heartbeat.
+type HeartbeatRequest struct {
+	AgentID string `json:"agent_id"`
+	Token   string `json:"token"`
+	// Agents that predate the task and interface rates send rate_mbps only.
+	model.AgentRates
+	// RunningTasks lists the IDs of the tasks the agent is executing. Agents
+	// that predate it send null, and their tasks are not checked.
+	RunningTasks []string `json:"running_tasks"`
+}
+
+// TokenResponse is the body of POST /api/v1/agents/{id}/rotate-token.
+type TokenResponse struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+// StoppedResponse is the body of POST /api/v1/agents/{id}/stop-tasks.
+type StoppedResponse struct {
+	Stopped int `json:"stopped"`
+}
+
+// FailRequest is the optional body of POST /api/v1/tasks/{id}/fail.
+type FailRequest struct {
+	Reason string `json:"reason"`
+}
+
+// TaskOverrides is the optional body of POST /api/v1/tasks/from-template:
+// any subset of the task creation fields, replacing the template's values.
+type TaskOverrides service.CreateTaskRequest
+
+// URLPoolRequest is the body for creating or updating a URL pool.
+type URLPoolRequest struct {
+	Name        string            `json:"name"`
+	Type        model.URLPoolType `json:"type"`
+	Description string            `json:"description"`
+	URLs        []string          `json:"urls"`
+}
+
+// TrafficProfileRequest is the body for creating a traffic profile.
+type TrafficProfileRequest struct {
+	Name         string             `json:"name"`
+	ExternalID   string             `json:"external_id,omitempty"`
+	Description  string             `json:"description"`
+	Distribution model.Distribution `json:"distribution"`
+	Points       string             `json:"points"`
+	Timezone     string             `json:"timezone,omitempty"`
+}
+
+// BackupRequest is the optional body of POST /api/v1/admin/backup.
+type BackupRequest struct {
+	// Path, on the master's host, to write the backup to instead of
+	// returning it. The file must not exist yet.
+	Path string `json:"path,omitempty"`
+}
+
+// BackupResponse is the body of POST /api/v1/admin/backup when a path is
+// given.
+type BackupResponse struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+var timeRange = []Param{
+	{Name: "from", Description: "range start (RFC3339)", Format: "date-time"},
+	{Name: "to", Description: "range end (RFC3339)", Format: "date-time"},
+	{Name: "last", Description: "range length counting back from to or now, such as 15m or 24h; instead of from"},
+}
+
+// historyQuery are the query parameters of the bandwidth history endpoints.
+var historyQuery = append(timeRange[:len(timeRange):len(timeRange)],
+	Param{Name: "step", Description: "bucket size: a duration such as 10s, 15m or 1h, or seconds; raised to respect max_points (the step used is returned in X-Step-Seconds)"},
+	Param{Name: "max_points", Description: "most points to return, at least 2; capped by the server"},
+)
+
+// Routes lists every Master API endpoint. Keep it in step with the handlers'
+// Router methods; handler tests fail when the two drift apart.
+var Routes = []Route{
+	// Agents
+	{Method: "POST", Path: "/api/v1/agents/register", Tag: "agents", Summary: "Register an agent",
+		Body: service.RegisterRequest{}, Response: service.RegisterResponse{}},
+	{Method: "POST", Path: "/api/v1/agents/heartbeat", Tag: "agents", Summary: "Agent heartbeat",
+		Body: HeartbeatRequest{}, Response: StatusResponse{}},
+	{Method: "GET", Path: "/api/v1/agents", Tag: "agents", Summary: "List agents (total in X-Total-Count)",
+		Query: []Param{
+			{Name: "status", Description: "online or offline"},
+			{Name: "tag", Description: "only agents carrying this tag"},
+			{Name: "limit", Description: "page size (max 500)"},
+			{Name: "offset", Description: "number of agents to skip"},
+		},
+		Response: []model.Agent{}},
+	{Method: "GET", Path: "/api/v1/agents/{id}", Tag: "agents", Summary: "Get an agent",
+		Response: model.Agent{}},
+	{Method: "DELETE", Path: "/api/v1/agents/{id}", Tag: "agents", Summary: "Delete an agent",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/agents/{id}/rotate-token", Tag: "agents", Summary: "Rotate an agent's token",
+		Response: TokenResponse{}},
+	{Method: "POST", Path: "/api/v1/agents/{id}/disable", Tag: "agents", Summary: "Stop handing an agent new tasks",
+		Response: model.Agent{}},
+	{Method: "POST", Path: "/api/v1/agents/{id}/enable", Tag: "agents", Summary: "Resume handing an agent new tasks",
+		Response: model.Agent{}},
+	{Method: "GET", Path: "/api/v1/agents/{agent_id}/tasks/pull", Tag: "agents", Summary: "Pull tasks assigned to an agent",
+		Query: []Param{
+			{Name: "wait", Description: "long-poll: hold the request until the task list changes from since, for at most this duration (capped at 25s)"},
+			{Name: "since", Description: "X-Tasks-Version of the agent's previous pull"},
+		},
+		Response: []model.Task{}},
+	{Method: "GET", Path: "/api/v1/agents/{agent_id}/tasks/stream", Tag: "agents", Summary: "Stream an agent's task list (Server-Sent Events: a \"tasks\" event with the full list on connect, on every change and at least every 15s)",
+		Response: []model.Task{}},
+	{Method: "POST", Path: "/api/v1/agents/{id}/stop-tasks", Tag: "agents", Summary: "Stop all unfinished tasks assigned to an agent",
+		Response: StoppedResponse{}},
+
+	// Provisioning
+	{Method: "POST", Path: "/api/v1/agents/provision", Tag: "provisioning", Summary: "Start an SSH provisioning job",
+		Body: provision.JobRequest{}, Status: 201, Response: model.ProvisionJob{}},
+	{Method: "GET", Path: "/api/v1/agents/provision-jobs", Tag: "provisioning", Summary: "List provisioning jobs",
+		Response: []model.ProvisionJob{}},
+	{Method: "GET", Path: "/api/v1/agents/provision-jobs/{job_id}", Tag: "provisioning", Summary: "Get a provisioning job",
+		Response: model.ProvisionJob{}},
+	{Method: "POST", Path: "/api/v1/agents/provision-jobs/{job_id}/retry", Tag: "provisioning", Summary: "Retry a failed job",
+		Response: model.ProvisionJob{}},
+	{Method: "POST", Path: "/api/v1/agents/provision-jobs/{job_id}/uninstall", Tag: "provisioning", Summary: "Uninstall the agent a job installed",
+		Response: StatusResponse{}},
+	{Method: "DELETE", Path: "/api/v1/agents/provision-jobs/{job_id}", Tag: "provisioning", Summary: "Delete a provisioning job",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/credentials", Tag: "provisioning", Summary: "Store an SSH credential",
+		Body: provision.CredentialRequest{}, Status: 201, Response: model.Credential{}},
+	{Method: "GET", Path: "/api/v1/credentials", Tag: "provisioning", Summary: "List credentials",
+		Response: []model.Credential{}},
+	{Method: "PUT", Path: "/api/v1/credentials/{id}", Tag: "provisioning", Summary: "Rotate a credential in place",
+		Body: provision.CredentialRequest{}, Response: model.Credential{}},
+	{Method: "DELETE", Path: "/api/v1/credentials/{id}", Tag: "provisioning", Summary: "Delete a credential (409 while provision jobs use it)",
+		Query:    []Param{{Name: "force", Description: "true to delete it even though provision jobs reference it"}},
+		Response: StatusResponse{}},
+
+	// Tasks
+	{Method: "POST", Path: "/api/v1/tasks", Tag: "tasks", Summary: "Create a task",
+		Body: service.CreateTaskRequest{}, Status: 201, Response: model.Task{}},
+	{Method: "GET", Path: "/api/v1/tasks", Tag: "tasks", Summary: "List tasks",
+		Response: []model.Task{}},
+	{Method: "GET", Path: "/api/v1/tasks/compare", Tag: "tasks", Summary: "Compare the results of two finished tasks",
+		Query: []Param{
+			{Name: "a", Description: "baseline task id"},
+			{Name: "b", Description: "task id compared against a"},
+		},
+		Response: service.TaskComparison{}},
+	{Method: "GET", Path: "/api/v1/tasks/{id}", Tag: "tasks", Summary: "Get a task",
+		Response: model.Task{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/dispatch", Tag: "tasks", Summary: "Dispatch a task",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/stop", Tag: "tasks", Summary: "Stop a task",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/pause", Tag: "tasks", Summary: "Pause a dispatched or running task, keeping its progress",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/resume", Tag: "tasks", Summary: "Resume a paused task",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/run", Tag: "tasks", Summary: "Mark a task running",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/done", Tag: "tasks", Summary: "Mark a task done",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/fail", Tag: "tasks", Summary: "Mark a task failed",
+		Body: FailRequest{}, Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/metrics", Tag: "tasks", Summary: "Report task metrics (bearer: the agent's token)",
+		Body: model.TaskMetrics{}, Response: StatusResponse{}},
+	{Method: "GET", Path: "/api/v1/tasks/{id}/metrics", Tag: "tasks", Summary: "Get task metrics",
+		Query: timeRange, Response: []model.TaskMetrics{}},
+	{Method: "GET", Path: "/api/v1/tasks/{id}/summary", Tag: "tasks", Summary: "Get task totals and latency percentiles",
+		Response: service.TaskSummary{}},
+	{Method: "GET", Path: "/api/v1/tasks/{id}/result", Tag: "tasks", Summary: "Get the result saved when a task finished",
+		Response: model.TaskResult{}},
+	{Method: "POST", Path: "/api/v1/tasks/{id}/result", Tag: "tasks", Summary: "Report an agent's final result for a task (bearer: the agent's token)",
+		Body: model.AgentTaskResult{}, Response: StatusResponse{}},
+
+	{Method: "POST", Path: "/api/v1/tasks/from-template", Tag: "tasks", Summary: "Create a task from a template",
+		Query: []Param{{Name: "template_id", Description: "template to instantiate"}},
+		Body:  TaskOverrides{}, Status: 201, Response: model.Task{}},
+	{Method: "POST", Path: "/api/v1/tasks/import", Tag: "tasks", Summary: "Create or update tasks and profiles from a YAML or JSON document",
+		Query: []Param{{Name: "dry_run", Description: "true to report the changes without applying them"}},
+		Body:  service.TaskDocument{}, Response: service.ImportResult{}},
+	{Method: "GET", Path: "/api/v1/tasks/export", Tag: "tasks", Summary: "Export tasks and profiles that have an external_id",
+		Query:    []Param{{Name: "format", Description: "json (default) or yaml"}},
+		Response: service.TaskDocument{}},
+
+	// Task templates
+	{Method: "POST", Path: "/api/v1/task-templates", Tag: "task-templates", Summary: "Create a task template",
+		Body: service.TaskTemplateRequest{}, Status: 201, Response: model.TaskTemplate{}},
+	{Method: "GET", Path: "/api/v1/task-templates", Tag: "task-templates", Summary: "List task templates",
+		Response: []model.TaskTemplate{}},
+	{Method: "GET", Path: "/api/v1/task-templates/{id}", Tag: "task-templates", Summary: "Get a task template",
+		Response: model.TaskTemplate{}},
+	{Method: "PUT", Path: "/api/v1/task-templates/{id}", Tag: "task-templates", Summary: "Update a task template",
+		Body: service.TaskTemplateRequest{}, Response: model.TaskTemplate{}},
+	{Method: "DELETE", Path: "/api/v1/task-templates/{id}", Tag: "task-templates", Summary: "Delete a task template",
+		Response: StatusResponse{}},
+
+	// Maintenance windows
+	{Method: "POST", Path: "/api/v1/maintenance-windows", Tag: "maintenance", Summary: "Create a maintenance window, during which no task starts",
+		Body: service.MaintenanceWindowRequest{}, Status: 201, Response: model.MaintenanceWindow{}},
+	{Method: "GET", Path: "/api/v1/maintenance-windows", Tag: "maintenance", Summary: "List maintenance windows",
+		Response: []model.MaintenanceWindow{}},
+	{Method: "GET", Path: "/api/v1/maintenance-windows/{id}", Tag: "maintenance", Summary: "Get a maintenance window",
+		Response: model.MaintenanceWindow{}},
+	{Method: "PUT", Path: "/api/v1/maintenance-windows/{id}", Tag: "maintenance", Summary: "Update a maintenance window",
+		Body: service.MaintenanceWindowRequest{}, Response: model.MaintenanceWindow{}},
+	{Method: "DELETE", Path: "/api/v1/maintenance-windows/{id}", Tag: "maintenance", Summary: "Delete a maintenance window",
+		Response: StatusResponse{}},
+
+	// Task groups
+	{Method: "POST", Path: "/api/v1/task-groups", Tag: "task-groups", Summary: "Create a task group",
+		Body: service.CreateTaskGroupRequest{}, Status: 201, Response: model.TaskGroup{}},
+	{Method: "GET", Path: "/api/v1/task-groups", Tag: "task-groups", Summary: "List task groups",
+		Response: []model.TaskGroup{}},
+	{Method: "GET", Path: "/api/v1/task-groups/{id}", Tag: "task-groups", Summary: "Get a task group",
+		Response: model.TaskGroup{}},
+	{Method: "POST", Path: "/api/v1/task-groups/{id}/dispatch", Tag: "task-groups", Summary: "Dispatch a task group",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/task-groups/{id}/stop", Tag: "task-groups", Summary: "Stop a task group",
+		Response: StatusResponse{}},
+	{Method: "GET", Path: "/api/v1/task-groups/{id}/metrics", Tag: "task-groups", Summary: "Get task group metrics",
+		Query: timeRange, Response: []model.TaskMetrics{}},
+
+	// Agent groups
+	{Method: "POST", Path: "/api/v1/agent-groups", Tag: "agent-groups", Summary: "Create an agent group",
+		Body: service.CreateAgentGroupRequest{}, Status: 201, Response: model.AgentGroup{}},
+	{Method: "GET", Path: "/api/v1/agent-groups", Tag: "agent-groups", Summary: "List agent groups",
+		Response: []model.AgentGroup{}},
+	{Method: "GET", Path: "/api/v1/agent-groups/{id}", Tag: "agent-groups", Summary: "Get an agent group",
+		Response: model.AgentGroup{}},
+	{Method: "DELETE", Path: "/api/v1/agent-groups/{id}", Tag: "agent-groups", Summary: "Delete an agent group",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/agent-groups/{id}/members", Tag: "agent-groups", Summary: "Add agents to a group",
+		Body: service.AgentGroupMembersRequest{}, Response: model.AgentGroup{}},
+	{Method: "DELETE", Path: "/api/v1/agent-groups/{id}/members/{agent_id}", Tag: "agent-groups", Summary: "Remove an agent from a group",
+		Response: model.AgentGroup{}},
+	{Method: "GET", Path: "/api/v1/agent-groups/{id}/stats", Tag: "agent-groups", Summary: "Get a group's online count and total rate",
+		Response: service.AgentGroupStats{}},
+	{Method: "POST", Path: "/api/v1/agent-groups/{id}/dispatch", Tag: "agent-groups", Summary: "Dispatch pending tasks targeting a group",
+		Response: StatusResponse{}},
+	{Method: "POST", Path: "/api/v1/agent-groups/{id}/stop", Tag: "agent-groups", Summary: "Stop active tasks running on a group",
+		Response: StatusResponse{}},
+
+	// Dashboard
+	{Method: "GET", Path: "/api/v1/dashboard/overview", Tag: "dashboard", Summary: "Dashboard overview",
+		Query:    []Param{{Name: "top", Description: "number of highest-rate running tasks to list (default 5, max 100)"}},
+		Response: service.OverviewResponse{}},
+	{Method: "GET", Path: "/api/v1/dashboard/bandwidth/history", Tag: "dashboard", Summary: "Bandwidth history",
+		Query: append(historyQuery[:len(historyQuery):len(historyQuery)],
+			Param{Name: "agg", Description: "how each bucket's per-agent average rates combine into mbps: sum (default, the fleet total, also in avg_mbps), avg or max"}),
+		Response: []store.BandwidthPoint{}},
+	{Method: "GET", Path: "/api/v1/dashboard/bandwidth/total/history", Tag: "dashboard", Summary: "Fleet total bandwidth history: per bucket, the sum across agents of each agent's average rate",
+		Query:    historyQuery,
+		Response: []service.TotalPoint{}},
+	{Method: "GET", Path: "/api/v1/agents/{id}/bandwidth/history", Tag: "dashboard", Summary: "One agent's bandwidth history: per bucket its average (avg_mbps, mbps) and peak (max_mbps) sample",
+		Query:    historyQuery,
+		Response: []store.BandwidthPoint{}},
+	{Method: "GET", Path: "/api/v1/dashboard/provisioning", Tag: "dashboard", Summary: "Provision job success rates, install times and failing steps",
+		Query: timeRange, Response: service.ProvisioningStats{}},
+
+	// Traffic profiles
+	{Method: "POST", Path: "/api/v1/traffic-profiles", Tag: "traffic-profiles", Summary: "Create a traffic profile",
+		Body: TrafficProfileRequest{}, Status: 201, Response: model.TrafficProfile{}},
+	{Method: "GET", Path: "/api/v1/traffic-profiles", Tag: "traffic-profiles", Summary: "List traffic profiles",
+		Response: []model.TrafficProfile{}},
+	{Method: "GET", Path: "/api/v1/traffic-profiles/{id}", Tag: "traffic-profiles", Summary: "Get a traffic profile",
+		Response: model.TrafficProfile{}},
+
+	// URL pools
+	{Method: "POST", Path: "/api/v1/url-pools", Tag: "url-pools", Summary: "Create a URL pool",
+		Body: URLPoolRequest{}, Status: 201, Response: model.URLPool{}},
+	{Method: "GET", Path: "/api/v1/url-pools", Tag: "url-pools", Summary: "List URL pools",
+		Response: []model.URLPool{}},
+	{Method: "GET", Path: "/api/v1/url-pools/{id}", Tag: "url-pools", Summary: "Get a URL pool",
+		Response: model.URLPool{}},
+	{Method: "PUT", Path: "/api/v1/url-pools/{id}", Tag: "url-pools", Summary: "Update a URL pool",
+		Body: URLPoolRequest{}, Response: model.URLPool{}},
+	{Method: "DELETE", Path: "/api/v1/url-pools/{id}", Tag: "url-pools", Summary: "Delete a URL pool",
+		Response: StatusResponse{}},
+
+	// Admin
+	{Method: "POST", Path: "/api/v1/admin/backup", Tag: "admin", Summary: "Snapshot the SQLite database (requires ADMIN_TOKEN as a bearer token); returns the file as a download, or writes it to path on the master's host and returns the path and size",
+		Body: BackupRequest{}, Response: BackupResponse{}},
+
+	// Meta
+	{Method: "GET", Path: "/api/v1/openapi.json", Tag: "meta", Summary: "This OpenAPI document"},
+}
@@ -0,0 +1,248 @@
+// Package spec describes the Master HTTP API as an OpenAPI 3 document.
+//
+// The route table in routes.go is maintained by hand next to the handlers;
+// request and response schemas are derived from the Go types the handlers
+// actually decode and encode, so field changes flow into the spec on their own.
+package spec
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route documents one API endpoint.
+type Route struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	// Query lists query-string parameters. Path parameters are taken from
+	// the {name} segments of Path.
+	Query []Param
+	// Body is a zero value of the request body type, or nil for none.
+	Body any
+	// Status is the success status code (default 200).
+	Status int
+	// Response is a zero value of the success body type, or nil for none.
+	Response any
+}
+
+// Param documents a query parameter.
+type Param struct {
+	Name        string
+	Description string
+	Format      string // optional OpenAPI string format, e.g. date-time
+}
+
+// StatusResponse is the body of action endpoints that report only a status.
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ErrorResponse is the body of every non-2xx response.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+var pathParamRe = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Document builds the OpenAPI document for routes.
+func Document(routes []Route) map[string]any {
+	g := &generator{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+	errRef := g.schema(reflect.TypeOf(ErrorResponse{}))
+
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		op := map[string]any{
+			"operationId": operationID(rt),
+			"summary":     rt.Summary,
+			"tags":        []string{rt.Tag},
+		}
+		var params []any
+		for _, m := range pathParamRe.FindAllStringSubmatch(rt.Path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range rt.Query {
+			s := map[string]any{"type": "string"}
+			if q.Format != "" {
+				s["format"] = q.Format
+			}
+			params = append(params, map[string]any{
+				"name": q.Name, "in": "query", "description": q.Description, "schema": s,
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.Body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(g.schema(reflect.TypeOf(rt.Body))),
+			}
+		}
+		status := rt.Status
+		if status == 0 {
+			status = 200
+		}
+		success := map[string]any{"description": "OK"}
+		if rt.Response != nil {
+			success["content"] = jsonContent(g.schema(reflect.TypeOf(rt.Response)))
+		}
+		op["responses"] = map[string]any{
+			strconv.Itoa(status): success,
+			"default":            map[string]any{"description": "Error", "content": jsonContent(errRef)},
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]any{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "ngoogle Master API",
+			"version": "v1",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.schemas},
+	}
+}
+
+func jsonContent(schema any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// operationID turns "POST /api/v1/tasks/{id}/dispatch" into
+// "post_tasks_id_dispatch".
+func operationID(rt Route) string {
+	p := strings.TrimPrefix(rt.Path, "/api/v1/")
+	p = strings.NewReplacer("{", "", "}", "", "/", "_", "-", "_", ".", "_").Replace(p)
+	return strings.ToLower(rt.Method) + "_" + p
+}
+
+// ─── Schema generation ────────────────────────────────────────────────────────
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+type generator struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+// schema returns the schema for t, registering named structs as components
+// and returning a $ref to them.
+func (g *generator) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawJSONType:
+		return map[string]any{"type": "object"}
+	case t.Kind() == reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return s
+		}
+		s["nullable"] = true
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := g.name(t)
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = map[string]any{} // placeholder breaks recursion
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	// interface{} and anything else: any JSON value.
+	return map[string]any{}
+}
+
+// name picks a component name for t, qualifying it with the package name
+// only when two packages export the same type name.
+func (g *generator) name(t reflect.Type) string {
+	if n, ok := g.names[t]; ok {
+		return n
+	}
+	n := t.Name()
+	for other, taken := range g.names {
+		if taken == n && other != t {
+			pkg := t.PkgPath()
+			n = pkg[strings.LastIndex(pkg, "/")+1:] + "." + n
+			break
+		}
+	}
+	g.names[t] = n
+	return n
+}
+
+func (g *generator) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	g.fields(t, props)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// fields collects t's JSON-visible fields, flattening embedded structs the
+// way encoding/json does.
+func (g *generator) fields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, props)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+}
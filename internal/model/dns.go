@@ -0,0 +1,48 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// DNSQuery is one query of a dns task. Tasks give their queries as target
+// URLs of the form dns://server[:port]/name[?type=A|AAAA|TXT]; the port
+// defaults to 53 and the type to A.
+type DNSQuery struct {
+	Server string // host:port of the resolver queried
+	Name   string
+	Type   string // A, AAAA or TXT
+}
+
+// IsDNSURL reports whether raw is a dns:// target URL.
+func IsDNSURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "dns"
+}
+
+// ParseDNSQuery parses a dns:// target URL.
+func ParseDNSQuery(raw string) (DNSQuery, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "dns" || u.Hostname() == "" {
+		return DNSQuery{}, fmt.Errorf("invalid dns url %s: want dns://server[:port]/name", raw)
+	}
+	name := strings.Trim(u.Path, "/")
+	if name == "" || strings.Contains(name, "/") {
+		return DNSQuery{}, fmt.Errorf("invalid dns url %s: want a single name to query", raw)
+	}
+	qtype := strings.ToUpper(u.Query().Get("type"))
+	switch qtype {
+	case "":
+		qtype = "A"
+	case "A", "AAAA", "TXT":
+	default:
+		return DNSQuery{}, fmt.Errorf("invalid dns url %s: unsupported query type %s", raw, qtype)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "53"
+	}
+	return DNSQuery{Server: net.JoinHostPort(u.Hostname(), port), Name: name, Type: qtype}, nil
+}
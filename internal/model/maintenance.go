@@ -0,0 +1,100 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaintenanceWindow is a period during which the scheduler starts no tasks,
+// for change freezes. Tasks due to start during a window stay pending and
+// start once it ends; tasks already running are left alone.
+//
+// A window is either one-off, from StartAt to EndAt, or daily, from
+// DailyStart to DailyEnd ("15:04") every day in Timezone. A daily window
+// whose end is before its start spans midnight.
+type MaintenanceWindow struct {
+	ID          string     `json:"id" db:"id"`
+	Name        string     `json:"name" db:"name"`
+	Description string     `json:"description" db:"description"`
+	StartAt     *time.Time `json:"start_at,omitempty" db:"start_at"`
+	EndAt       *time.Time `json:"end_at,omitempty" db:"end_at"`
+	DailyStart  string     `json:"daily_start,omitempty" db:"daily_start"`
+	DailyEnd    string     `json:"daily_end,omitempty" db:"daily_end"`
+	// Timezone is the IANA zone of DailyStart and DailyEnd; UTC if empty.
+	Timezone  string    `json:"timezone,omitempty" db:"timezone"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Daily reports whether w recurs every day.
+func (w *MaintenanceWindow) Daily() bool { return w.DailyStart != "" }
+
+// ParseClock parses a time of day in the form "15:04".
+func ParseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("time of day %q is not HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ActiveUntil reports whether w is active at now and, if so, when it ends.
+// A daily window with an invalid time or zone is never active; the master
+// rejects such windows when they are saved.
+func (w *MaintenanceWindow) ActiveUntil(now time.Time) (time.Time, bool) {
+	if !w.Daily() {
+		if w.StartAt == nil || w.EndAt == nil || now.Before(*w.StartAt) || !now.Before(*w.EndAt) {
+			return time.Time{}, false
+		}
+		return *w.EndAt, true
+	}
+	from, err := ParseClock(w.DailyStart)
+	if err != nil {
+		return time.Time{}, false
+	}
+	to, err := ParseClock(w.DailyEnd)
+	if err != nil {
+		return time.Time{}, false
+	}
+	loc := time.UTC
+	if w.Timezone != "" {
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return time.Time{}, false
+		}
+	}
+	local := now.In(loc)
+	// at returns the given time of day on the day offset days from today,
+	// so that days shortened or lengthened by DST keep their wall clock.
+	at := func(days int, d time.Duration) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days,
+			int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, loc)
+	}
+	if from < to {
+		if !now.Before(at(0, from)) && now.Before(at(0, to)) {
+			return at(0, to), true
+		}
+		return time.Time{}, false
+	}
+	// The window spans midnight: it is active after today's start, until
+	// tomorrow's end, or before today's end, having started yesterday.
+	if !now.Before(at(0, from)) {
+		return at(1, to), true
+	}
+	if now.Before(at(0, to)) {
+		return at(0, to), true
+	}
+	return time.Time{}, false
+}
+
+// ActiveMaintenance returns the window among windows that is active at now
+// and ends last, with its end, or nil if none is active.
+func ActiveMaintenance(windows []*MaintenanceWindow, now time.Time) (*MaintenanceWindow, time.Time) {
+	var active *MaintenanceWindow
+	var until time.Time
+	for _, w := range windows {
+		if end, ok := w.ActiveUntil(now); ok && end.After(until) {
+			active, until = w, end
+		}
+	}
+	return active, until
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"strings"
 	"time"
+
+	"github.com/aven/ngoogle/pkg/latency"
 )
 
 // ─── Agent ──────────────────────────────────────────────────────────────────
@@ -16,17 +18,67 @@ const (
 )
 
 type Agent struct {
-	ID              string      `json:"id" db:"id"`
-	Hostname        string      `json:"hostname" db:"hostname"`
-	IP              string      `json:"ip" db:"ip"`
-	Port            int         `json:"port" db:"port"`
-	Token           string      `json:"token" db:"token"`
-	Status          AgentStatus `json:"status" db:"status"`
-	Version         string      `json:"version" db:"version"`
-	CurrentRateMbps float64     `json:"current_rate_mbps" db:"current_rate_mbps"`
-	LastHeartbeat   time.Time   `json:"last_heartbeat" db:"last_heartbeat"`
-	CreatedAt       time.Time   `json:"created_at" db:"created_at"`
-	UpdatedAt       time.Time   `json:"updated_at" db:"updated_at"`
+	ID       string `json:"id" db:"id"`
+	Hostname string `json:"hostname" db:"hostname"`
+	// MachineID identifies the agent's host across restarts and renames.
+	// Since it stands in for a credential when registration is open, it is
+	// never serialized.
+	MachineID string `json:"-" db:"machine_id"`
+	IP        string `json:"ip" db:"ip"`
+	Port      int    `json:"port" db:"port"`
+	// Token authenticates the agent. It is never serialized; registration
+	// hands it back once through a dedicated response.
+	Token   string      `json:"-" db:"token"`
+	Status  AgentStatus `json:"status" db:"status"`
+	Version string      `json:"version" db:"version"`
+	// Commit is the VCS revision the agent was built from, if known.
+	Commit          string   `json:"commit,omitempty" db:"commit_hash"`
+	TagsJSON        string   `json:"-" db:"tags_json"`
+	Tags            []string `json:"tags" db:"-"`
+	CurrentRateMbps float64  `json:"current_rate_mbps" db:"current_rate_mbps"`
+	// TaskRateMbps, TxRateMbps and RateMeasured are the rest of the last
+	// reported AgentRates; CurrentRateMbps is its RateMbps.
+	TaskRateMbps float64 `json:"task_rate_mbps" db:"task_rate_mbps"`
+	TxRateMbps   float64 `json:"tx_rate_mbps" db:"tx_rate_mbps"`
+	RateMeasured bool    `json:"rate_measured" db:"rate_measured"`
+	// CapacityMbps is the most bandwidth the agent says it can carry, set
+	// at registration; zero if it did not say. It caps the rate of tasks
+	// assigned to the agent alone.
+	CapacityMbps float64 `json:"capacity_mbps,omitempty" db:"capacity_mbps"`
+	// Enabled is false for an agent disabled by an operator: it stays
+	// registered and keeps the tasks it already runs, but is given no new
+	// ones. Stores set it only through AgentStore.SetEnabled.
+	Enabled       bool      `json:"enabled" db:"enabled"`
+	LastHeartbeat time.Time `json:"last_heartbeat" db:"last_heartbeat"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AgentRates is the bandwidth an agent reports with each heartbeat.
+// RateMbps is what its network interface received, when the agent can
+// measure it (Measured), and otherwise the sum of its tasks' rates. Totals
+// and bandwidth history use RateMbps, which includes traffic the task
+// meters miss, such as proxy and protocol overhead.
+type AgentRates struct {
+	RateMbps float64 `json:"rate_mbps"`
+	// TaskRateMbps is the sum of the agent's task meters: the traffic its
+	// tasks account for.
+	TaskRateMbps float64 `json:"task_rate_mbps"`
+	// TxRateMbps is what the interface sent, zero unless Measured.
+	TxRateMbps float64 `json:"tx_rate_mbps"`
+	Measured   bool    `json:"rate_measured"`
+}
+
+// AgentGroup is a named pool of agents, such as a region, that is viewed,
+// stopped and targeted by tasks as a unit. Unlike tags, which agents report
+// about themselves, groups are managed on the master.
+type AgentGroup struct {
+	ID          string    `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	AgentIDs    []string  `json:"agent_ids" db:"-"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // ─── Task ────────────────────────────────────────────────────────────────────
@@ -41,6 +93,7 @@ const (
 	TaskTypeYoutube TaskType = "youtube"
 	TaskTypeStatic  TaskType = "static"
 	TaskTypeMixed   TaskType = "mixed"
+	TaskTypeDNS     TaskType = "dns"
 
 	TaskStatusPending    TaskStatus = "pending"
 	TaskStatusDispatched TaskStatus = "dispatched"
@@ -48,6 +101,7 @@ const (
 	TaskStatusDone       TaskStatus = "done"
 	TaskStatusFailed     TaskStatus = "failed"
 	TaskStatusStopped    TaskStatus = "stopped"
+	TaskStatusPaused     TaskStatus = "paused"
 
 	DistributionFlat    Distribution = "flat"
 	DistributionRamp    Distribution = "ramp"
@@ -55,15 +109,39 @@ const (
 
 	TaskExecutionScopeSingleAgent TaskExecutionScope = "single_agent"
 	TaskExecutionScopeGlobal      TaskExecutionScope = "global"
+	TaskExecutionScopeAgentGroup  TaskExecutionScope = "agent_group"
 
 	URLPoolTypeYoutube URLPoolType = "youtube"
 	URLPoolTypeStatic  URLPoolType = "static"
 )
 
+// Bounds of a task's ReadChunkBytes, the size of the buffer static downloads
+// read response bodies into. Zero selects DefaultReadChunkBytes.
+const (
+	DefaultReadChunkBytes = 64 << 10
+	MinReadChunkBytes     = 4 << 10
+	MaxReadChunkBytes     = 4 << 20
+)
+
+// RedirectPolicy says which HTTP redirects the static requests of a task
+// follow. The empty policy is RedirectFollow.
+type RedirectPolicy string
+
+const (
+	RedirectFollow   RedirectPolicy = "follow"    // to any host
+	RedirectSameHost RedirectPolicy = "same_host" // only to the host of the URL requested
+	RedirectNone     RedirectPolicy = "none"      // the redirect response itself is the result
+)
+
+// DefaultMaxRedirects is how many redirects a request follows when the
+// task's MaxRedirects is zero, as with net/http's default policy.
+const DefaultMaxRedirects = 10
+
 type Task struct {
 	ID                  string             `json:"id" db:"id"`
 	GroupID             string             `json:"group_id,omitempty" db:"group_id"`
 	Name                string             `json:"name" db:"name"`
+	ExternalID          string             `json:"external_id,omitempty" db:"external_id"`
 	Type                TaskType           `json:"type" db:"type"`
 	URLPoolID           string             `json:"url_pool_id" db:"url_pool_id"`
 	TargetURL           string             `json:"target_url" db:"target_url"`
@@ -71,9 +149,11 @@ type Task struct {
 	TargetURLs          []string           `json:"target_urls,omitempty" db:"-"`
 	URLPool             *URLPool           `json:"url_pool,omitempty" db:"-"`
 	AgentID             string             `json:"agent_id" db:"agent_id"`
+	AgentGroupID        string             `json:"agent_group_id,omitempty" db:"agent_group_id"`
 	ExecutionScope      TaskExecutionScope `json:"execution_scope" db:"execution_scope"`
 	Status              TaskStatus         `json:"status" db:"status"`
 	TargetRateMbps      float64            `json:"target_rate_mbps" db:"target_rate_mbps"`
+	TargetRps           float64            `json:"target_rps,omitempty" db:"target_rps"`
 	StartAt             *time.Time         `json:"start_at,omitempty" db:"start_at"`
 	EndAt               *time.Time         `json:"end_at,omitempty" db:"end_at"`
 	DurationSec         int                `json:"duration_sec" db:"duration_sec"`
@@ -86,12 +166,25 @@ type Task struct {
 	RampUpSec           int                `json:"ramp_up_sec" db:"ramp_up_sec"`
 	RampDownSec         int                `json:"ramp_down_sec" db:"ramp_down_sec"`
 	TrafficProfileID    string             `json:"traffic_profile_id" db:"traffic_profile_id"`
+	Timezone            string             `json:"timezone,omitempty" db:"timezone"`
 	ConcurrentFragments int                `json:"concurrent_fragments" db:"concurrent_fragments"`
+	ReuseConnections    bool               `json:"reuse_connections" db:"reuse_connections"`
+	RangeChunkBytes     int64              `json:"range_chunk_bytes,omitempty" db:"range_chunk_bytes"`
+	ReadChunkBytes      int64              `json:"read_chunk_bytes,omitempty" db:"read_chunk_bytes"`
+	BurstBytes          int64              `json:"burst_bytes,omitempty" db:"burst_bytes"`
+	CacheBustParam      string             `json:"cache_bust_param,omitempty" db:"cache_bust_param"`
+	Redirects           RedirectPolicy     `json:"redirects,omitempty" db:"redirects"`
+	MaxRedirects        int                `json:"max_redirects,omitempty" db:"max_redirects"`
+	ExpectedBytes       int64              `json:"expected_bytes,omitempty" db:"expected_bytes"`
+	ExpectedSHA256      string             `json:"expected_sha256,omitempty" db:"expected_sha256"`
+	WarmupSec           int                `json:"warmup_sec,omitempty" db:"warmup_sec"`
 	Retries             int                `json:"retries" db:"retries"`
 	TotalBytesDone      int64              `json:"total_bytes_done" db:"total_bytes_done"`
 	ErrorMessage        string             `json:"error_message,omitempty" db:"error_message"`
 	DispatchedAt        *time.Time         `json:"dispatched_at,omitempty" db:"dispatched_at"`
 	StartedAt           *time.Time         `json:"started_at,omitempty" db:"started_at"`
+	PausedAt            *time.Time         `json:"paused_at,omitempty" db:"paused_at"`
+	PausedSec           int                `json:"paused_sec,omitempty" db:"paused_sec"`
 	FinishedAt          *time.Time         `json:"finished_at,omitempty" db:"finished_at"`
 	CreatedAt           time.Time          `json:"created_at" db:"created_at"`
 	UpdatedAt           time.Time          `json:"updated_at" db:"updated_at"`
@@ -104,6 +197,7 @@ type TaskGroup struct {
 	PoolIDsJSON         string             `json:"-" db:"pool_ids_json"`
 	PoolIDs             []string           `json:"pool_ids" db:"-"`
 	AgentID             string             `json:"agent_id" db:"agent_id"`
+	AgentGroupID        string             `json:"agent_group_id,omitempty" db:"agent_group_id"`
 	ExecutionScope      TaskExecutionScope `json:"execution_scope" db:"execution_scope"`
 	TargetRateMbps      float64            `json:"target_rate_mbps" db:"target_rate_mbps"`
 	StartAt             *time.Time         `json:"start_at,omitempty" db:"start_at"`
@@ -118,7 +212,19 @@ type TaskGroup struct {
 	RampUpSec           int                `json:"ramp_up_sec" db:"ramp_up_sec"`
 	RampDownSec         int                `json:"ramp_down_sec" db:"ramp_down_sec"`
 	TrafficProfileID    string             `json:"traffic_profile_id" db:"traffic_profile_id"`
+	Timezone            string             `json:"timezone,omitempty" db:"timezone"`
 	ConcurrentFragments int                `json:"concurrent_fragments" db:"concurrent_fragments"`
+	ReuseConnections    bool               `json:"reuse_connections" db:"reuse_connections"`
+	RangeChunkBytes     int64              `json:"range_chunk_bytes,omitempty" db:"range_chunk_bytes"`
+	ReadChunkBytes      int64              `json:"read_chunk_bytes,omitempty" db:"read_chunk_bytes"`
+	BurstBytes          int64              `json:"burst_bytes,omitempty" db:"burst_bytes"`
+	CacheBustParam      string             `json:"cache_bust_param,omitempty" db:"cache_bust_param"`
+	Redirects           RedirectPolicy     `json:"redirects,omitempty" db:"redirects"`
+	MaxRedirects        int                `json:"max_redirects,omitempty" db:"max_redirects"`
+	ExpectedBytes       int64              `json:"expected_bytes,omitempty" db:"expected_bytes"`
+	ExpectedSHA256      string             `json:"expected_sha256,omitempty" db:"expected_sha256"`
+	WarmupSec           int                `json:"warmup_sec,omitempty" db:"warmup_sec"`
+	StaggerSec          int                `json:"stagger_sec,omitempty" db:"stagger_sec"`
 	Retries             int                `json:"retries" db:"retries"`
 	CreatedAt           time.Time          `json:"created_at" db:"created_at"`
 	UpdatedAt           time.Time          `json:"updated_at" db:"updated_at"`
@@ -191,6 +297,33 @@ func (t *Task) URLs() []string {
 	return out
 }
 
+// MissedWindow reports whether a task that has not started yet can no longer
+// run inside its schedule: its end time has passed, or it was due to start
+// more than its duration ago.
+func (t *Task) MissedWindow(now time.Time) bool {
+	if t.EndAt != nil && !now.Before(*t.EndAt) {
+		return true
+	}
+	return t.StartAt != nil && t.DurationSec > 0 &&
+		now.Sub(*t.StartAt) >= time.Duration(t.DurationSec)*time.Second
+}
+
+// ActiveDuration returns how long a started task has run by now, leaving
+// out the time it spent paused.
+func (t *Task) ActiveDuration(now time.Time) time.Duration {
+	if t.StartedAt == nil {
+		return 0
+	}
+	if t.PausedAt != nil {
+		now = *t.PausedAt
+	}
+	d := now.Sub(*t.StartedAt) - time.Duration(t.PausedSec)*time.Second
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
 func (t *Task) Clone() *Task {
 	if t == nil {
 		return nil
@@ -326,6 +459,84 @@ func (p *URLPool) syncURLsJSON() {
 	p.URLsJSON = string(raw)
 }
 
+func (a *Agent) Normalize() {
+	if len(a.Tags) == 0 && a.TagsJSON != "" {
+		var tags []string
+		if err := json.Unmarshal([]byte(a.TagsJSON), &tags); err == nil {
+			a.Tags = sanitizeURLs(tags)
+		}
+	}
+	if a.TagsJSON == "" {
+		a.syncTagsJSON()
+	}
+}
+
+// SetTags replaces the agent's tags, trimming blanks and duplicates the same
+// way URL lists are cleaned.
+func (a *Agent) SetTags(tags []string) {
+	a.Tags = sanitizeURLs(tags)
+	a.syncTagsJSON()
+}
+
+func (a *Agent) syncTagsJSON() {
+	raw, err := json.Marshal(a.Tags)
+	if err != nil || len(a.Tags) == 0 {
+		a.TagsJSON = "[]"
+		return
+	}
+	a.TagsJSON = string(raw)
+}
+
+func (j *ProvisionJob) Normalize() {
+	if len(j.AgentEnv) == 0 && j.AgentEnvJSON != "" {
+		var env map[string]string
+		if err := json.Unmarshal([]byte(j.AgentEnvJSON), &env); err == nil && len(env) > 0 {
+			j.AgentEnv = env
+		}
+	}
+	if j.AgentEnvJSON == "" {
+		j.syncAgentEnvJSON()
+	}
+	if len(j.StepDurations) == 0 && j.StepDurationsJSON != "" {
+		var d map[string]float64
+		if err := json.Unmarshal([]byte(j.StepDurationsJSON), &d); err == nil && len(d) > 0 {
+			j.StepDurations = d
+		}
+	}
+	if j.StepDurationsJSON == "" {
+		j.SetStepDurations(j.StepDurations)
+	}
+}
+
+func (j *ProvisionJob) SetAgentEnv(env map[string]string) {
+	j.AgentEnv = env
+	j.syncAgentEnvJSON()
+}
+
+// SetStepDurations replaces the job's step durations.
+func (j *ProvisionJob) SetStepDurations(d map[string]float64) {
+	j.StepDurations = d
+	raw, err := json.Marshal(d)
+	if err != nil || len(d) == 0 {
+		j.StepDurationsJSON = "{}"
+		return
+	}
+	j.StepDurationsJSON = string(raw)
+}
+
+func (j *ProvisionJob) syncAgentEnvJSON() {
+	if len(j.AgentEnv) == 0 {
+		j.AgentEnvJSON = "{}"
+		return
+	}
+	raw, err := json.Marshal(j.AgentEnv)
+	if err != nil {
+		j.AgentEnvJSON = "{}"
+		return
+	}
+	j.AgentEnvJSON = string(raw)
+}
+
 func (p *URLPool) TaskType() TaskType {
 	switch p.Type {
 	case URLPoolTypeYoutube:
@@ -338,16 +549,118 @@ func (p *URLPool) TaskType() TaskType {
 // ─── Task Metrics ─────────────────────────────────────────────────────────────
 
 type TaskMetrics struct {
-	ID           int64     `json:"id" db:"id"`
-	TaskID       string    `json:"task_id" db:"task_id"`
-	AgentID      string    `json:"agent_id" db:"agent_id"`
-	BytesTotal   int64     `json:"bytes_total" db:"bytes_total"`
-	BytesDelta   int64     `json:"bytes_delta" db:"bytes_delta"`
-	RateMbps5s   float64   `json:"rate_mbps_5s" db:"rate_mbps_5s"`
-	RateMbps30s  float64   `json:"rate_mbps_30s" db:"rate_mbps_30s"`
-	RequestCount int64     `json:"request_count" db:"request_count"`
-	ErrorCount   int64     `json:"error_count" db:"error_count"`
-	RecordedAt   time.Time `json:"recorded_at" db:"recorded_at"`
+	ID           int64   `json:"id" db:"id"`
+	TaskID       string  `json:"task_id" db:"task_id"`
+	AgentID      string  `json:"agent_id" db:"agent_id"`
+	BytesTotal   int64   `json:"bytes_total" db:"bytes_total"`
+	BytesDelta   int64   `json:"bytes_delta" db:"bytes_delta"`
+	RateMbps5s   float64 `json:"rate_mbps_5s" db:"rate_mbps_5s"`
+	RateMbps30s  float64 `json:"rate_mbps_30s" db:"rate_mbps_30s"`
+	RequestCount int64   `json:"request_count" db:"request_count"`
+	ErrorCount   int64   `json:"error_count" db:"error_count"`
+	// ContentErrorCount is the part of ErrorCount from downloads that did
+	// not match the task's expected size or SHA-256.
+	ContentErrorCount int64         `json:"content_error_count,omitempty" db:"content_error_count"`
+	LatencyJSON       string        `json:"-" db:"latency_json"`
+	Latency           *LatencyStats `json:"latency,omitempty" db:"-"`
+	Warmup            bool          `json:"warmup,omitempty" db:"warmup"`
+	// Final marks the last report of an execution, sent as the agent stops
+	// the task; the master records it however soon it follows the one
+	// before.
+	Final      bool      `json:"final,omitempty" db:"-"`
+	RecordedAt time.Time `json:"recorded_at" db:"recorded_at"`
+}
+
+// LatencyStats holds an agent's cumulative request timings for a task:
+// time to first byte and time to the last byte of the response.
+type LatencyStats struct {
+	TTFB  latency.Histogram `json:"ttfb"`
+	Total latency.Histogram `json:"total"`
+}
+
+// Normalize keeps Latency and LatencyJSON in step, whichever one was set.
+func (m *TaskMetrics) Normalize() {
+	if m.Latency == nil && m.LatencyJSON != "" {
+		var stats LatencyStats
+		if err := json.Unmarshal([]byte(m.LatencyJSON), &stats); err == nil {
+			m.Latency = &stats
+		}
+	}
+	if m.LatencyJSON == "" && m.Latency != nil {
+		if raw, err := json.Marshal(m.Latency); err == nil {
+			m.LatencyJSON = string(raw)
+		}
+	}
+}
+
+// ─── Task Result ──────────────────────────────────────────────────────────────
+
+// TaskResult is the summary saved when a task reaches a terminal status, so
+// its outcome can be read without replaying its metrics.
+type TaskResult struct {
+	TaskID       string     `json:"task_id" db:"task_id"`
+	Status       TaskStatus `json:"status" db:"status"`
+	EndReason    string     `json:"end_reason" db:"end_reason"`
+	BytesTotal   int64      `json:"bytes_total" db:"bytes_total"`
+	RequestCount int64      `json:"request_count" db:"request_count"`
+	ErrorCount   int64      `json:"error_count" db:"error_count"`
+	AvgRateMbps  float64    `json:"avg_rate_mbps" db:"avg_rate_mbps"`
+	P95RateMbps  float64    `json:"p95_rate_mbps" db:"p95_rate_mbps"`
+	DurationSec  float64    `json:"duration_sec" db:"duration_sec"`
+	StartedAt    *time.Time `json:"started_at,omitempty" db:"started_at"`
+	FinishedAt   time.Time  `json:"finished_at" db:"finished_at"`
+	// Agents holds the final reports of the agents that ran the task.
+	Agents []*AgentTaskResult `json:"agents,omitempty" db:"-"`
+}
+
+// StopReason says why an agent's execution of a task returned.
+type StopReason string
+
+const (
+	StopReasonCompleted StopReason = "completed" // its volume targets were reached
+	StopReasonEndTime   StopReason = "end_time"  // its end time or duration passed
+	StopReasonStopped   StopReason = "stopped"   // the master paused, stopped or unassigned it
+	StopReasonFailed    StopReason = "failed"
+)
+
+// AgentTaskResult is the summary an agent sends once its execution of a task
+// returns. Its counters are exact where the last periodic metrics report may
+// be a tick behind.
+type AgentTaskResult struct {
+	TaskID       string     `json:"task_id" db:"task_id"`
+	AgentID      string     `json:"agent_id" db:"agent_id"`
+	StopReason   StopReason `json:"stop_reason" db:"stop_reason"`
+	Error        string     `json:"error,omitempty" db:"error"`
+	BytesTotal   int64      `json:"bytes_total" db:"bytes_total"`
+	RequestCount int64      `json:"request_count" db:"request_count"`
+	ErrorCount   int64      `json:"error_count" db:"error_count"`
+	DurationSec  float64    `json:"duration_sec" db:"duration_sec"` // wall-clock time the execution ran
+	ReportedAt   time.Time  `json:"reported_at" db:"reported_at"`
+}
+
+// TaskTemplate is a named, reusable set of task creation fields. It has no
+// runtime state; tasks are created from it with optional overrides.
+type TaskTemplate struct {
+	ID          string `json:"id" db:"id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	// Spec is a JSON object holding a subset of the task creation request.
+	Spec      json.RawMessage `json:"spec" db:"-"`
+	SpecJSON  string          `json:"-" db:"spec_json"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// Normalize keeps Spec and SpecJSON in step, preferring Spec when both are set.
+func (t *TaskTemplate) Normalize() {
+	switch {
+	case len(t.Spec) > 0:
+		t.SpecJSON = string(t.Spec)
+	case t.SpecJSON != "":
+		t.Spec = json.RawMessage(t.SpecJSON)
+	default:
+		t.Spec, t.SpecJSON = json.RawMessage("{}"), "{}"
+	}
 }
 
 // ─── Traffic Profile ─────────────────────────────────────────────────────────
@@ -355,10 +668,14 @@ type TaskMetrics struct {
 type TrafficProfile struct {
 	ID           string       `json:"id" db:"id"`
 	Name         string       `json:"name" db:"name"`
+	ExternalID   string       `json:"external_id,omitempty" db:"external_id"`
 	Description  string       `json:"description" db:"description"`
 	Distribution Distribution `json:"distribution" db:"distribution"`
-	// Points is a JSON array of {offset_sec, rate_pct} for diurnal curves
+	// Points is a JSON array of {offset_sec, rate_pct} for diurnal curves.
+	// With a Timezone, offsets are seconds since local midnight in that zone;
+	// without one they are seconds since the task started.
 	Points    string    `json:"points" db:"points"`
+	Timezone  string    `json:"timezone,omitempty" db:"timezone"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -371,8 +688,14 @@ const (
 	ProvisionStatusRunning ProvisionStatus = "running"
 	ProvisionStatusSuccess ProvisionStatus = "success"
 	ProvisionStatusFailed  ProvisionStatus = "failed"
+	// ProvisionStatusInterrupted marks a job stopped by a master shutdown.
+	// It is resumed when the master starts again.
+	ProvisionStatusInterrupted ProvisionStatus = "interrupted"
 )
 
+// ProvisionSteps are the steps of a provision job, in the order they run.
+var ProvisionSteps = []string{"ssh_check", "download_binary", "install_runtime", "install_service", "health_check"}
+
 type AuthType string
 
 const (
@@ -381,19 +704,37 @@ const (
 )
 
 type ProvisionJob struct {
-	ID            string          `json:"id" db:"id"`
-	HostIP        string          `json:"host_ip" db:"host_ip"`
-	SSHPort       int             `json:"ssh_port" db:"ssh_port"`
-	SSHUser       string          `json:"ssh_user" db:"ssh_user"`
-	AuthType      AuthType        `json:"auth_type" db:"auth_type"`
-	CredentialRef string          `json:"credential_ref" db:"credential_ref"`
-	Status        ProvisionStatus `json:"status" db:"status"`
-	CurrentStep   string          `json:"current_step" db:"current_step"`
-	Log           string          `json:"log" db:"log"`
-	AgentID       string          `json:"agent_id,omitempty" db:"agent_id"`
-	FailedStep    string          `json:"failed_step,omitempty" db:"failed_step"`
-	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+	ID                string            `json:"id" db:"id"`
+	HostIP            string            `json:"host_ip" db:"host_ip"`
+	SSHPort           int               `json:"ssh_port" db:"ssh_port"`
+	SSHUser           string            `json:"ssh_user" db:"ssh_user"`
+	AuthType          AuthType          `json:"auth_type" db:"auth_type"`
+	CredentialRef     string            `json:"credential_ref" db:"credential_ref"`
+	SudoCredentialRef string            `json:"sudo_credential_ref,omitempty" db:"sudo_credential_ref"`
+	InstallDir        string            `json:"install_dir,omitempty" db:"install_dir"`
+	ServiceName       string            `json:"service_name,omitempty" db:"service_name"`
+	AgentEnvJSON      string            `json:"-" db:"agent_env_json"`
+	AgentEnv          map[string]string `json:"agent_env,omitempty" db:"-"`
+	DownloadURL       string            `json:"download_url,omitempty" db:"download_url"`
+	AgentToken        string            `json:"-" db:"agent_token"`
+	Status            ProvisionStatus   `json:"status" db:"status"`
+	CurrentStep       string            `json:"current_step" db:"current_step"`
+	Log               string            `json:"log" db:"log"`
+	AgentID           string            `json:"agent_id,omitempty" db:"agent_id"`
+	FailedStep        string            `json:"failed_step,omitempty" db:"failed_step"`
+	// StepDurations holds the seconds each step of the latest run took,
+	// including the step it failed in.
+	StepDurationsJSON string             `json:"-" db:"step_durations"`
+	StepDurations     map[string]float64 `json:"step_durations,omitempty" db:"-"`
+	// QueuePosition is the place of a pending job in the queue for a
+	// provisioning slot, 1 for the next to start, and zero for a job that
+	// is not queued. EstimatedWaitSec is a rough guess of how long it will
+	// wait, from how long successful jobs took; zero without that history.
+	// Neither is stored: the master fills them in from its queue.
+	QueuePosition    int       `json:"queue_position,omitempty" db:"-"`
+	EstimatedWaitSec int       `json:"estimated_wait_sec,omitempty" db:"-"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // ─── Bandwidth Sample ─────────────────────────────────────────────────────────
@@ -413,4 +754,7 @@ type Credential struct {
 	Type      AuthType  `json:"type" db:"type"`
 	Payload   string    `json:"-" db:"payload"` // encrypted at rest
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// UsedBy counts the provision jobs that reference the credential, for
+	// SSH or sudo. It is not stored; ListCredentials fills it in.
+	UsedBy int `json:"used_by" db:"-"`
 }
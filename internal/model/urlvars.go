@@ -0,0 +1,143 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Target URLs may contain variables of the form ${NAME}, which each agent
+// replaces before requesting the URL, so that one task reaches per-agent or
+// per-region endpoints across the fleet. The variables are:
+//
+//	${AGENT_ID}  the agent's ID
+//	${HOSTNAME}  the agent's hostname
+//	${AGENT_IP}  the IP the agent registered with
+//	${REGION}    the value of the agent's region=<value> tag, empty without one
+//	${SEQ}       the request's sequence number in the execution, from 1
+//
+// ${SEQ} changes with every request, so only static and mixed tasks, whose
+// URLs are HTTP requests, may use it. Any other text, a lone $ included, is
+// left as is.
+const (
+	URLVarAgentID  = "AGENT_ID"
+	URLVarHostname = "HOSTNAME"
+	URLVarAgentIP  = "AGENT_IP"
+	URLVarRegion   = "REGION"
+	URLVarSeq      = "SEQ"
+)
+
+// RegionTagPrefix marks the agent tag that sets ${REGION}.
+const RegionTagPrefix = "region="
+
+// URLVars holds the values an agent gives the variables of target URLs
+// other than ${SEQ}.
+type URLVars struct {
+	AgentID  string
+	Hostname string
+	IP       string
+	Region   string
+}
+
+// RegionFromTags returns the value of the first region=<value> tag in tags,
+// or "" if there is none.
+func RegionFromTags(tags []string) string {
+	for _, tag := range tags {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(tag), RegionTagPrefix); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// Expand replaces the agent variables in raw, leaving ${SEQ} for ExpandSeq.
+func (v URLVars) Expand(raw string) string {
+	return expandURLVars(raw, func(name string) (string, bool) {
+		switch name {
+		case URLVarAgentID:
+			return v.AgentID, true
+		case URLVarHostname:
+			return v.Hostname, true
+		case URLVarAgentIP:
+			return v.IP, true
+		case URLVarRegion:
+			return v.Region, true
+		}
+		return "", false
+	})
+}
+
+// ExpandSeq replaces ${SEQ} in raw with seq.
+func ExpandSeq(raw string, seq int64) string {
+	return expandURLVars(raw, func(name string) (string, bool) {
+		if name == URLVarSeq {
+			return strconv.FormatInt(seq, 10), true
+		}
+		return "", false
+	})
+}
+
+// CheckURLVars returns an error if raw has a variable other than those
+// above, or one left unclosed. With seq false, ${SEQ} is not allowed either.
+func CheckURLVars(raw string, seq bool) error {
+	rest := raw
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			return nil
+		}
+		rest = rest[i+2:]
+		name, after, ok := strings.Cut(rest, "}")
+		if !ok {
+			return fmt.Errorf("url %s: unclosed ${", raw)
+		}
+		switch name {
+		case URLVarAgentID, URLVarHostname, URLVarAgentIP, URLVarRegion:
+		case URLVarSeq:
+			if !seq {
+				return fmt.Errorf("url %s: ${SEQ} is only allowed in static and mixed tasks", raw)
+			}
+		default:
+			return fmt.Errorf("url %s: unknown variable ${%s}", raw, name)
+		}
+		rest = after
+	}
+}
+
+// expandURLVars replaces each ${NAME} in raw for which lookup returns ok.
+func expandURLVars(raw string, lookup func(name string) (string, bool)) string {
+	if !strings.Contains(raw, "${") {
+		return raw
+	}
+	var b strings.Builder
+	rest := raw
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			break
+		}
+		name, after, ok := strings.Cut(rest[i+2:], "}")
+		if !ok {
+			break
+		}
+		b.WriteString(rest[:i])
+		if v, ok := lookup(name); ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(rest[i : len(rest)-len(after)])
+		}
+		rest = after
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+// ExpandURLVars replaces the agent variables in the task's target URLs with
+// v, leaving ${SEQ} for its executor.
+func (t *Task) ExpandURLVars(v URLVars) {
+	urls := t.URLs()
+	for i, u := range urls {
+		urls[i] = v.Expand(u)
+	}
+	t.SetTargetURLs(urls)
+}
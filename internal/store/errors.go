@@ -0,0 +1,12 @@
+package store
+
+import "errors"
+
+// Sentinel errors returned by Store implementations. Backends wrap them so
+// callers can test with errors.Is while keeping a descriptive message.
+var (
+	// ErrNotFound means the requested record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the write collides with an existing record.
+	ErrConflict = errors.New("conflict")
+)
@@ -12,8 +12,14 @@ type AgentStore interface {
 	Upsert(ctx context.Context, a *model.Agent) error
 	Get(ctx context.Context, id string) (*model.Agent, error)
 	List(ctx context.Context) ([]*model.Agent, error)
+	// ListFiltered returns one page of agents matching f, newest first, and
+	// the total number of matches ignoring Limit and Offset.
+	ListFiltered(ctx context.Context, f AgentFilter) ([]*model.Agent, int, error)
 	UpdateStatus(ctx context.Context, id string, status model.AgentStatus, heartbeat time.Time) error
-	UpdateRate(ctx context.Context, id string, rateMbps float64) error
+	UpdateRate(ctx context.Context, id string, rates model.AgentRates) error
+	// SetEnabled enables or disables an agent. Upsert leaves the flag alone,
+	// and agents are enabled when first inserted.
+	SetEnabled(ctx context.Context, id string, enabled bool) error
 	Delete(ctx context.Context, id string) error
 }
 
@@ -24,8 +30,15 @@ type TaskStore interface {
 	List(ctx context.Context) ([]*model.Task, error)
 	ListByGroup(ctx context.Context, groupID string) ([]*model.Task, error)
 	ListByAgent(ctx context.Context, agentID string, statuses []model.TaskStatus) ([]*model.Task, error)
+	// Update rewrites a task's configuration; status, progress and
+	// timestamps other than updated_at are left alone.
+	Update(ctx context.Context, t *model.Task) error
 	UpdateStatus(ctx context.Context, id string, status model.TaskStatus) error
 	UpdateStatusWithTime(ctx context.Context, id string, status model.TaskStatus, ts time.Time, field string) error
+	// UpdatePause sets a task's status together with its pause bookkeeping:
+	// when the current pause began (nil when not paused) and the seconds
+	// spent in earlier pauses.
+	UpdatePause(ctx context.Context, id string, status model.TaskStatus, pausedAt *time.Time, pausedSec int) error
 	UpdateBytes(ctx context.Context, id string, bytesTotal int64) error
 	SetError(ctx context.Context, id string, msg string) error
 	Delete(ctx context.Context, id string) error
@@ -37,6 +50,22 @@ type TaskMetricsStore interface {
 	ListByTask(ctx context.Context, taskID string, from, to time.Time) ([]*model.TaskMetrics, error)
 	LatestByTask(ctx context.Context, taskID string) (*model.TaskMetrics, error)
 	LatestByTaskAgents(ctx context.Context, taskID string) ([]*model.TaskMetrics, error)
+	// LatestWarmupByTaskAgents returns each agent's last sample recorded
+	// during the task's warm-up period.
+	LatestWarmupByTaskAgents(ctx context.Context, taskID string) ([]*model.TaskMetrics, error)
+}
+
+// TaskResultStore manages the final results of finished tasks.
+type TaskResultStore interface {
+	// Upsert saves r, replacing any earlier result for the same task.
+	Upsert(ctx context.Context, r *model.TaskResult) error
+	Get(ctx context.Context, taskID string) (*model.TaskResult, error)
+	// UpsertAgent saves r, replacing any earlier result the same agent
+	// reported for the task.
+	UpsertAgent(ctx context.Context, r *model.AgentTaskResult) error
+	// ListAgents returns the results agents reported for a task, ordered by
+	// agent ID.
+	ListAgents(ctx context.Context, taskID string) ([]*model.AgentTaskResult, error)
 }
 
 // TrafficProfileStore manages traffic profile records.
@@ -44,6 +73,7 @@ type TrafficProfileStore interface {
 	Create(ctx context.Context, p *model.TrafficProfile) error
 	Get(ctx context.Context, id string) (*model.TrafficProfile, error)
 	List(ctx context.Context) ([]*model.TrafficProfile, error)
+	Update(ctx context.Context, p *model.TrafficProfile) error
 }
 
 type URLPoolStore interface {
@@ -54,6 +84,25 @@ type URLPoolStore interface {
 	Delete(ctx context.Context, id string) error
 }
 
+// TaskTemplateStore manages task templates.
+type TaskTemplateStore interface {
+	Create(ctx context.Context, t *model.TaskTemplate) error
+	Get(ctx context.Context, id string) (*model.TaskTemplate, error)
+	List(ctx context.Context) ([]*model.TaskTemplate, error)
+	Update(ctx context.Context, t *model.TaskTemplate) error
+	Delete(ctx context.Context, id string) error
+}
+
+// MaintenanceWindowStore manages maintenance windows.
+type MaintenanceWindowStore interface {
+	Create(ctx context.Context, w *model.MaintenanceWindow) error
+	Get(ctx context.Context, id string) (*model.MaintenanceWindow, error)
+	// List returns all windows, oldest first.
+	List(ctx context.Context) ([]*model.MaintenanceWindow, error)
+	Update(ctx context.Context, w *model.MaintenanceWindow) error
+	Delete(ctx context.Context, id string) error
+}
+
 type TaskGroupStore interface {
 	Create(ctx context.Context, g *model.TaskGroup) error
 	Get(ctx context.Context, id string) (*model.TaskGroup, error)
@@ -61,6 +110,21 @@ type TaskGroupStore interface {
 	Delete(ctx context.Context, id string) error
 }
 
+// AgentGroupStore manages agent groups and their membership. Get and List
+// fill in each group's AgentIDs.
+type AgentGroupStore interface {
+	Create(ctx context.Context, g *model.AgentGroup) error
+	Get(ctx context.Context, id string) (*model.AgentGroup, error)
+	List(ctx context.Context) ([]*model.AgentGroup, error)
+	// Delete removes the group and its memberships.
+	Delete(ctx context.Context, id string) error
+	// AddMembers adds agents to a group; agents already in it are ignored.
+	AddMembers(ctx context.Context, groupID string, agentIDs []string) error
+	RemoveMember(ctx context.Context, groupID, agentID string) error
+	// GroupsOf returns the ids of the groups an agent belongs to.
+	GroupsOf(ctx context.Context, agentID string) ([]string, error)
+}
+
 // ProvisionJobStore manages provisioning job records.
 type ProvisionJobStore interface {
 	Create(ctx context.Context, j *model.ProvisionJob) error
@@ -69,7 +133,11 @@ type ProvisionJobStore interface {
 	UpdateStatus(ctx context.Context, id string, status model.ProvisionStatus, step string) error
 	AppendLog(ctx context.Context, id string, line string) error
 	SetAgentID(ctx context.Context, id string, agentID string) error
+	SetAgentToken(ctx context.Context, id string, token string) error
 	SetFailed(ctx context.Context, id string, step string, reason string) error
+	// SetStepDurations replaces the seconds each step of the job took.
+	SetStepDurations(ctx context.Context, id string, durations map[string]float64) error
+	// ResetForRetry clears the job's progress, log and step durations.
 	ResetForRetry(ctx context.Context, id string) error
 	Delete(ctx context.Context, id string) error
 }
@@ -78,7 +146,14 @@ type ProvisionJobStore interface {
 type BandwidthStore interface {
 	Insert(ctx context.Context, s *model.BandwidthSample) error
 	History(ctx context.Context, agentID string, from, to time.Time) ([]*model.BandwidthSample, error)
-	AggregateHistory(ctx context.Context, from, to time.Time, stepSec int) ([]BandwidthPoint, error)
+	// AggregateHistory averages each agent's samples per bucket of stepSec
+	// seconds, then sums those averages across agents into AvgMbps, the
+	// fleet total, takes their maximum as MaxMbps, and combines them with agg
+	// into Mbps.
+	AggregateHistory(ctx context.Context, from, to time.Time, stepSec int, agg BandwidthAgg) ([]BandwidthPoint, error)
+	// AgentHistory buckets one agent's samples like AggregateHistory: their
+	// average goes to AvgMbps and Mbps, and the highest sample to MaxMbps.
+	AgentHistory(ctx context.Context, agentID string, from, to time.Time, stepSec int) ([]BandwidthPoint, error)
 	PurgeOlderThan(ctx context.Context, before time.Time) error
 	TotalCurrent(ctx context.Context, since time.Time) (float64, error)
 }
@@ -88,14 +163,55 @@ type CredentialStore interface {
 	Create(ctx context.Context, c *model.Credential) error
 	Get(ctx context.Context, id string) (*model.Credential, error)
 	List(ctx context.Context) ([]*model.Credential, error)
+	// Update replaces a credential's name, type and payload in place,
+	// keeping its ID and creation time, so that jobs referencing it keep
+	// working after a key rotation.
+	Update(ctx context.Context, c *model.Credential) error
 	Delete(ctx context.Context, id string) error
 }
 
+// AgentFilter narrows AgentStore.ListFiltered. Zero fields match everything;
+// Limit <= 0 means no limit.
+type AgentFilter struct {
+	Status model.AgentStatus
+	Tag    string
+	Limit  int
+	Offset int
+}
+
 // BandwidthPoint is a time-bucketed bandwidth data point.
 type BandwidthPoint struct {
-	Ts      time.Time `json:"ts"`
-	AvgMbps float64   `json:"avg_mbps"`
-	MaxMbps float64   `json:"max_mbps"`
+	Ts time.Time `json:"ts"`
+	// AvgMbps is the fleet total: the sum across agents of each agent's
+	// average rate in the bucket.
+	AvgMbps float64 `json:"avg_mbps"`
+	// MaxMbps is the highest single agent's average rate in the bucket.
+	MaxMbps float64 `json:"max_mbps"`
+	// Mbps combines the agents' average rates in the bucket as requested.
+	Mbps float64 `json:"mbps"`
+}
+
+// BandwidthAgg says how AggregateHistory combines the average rates of the
+// agents that reported in a bucket into BandwidthPoint.Mbps.
+type BandwidthAgg string
+
+const (
+	BandwidthAggSum BandwidthAgg = "sum" // the fleet total
+	BandwidthAggAvg BandwidthAgg = "avg" // the mean agent; idle agents that reported count
+	BandwidthAggMax BandwidthAgg = "max" // the busiest agent
+)
+
+// SQL returns the SQL aggregate function for a, or "" if a is unknown.
+func (a BandwidthAgg) SQL() string {
+	switch a {
+	case BandwidthAggSum:
+		return "SUM"
+	case BandwidthAggAvg:
+		return "AVG"
+	case BandwidthAggMax:
+		return "MAX"
+	}
+	return ""
 }
 
 // Store bundles all sub-stores.
@@ -103,11 +219,30 @@ type Store interface {
 	Agents() AgentStore
 	Tasks() TaskStore
 	TaskMetrics() TaskMetricsStore
+	TaskResults() TaskResultStore
 	TrafficProfiles() TrafficProfileStore
 	URLPools() URLPoolStore
 	TaskGroups() TaskGroupStore
+	AgentGroups() AgentGroupStore
+	TaskTemplates() TaskTemplateStore
+	MaintenanceWindows() MaintenanceWindowStore
 	ProvisionJobs() ProvisionJobStore
 	Bandwidth() BandwidthStore
 	Credentials() CredentialStore
+	// WithTx runs fn in a transaction and commits it if fn returns nil;
+	// otherwise the transaction is rolled back and fn's error returned. fn
+	// must do its work through tx, not the outer store: on SQLite, which has
+	// a single writer connection, a write through the outer store blocks
+	// until the transaction ends. Calling WithTx on tx joins the enclosing
+	// transaction.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
 	Close() error
 }
+
+// Backuper is implemented by stores that can snapshot their database while
+// it is in use. The SQLite store does; back up PostgreSQL with pg_dump.
+type Backuper interface {
+	// Backup writes a consistent copy of the database to path, which must
+	// not exist yet.
+	Backup(ctx context.Context, path string) error
+}
@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type agentGroupStore struct{ db *db }
+
+// groupOut copies a stored group and fills in its members.
+func (s *agentGroupStore) groupOut(r *model.AgentGroup) *model.AgentGroup {
+	g := *r
+	g.AgentIDs = nil
+	for m := range s.db.t.members {
+		if m.groupID == r.ID {
+			g.AgentIDs = append(g.AgentIDs, m.agentID)
+		}
+	}
+	slices.Sort(g.AgentIDs)
+	return &g
+}
+
+func (s *agentGroupStore) Create(_ context.Context, g *model.AgentGroup) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.agentGroups[g.ID]; ok {
+		return conflict("agent group id %q", g.ID)
+	}
+	for _, r := range s.db.t.agentGroups {
+		if r.Name == g.Name {
+			return conflict("agent group name %q", g.Name)
+		}
+	}
+	r := *g
+	r.AgentIDs = nil
+	r.CreatedAt, r.UpdatedAt = g.CreatedAt.UTC(), g.UpdatedAt.UTC()
+	s.db.t.agentGroups[g.ID] = &r
+	return nil
+}
+
+func (s *agentGroupStore) Get(_ context.Context, id string) (*model.AgentGroup, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.agentGroups[id]
+	if !ok {
+		return nil, fmt.Errorf("agent group %w", store.ErrNotFound)
+	}
+	return s.groupOut(r), nil
+}
+
+func (s *agentGroupStore) List(_ context.Context) ([]*model.AgentGroup, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return list(s.db.t.agentGroups, nil, s.groupOut, func(a, b *model.AgentGroup) int {
+		return strings.Compare(a.Name, b.Name)
+	}), nil
+}
+
+func (s *agentGroupStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for m := range s.db.t.members {
+		if m.groupID == id {
+			delete(s.db.t.members, m)
+		}
+	}
+	delete(s.db.t.agentGroups, id)
+	return nil
+}
+
+func (s *agentGroupStore) AddMembers(_ context.Context, groupID string, agentIDs []string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for _, agentID := range agentIDs {
+		s.db.t.members[membership{groupID, agentID}] = struct{}{}
+	}
+	return nil
+}
+
+func (s *agentGroupStore) RemoveMember(_ context.Context, groupID, agentID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.members, membership{groupID, agentID})
+	return nil
+}
+
+func (s *agentGroupStore) GroupsOf(_ context.Context, agentID string) ([]string, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	var ids []string
+	for m := range s.db.t.members {
+		if m.agentID == agentID {
+			ids = append(ids, m.groupID)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
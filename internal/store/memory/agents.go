@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type agentStore struct{ db *db }
+
+// agentRow copies a into the stored form, which keeps tags as TagsJSON only.
+func agentRow(a *model.Agent) *model.Agent {
+	r := *a
+	r.Tags = nil
+	r.LastHeartbeat, r.CreatedAt, r.UpdatedAt = a.LastHeartbeat.UTC(), a.CreatedAt.UTC(), a.UpdatedAt.UTC()
+	return &r
+}
+
+func agentOut(r *model.Agent) *model.Agent {
+	a := *r
+	a.Normalize()
+	return &a
+}
+
+func (s *agentStore) newestFirst() func(a, b *model.Agent) int {
+	byCreated := s.db.t.byCreated("agents", true)
+	return func(a, b *model.Agent) int { return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID) }
+}
+
+func (s *agentStore) Upsert(_ context.Context, a *model.Agent) error {
+	a.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	r := agentRow(a)
+	r.Enabled = true
+	if old, ok := s.db.t.agents[a.ID]; ok {
+		r.CreatedAt, r.Enabled = old.CreatedAt, old.Enabled
+	}
+	s.db.t.agents[a.ID] = r
+	s.db.t.inserted("agents", a.ID)
+	return nil
+}
+
+func (s *agentStore) Get(_ context.Context, id string) (*model.Agent, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.agents[id]
+	if !ok {
+		return nil, fmt.Errorf("agent %w", store.ErrNotFound)
+	}
+	return agentOut(r), nil
+}
+
+func (s *agentStore) List(_ context.Context) ([]*model.Agent, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return list(s.db.t.agents, nil, agentOut, s.newestFirst()), nil
+}
+
+func (s *agentStore) ListFiltered(_ context.Context, f store.AgentFilter) ([]*model.Agent, int, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	l := list(s.db.t.agents, func(r *model.Agent) bool {
+		return f.Status == "" || r.Status == f.Status
+	}, agentOut, s.newestFirst())
+	if f.Tag != "" {
+		l = slices.DeleteFunc(l, func(a *model.Agent) bool { return !slices.Contains(a.Tags, f.Tag) })
+	}
+	total := len(l)
+	l = l[min(f.Offset, len(l)):]
+	if f.Limit > 0 {
+		l = l[:min(f.Limit, len(l))]
+	}
+	return l, total, nil
+}
+
+func (s *agentStore) UpdateStatus(_ context.Context, id string, status model.AgentStatus, heartbeat time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if old, ok := s.db.t.agents[id]; ok {
+		r := *old
+		r.Status, r.LastHeartbeat, r.UpdatedAt = status, heartbeat.UTC(), time.Now().UTC()
+		s.db.t.agents[id] = &r
+	}
+	return nil
+}
+
+func (s *agentStore) SetEnabled(_ context.Context, id string, enabled bool) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if old, ok := s.db.t.agents[id]; ok {
+		r := *old
+		r.Enabled, r.UpdatedAt = enabled, time.Now().UTC()
+		s.db.t.agents[id] = &r
+	}
+	return nil
+}
+
+func (s *agentStore) UpdateRate(_ context.Context, id string, rates model.AgentRates) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if old, ok := s.db.t.agents[id]; ok {
+		r := *old
+		r.CurrentRateMbps, r.TaskRateMbps, r.TxRateMbps = rates.RateMbps, rates.TaskRateMbps, rates.TxRateMbps
+		r.RateMeasured, r.UpdatedAt = rates.Measured, time.Now().UTC()
+		s.db.t.agents[id] = &r
+	}
+	return nil
+}
+
+func (s *agentStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	for m := range s.db.t.members {
+		if m.agentID == id {
+			delete(s.db.t.members, m)
+		}
+	}
+	delete(s.db.t.agents, id)
+	s.db.t.deleted("agents", id)
+	return nil
+}
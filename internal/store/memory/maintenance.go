@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type maintenanceWindowStore struct{ db *db }
+
+func maintenanceWindowOut(r *model.MaintenanceWindow) *model.MaintenanceWindow {
+	w := *r
+	w.StartAt, w.EndAt = copyTime(r.StartAt), copyTime(r.EndAt)
+	return &w
+}
+
+func (s *maintenanceWindowStore) Create(_ context.Context, w *model.MaintenanceWindow) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.maintenance[w.ID]; ok {
+		return conflict("maintenance window id %q", w.ID)
+	}
+	r := *w
+	r.StartAt, r.EndAt = copyTime(w.StartAt), copyTime(w.EndAt)
+	r.CreatedAt, r.UpdatedAt = w.CreatedAt.UTC(), w.UpdatedAt.UTC()
+	s.db.t.maintenance[w.ID] = &r
+	s.db.t.inserted("maintenance_windows", w.ID)
+	return nil
+}
+
+func (s *maintenanceWindowStore) Get(_ context.Context, id string) (*model.MaintenanceWindow, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.maintenance[id]
+	if !ok {
+		return nil, fmt.Errorf("maintenance window %w", store.ErrNotFound)
+	}
+	return maintenanceWindowOut(r), nil
+}
+
+func (s *maintenanceWindowStore) List(_ context.Context) ([]*model.MaintenanceWindow, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	byCreated := s.db.t.byCreated("maintenance_windows", false)
+	return list(s.db.t.maintenance, nil, maintenanceWindowOut, func(a, b *model.MaintenanceWindow) int {
+		return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
+
+func (s *maintenanceWindowStore) Update(_ context.Context, w *model.MaintenanceWindow) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.maintenance[w.ID]
+	if !ok {
+		return nil
+	}
+	r := *old
+	r.Name, r.Description = w.Name, w.Description
+	r.StartAt, r.EndAt = copyTime(w.StartAt), copyTime(w.EndAt)
+	r.DailyStart, r.DailyEnd, r.Timezone = w.DailyStart, w.DailyEnd, w.Timezone
+	r.UpdatedAt = w.UpdatedAt.UTC()
+	s.db.t.maintenance[w.ID] = &r
+	return nil
+}
+
+func (s *maintenanceWindowStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.maintenance, id)
+	s.db.t.deleted("maintenance_windows", id)
+	return nil
+}
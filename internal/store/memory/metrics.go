@@ -0,0 +1,297 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// Metric and bandwidth samples are stored to the second, like the SQL
+// stores' recorded_at columns, and range bounds are compared the same way.
+func toSecond(t time.Time) time.Time { return t.UTC().Truncate(time.Second) }
+
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(toSecond(from)) && !t.After(toSecond(to))
+}
+
+type taskMetricsStore struct{ db *db }
+
+func metricsOut(r *model.TaskMetrics) *model.TaskMetrics {
+	m := *r
+	m.Normalize()
+	return &m
+}
+
+func (s *taskMetricsStore) Insert(_ context.Context, m *model.TaskMetrics) error {
+	m.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.t.lastMetricID++
+	r := *m
+	r.ID, r.Latency, r.RecordedAt = s.db.t.lastMetricID, nil, toSecond(m.RecordedAt)
+	s.db.t.metrics = append(s.db.t.metrics, &r)
+	return nil
+}
+
+func (s *taskMetricsStore) ListByTask(_ context.Context, taskID string, from, to time.Time) ([]*model.TaskMetrics, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	var l []*model.TaskMetrics
+	for _, r := range s.db.t.metrics {
+		if r.TaskID == taskID && inRange(r.RecordedAt, from, to) {
+			l = append(l, metricsOut(r))
+		}
+	}
+	slices.SortStableFunc(l, func(a, b *model.TaskMetrics) int { return a.RecordedAt.Compare(b.RecordedAt) })
+	return l, nil
+}
+
+func (s *taskMetricsStore) LatestByTask(_ context.Context, taskID string) (*model.TaskMetrics, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	var latest *model.TaskMetrics
+	for _, r := range s.db.t.metrics {
+		if r.TaskID == taskID && (latest == nil || !r.RecordedAt.Before(latest.RecordedAt)) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return metricsOut(latest), nil
+}
+
+func (s *taskMetricsStore) LatestByTaskAgents(_ context.Context, taskID string) ([]*model.TaskMetrics, error) {
+	return s.latestPerAgent(taskID, false), nil
+}
+
+func (s *taskMetricsStore) LatestWarmupByTaskAgents(_ context.Context, taskID string) ([]*model.TaskMetrics, error) {
+	return s.latestPerAgent(taskID, true), nil
+}
+
+// latestPerAgent returns each agent's newest sample for taskID, considering
+// only warm-up samples if warmup is set, ordered by agent id.
+func (s *taskMetricsStore) latestPerAgent(taskID string, warmup bool) []*model.TaskMetrics {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	latest := map[string]*model.TaskMetrics{}
+	for _, r := range s.db.t.metrics {
+		if r.TaskID != taskID || (warmup && !r.Warmup) {
+			continue
+		}
+		if l, ok := latest[r.AgentID]; !ok || !r.RecordedAt.Before(l.RecordedAt) {
+			latest[r.AgentID] = r
+		}
+	}
+	var list []*model.TaskMetrics
+	for _, r := range latest {
+		list = append(list, metricsOut(r))
+	}
+	slices.SortFunc(list, func(a, b *model.TaskMetrics) int { return strings.Compare(a.AgentID, b.AgentID) })
+	return list
+}
+
+// ─── Task results ─────────────────────────────────────────────────────────────
+
+type taskResultStore struct{ db *db }
+
+func (s *taskResultStore) Upsert(_ context.Context, r *model.TaskResult) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	row := *r
+	row.StartedAt, row.FinishedAt = copyTime(r.StartedAt), r.FinishedAt.UTC()
+	s.db.t.results[r.TaskID] = &row
+	return nil
+}
+
+func (s *taskResultStore) Get(_ context.Context, taskID string) (*model.TaskResult, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	row, ok := s.db.t.results[taskID]
+	if !ok {
+		return nil, fmt.Errorf("task result %w", store.ErrNotFound)
+	}
+	r := *row
+	r.StartedAt = copyTime(row.StartedAt)
+	return &r, nil
+}
+
+func (s *taskResultStore) UpsertAgent(_ context.Context, r *model.AgentTaskResult) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	row := *r
+	row.ReportedAt = r.ReportedAt.UTC()
+	s.db.t.agentResults[agentResultKey{r.TaskID, r.AgentID}] = &row
+	return nil
+}
+
+func (s *taskResultStore) ListAgents(_ context.Context, taskID string) ([]*model.AgentTaskResult, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	var list []*model.AgentTaskResult
+	for k, row := range s.db.t.agentResults {
+		if k.taskID == taskID {
+			r := *row
+			list = append(list, &r)
+		}
+	}
+	slices.SortFunc(list, func(a, b *model.AgentTaskResult) int { return strings.Compare(a.AgentID, b.AgentID) })
+	return list, nil
+}
+
+// ─── Bandwidth ────────────────────────────────────────────────────────────────
+
+type bandwidthStore struct{ db *db }
+
+func (s *bandwidthStore) Insert(_ context.Context, bs *model.BandwidthSample) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.t.lastSampleID++
+	r := *bs
+	r.ID, r.RecordedAt = s.db.t.lastSampleID, toSecond(bs.RecordedAt)
+	s.db.t.samples = append(s.db.t.samples, &r)
+	return nil
+}
+
+func (s *bandwidthStore) History(_ context.Context, agentID string, from, to time.Time) ([]*model.BandwidthSample, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	var l []*model.BandwidthSample
+	for _, r := range s.db.t.samples {
+		if r.AgentID == agentID && inRange(r.RecordedAt, from, to) {
+			b := *r
+			l = append(l, &b)
+		}
+	}
+	slices.SortStableFunc(l, func(a, b *model.BandwidthSample) int { return a.RecordedAt.Compare(b.RecordedAt) })
+	return l, nil
+}
+
+// AggregateHistory averages each agent's samples per step, then sums and
+// takes the maximum of those averages across agents, and combines them with
+// agg.
+func (s *bandwidthStore) AggregateHistory(_ context.Context, from, to time.Time, stepSec int, agg store.BandwidthAgg) ([]store.BandwidthPoint, error) {
+	if agg.SQL() == "" {
+		return nil, fmt.Errorf("unknown bandwidth aggregation %q", agg)
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	type key struct {
+		bucket  int64
+		agentID string
+	}
+	type avg struct {
+		sum float64
+		n   int
+	}
+	step := int64(stepSec)
+	perAgent := map[key]*avg{}
+	for _, r := range s.db.t.samples {
+		ts := r.RecordedAt.Unix()
+		if ts < from.Unix() || ts > to.Unix() {
+			continue
+		}
+		k := key{ts / step * step, r.AgentID}
+		a, ok := perAgent[k]
+		if !ok {
+			a = &avg{}
+			perAgent[k] = a
+		}
+		a.sum += r.RateMbps
+		a.n++
+	}
+	points := map[int64]*store.BandwidthPoint{}
+	agents := map[int64]int{}
+	for k, a := range perAgent {
+		v := a.sum / float64(a.n)
+		p, ok := points[k.bucket]
+		if !ok {
+			p = &store.BandwidthPoint{Ts: time.Unix(k.bucket, 0).UTC(), MaxMbps: v}
+			points[k.bucket] = p
+		}
+		p.AvgMbps += v
+		p.MaxMbps = max(p.MaxMbps, v)
+		agents[k.bucket]++
+	}
+	var result []store.BandwidthPoint
+	for bucket, p := range points {
+		switch agg {
+		case store.BandwidthAggSum:
+			p.Mbps = p.AvgMbps
+		case store.BandwidthAggAvg:
+			p.Mbps = p.AvgMbps / float64(agents[bucket])
+		case store.BandwidthAggMax:
+			p.Mbps = p.MaxMbps
+		}
+		result = append(result, *p)
+	}
+	slices.SortFunc(result, func(a, b store.BandwidthPoint) int { return a.Ts.Compare(b.Ts) })
+	return result, nil
+}
+
+// AgentHistory averages one agent's samples per step and takes their
+// maximum.
+func (s *bandwidthStore) AgentHistory(_ context.Context, agentID string, from, to time.Time, stepSec int) ([]store.BandwidthPoint, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	step := int64(stepSec)
+	points := map[int64]*store.BandwidthPoint{}
+	counts := map[int64]int{}
+	for _, r := range s.db.t.samples {
+		ts := r.RecordedAt.Unix()
+		if r.AgentID != agentID || ts < from.Unix() || ts > to.Unix() {
+			continue
+		}
+		bucket := ts / step * step
+		p, ok := points[bucket]
+		if !ok {
+			p = &store.BandwidthPoint{Ts: time.Unix(bucket, 0).UTC(), MaxMbps: r.RateMbps}
+			points[bucket] = p
+		}
+		p.AvgMbps += r.RateMbps
+		p.MaxMbps = max(p.MaxMbps, r.RateMbps)
+		counts[bucket]++
+	}
+	var result []store.BandwidthPoint
+	for bucket, p := range points {
+		p.AvgMbps /= float64(counts[bucket])
+		p.Mbps = p.AvgMbps
+		result = append(result, *p)
+	}
+	slices.SortFunc(result, func(a, b store.BandwidthPoint) int { return a.Ts.Compare(b.Ts) })
+	return result, nil
+}
+
+func (s *bandwidthStore) PurgeOlderThan(_ context.Context, before time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.t.samples = slices.DeleteFunc(s.db.t.samples, func(r *model.BandwidthSample) bool {
+		return r.RecordedAt.Unix() < before.Unix()
+	})
+	return nil
+}
+
+// TotalCurrent sums each agent's latest rate since since.
+func (s *bandwidthStore) TotalCurrent(_ context.Context, since time.Time) (float64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	latest := map[string]*model.BandwidthSample{}
+	for _, r := range s.db.t.samples {
+		if r.RecordedAt.Unix() < since.Unix() {
+			continue
+		}
+		if l, ok := latest[r.AgentID]; !ok || !r.RecordedAt.Before(l.RecordedAt) {
+			latest[r.AgentID] = r
+		}
+	}
+	var total float64
+	for _, r := range latest {
+		total += r.RateMbps
+	}
+	return total, nil
+}
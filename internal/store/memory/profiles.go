@@ -0,0 +1,258 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// ─── Traffic Profile ──────────────────────────────────────────────────────────
+
+type trafficProfileStore struct{ db *db }
+
+func profileOut(r *model.TrafficProfile) *model.TrafficProfile {
+	p := *r
+	return &p
+}
+
+// checkExternalID returns a conflict if another profile than id uses
+// externalID.
+func (s *trafficProfileStore) checkExternalID(id, externalID string) error {
+	if externalID == "" {
+		return nil
+	}
+	for _, r := range s.db.t.profiles {
+		if r.ExternalID == externalID && r.ID != id {
+			return conflict("profile external_id %q", externalID)
+		}
+	}
+	return nil
+}
+
+func (s *trafficProfileStore) Create(_ context.Context, p *model.TrafficProfile) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.profiles[p.ID]; ok {
+		return conflict("profile id %q", p.ID)
+	}
+	if err := s.checkExternalID(p.ID, p.ExternalID); err != nil {
+		return err
+	}
+	r := *p
+	r.CreatedAt = p.CreatedAt.UTC()
+	s.db.t.profiles[p.ID] = &r
+	s.db.t.inserted("traffic_profiles", p.ID)
+	return nil
+}
+
+func (s *trafficProfileStore) Get(_ context.Context, id string) (*model.TrafficProfile, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.profiles[id]
+	if !ok {
+		return nil, fmt.Errorf("profile %w", store.ErrNotFound)
+	}
+	return profileOut(r), nil
+}
+
+func (s *trafficProfileStore) List(_ context.Context) ([]*model.TrafficProfile, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	byCreated := s.db.t.byCreated("traffic_profiles", true)
+	return list(s.db.t.profiles, nil, profileOut, func(a, b *model.TrafficProfile) int {
+		return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
+
+func (s *trafficProfileStore) Update(_ context.Context, p *model.TrafficProfile) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.profiles[p.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkExternalID(p.ID, p.ExternalID); err != nil {
+		return err
+	}
+	r := *p
+	r.CreatedAt = old.CreatedAt
+	s.db.t.profiles[p.ID] = &r
+	return nil
+}
+
+// ─── Provision Job ────────────────────────────────────────────────────────────
+
+type provisionJobStore struct{ db *db }
+
+// jobOut copies a stored job; the copy's AgentEnv and StepDurations are
+// decoded afresh from their JSON.
+func jobOut(r *model.ProvisionJob) *model.ProvisionJob {
+	j := *r
+	j.Normalize()
+	return &j
+}
+
+func (s *provisionJobStore) Create(_ context.Context, j *model.ProvisionJob) error {
+	j.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.jobs[j.ID]; ok {
+		return conflict("provision job id %q", j.ID)
+	}
+	r := *j
+	r.AgentEnv, r.StepDurations = nil, nil
+	r.CreatedAt, r.UpdatedAt = j.CreatedAt.UTC(), j.UpdatedAt.UTC()
+	s.db.t.jobs[j.ID] = &r
+	s.db.t.inserted("provision_jobs", j.ID)
+	return nil
+}
+
+func (s *provisionJobStore) Get(_ context.Context, id string) (*model.ProvisionJob, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("provision job %w", store.ErrNotFound)
+	}
+	return jobOut(r), nil
+}
+
+func (s *provisionJobStore) List(_ context.Context) ([]*model.ProvisionJob, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	byCreated := s.db.t.byCreated("provision_jobs", true)
+	return list(s.db.t.jobs, nil, jobOut, func(a, b *model.ProvisionJob) int {
+		return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
+
+// update replaces job id, if it exists, with a copy changed by fn and a new
+// updated_at.
+func (s *provisionJobStore) update(id string, fn func(r *model.ProvisionJob)) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.jobs[id]
+	if !ok {
+		return
+	}
+	r := *old
+	fn(&r)
+	r.UpdatedAt = time.Now().UTC()
+	s.db.t.jobs[id] = &r
+}
+
+func (s *provisionJobStore) UpdateStatus(_ context.Context, id string, status model.ProvisionStatus, step string) error {
+	s.update(id, func(r *model.ProvisionJob) { r.Status, r.CurrentStep = status, step })
+	return nil
+}
+
+func (s *provisionJobStore) AppendLog(_ context.Context, id string, line string) error {
+	s.update(id, func(r *model.ProvisionJob) { r.Log += line + "\n" })
+	return nil
+}
+
+func (s *provisionJobStore) SetAgentID(_ context.Context, id string, agentID string) error {
+	s.update(id, func(r *model.ProvisionJob) { r.AgentID = agentID })
+	return nil
+}
+
+func (s *provisionJobStore) SetAgentToken(_ context.Context, id string, token string) error {
+	s.update(id, func(r *model.ProvisionJob) { r.AgentToken = token })
+	return nil
+}
+
+func (s *provisionJobStore) SetFailed(_ context.Context, id string, step string, reason string) error {
+	s.update(id, func(r *model.ProvisionJob) {
+		r.Status, r.FailedStep = model.ProvisionStatusFailed, step
+		r.Log += "[FAIL] " + reason + "\n"
+	})
+	return nil
+}
+
+func (s *provisionJobStore) SetStepDurations(_ context.Context, id string, durations map[string]float64) error {
+	var j model.ProvisionJob
+	j.SetStepDurations(durations)
+	s.update(id, func(r *model.ProvisionJob) { r.StepDurationsJSON = j.StepDurationsJSON })
+	return nil
+}
+
+func (s *provisionJobStore) ResetForRetry(_ context.Context, id string) error {
+	s.update(id, func(r *model.ProvisionJob) {
+		r.Status, r.CurrentStep, r.Log, r.AgentID, r.FailedStep = model.ProvisionStatusPending, "created", "", "", ""
+		r.StepDurationsJSON = "{}"
+	})
+	return nil
+}
+
+func (s *provisionJobStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.jobs, id)
+	s.db.t.deleted("provision_jobs", id)
+	return nil
+}
+
+// ─── Credentials ─────────────────────────────────────────────────────────────
+
+type credentialStore struct{ db *db }
+
+func credentialOut(r *model.Credential) *model.Credential {
+	c := *r
+	return &c
+}
+
+func (s *credentialStore) Create(_ context.Context, c *model.Credential) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.creds[c.ID]; ok {
+		return conflict("credential id %q", c.ID)
+	}
+	r := *c
+	r.CreatedAt = c.CreatedAt.UTC()
+	s.db.t.creds[c.ID] = &r
+	s.db.t.inserted("credentials", c.ID)
+	return nil
+}
+
+func (s *credentialStore) Get(_ context.Context, id string) (*model.Credential, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.creds[id]
+	if !ok {
+		return nil, fmt.Errorf("credential %w", store.ErrNotFound)
+	}
+	return credentialOut(r), nil
+}
+
+func (s *credentialStore) Update(_ context.Context, c *model.Credential) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.creds[c.ID]
+	if !ok {
+		return nil
+	}
+	r := *old
+	r.Name, r.Type, r.Payload = c.Name, c.Type, c.Payload
+	s.db.t.creds[c.ID] = &r
+	return nil
+}
+
+func (s *credentialStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.creds, id)
+	s.db.t.deleted("credentials", id)
+	return nil
+}
+
+func (s *credentialStore) List(_ context.Context) ([]*model.Credential, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	byCreated := s.db.t.byCreated("credentials", true)
+	return list(s.db.t.creds, nil, credentialOut, func(a, b *model.Credential) int {
+		return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
@@ -0,0 +1,237 @@
+// Package memory implements store.Store in process memory. Nothing is
+// persisted: it backs tests and demos (DB_DRIVER=memory), and doubles as a
+// reference for the behaviour the SQL stores share, such as ordering,
+// not-found and conflict errors, and which fields each update touches.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// memStore implements store.Store.
+type memStore struct {
+	db          *db
+	agents      *agentStore
+	tasks       *taskStore
+	metrics     *taskMetricsStore
+	results     *taskResultStore
+	profiles    *trafficProfileStore
+	pools       *urlPoolStore
+	groups      *taskGroupStore
+	agentGroups *agentGroupStore
+	templates   *taskTemplateStore
+	maintenance *maintenanceWindowStore
+	jobs        *provisionJobStore
+	bw          *bandwidthStore
+	creds       *credentialStore
+}
+
+// db guards a set of tables. Rows are never modified in place: writes store
+// a fresh copy, so tables can be cloned shallowly.
+type db struct {
+	mu sync.RWMutex
+	t  *tables
+}
+
+type tables struct {
+	agents       map[string]*model.Agent
+	tasks        map[string]*model.Task
+	metrics      []*model.TaskMetrics
+	lastMetricID int64
+	results      map[string]*model.TaskResult
+	agentResults map[agentResultKey]*model.AgentTaskResult
+	profiles     map[string]*model.TrafficProfile
+	pools        map[string]*model.URLPool
+	groups       map[string]*model.TaskGroup
+	agentGroups  map[string]*model.AgentGroup
+	members      map[membership]struct{}
+	templates    map[string]*model.TaskTemplate
+	maintenance  map[string]*model.MaintenanceWindow
+	jobs         map[string]*model.ProvisionJob
+	samples      []*model.BandwidthSample
+	lastSampleID int64
+	creds        map[string]*model.Credential
+
+	// order records when each row of the tables listed by creation time
+	// was inserted, to order rows created at the same time.
+	order   map[rowKey]int64
+	lastSeq int64
+}
+
+type membership struct{ groupID, agentID string }
+
+type agentResultKey struct{ taskID, agentID string }
+
+type rowKey struct{ table, id string }
+
+func newTables() *tables {
+	return &tables{
+		agents:       map[string]*model.Agent{},
+		tasks:        map[string]*model.Task{},
+		results:      map[string]*model.TaskResult{},
+		agentResults: map[agentResultKey]*model.AgentTaskResult{},
+		profiles:     map[string]*model.TrafficProfile{},
+		pools:        map[string]*model.URLPool{},
+		groups:       map[string]*model.TaskGroup{},
+		agentGroups:  map[string]*model.AgentGroup{},
+		members:      map[membership]struct{}{},
+		templates:    map[string]*model.TaskTemplate{},
+		maintenance:  map[string]*model.MaintenanceWindow{},
+		jobs:         map[string]*model.ProvisionJob{},
+		creds:        map[string]*model.Credential{},
+		order:        map[rowKey]int64{},
+	}
+}
+
+func (t *tables) clone() *tables {
+	c := *t
+	c.agents = maps.Clone(t.agents)
+	c.tasks = maps.Clone(t.tasks)
+	c.metrics = slices.Clone(t.metrics)
+	c.results = maps.Clone(t.results)
+	c.agentResults = maps.Clone(t.agentResults)
+	c.profiles = maps.Clone(t.profiles)
+	c.pools = maps.Clone(t.pools)
+	c.groups = maps.Clone(t.groups)
+	c.agentGroups = maps.Clone(t.agentGroups)
+	c.members = maps.Clone(t.members)
+	c.templates = maps.Clone(t.templates)
+	c.maintenance = maps.Clone(t.maintenance)
+	c.jobs = maps.Clone(t.jobs)
+	c.samples = slices.Clone(t.samples)
+	c.creds = maps.Clone(t.creds)
+	c.order = maps.Clone(t.order)
+	return &c
+}
+
+// New creates an empty in-memory store.
+func New() store.Store {
+	s := &memStore{}
+	s.bind(&db{t: newTables()})
+	return s
+}
+
+// bind points the sub-stores at d.
+func (s *memStore) bind(d *db) {
+	s.db = d
+	s.agents = &agentStore{d}
+	s.tasks = &taskStore{d}
+	s.metrics = &taskMetricsStore{d}
+	s.results = &taskResultStore{d}
+	s.profiles = &trafficProfileStore{d}
+	s.pools = &urlPoolStore{d}
+	s.groups = &taskGroupStore{d}
+	s.agentGroups = &agentGroupStore{d}
+	s.templates = &taskTemplateStore{d}
+	s.maintenance = &maintenanceWindowStore{d}
+	s.jobs = &provisionJobStore{d}
+	s.bw = &bandwidthStore{d}
+	s.creds = &credentialStore{d}
+}
+
+func (s *memStore) Agents() store.AgentStore                         { return s.agents }
+func (s *memStore) Tasks() store.TaskStore                           { return s.tasks }
+func (s *memStore) TaskMetrics() store.TaskMetricsStore              { return s.metrics }
+func (s *memStore) TaskResults() store.TaskResultStore               { return s.results }
+func (s *memStore) TrafficProfiles() store.TrafficProfileStore       { return s.profiles }
+func (s *memStore) URLPools() store.URLPoolStore                     { return s.pools }
+func (s *memStore) TaskGroups() store.TaskGroupStore                 { return s.groups }
+func (s *memStore) AgentGroups() store.AgentGroupStore               { return s.agentGroups }
+func (s *memStore) TaskTemplates() store.TaskTemplateStore           { return s.templates }
+func (s *memStore) MaintenanceWindows() store.MaintenanceWindowStore { return s.maintenance }
+func (s *memStore) ProvisionJobs() store.ProvisionJobStore           { return s.jobs }
+func (s *memStore) Bandwidth() store.BandwidthStore                  { return s.bw }
+func (s *memStore) Credentials() store.CredentialStore               { return s.creds }
+func (s *memStore) Close() error                                     { return nil }
+
+// WithTx runs fn on a copy of the tables and swaps the copy in if fn
+// succeeds. The store is locked meanwhile, so, as with SQLite's single
+// writer, calls through the outer store block until fn returns.
+func (s *memStore) WithTx(ctx context.Context, fn func(tx store.Store) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	ts := &txStore{}
+	ts.bind(&db{t: s.db.t.clone()})
+	if err := fn(ts); err != nil {
+		return err
+	}
+	s.db.t = ts.db.t
+	return nil
+}
+
+// txStore is the store handed to a WithTx callback.
+type txStore struct{ memStore }
+
+// WithTx runs fn in the enclosing transaction.
+func (s *txStore) WithTx(_ context.Context, fn func(tx store.Store) error) error { return fn(s) }
+
+func (s *txStore) Close() error { return errors.New("memory: Close called inside a transaction") }
+
+// ─── Helpers ──────────────────────────────────────────────────────────────────
+
+// conflict reports a unique-constraint violation, like the SQL stores.
+func conflict(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", store.ErrConflict, fmt.Sprintf(format, args...))
+}
+
+// copyTime returns a copy of t in UTC, or nil.
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := t.UTC()
+	return &c
+}
+
+// list returns every row of m accepted by keep (nil keeps all), copied by
+// out and sorted by compare.
+func list[T any](m map[string]*T, keep func(*T) bool, out func(*T) *T, compare func(a, b *T) int) []*T {
+	var l []*T
+	for _, r := range m {
+		if keep == nil || keep(r) {
+			l = append(l, out(r))
+		}
+	}
+	slices.SortFunc(l, compare)
+	return l
+}
+
+// inserted records the insertion of row id into table, unless it exists.
+func (t *tables) inserted(table, id string) {
+	k := rowKey{table, id}
+	if _, ok := t.order[k]; !ok {
+		t.lastSeq++
+		t.order[k] = t.lastSeq
+	}
+}
+
+func (t *tables) deleted(table, id string) { delete(t.order, rowKey{table, id}) }
+
+// byCreated returns a comparison ordering the rows of table by creation
+// time, newest first if desc, and rows created at the same time in
+// insertion order, as the SQL stores' table scans return them.
+func (t *tables) byCreated(table string, desc bool) func(aCreated, bCreated time.Time, aID, bID string) int {
+	return func(aCreated, bCreated time.Time, aID, bID string) int {
+		c := aCreated.Compare(bCreated)
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(t.order[rowKey{table, aID}], t.order[rowKey{table, bID}])
+	}
+}
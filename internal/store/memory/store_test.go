@@ -0,0 +1,13 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/aven/ngoogle/internal/store"
+	"github.com/aven/ngoogle/internal/store/memory"
+	"github.com/aven/ngoogle/internal/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(*testing.T) store.Store { return memory.New() })
+}
@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type taskGroupStore struct{ db *db }
+
+// taskGroupRow copies g into the stored form: pool ids as PoolIDsJSON only,
+// and none of the fields derived from the group's tasks.
+func taskGroupRow(g *model.TaskGroup) *model.TaskGroup {
+	return &model.TaskGroup{
+		ID: g.ID, Name: g.Name, Description: g.Description, PoolIDsJSON: g.PoolIDsJSON,
+		AgentID: g.AgentID, AgentGroupID: g.AgentGroupID, ExecutionScope: g.ExecutionScope, TargetRateMbps: g.TargetRateMbps,
+		StartAt: copyTime(g.StartAt), EndAt: copyTime(g.EndAt), DurationSec: g.DurationSec,
+		TotalBytesTarget: g.TotalBytesTarget, TotalRequestsTarget: g.TotalRequestsTarget,
+		DispatchRateTpm: g.DispatchRateTpm, DispatchBatchSize: g.DispatchBatchSize, Distribution: g.Distribution,
+		JitterPct: g.JitterPct, RampUpSec: g.RampUpSec, RampDownSec: g.RampDownSec,
+		TrafficProfileID: g.TrafficProfileID, Timezone: g.Timezone, ConcurrentFragments: g.ConcurrentFragments,
+		ReuseConnections: g.ReuseConnections, RangeChunkBytes: g.RangeChunkBytes, ReadChunkBytes: g.ReadChunkBytes,
+		BurstBytes: g.BurstBytes, CacheBustParam: g.CacheBustParam, Redirects: g.Redirects, MaxRedirects: g.MaxRedirects, ExpectedBytes: g.ExpectedBytes, ExpectedSHA256: g.ExpectedSHA256, WarmupSec: g.WarmupSec, StaggerSec: g.StaggerSec, Retries: g.Retries, CreatedAt: g.CreatedAt.UTC(), UpdatedAt: g.UpdatedAt.UTC(),
+	}
+}
+
+func taskGroupOut(r *model.TaskGroup) *model.TaskGroup {
+	g := taskGroupRow(r)
+	g.Normalize()
+	return g
+}
+
+func (s *taskGroupStore) Create(_ context.Context, g *model.TaskGroup) error {
+	g.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.groups[g.ID]; ok {
+		return conflict("task group id %q", g.ID)
+	}
+	s.db.t.groups[g.ID] = taskGroupRow(g)
+	s.db.t.inserted("task_groups", g.ID)
+	return nil
+}
+
+func (s *taskGroupStore) Get(_ context.Context, id string) (*model.TaskGroup, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.groups[id]
+	if !ok {
+		return nil, fmt.Errorf("task group %w", store.ErrNotFound)
+	}
+	return taskGroupOut(r), nil
+}
+
+func (s *taskGroupStore) List(_ context.Context) ([]*model.TaskGroup, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	byCreated := s.db.t.byCreated("task_groups", true)
+	return list(s.db.t.groups, nil, taskGroupOut, func(a, b *model.TaskGroup) int {
+		return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
+
+func (s *taskGroupStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.groups, id)
+	s.db.t.deleted("task_groups", id)
+	return nil
+}
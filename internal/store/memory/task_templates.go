@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type taskTemplateStore struct{ db *db }
+
+// templateOut copies a stored template; the copy's Spec is decoded afresh
+// from SpecJSON.
+func templateOut(r *model.TaskTemplate) *model.TaskTemplate {
+	t := *r
+	t.Normalize()
+	return &t
+}
+
+// checkName returns a conflict if another template than id is called name.
+func (s *taskTemplateStore) checkName(id, name string) error {
+	for _, r := range s.db.t.templates {
+		if r.Name == name && r.ID != id {
+			return conflict("task template name %q", name)
+		}
+	}
+	return nil
+}
+
+func (s *taskTemplateStore) Create(_ context.Context, t *model.TaskTemplate) error {
+	t.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.templates[t.ID]; ok {
+		return conflict("task template id %q", t.ID)
+	}
+	if err := s.checkName(t.ID, t.Name); err != nil {
+		return err
+	}
+	r := *t
+	r.Spec = nil
+	r.CreatedAt, r.UpdatedAt = t.CreatedAt.UTC(), t.UpdatedAt.UTC()
+	s.db.t.templates[t.ID] = &r
+	return nil
+}
+
+func (s *taskTemplateStore) Get(_ context.Context, id string) (*model.TaskTemplate, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.templates[id]
+	if !ok {
+		return nil, fmt.Errorf("task template %w", store.ErrNotFound)
+	}
+	return templateOut(r), nil
+}
+
+func (s *taskTemplateStore) List(_ context.Context) ([]*model.TaskTemplate, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return list(s.db.t.templates, nil, templateOut, func(a, b *model.TaskTemplate) int {
+		return strings.Compare(a.Name, b.Name)
+	}), nil
+}
+
+func (s *taskTemplateStore) Update(_ context.Context, t *model.TaskTemplate) error {
+	t.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.templates[t.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkName(t.ID, t.Name); err != nil {
+		return err
+	}
+	r := *old
+	r.Name, r.Description, r.SpecJSON, r.UpdatedAt = t.Name, t.Description, t.SpecJSON, t.UpdatedAt.UTC()
+	s.db.t.templates[t.ID] = &r
+	return nil
+}
+
+func (s *taskTemplateStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.templates, id)
+	return nil
+}
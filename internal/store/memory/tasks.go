@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type taskStore struct{ db *db }
+
+// taskRow copies t into the stored form, which keeps its URLs as
+// TargetURLsJSON only.
+func taskRow(t *model.Task) *model.Task {
+	r := *t
+	r.TargetURLs, r.URLPool = nil, nil
+	r.StartAt, r.EndAt = copyTime(t.StartAt), copyTime(t.EndAt)
+	r.DispatchedAt, r.StartedAt = copyTime(t.DispatchedAt), copyTime(t.StartedAt)
+	r.PausedAt, r.FinishedAt = copyTime(t.PausedAt), copyTime(t.FinishedAt)
+	r.CreatedAt, r.UpdatedAt = t.CreatedAt.UTC(), t.UpdatedAt.UTC()
+	return &r
+}
+
+func taskOut(r *model.Task) *model.Task {
+	t := taskRow(r)
+	t.Normalize()
+	return t
+}
+
+func (s *taskStore) byCreated(desc bool) func(a, b *model.Task) int {
+	byCreated := s.db.t.byCreated("tasks", desc)
+	return func(a, b *model.Task) int { return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID) }
+}
+
+// checkExternalID returns a conflict if another task than id uses
+// externalID.
+func (s *taskStore) checkExternalID(id, externalID string) error {
+	if externalID == "" {
+		return nil
+	}
+	for _, r := range s.db.t.tasks {
+		if r.ExternalID == externalID && r.ID != id {
+			return conflict("task external_id %q", externalID)
+		}
+	}
+	return nil
+}
+
+func (s *taskStore) Create(_ context.Context, t *model.Task) error {
+	t.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.tasks[t.ID]; ok {
+		return conflict("task id %q", t.ID)
+	}
+	if err := s.checkExternalID(t.ID, t.ExternalID); err != nil {
+		return err
+	}
+	s.db.t.tasks[t.ID] = taskRow(t)
+	s.db.t.inserted("tasks", t.ID)
+	return nil
+}
+
+func (s *taskStore) Get(_ context.Context, id string) (*model.Task, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.tasks[id]
+	if !ok {
+		return nil, fmt.Errorf("task %w", store.ErrNotFound)
+	}
+	return taskOut(r), nil
+}
+
+func (s *taskStore) List(_ context.Context) ([]*model.Task, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return list(s.db.t.tasks, nil, taskOut, s.byCreated(true)), nil
+}
+
+func (s *taskStore) ListByGroup(_ context.Context, groupID string) ([]*model.Task, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return list(s.db.t.tasks, func(r *model.Task) bool { return r.GroupID == groupID }, taskOut, s.byCreated(false)), nil
+}
+
+func (s *taskStore) ListByAgent(_ context.Context, agentID string, statuses []model.TaskStatus) ([]*model.Task, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return list(s.db.t.tasks, func(r *model.Task) bool {
+		return r.AgentID == agentID && slices.Contains(statuses, r.Status)
+	}, taskOut, s.byCreated(false)), nil
+}
+
+func (s *taskStore) Update(_ context.Context, t *model.Task) error {
+	t.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.tasks[t.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkExternalID(t.ID, t.ExternalID); err != nil {
+		return err
+	}
+	r := taskRow(t)
+	// Runtime state and the group link are not part of the configuration.
+	r.GroupID, r.Status, r.TotalBytesDone, r.PausedSec, r.ErrorMessage = old.GroupID, old.Status, old.TotalBytesDone, old.PausedSec, old.ErrorMessage
+	r.DispatchedAt, r.StartedAt, r.PausedAt, r.FinishedAt = old.DispatchedAt, old.StartedAt, old.PausedAt, old.FinishedAt
+	r.CreatedAt = old.CreatedAt
+	s.db.t.tasks[t.ID] = r
+	return nil
+}
+
+// update replaces task id, if it exists, with a copy changed by fn and a new
+// updated_at.
+func (s *taskStore) update(id string, fn func(r *model.Task)) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.tasks[id]
+	if !ok {
+		return
+	}
+	r := *old
+	fn(&r)
+	r.UpdatedAt = time.Now().UTC()
+	s.db.t.tasks[id] = &r
+}
+
+func (s *taskStore) UpdateStatus(_ context.Context, id string, status model.TaskStatus) error {
+	s.update(id, func(r *model.Task) { r.Status = status })
+	return nil
+}
+
+func (s *taskStore) UpdateStatusWithTime(_ context.Context, id string, status model.TaskStatus, ts time.Time, field string) error {
+	var set func(r *model.Task, ts *time.Time)
+	switch field {
+	case "dispatched_at":
+		set = func(r *model.Task, ts *time.Time) { r.DispatchedAt = ts }
+	case "started_at":
+		set = func(r *model.Task, ts *time.Time) { r.StartedAt = ts }
+	case "paused_at":
+		set = func(r *model.Task, ts *time.Time) { r.PausedAt = ts }
+	case "finished_at":
+		set = func(r *model.Task, ts *time.Time) { r.FinishedAt = ts }
+	default:
+		return fmt.Errorf("memory: unknown task time field %q", field)
+	}
+	s.update(id, func(r *model.Task) {
+		r.Status = status
+		set(r, copyTime(&ts))
+	})
+	return nil
+}
+
+func (s *taskStore) UpdatePause(_ context.Context, id string, status model.TaskStatus, pausedAt *time.Time, pausedSec int) error {
+	s.update(id, func(r *model.Task) {
+		r.Status, r.PausedAt, r.PausedSec = status, copyTime(pausedAt), pausedSec
+	})
+	return nil
+}
+
+func (s *taskStore) UpdateBytes(_ context.Context, id string, bytesTotal int64) error {
+	s.update(id, func(r *model.Task) { r.TotalBytesDone = bytesTotal })
+	return nil
+}
+
+func (s *taskStore) SetError(_ context.Context, id string, msg string) error {
+	s.update(id, func(r *model.Task) { r.ErrorMessage = msg })
+	return nil
+}
+
+func (s *taskStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.tasks, id)
+	s.db.t.deleted("tasks", id)
+	return nil
+}
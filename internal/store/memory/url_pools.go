@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type urlPoolStore struct{ db *db }
+
+// urlPoolOut copies a stored pool; the copy's URLs are decoded afresh from
+// URLsJSON.
+func urlPoolOut(r *model.URLPool) *model.URLPool {
+	p := *r
+	p.Normalize()
+	return &p
+}
+
+func (s *urlPoolStore) Create(_ context.Context, p *model.URLPool) error {
+	p.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if _, ok := s.db.t.pools[p.ID]; ok {
+		return conflict("url pool id %q", p.ID)
+	}
+	r := *p
+	r.URLs = nil
+	r.CreatedAt, r.UpdatedAt = p.CreatedAt.UTC(), p.UpdatedAt.UTC()
+	s.db.t.pools[p.ID] = &r
+	s.db.t.inserted("url_pools", p.ID)
+	return nil
+}
+
+func (s *urlPoolStore) Get(_ context.Context, id string) (*model.URLPool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	r, ok := s.db.t.pools[id]
+	if !ok {
+		return nil, fmt.Errorf("url pool %w", store.ErrNotFound)
+	}
+	return urlPoolOut(r), nil
+}
+
+func (s *urlPoolStore) List(_ context.Context) ([]*model.URLPool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	byCreated := s.db.t.byCreated("url_pools", true)
+	return list(s.db.t.pools, nil, urlPoolOut, func(a, b *model.URLPool) int {
+		return byCreated(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	}), nil
+}
+
+func (s *urlPoolStore) Update(_ context.Context, p *model.URLPool) error {
+	p.Normalize()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	old, ok := s.db.t.pools[p.ID]
+	if !ok {
+		return nil
+	}
+	r := *old
+	r.Name, r.Type, r.Description, r.URLsJSON, r.UpdatedAt = p.Name, p.Type, p.Description, p.URLsJSON, p.UpdatedAt.UTC()
+	s.db.t.pools[p.ID] = &r
+	return nil
+}
+
+func (s *urlPoolStore) Delete(_ context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	delete(s.db.t.pools, id)
+	s.db.t.deleted("url_pools", id)
+	return nil
+}
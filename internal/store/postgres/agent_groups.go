@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type agentGroupStore struct{ db dbtx }
+
+func (s *agentGroupStore) Create(ctx context.Context, g *model.AgentGroup) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO agent_groups (id,name,description,created_at,updated_at) VALUES ($1,$2,$3,$4,$5)`,
+		g.ID, g.Name, g.Description, g.CreatedAt.UTC(), g.UpdatedAt.UTC(),
+	)
+	return mapConflict(err)
+}
+
+func (s *agentGroupStore) Get(ctx context.Context, id string) (*model.AgentGroup, error) {
+	g := &model.AgentGroup{}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id,name,description,created_at,updated_at FROM agent_groups WHERE id=$1`, id,
+	).Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt, &g.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("agent group %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.members(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	g.AgentIDs = members[id]
+	return g, nil
+}
+
+func (s *agentGroupStore) List(ctx context.Context) ([]*model.AgentGroup, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id,name,description,created_at,updated_at FROM agent_groups ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*model.AgentGroup
+	for rows.Next() {
+		g := &model.AgentGroup{}
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt, &g.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	members, err := s.members(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range list {
+		g.AgentIDs = members[g.ID]
+	}
+	return list, nil
+}
+
+// members maps group ids to their agent ids, for one group or, when groupID
+// is empty, for all of them.
+func (s *agentGroupStore) members(ctx context.Context, groupID string) (map[string][]string, error) {
+	query, args := `SELECT group_id,agent_id FROM agent_group_members`, []any{}
+	if groupID != "" {
+		query, args = query+` WHERE group_id=$1`, append(args, groupID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY agent_id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]string{}
+	for rows.Next() {
+		var gid, aid string
+		if err := rows.Scan(&gid, &aid); err != nil {
+			return nil, err
+		}
+		out[gid] = append(out[gid], aid)
+	}
+	return out, rows.Err()
+}
+
+func (s *agentGroupStore) Delete(ctx context.Context, id string) error {
+	return inTx(ctx, s.db, func(db dbtx) error {
+		if _, err := db.ExecContext(ctx, `DELETE FROM agent_group_members WHERE group_id=$1`, id); err != nil {
+			return err
+		}
+		_, err := db.ExecContext(ctx, `DELETE FROM agent_groups WHERE id=$1`, id)
+		return err
+	})
+}
+
+func (s *agentGroupStore) AddMembers(ctx context.Context, groupID string, agentIDs []string) error {
+	for _, agentID := range agentIDs {
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO agent_group_members (group_id,agent_id) VALUES ($1,$2)
+			ON CONFLICT(group_id,agent_id) DO NOTHING`, groupID, agentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *agentGroupStore) RemoveMember(ctx context.Context, groupID, agentID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM agent_group_members WHERE group_id=$1 AND agent_id=$2`, groupID, agentID)
+	return err
+}
+
+func (s *agentGroupStore) GroupsOf(ctx context.Context, agentID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT group_id FROM agent_group_members WHERE agent_id=$1 ORDER BY group_id ASC`, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -4,37 +4,42 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
-type agentStore struct{ db *sql.DB }
+type agentStore struct{ db dbtx }
 
 func (s *agentStore) Upsert(ctx context.Context, a *model.Agent) error {
+	a.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO agents (id, hostname, ip, port, token, status, version, current_rate_mbps, last_heartbeat, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		INSERT INTO agents (id, hostname, machine_id, ip, port, token, status, version, commit_hash, tags_json, current_rate_mbps, task_rate_mbps, tx_rate_mbps, rate_measured, capacity_mbps, last_heartbeat, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18)
 		ON CONFLICT(id) DO UPDATE SET
-			hostname=excluded.hostname, ip=excluded.ip, port=excluded.port,
-			token=excluded.token, status=excluded.status, version=excluded.version,
-			current_rate_mbps=excluded.current_rate_mbps,
+			hostname=excluded.hostname, machine_id=excluded.machine_id, ip=excluded.ip, port=excluded.port,
+			token=excluded.token, status=excluded.status, version=excluded.version, commit_hash=excluded.commit_hash,
+			tags_json=excluded.tags_json,
+			current_rate_mbps=excluded.current_rate_mbps, task_rate_mbps=excluded.task_rate_mbps,
+			tx_rate_mbps=excluded.tx_rate_mbps, rate_measured=excluded.rate_measured, capacity_mbps=excluded.capacity_mbps,
 			last_heartbeat=excluded.last_heartbeat, updated_at=excluded.updated_at`,
-		a.ID, a.Hostname, a.IP, a.Port, a.Token, a.Status, a.Version,
-		a.CurrentRateMbps, a.LastHeartbeat.UTC(), a.CreatedAt.UTC(), a.UpdatedAt.UTC(),
+		a.ID, a.Hostname, a.MachineID, a.IP, a.Port, a.Token, a.Status, a.Version, a.Commit, a.TagsJSON,
+		a.CurrentRateMbps, a.TaskRateMbps, a.TxRateMbps, a.RateMeasured, a.CapacityMbps, a.LastHeartbeat.UTC(), a.CreatedAt.UTC(), a.UpdatedAt.UTC(),
 	)
 	return err
 }
 
 func (s *agentStore) Get(ctx context.Context, id string) (*model.Agent, error) {
 	row := s.db.QueryRowContext(ctx,
-		`SELECT id,hostname,ip,port,token,status,version,current_rate_mbps,last_heartbeat,created_at,updated_at FROM agents WHERE id=$1`, id)
+		`SELECT id,hostname,machine_id,ip,port,token,status,version,commit_hash,tags_json,current_rate_mbps,task_rate_mbps,tx_rate_mbps,rate_measured,capacity_mbps,enabled,last_heartbeat,created_at,updated_at FROM agents WHERE id=$1`, id)
 	return scanAgent(row)
 }
 
 func (s *agentStore) List(ctx context.Context) ([]*model.Agent, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id,hostname,ip,port,token,status,version,current_rate_mbps,last_heartbeat,created_at,updated_at FROM agents ORDER BY created_at DESC`)
+		`SELECT id,hostname,machine_id,ip,port,token,status,version,commit_hash,tags_json,current_rate_mbps,task_rate_mbps,tx_rate_mbps,rate_measured,capacity_mbps,enabled,last_heartbeat,created_at,updated_at FROM agents ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +55,53 @@ func (s *agentStore) List(ctx context.Context) ([]*model.Agent, error) {
 	return list, rows.Err()
 }
 
+func (s *agentStore) ListFiltered(ctx context.Context, f store.AgentFilter) ([]*model.Agent, int, error) {
+	var where []string
+	var args []any
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, fmt.Sprintf("status=$%d", len(args)))
+	}
+	if f.Tag != "" {
+		args = append(args, f.Tag)
+		where = append(where, fmt.Sprintf("tags_json::jsonb ? $%d", len(args)))
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM agents`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id,hostname,machine_id,ip,port,token,status,version,commit_hash,tags_json,current_rate_mbps,task_rate_mbps,tx_rate_mbps,rate_measured,capacity_mbps,enabled,last_heartbeat,created_at,updated_at FROM agents` +
+		cond + ` ORDER BY created_at DESC`
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		query += fmt.Sprintf(` OFFSET $%d`, len(args))
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var list []*model.Agent
+	for rows.Next() {
+		a, err := scanAgent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, a)
+	}
+	return list, total, rows.Err()
+}
+
 func (s *agentStore) UpdateStatus(ctx context.Context, id string, status model.AgentStatus, heartbeat time.Time) error {
 	_, err := s.db.ExecContext(ctx,
 		`UPDATE agents SET status=$1, last_heartbeat=$2, updated_at=$3 WHERE id=$4`,
@@ -57,25 +109,40 @@ func (s *agentStore) UpdateStatus(ctx context.Context, id string, status model.A
 	return err
 }
 
-func (s *agentStore) UpdateRate(ctx context.Context, id string, rateMbps float64) error {
+func (s *agentStore) UpdateRate(ctx context.Context, id string, r model.AgentRates) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE agents SET current_rate_mbps=$1, task_rate_mbps=$2, tx_rate_mbps=$3, rate_measured=$4, updated_at=$5 WHERE id=$6`,
+		r.RateMbps, r.TaskRateMbps, r.TxRateMbps, r.Measured, time.Now().UTC(), id)
+	return err
+}
+
+func (s *agentStore) SetEnabled(ctx context.Context, id string, enabled bool) error {
 	_, err := s.db.ExecContext(ctx,
-		`UPDATE agents SET current_rate_mbps=$1, updated_at=$2 WHERE id=$3`,
-		rateMbps, time.Now().UTC(), id)
+		`UPDATE agents SET enabled=$1, updated_at=$2 WHERE id=$3`,
+		enabled, time.Now().UTC(), id)
 	return err
 }
 
 func (s *agentStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM agent_group_members WHERE agent_id=$1`, id); err != nil {
+		return err
+	}
 	_, err := s.db.ExecContext(ctx, `DELETE FROM agents WHERE id=$1`, id)
 	return err
 }
 
 func scanAgent(row scanner) (*model.Agent, error) {
 	a := &model.Agent{}
-	err := row.Scan(&a.ID, &a.Hostname, &a.IP, &a.Port, &a.Token,
-		&a.Status, &a.Version, &a.CurrentRateMbps,
+	err := row.Scan(&a.ID, &a.Hostname, &a.MachineID, &a.IP, &a.Port, &a.Token,
+		&a.Status, &a.Version, &a.Commit, &a.TagsJSON, &a.CurrentRateMbps,
+		&a.TaskRateMbps, &a.TxRateMbps, &a.RateMeasured, &a.CapacityMbps, &a.Enabled,
 		&a.LastHeartbeat, &a.CreatedAt, &a.UpdatedAt)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("agent not found")
+		return nil, fmt.Errorf("agent %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return a, err
+	a.Normalize()
+	return a, nil
 }
@@ -10,21 +10,22 @@ import (
 	"github.com/aven/ngoogle/internal/store"
 )
 
-type taskMetricsStore struct{ db *sql.DB }
+type taskMetricsStore struct{ db dbtx }
 
 func (s *taskMetricsStore) Insert(ctx context.Context, m *model.TaskMetrics) error {
+	m.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO task_metrics (task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,recorded_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		INSERT INTO task_metrics (task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,content_error_count,latency_json,warmup,recorded_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`,
 		m.TaskID, m.AgentID, m.BytesTotal, m.BytesDelta,
-		m.RateMbps5s, m.RateMbps30s, m.RequestCount, m.ErrorCount, m.RecordedAt.UTC(),
+		m.RateMbps5s, m.RateMbps30s, m.RequestCount, m.ErrorCount, m.ContentErrorCount, m.LatencyJSON, m.Warmup, m.RecordedAt.UTC(),
 	)
 	return err
 }
 
 func (s *taskMetricsStore) ListByTask(ctx context.Context, taskID string, from, to time.Time) ([]*model.TaskMetrics, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,recorded_at
+		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,content_error_count,latency_json,warmup,recorded_at
 		FROM task_metrics WHERE task_id=$1 AND recorded_at BETWEEN $2 AND $3 ORDER BY recorded_at ASC`,
 		taskID, from.UTC(), to.UTC())
 	if err != nil {
@@ -35,9 +36,10 @@ func (s *taskMetricsStore) ListByTask(ctx context.Context, taskID string, from,
 	for rows.Next() {
 		m := &model.TaskMetrics{}
 		if err := rows.Scan(&m.ID, &m.TaskID, &m.AgentID, &m.BytesTotal, &m.BytesDelta,
-			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.RecordedAt); err != nil {
+			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.ContentErrorCount, &m.LatencyJSON, &m.Warmup, &m.RecordedAt); err != nil {
 			return nil, err
 		}
+		m.Normalize()
 		list = append(list, m)
 	}
 	return list, rows.Err()
@@ -45,24 +47,35 @@ func (s *taskMetricsStore) ListByTask(ctx context.Context, taskID string, from,
 
 func (s *taskMetricsStore) LatestByTask(ctx context.Context, taskID string) (*model.TaskMetrics, error) {
 	row := s.db.QueryRowContext(ctx, `
-		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,recorded_at
-		FROM task_metrics WHERE task_id=$1 ORDER BY recorded_at DESC LIMIT 1`, taskID)
+		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,content_error_count,latency_json,warmup,recorded_at
+		FROM task_metrics WHERE task_id=$1 ORDER BY recorded_at DESC, id DESC LIMIT 1`, taskID)
 	m := &model.TaskMetrics{}
 	err := row.Scan(&m.ID, &m.TaskID, &m.AgentID, &m.BytesTotal, &m.BytesDelta,
-		&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.RecordedAt)
+		&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.ContentErrorCount, &m.LatencyJSON, &m.Warmup, &m.RecordedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	m.Normalize()
 	return m, err
 }
 
 func (s *taskMetricsStore) LatestByTaskAgents(ctx context.Context, taskID string) ([]*model.TaskMetrics, error) {
+	return s.latestPerAgent(ctx, taskID, "")
+}
+
+func (s *taskMetricsStore) LatestWarmupByTaskAgents(ctx context.Context, taskID string) ([]*model.TaskMetrics, error) {
+	return s.latestPerAgent(ctx, taskID, " AND tm.warmup")
+}
+
+// latestPerAgent returns each agent's newest sample for taskID among the rows
+// matching the extra WHERE condition.
+func (s *taskMetricsStore) latestPerAgent(ctx context.Context, taskID, cond string) ([]*model.TaskMetrics, error) {
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT DISTINCT ON (tm.agent_id)
-			tm.id,tm.task_id,tm.agent_id,tm.bytes_total,tm.bytes_delta,tm.rate_mbps_5s,tm.rate_mbps_30s,tm.request_count,tm.error_count,tm.recorded_at
+			tm.id,tm.task_id,tm.agent_id,tm.bytes_total,tm.bytes_delta,tm.rate_mbps_5s,tm.rate_mbps_30s,tm.request_count,tm.error_count,tm.content_error_count,tm.latency_json,tm.warmup,tm.recorded_at
 		FROM task_metrics tm
-		WHERE tm.task_id=$1
-		ORDER BY tm.agent_id, tm.recorded_at DESC`, taskID)
+		WHERE tm.task_id=$1`+cond+`
+		ORDER BY tm.agent_id, tm.recorded_at DESC, tm.id DESC`, taskID)
 	if err != nil {
 		return nil, err
 	}
@@ -72,17 +85,89 @@ func (s *taskMetricsStore) LatestByTaskAgents(ctx context.Context, taskID string
 	for rows.Next() {
 		m := &model.TaskMetrics{}
 		if err := rows.Scan(&m.ID, &m.TaskID, &m.AgentID, &m.BytesTotal, &m.BytesDelta,
-			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.RecordedAt); err != nil {
+			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.ContentErrorCount, &m.LatencyJSON, &m.Warmup, &m.RecordedAt); err != nil {
 			return nil, err
 		}
+		m.Normalize()
 		list = append(list, m)
 	}
 	return list, rows.Err()
 }
 
+// ─── Task results ─────────────────────────────────────────────────────────────
+
+type taskResultStore struct{ db dbtx }
+
+func (s *taskResultStore) Upsert(ctx context.Context, r *model.TaskResult) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO task_results (task_id,status,end_reason,bytes_total,request_count,error_count,avg_rate_mbps,p95_rate_mbps,duration_sec,started_at,finished_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT(task_id) DO UPDATE SET
+			status=excluded.status, end_reason=excluded.end_reason, bytes_total=excluded.bytes_total,
+			request_count=excluded.request_count, error_count=excluded.error_count,
+			avg_rate_mbps=excluded.avg_rate_mbps, p95_rate_mbps=excluded.p95_rate_mbps,
+			duration_sec=excluded.duration_sec, started_at=excluded.started_at, finished_at=excluded.finished_at`,
+		r.TaskID, r.Status, r.EndReason, r.BytesTotal, r.RequestCount, r.ErrorCount,
+		r.AvgRateMbps, r.P95RateMbps, r.DurationSec, nullTime(r.StartedAt), r.FinishedAt.UTC(),
+	)
+	return err
+}
+
+func (s *taskResultStore) Get(ctx context.Context, taskID string) (*model.TaskResult, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT task_id,status,end_reason,bytes_total,request_count,error_count,avg_rate_mbps,p95_rate_mbps,duration_sec,started_at,finished_at
+		FROM task_results WHERE task_id=$1`, taskID)
+	r := &model.TaskResult{}
+	var startedAt sql.NullTime
+	err := row.Scan(&r.TaskID, &r.Status, &r.EndReason, &r.BytesTotal, &r.RequestCount, &r.ErrorCount,
+		&r.AvgRateMbps, &r.P95RateMbps, &r.DurationSec, &startedAt, &r.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("task result %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.StartedAt = scanNullTime(startedAt)
+	return r, nil
+}
+
+func (s *taskResultStore) UpsertAgent(ctx context.Context, r *model.AgentTaskResult) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO task_agent_results (task_id,agent_id,stop_reason,error,bytes_total,request_count,error_count,duration_sec,reported_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		ON CONFLICT(task_id,agent_id) DO UPDATE SET
+			stop_reason=excluded.stop_reason, error=excluded.error, bytes_total=excluded.bytes_total,
+			request_count=excluded.request_count, error_count=excluded.error_count,
+			duration_sec=excluded.duration_sec, reported_at=excluded.reported_at`,
+		r.TaskID, r.AgentID, r.StopReason, r.Error, r.BytesTotal, r.RequestCount, r.ErrorCount,
+		r.DurationSec, r.ReportedAt.UTC(),
+	)
+	return err
+}
+
+func (s *taskResultStore) ListAgents(ctx context.Context, taskID string) ([]*model.AgentTaskResult, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT task_id,agent_id,stop_reason,error,bytes_total,request_count,error_count,duration_sec,reported_at
+		FROM task_agent_results WHERE task_id=$1 ORDER BY agent_id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*model.AgentTaskResult
+	for rows.Next() {
+		r := &model.AgentTaskResult{}
+		if err := rows.Scan(&r.TaskID, &r.AgentID, &r.StopReason, &r.Error, &r.BytesTotal, &r.RequestCount, &r.ErrorCount,
+			&r.DurationSec, &r.ReportedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
 // ─── Bandwidth ────────────────────────────────────────────────────────────────
 
-type bandwidthStore struct{ db *sql.DB }
+type bandwidthStore struct{ db dbtx }
 
 func (s *bandwidthStore) Insert(ctx context.Context, bs *model.BandwidthSample) error {
 	unix := bs.RecordedAt.Unix()
@@ -119,24 +204,52 @@ func (s *bandwidthStore) History(ctx context.Context, agentID string, from, to t
 	return list, rows.Err()
 }
 
-func (s *bandwidthStore) AggregateHistory(ctx context.Context, from, to time.Time, stepSec int) ([]store.BandwidthPoint, error) {
+func (s *bandwidthStore) AggregateHistory(ctx context.Context, from, to time.Time, stepSec int, agg store.BandwidthAgg) ([]store.BandwidthPoint, error) {
+	fn := agg.SQL()
+	if fn == "" {
+		return nil, fmt.Errorf("unknown bandwidth aggregation %q", agg)
+	}
 	// Two-level aggregation: first AVG per agent per bucket, then SUM across agents.
 	// This gives the correct total bandwidth (not inflated by multiple heartbeats per agent).
 	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
-		SELECT bucket, SUM(agent_avg), MAX(agent_avg)
+		SELECT bucket, SUM(agent_avg), MAX(agent_avg), %s(agent_avg)
 		FROM (
 			SELECT (ts / %d) * %d as bucket, agent_id, AVG(rate_mbps) as agent_avg
 			FROM bandwidth_samples
 			WHERE ts BETWEEN $1 AND $2
 			GROUP BY bucket, agent_id
 		) sub
-		GROUP BY bucket ORDER BY bucket ASC`, stepSec, stepSec),
+		GROUP BY bucket ORDER BY bucket ASC`, fn, stepSec, stepSec),
 		from.Unix(), to.Unix())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var result []store.BandwidthPoint
+	for rows.Next() {
+		var p store.BandwidthPoint
+		var bucketUnix int64
+		if err := rows.Scan(&bucketUnix, &p.AvgMbps, &p.MaxMbps, &p.Mbps); err != nil {
+			return nil, err
+		}
+		p.Ts = time.Unix(bucketUnix, 0).UTC()
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func (s *bandwidthStore) AgentHistory(ctx context.Context, agentID string, from, to time.Time, stepSec int) ([]store.BandwidthPoint, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT (ts / %d) * %d as bucket, AVG(rate_mbps), MAX(rate_mbps)
+		FROM bandwidth_samples
+		WHERE agent_id = $1 AND ts BETWEEN $2 AND $3
+		GROUP BY bucket ORDER BY bucket ASC`, stepSec, stepSec),
+		agentID, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []store.BandwidthPoint
 	for rows.Next() {
 		var p store.BandwidthPoint
 		var bucketUnix int64
@@ -144,6 +257,7 @@ func (s *bandwidthStore) AggregateHistory(ctx context.Context, from, to time.Tim
 			return nil, err
 		}
 		p.Ts = time.Unix(bucketUnix, 0).UTC()
+		p.Mbps = p.AvgMbps
 		result = append(result, p)
 	}
 	return result, rows.Err()
@@ -7,31 +7,32 @@ import (
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
 // ─── Traffic Profile ──────────────────────────────────────────────────────────
 
-type trafficProfileStore struct{ db *sql.DB }
+type trafficProfileStore struct{ db dbtx }
 
 func (s *trafficProfileStore) Create(ctx context.Context, p *model.TrafficProfile) error {
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO traffic_profiles(id,name,description,distribution,points,created_at) VALUES($1,$2,$3,$4,$5,$6)`,
-		p.ID, p.Name, p.Description, p.Distribution, p.Points, p.CreatedAt.UTC())
-	return err
+		`INSERT INTO traffic_profiles(id,name,external_id,description,distribution,points,timezone,created_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8)`,
+		p.ID, p.Name, p.ExternalID, p.Description, p.Distribution, p.Points, p.Timezone, p.CreatedAt.UTC())
+	return mapConflict(err)
 }
 
 func (s *trafficProfileStore) Get(ctx context.Context, id string) (*model.TrafficProfile, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id,name,description,distribution,points,created_at FROM traffic_profiles WHERE id=$1`, id)
+	row := s.db.QueryRowContext(ctx, `SELECT id,name,external_id,description,distribution,points,timezone,created_at FROM traffic_profiles WHERE id=$1`, id)
 	p := &model.TrafficProfile{}
-	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Distribution, &p.Points, &p.CreatedAt)
+	err := row.Scan(&p.ID, &p.Name, &p.ExternalID, &p.Description, &p.Distribution, &p.Points, &p.Timezone, &p.CreatedAt)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("profile not found")
+		return nil, fmt.Errorf("profile %w", store.ErrNotFound)
 	}
 	return p, err
 }
 
 func (s *trafficProfileStore) List(ctx context.Context) ([]*model.TrafficProfile, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id,name,description,distribution,points,created_at FROM traffic_profiles ORDER BY created_at DESC`)
+	rows, err := s.db.QueryContext(ctx, `SELECT id,name,external_id,description,distribution,points,timezone,created_at FROM traffic_profiles ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +40,7 @@ func (s *trafficProfileStore) List(ctx context.Context) ([]*model.TrafficProfile
 	var list []*model.TrafficProfile
 	for rows.Next() {
 		p := &model.TrafficProfile{}
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Distribution, &p.Points, &p.CreatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.ExternalID, &p.Description, &p.Distribution, &p.Points, &p.Timezone, &p.CreatedAt); err != nil {
 			return nil, err
 		}
 		list = append(list, p)
@@ -47,30 +48,38 @@ func (s *trafficProfileStore) List(ctx context.Context) ([]*model.TrafficProfile
 	return list, rows.Err()
 }
 
+func (s *trafficProfileStore) Update(ctx context.Context, p *model.TrafficProfile) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE traffic_profiles SET name=$1,external_id=$2,description=$3,distribution=$4,points=$5,timezone=$6 WHERE id=$7`,
+		p.Name, p.ExternalID, p.Description, p.Distribution, p.Points, p.Timezone, p.ID)
+	return mapConflict(err)
+}
+
 // ─── Provision Job ────────────────────────────────────────────────────────────
 
-type provisionJobStore struct{ db *sql.DB }
+type provisionJobStore struct{ db dbtx }
 
 func (s *provisionJobStore) Create(ctx context.Context, j *model.ProvisionJob) error {
+	j.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO provision_jobs(id,host_ip,ssh_port,ssh_user,auth_type,credential_ref,status,current_step,log,agent_id,failed_step,created_at,updated_at)
-		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)`,
-		j.ID, j.HostIP, j.SSHPort, j.SSHUser, j.AuthType, j.CredentialRef,
-		j.Status, j.CurrentStep, j.Log, j.AgentID, j.FailedStep,
+		INSERT INTO provision_jobs(id,host_ip,ssh_port,ssh_user,auth_type,credential_ref,sudo_credential_ref,install_dir,service_name,agent_env_json,download_url,agent_token,status,current_step,log,agent_id,failed_step,step_durations,created_at,updated_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20)`,
+		j.ID, j.HostIP, j.SSHPort, j.SSHUser, j.AuthType, j.CredentialRef, j.SudoCredentialRef, j.InstallDir, j.ServiceName, j.AgentEnvJSON, j.DownloadURL, j.AgentToken,
+		j.Status, j.CurrentStep, j.Log, j.AgentID, j.FailedStep, j.StepDurationsJSON,
 		j.CreatedAt.UTC(), j.UpdatedAt.UTC())
-	return err
+	return mapConflict(err)
 }
 
 func (s *provisionJobStore) Get(ctx context.Context, id string) (*model.ProvisionJob, error) {
 	row := s.db.QueryRowContext(ctx,
-		`SELECT id,host_ip,ssh_port,ssh_user,auth_type,credential_ref,status,current_step,log,agent_id,failed_step,created_at,updated_at
+		`SELECT id,host_ip,ssh_port,ssh_user,auth_type,credential_ref,sudo_credential_ref,install_dir,service_name,agent_env_json,download_url,agent_token,status,current_step,log,agent_id,failed_step,step_durations,created_at,updated_at
 		 FROM provision_jobs WHERE id=$1`, id)
 	return scanProvisionJob(row)
 }
 
 func (s *provisionJobStore) List(ctx context.Context) ([]*model.ProvisionJob, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id,host_ip,ssh_port,ssh_user,auth_type,credential_ref,status,current_step,log,agent_id,failed_step,created_at,updated_at
+		`SELECT id,host_ip,ssh_port,ssh_user,auth_type,credential_ref,sudo_credential_ref,install_dir,service_name,agent_env_json,download_url,agent_token,status,current_step,log,agent_id,failed_step,step_durations,created_at,updated_at
 		 FROM provision_jobs ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
@@ -106,6 +115,11 @@ func (s *provisionJobStore) SetAgentID(ctx context.Context, id string, agentID s
 	return err
 }
 
+func (s *provisionJobStore) SetAgentToken(ctx context.Context, id string, token string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE provision_jobs SET agent_token=$1,updated_at=$2 WHERE id=$3`, token, time.Now().UTC(), id)
+	return err
+}
+
 func (s *provisionJobStore) SetFailed(ctx context.Context, id string, step string, reason string) error {
 	_, err := s.db.ExecContext(ctx,
 		`UPDATE provision_jobs SET status='failed',failed_step=$1,log=log||$2||chr(10),updated_at=$3 WHERE id=$4`,
@@ -113,9 +127,18 @@ func (s *provisionJobStore) SetFailed(ctx context.Context, id string, step strin
 	return err
 }
 
+func (s *provisionJobStore) SetStepDurations(ctx context.Context, id string, durations map[string]float64) error {
+	j := model.ProvisionJob{}
+	j.SetStepDurations(durations)
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE provision_jobs SET step_durations=$1,updated_at=$2 WHERE id=$3`,
+		j.StepDurationsJSON, time.Now().UTC(), id)
+	return err
+}
+
 func (s *provisionJobStore) ResetForRetry(ctx context.Context, id string) error {
 	_, err := s.db.ExecContext(ctx,
-		`UPDATE provision_jobs SET status=$1,current_step='created',log='',agent_id='',failed_step='',updated_at=$2 WHERE id=$3`,
+		`UPDATE provision_jobs SET status=$1,current_step='created',log='',agent_id='',failed_step='',step_durations='{}',updated_at=$2 WHERE id=$3`,
 		model.ProvisionStatusPending, time.Now().UTC(), id)
 	return err
 }
@@ -127,22 +150,26 @@ func (s *provisionJobStore) Delete(ctx context.Context, id string) error {
 
 func scanProvisionJob(row scanner) (*model.ProvisionJob, error) {
 	j := &model.ProvisionJob{}
-	err := row.Scan(&j.ID, &j.HostIP, &j.SSHPort, &j.SSHUser, &j.AuthType, &j.CredentialRef,
-		&j.Status, &j.CurrentStep, &j.Log, &j.AgentID, &j.FailedStep, &j.CreatedAt, &j.UpdatedAt)
+	err := row.Scan(&j.ID, &j.HostIP, &j.SSHPort, &j.SSHUser, &j.AuthType, &j.CredentialRef, &j.SudoCredentialRef, &j.InstallDir, &j.ServiceName, &j.AgentEnvJSON, &j.DownloadURL, &j.AgentToken,
+		&j.Status, &j.CurrentStep, &j.Log, &j.AgentID, &j.FailedStep, &j.StepDurationsJSON, &j.CreatedAt, &j.UpdatedAt)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("provision job not found")
+		return nil, fmt.Errorf("provision job %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return j, err
+	j.Normalize()
+	return j, nil
 }
 
 // ─── Credentials ─────────────────────────────────────────────────────────────
 
-type credentialStore struct{ db *sql.DB }
+type credentialStore struct{ db dbtx }
 
 func (s *credentialStore) Create(ctx context.Context, c *model.Credential) error {
 	_, err := s.db.ExecContext(ctx, `INSERT INTO credentials(id,name,type,payload,created_at) VALUES($1,$2,$3,$4,$5)`,
 		c.ID, c.Name, c.Type, c.Payload, c.CreatedAt.UTC())
-	return err
+	return mapConflict(err)
 }
 
 func (s *credentialStore) Get(ctx context.Context, id string) (*model.Credential, error) {
@@ -150,11 +177,17 @@ func (s *credentialStore) Get(ctx context.Context, id string) (*model.Credential
 	c := &model.Credential{}
 	err := row.Scan(&c.ID, &c.Name, &c.Type, &c.Payload, &c.CreatedAt)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("credential not found")
+		return nil, fmt.Errorf("credential %w", store.ErrNotFound)
 	}
 	return c, err
 }
 
+func (s *credentialStore) Update(ctx context.Context, c *model.Credential) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE credentials SET name=$1,type=$2,payload=$3 WHERE id=$4`,
+		c.Name, c.Type, c.Payload, c.ID)
+	return err
+}
+
 func (s *credentialStore) Delete(ctx context.Context, id string) error {
 	_, err := s.db.ExecContext(ctx, `DELETE FROM credentials WHERE id=$1`, id)
 	return err
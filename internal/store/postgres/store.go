@@ -3,25 +3,31 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"github.com/aven/ngoogle/internal/store"
 )
 
 type pgStore struct {
-	db       *sql.DB
-	agents   *agentStore
-	tasks    *taskStore
-	metrics  *taskMetricsStore
-	profiles *trafficProfileStore
-	pools    *urlPoolStore
-	groups   *taskGroupStore
-	jobs     *provisionJobStore
-	bw       *bandwidthStore
-	creds    *credentialStore
+	db          *sql.DB
+	agents      *agentStore
+	tasks       *taskStore
+	metrics     *taskMetricsStore
+	results     *taskResultStore
+	profiles    *trafficProfileStore
+	pools       *urlPoolStore
+	groups      *taskGroupStore
+	agentGroups *agentGroupStore
+	templates   *taskTemplateStore
+	maintenance *maintenanceWindowStore
+	jobs        *provisionJobStore
+	bw          *bandwidthStore
+	creds       *credentialStore
 }
 
 // New opens a PostgreSQL database and runs migrations.
@@ -37,31 +43,42 @@ func New(dsn string) (store.Store, error) {
 	if err := migrate(db); err != nil {
 		return nil, fmt.Errorf("postgres migrate: %w", err)
 	}
-	s := &pgStore{
-		db:       db,
-		agents:   &agentStore{db},
-		tasks:    &taskStore{db},
-		metrics:  &taskMetricsStore{db},
-		profiles: &trafficProfileStore{db},
-		pools:    &urlPoolStore{db},
-		groups:   &taskGroupStore{db},
-		jobs:     &provisionJobStore{db},
-		bw:       &bandwidthStore{db},
-		creds:    &credentialStore{db},
-	}
+	s := &pgStore{db: db}
+	s.bind(db)
 	return s, nil
 }
 
-func (s *pgStore) Agents() store.AgentStore                   { return s.agents }
-func (s *pgStore) Tasks() store.TaskStore                     { return s.tasks }
-func (s *pgStore) TaskMetrics() store.TaskMetricsStore        { return s.metrics }
-func (s *pgStore) TrafficProfiles() store.TrafficProfileStore { return s.profiles }
-func (s *pgStore) URLPools() store.URLPoolStore               { return s.pools }
-func (s *pgStore) TaskGroups() store.TaskGroupStore           { return s.groups }
-func (s *pgStore) ProvisionJobs() store.ProvisionJobStore     { return s.jobs }
-func (s *pgStore) Bandwidth() store.BandwidthStore            { return s.bw }
-func (s *pgStore) Credentials() store.CredentialStore         { return s.creds }
-func (s *pgStore) Close() error                               { return s.db.Close() }
+// bind points the sub-stores at db.
+func (s *pgStore) bind(db dbtx) {
+	s.agents = &agentStore{db}
+	s.tasks = &taskStore{db}
+	s.metrics = &taskMetricsStore{db}
+	s.results = &taskResultStore{db}
+	s.profiles = &trafficProfileStore{db}
+	s.pools = &urlPoolStore{db}
+	s.groups = &taskGroupStore{db}
+	s.agentGroups = &agentGroupStore{db}
+	s.templates = &taskTemplateStore{db}
+	s.maintenance = &maintenanceWindowStore{db}
+	s.jobs = &provisionJobStore{db}
+	s.bw = &bandwidthStore{db}
+	s.creds = &credentialStore{db}
+}
+
+func (s *pgStore) Agents() store.AgentStore                         { return s.agents }
+func (s *pgStore) Tasks() store.TaskStore                           { return s.tasks }
+func (s *pgStore) TaskMetrics() store.TaskMetricsStore              { return s.metrics }
+func (s *pgStore) TaskResults() store.TaskResultStore               { return s.results }
+func (s *pgStore) TrafficProfiles() store.TrafficProfileStore       { return s.profiles }
+func (s *pgStore) URLPools() store.URLPoolStore                     { return s.pools }
+func (s *pgStore) TaskGroups() store.TaskGroupStore                 { return s.groups }
+func (s *pgStore) AgentGroups() store.AgentGroupStore               { return s.agentGroups }
+func (s *pgStore) TaskTemplates() store.TaskTemplateStore           { return s.templates }
+func (s *pgStore) MaintenanceWindows() store.MaintenanceWindowStore { return s.maintenance }
+func (s *pgStore) ProvisionJobs() store.ProvisionJobStore           { return s.jobs }
+func (s *pgStore) Bandwidth() store.BandwidthStore                  { return s.bw }
+func (s *pgStore) Credentials() store.CredentialStore               { return s.creds }
+func (s *pgStore) Close() error                                     { return s.db.Close() }
 
 // ─── Migrations ───────────────────────────────────────────────────────────────
 
@@ -70,25 +87,48 @@ func migrate(db *sql.DB) error {
 		`CREATE TABLE IF NOT EXISTS agents (
 			id TEXT PRIMARY KEY,
 			hostname TEXT NOT NULL DEFAULT '',
+			machine_id TEXT NOT NULL DEFAULT '',
 			ip TEXT NOT NULL DEFAULT '',
 			port INTEGER NOT NULL DEFAULT 0,
 			token TEXT NOT NULL DEFAULT '',
 			status TEXT NOT NULL DEFAULT 'offline',
 			version TEXT NOT NULL DEFAULT '',
+			commit_hash TEXT NOT NULL DEFAULT '',
+			tags_json TEXT NOT NULL DEFAULT '[]',
 			current_rate_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
+			task_rate_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
+			tx_rate_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
+			rate_measured BOOLEAN NOT NULL DEFAULT FALSE,
+			capacity_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
 			last_heartbeat TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		)`,
+		`CREATE TABLE IF NOT EXISTS agent_groups (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS agent_group_members (
+			group_id TEXT NOT NULL,
+			agent_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, agent_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_agent_group_members_agent ON agent_group_members(agent_id)`,
 		`CREATE TABLE IF NOT EXISTS tasks (
 			id TEXT PRIMARY KEY,
 			group_id TEXT NOT NULL DEFAULT '',
 			name TEXT NOT NULL DEFAULT '',
+			external_id TEXT NOT NULL DEFAULT '',
 			type TEXT NOT NULL DEFAULT 'static',
 			url_pool_id TEXT NOT NULL DEFAULT '',
 			target_url TEXT NOT NULL DEFAULT '',
 			target_urls_json TEXT NOT NULL DEFAULT '[]',
 			agent_id TEXT NOT NULL DEFAULT '',
+			agent_group_id TEXT NOT NULL DEFAULT '',
 			execution_scope TEXT NOT NULL DEFAULT 'single_agent',
 			status TEXT NOT NULL DEFAULT 'pending',
 			target_rate_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
@@ -104,12 +144,26 @@ func migrate(db *sql.DB) error {
 			ramp_up_sec INTEGER NOT NULL DEFAULT 0,
 			ramp_down_sec INTEGER NOT NULL DEFAULT 0,
 			traffic_profile_id TEXT NOT NULL DEFAULT '',
+			timezone TEXT NOT NULL DEFAULT '',
 			concurrent_fragments INTEGER NOT NULL DEFAULT 1,
+			reuse_connections BOOLEAN NOT NULL DEFAULT TRUE,
+			range_chunk_bytes BIGINT NOT NULL DEFAULT 0,
+			read_chunk_bytes BIGINT NOT NULL DEFAULT 0,
+			burst_bytes BIGINT NOT NULL DEFAULT 0,
+			cache_bust_param TEXT NOT NULL DEFAULT '',
+			redirects TEXT NOT NULL DEFAULT '',
+			max_redirects INTEGER NOT NULL DEFAULT 0,
+			expected_bytes BIGINT NOT NULL DEFAULT 0,
+			expected_sha256 TEXT NOT NULL DEFAULT '',
+			target_rps DOUBLE PRECISION NOT NULL DEFAULT 0,
+			warmup_sec INTEGER NOT NULL DEFAULT 0,
 			retries INTEGER NOT NULL DEFAULT 3,
 			total_bytes_done BIGINT NOT NULL DEFAULT 0,
+			paused_sec INTEGER NOT NULL DEFAULT 0,
 			error_message TEXT NOT NULL DEFAULT '',
 			dispatched_at TIMESTAMPTZ,
 			started_at TIMESTAMPTZ,
+			paused_at TIMESTAMPTZ,
 			finished_at TIMESTAMPTZ,
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
@@ -124,15 +178,45 @@ func migrate(db *sql.DB) error {
 			rate_mbps_30s DOUBLE PRECISION NOT NULL DEFAULT 0,
 			request_count BIGINT NOT NULL DEFAULT 0,
 			error_count BIGINT NOT NULL DEFAULT 0,
+			content_error_count BIGINT NOT NULL DEFAULT 0,
+			latency_json TEXT NOT NULL DEFAULT '',
+			warmup BOOLEAN NOT NULL DEFAULT FALSE,
 			recorded_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_task_metrics_task_id ON task_metrics(task_id, recorded_at)`,
+		`CREATE TABLE IF NOT EXISTS task_results (
+			task_id TEXT PRIMARY KEY,
+			status TEXT NOT NULL DEFAULT '',
+			end_reason TEXT NOT NULL DEFAULT '',
+			bytes_total BIGINT NOT NULL DEFAULT 0,
+			request_count BIGINT NOT NULL DEFAULT 0,
+			error_count BIGINT NOT NULL DEFAULT 0,
+			avg_rate_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
+			p95_rate_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
+			duration_sec DOUBLE PRECISION NOT NULL DEFAULT 0,
+			started_at TIMESTAMPTZ,
+			finished_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS task_agent_results (
+			task_id TEXT NOT NULL,
+			agent_id TEXT NOT NULL,
+			stop_reason TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			bytes_total BIGINT NOT NULL DEFAULT 0,
+			request_count BIGINT NOT NULL DEFAULT 0,
+			error_count BIGINT NOT NULL DEFAULT 0,
+			duration_sec DOUBLE PRECISION NOT NULL DEFAULT 0,
+			reported_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (task_id, agent_id)
+		)`,
 		`CREATE TABLE IF NOT EXISTS traffic_profiles (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL DEFAULT '',
+			external_id TEXT NOT NULL DEFAULT '',
 			description TEXT NOT NULL DEFAULT '',
 			distribution TEXT NOT NULL DEFAULT 'flat',
 			points TEXT NOT NULL DEFAULT '[]',
+			timezone TEXT NOT NULL DEFAULT '',
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		)`,
 		`CREATE TABLE IF NOT EXISTS url_pools (
@@ -144,12 +228,33 @@ func migrate(db *sql.DB) error {
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		)`,
+		`CREATE TABLE IF NOT EXISTS task_templates (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			spec_json TEXT NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS maintenance_windows (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			start_at TIMESTAMPTZ,
+			end_at TIMESTAMPTZ,
+			daily_start TEXT NOT NULL DEFAULT '',
+			daily_end TEXT NOT NULL DEFAULT '',
+			timezone TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
 		`CREATE TABLE IF NOT EXISTS task_groups (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL DEFAULT '',
 			description TEXT NOT NULL DEFAULT '',
 			pool_ids_json TEXT NOT NULL DEFAULT '[]',
 			agent_id TEXT NOT NULL DEFAULT '',
+			agent_group_id TEXT NOT NULL DEFAULT '',
 			execution_scope TEXT NOT NULL DEFAULT 'single_agent',
 			target_rate_mbps DOUBLE PRECISION NOT NULL DEFAULT 0,
 			start_at TIMESTAMPTZ,
@@ -164,7 +269,19 @@ func migrate(db *sql.DB) error {
 			ramp_up_sec INTEGER NOT NULL DEFAULT 0,
 			ramp_down_sec INTEGER NOT NULL DEFAULT 0,
 			traffic_profile_id TEXT NOT NULL DEFAULT '',
+			timezone TEXT NOT NULL DEFAULT '',
 			concurrent_fragments INTEGER NOT NULL DEFAULT 1,
+			reuse_connections BOOLEAN NOT NULL DEFAULT TRUE,
+			range_chunk_bytes BIGINT NOT NULL DEFAULT 0,
+			read_chunk_bytes BIGINT NOT NULL DEFAULT 0,
+			burst_bytes BIGINT NOT NULL DEFAULT 0,
+			cache_bust_param TEXT NOT NULL DEFAULT '',
+			redirects TEXT NOT NULL DEFAULT '',
+			max_redirects INTEGER NOT NULL DEFAULT 0,
+			expected_bytes BIGINT NOT NULL DEFAULT 0,
+			expected_sha256 TEXT NOT NULL DEFAULT '',
+			warmup_sec INTEGER NOT NULL DEFAULT 0,
+			stagger_sec INTEGER NOT NULL DEFAULT 0,
 			retries INTEGER NOT NULL DEFAULT 3,
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
@@ -176,11 +293,18 @@ func migrate(db *sql.DB) error {
 			ssh_user TEXT NOT NULL DEFAULT '',
 			auth_type TEXT NOT NULL DEFAULT 'key',
 			credential_ref TEXT NOT NULL DEFAULT '',
+			sudo_credential_ref TEXT NOT NULL DEFAULT '',
+			install_dir TEXT NOT NULL DEFAULT '',
+			service_name TEXT NOT NULL DEFAULT '',
+			agent_env_json TEXT NOT NULL DEFAULT '{}',
+			download_url TEXT NOT NULL DEFAULT '',
+			agent_token TEXT NOT NULL DEFAULT '',
 			status TEXT NOT NULL DEFAULT 'pending',
 			current_step TEXT NOT NULL DEFAULT '',
 			log TEXT NOT NULL DEFAULT '',
 			agent_id TEXT NOT NULL DEFAULT '',
 			failed_step TEXT NOT NULL DEFAULT '',
+			step_durations TEXT NOT NULL DEFAULT '{}',
 			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		)`,
@@ -217,12 +341,70 @@ func migrate(db *sql.DB) error {
 		}
 	}
 	// Ensure columns added in later migrations
+	ensureColumn(db, "agents", "machine_id", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "agents", "tags_json", "TEXT NOT NULL DEFAULT '[]'")
+	ensureColumn(db, "agents", "task_rate_mbps", "DOUBLE PRECISION NOT NULL DEFAULT 0")
+	ensureColumn(db, "agents", "tx_rate_mbps", "DOUBLE PRECISION NOT NULL DEFAULT 0")
+	ensureColumn(db, "agents", "rate_measured", "BOOLEAN NOT NULL DEFAULT FALSE")
+	ensureColumn(db, "agents", "commit_hash", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "agents", "enabled", "BOOLEAN NOT NULL DEFAULT TRUE")
+	ensureColumn(db, "agents", "capacity_mbps", "DOUBLE PRECISION NOT NULL DEFAULT 0")
 	ensureColumn(db, "tasks", "target_urls_json", "TEXT NOT NULL DEFAULT '[]'")
 	ensureColumn(db, "tasks", "group_id", "TEXT NOT NULL DEFAULT ''")
 	ensureColumn(db, "tasks", "url_pool_id", "TEXT NOT NULL DEFAULT ''")
 	ensureColumn(db, "tasks", "execution_scope", "TEXT NOT NULL DEFAULT 'single_agent'")
+	ensureColumn(db, "tasks", "timezone", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "task_groups", "timezone", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "traffic_profiles", "timezone", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "tasks", "reuse_connections", "BOOLEAN NOT NULL DEFAULT TRUE")
+	ensureColumn(db, "task_groups", "reuse_connections", "BOOLEAN NOT NULL DEFAULT TRUE")
+	ensureColumn(db, "tasks", "range_chunk_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "task_groups", "range_chunk_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "read_chunk_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "task_groups", "read_chunk_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "burst_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "task_groups", "burst_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "cache_bust_param", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "task_groups", "cache_bust_param", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "tasks", "redirects", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "tasks", "max_redirects", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn(db, "task_groups", "redirects", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "task_groups", "max_redirects", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "expected_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "expected_sha256", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "task_groups", "expected_bytes", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "task_groups", "expected_sha256", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "tasks", "target_rps", "DOUBLE PRECISION NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "warmup_sec", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn(db, "task_groups", "warmup_sec", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn(db, "task_metrics", "latency_json", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "task_metrics", "warmup", "BOOLEAN NOT NULL DEFAULT FALSE")
+	ensureColumn(db, "task_metrics", "content_error_count", "BIGINT NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "agent_group_id", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "task_groups", "agent_group_id", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "task_groups", "stagger_sec", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn(db, "tasks", "external_id", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "traffic_profiles", "external_id", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "tasks", "paused_at", "TIMESTAMPTZ")
+	ensureColumn(db, "tasks", "paused_sec", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn(db, "provision_jobs", "sudo_credential_ref", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "provision_jobs", "install_dir", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "provision_jobs", "service_name", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "provision_jobs", "agent_env_json", "TEXT NOT NULL DEFAULT '{}'")
+	ensureColumn(db, "provision_jobs", "agent_token", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn(db, "provision_jobs", "step_durations", "TEXT NOT NULL DEFAULT '{}'")
+	ensureColumn(db, "provision_jobs", "download_url", "TEXT NOT NULL DEFAULT ''")
 	ensureColumn(db, "bandwidth_samples", "ts", "BIGINT NOT NULL DEFAULT 0")
 
+	// External ids are optional but unique when set
+	for _, stmt := range []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_external_id ON tasks(external_id) WHERE external_id <> ''`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_traffic_profiles_external_id ON traffic_profiles(external_id) WHERE external_id <> ''`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("create external id index: %w", err)
+		}
+	}
 	// Backfill ts from recorded_at for existing rows
 	if _, err := db.Exec(`UPDATE bandwidth_samples SET ts = EXTRACT(EPOCH FROM recorded_at)::BIGINT WHERE ts = 0`); err != nil {
 		return fmt.Errorf("backfill bandwidth ts: %w", err)
@@ -283,3 +465,12 @@ func checkCtx(ctx context.Context) error {
 type scanner interface {
 	Scan(dest ...any) error
 }
+
+// mapConflict converts unique-constraint violations to store.ErrConflict.
+func mapConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return fmt.Errorf("%w: %v", store.ErrConflict, err)
+	}
+	return err
+}
@@ -6,29 +6,30 @@ import (
 	"fmt"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
-type taskGroupStore struct{ db *sql.DB }
+type taskGroupStore struct{ db dbtx }
 
-const taskGroupCols = `id,name,description,pool_ids_json,agent_id,execution_scope,target_rate_mbps,
+const taskGroupCols = `id,name,description,pool_ids_json,agent_id,agent_group_id,execution_scope,target_rate_mbps,
 start_at,end_at,duration_sec,total_bytes_target,total_requests_target,dispatch_rate_tpm,dispatch_batch_size,
-distribution,jitter_pct,ramp_up_sec,ramp_down_sec,traffic_profile_id,concurrent_fragments,retries,
+distribution,jitter_pct,ramp_up_sec,ramp_down_sec,traffic_profile_id,timezone,concurrent_fragments,reuse_connections,range_chunk_bytes,read_chunk_bytes,burst_bytes,cache_bust_param,redirects,max_redirects,expected_bytes,expected_sha256,warmup_sec,stagger_sec,retries,
 created_at,updated_at`
 
 func (s *taskGroupStore) Create(ctx context.Context, g *model.TaskGroup) error {
 	g.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO task_groups (id,name,description,pool_ids_json,agent_id,execution_scope,target_rate_mbps,
+		INSERT INTO task_groups (id,name,description,pool_ids_json,agent_id,agent_group_id,execution_scope,target_rate_mbps,
 			start_at,end_at,duration_sec,total_bytes_target,total_requests_target,dispatch_rate_tpm,dispatch_batch_size,
-			distribution,jitter_pct,ramp_up_sec,ramp_down_sec,traffic_profile_id,concurrent_fragments,retries,
+			distribution,jitter_pct,ramp_up_sec,ramp_down_sec,traffic_profile_id,timezone,concurrent_fragments,reuse_connections,range_chunk_bytes,read_chunk_bytes,burst_bytes,cache_bust_param,redirects,max_redirects,expected_bytes,expected_sha256,warmup_sec,stagger_sec,retries,
 			created_at,updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23)`,
-		g.ID, g.Name, g.Description, g.PoolIDsJSON, g.AgentID, g.ExecutionScope, g.TargetRateMbps,
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32,$33,$34,$35,$36)`,
+		g.ID, g.Name, g.Description, g.PoolIDsJSON, g.AgentID, g.AgentGroupID, g.ExecutionScope, g.TargetRateMbps,
 		nullTime(g.StartAt), nullTime(g.EndAt), g.DurationSec, g.TotalBytesTarget, g.TotalRequestsTarget,
 		g.DispatchRateTpm, g.DispatchBatchSize, g.Distribution, g.JitterPct, g.RampUpSec, g.RampDownSec,
-		g.TrafficProfileID, g.ConcurrentFragments, g.Retries, g.CreatedAt.UTC(), g.UpdatedAt.UTC(),
+		g.TrafficProfileID, g.Timezone, g.ConcurrentFragments, g.ReuseConnections, g.RangeChunkBytes, g.ReadChunkBytes, g.BurstBytes, g.CacheBustParam, g.Redirects, g.MaxRedirects, g.ExpectedBytes, g.ExpectedSHA256, g.WarmupSec, g.StaggerSec, g.Retries, g.CreatedAt.UTC(), g.UpdatedAt.UTC(),
 	)
-	return err
+	return mapConflict(err)
 }
 
 func (s *taskGroupStore) Get(ctx context.Context, id string) (*model.TaskGroup, error) {
@@ -63,13 +64,13 @@ func scanTaskGroup(row scanner) (*model.TaskGroup, error) {
 	g := &model.TaskGroup{}
 	var startAt, endAt sql.NullTime
 	err := row.Scan(
-		&g.ID, &g.Name, &g.Description, &g.PoolIDsJSON, &g.AgentID, &g.ExecutionScope, &g.TargetRateMbps,
+		&g.ID, &g.Name, &g.Description, &g.PoolIDsJSON, &g.AgentID, &g.AgentGroupID, &g.ExecutionScope, &g.TargetRateMbps,
 		&startAt, &endAt, &g.DurationSec, &g.TotalBytesTarget, &g.TotalRequestsTarget, &g.DispatchRateTpm, &g.DispatchBatchSize,
-		&g.Distribution, &g.JitterPct, &g.RampUpSec, &g.RampDownSec, &g.TrafficProfileID, &g.ConcurrentFragments, &g.Retries,
+		&g.Distribution, &g.JitterPct, &g.RampUpSec, &g.RampDownSec, &g.TrafficProfileID, &g.Timezone, &g.ConcurrentFragments, &g.ReuseConnections, &g.RangeChunkBytes, &g.ReadChunkBytes, &g.BurstBytes, &g.CacheBustParam, &g.Redirects, &g.MaxRedirects, &g.ExpectedBytes, &g.ExpectedSHA256, &g.WarmupSec, &g.StaggerSec, &g.Retries,
 		&g.CreatedAt, &g.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("task group not found")
+		return nil, fmt.Errorf("task group %w", store.ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+type taskTemplateStore struct{ db dbtx }
+
+func (s *taskTemplateStore) Create(ctx context.Context, t *model.TaskTemplate) error {
+	t.Normalize()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO task_templates(id,name,description,spec_json,created_at,updated_at)
+		VALUES($1,$2,$3,$4,$5,$6)`,
+		t.ID, t.Name, t.Description, t.SpecJSON, t.CreatedAt.UTC(), t.UpdatedAt.UTC())
+	return mapConflict(err)
+}
+
+func (s *taskTemplateStore) Get(ctx context.Context, id string) (*model.TaskTemplate, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id,name,description,spec_json,created_at,updated_at FROM task_templates WHERE id=$1`, id)
+	return scanTaskTemplate(row)
+}
+
+func (s *taskTemplateStore) List(ctx context.Context) ([]*model.TaskTemplate, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id,name,description,spec_json,created_at,updated_at FROM task_templates ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*model.TaskTemplate
+	for rows.Next() {
+		t, err := scanTaskTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *taskTemplateStore) Update(ctx context.Context, t *model.TaskTemplate) error {
+	t.Normalize()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE task_templates
+		SET name=$1, description=$2, spec_json=$3, updated_at=$4
+		WHERE id=$5`,
+		t.Name, t.Description, t.SpecJSON, t.UpdatedAt.UTC(), t.ID)
+	return mapConflict(err)
+}
+
+func (s *taskTemplateStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM task_templates WHERE id=$1`, id)
+	return err
+}
+
+func scanTaskTemplate(row scanner) (*model.TaskTemplate, error) {
+	t := &model.TaskTemplate{}
+	err := row.Scan(&t.ID, &t.Name, &t.Description, &t.SpecJSON, &t.CreatedAt, &t.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("task template %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Normalize()
+	return t, nil
+}
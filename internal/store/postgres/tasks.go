@@ -8,36 +8,37 @@ import (
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
-type taskStore struct{ db *sql.DB }
+type taskStore struct{ db dbtx }
 
-const taskCols = `id,group_id,name,type,url_pool_id,target_url,target_urls_json,agent_id,execution_scope,status,target_rate_mbps,
+const taskCols = `id,group_id,name,external_id,type,url_pool_id,target_url,target_urls_json,agent_id,agent_group_id,execution_scope,status,target_rate_mbps,
 start_at,end_at,duration_sec,total_bytes_target,total_requests_target,
 dispatch_rate_tpm,dispatch_batch_size,distribution,jitter_pct,ramp_up_sec,ramp_down_sec,
-traffic_profile_id,concurrent_fragments,retries,total_bytes_done,error_message,
-dispatched_at,started_at,finished_at,created_at,updated_at`
+traffic_profile_id,timezone,concurrent_fragments,reuse_connections,range_chunk_bytes,read_chunk_bytes,burst_bytes,cache_bust_param,redirects,max_redirects,expected_bytes,expected_sha256,target_rps,warmup_sec,retries,total_bytes_done,paused_sec,error_message,
+dispatched_at,started_at,paused_at,finished_at,created_at,updated_at`
 
 func (s *taskStore) Create(ctx context.Context, t *model.Task) error {
 	t.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO tasks (id,group_id,name,type,url_pool_id,target_url,target_urls_json,agent_id,execution_scope,status,target_rate_mbps,
+		INSERT INTO tasks (id,group_id,name,external_id,type,url_pool_id,target_url,target_urls_json,agent_id,agent_group_id,execution_scope,status,target_rate_mbps,
 			start_at,end_at,duration_sec,total_bytes_target,total_requests_target,
 			dispatch_rate_tpm,dispatch_batch_size,distribution,jitter_pct,ramp_up_sec,ramp_down_sec,
-			traffic_profile_id,concurrent_fragments,retries,total_bytes_done,error_message,
-			dispatched_at,started_at,finished_at,created_at,updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32)`,
-		t.ID, t.GroupID, t.Name, t.Type, t.URLPoolID, t.TargetURL, t.TargetURLsJSON, t.AgentID, t.ExecutionScope, t.Status, t.TargetRateMbps,
+			traffic_profile_id,timezone,concurrent_fragments,reuse_connections,range_chunk_bytes,read_chunk_bytes,burst_bytes,cache_bust_param,redirects,max_redirects,expected_bytes,expected_sha256,target_rps,warmup_sec,retries,total_bytes_done,paused_sec,error_message,
+			dispatched_at,started_at,paused_at,finished_at,created_at,updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32,$33,$34,$35,$36,$37,$38,$39,$40,$41,$42,$43,$44,$45,$46,$47,$48)`,
+		t.ID, t.GroupID, t.Name, t.ExternalID, t.Type, t.URLPoolID, t.TargetURL, t.TargetURLsJSON, t.AgentID, t.AgentGroupID, t.ExecutionScope, t.Status, t.TargetRateMbps,
 		nullTime(t.StartAt), nullTime(t.EndAt), t.DurationSec,
 		t.TotalBytesTarget, t.TotalRequestsTarget,
 		t.DispatchRateTpm, t.DispatchBatchSize, t.Distribution,
 		t.JitterPct, t.RampUpSec, t.RampDownSec,
-		t.TrafficProfileID, t.ConcurrentFragments, t.Retries,
-		t.TotalBytesDone, t.ErrorMessage,
-		nullTime(t.DispatchedAt), nullTime(t.StartedAt), nullTime(t.FinishedAt),
+		t.TrafficProfileID, t.Timezone, t.ConcurrentFragments, t.ReuseConnections, t.RangeChunkBytes, t.ReadChunkBytes, t.BurstBytes, t.CacheBustParam, t.Redirects, t.MaxRedirects, t.ExpectedBytes, t.ExpectedSHA256, t.TargetRps, t.WarmupSec, t.Retries,
+		t.TotalBytesDone, t.PausedSec, t.ErrorMessage,
+		nullTime(t.DispatchedAt), nullTime(t.StartedAt), nullTime(t.PausedAt), nullTime(t.FinishedAt),
 		t.CreatedAt.UTC(), t.UpdatedAt.UTC(),
 	)
-	return err
+	return mapConflict(err)
 }
 
 func (s *taskStore) Get(ctx context.Context, id string) (*model.Task, error) {
@@ -80,6 +81,24 @@ func (s *taskStore) ListByAgent(ctx context.Context, agentID string, statuses []
 	return scanTasks(rows)
 }
 
+func (s *taskStore) Update(ctx context.Context, t *model.Task) error {
+	t.Normalize()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE tasks SET name=$1,external_id=$2,type=$3,url_pool_id=$4,target_url=$5,target_urls_json=$6,agent_id=$7,agent_group_id=$8,execution_scope=$9,target_rate_mbps=$10,
+			start_at=$11,end_at=$12,duration_sec=$13,total_bytes_target=$14,total_requests_target=$15,
+			dispatch_rate_tpm=$16,dispatch_batch_size=$17,distribution=$18,jitter_pct=$19,ramp_up_sec=$20,ramp_down_sec=$21,
+			traffic_profile_id=$22,timezone=$23,concurrent_fragments=$24,reuse_connections=$25,range_chunk_bytes=$26,read_chunk_bytes=$27,burst_bytes=$28,cache_bust_param=$29,redirects=$30,max_redirects=$31,expected_bytes=$32,expected_sha256=$33,target_rps=$34,warmup_sec=$35,retries=$36,
+			updated_at=$37
+		WHERE id=$38`,
+		t.Name, t.ExternalID, t.Type, t.URLPoolID, t.TargetURL, t.TargetURLsJSON, t.AgentID, t.AgentGroupID, t.ExecutionScope, t.TargetRateMbps,
+		nullTime(t.StartAt), nullTime(t.EndAt), t.DurationSec, t.TotalBytesTarget, t.TotalRequestsTarget,
+		t.DispatchRateTpm, t.DispatchBatchSize, t.Distribution, t.JitterPct, t.RampUpSec, t.RampDownSec,
+		t.TrafficProfileID, t.Timezone, t.ConcurrentFragments, t.ReuseConnections, t.RangeChunkBytes, t.ReadChunkBytes, t.BurstBytes, t.CacheBustParam, t.Redirects, t.MaxRedirects, t.ExpectedBytes, t.ExpectedSHA256, t.TargetRps, t.WarmupSec, t.Retries,
+		t.UpdatedAt.UTC(), t.ID,
+	)
+	return mapConflict(err)
+}
+
 func (s *taskStore) UpdateStatus(ctx context.Context, id string, status model.TaskStatus) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET status=$1,updated_at=$2 WHERE id=$3`, status, time.Now().UTC(), id)
 	return err
@@ -91,6 +110,12 @@ func (s *taskStore) UpdateStatusWithTime(ctx context.Context, id string, status
 	return err
 }
 
+func (s *taskStore) UpdatePause(ctx context.Context, id string, status model.TaskStatus, pausedAt *time.Time, pausedSec int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET status=$1,paused_at=$2,paused_sec=$3,updated_at=$4 WHERE id=$5`,
+		status, nullTime(pausedAt), pausedSec, time.Now().UTC(), id)
+	return err
+}
+
 func (s *taskStore) UpdateBytes(ctx context.Context, id string, bytesTotal int64) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET total_bytes_done=$1,updated_at=$2 WHERE id=$3`, bytesTotal, time.Now().UTC(), id)
 	return err
@@ -108,20 +133,20 @@ func (s *taskStore) Delete(ctx context.Context, id string) error {
 
 func scanTask(row scanner) (*model.Task, error) {
 	t := &model.Task{}
-	var startAt, endAt, dispatchedAt, startedAt, finishedAt sql.NullTime
+	var startAt, endAt, dispatchedAt, startedAt, pausedAt, finishedAt sql.NullTime
 	err := row.Scan(
-		&t.ID, &t.GroupID, &t.Name, &t.Type, &t.URLPoolID, &t.TargetURL, &t.TargetURLsJSON, &t.AgentID, &t.ExecutionScope, &t.Status, &t.TargetRateMbps,
+		&t.ID, &t.GroupID, &t.Name, &t.ExternalID, &t.Type, &t.URLPoolID, &t.TargetURL, &t.TargetURLsJSON, &t.AgentID, &t.AgentGroupID, &t.ExecutionScope, &t.Status, &t.TargetRateMbps,
 		&startAt, &endAt, &t.DurationSec,
 		&t.TotalBytesTarget, &t.TotalRequestsTarget,
 		&t.DispatchRateTpm, &t.DispatchBatchSize, &t.Distribution,
 		&t.JitterPct, &t.RampUpSec, &t.RampDownSec,
-		&t.TrafficProfileID, &t.ConcurrentFragments, &t.Retries,
-		&t.TotalBytesDone, &t.ErrorMessage,
-		&dispatchedAt, &startedAt, &finishedAt,
+		&t.TrafficProfileID, &t.Timezone, &t.ConcurrentFragments, &t.ReuseConnections, &t.RangeChunkBytes, &t.ReadChunkBytes, &t.BurstBytes, &t.CacheBustParam, &t.Redirects, &t.MaxRedirects, &t.ExpectedBytes, &t.ExpectedSHA256, &t.TargetRps, &t.WarmupSec, &t.Retries,
+		&t.TotalBytesDone, &t.PausedSec, &t.ErrorMessage,
+		&dispatchedAt, &startedAt, &pausedAt, &finishedAt,
 		&t.CreatedAt, &t.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("task not found")
+		return nil, fmt.Errorf("task %w", store.ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
@@ -130,6 +155,7 @@ func scanTask(row scanner) (*model.Task, error) {
 	t.EndAt = scanNullTime(endAt)
 	t.DispatchedAt = scanNullTime(dispatchedAt)
 	t.StartedAt = scanNullTime(startedAt)
+	t.PausedAt = scanNullTime(pausedAt)
 	t.FinishedAt = scanNullTime(finishedAt)
 	t.Normalize()
 	return t, nil
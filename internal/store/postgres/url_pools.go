@@ -6,9 +6,10 @@ import (
 	"fmt"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
-type urlPoolStore struct{ db *sql.DB }
+type urlPoolStore struct{ db dbtx }
 
 func (s *urlPoolStore) Create(ctx context.Context, p *model.URLPool) error {
 	p.Normalize()
@@ -16,7 +17,7 @@ func (s *urlPoolStore) Create(ctx context.Context, p *model.URLPool) error {
 		INSERT INTO url_pools(id,name,type,description,urls_json,created_at,updated_at)
 		VALUES($1,$2,$3,$4,$5,$6,$7)`,
 		p.ID, p.Name, p.Type, p.Description, p.URLsJSON, p.CreatedAt.UTC(), p.UpdatedAt.UTC())
-	return err
+	return mapConflict(err)
 }
 
 func (s *urlPoolStore) Get(ctx context.Context, id string) (*model.URLPool, error) {
@@ -63,7 +64,7 @@ func scanURLPool(row scanner) (*model.URLPool, error) {
 	p := &model.URLPool{}
 	err := row.Scan(&p.ID, &p.Name, &p.Type, &p.Description, &p.URLsJSON, &p.CreatedAt, &p.UpdatedAt)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("url pool not found")
+		return nil, fmt.Errorf("url pool %w", store.ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
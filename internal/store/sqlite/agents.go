@@ -4,37 +4,42 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
-type agentStore struct{ db, ro *sql.DB }
+type agentStore struct{ db, ro dbtx }
 
 func (s *agentStore) Upsert(ctx context.Context, a *model.Agent) error {
+	a.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO agents (id, hostname, ip, port, token, status, version, current_rate_mbps, last_heartbeat, created_at, updated_at)
-		VALUES (?,?,?,?,?,?,?,?,?,?,?)
+		INSERT INTO agents (id, hostname, machine_id, ip, port, token, status, version, commit_hash, tags_json, current_rate_mbps, task_rate_mbps, tx_rate_mbps, rate_measured, capacity_mbps, last_heartbeat, created_at, updated_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
 		ON CONFLICT(id) DO UPDATE SET
-			hostname=excluded.hostname, ip=excluded.ip, port=excluded.port,
-			token=excluded.token, status=excluded.status, version=excluded.version,
-			current_rate_mbps=excluded.current_rate_mbps,
+			hostname=excluded.hostname, machine_id=excluded.machine_id, ip=excluded.ip, port=excluded.port,
+			token=excluded.token, status=excluded.status, version=excluded.version, commit_hash=excluded.commit_hash,
+			tags_json=excluded.tags_json,
+			current_rate_mbps=excluded.current_rate_mbps, task_rate_mbps=excluded.task_rate_mbps,
+			tx_rate_mbps=excluded.tx_rate_mbps, rate_measured=excluded.rate_measured, capacity_mbps=excluded.capacity_mbps,
 			last_heartbeat=excluded.last_heartbeat, updated_at=excluded.updated_at`,
-		a.ID, a.Hostname, a.IP, a.Port, a.Token, a.Status, a.Version,
-		a.CurrentRateMbps, a.LastHeartbeat.UTC(), a.CreatedAt.UTC(), a.UpdatedAt.UTC(),
+		a.ID, a.Hostname, a.MachineID, a.IP, a.Port, a.Token, a.Status, a.Version, a.Commit, a.TagsJSON,
+		a.CurrentRateMbps, a.TaskRateMbps, a.TxRateMbps, a.RateMeasured, a.CapacityMbps, a.LastHeartbeat.UTC(), a.CreatedAt.UTC(), a.UpdatedAt.UTC(),
 	)
 	return err
 }
 
 func (s *agentStore) Get(ctx context.Context, id string) (*model.Agent, error) {
 	row := s.ro.QueryRowContext(ctx,
-		`SELECT id,hostname,ip,port,token,status,version,current_rate_mbps,last_heartbeat,created_at,updated_at FROM agents WHERE id=?`, id)
+		`SELECT id,hostname,machine_id,ip,port,token,status,version,commit_hash,tags_json,current_rate_mbps,task_rate_mbps,tx_rate_mbps,rate_measured,capacity_mbps,enabled,last_heartbeat,created_at,updated_at FROM agents WHERE id=?`, id)
 	return scanAgent(row)
 }
 
 func (s *agentStore) List(ctx context.Context) ([]*model.Agent, error) {
 	rows, err := s.ro.QueryContext(ctx,
-		`SELECT id,hostname,ip,port,token,status,version,current_rate_mbps,last_heartbeat,created_at,updated_at FROM agents ORDER BY created_at DESC`)
+		`SELECT id,hostname,machine_id,ip,port,token,status,version,commit_hash,tags_json,current_rate_mbps,task_rate_mbps,tx_rate_mbps,rate_measured,capacity_mbps,enabled,last_heartbeat,created_at,updated_at FROM agents ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +55,52 @@ func (s *agentStore) List(ctx context.Context) ([]*model.Agent, error) {
 	return list, rows.Err()
 }
 
+func (s *agentStore) ListFiltered(ctx context.Context, f store.AgentFilter) ([]*model.Agent, int, error) {
+	var where []string
+	var args []any
+	if f.Status != "" {
+		where = append(where, "status=?")
+		args = append(args, f.Status)
+	}
+	if f.Tag != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(agents.tags_json) WHERE json_each.value=?)")
+		args = append(args, f.Tag)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.ro.QueryRowContext(ctx, `SELECT COUNT(*) FROM agents`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id,hostname,machine_id,ip,port,token,status,version,commit_hash,tags_json,current_rate_mbps,task_rate_mbps,tx_rate_mbps,rate_measured,capacity_mbps,enabled,last_heartbeat,created_at,updated_at FROM agents` +
+		cond + ` ORDER BY created_at DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, f.Limit, f.Offset)
+	} else if f.Offset > 0 {
+		query += ` LIMIT -1 OFFSET ?`
+		args = append(args, f.Offset)
+	}
+	rows, err := s.ro.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var list []*model.Agent
+	for rows.Next() {
+		a, err := scanAgent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, a)
+	}
+	return list, total, rows.Err()
+}
+
 func (s *agentStore) UpdateStatus(ctx context.Context, id string, status model.AgentStatus, heartbeat time.Time) error {
 	_, err := s.db.ExecContext(ctx,
 		`UPDATE agents SET status=?, last_heartbeat=?, updated_at=? WHERE id=?`,
@@ -57,14 +108,24 @@ func (s *agentStore) UpdateStatus(ctx context.Context, id string, status model.A
 	return err
 }
 
-func (s *agentStore) UpdateRate(ctx context.Context, id string, rateMbps float64) error {
+func (s *agentStore) UpdateRate(ctx context.Context, id string, r model.AgentRates) error {
 	_, err := s.db.ExecContext(ctx,
-		`UPDATE agents SET current_rate_mbps=?, updated_at=? WHERE id=?`,
-		rateMbps, time.Now().UTC(), id)
+		`UPDATE agents SET current_rate_mbps=?, task_rate_mbps=?, tx_rate_mbps=?, rate_measured=?, updated_at=? WHERE id=?`,
+		r.RateMbps, r.TaskRateMbps, r.TxRateMbps, r.Measured, time.Now().UTC(), id)
+	return err
+}
+
+func (s *agentStore) SetEnabled(ctx context.Context, id string, enabled bool) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE agents SET enabled=?, updated_at=? WHERE id=?`,
+		enabled, time.Now().UTC(), id)
 	return err
 }
 
 func (s *agentStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM agent_group_members WHERE agent_id=?`, id); err != nil {
+		return err
+	}
 	_, err := s.db.ExecContext(ctx, `DELETE FROM agents WHERE id=?`, id)
 	return err
 }
@@ -75,11 +136,16 @@ type scanner interface {
 
 func scanAgent(row scanner) (*model.Agent, error) {
 	a := &model.Agent{}
-	err := row.Scan(&a.ID, &a.Hostname, &a.IP, &a.Port, &a.Token,
-		&a.Status, &a.Version, &a.CurrentRateMbps,
+	err := row.Scan(&a.ID, &a.Hostname, &a.MachineID, &a.IP, &a.Port, &a.Token,
+		&a.Status, &a.Version, &a.Commit, &a.TagsJSON, &a.CurrentRateMbps,
+		&a.TaskRateMbps, &a.TxRateMbps, &a.RateMeasured, &a.CapacityMbps, &a.Enabled,
 		&a.LastHeartbeat, &a.CreatedAt, &a.UpdatedAt)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("agent not found")
+		return nil, fmt.Errorf("agent %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return a, err
+	a.Normalize()
+	return a, nil
 }
@@ -0,0 +1,28 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Backup writes a consistent copy of the database to path with VACUUM INTO.
+// The copy is taken from a read transaction, so in WAL mode writers carry on
+// while it is made. It runs on a connection of its own because the read
+// pool is query_only, which VACUUM INTO refuses. The copy is compacted and
+// not in WAL mode, ready to be opened as a DSN of its own.
+func (s *sqliteStore) Backup(ctx context.Context, path string) error {
+	db := s.db
+	if s.roDSN != "" {
+		conn, err := sql.Open("sqlite", s.roDSN)
+		if err != nil {
+			return fmt.Errorf("sqlite backup: %w", err)
+		}
+		defer conn.Close()
+		db = conn
+	}
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("sqlite backup: %w", err)
+	}
+	return nil
+}
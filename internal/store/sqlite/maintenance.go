@@ -0,0 +1,79 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+const maintenanceWindowColumns = `id,name,description,start_at,end_at,daily_start,daily_end,timezone,created_at,updated_at`
+
+type maintenanceWindowStore struct{ db dbtx }
+
+func (s *maintenanceWindowStore) Create(ctx context.Context, w *model.MaintenanceWindow) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO maintenance_windows(`+maintenanceWindowColumns+`)
+		VALUES(?,?,?,?,?,?,?,?,?,?)`,
+		w.ID, w.Name, w.Description, nullTime(w.StartAt), nullTime(w.EndAt),
+		w.DailyStart, w.DailyEnd, w.Timezone, w.CreatedAt.UTC(), w.UpdatedAt.UTC())
+	return mapConflict(err)
+}
+
+func (s *maintenanceWindowStore) Get(ctx context.Context, id string) (*model.MaintenanceWindow, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+maintenanceWindowColumns+` FROM maintenance_windows WHERE id=?`, id)
+	return scanMaintenanceWindow(row)
+}
+
+func (s *maintenanceWindowStore) List(ctx context.Context) ([]*model.MaintenanceWindow, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+maintenanceWindowColumns+` FROM maintenance_windows ORDER BY created_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*model.MaintenanceWindow
+	for rows.Next() {
+		w, err := scanMaintenanceWindow(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, w)
+	}
+	return list, rows.Err()
+}
+
+func (s *maintenanceWindowStore) Update(ctx context.Context, w *model.MaintenanceWindow) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE maintenance_windows
+		SET name=?, description=?, start_at=?, end_at=?, daily_start=?, daily_end=?, timezone=?, updated_at=?
+		WHERE id=?`,
+		w.Name, w.Description, nullTime(w.StartAt), nullTime(w.EndAt),
+		w.DailyStart, w.DailyEnd, w.Timezone, w.UpdatedAt.UTC(), w.ID)
+	return err
+}
+
+func (s *maintenanceWindowStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM maintenance_windows WHERE id=?`, id)
+	return err
+}
+
+func scanMaintenanceWindow(row scanner) (*model.MaintenanceWindow, error) {
+	w := &model.MaintenanceWindow{}
+	var startAt, endAt sql.NullTime
+	err := row.Scan(&w.ID, &w.Name, &w.Description, &startAt, &endAt,
+		&w.DailyStart, &w.DailyEnd, &w.Timezone, &w.CreatedAt, &w.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("maintenance window %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.StartAt = scanNullTime(startAt)
+	w.EndAt = scanNullTime(endAt)
+	return w, nil
+}
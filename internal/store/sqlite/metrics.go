@@ -4,27 +4,31 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
 	"github.com/aven/ngoogle/internal/store"
 )
 
-type taskMetricsStore struct{ db, ro *sql.DB }
+type taskMetricsStore struct{ db, ro dbtx }
 
 func (s *taskMetricsStore) Insert(ctx context.Context, m *model.TaskMetrics) error {
+	m.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO task_metrics (task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,recorded_at)
-		VALUES (?,?,?,?,?,?,?,?,?)`,
+		INSERT INTO task_metrics (task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,content_error_count,latency_json,warmup,recorded_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`,
 		m.TaskID, m.AgentID, m.BytesTotal, m.BytesDelta,
-		m.RateMbps5s, m.RateMbps30s, m.RequestCount, m.ErrorCount, m.RecordedAt.UTC().Format("2006-01-02 15:04:05"),
+		m.RateMbps5s, m.RateMbps30s, m.RequestCount, m.ErrorCount, m.ContentErrorCount, m.LatencyJSON, m.Warmup, m.RecordedAt.UTC().Format("2006-01-02 15:04:05"),
 	)
 	return err
 }
 
 func (s *taskMetricsStore) ListByTask(ctx context.Context, taskID string, from, to time.Time) ([]*model.TaskMetrics, error) {
 	rows, err := s.ro.QueryContext(ctx, `
-		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,recorded_at
+		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,content_error_count,latency_json,warmup,recorded_at
 		FROM task_metrics WHERE task_id=? AND recorded_at BETWEEN ? AND ? ORDER BY recorded_at ASC`,
 		taskID, from.UTC().Format("2006-01-02 15:04:05"), to.UTC().Format("2006-01-02 15:04:05"))
 	if err != nil {
@@ -35,9 +39,10 @@ func (s *taskMetricsStore) ListByTask(ctx context.Context, taskID string, from,
 	for rows.Next() {
 		m := &model.TaskMetrics{}
 		if err := rows.Scan(&m.ID, &m.TaskID, &m.AgentID, &m.BytesTotal, &m.BytesDelta,
-			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.RecordedAt); err != nil {
+			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.ContentErrorCount, &m.LatencyJSON, &m.Warmup, &m.RecordedAt); err != nil {
 			return nil, err
 		}
+		m.Normalize()
 		list = append(list, m)
 	}
 	return list, rows.Err()
@@ -45,31 +50,39 @@ func (s *taskMetricsStore) ListByTask(ctx context.Context, taskID string, from,
 
 func (s *taskMetricsStore) LatestByTask(ctx context.Context, taskID string) (*model.TaskMetrics, error) {
 	row := s.ro.QueryRowContext(ctx, `
-		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,recorded_at
-		FROM task_metrics WHERE task_id=? ORDER BY recorded_at DESC LIMIT 1`, taskID)
+		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,content_error_count,latency_json,warmup,recorded_at
+		FROM task_metrics WHERE task_id=? ORDER BY recorded_at DESC, id DESC LIMIT 1`, taskID)
 	m := &model.TaskMetrics{}
 	err := row.Scan(&m.ID, &m.TaskID, &m.AgentID, &m.BytesTotal, &m.BytesDelta,
-		&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.RecordedAt)
+		&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.ContentErrorCount, &m.LatencyJSON, &m.Warmup, &m.RecordedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	m.Normalize()
 	return m, err
 }
 
 func (s *taskMetricsStore) LatestByTaskAgents(ctx context.Context, taskID string) ([]*model.TaskMetrics, error) {
+	return s.latestPerAgent(ctx, taskID, "")
+}
+
+func (s *taskMetricsStore) LatestWarmupByTaskAgents(ctx context.Context, taskID string) ([]*model.TaskMetrics, error) {
+	return s.latestPerAgent(ctx, taskID, " AND warmup=1")
+}
+
+// latestPerAgent returns each agent's newest sample for taskID among the rows
+// matching the extra WHERE condition. Of samples recorded in the same
+// second, the last inserted counts as the newest.
+func (s *taskMetricsStore) latestPerAgent(ctx context.Context, taskID, cond string) ([]*model.TaskMetrics, error) {
 	rows, err := s.ro.QueryContext(ctx, `
-		SELECT tm.id,tm.task_id,tm.agent_id,tm.bytes_total,tm.bytes_delta,tm.rate_mbps_5s,tm.rate_mbps_30s,tm.request_count,tm.error_count,tm.recorded_at
-		FROM task_metrics tm
-		INNER JOIN (
-			SELECT agent_id, MAX(recorded_at) AS max_recorded_at
+		SELECT id,task_id,agent_id,bytes_total,bytes_delta,rate_mbps_5s,rate_mbps_30s,request_count,error_count,content_error_count,latency_json,warmup,recorded_at
+		FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY agent_id ORDER BY recorded_at DESC, id DESC) AS rn
 			FROM task_metrics
-			WHERE task_id=?
-			GROUP BY agent_id
-		) latest
-			ON latest.agent_id = tm.agent_id
-			AND latest.max_recorded_at = tm.recorded_at
-		WHERE tm.task_id=?
-		ORDER BY tm.agent_id ASC`, taskID, taskID)
+			WHERE task_id=?`+cond+`
+		)
+		WHERE rn=1
+		ORDER BY agent_id ASC`, taskID)
 	if err != nil {
 		return nil, err
 	}
@@ -79,34 +92,275 @@ func (s *taskMetricsStore) LatestByTaskAgents(ctx context.Context, taskID string
 	for rows.Next() {
 		m := &model.TaskMetrics{}
 		if err := rows.Scan(&m.ID, &m.TaskID, &m.AgentID, &m.BytesTotal, &m.BytesDelta,
-			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.RecordedAt); err != nil {
+			&m.RateMbps5s, &m.RateMbps30s, &m.RequestCount, &m.ErrorCount, &m.ContentErrorCount, &m.LatencyJSON, &m.Warmup, &m.RecordedAt); err != nil {
 			return nil, err
 		}
+		m.Normalize()
 		list = append(list, m)
 	}
 	return list, rows.Err()
 }
 
+// ─── Task results ─────────────────────────────────────────────────────────────
+
+type taskResultStore struct{ db, ro dbtx }
+
+func (s *taskResultStore) Upsert(ctx context.Context, r *model.TaskResult) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO task_results (task_id,status,end_reason,bytes_total,request_count,error_count,avg_rate_mbps,p95_rate_mbps,duration_sec,started_at,finished_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?)
+		ON CONFLICT(task_id) DO UPDATE SET
+			status=excluded.status, end_reason=excluded.end_reason, bytes_total=excluded.bytes_total,
+			request_count=excluded.request_count, error_count=excluded.error_count,
+			avg_rate_mbps=excluded.avg_rate_mbps, p95_rate_mbps=excluded.p95_rate_mbps,
+			duration_sec=excluded.duration_sec, started_at=excluded.started_at, finished_at=excluded.finished_at`,
+		r.TaskID, r.Status, r.EndReason, r.BytesTotal, r.RequestCount, r.ErrorCount,
+		r.AvgRateMbps, r.P95RateMbps, r.DurationSec, nullTime(r.StartedAt), r.FinishedAt.UTC(),
+	)
+	return err
+}
+
+func (s *taskResultStore) Get(ctx context.Context, taskID string) (*model.TaskResult, error) {
+	row := s.ro.QueryRowContext(ctx, `
+		SELECT task_id,status,end_reason,bytes_total,request_count,error_count,avg_rate_mbps,p95_rate_mbps,duration_sec,started_at,finished_at
+		FROM task_results WHERE task_id=?`, taskID)
+	r := &model.TaskResult{}
+	var startedAt sql.NullTime
+	err := row.Scan(&r.TaskID, &r.Status, &r.EndReason, &r.BytesTotal, &r.RequestCount, &r.ErrorCount,
+		&r.AvgRateMbps, &r.P95RateMbps, &r.DurationSec, &startedAt, &r.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("task result %w", store.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.StartedAt = scanNullTime(startedAt)
+	return r, nil
+}
+
+func (s *taskResultStore) UpsertAgent(ctx context.Context, r *model.AgentTaskResult) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO task_agent_results (task_id,agent_id,stop_reason,error,bytes_total,request_count,error_count,duration_sec,reported_at)
+		VALUES (?,?,?,?,?,?,?,?,?)
+		ON CONFLICT(task_id,agent_id) DO UPDATE SET
+			stop_reason=excluded.stop_reason, error=excluded.error, bytes_total=excluded.bytes_total,
+			request_count=excluded.request_count, error_count=excluded.error_count,
+			duration_sec=excluded.duration_sec, reported_at=excluded.reported_at`,
+		r.TaskID, r.AgentID, r.StopReason, r.Error, r.BytesTotal, r.RequestCount, r.ErrorCount,
+		r.DurationSec, r.ReportedAt.UTC(),
+	)
+	return err
+}
+
+func (s *taskResultStore) ListAgents(ctx context.Context, taskID string) ([]*model.AgentTaskResult, error) {
+	rows, err := s.ro.QueryContext(ctx, `
+		SELECT task_id,agent_id,stop_reason,error,bytes_total,request_count,error_count,duration_sec,reported_at
+		FROM task_agent_results WHERE task_id=? ORDER BY agent_id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*model.AgentTaskResult
+	for rows.Next() {
+		r := &model.AgentTaskResult{}
+		if err := rows.Scan(&r.TaskID, &r.AgentID, &r.StopReason, &r.Error, &r.BytesTotal, &r.RequestCount, &r.ErrorCount,
+			&r.DurationSec, &r.ReportedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
 // ─── Bandwidth ────────────────────────────────────────────────────────────────
 
-type bandwidthStore struct{ db, ro *sql.DB }
+// Bandwidth samples arrive with every agent heartbeat. Rather than two
+// statements per sample on the single writer, bandwidthStore buffers them
+// and writes each batch in one transaction of multi-row inserts.
+const (
+	bandwidthFlushInterval = 5 * time.Second
+	bandwidthBatchSize     = 200 // rows per INSERT, within SQLite's bound-variable limit
+)
+
+// bandwidthStore reads and writes bandwidth samples. Outside transactions
+// inserted samples are buffered until a background flusher writes them,
+// every bandwidthFlushInterval or as soon as bandwidthBatchSize are waiting.
+// Reads and purges flush first, so they see every sample inserted before
+// them.
+type bandwidthStore struct {
+	db, ro dbtx
+	// buf holds inserted samples until they are written; nil in
+	// transactions, which write samples straight away.
+	buf *sampleBuffer
+}
+
+func newBandwidthStore(db, ro *sql.DB) *bandwidthStore {
+	buf := &sampleBuffer{
+		db:      db,
+		full:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go buf.run()
+	return &bandwidthStore{db: db, ro: ro, buf: buf}
+}
 
 func (s *bandwidthStore) Insert(ctx context.Context, bs *model.BandwidthSample) error {
-	unix := bs.RecordedAt.Unix()
-	_, err := s.db.ExecContext(ctx, `INSERT INTO bandwidth_samples(agent_id,rate_mbps,recorded_at,ts) VALUES(?,?,?,?)`,
-		bs.AgentID, bs.RateMbps, bs.RecordedAt.UTC().Format("2006-01-02 15:04:05"), unix)
+	if s.buf == nil {
+		return writeSamples(ctx, s.db, []*model.BandwidthSample{bs})
+	}
+	s.buf.add(bs)
+	return nil
+}
+
+// flush writes the buffered samples, if any, before a read or purge. It
+// ignores ctx's cancellation, since the caller is often an HTTP request
+// whose client may go away while another's samples are being written, and
+// only logs a failure: the read goes ahead without the lost batch.
+func (s *bandwidthStore) flush(ctx context.Context) {
+	if s.buf == nil {
+		return
+	}
+	if err := s.buf.flush(context.WithoutCancel(ctx)); err != nil {
+		slog.Error("flush bandwidth samples", "err", err)
+	}
+}
+
+// sampleBuffer collects bandwidth samples and writes them in batches.
+type sampleBuffer struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	pending []*model.BandwidthSample
+	flushMu sync.Mutex // held while a batch is taken and written
+
+	full    chan struct{} // signals the flusher that a batch is waiting
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func (b *sampleBuffer) run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(bandwidthFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		if err := b.flush(context.Background()); err != nil {
+			slog.Error("flush bandwidth samples", "err", err)
+		}
+	}
+}
+
+// close stops the flusher and writes the samples still buffered.
+func (b *sampleBuffer) close() error {
+	close(b.stop)
+	<-b.stopped
+	return b.flush(context.Background())
+}
+
+func (b *sampleBuffer) add(bs *model.BandwidthSample) {
+	sample := *bs
+	b.mu.Lock()
+	b.pending = append(b.pending, &sample)
+	full := len(b.pending) >= bandwidthBatchSize
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush writes the buffered samples in one transaction. A failed batch is
+// dropped.
+func (b *sampleBuffer) flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	tx, err := b.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("%d samples dropped: %w", len(batch), err)
 	}
-	// Update pre-aggregated 1-minute bucket
-	bucket := (unix / 60) * 60
-	_, err = s.db.ExecContext(ctx, `INSERT INTO bandwidth_agg(bucket,sum_mbps,max_mbps,cnt) VALUES(?,?,?,1)
-		ON CONFLICT(bucket) DO UPDATE SET sum_mbps=sum_mbps+excluded.sum_mbps, max_mbps=MAX(max_mbps,excluded.max_mbps), cnt=cnt+1`,
-		bucket, bs.RateMbps, bs.RateMbps)
-	return err
+	defer tx.Rollback()
+	if err := writeSamples(ctx, tx, batch); err != nil {
+		return fmt.Errorf("%d samples dropped: %w", len(batch), err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%d samples dropped: %w", len(batch), err)
+	}
+	return nil
+}
+
+// writeSamples inserts samples, and updates their pre-aggregated 1-minute
+// buckets, with multi-row statements.
+func writeSamples(ctx context.Context, db dbtx, samples []*model.BandwidthSample) error {
+	type agg struct {
+		sum, max float64
+		cnt      int
+	}
+	buckets := map[int64]*agg{}
+	var order []int64
+	for _, bs := range samples {
+		bucket := (bs.RecordedAt.Unix() / 60) * 60
+		a, ok := buckets[bucket]
+		if !ok {
+			a = &agg{max: bs.RateMbps}
+			buckets[bucket] = a
+			order = append(order, bucket)
+		}
+		a.sum += bs.RateMbps
+		a.max = max(a.max, bs.RateMbps)
+		a.cnt++
+	}
+
+	for start := 0; start < len(samples); start += bandwidthBatchSize {
+		chunk := samples[start:min(start+bandwidthBatchSize, len(samples))]
+		args := make([]any, 0, 4*len(chunk))
+		for _, bs := range chunk {
+			args = append(args, bs.AgentID, bs.RateMbps, bs.RecordedAt.UTC().Format("2006-01-02 15:04:05"), bs.RecordedAt.Unix())
+		}
+		if _, err := db.ExecContext(ctx, `INSERT INTO bandwidth_samples(agent_id,rate_mbps,recorded_at,ts) VALUES`+
+			valueRows(len(chunk), 4), args...); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(order); start += bandwidthBatchSize {
+		chunk := order[start:min(start+bandwidthBatchSize, len(order))]
+		args := make([]any, 0, 4*len(chunk))
+		for _, bucket := range chunk {
+			a := buckets[bucket]
+			args = append(args, bucket, a.sum, a.max, a.cnt)
+		}
+		if _, err := db.ExecContext(ctx, `INSERT INTO bandwidth_agg(bucket,sum_mbps,max_mbps,cnt) VALUES`+valueRows(len(chunk), 4)+`
+			ON CONFLICT(bucket) DO UPDATE SET sum_mbps=sum_mbps+excluded.sum_mbps, max_mbps=MAX(max_mbps,excluded.max_mbps), cnt=cnt+excluded.cnt`,
+			args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valueRows returns the VALUES list of a multi-row INSERT: n rows of cols
+// placeholders each.
+func valueRows(n, cols int) string {
+	row := "(" + strings.Repeat("?,", cols-1) + "?)"
+	return strings.Repeat(row+",", n-1) + row
 }
 
 func (s *bandwidthStore) History(ctx context.Context, agentID string, from, to time.Time) ([]*model.BandwidthSample, error) {
+	s.flush(ctx)
 	rows, err := s.ro.QueryContext(ctx, `
 		SELECT id,agent_id,rate_mbps,recorded_at FROM bandwidth_samples
 		WHERE agent_id=? AND recorded_at BETWEEN ? AND ? ORDER BY recorded_at ASC`,
@@ -126,23 +380,53 @@ func (s *bandwidthStore) History(ctx context.Context, agentID string, from, to t
 	return list, rows.Err()
 }
 
-func (s *bandwidthStore) AggregateHistory(ctx context.Context, from, to time.Time, stepSec int) ([]store.BandwidthPoint, error) {
+func (s *bandwidthStore) AggregateHistory(ctx context.Context, from, to time.Time, stepSec int, agg store.BandwidthAgg) ([]store.BandwidthPoint, error) {
+	fn := agg.SQL()
+	if fn == "" {
+		return nil, fmt.Errorf("unknown bandwidth aggregation %q", agg)
+	}
+	s.flush(ctx)
 	// Two-level aggregation: first AVG per agent per bucket, then SUM across agents.
 	rows, err := s.ro.QueryContext(ctx, fmt.Sprintf(`
-		SELECT bucket, SUM(agent_avg), MAX(agent_avg)
+		SELECT bucket, SUM(agent_avg), MAX(agent_avg), %s(agent_avg)
 		FROM (
 			SELECT (ts / %d) * %d as bucket, agent_id, AVG(rate_mbps) as agent_avg
 			FROM bandwidth_samples
 			WHERE ts BETWEEN ? AND ?
 			GROUP BY bucket, agent_id
 		)
-		GROUP BY bucket ORDER BY bucket ASC`, stepSec, stepSec),
+		GROUP BY bucket ORDER BY bucket ASC`, fn, stepSec, stepSec),
 		from.Unix(), to.Unix())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var result []store.BandwidthPoint
+	for rows.Next() {
+		var p store.BandwidthPoint
+		var bucketUnix int64
+		if err := rows.Scan(&bucketUnix, &p.AvgMbps, &p.MaxMbps, &p.Mbps); err != nil {
+			return nil, err
+		}
+		p.Ts = time.Unix(bucketUnix, 0).UTC()
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func (s *bandwidthStore) AgentHistory(ctx context.Context, agentID string, from, to time.Time, stepSec int) ([]store.BandwidthPoint, error) {
+	s.flush(ctx)
+	rows, err := s.ro.QueryContext(ctx, fmt.Sprintf(`
+		SELECT (ts / %d) * %d as bucket, AVG(rate_mbps), MAX(rate_mbps)
+		FROM bandwidth_samples
+		WHERE agent_id = ? AND ts BETWEEN ? AND ?
+		GROUP BY bucket ORDER BY bucket ASC`, stepSec, stepSec),
+		agentID, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []store.BandwidthPoint
 	for rows.Next() {
 		var p store.BandwidthPoint
 		var bucketUnix int64
@@ -150,12 +434,14 @@ func (s *bandwidthStore) AggregateHistory(ctx context.Context, from, to time.Tim
 			return nil, err
 		}
 		p.Ts = time.Unix(bucketUnix, 0).UTC()
+		p.Mbps = p.AvgMbps
 		result = append(result, p)
 	}
 	return result, rows.Err()
 }
 
 func (s *bandwidthStore) PurgeOlderThan(ctx context.Context, before time.Time) error {
+	s.flush(ctx)
 	unix := before.Unix()
 	if _, err := s.db.ExecContext(ctx, `DELETE FROM bandwidth_samples WHERE ts < ?`, unix); err != nil {
 		return err
@@ -165,6 +451,7 @@ func (s *bandwidthStore) PurgeOlderThan(ctx context.Context, before time.Time) e
 }
 
 func (s *bandwidthStore) TotalCurrent(ctx context.Context, since time.Time) (float64, error) {
+	s.flush(ctx)
 	// Sum of the latest rate_mbps per agent
 	row := s.ro.QueryRowContext(ctx, `
 		SELECT COALESCE(SUM(rate_mbps),0) FROM (
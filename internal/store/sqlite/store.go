@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -14,17 +15,22 @@ import (
 
 // sqliteStore implements store.Store.
 type sqliteStore struct {
-	db       *sql.DB
-	roDB     *sql.DB
-	agents   *agentStore
-	tasks    *taskStore
-	metrics  *taskMetricsStore
-	profiles *trafficProfileStore
-	pools    *urlPoolStore
-	groups   *taskGroupStore
-	jobs     *provisionJobStore
-	bw       *bandwidthStore
-	creds    *credentialStore
+	db          *sql.DB
+	roDB        *sql.DB
+	roDSN       string // opens Backup's connection; empty for :memory:
+	agents      *agentStore
+	tasks       *taskStore
+	metrics     *taskMetricsStore
+	results     *taskResultStore
+	profiles    *trafficProfileStore
+	pools       *urlPoolStore
+	groups      *taskGroupStore
+	agentGroups *agentGroupStore
+	templates   *taskTemplateStore
+	maintenance *maintenanceWindowStore
+	jobs        *provisionJobStore
+	bw          *bandwidthStore
+	creds       *credentialStore
 }
 
 // New opens (or creates) a SQLite database and runs migrations.
@@ -42,13 +48,14 @@ func New(dsn string) (store.Store, error) {
 	// This prevents dashboard reads from queuing behind heartbeat writes.
 	// For :memory: databases (tests), reuse the same connection since separate connections
 	// cannot access the same in-memory database.
-	roDB := db
+	roDB, roDSN := db, ""
 	if dsn != ":memory:" {
 		sep := "?"
 		if strings.Contains(dsn, "?") {
 			sep = "&"
 		}
-		roDB, err = sql.Open("sqlite", dsn+sep+"_pragma=busy_timeout(5000)")
+		roDSN = dsn + sep + "_pragma=busy_timeout(5000)"
+		roDB, err = sql.Open("sqlite", roDSN)
 		if err != nil {
 			return nil, fmt.Errorf("sqlite open read pool: %w", err)
 		}
@@ -62,32 +69,45 @@ func New(dsn string) (store.Store, error) {
 			return nil, fmt.Errorf("ro pragma query_only: %w", err)
 		}
 	}
-	s := &sqliteStore{
-		db:       db,
-		roDB:     roDB,
-		agents:   &agentStore{db: db, ro: roDB},
-		tasks:    &taskStore{db: db, ro: roDB},
-		metrics:  &taskMetricsStore{db: db, ro: roDB},
-		profiles: &trafficProfileStore{db},
-		pools:    &urlPoolStore{db},
-		groups:   &taskGroupStore{db: db, ro: roDB},
-		jobs:     &provisionJobStore{db},
-		bw:       &bandwidthStore{db: db, ro: roDB},
-		creds:    &credentialStore{db},
-	}
+	s := &sqliteStore{db: db, roDB: roDB, roDSN: roDSN, bw: newBandwidthStore(db, roDB)}
+	s.bind(db, roDB)
 	return s, nil
 }
 
-func (s *sqliteStore) Agents() store.AgentStore                   { return s.agents }
-func (s *sqliteStore) Tasks() store.TaskStore                     { return s.tasks }
-func (s *sqliteStore) TaskMetrics() store.TaskMetricsStore        { return s.metrics }
-func (s *sqliteStore) TrafficProfiles() store.TrafficProfileStore { return s.profiles }
-func (s *sqliteStore) URLPools() store.URLPoolStore               { return s.pools }
-func (s *sqliteStore) TaskGroups() store.TaskGroupStore           { return s.groups }
-func (s *sqliteStore) ProvisionJobs() store.ProvisionJobStore     { return s.jobs }
-func (s *sqliteStore) Bandwidth() store.BandwidthStore            { return s.bw }
-func (s *sqliteStore) Credentials() store.CredentialStore         { return s.creds }
+// bind points the sub-stores, except bandwidth, at db for writes and ro for
+// reads.
+func (s *sqliteStore) bind(db, ro dbtx) {
+	s.agents = &agentStore{db: db, ro: ro}
+	s.tasks = &taskStore{db: db, ro: ro}
+	s.metrics = &taskMetricsStore{db: db, ro: ro}
+	s.results = &taskResultStore{db: db, ro: ro}
+	s.profiles = &trafficProfileStore{db}
+	s.pools = &urlPoolStore{db}
+	s.groups = &taskGroupStore{db: db, ro: ro}
+	s.agentGroups = &agentGroupStore{db: db, ro: ro}
+	s.templates = &taskTemplateStore{db}
+	s.maintenance = &maintenanceWindowStore{db}
+	s.jobs = &provisionJobStore{db}
+	s.creds = &credentialStore{db}
+}
+
+func (s *sqliteStore) Agents() store.AgentStore                         { return s.agents }
+func (s *sqliteStore) Tasks() store.TaskStore                           { return s.tasks }
+func (s *sqliteStore) TaskMetrics() store.TaskMetricsStore              { return s.metrics }
+func (s *sqliteStore) TaskResults() store.TaskResultStore               { return s.results }
+func (s *sqliteStore) TrafficProfiles() store.TrafficProfileStore       { return s.profiles }
+func (s *sqliteStore) URLPools() store.URLPoolStore                     { return s.pools }
+func (s *sqliteStore) TaskGroups() store.TaskGroupStore                 { return s.groups }
+func (s *sqliteStore) AgentGroups() store.AgentGroupStore               { return s.agentGroups }
+func (s *sqliteStore) TaskTemplates() store.TaskTemplateStore           { return s.templates }
+func (s *sqliteStore) MaintenanceWindows() store.MaintenanceWindowStore { return s.maintenance }
+func (s *sqliteStore) ProvisionJobs() store.ProvisionJobStore           { return s.jobs }
+func (s *sqliteStore) Bandwidth() store.BandwidthStore                  { return s.bw }
+func (s *sqliteStore) Credentials() store.CredentialStore               { return s.creds }
 func (s *sqliteStore) Close() error {
+	if err := s.bw.buf.close(); err != nil {
+		slog.Error("flush bandwidth samples on close", "err", err)
+	}
 	if s.roDB != s.db {
 		s.roDB.Close()
 	}
@@ -103,25 +123,48 @@ func migrate(db *sql.DB) error {
 		`CREATE TABLE IF NOT EXISTS agents (
 			id TEXT PRIMARY KEY,
 			hostname TEXT NOT NULL DEFAULT '',
+			machine_id TEXT NOT NULL DEFAULT '',
 			ip TEXT NOT NULL DEFAULT '',
 			port INTEGER NOT NULL DEFAULT 0,
 			token TEXT NOT NULL DEFAULT '',
 			status TEXT NOT NULL DEFAULT 'offline',
 			version TEXT NOT NULL DEFAULT '',
+			commit_hash TEXT NOT NULL DEFAULT '',
+			tags_json TEXT NOT NULL DEFAULT '[]',
 			current_rate_mbps REAL NOT NULL DEFAULT 0,
+			task_rate_mbps REAL NOT NULL DEFAULT 0,
+			tx_rate_mbps REAL NOT NULL DEFAULT 0,
+			rate_measured INTEGER NOT NULL DEFAULT 0,
+			capacity_mbps REAL NOT NULL DEFAULT 0,
+			enabled INTEGER NOT NULL DEFAULT 1,
 			last_heartbeat DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);`,
+		`CREATE TABLE IF NOT EXISTS agent_groups (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS agent_group_members (
+			group_id TEXT NOT NULL,
+			agent_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, agent_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_agent_group_members_agent ON agent_group_members(agent_id);`,
 		`CREATE TABLE IF NOT EXISTS tasks (
 			id TEXT PRIMARY KEY,
 			group_id TEXT NOT NULL DEFAULT '',
 			name TEXT NOT NULL DEFAULT '',
+			external_id TEXT NOT NULL DEFAULT '',
 			type TEXT NOT NULL DEFAULT 'static',
 			url_pool_id TEXT NOT NULL DEFAULT '',
 			target_url TEXT NOT NULL DEFAULT '',
 			target_urls_json TEXT NOT NULL DEFAULT '[]',
 			agent_id TEXT NOT NULL DEFAULT '',
+			agent_group_id TEXT NOT NULL DEFAULT '',
 			execution_scope TEXT NOT NULL DEFAULT 'single_agent',
 			status TEXT NOT NULL DEFAULT 'pending',
 			target_rate_mbps REAL NOT NULL DEFAULT 0,
@@ -137,12 +180,26 @@ func migrate(db *sql.DB) error {
 			ramp_up_sec INTEGER NOT NULL DEFAULT 0,
 			ramp_down_sec INTEGER NOT NULL DEFAULT 0,
 			traffic_profile_id TEXT NOT NULL DEFAULT '',
+			timezone TEXT NOT NULL DEFAULT '',
 			concurrent_fragments INTEGER NOT NULL DEFAULT 1,
+			reuse_connections INTEGER NOT NULL DEFAULT 1,
+			range_chunk_bytes INTEGER NOT NULL DEFAULT 0,
+			read_chunk_bytes INTEGER NOT NULL DEFAULT 0,
+			burst_bytes INTEGER NOT NULL DEFAULT 0,
+			cache_bust_param TEXT NOT NULL DEFAULT '',
+			redirects TEXT NOT NULL DEFAULT '',
+			max_redirects INTEGER NOT NULL DEFAULT 0,
+			expected_bytes INTEGER NOT NULL DEFAULT 0,
+			expected_sha256 TEXT NOT NULL DEFAULT '',
+			target_rps REAL NOT NULL DEFAULT 0,
+			warmup_sec INTEGER NOT NULL DEFAULT 0,
 			retries INTEGER NOT NULL DEFAULT 3,
 			total_bytes_done INTEGER NOT NULL DEFAULT 0,
+			paused_sec INTEGER NOT NULL DEFAULT 0,
 			error_message TEXT NOT NULL DEFAULT '',
 			dispatched_at DATETIME,
 			started_at DATETIME,
+			paused_at DATETIME,
 			finished_at DATETIME,
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
@@ -157,15 +214,45 @@ func migrate(db *sql.DB) error {
 			rate_mbps_30s REAL NOT NULL DEFAULT 0,
 			request_count INTEGER NOT NULL DEFAULT 0,
 			error_count INTEGER NOT NULL DEFAULT 0,
+			content_error_count INTEGER NOT NULL DEFAULT 0,
+			latency_json TEXT NOT NULL DEFAULT '',
+			warmup INTEGER NOT NULL DEFAULT 0,
 			recorded_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);`,
 		`CREATE INDEX IF NOT EXISTS idx_task_metrics_task_id ON task_metrics(task_id, recorded_at);`,
+		`CREATE TABLE IF NOT EXISTS task_results (
+			task_id TEXT PRIMARY KEY,
+			status TEXT NOT NULL DEFAULT '',
+			end_reason TEXT NOT NULL DEFAULT '',
+			bytes_total INTEGER NOT NULL DEFAULT 0,
+			request_count INTEGER NOT NULL DEFAULT 0,
+			error_count INTEGER NOT NULL DEFAULT 0,
+			avg_rate_mbps REAL NOT NULL DEFAULT 0,
+			p95_rate_mbps REAL NOT NULL DEFAULT 0,
+			duration_sec REAL NOT NULL DEFAULT 0,
+			started_at DATETIME,
+			finished_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS task_agent_results (
+			task_id TEXT NOT NULL,
+			agent_id TEXT NOT NULL,
+			stop_reason TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			bytes_total INTEGER NOT NULL DEFAULT 0,
+			request_count INTEGER NOT NULL DEFAULT 0,
+			error_count INTEGER NOT NULL DEFAULT 0,
+			duration_sec REAL NOT NULL DEFAULT 0,
+			reported_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (task_id, agent_id)
+		);`,
 		`CREATE TABLE IF NOT EXISTS traffic_profiles (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL DEFAULT '',
+			external_id TEXT NOT NULL DEFAULT '',
 			description TEXT NOT NULL DEFAULT '',
 			distribution TEXT NOT NULL DEFAULT 'flat',
 			points TEXT NOT NULL DEFAULT '[]',
+			timezone TEXT NOT NULL DEFAULT '',
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);`,
 		`CREATE TABLE IF NOT EXISTS url_pools (
@@ -177,12 +264,33 @@ func migrate(db *sql.DB) error {
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);`,
+		`CREATE TABLE IF NOT EXISTS task_templates (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			spec_json TEXT NOT NULL DEFAULT '{}',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS maintenance_windows (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			start_at DATETIME,
+			end_at DATETIME,
+			daily_start TEXT NOT NULL DEFAULT '',
+			daily_end TEXT NOT NULL DEFAULT '',
+			timezone TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
 		`CREATE TABLE IF NOT EXISTS task_groups (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL DEFAULT '',
 			description TEXT NOT NULL DEFAULT '',
 			pool_ids_json TEXT NOT NULL DEFAULT '[]',
 			agent_id TEXT NOT NULL DEFAULT '',
+			agent_group_id TEXT NOT NULL DEFAULT '',
 			execution_scope TEXT NOT NULL DEFAULT 'single_agent',
 			target_rate_mbps REAL NOT NULL DEFAULT 0,
 			start_at DATETIME,
@@ -197,7 +305,19 @@ func migrate(db *sql.DB) error {
 			ramp_up_sec INTEGER NOT NULL DEFAULT 0,
 			ramp_down_sec INTEGER NOT NULL DEFAULT 0,
 			traffic_profile_id TEXT NOT NULL DEFAULT '',
+			timezone TEXT NOT NULL DEFAULT '',
 			concurrent_fragments INTEGER NOT NULL DEFAULT 1,
+			reuse_connections INTEGER NOT NULL DEFAULT 1,
+			range_chunk_bytes INTEGER NOT NULL DEFAULT 0,
+			read_chunk_bytes INTEGER NOT NULL DEFAULT 0,
+			burst_bytes INTEGER NOT NULL DEFAULT 0,
+			cache_bust_param TEXT NOT NULL DEFAULT '',
+			redirects TEXT NOT NULL DEFAULT '',
+			max_redirects INTEGER NOT NULL DEFAULT 0,
+			expected_bytes INTEGER NOT NULL DEFAULT 0,
+			expected_sha256 TEXT NOT NULL DEFAULT '',
+			warmup_sec INTEGER NOT NULL DEFAULT 0,
+			stagger_sec INTEGER NOT NULL DEFAULT 0,
 			retries INTEGER NOT NULL DEFAULT 3,
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
@@ -209,11 +329,18 @@ func migrate(db *sql.DB) error {
 			ssh_user TEXT NOT NULL DEFAULT '',
 			auth_type TEXT NOT NULL DEFAULT 'key',
 			credential_ref TEXT NOT NULL DEFAULT '',
+			sudo_credential_ref TEXT NOT NULL DEFAULT '',
+			install_dir TEXT NOT NULL DEFAULT '',
+			service_name TEXT NOT NULL DEFAULT '',
+			agent_env_json TEXT NOT NULL DEFAULT '{}',
+			download_url TEXT NOT NULL DEFAULT '',
+			agent_token TEXT NOT NULL DEFAULT '',
 			status TEXT NOT NULL DEFAULT 'pending',
 			current_step TEXT NOT NULL DEFAULT '',
 			log TEXT NOT NULL DEFAULT '',
 			agent_id TEXT NOT NULL DEFAULT '',
 			failed_step TEXT NOT NULL DEFAULT '',
+			step_durations TEXT NOT NULL DEFAULT '{}',
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);`,
@@ -238,6 +365,30 @@ func migrate(db *sql.DB) error {
 			return fmt.Errorf("exec %q: %w", stmt[:min(40, len(stmt))], err)
 		}
 	}
+	if err := ensureColumn(db, "agents", "machine_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "agents", "tags_json", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "agents", "task_rate_mbps", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "agents", "tx_rate_mbps", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "agents", "rate_measured", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "agents", "commit_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "agents", "enabled", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "agents", "capacity_mbps", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
 	if err := ensureColumn(db, "tasks", "target_urls_json", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
 		return err
 	}
@@ -250,6 +401,138 @@ func migrate(db *sql.DB) error {
 	if err := ensureColumn(db, "tasks", "execution_scope", "TEXT NOT NULL DEFAULT 'single_agent'"); err != nil {
 		return err
 	}
+	if err := ensureColumn(db, "tasks", "timezone", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "timezone", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "traffic_profiles", "timezone", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "reuse_connections", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "reuse_connections", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "range_chunk_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "range_chunk_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "read_chunk_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "read_chunk_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "burst_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "burst_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "cache_bust_param", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "cache_bust_param", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "redirects", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "max_redirects", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "redirects", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "max_redirects", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "expected_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "expected_sha256", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "expected_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "expected_sha256", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "target_rps", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "warmup_sec", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "warmup_sec", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_metrics", "latency_json", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_metrics", "warmup", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_metrics", "content_error_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "agent_group_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "agent_group_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "task_groups", "stagger_sec", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "external_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "traffic_profiles", "external_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "paused_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "tasks", "paused_sec", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "provision_jobs", "sudo_credential_ref", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "provision_jobs", "install_dir", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "provision_jobs", "service_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "provision_jobs", "agent_env_json", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "provision_jobs", "agent_token", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "provision_jobs", "step_durations", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "provision_jobs", "download_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// External ids are optional but unique when set
+	for _, stmt := range []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_external_id ON tasks(external_id) WHERE external_id <> ''`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_traffic_profiles_external_id ON traffic_profiles(external_id) WHERE external_id <> ''`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("create external id index: %w", err)
+		}
+	}
 	// Add unix timestamp column for fast aggregation (avoids strftime on every row)
 	if err := ensureColumn(db, "bandwidth_samples", "ts", "INTEGER NOT NULL DEFAULT 0"); err != nil {
 		return err
@@ -348,3 +631,11 @@ func checkCtx(ctx context.Context) error {
 		return nil
 	}
 }
+
+// mapConflict converts unique-constraint violations to store.ErrConflict.
+func mapConflict(err error) error {
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return fmt.Errorf("%w: %v", store.ErrConflict, err)
+	}
+	return err
+}
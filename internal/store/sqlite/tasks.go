@@ -8,36 +8,37 @@ import (
 	"time"
 
 	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
 )
 
-type taskStore struct{ db, ro *sql.DB }
+type taskStore struct{ db, ro dbtx }
 
-const taskCols = `id,group_id,name,type,url_pool_id,target_url,target_urls_json,agent_id,execution_scope,status,target_rate_mbps,
+const taskCols = `id,group_id,name,external_id,type,url_pool_id,target_url,target_urls_json,agent_id,agent_group_id,execution_scope,status,target_rate_mbps,
 start_at,end_at,duration_sec,total_bytes_target,total_requests_target,
 dispatch_rate_tpm,dispatch_batch_size,distribution,jitter_pct,ramp_up_sec,ramp_down_sec,
-traffic_profile_id,concurrent_fragments,retries,total_bytes_done,error_message,
-dispatched_at,started_at,finished_at,created_at,updated_at`
+traffic_profile_id,timezone,concurrent_fragments,reuse_connections,range_chunk_bytes,read_chunk_bytes,burst_bytes,cache_bust_param,redirects,max_redirects,expected_bytes,expected_sha256,target_rps,warmup_sec,retries,total_bytes_done,paused_sec,error_message,
+dispatched_at,started_at,paused_at,finished_at,created_at,updated_at`
 
 func (s *taskStore) Create(ctx context.Context, t *model.Task) error {
 	t.Normalize()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO tasks (id,group_id,name,type,url_pool_id,target_url,target_urls_json,agent_id,execution_scope,status,target_rate_mbps,
+		INSERT INTO tasks (id,group_id,name,external_id,type,url_pool_id,target_url,target_urls_json,agent_id,agent_group_id,execution_scope,status,target_rate_mbps,
 			start_at,end_at,duration_sec,total_bytes_target,total_requests_target,
 			dispatch_rate_tpm,dispatch_batch_size,distribution,jitter_pct,ramp_up_sec,ramp_down_sec,
-			traffic_profile_id,concurrent_fragments,retries,total_bytes_done,error_message,
-			dispatched_at,started_at,finished_at,created_at,updated_at)
-		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
-		t.ID, t.GroupID, t.Name, t.Type, t.URLPoolID, t.TargetURL, t.TargetURLsJSON, t.AgentID, t.ExecutionScope, t.Status, t.TargetRateMbps,
+			traffic_profile_id,timezone,concurrent_fragments,reuse_connections,range_chunk_bytes,read_chunk_bytes,burst_bytes,cache_bust_param,redirects,max_redirects,expected_bytes,expected_sha256,target_rps,warmup_sec,retries,total_bytes_done,paused_sec,error_message,
+			dispatched_at,started_at,paused_at,finished_at,created_at,updated_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		t.ID, t.GroupID, t.Name, t.ExternalID, t.Type, t.URLPoolID, t.TargetURL, t.TargetURLsJSON, t.AgentID, t.AgentGroupID, t.ExecutionScope, t.Status, t.TargetRateMbps,
 		nullTime(t.StartAt), nullTime(t.EndAt), t.DurationSec,
 		t.TotalBytesTarget, t.TotalRequestsTarget,
 		t.DispatchRateTpm, t.DispatchBatchSize, t.Distribution,
 		t.JitterPct, t.RampUpSec, t.RampDownSec,
-		t.TrafficProfileID, t.ConcurrentFragments, t.Retries,
-		t.TotalBytesDone, t.ErrorMessage,
-		nullTime(t.DispatchedAt), nullTime(t.StartedAt), nullTime(t.FinishedAt),
+		t.TrafficProfileID, t.Timezone, t.ConcurrentFragments, t.ReuseConnections, t.RangeChunkBytes, t.ReadChunkBytes, t.BurstBytes, t.CacheBustParam, t.Redirects, t.MaxRedirects, t.ExpectedBytes, t.ExpectedSHA256, t.TargetRps, t.WarmupSec, t.Retries,
+		t.TotalBytesDone, t.PausedSec, t.ErrorMessage,
+		nullTime(t.DispatchedAt), nullTime(t.StartedAt), nullTime(t.PausedAt), nullTime(t.FinishedAt),
 		t.CreatedAt.UTC(), t.UpdatedAt.UTC(),
 	)
-	return err
+	return mapConflict(err)
 }
 
 func (s *taskStore) Get(ctx context.Context, id string) (*model.Task, error) {
@@ -80,6 +81,24 @@ func (s *taskStore) ListByAgent(ctx context.Context, agentID string, statuses []
 	return scanTasks(rows)
 }
 
+func (s *taskStore) Update(ctx context.Context, t *model.Task) error {
+	t.Normalize()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE tasks SET name=?,external_id=?,type=?,url_pool_id=?,target_url=?,target_urls_json=?,agent_id=?,agent_group_id=?,execution_scope=?,target_rate_mbps=?,
+			start_at=?,end_at=?,duration_sec=?,total_bytes_target=?,total_requests_target=?,
+			dispatch_rate_tpm=?,dispatch_batch_size=?,distribution=?,jitter_pct=?,ramp_up_sec=?,ramp_down_sec=?,
+			traffic_profile_id=?,timezone=?,concurrent_fragments=?,reuse_connections=?,range_chunk_bytes=?,read_chunk_bytes=?,burst_bytes=?,cache_bust_param=?,redirects=?,max_redirects=?,expected_bytes=?,expected_sha256=?,target_rps=?,warmup_sec=?,retries=?,
+			updated_at=?
+		WHERE id=?`,
+		t.Name, t.ExternalID, t.Type, t.URLPoolID, t.TargetURL, t.TargetURLsJSON, t.AgentID, t.AgentGroupID, t.ExecutionScope, t.TargetRateMbps,
+		nullTime(t.StartAt), nullTime(t.EndAt), t.DurationSec, t.TotalBytesTarget, t.TotalRequestsTarget,
+		t.DispatchRateTpm, t.DispatchBatchSize, t.Distribution, t.JitterPct, t.RampUpSec, t.RampDownSec,
+		t.TrafficProfileID, t.Timezone, t.ConcurrentFragments, t.ReuseConnections, t.RangeChunkBytes, t.ReadChunkBytes, t.BurstBytes, t.CacheBustParam, t.Redirects, t.MaxRedirects, t.ExpectedBytes, t.ExpectedSHA256, t.TargetRps, t.WarmupSec, t.Retries,
+		t.UpdatedAt.UTC(), t.ID,
+	)
+	return mapConflict(err)
+}
+
 func (s *taskStore) UpdateStatus(ctx context.Context, id string, status model.TaskStatus) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET status=?,updated_at=? WHERE id=?`, status, time.Now().UTC(), id)
 	return err
@@ -91,6 +110,12 @@ func (s *taskStore) UpdateStatusWithTime(ctx context.Context, id string, status
 	return err
 }
 
+func (s *taskStore) UpdatePause(ctx context.Context, id string, status model.TaskStatus, pausedAt *time.Time, pausedSec int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET status=?,paused_at=?,paused_sec=?,updated_at=? WHERE id=?`,
+		status, nullTime(pausedAt), pausedSec, time.Now().UTC(), id)
+	return err
+}
+
 func (s *taskStore) UpdateBytes(ctx context.Context, id string, bytesTotal int64) error {
 	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET total_bytes_done=?,updated_at=? WHERE id=?`, bytesTotal, time.Now().UTC(), id)
 	return err
@@ -108,20 +133,20 @@ func (s *taskStore) Delete(ctx context.Context, id string) error {
 
 func scanTask(row scanner) (*model.Task, error) {
 	t := &model.Task{}
-	var startAt, endAt, dispatchedAt, startedAt, finishedAt sql.NullTime
+	var startAt, endAt, dispatchedAt, startedAt, pausedAt, finishedAt sql.NullTime
 	err := row.Scan(
-		&t.ID, &t.GroupID, &t.Name, &t.Type, &t.URLPoolID, &t.TargetURL, &t.TargetURLsJSON, &t.AgentID, &t.ExecutionScope, &t.Status, &t.TargetRateMbps,
+		&t.ID, &t.GroupID, &t.Name, &t.ExternalID, &t.Type, &t.URLPoolID, &t.TargetURL, &t.TargetURLsJSON, &t.AgentID, &t.AgentGroupID, &t.ExecutionScope, &t.Status, &t.TargetRateMbps,
 		&startAt, &endAt, &t.DurationSec,
 		&t.TotalBytesTarget, &t.TotalRequestsTarget,
 		&t.DispatchRateTpm, &t.DispatchBatchSize, &t.Distribution,
 		&t.JitterPct, &t.RampUpSec, &t.RampDownSec,
-		&t.TrafficProfileID, &t.ConcurrentFragments, &t.Retries,
-		&t.TotalBytesDone, &t.ErrorMessage,
-		&dispatchedAt, &startedAt, &finishedAt,
+		&t.TrafficProfileID, &t.Timezone, &t.ConcurrentFragments, &t.ReuseConnections, &t.RangeChunkBytes, &t.ReadChunkBytes, &t.BurstBytes, &t.CacheBustParam, &t.Redirects, &t.MaxRedirects, &t.ExpectedBytes, &t.ExpectedSHA256, &t.TargetRps, &t.WarmupSec, &t.Retries,
+		&t.TotalBytesDone, &t.PausedSec, &t.ErrorMessage,
+		&dispatchedAt, &startedAt, &pausedAt, &finishedAt,
 		&t.CreatedAt, &t.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("task not found")
+		return nil, fmt.Errorf("task %w", store.ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
@@ -130,6 +155,7 @@ func scanTask(row scanner) (*model.Task, error) {
 	t.EndAt = scanNullTime(endAt)
 	t.DispatchedAt = scanNullTime(dispatchedAt)
 	t.StartedAt = scanNullTime(startedAt)
+	t.PausedAt = scanNullTime(pausedAt)
 	t.FinishedAt = scanNullTime(finishedAt)
 	t.Normalize()
 	return t, nil
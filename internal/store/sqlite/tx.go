@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// dbtx is the query interface shared by *sql.DB and *sql.Tx, so sub-stores
+// run the same statements inside and outside transactions.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx runs fn on a transaction of its own when db is the database, or on
+// db itself when it already is a transaction, so that a sub-store's
+// multi-statement writes are atomic either way.
+func inTx(ctx context.Context, db dbtx, fn func(db dbtx) error) error {
+	conn, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WithTx runs fn with a store whose sub-stores all run on one transaction
+// on the writer connection, reads included.
+func (s *sqliteStore) WithTx(ctx context.Context, fn func(tx store.Store) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ts := &txStore{sqliteStore{db: s.db, roDB: s.roDB, roDSN: s.roDSN, bw: &bandwidthStore{db: tx, ro: tx}}}
+	ts.bind(tx, tx)
+	if err := fn(ts); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// txStore is the store handed to a WithTx callback.
+type txStore struct{ sqliteStore }
+
+// WithTx runs fn in the enclosing transaction.
+func (s *txStore) WithTx(_ context.Context, fn func(tx store.Store) error) error { return fn(s) }
+
+func (s *txStore) Close() error { return errors.New("sqlite: Close called inside a transaction") }
@@ -0,0 +1,819 @@
+// Package storetest is a conformance suite for store.Store implementations:
+// every backend runs it from its own tests, so they agree on ordering,
+// errors and what each method reads and writes.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// Run runs the suite against stores from open, which must return a new,
+// empty store on every call. Run closes them.
+func Run(t *testing.T, open func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st store.Store)
+	}{
+		{"AgentUpsertAndGet", testAgentUpsertAndGet},
+		{"AgentListFiltered", testAgentListFiltered},
+		{"AgentUpdateRate", testAgentUpdateRate},
+		{"TaskCreateAndGet", testTaskCreateAndGet},
+		{"URLPoolCreateAndGet", testURLPoolCreateAndGet},
+		{"URLPoolUpdate", testURLPoolUpdate},
+		{"MaintenanceWindows", testMaintenanceWindows},
+		{"TaskGroupCreateAndTaskListByGroup", testTaskGroupCreateAndTaskListByGroup},
+		{"TaskStatusTransition", testTaskStatusTransition},
+		{"BandwidthPurge", testBandwidthPurge},
+		{"WithTxCommitsOrRollsBack", testWithTxCommitsOrRollsBack},
+		{"MetricsInsertAndList", testMetricsInsertAndList},
+		{"MetricsLatestByTaskAgents", testMetricsLatestByTaskAgents},
+		{"SentinelErrors", testSentinelErrors},
+		{"TaskUpdateKeepsRuntimeState", testTaskUpdateKeepsRuntimeState},
+		{"AgentGroupMembers", testAgentGroupMembers},
+		{"ProvisionJobLog", testProvisionJobLog},
+		{"CredentialUpdate", testCredentialUpdate},
+		{"BandwidthAggregate", testBandwidthAggregate},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			st := open(t)
+			defer st.Close()
+			tc.fn(t, st)
+		})
+	}
+}
+
+func testAgentUpsertAndGet(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	a := &model.Agent{
+		ID:            "agent1",
+		Hostname:      "test-host",
+		IP:            "10.0.0.1",
+		Port:          0,
+		Token:         "tok123",
+		Status:        model.AgentStatusOnline,
+		Version:       "1.0.0",
+		Commit:        "0123abc",
+		CapacityMbps:  2500,
+		LastHeartbeat: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := st.Agents().Upsert(ctx, a); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+
+	got, err := st.Agents().Get(ctx, "agent1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Hostname != "test-host" {
+		t.Errorf("expected test-host, got %s", got.Hostname)
+	}
+	if got.Status != model.AgentStatusOnline {
+		t.Errorf("expected online, got %s", got.Status)
+	}
+	if got.Version != "1.0.0" || got.Commit != "0123abc" {
+		t.Errorf("expected version 1.0.0 at 0123abc, got %s at %s", got.Version, got.Commit)
+	}
+	if got.CapacityMbps != 2500 {
+		t.Errorf("expected capacity 2500 Mbps, got %g", got.CapacityMbps)
+	}
+}
+
+func testAgentUpdateRate(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	if err := st.Agents().Upsert(ctx, &model.Agent{ID: "a1", Status: model.AgentStatusOnline, LastHeartbeat: now, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	want := model.AgentRates{RateMbps: 105.5, TaskRateMbps: 98.25, TxRateMbps: 3.5, Measured: true}
+	if err := st.Agents().UpdateRate(ctx, "a1", want); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, get := range []func() (*model.Agent, error){
+		func() (*model.Agent, error) { return st.Agents().Get(ctx, "a1") },
+		func() (*model.Agent, error) {
+			list, err := st.Agents().List(ctx)
+			if err != nil || len(list) != 1 {
+				return nil, fmt.Errorf("list: %d agents, %v", len(list), err)
+			}
+			return list[0], nil
+		},
+	} {
+		a, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := model.AgentRates{RateMbps: a.CurrentRateMbps, TaskRateMbps: a.TaskRateMbps, TxRateMbps: a.TxRateMbps, Measured: a.RateMeasured}
+		if got != want {
+			t.Fatalf("rates = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func testAgentListFiltered(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	base := time.Now()
+	seed := []struct {
+		id     string
+		status model.AgentStatus
+		tags   []string
+	}{
+		{"a1", model.AgentStatusOnline, []string{"eu", "gpu"}},
+		{"a2", model.AgentStatusOffline, []string{"eu"}},
+		{"a3", model.AgentStatusOnline, []string{"us"}},
+		{"a4", model.AgentStatusOnline, nil},
+	}
+	for i, s := range seed {
+		a := &model.Agent{ID: s.id, Status: s.status, CreatedAt: base.Add(time.Duration(i) * time.Second)}
+		a.SetTags(s.tags)
+		if err := st.Agents().Upsert(ctx, a); err != nil {
+			t.Fatalf("upsert %s: %v", s.id, err)
+		}
+	}
+
+	ids := func(list []*model.Agent) []string {
+		var out []string
+		for _, a := range list {
+			out = append(out, a.ID)
+		}
+		return out
+	}
+	cases := []struct {
+		name      string
+		filter    store.AgentFilter
+		wantIDs   []string
+		wantTotal int
+	}{
+		{"all", store.AgentFilter{}, []string{"a4", "a3", "a2", "a1"}, 4},
+		{"status", store.AgentFilter{Status: model.AgentStatusOnline}, []string{"a4", "a3", "a1"}, 3},
+		{"tag", store.AgentFilter{Tag: "eu"}, []string{"a2", "a1"}, 2},
+		{"status and tag", store.AgentFilter{Status: model.AgentStatusOnline, Tag: "eu"}, []string{"a1"}, 1},
+		{"page", store.AgentFilter{Limit: 2, Offset: 1}, []string{"a3", "a2"}, 4},
+		{"offset only", store.AgentFilter{Offset: 3}, []string{"a1"}, 4},
+	}
+	for _, tc := range cases {
+		list, total, err := st.Agents().ListFiltered(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := ids(list); strings.Join(got, ",") != strings.Join(tc.wantIDs, ",") || total != tc.wantTotal {
+			t.Errorf("%s: got %v (total %d), want %v (total %d)", tc.name, got, total, tc.wantIDs, tc.wantTotal)
+		}
+	}
+
+	got, err := st.Agents().Get(ctx, "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got.Tags, ",") != "eu,gpu" {
+		t.Errorf("tags not round-tripped: %v", got.Tags)
+	}
+}
+
+func testTaskCreateAndGet(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	task := &model.Task{
+		ID:             "task1",
+		Name:           "test",
+		Type:           model.TaskTypeMixed,
+		AgentID:        "",
+		ExecutionScope: model.TaskExecutionScopeGlobal,
+		Status:         model.TaskStatusPending,
+		TargetRateMbps: 10,
+		Distribution:   model.DistributionFlat,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	task.SetTargetURLs([]string{"https://example.com/a", "https://www.youtube.com/watch?v=test"})
+
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+
+	got, err := st.Tasks().Get(ctx, "task1")
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	if got.TargetURL != "https://example.com/a" {
+		t.Errorf("expected first URL, got %s", got.TargetURL)
+	}
+	if got.Status != model.TaskStatusPending {
+		t.Errorf("expected pending, got %s", got.Status)
+	}
+	if got.ExecutionScope != model.TaskExecutionScopeGlobal {
+		t.Errorf("expected global scope, got %s", got.ExecutionScope)
+	}
+	if len(got.TargetURLs) != 2 {
+		t.Fatalf("expected 2 target URLs, got %d", len(got.TargetURLs))
+	}
+}
+
+func testURLPoolCreateAndGet(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	pool := &model.URLPool{
+		ID:          "pool1",
+		Name:        "yt-pool",
+		Type:        model.URLPoolTypeYoutube,
+		Description: "videos",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	pool.SetURLs([]string{"https://youtu.be/a", "https://youtu.be/b"})
+
+	if err := st.URLPools().Create(ctx, pool); err != nil {
+		t.Fatalf("create pool: %v", err)
+	}
+
+	got, err := st.URLPools().Get(ctx, "pool1")
+	if err != nil {
+		t.Fatalf("get pool: %v", err)
+	}
+	if got.Type != model.URLPoolTypeYoutube {
+		t.Fatalf("expected youtube pool, got %s", got.Type)
+	}
+	if len(got.URLs) != 2 {
+		t.Fatalf("expected 2 urls, got %d", len(got.URLs))
+	}
+}
+
+func testURLPoolUpdate(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	pool := &model.URLPool{
+		ID:          "pool-update",
+		Name:        "before",
+		Type:        model.URLPoolTypeStatic,
+		Description: "before",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	pool.SetURLs([]string{"https://example.com/a"})
+	if err := st.URLPools().Create(ctx, pool); err != nil {
+		t.Fatalf("create pool: %v", err)
+	}
+
+	pool.Name = "after"
+	pool.Description = "after"
+	pool.UpdatedAt = now.Add(time.Minute)
+	pool.SetURLs([]string{"https://example.com/b", "https://example.com/c"})
+	if err := st.URLPools().Update(ctx, pool); err != nil {
+		t.Fatalf("update pool: %v", err)
+	}
+
+	got, err := st.URLPools().Get(ctx, "pool-update")
+	if err != nil {
+		t.Fatalf("get updated pool: %v", err)
+	}
+	if got.Name != "after" || got.Description != "after" {
+		t.Fatalf("unexpected updated metadata: %+v", got)
+	}
+	if len(got.URLs) != 2 || got.URLs[0] != "https://example.com/b" {
+		t.Fatalf("unexpected updated urls: %+v", got.URLs)
+	}
+}
+
+func testMaintenanceWindows(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	start, end := now.Add(time.Hour), now.Add(2*time.Hour)
+	once := &model.MaintenanceWindow{ID: "mw1", Name: "freeze", StartAt: &start, EndAt: &end, CreatedAt: now, UpdatedAt: now}
+	daily := &model.MaintenanceWindow{ID: "mw2", Name: "nightly", DailyStart: "23:00", DailyEnd: "01:00",
+		Timezone: "Asia/Shanghai", CreatedAt: now.Add(time.Second), UpdatedAt: now.Add(time.Second)}
+	for _, w := range []*model.MaintenanceWindow{once, daily} {
+		if err := st.MaintenanceWindows().Create(ctx, w); err != nil {
+			t.Fatalf("create window %s: %v", w.ID, err)
+		}
+	}
+
+	got, err := st.MaintenanceWindows().Get(ctx, "mw1")
+	if err != nil {
+		t.Fatalf("get window: %v", err)
+	}
+	if got.StartAt == nil || !got.StartAt.Equal(start) || got.EndAt == nil || !got.EndAt.Equal(end) || got.Daily() {
+		t.Fatalf("unexpected one-off window: %+v", got)
+	}
+
+	daily.DailyEnd, daily.UpdatedAt = "02:30", now.Add(time.Minute)
+	if err := st.MaintenanceWindows().Update(ctx, daily); err != nil {
+		t.Fatalf("update window: %v", err)
+	}
+	list, err := st.MaintenanceWindows().List(ctx)
+	if err != nil {
+		t.Fatalf("list windows: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != "mw1" || list[1].ID != "mw2" {
+		t.Fatalf("expected mw1, mw2 oldest first, got %+v", list)
+	}
+	if w := list[1]; w.DailyStart != "23:00" || w.DailyEnd != "02:30" || w.Timezone != "Asia/Shanghai" || w.StartAt != nil {
+		t.Fatalf("unexpected daily window: %+v", w)
+	}
+
+	if err := st.MaintenanceWindows().Delete(ctx, "mw1"); err != nil {
+		t.Fatalf("delete window: %v", err)
+	}
+	if _, err := st.MaintenanceWindows().Get(ctx, "mw1"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func testTaskGroupCreateAndTaskListByGroup(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	group := &model.TaskGroup{
+		ID:             "group1",
+		Name:           "group",
+		ExecutionScope: model.TaskExecutionScopeGlobal,
+		TargetRateMbps: 100,
+		Distribution:   model.DistributionFlat,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	group.SetPoolIDs([]string{"pool-a", "pool-b"})
+	if err := st.TaskGroups().Create(ctx, group); err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+
+	task := &model.Task{
+		ID:             "task-group-child",
+		GroupID:        "group1",
+		Name:           "child",
+		Type:           model.TaskTypeStatic,
+		Status:         model.TaskStatusPending,
+		TargetRateMbps: 10,
+		Distribution:   model.DistributionFlat,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	task.SetTargetURLs([]string{"https://example.com/a"})
+	if err := st.Tasks().Create(ctx, task); err != nil {
+		t.Fatalf("create child task: %v", err)
+	}
+
+	gotGroup, err := st.TaskGroups().Get(ctx, "group1")
+	if err != nil {
+		t.Fatalf("get group: %v", err)
+	}
+	if len(gotGroup.PoolIDs) != 2 {
+		t.Fatalf("expected 2 pool ids, got %d", len(gotGroup.PoolIDs))
+	}
+
+	children, err := st.Tasks().ListByGroup(ctx, "group1")
+	if err != nil {
+		t.Fatalf("list by group: %v", err)
+	}
+	if len(children) != 1 {
+		t.Fatalf("expected 1 child task, got %d", len(children))
+	}
+	if children[0].GroupID != "group1" {
+		t.Fatalf("expected child task group1, got %s", children[0].GroupID)
+	}
+}
+
+func testTaskStatusTransition(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	task := &model.Task{
+		ID: "t1", Type: model.TaskTypeStatic, TargetURL: "https://x.com",
+		Status: model.TaskStatusPending, Distribution: model.DistributionFlat,
+		CreatedAt: now, UpdatedAt: now,
+	}
+	_ = st.Tasks().Create(ctx, task)
+	_ = st.Tasks().UpdateStatus(ctx, "t1", model.TaskStatusRunning)
+	got, _ := st.Tasks().Get(ctx, "t1")
+	if got.Status != model.TaskStatusRunning {
+		t.Errorf("expected running, got %s", got.Status)
+	}
+}
+
+func testBandwidthPurge(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	old := time.Now().Add(-8 * 24 * time.Hour)
+	recent := time.Now().Add(-1 * time.Hour)
+
+	_ = st.Bandwidth().Insert(ctx, &model.BandwidthSample{AgentID: "a1", RateMbps: 5, RecordedAt: old})
+	_ = st.Bandwidth().Insert(ctx, &model.BandwidthSample{AgentID: "a1", RateMbps: 10, RecordedAt: recent})
+
+	cutoff := time.Now().Add(-7 * 24 * time.Hour)
+	if err := st.Bandwidth().PurgeOlderThan(ctx, cutoff); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+
+	pts, _ := st.Bandwidth().History(ctx, "a1",
+		time.Now().Add(-10*24*time.Hour),
+		time.Now())
+	if len(pts) != 1 {
+		t.Errorf("expected 1 sample after purge, got %d", len(pts))
+	}
+	if pts[0].RateMbps != 10 {
+		t.Errorf("expected recent sample (10 Mbps), got %f", pts[0].RateMbps)
+	}
+}
+
+func testWithTxCommitsOrRollsBack(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 12, 30, 0, 0, time.UTC)
+	newTask := func(id string) *model.Task {
+		return &model.Task{ID: id, Type: model.TaskTypeStatic, Status: model.TaskStatusPending,
+			Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now}
+	}
+	write := func(tx store.Store, taskID string) error {
+		if err := tx.Tasks().Create(ctx, newTask(taskID)); err != nil {
+			return err
+		}
+		if err := tx.TaskMetrics().Insert(ctx, &model.TaskMetrics{TaskID: taskID, AgentID: "a1", BytesTotal: 100, RecordedAt: now}); err != nil {
+			return err
+		}
+		return tx.Bandwidth().Insert(ctx, &model.BandwidthSample{AgentID: taskID, RateMbps: 5, RecordedAt: now})
+	}
+
+	err := st.WithTx(ctx, func(tx store.Store) error {
+		if err := write(tx, "t1"); err != nil {
+			return err
+		}
+		// Reads inside the transaction see its own writes.
+		if _, err := tx.Tasks().Get(ctx, "t1"); err != nil {
+			return err
+		}
+		// A nested WithTx joins the transaction.
+		return tx.WithTx(ctx, func(inner store.Store) error { return write(inner, "t2") })
+	})
+	if err != nil {
+		t.Fatalf("committed tx: %v", err)
+	}
+	for _, id := range []string{"t1", "t2"} {
+		if _, err := st.Tasks().Get(ctx, id); err != nil {
+			t.Errorf("task %s after commit: %v", id, err)
+		}
+		if m, err := st.TaskMetrics().LatestByTask(ctx, id); err != nil || m.BytesTotal != 100 {
+			t.Errorf("metrics of %s after commit: %+v, %v", id, m, err)
+		}
+	}
+
+	errAbort := errors.New("abort")
+	err = st.WithTx(ctx, func(tx store.Store) error {
+		if err := write(tx, "t3"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("aborted tx returned %v, want errAbort", err)
+	}
+	if _, err := st.Tasks().Get(ctx, "t3"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("task after rollback: got %v, want ErrNotFound", err)
+	}
+	if m, err := st.TaskMetrics().LatestByTask(ctx, "t3"); err == nil && m != nil {
+		t.Errorf("metrics after rollback: %+v", m)
+	}
+	samples, err := st.Bandwidth().History(ctx, "t3", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil || len(samples) != 0 {
+		t.Errorf("bandwidth after rollback: %d samples, %v", len(samples), err)
+	}
+}
+
+func testMetricsInsertAndList(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	m := &model.TaskMetrics{
+		TaskID: "t1", AgentID: "a1",
+		BytesTotal: 1_000_000, BytesDelta: 50_000,
+		RateMbps5s: 8.0, RateMbps30s: 7.5,
+		RequestCount: 10, ErrorCount: 0,
+		RecordedAt: now,
+	}
+	if err := st.TaskMetrics().Insert(ctx, m); err != nil {
+		t.Fatalf("insert metrics: %v", err)
+	}
+
+	list, err := st.TaskMetrics().ListByTask(ctx, "t1",
+		now.Add(-1*time.Minute), now.Add(1*time.Minute))
+	if err != nil {
+		t.Fatalf("list metrics: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("expected 1 metric, got %d", len(list))
+	}
+	if list[0].RateMbps5s != 8.0 {
+		t.Errorf("expected 8.0 Mbps, got %f", list[0].RateMbps5s)
+	}
+}
+
+func testMetricsLatestByTaskAgents(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	metrics := []*model.TaskMetrics{
+		{TaskID: "t1", AgentID: "a1", BytesTotal: 100, RecordedAt: now.Add(-10 * time.Second)},
+		{TaskID: "t1", AgentID: "a1", BytesTotal: 200, RecordedAt: now},
+		{TaskID: "t1", AgentID: "a2", BytesTotal: 300, RecordedAt: now.Add(-5 * time.Second)},
+	}
+	for _, m := range metrics {
+		if err := st.TaskMetrics().Insert(ctx, m); err != nil {
+			t.Fatalf("insert metrics: %v", err)
+		}
+	}
+
+	latest, err := st.TaskMetrics().LatestByTaskAgents(ctx, "t1")
+	if err != nil {
+		t.Fatalf("latest by agents: %v", err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("expected 2 latest metrics, got %d", len(latest))
+	}
+	if latest[0].AgentID != "a1" || latest[0].BytesTotal != 200 {
+		t.Fatalf("unexpected latest for a1: %+v", latest[0])
+	}
+	if latest[1].AgentID != "a2" || latest[1].BytesTotal != 300 {
+		t.Fatalf("unexpected latest for a2: %+v", latest[1])
+	}
+
+	// Of samples recorded within the same second, the last inserted wins.
+	if err := st.TaskMetrics().Insert(ctx, &model.TaskMetrics{TaskID: "t1", AgentID: "a1", BytesTotal: 250, RecordedAt: now}); err != nil {
+		t.Fatalf("insert metrics: %v", err)
+	}
+	latest, err = st.TaskMetrics().LatestByTaskAgents(ctx, "t1")
+	if err != nil {
+		t.Fatalf("latest by agents: %v", err)
+	}
+	if len(latest) != 2 || latest[0].BytesTotal != 250 {
+		t.Fatalf("latest after a same-second sample = %+v, want one row for a1 with 250 bytes", latest)
+	}
+	if m, err := st.TaskMetrics().LatestByTask(ctx, "t1"); err != nil || m.BytesTotal != 250 {
+		t.Fatalf("latest by task = %+v, %v; want 250 bytes", m, err)
+	}
+}
+
+func testSentinelErrors(t *testing.T, st store.Store) {
+	ctx := context.Background()
+
+	if _, err := st.Tasks().Get(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := st.Agents().Get(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	now := time.Now()
+	p := &model.URLPool{ID: "p1", Name: "pool", Type: model.URLPoolTypeStatic, CreatedAt: now, UpdatedAt: now}
+	p.SetURLs([]string{"https://example.com/a"})
+	if err := st.URLPools().Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.URLPools().Create(ctx, p); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("expected ErrConflict on duplicate id, got %v", err)
+	}
+}
+
+func testTaskUpdateKeepsRuntimeState(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 12, 30, 0, 0, time.UTC)
+	newTask := func(id, externalID string) *model.Task {
+		return &model.Task{ID: id, GroupID: "g1", ExternalID: externalID, Type: model.TaskTypeStatic, TargetURL: "https://example.com/a",
+			Status: model.TaskStatusPending, Distribution: model.DistributionFlat, CreatedAt: now, UpdatedAt: now}
+	}
+	if err := st.Tasks().Create(ctx, newTask("t1", "ext-1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Tasks().Create(ctx, newTask("t2", "ext-1")); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("duplicate external id: got %v, want ErrConflict", err)
+	}
+	if err := st.Tasks().UpdateStatusWithTime(ctx, "t1", model.TaskStatusRunning, now, "started_at"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Tasks().UpdateBytes(ctx, "t1", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	upd := newTask("t1", "ext-2")
+	upd.GroupID, upd.Status, upd.Name = "", model.TaskStatusPending, "renamed"
+	upd.SetTargetURLs([]string{"https://example.com/b"})
+	if err := st.Tasks().Update(ctx, upd); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, err := st.Tasks().Get(ctx, "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "renamed" || got.ExternalID != "ext-2" || got.TargetURL != "https://example.com/b" {
+		t.Errorf("configuration not updated: %+v", got)
+	}
+	if got.GroupID != "g1" || got.Status != model.TaskStatusRunning || got.TotalBytesDone != 42 ||
+		got.StartedAt == nil || !got.StartedAt.Equal(now) {
+		t.Errorf("runtime state changed by update: %+v", got)
+	}
+
+	running, err := st.Tasks().ListByAgent(ctx, "", []model.TaskStatus{model.TaskStatusRunning})
+	if err != nil || len(running) != 1 || running[0].ID != "t1" {
+		t.Errorf("running tasks = %v, %v; want t1", running, err)
+	}
+	if err := st.Tasks().Delete(ctx, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Tasks().Get(ctx, "t1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("deleted task: got %v, want ErrNotFound", err)
+	}
+}
+
+func testAgentGroupMembers(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	for _, g := range []*model.AgentGroup{{ID: "g2", Name: "us"}, {ID: "g1", Name: "eu"}} {
+		g.CreatedAt, g.UpdatedAt = now, now
+		if err := st.AgentGroups().Create(ctx, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.AgentGroups().Create(ctx, &model.AgentGroup{ID: "g3", Name: "eu", CreatedAt: now, UpdatedAt: now}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("duplicate name: got %v, want ErrConflict", err)
+	}
+	if err := st.Agents().Upsert(ctx, &model.Agent{ID: "a1", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AgentGroups().AddMembers(ctx, "g1", []string{"a2", "a1", "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AgentGroups().AddMembers(ctx, "g2", []string{"a1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := st.AgentGroups().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Name != "eu" || strings.Join(groups[0].AgentIDs, ",") != "a1,a2" {
+		t.Fatalf("groups = %+v, want eu (a1,a2) first", groups)
+	}
+	if ids, _ := st.AgentGroups().GroupsOf(ctx, "a1"); strings.Join(ids, ",") != "g1,g2" {
+		t.Errorf("groups of a1 = %v, want g1,g2", ids)
+	}
+
+	if err := st.AgentGroups().RemoveMember(ctx, "g1", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting an agent or a group drops its memberships.
+	if err := st.Agents().Delete(ctx, "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := st.AgentGroups().Get(ctx, "g1"); g == nil || len(g.AgentIDs) != 0 {
+		t.Errorf("g1 after removals = %+v, want no members", g)
+	}
+	if err := st.AgentGroups().AddMembers(ctx, "g2", []string{"a3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AgentGroups().Delete(ctx, "g2"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := st.AgentGroups().GroupsOf(ctx, "a3"); len(ids) != 0 {
+		t.Errorf("groups of a3 after group delete = %v", ids)
+	}
+	if _, err := st.AgentGroups().Get(ctx, "g2"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("deleted group: got %v, want ErrNotFound", err)
+	}
+}
+
+func testProvisionJobLog(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now()
+	j := &model.ProvisionJob{ID: "j1", HostIP: "10.0.0.1", SSHPort: 22, DownloadURL: "https://mirror/agent-{arch}", Status: model.ProvisionStatusPending, CreatedAt: now, UpdatedAt: now}
+	j.SetAgentEnv(map[string]string{"MASTER_URL": "http://master:8080"})
+	if err := st.ProvisionJobs().Create(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ProvisionJobs().UpdateStatus(ctx, "j1", model.ProvisionStatusRunning, "upload"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ProvisionJobs().AppendLog(ctx, "j1", "uploading"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ProvisionJobs().SetAgentID(ctx, "j1", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ProvisionJobs().SetFailed(ctx, "j1", "upload", "no space"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ProvisionJobs().SetStepDurations(ctx, "j1", map[string]float64{"ssh_check": 1.25, "upload": 30}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.ProvisionJobs().Get(ctx, "j1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != model.ProvisionStatusFailed || got.FailedStep != "upload" || got.AgentID != "a1" ||
+		got.Log != "uploading\n[FAIL] no space\n" || got.AgentEnv["MASTER_URL"] != "http://master:8080" ||
+		got.StepDurations["ssh_check"] != 1.25 || got.StepDurations["upload"] != 30 || got.DownloadURL != "https://mirror/agent-{arch}" {
+		t.Fatalf("failed job = %+v", got)
+	}
+
+	if err := st.ProvisionJobs().ResetForRetry(ctx, "j1"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = st.ProvisionJobs().Get(ctx, "j1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != model.ProvisionStatusPending || got.CurrentStep != "created" || got.Log != "" || got.AgentID != "" || got.FailedStep != "" ||
+		len(got.StepDurations) != 0 {
+		t.Fatalf("reset job = %+v", got)
+	}
+}
+
+func testBandwidthAggregate(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 12, 30, 0, 0, time.UTC)
+	for _, s := range []*model.BandwidthSample{
+		{AgentID: "a1", RateMbps: 2, RecordedAt: now},
+		{AgentID: "a1", RateMbps: 4, RecordedAt: now.Add(10 * time.Second)},
+		{AgentID: "a2", RateMbps: 5, RecordedAt: now.Add(20 * time.Second)},
+		{AgentID: "a2", RateMbps: 7, RecordedAt: now.Add(2 * time.Minute)},
+	} {
+		if err := st.Bandwidth().Insert(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	points, err := st.Bandwidth().AggregateHistory(ctx, now.Add(-time.Hour), now.Add(time.Hour), 60, store.BandwidthAggSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Per-agent averages are summed: a1 averages 3 and a2 5 in the first
+	// minute.
+	if len(points) != 2 || points[0].AvgMbps != 8 || points[0].MaxMbps != 5 || !points[0].Ts.Equal(now) ||
+		points[1].AvgMbps != 7 || !points[1].Ts.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("aggregate = %+v", points)
+	}
+	for agg, want := range map[store.BandwidthAgg]float64{store.BandwidthAggSum: 8, store.BandwidthAggAvg: 4, store.BandwidthAggMax: 5} {
+		points, err := st.Bandwidth().AggregateHistory(ctx, now.Add(-time.Hour), now.Add(time.Hour), 60, agg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != 2 || points[0].Mbps != want || points[1].Mbps != 7 {
+			t.Errorf("%s aggregate = %+v, want %v then 7", agg, points, want)
+		}
+	}
+	if _, err := st.Bandwidth().AggregateHistory(ctx, now.Add(-time.Hour), now, 60, "median"); err == nil {
+		t.Error("an unknown aggregation was accepted")
+	}
+
+	mine, err := st.Bandwidth().AgentHistory(ctx, "a1", now.Add(-time.Hour), now.Add(time.Hour), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mine) != 1 || mine[0].AvgMbps != 3 || mine[0].Mbps != 3 || mine[0].MaxMbps != 4 || !mine[0].Ts.Equal(now) {
+		t.Fatalf("a1 history = %+v, want one bucket averaging 3 with a peak of 4", mine)
+	}
+	if mine, err = st.Bandwidth().AgentHistory(ctx, "a2", now.Add(-time.Hour), now.Add(time.Hour), 60); err != nil {
+		t.Fatal(err)
+	}
+	if len(mine) != 2 || mine[0].Mbps != 5 || mine[1].Mbps != 7 {
+		t.Fatalf("a2 history = %+v, want 5 then 7", mine)
+	}
+
+	total, err := st.Bandwidth().TotalCurrent(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 11 {
+		t.Errorf("total current = %v, want a1's 4 + a2's 7", total)
+	}
+}
+
+func testCredentialUpdate(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	c := &model.Credential{ID: "cred1", Name: "deploy", Type: model.AuthTypeKey, Payload: "old-key", CreatedAt: now}
+	if err := st.Credentials().Create(ctx, c); err != nil {
+		t.Fatalf("create credential: %v", err)
+	}
+
+	if err := st.Credentials().Update(ctx, &model.Credential{ID: "cred1", Name: "deploy-2", Type: model.AuthTypePassword, Payload: "new-secret"}); err != nil {
+		t.Fatalf("update credential: %v", err)
+	}
+	got, err := st.Credentials().Get(ctx, "cred1")
+	if err != nil {
+		t.Fatalf("get credential: %v", err)
+	}
+	if got.Name != "deploy-2" || got.Type != model.AuthTypePassword || got.Payload != "new-secret" || !got.CreatedAt.Equal(now) {
+		t.Fatalf("unexpected credential after update: %+v", got)
+	}
+	list, err := st.Credentials().List(ctx)
+	if err != nil {
+		t.Fatalf("list credentials: %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("expected the credential updated in place, got %d", len(list))
+	}
+}
@@ -0,0 +1,87 @@
+// Package tracing sets up the optional OpenTelemetry tracing both binaries
+// share. Tracing is off unless OTEL_EXPORTER_OTLP_ENDPOINT is set; spans are
+// then exported over OTLP/HTTP, and the exporter, sampler and resource read
+// the other standard OTEL_* variables themselves.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+)
+
+// Enabled reports whether OTEL_EXPORTER_OTLP_ENDPOINT is set.
+func Enabled() bool { return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" }
+
+// Setup installs a global tracer provider exporting spans as service, and
+// W3C trace context propagation. Without an endpoint it installs nothing:
+// the global provider stays a no-op, so spans started elsewhere cost
+// nothing. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, service string) (shutdown func(context.Context) error, err error) {
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, fmt.Errorf("otel resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// Handler traces API requests served by h. Spans are named after the
+// matched route pattern, such as "POST /api/v1/tasks/{id}/dispatch", and
+// continue any trace the caller propagated. Other paths, such as the web
+// UI's assets, are not traced.
+func Handler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "http.request",
+		otelhttp.WithFilter(func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/api/") }),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if r.Pattern != "" {
+				return r.Pattern
+			}
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// Transport traces requests sent through rt (http.DefaultTransport if nil)
+// and propagates the trace context in their headers.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(rt)
+}
+
+// ServerOption traces the RPCs of a gRPC server, continuing traces
+// propagated in request metadata.
+func ServerOption() grpc.ServerOption { return grpc.StatsHandler(otelgrpc.NewServerHandler()) }
+
+// DialOption traces the RPCs of a gRPC client and propagates the trace
+// context in request metadata.
+func DialOption() grpc.DialOption { return grpc.WithStatsHandler(otelgrpc.NewClientHandler()) }
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetupDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	before := otel.GetTracerProvider()
+	shutdown, err := Setup(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if otel.GetTracerProvider() != before {
+		t.Fatal("Setup without an endpoint replaced the tracer provider")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/tasks/{id}/dispatch", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(Handler(mux))
+	defer srv.Close()
+	hc := &http.Client{Transport: Transport(nil)}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	for _, req := range []struct{ method, path string }{
+		{"POST", "/api/v1/tasks/t1/dispatch"},
+		{"GET", "/index.html"},
+	} {
+		r, _ := http.NewRequestWithContext(ctx, req.method, srv.URL+req.path, nil)
+		res, err := hc.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	parent.End()
+
+	var server []sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		if s.SpanKind() == trace.SpanKindServer {
+			server = append(server, s)
+		}
+	}
+	if len(server) != 1 {
+		t.Fatalf("got %d server spans, want 1 (UI paths are not traced)", len(server))
+	}
+	s := server[0]
+	if s.Name() != "POST /api/v1/tasks/{id}/dispatch" {
+		t.Errorf("server span name = %q, want the route pattern", s.Name())
+	}
+	if s.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Error("server span did not continue the client's trace")
+	}
+}
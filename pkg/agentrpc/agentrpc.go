@@ -0,0 +1,159 @@
+// Package agentrpc defines the gRPC transport between agents and the master.
+//
+// The AgentService mirrors the agent-facing REST endpoints (register,
+// heartbeat, task pull and stream, metrics, result and status reports) for
+// fleets where per-call HTTP requests are too heavy: calls share one
+// multiplexed connection and payloads are gzip-compressed. Messages are the
+// REST request and response types encoded as JSON under the "json" content
+// subtype (application/grpc+json), so no generated code is needed and other
+// languages can call the service with any gRPC library that accepts a custom
+// codec. The REST API remains the interface for the UI and tooling.
+package agentrpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/master/spec"
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// ServiceName is the fully qualified gRPC service name.
+const ServiceName = "ngoogle.agent.v1.AgentService"
+
+// codecName is the content subtype the service's messages are encoded with.
+const codecName = "json"
+
+// AuthMetadataKey is the metadata key under which agents send their token,
+// as "Bearer <token>", with metrics and result reports.
+const AuthMetadataKey = "authorization"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return codecName }
+
+// Messages shared with the REST API.
+type (
+	RegisterRequest  = service.RegisterRequest
+	RegisterResponse = service.RegisterResponse
+	HeartbeatRequest = spec.HeartbeatRequest
+)
+
+// PullRequest asks for an agent's tasks. With WaitMillis set the call is a
+// long poll, held until the task list changes from version Since, like
+// GET /api/v1/agents/{agent_id}/tasks/pull?wait=&since=.
+type PullRequest struct {
+	AgentID    string `json:"agent_id"`
+	Since      string `json:"since,omitempty"`
+	WaitMillis int64  `json:"wait_ms,omitempty"`
+}
+
+// TaskList is an agent's complete task list and its version.
+type TaskList struct {
+	Tasks   []*model.Task `json:"tasks"`
+	Version string        `json:"version"`
+}
+
+// StreamRequest subscribes an agent to its task list.
+type StreamRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+// TaskRequest names the task a status report is about. Reason is set for
+// MarkFailed only.
+type TaskRequest struct {
+	TaskID string `json:"task_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Empty is the response of calls that return nothing.
+type Empty struct{}
+
+// AgentServiceServer is the master's side of the AgentService.
+type AgentServiceServer interface {
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	Heartbeat(context.Context, *HeartbeatRequest) (*Empty, error)
+	PullTasks(context.Context, *PullRequest) (*TaskList, error)
+	// StreamTasks sends the agent's task list when the stream opens, after
+	// every change and at least every 15s, like the REST task stream.
+	StreamTasks(*StreamRequest, TaskStream) error
+	ReportMetrics(context.Context, *model.TaskMetrics) (*Empty, error)
+	ReportResult(context.Context, *model.AgentTaskResult) (*Empty, error)
+	MarkRunning(context.Context, *TaskRequest) (*Empty, error)
+	MarkDone(context.Context, *TaskRequest) (*Empty, error)
+	MarkFailed(context.Context, *TaskRequest) (*Empty, error)
+}
+
+// TaskStream is the server side of a StreamTasks call.
+type TaskStream interface {
+	Send(*TaskList) error
+	Context() context.Context
+}
+
+// RegisterAgentServiceServer registers srv on s.
+func RegisterAgentServiceServer(s grpc.ServiceRegistrar, srv AgentServiceServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AgentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Register", AgentServiceServer.Register),
+		unary("Heartbeat", AgentServiceServer.Heartbeat),
+		unary("PullTasks", AgentServiceServer.PullTasks),
+		unary("ReportMetrics", AgentServiceServer.ReportMetrics),
+		unary("ReportResult", AgentServiceServer.ReportResult),
+		unary("MarkRunning", AgentServiceServer.MarkRunning),
+		unary("MarkDone", AgentServiceServer.MarkDone),
+		unary("MarkFailed", AgentServiceServer.MarkFailed),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "StreamTasks",
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			in := new(StreamRequest)
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			return srv.(AgentServiceServer).StreamTasks(in, taskStream{stream})
+		},
+	}},
+}
+
+// unary builds the descriptor of a unary method served by call.
+func unary[Req, Resp any](name string, call func(AgentServiceServer, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := new(Req)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(AgentServiceServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod(name)}
+			return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv.(AgentServiceServer), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+type taskStream struct{ grpc.ServerStream }
+
+func (s taskStream) Send(l *TaskList) error { return s.SendMsg(l) }
+
+func fullMethod(name string) string { return "/" + ServiceName + "/" + name }
@@ -0,0 +1,184 @@
+package agentrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/masterclient"
+)
+
+// callTimeout bounds unary calls, like masterclient's per-request timeout.
+const callTimeout = 30 * time.Second
+
+// Client calls the AgentService. Its methods match masterclient.Client's
+// agent calls, and return the same *masterclient.APIError values, so either
+// can carry an agent's traffic. It is safe for concurrent use.
+type Client struct {
+	cc     grpc.ClientConnInterface
+	closer func() error
+}
+
+// Dial creates a Client for the master's gRPC address (host:port). Options
+// must at least supply transport credentials.
+func Dial(target string, opts ...grpc.DialOption) (*Client, error) {
+	cc, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{cc: cc, closer: cc.Close}, nil
+}
+
+// NewClient creates a Client on an existing connection, which the caller
+// keeps ownership of.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc, closer: func() error { return nil }}
+}
+
+// Close closes the connection opened by Dial.
+func (c *Client) Close() error { return c.closer() }
+
+var callOptions = []grpc.CallOption{grpc.CallContentSubtype(codecName), grpc.UseCompressor(gzip.Name)}
+
+func (c *Client) invoke(ctx context.Context, method string, in, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	return apiError(c.cc.Invoke(ctx, fullMethod(method), in, out, callOptions...))
+}
+
+// RegisterAgent registers (or re-registers) an agent and returns its token.
+func (c *Client) RegisterAgent(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+	var resp RegisterResponse
+	if err := c.invoke(ctx, "Register", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Heartbeat reports agent liveness, its current rates and the IDs of the
+// tasks it is running; nil running leaves them unreported.
+func (c *Client) Heartbeat(ctx context.Context, agentID, token string, rates model.AgentRates, running []string) error {
+	return c.invoke(ctx, "Heartbeat", &HeartbeatRequest{AgentID: agentID, Token: token, AgentRates: rates, RunningTasks: running}, &Empty{})
+}
+
+// PullTasks returns the tasks an agent should be running.
+func (c *Client) PullTasks(ctx context.Context, agentID string) ([]*model.Task, error) {
+	var out TaskList
+	return out.Tasks, c.invoke(ctx, "PullTasks", &PullRequest{AgentID: agentID}, &out)
+}
+
+// PullTasksWait long-polls an agent's tasks until they change from version
+// since, or wait elapses, and returns them with their version.
+func (c *Client) PullTasksWait(ctx context.Context, agentID, since string, wait time.Duration) ([]*model.Task, string, error) {
+	var out TaskList
+	req := &PullRequest{AgentID: agentID, Since: since, WaitMillis: wait.Milliseconds()}
+	if err := c.invoke(ctx, "PullTasks", req, &out); err != nil {
+		return nil, "", err
+	}
+	return out.Tasks, out.Version, nil
+}
+
+// StreamTasks subscribes to an agent's task list and calls fn with every
+// list the master sends, starting with the current one. Like
+// masterclient.Client.StreamTasks it blocks until ctx is done or the stream
+// ends, always returns an error, and gives up with
+// masterclient.ErrStreamIdle on a stream silent for longer than idle.
+func (c *Client) StreamTasks(ctx context.Context, agentID string, idle time.Duration, fn func(tasks []*model.Task)) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	watchdog := time.AfterFunc(idle, func() { cancel(masterclient.ErrStreamIdle) })
+	defer watchdog.Stop()
+
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], fullMethod("StreamTasks"), callOptions...)
+	if err == nil {
+		err = stream.SendMsg(&StreamRequest{AgentID: agentID})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	for err == nil {
+		var l TaskList
+		if err = stream.RecvMsg(&l); err == nil {
+			watchdog.Reset(idle)
+			fn(l.Tasks)
+		}
+	}
+	if cause := context.Cause(ctx); cause != nil {
+		return cause
+	}
+	if errors.Is(err, io.EOF) {
+		return errors.New("task stream closed by master")
+	}
+	return apiError(err)
+}
+
+// ReportTaskMetrics sends a metrics sample for m.TaskID on behalf of
+// m.AgentID, whose token is token.
+func (c *Client) ReportTaskMetrics(ctx context.Context, token string, m *model.TaskMetrics) error {
+	return c.invoke(withAgentToken(ctx, token), "ReportMetrics", m, &Empty{})
+}
+
+// ReportTaskResult sends an agent's final result for r.TaskID; token is
+// r.AgentID's.
+func (c *Client) ReportTaskResult(ctx context.Context, token string, r *model.AgentTaskResult) error {
+	return c.invoke(withAgentToken(ctx, token), "ReportResult", r, &Empty{})
+}
+
+// withAgentToken attaches an agent's token to the outgoing call.
+func withAgentToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, AuthMetadataKey, "Bearer "+token)
+}
+
+// MarkTaskRunning marks a task as running.
+func (c *Client) MarkTaskRunning(ctx context.Context, id string) error {
+	return c.invoke(ctx, "MarkRunning", &TaskRequest{TaskID: id}, &Empty{})
+}
+
+// MarkTaskDone marks a task as completed.
+func (c *Client) MarkTaskDone(ctx context.Context, id string) error {
+	return c.invoke(ctx, "MarkDone", &TaskRequest{TaskID: id}, &Empty{})
+}
+
+// MarkTaskFailed marks a task as failed with a reason.
+func (c *Client) MarkTaskFailed(ctx context.Context, id, reason string) error {
+	return c.invoke(ctx, "MarkFailed", &TaskRequest{TaskID: id, Reason: reason}, &Empty{})
+}
+
+// httpStatus maps the status codes the master returns onto the HTTP statuses
+// the REST API uses for the same failures.
+var httpStatus = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.NotFound:           http.StatusNotFound,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Internal:           http.StatusInternalServerError,
+}
+
+// apiError turns an error status from the master into the *APIError the
+// REST client would return, so callers can match masterclient's sentinels.
+// Transport failures are returned as they are.
+func apiError(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	code, ok := httpStatus[st.Code()]
+	if !ok {
+		return err
+	}
+	return &masterclient.APIError{StatusCode: code, Message: st.Message()}
+}
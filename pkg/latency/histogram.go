@@ -0,0 +1,155 @@
+// Package latency records request latencies in compact, mergeable histograms.
+//
+// Agents report cumulative histograms rather than percentiles because
+// percentiles from several agents cannot be combined; histograms merge
+// exactly, so the master can compute task-wide percentiles from the latest
+// snapshot of every agent.
+package latency
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// base is the upper bound of bucket 0; anything faster lands there.
+	base = 100 * time.Microsecond
+	// growth is the ratio between consecutive bucket bounds, so a quantile
+	// is accurate to within 10%.
+	growth = 1.1
+	// maxBucket caps the range at roughly 160s; slower samples land in it.
+	maxBucket = 150
+)
+
+var logGrowth = math.Log(growth)
+
+// Histogram counts durations in logarithmic buckets. The zero value is an
+// empty histogram; it is not safe for concurrent use (see Recorder).
+type Histogram struct {
+	// Buckets maps a bucket index to its sample count; empty buckets are
+	// omitted to keep reports small.
+	Buckets map[int]int64 `json:"buckets,omitempty"`
+	Count   int64         `json:"count"`
+}
+
+// Observe adds one sample.
+func (h *Histogram) Observe(d time.Duration) {
+	if h.Buckets == nil {
+		h.Buckets = map[int]int64{}
+	}
+	h.Buckets[bucketFor(d)]++
+	h.Count++
+}
+
+// Merge adds every sample of o to h.
+func (h *Histogram) Merge(o Histogram) {
+	if o.Count == 0 {
+		return
+	}
+	if h.Buckets == nil {
+		h.Buckets = map[int]int64{}
+	}
+	for i, n := range o.Buckets {
+		h.Buckets[i] += n
+	}
+	h.Count += o.Count
+}
+
+// Sub removes the samples of o, an earlier snapshot of the same cumulative
+// histogram, leaving only what was observed since.
+func (h *Histogram) Sub(o Histogram) {
+	for i, n := range o.Buckets {
+		if left := h.Buckets[i] - n; left > 0 {
+			h.Buckets[i] = left
+		} else {
+			delete(h.Buckets, i)
+		}
+	}
+	h.Count -= o.Count
+	if h.Count < 0 {
+		h.Count = 0
+	}
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile
+// (0 < q ≤ 1), or 0 for an empty histogram.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	idx := make([]int, 0, len(h.Buckets))
+	for i := range h.Buckets {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	rank := int64(math.Ceil(q * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for _, i := range idx {
+		seen += h.Buckets[i]
+		if seen >= rank {
+			return upperBound(i)
+		}
+	}
+	return upperBound(idx[len(idx)-1])
+}
+
+// Clone returns a deep copy of h.
+func (h *Histogram) Clone() Histogram {
+	out := Histogram{Count: h.Count}
+	if h.Buckets != nil {
+		out.Buckets = make(map[int]int64, len(h.Buckets))
+		for i, n := range h.Buckets {
+			out.Buckets[i] = n
+		}
+	}
+	return out
+}
+
+func bucketFor(d time.Duration) int {
+	if d <= base {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(base)) / logGrowth))
+	if i > maxBucket {
+		return maxBucket
+	}
+	return i
+}
+
+func upperBound(i int) time.Duration {
+	return time.Duration(float64(base) * math.Pow(growth, float64(i)))
+}
+
+// Recorder tracks time-to-first-byte and total response time for one task.
+// It is safe for concurrent use, and a nil *Recorder discards samples.
+type Recorder struct {
+	mu    sync.Mutex
+	ttfb  Histogram
+	total Histogram
+}
+
+// Observe records one request's time to first byte and total duration.
+func (r *Recorder) Observe(ttfb, total time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.ttfb.Observe(ttfb)
+	r.total.Observe(total)
+	r.mu.Unlock()
+}
+
+// Snapshot returns copies of the cumulative TTFB and total histograms.
+func (r *Recorder) Snapshot() (ttfb, total Histogram) {
+	if r == nil {
+		return Histogram{}, Histogram{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ttfb.Clone(), r.total.Clone()
+}
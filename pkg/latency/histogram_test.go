@@ -0,0 +1,84 @@
+package latency
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestQuantileWithinBucketResolution(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 100; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	cases := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.50, 50 * time.Millisecond},
+		{0.95, 95 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		got := h.Quantile(tc.q)
+		if got < tc.want || float64(got) > float64(tc.want)*growth {
+			t.Errorf("Quantile(%v) = %v, want within 10%% above %v", tc.q, got, tc.want)
+		}
+	}
+	if got := (&Histogram{}).Quantile(0.5); got != 0 {
+		t.Errorf("empty histogram quantile = %v, want 0", got)
+	}
+}
+
+func TestMergeSurvivesJSON(t *testing.T) {
+	var fast, slow Histogram
+	for i := 0; i < 90; i++ {
+		fast.Observe(10 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		slow.Observe(time.Second)
+	}
+	raw, err := json.Marshal(slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Histogram
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	fast.Merge(decoded)
+	if fast.Count != 100 {
+		t.Fatalf("Count = %d, want 100", fast.Count)
+	}
+	if p50 := fast.Quantile(0.5); p50 > 11*time.Millisecond {
+		t.Errorf("p50 = %v, want about 10ms", p50)
+	}
+	if p95 := fast.Quantile(0.95); p95 < time.Second {
+		t.Errorf("p95 = %v, want at least 1s", p95)
+	}
+}
+
+func TestNilRecorderDiscards(t *testing.T) {
+	var r *Recorder
+	r.Observe(time.Millisecond, time.Millisecond)
+	if ttfb, total := r.Snapshot(); ttfb.Count != 0 || total.Count != 0 {
+		t.Fatal("nil recorder should report nothing")
+	}
+}
+
+func TestSubLeavesLaterSamples(t *testing.T) {
+	var h Histogram
+	h.Observe(time.Second)
+	earlier := h.Clone()
+	h.Observe(5 * time.Millisecond)
+	h.Observe(5 * time.Millisecond)
+
+	h.Sub(earlier)
+	if h.Count != 2 {
+		t.Fatalf("Count = %d, want 2", h.Count)
+	}
+	if p := h.Quantile(1); p > 6*time.Millisecond {
+		t.Fatalf("max = %v, want the 5ms samples only", p)
+	}
+}
@@ -0,0 +1,666 @@
+package masterclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aven/ngoogle/internal/master/provision"
+	"github.com/aven/ngoogle/internal/master/service"
+	"github.com/aven/ngoogle/internal/master/spec"
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/internal/store"
+)
+
+// Request types shared with the master.
+type (
+	RegisterRequest        = service.RegisterRequest
+	RegisterResponse       = service.RegisterResponse
+	CreateTaskRequest      = service.CreateTaskRequest
+	CreateTaskGroupRequest = service.CreateTaskGroupRequest
+	AgentGroupRequest      = service.CreateAgentGroupRequest
+	AgentGroupStats        = service.AgentGroupStats
+	TaskTemplateRequest    = service.TaskTemplateRequest
+	TaskDocument           = service.TaskDocument
+	ImportResult           = service.ImportResult
+	ProvisionRequest       = provision.JobRequest
+	CredentialRequest      = provision.CredentialRequest
+	Overview               = service.OverviewResponse
+	BandwidthPoint         = store.BandwidthPoint
+	URLPoolRequest         = spec.URLPoolRequest
+	TrafficProfileRequest  = spec.TrafficProfileRequest
+	TaskSummary            = service.TaskSummary
+	TaskResult             = model.TaskResult
+	TaskComparison         = service.TaskComparison
+	TaskRate               = service.TaskRate
+)
+
+// ─── Agents ───────────────────────────────────────────────────────────────────
+
+// RegisterAgent registers an agent and returns it with its token. This is
+// the only call that exposes an agent token.
+func (c *Client) RegisterAgent(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+	var resp RegisterResponse
+	if err := c.post(ctx, "/api/v1/agents/register", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Heartbeat reports agent liveness, its current rates and the IDs of the
+// tasks it is running; nil running leaves them unreported.
+func (c *Client) Heartbeat(ctx context.Context, agentID, token string, rates model.AgentRates, running []string) error {
+	body := map[string]any{
+		"agent_id": agentID, "token": token, "running_tasks": running,
+		"rate_mbps": rates.RateMbps, "task_rate_mbps": rates.TaskRateMbps, "tx_rate_mbps": rates.TxRateMbps, "rate_measured": rates.Measured,
+	}
+	return c.post(ctx, "/api/v1/agents/heartbeat", body, nil)
+}
+
+// ListAgents returns all agents.
+func (c *Client) ListAgents(ctx context.Context) ([]*model.Agent, error) {
+	var out []*model.Agent
+	return out, c.get(ctx, "/api/v1/agents", nil, &out)
+}
+
+// AgentFilter narrows ListAgentsFiltered.
+type AgentFilter = store.AgentFilter
+
+// ListAgentsFiltered returns one page of agents matching f and the total
+// number of matches.
+func (c *Client) ListAgentsFiltered(ctx context.Context, f AgentFilter) ([]*model.Agent, int, error) {
+	q := url.Values{}
+	if f.Status != "" {
+		q.Set("status", string(f.Status))
+	}
+	if f.Tag != "" {
+		q.Set("tag", f.Tag)
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Offset > 0 {
+		q.Set("offset", strconv.Itoa(f.Offset))
+	}
+	path := "/api/v1/agents"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var out []*model.Agent
+	header, err := c.send(ctx, http.MethodGet, path, nil, &out)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := strconv.Atoi(header.Get("X-Total-Count"))
+	if err != nil {
+		total = len(out)
+	}
+	return out, total, nil
+}
+
+// GetAgent returns one agent.
+func (c *Client) GetAgent(ctx context.Context, id string) (*model.Agent, error) {
+	var a model.Agent
+	if err := c.get(ctx, "/api/v1/agents/"+url.PathEscape(id), nil, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// RotateAgentToken replaces an agent's token and returns the new one.
+func (c *Client) RotateAgentToken(ctx context.Context, id string) (string, error) {
+	var resp spec.TokenResponse
+	if err := c.post(ctx, "/api/v1/agents/"+url.PathEscape(id)+"/rotate-token", nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+// DeleteAgent removes an agent.
+func (c *Client) DeleteAgent(ctx context.Context, id string) error {
+	return c.delete(ctx, "/api/v1/agents/"+url.PathEscape(id))
+}
+
+// PullTasks returns the tasks an agent should be running.
+func (c *Client) PullTasks(ctx context.Context, agentID string) ([]*model.Task, error) {
+	var out []*model.Task
+	return out, c.get(ctx, "/api/v1/agents/"+url.PathEscape(agentID)+"/tasks/pull", nil, &out)
+}
+
+// PullTasksWait long-polls an agent's tasks: the master holds the request until
+// the task list changes from version since, or wait elapses. It also returns
+// the version of the returned list, to pass as since on the next call.
+func (c *Client) PullTasksWait(ctx context.Context, agentID, since string, wait time.Duration) ([]*model.Task, string, error) {
+	q := url.Values{}
+	q.Set("wait", wait.String())
+	if since != "" {
+		q.Set("since", since)
+	}
+	var out []*model.Task
+	header, err := c.send(ctx, http.MethodGet, "/api/v1/agents/"+url.PathEscape(agentID)+"/tasks/pull?"+q.Encode(), nil, &out)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, header.Get("X-Tasks-Version"), nil
+}
+
+// ─── Tasks ────────────────────────────────────────────────────────────────────
+
+// CreateTask creates a task.
+func (c *Client) CreateTask(ctx context.Context, req *CreateTaskRequest) (*model.Task, error) {
+	var t model.Task
+	if err := c.post(ctx, "/api/v1/tasks", req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTasks returns all tasks.
+func (c *Client) ListTasks(ctx context.Context) ([]*model.Task, error) {
+	var out []*model.Task
+	return out, c.get(ctx, "/api/v1/tasks", nil, &out)
+}
+
+// GetTask returns one task.
+func (c *Client) GetTask(ctx context.Context, id string) (*model.Task, error) {
+	var t model.Task
+	if err := c.get(ctx, taskPath(id, ""), nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DispatchTask moves a pending task to dispatched.
+func (c *Client) DispatchTask(ctx context.Context, id string) error {
+	return c.post(ctx, taskPath(id, "/dispatch"), nil, nil)
+}
+
+// StopTask stops a task.
+func (c *Client) StopTask(ctx context.Context, id string) error {
+	return c.post(ctx, taskPath(id, "/stop"), nil, nil)
+}
+
+// StopAgentTasks stops every unfinished task assigned to an agent and
+// returns how many were stopped.
+func (c *Client) StopAgentTasks(ctx context.Context, agentID string) (int, error) {
+	var resp spec.StoppedResponse
+	if err := c.post(ctx, "/api/v1/agents/"+url.PathEscape(agentID)+"/stop-tasks", nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Stopped, nil
+}
+
+// PauseTask pauses a dispatched or running task.
+func (c *Client) PauseTask(ctx context.Context, id string) error {
+	return c.post(ctx, taskPath(id, "/pause"), nil, nil)
+}
+
+// ResumeTask resumes a paused task.
+func (c *Client) ResumeTask(ctx context.Context, id string) error {
+	return c.post(ctx, taskPath(id, "/resume"), nil, nil)
+}
+
+// MarkTaskRunning marks a task as running.
+func (c *Client) MarkTaskRunning(ctx context.Context, id string) error {
+	return c.post(ctx, taskPath(id, "/run"), nil, nil)
+}
+
+// MarkTaskDone marks a task as completed.
+func (c *Client) MarkTaskDone(ctx context.Context, id string) error {
+	return c.post(ctx, taskPath(id, "/done"), nil, nil)
+}
+
+// MarkTaskFailed marks a task as failed with a reason.
+func (c *Client) MarkTaskFailed(ctx context.Context, id, reason string) error {
+	return c.post(ctx, taskPath(id, "/fail"), map[string]string{"reason": reason}, nil)
+}
+
+// ReportTaskMetrics sends a metrics sample for m.TaskID on behalf of
+// m.AgentID, whose token is token.
+func (c *Client) ReportTaskMetrics(ctx context.Context, token string, m *model.TaskMetrics) error {
+	_, err := c.sendAs(ctx, token, http.MethodPost, taskPath(m.TaskID, "/metrics"), m, nil)
+	return err
+}
+
+// ReportTaskResult sends an agent's final result for r.TaskID; token is
+// r.AgentID's.
+func (c *Client) ReportTaskResult(ctx context.Context, token string, r *model.AgentTaskResult) error {
+	_, err := c.sendAs(ctx, token, http.MethodPost, taskPath(r.TaskID, "/result"), r, nil)
+	return err
+}
+
+// TaskMetrics returns a task's metric samples between from and to.
+func (c *Client) TaskMetrics(ctx context.Context, id string, from, to time.Time) ([]*model.TaskMetrics, error) {
+	var out []*model.TaskMetrics
+	return out, c.get(ctx, taskPath(id, "/metrics"), timeRange(from, to), &out)
+}
+
+// TaskSummary returns a task's totals and latency percentiles across agents.
+func (c *Client) TaskSummary(ctx context.Context, id string) (*TaskSummary, error) {
+	var sum TaskSummary
+	if err := c.get(ctx, taskPath(id, "/summary"), nil, &sum); err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+// TaskResult returns the result snapshot saved when a task finished. It
+// fails with ErrNotFound while the task is still running.
+func (c *Client) TaskResult(ctx context.Context, id string) (*TaskResult, error) {
+	var res TaskResult
+	if err := c.get(ctx, taskPath(id, "/result"), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CompareTasks returns the results of two finished tasks and b's change
+// relative to a. It fails with ErrConflict if either has not finished.
+func (c *Client) CompareTasks(ctx context.Context, a, b string) (*TaskComparison, error) {
+	var out TaskComparison
+	if err := c.get(ctx, "/api/v1/tasks/compare", url.Values{"a": {a}, "b": {b}}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ImportTasks creates or updates the document's profiles and tasks, matched
+// by external_id. With dryRun the master only reports what would change.
+func (c *Client) ImportTasks(ctx context.Context, doc *TaskDocument, dryRun bool) (*ImportResult, error) {
+	path := "/api/v1/tasks/import"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	var res ImportResult
+	if err := c.post(ctx, path, doc, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ExportTasks returns the profiles and tasks that have an external_id, in
+// the form ImportTasks accepts.
+func (c *Client) ExportTasks(ctx context.Context) (*TaskDocument, error) {
+	var doc TaskDocument
+	if err := c.get(ctx, "/api/v1/tasks/export", nil, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func taskPath(id, suffix string) string {
+	return "/api/v1/tasks/" + url.PathEscape(id) + suffix
+}
+
+// ─── Task templates ───────────────────────────────────────────────────────────
+
+// CreateTaskTemplate saves a task template. The master rejects specs that do
+// not on their own produce a valid task.
+func (c *Client) CreateTaskTemplate(ctx context.Context, req *TaskTemplateRequest) (*model.TaskTemplate, error) {
+	var t model.TaskTemplate
+	if err := c.post(ctx, "/api/v1/task-templates", req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTaskTemplates returns all task templates ordered by name.
+func (c *Client) ListTaskTemplates(ctx context.Context) ([]*model.TaskTemplate, error) {
+	var out []*model.TaskTemplate
+	return out, c.get(ctx, "/api/v1/task-templates", nil, &out)
+}
+
+// GetTaskTemplate returns one task template.
+func (c *Client) GetTaskTemplate(ctx context.Context, id string) (*model.TaskTemplate, error) {
+	var t model.TaskTemplate
+	if err := c.get(ctx, taskTemplatePath(id), nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateTaskTemplate replaces a template's name, description and spec.
+func (c *Client) UpdateTaskTemplate(ctx context.Context, id string, req *TaskTemplateRequest) (*model.TaskTemplate, error) {
+	var t model.TaskTemplate
+	if err := c.put(ctx, taskTemplatePath(id), req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteTaskTemplate deletes a task template.
+func (c *Client) DeleteTaskTemplate(ctx context.Context, id string) error {
+	return c.delete(ctx, taskTemplatePath(id))
+}
+
+// CreateTaskFromTemplate creates a task from a template. Keys in overrides
+// are task creation fields that replace the template's values; nil keeps the
+// template as is.
+func (c *Client) CreateTaskFromTemplate(ctx context.Context, id string, overrides map[string]any) (*model.Task, error) {
+	var body any
+	if len(overrides) > 0 {
+		body = overrides
+	}
+	var t model.Task
+	path := "/api/v1/tasks/from-template?" + url.Values{"template_id": {id}}.Encode()
+	if err := c.post(ctx, path, body, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func taskTemplatePath(id string) string {
+	return "/api/v1/task-templates/" + url.PathEscape(id)
+}
+
+// ─── Task groups ──────────────────────────────────────────────────────────────
+
+// CreateTaskGroup creates a task group and its child tasks.
+func (c *Client) CreateTaskGroup(ctx context.Context, req *CreateTaskGroupRequest) (*model.TaskGroup, error) {
+	var g model.TaskGroup
+	if err := c.post(ctx, "/api/v1/task-groups", req, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ListTaskGroups returns all task groups.
+func (c *Client) ListTaskGroups(ctx context.Context) ([]*model.TaskGroup, error) {
+	var out []*model.TaskGroup
+	return out, c.get(ctx, "/api/v1/task-groups", nil, &out)
+}
+
+// GetTaskGroup returns one task group with its children.
+func (c *Client) GetTaskGroup(ctx context.Context, id string) (*model.TaskGroup, error) {
+	var g model.TaskGroup
+	if err := c.get(ctx, groupPath(id, ""), nil, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// DispatchTaskGroup dispatches every pending child task.
+func (c *Client) DispatchTaskGroup(ctx context.Context, id string) error {
+	return c.post(ctx, groupPath(id, "/dispatch"), nil, nil)
+}
+
+// StopTaskGroup stops every non-terminal child task.
+func (c *Client) StopTaskGroup(ctx context.Context, id string) error {
+	return c.post(ctx, groupPath(id, "/stop"), nil, nil)
+}
+
+// TaskGroupMetrics returns metric samples for all children between from and to.
+func (c *Client) TaskGroupMetrics(ctx context.Context, id string, from, to time.Time) ([]*model.TaskMetrics, error) {
+	var out []*model.TaskMetrics
+	return out, c.get(ctx, groupPath(id, "/metrics"), timeRange(from, to), &out)
+}
+
+func groupPath(id, suffix string) string {
+	return "/api/v1/task-groups/" + url.PathEscape(id) + suffix
+}
+
+// ─── Agent groups ─────────────────────────────────────────────────────────────
+
+// CreateAgentGroup creates an agent group with optional initial members.
+func (c *Client) CreateAgentGroup(ctx context.Context, req *AgentGroupRequest) (*model.AgentGroup, error) {
+	var g model.AgentGroup
+	if err := c.post(ctx, "/api/v1/agent-groups", req, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ListAgentGroups returns all agent groups with their members.
+func (c *Client) ListAgentGroups(ctx context.Context) ([]*model.AgentGroup, error) {
+	var out []*model.AgentGroup
+	return out, c.get(ctx, "/api/v1/agent-groups", nil, &out)
+}
+
+// GetAgentGroup returns one agent group.
+func (c *Client) GetAgentGroup(ctx context.Context, id string) (*model.AgentGroup, error) {
+	var g model.AgentGroup
+	if err := c.get(ctx, agentGroupPath(id, ""), nil, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// DeleteAgentGroup deletes an agent group. It fails with ErrConflict while
+// an active task targets the group.
+func (c *Client) DeleteAgentGroup(ctx context.Context, id string) error {
+	return c.delete(ctx, agentGroupPath(id, ""))
+}
+
+// AddAgentGroupMembers adds agents to a group and returns the updated group.
+func (c *Client) AddAgentGroupMembers(ctx context.Context, id string, agentIDs ...string) (*model.AgentGroup, error) {
+	var g model.AgentGroup
+	if err := c.post(ctx, agentGroupPath(id, "/members"), map[string][]string{"agent_ids": agentIDs}, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// RemoveAgentGroupMember removes an agent from a group and returns the
+// updated group.
+func (c *Client) RemoveAgentGroupMember(ctx context.Context, id, agentID string) (*model.AgentGroup, error) {
+	var g model.AgentGroup
+	if err := c.do(ctx, http.MethodDelete, agentGroupPath(id, "/members/"+url.PathEscape(agentID)), nil, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// AgentGroupStats returns a group's online count and combined rate.
+func (c *Client) AgentGroupStats(ctx context.Context, id string) (*AgentGroupStats, error) {
+	var stats AgentGroupStats
+	if err := c.get(ctx, agentGroupPath(id, "/stats"), nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// DispatchAgentGroup dispatches every pending task that targets the group.
+func (c *Client) DispatchAgentGroup(ctx context.Context, id string) error {
+	return c.post(ctx, agentGroupPath(id, "/dispatch"), nil, nil)
+}
+
+// StopAgentGroup stops every active task running on the group's agents.
+func (c *Client) StopAgentGroup(ctx context.Context, id string) error {
+	return c.post(ctx, agentGroupPath(id, "/stop"), nil, nil)
+}
+
+func agentGroupPath(id, suffix string) string {
+	return "/api/v1/agent-groups/" + url.PathEscape(id) + suffix
+}
+
+// ─── URL pools ────────────────────────────────────────────────────────────────
+
+// CreateURLPool creates a URL pool.
+func (c *Client) CreateURLPool(ctx context.Context, req *URLPoolRequest) (*model.URLPool, error) {
+	var p model.URLPool
+	if err := c.post(ctx, "/api/v1/url-pools", req, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListURLPools returns all URL pools.
+func (c *Client) ListURLPools(ctx context.Context) ([]*model.URLPool, error) {
+	var out []*model.URLPool
+	return out, c.get(ctx, "/api/v1/url-pools", nil, &out)
+}
+
+// GetURLPool returns one URL pool.
+func (c *Client) GetURLPool(ctx context.Context, id string) (*model.URLPool, error) {
+	var p model.URLPool
+	if err := c.get(ctx, "/api/v1/url-pools/"+url.PathEscape(id), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdateURLPool replaces a URL pool's fields.
+func (c *Client) UpdateURLPool(ctx context.Context, id string, req *URLPoolRequest) (*model.URLPool, error) {
+	var p model.URLPool
+	if err := c.put(ctx, "/api/v1/url-pools/"+url.PathEscape(id), req, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeleteURLPool deletes a URL pool that no task references.
+func (c *Client) DeleteURLPool(ctx context.Context, id string) error {
+	return c.delete(ctx, "/api/v1/url-pools/"+url.PathEscape(id))
+}
+
+// ─── Traffic profiles ─────────────────────────────────────────────────────────
+
+// CreateTrafficProfile creates a traffic profile.
+func (c *Client) CreateTrafficProfile(ctx context.Context, req *TrafficProfileRequest) (*model.TrafficProfile, error) {
+	var p model.TrafficProfile
+	if err := c.post(ctx, "/api/v1/traffic-profiles", req, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListTrafficProfiles returns all traffic profiles.
+func (c *Client) ListTrafficProfiles(ctx context.Context) ([]*model.TrafficProfile, error) {
+	var out []*model.TrafficProfile
+	return out, c.get(ctx, "/api/v1/traffic-profiles", nil, &out)
+}
+
+// ─── Provisioning ─────────────────────────────────────────────────────────────
+
+// StartProvision starts an SSH provisioning job.
+func (c *Client) StartProvision(ctx context.Context, req *ProvisionRequest) (*model.ProvisionJob, error) {
+	var j model.ProvisionJob
+	if err := c.post(ctx, "/api/v1/agents/provision", req, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ListProvisionJobs returns all provisioning jobs.
+func (c *Client) ListProvisionJobs(ctx context.Context) ([]*model.ProvisionJob, error) {
+	var out []*model.ProvisionJob
+	return out, c.get(ctx, "/api/v1/agents/provision-jobs", nil, &out)
+}
+
+// GetProvisionJob returns one provisioning job, including its log.
+func (c *Client) GetProvisionJob(ctx context.Context, id string) (*model.ProvisionJob, error) {
+	var j model.ProvisionJob
+	if err := c.get(ctx, jobPath(id, ""), nil, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// RetryProvisionJob re-runs a failed job.
+func (c *Client) RetryProvisionJob(ctx context.Context, id string) (*model.ProvisionJob, error) {
+	var j model.ProvisionJob
+	if err := c.post(ctx, jobPath(id, "/retry"), nil, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// UninstallProvisionJob removes the agent a job installed.
+func (c *Client) UninstallProvisionJob(ctx context.Context, id string) error {
+	return c.post(ctx, jobPath(id, "/uninstall"), nil, nil)
+}
+
+// DeleteProvisionJob deletes a job record.
+func (c *Client) DeleteProvisionJob(ctx context.Context, id string) error {
+	return c.delete(ctx, jobPath(id, ""))
+}
+
+func jobPath(id, suffix string) string {
+	return "/api/v1/agents/provision-jobs/" + url.PathEscape(id) + suffix
+}
+
+// ─── Credentials ──────────────────────────────────────────────────────────────
+
+// CreateCredential stores an SSH credential. The payload is never returned.
+func (c *Client) CreateCredential(ctx context.Context, req *CredentialRequest) (*model.Credential, error) {
+	var cred model.Credential
+	if err := c.post(ctx, "/api/v1/credentials", req, &cred); err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// ListCredentials returns credentials with payloads scrubbed.
+func (c *Client) ListCredentials(ctx context.Context) ([]*model.Credential, error) {
+	var out []*model.Credential
+	return out, c.get(ctx, "/api/v1/credentials", nil, &out)
+}
+
+// DeleteCredential deletes a credential.
+func (c *Client) DeleteCredential(ctx context.Context, id string) error {
+	return c.delete(ctx, "/api/v1/credentials/"+url.PathEscape(id))
+}
+
+// ─── Dashboard ────────────────────────────────────────────────────────────────
+
+// Overview returns the cached dashboard overview with the master's default
+// number of top tasks.
+func (c *Client) Overview(ctx context.Context) (*Overview, error) {
+	return c.overview(ctx, nil)
+}
+
+// OverviewTop returns the dashboard overview listing up to n of the running
+// tasks with the highest current rate.
+func (c *Client) OverviewTop(ctx context.Context, n int) (*Overview, error) {
+	return c.overview(ctx, url.Values{"top": {strconv.Itoa(n)}})
+}
+
+func (c *Client) overview(ctx context.Context, query url.Values) (*Overview, error) {
+	var o Overview
+	if err := c.get(ctx, "/api/v1/dashboard/overview", query, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// BandwidthHistory returns aggregated bandwidth between from and to. step is
+// a duration such as 10s, 15m or 1h, or a number of seconds; empty means 1m.
+// The master widens the step if the range would exceed its point cap.
+func (c *Client) BandwidthHistory(ctx context.Context, from, to time.Time, step string) ([]BandwidthPoint, error) {
+	points, _, err := c.BandwidthHistoryMax(ctx, from, to, step, 0)
+	return points, err
+}
+
+// BandwidthHistoryMax is BandwidthHistory returning at most maxPoints points
+// (0 means the master's cap). It also returns the step in seconds the master
+// used.
+func (c *Client) BandwidthHistoryMax(ctx context.Context, from, to time.Time, step string, maxPoints int) ([]BandwidthPoint, int, error) {
+	q := timeRange(from, to)
+	if step != "" {
+		q.Set("step", step)
+	}
+	if maxPoints > 0 {
+		q.Set("max_points", strconv.Itoa(maxPoints))
+	}
+	var out []BandwidthPoint
+	header, err := c.send(ctx, http.MethodGet, "/api/v1/dashboard/bandwidth/history?"+q.Encode(), nil, &out)
+	if err != nil {
+		return nil, 0, err
+	}
+	stepSec, _ := strconv.Atoi(header.Get("X-Step-Seconds"))
+	return out, stepSec, nil
+}
+
+// timeRange encodes from/to query params; zero times use the server default.
+func timeRange(from, to time.Time) url.Values {
+	q := url.Values{}
+	if !from.IsZero() {
+		q.Set("from", from.UTC().Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.UTC().Format(time.RFC3339))
+	}
+	return q
+}
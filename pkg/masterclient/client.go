@@ -0,0 +1,187 @@
+// Package masterclient is a typed Go client for the Master HTTP API.
+package masterclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Errors matched by APIError.Is, so callers can branch on the kind of
+// failure without inspecting status codes.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrThrottled    = errors.New("too many requests")
+)
+
+// APIError is returned for any non-2xx response.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("http %d: %s", e.StatusCode, e.Message)
+}
+
+// Is maps the status code onto the package's sentinel errors.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrThrottled:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Client talks to a Master instance. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	timeout    time.Duration // set by WithTimeout; zero keeps httpClient's
+	httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sets the Master base URL (default http://localhost:8080).
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimRight(u, "/") }
+}
+
+// WithAPIKey sends key as a bearer token on every request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithTimeout sets the per-request timeout (default 30s). It applies to a
+// client given with WithHTTPClient too, without changing the caller's
+// http.Client.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithHTTPClient replaces the underlying http.Client. Its own timeout is
+// kept unless WithTimeout is also given.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// New creates a Client.
+func New(opts ...Option) *Client {
+	c := &Client{
+		baseURL:    "http://localhost:8080",
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.timeout > 0 {
+		hc := *c.httpClient
+		hc.Timeout = c.timeout
+		c.httpClient = &hc
+	}
+	return c
+}
+
+// BaseURL returns the Master base URL the client is configured with.
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// ─── HTTP helpers ─────────────────────────────────────────────────────────────
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out any) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return c.do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (c *Client) post(ctx context.Context, path string, body, out any) error {
+	return c.do(ctx, http.MethodPost, path, body, out)
+}
+
+func (c *Client) put(ctx context.Context, path string, body, out any) error {
+	return c.do(ctx, http.MethodPut, path, body, out)
+}
+
+func (c *Client) delete(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	_, err := c.send(ctx, method, path, body, out)
+	return err
+}
+
+// send performs the request and returns the response headers alongside any
+// error, for endpoints that report metadata such as X-Total-Count.
+func (c *Client) send(ctx context.Context, method, path string, body, out any) (http.Header, error) {
+	return c.sendAs(ctx, c.apiKey, method, path, body, out)
+}
+
+// sendAs is send with bearer, such as an agent's token, in place of the API
+// key.
+func (c *Client) sendAs(ctx context.Context, bearer, method, path string, body, out any) (http.Header, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http %s %s: %w", req.Method, req.URL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return res.Header, decodeError(res)
+	}
+	if out != nil {
+		return res.Header, json.NewDecoder(res.Body).Decode(out)
+	}
+	return res.Header, nil
+}
+
+// decodeError turns an error response into an *APIError, using the master's
+// {"error": "..."} body when present.
+func decodeError(res *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	var payload struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(raw))
+	if json.Unmarshal(raw, &payload) == nil && payload.Error != "" {
+		msg = payload.Error
+	}
+	return &APIError{StatusCode: res.StatusCode, Message: msg}
+}
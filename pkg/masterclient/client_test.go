@@ -0,0 +1,85 @@
+package masterclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+	"github.com/aven/ngoogle/pkg/masterclient"
+)
+
+func TestGetTaskDecodesModel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/tasks/t1" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer k" {
+			t.Errorf("Authorization = %q", got)
+		}
+		_ = json.NewEncoder(w).Encode(model.Task{ID: "t1", Name: "demo"})
+	}))
+	defer srv.Close()
+
+	c := masterclient.New(masterclient.WithBaseURL(srv.URL+"/"), masterclient.WithAPIKey("k"))
+	task, err := c.GetTask(context.Background(), "t1")
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if task.ID != "t1" || task.Name != "demo" {
+		t.Fatalf("unexpected task %+v", task)
+	}
+}
+
+func TestErrorsMapToSentinels(t *testing.T) {
+	cases := []struct {
+		code int
+		want error
+	}{
+		{http.StatusBadRequest, masterclient.ErrBadRequest},
+		{http.StatusUnauthorized, masterclient.ErrUnauthorized},
+		{http.StatusNotFound, masterclient.ErrNotFound},
+		{http.StatusConflict, masterclient.ErrConflict},
+	}
+	for _, tc := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.code)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "boom"})
+		}))
+		err := masterclient.New(masterclient.WithBaseURL(srv.URL)).DeleteAgent(context.Background(), "a1")
+		srv.Close()
+
+		if !errors.Is(err, tc.want) {
+			t.Errorf("status %d: got %v, want %v", tc.code, err, tc.want)
+		}
+		var apiErr *masterclient.APIError
+		if !errors.As(err, &apiErr) || apiErr.Message != "boom" {
+			t.Errorf("status %d: expected APIError with message boom, got %v", tc.code, err)
+		}
+	}
+}
+
+func TestWithTimeoutLeavesCallersClientAlone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(model.Task{ID: "t1"})
+	}))
+	defer srv.Close()
+
+	hc := &http.Client{Timeout: time.Minute}
+	c := masterclient.New(masterclient.WithBaseURL(srv.URL), masterclient.WithTimeout(20*time.Millisecond), masterclient.WithHTTPClient(hc))
+	if hc.Timeout != time.Minute {
+		t.Fatalf("caller's client timeout changed to %s", hc.Timeout)
+	}
+	if _, err := c.GetTask(context.Background(), "t1"); err == nil {
+		t.Fatal("request outlived WithTimeout")
+	}
+	// Without WithTimeout the given client's own timeout applies.
+	if _, err := masterclient.New(masterclient.WithBaseURL(srv.URL), masterclient.WithHTTPClient(hc)).GetTask(context.Background(), "t1"); err != nil {
+		t.Fatal(err)
+	}
+}
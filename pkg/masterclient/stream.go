@@ -0,0 +1,94 @@
+package masterclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aven/ngoogle/internal/model"
+)
+
+// ErrStreamIdle is returned by StreamTasks when the master sent nothing for
+// longer than the idle limit.
+var ErrStreamIdle = errors.New("task stream idle")
+
+// StreamTasks subscribes to an agent's task stream and calls fn with every
+// task list the master pushes, starting with the current one. Each list is
+// complete: tasks missing from it are no longer assigned to the agent.
+//
+// It blocks until ctx is done or the stream ends, and always returns an
+// error. The master sends a list at least every 15s, so a stream silent for
+// longer than idle is treated as broken and ends with ErrStreamIdle.
+func (c *Client) StreamTasks(ctx context.Context, agentID string, idle time.Duration, fn func(tasks []*model.Task)) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/v1/agents/"+url.PathEscape(agentID)+"/tasks/stream", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	// The stream outlives the per-request timeout; the idle watchdog takes
+	// its place.
+	hc := *c.httpClient
+	hc.Timeout = 0
+	watchdog := time.AfterFunc(idle, func() { cancel(ErrStreamIdle) })
+	defer watchdog.Stop()
+	res, err := hc.Do(req)
+	if err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, ErrStreamIdle) {
+			return cause
+		}
+		return fmt.Errorf("http %s %s: %w", req.Method, req.URL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return decodeError(res)
+	}
+
+	sc := bufio.NewScanner(res.Body)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	var event, data string
+	for sc.Scan() {
+		watchdog.Reset(idle)
+		line := sc.Text()
+		if line == "" {
+			// A blank line ends the event.
+			if event == "tasks" {
+				var tasks []*model.Task
+				if err := json.Unmarshal([]byte(data), &tasks); err != nil {
+					return fmt.Errorf("decode task stream: %w", err)
+				}
+				fn(tasks)
+			}
+			event, data = "", ""
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		}
+	}
+	if cause := context.Cause(ctx); cause != nil {
+		return cause
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read task stream: %w", err)
+	}
+	return errors.New("task stream closed by master")
+}
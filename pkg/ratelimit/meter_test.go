@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestMeterAtHighRecordRate feeds a meter 10k records a second for a minute
+// of simulated time: the rates stay accurate and recording allocates nothing.
+func TestMeterAtHighRecordRate(t *testing.T) {
+	m := &Meter{}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	const perSec, size = 10_000, 1_000 // 80 Mbps
+	var now time.Time
+	for i := range 60 * perSec {
+		now = start.Add(time.Duration(i) * time.Second / perSec)
+		m.recordAt(now, size)
+	}
+
+	for _, window := range []time.Duration{5 * time.Second, 30 * time.Second} {
+		if got := m.rateAt(now, window); math.Abs(got-80) > 80*0.02 {
+			t.Errorf("rate over %v = %.2f Mbps, want about 80", window, got)
+		}
+	}
+	if got := m.TotalBytes(); got != 60*perSec*size {
+		t.Errorf("TotalBytes = %d, want %d", got, 60*perSec*size)
+	}
+	if allocs := testing.AllocsPerRun(1000, func() { m.Record(size) }); allocs != 0 {
+		t.Errorf("Record allocates %.0f times per call", allocs)
+	}
+}
+
+func TestMeterForgetsOldBuckets(t *testing.T) {
+	m := &Meter{}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.recordAt(start, 1_000_000)
+
+	// 30s later the ring has come round to the same bucket.
+	later := start.Add(30 * time.Second)
+	if got := m.rateAt(later, 30*time.Second); got != 0 {
+		t.Errorf("rate 30s later = %.2f Mbps, want 0", got)
+	}
+	m.recordAt(later, 1_000)
+	if got := m.rateAt(later, 5*time.Second); math.Abs(got-1_000*8/5e6) > 1e-9 {
+		t.Errorf("rate = %f Mbps, want only the new record", got)
+	}
+}
+
+func BenchmarkMeterRecord(b *testing.B) {
+	m := &Meter{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Record(64 * 1024)
+	}
+}
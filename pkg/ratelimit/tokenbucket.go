@@ -5,80 +5,171 @@ import (
 	"context"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // TokenBucket is a thread-safe token bucket rate limiter.
 // It limits byte throughput to a configured rate in Mbps.
 type TokenBucket struct {
+	// unlimited is set for a bucket created without a rate until SetRate
+	// gives it one; Wait and TryConsume then return at once.
+	unlimited atomic.Bool
+
 	mu       sync.Mutex
-	tokens   float64   // available tokens (bytes)
-	capacity float64   // max burst capacity (bytes)
-	rate     float64   // fill rate (bytes/sec)
+	tokens   float64 // available tokens (bytes), negative while reserved
+	capacity float64 // max burst capacity (bytes)
+	rate     float64 // fill rate (bytes/sec)
+	filled   float64 // tokens added since creation, to settle reservations against
+	burst    float64 // burst capacity multiplier given to New
+	floor    float64 // least capacity for the readers given to SetReaders
+	fixed    bool    // capacity set by NewWithBurst, kept by SetRate
 	lastFill time.Time
 }
 
-// New creates a TokenBucket for the given rate in Mbps.
+// minCapacity is the smallest burst capacity (bytes), so very low rates still
+// admit a full read buffer at once.
+const minCapacity = 64 * 1024
+
+// maxWait bounds a single sleep in Wait, so a waiter notices SetRate
+// raising the rate, or setting it from zero, within this long.
+const maxWait = 100 * time.Millisecond
+
+// New creates a TokenBucket for the given rate in Mbps; a rate of zero or
+// less creates an unlimited bucket.
 // burst is the burst capacity multiplier (e.g. 2 = allow 2x the 1s rate as burst).
 func New(rateMbps float64, burstMultiplier float64) *TokenBucket {
-	if rateMbps <= 0 {
-		rateMbps = math.MaxFloat64 / 1e6 // effectively unlimited
-	}
-	bps := rateMbps * 1e6 / 8 // bytes per second
-	capacity := bps * burstMultiplier
-	if capacity < 65536 {
-		capacity = 65536 // min 64 KB burst
-	}
-	return &TokenBucket{
-		tokens:   capacity,
-		capacity: capacity,
+	bps := max(rateMbps, 0) * 1e6 / 8 // bytes per second
+	tb := &TokenBucket{
 		rate:     bps,
+		burst:    burstMultiplier,
 		lastFill: time.Now(),
 	}
+	tb.capacity = tb.capacityFor(bps)
+	tb.tokens = tb.capacity
+	tb.unlimited.Store(rateMbps <= 0)
+	return tb
+}
+
+// NewWithBurst creates a TokenBucket for the given rate in Mbps whose burst
+// capacity is exactly burstBytes, however low the rate, and stays so across
+// SetRate. A small burst shapes low rates smoothly, where New would let up to
+// two seconds of traffic through at once, at the cost of more frequent
+// waits; reads larger than the burst still pass, one deficit at a time.
+func NewWithBurst(rateMbps float64, burstBytes int64) *TokenBucket {
+	tb := New(rateMbps, 0)
+	tb.capacity = float64(max(burstBytes, 1))
+	tb.tokens = tb.capacity
+	tb.fixed = true
+	return tb
 }
 
-// SetRate updates the rate at runtime (Mbps).
+// capacityFor returns the burst capacity for a fill rate of bps bytes/sec.
+func (tb *TokenBucket) capacityFor(bps float64) float64 {
+	return max(bps*tb.burst, minCapacity, tb.floor)
+}
+
+// SetReaders sizes the burst capacity for n readers sharing the bucket,
+// each consuming up to readBytes at a time, so that it holds at least one
+// read for every reader. With a capacity of a single read, whichever reader
+// asks first takes the whole burst and the others queue behind it on every
+// refill; at high concurrency and low rates that serialises the readers.
+// A burst fixed by NewWithBurst is kept: it was asked for explicitly. Call
+// SetReaders before the bucket is used; the capacity it adds starts full.
+func (tb *TokenBucket) SetReaders(n int, readBytes int64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.floor = float64(max(n, 1)) * float64(max(readBytes, 0))
+	if tb.fixed {
+		return
+	}
+	capacity := tb.capacityFor(tb.rate)
+	if capacity > tb.capacity {
+		tb.tokens += capacity - tb.capacity
+	}
+	tb.capacity = capacity
+	tb.tokens = math.Min(tb.tokens, tb.capacity)
+}
+
+// SetRate updates the rate at runtime (Mbps). The burst capacity follows
+// it, keeping the multiplier given to New, unless NewWithBurst fixed it.
+// Unlike New, a rate of zero admits nothing until the rate is raised again,
+// and an unlimited bucket becomes limited.
 func (tb *TokenBucket) SetRate(rateMbps float64) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
-	bps := rateMbps * 1e6 / 8
+	tb.fill()
+	tb.unlimited.Store(false)
+	bps := max(rateMbps, 0) * 1e6 / 8
 	tb.rate = bps
-	tb.capacity = bps * 2
+	if !tb.fixed {
+		tb.capacity = tb.capacityFor(bps)
+	}
 	if tb.tokens > tb.capacity {
 		tb.tokens = tb.capacity
 	}
 }
 
 // Wait blocks until n bytes can be consumed from the bucket,
-// respecting the provided context.
+// respecting the provided context. Bytes that are not available yet are
+// reserved at once, so waiters are served in order, and the caller sleeps for
+// its whole deficit instead of polling; if ctx ends first the reservation is
+// given back.
 func (tb *TokenBucket) Wait(ctx context.Context, n int64) error {
+	if tb.unlimited.Load() {
+		return nil
+	}
+	tb.mu.Lock()
+	tb.fill()
+	tb.tokens -= float64(n)
+	// The reservation is settled once the bucket has refilled the deficit.
+	due := tb.filled - tb.tokens
+	wait := tb.waitFor(due)
+	tb.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
 	for {
-		tb.mu.Lock()
-		tb.fill()
-		if tb.tokens >= float64(n) {
-			tb.tokens -= float64(n)
+		select {
+		case <-ctx.Done():
+			tb.mu.Lock()
+			tb.tokens = math.Min(tb.tokens+float64(n), tb.capacity)
 			tb.mu.Unlock()
-			return nil
+			return ctx.Err()
+		case <-timer.C:
 		}
-		// calculate wait duration
-		deficit := float64(n) - tb.tokens
-		waitDur := time.Duration(deficit / tb.rate * float64(time.Second))
+		tb.mu.Lock()
+		tb.fill()
+		wait = tb.waitFor(due)
 		tb.mu.Unlock()
-
-		if waitDur < time.Millisecond {
-			waitDur = time.Millisecond
+		if wait <= 0 {
+			return nil
 		}
+		timer.Reset(wait)
+	}
+}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(waitDur):
-		}
+// waitFor returns how long until filled reaches due at the current rate, at
+// most maxWait. Must be called with lock held.
+func (tb *TokenBucket) waitFor(due float64) time.Duration {
+	deficit := due - tb.filled
+	if deficit <= 0 {
+		return 0
+	}
+	if tb.rate <= 0 || deficit/tb.rate >= maxWait.Seconds() {
+		return maxWait
 	}
+	return time.Duration(deficit / tb.rate * float64(time.Second))
 }
 
 // TryConsume immediately consumes n bytes if available. Returns false if not enough tokens.
 func (tb *TokenBucket) TryConsume(n int64) bool {
+	if tb.unlimited.Load() {
+		return true
+	}
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 	tb.fill()
@@ -94,38 +185,59 @@ func (tb *TokenBucket) fill() {
 	now := time.Now()
 	elapsed := now.Sub(tb.lastFill).Seconds()
 	tb.lastFill = now
-	tb.tokens += elapsed * tb.rate
-	if tb.tokens > tb.capacity {
-		tb.tokens = tb.capacity
+	added := math.Min(elapsed*tb.rate, tb.capacity-tb.tokens)
+	if added > 0 {
+		tb.tokens += added
+		tb.filled += added
 	}
 }
 
 // ─── Sliding Window Rate Meter ────────────────────────────────────────────────
 
-// Meter tracks byte throughput over sliding windows.
+// Meter tracks byte throughput over sliding windows. Bytes are summed into
+// a ring of fixed sub-second buckets, so its size does not grow with the
+// number of records however high the request rate.
 type Meter struct {
 	mu      sync.Mutex
-	samples []sample
+	buckets [meterBuckets]meterBucket
 	total   int64 // cumulative bytes recorded
 }
 
-type sample struct {
-	ts    time.Time
+// meterBucketWidth is the resolution of a Meter's windows, and meterBuckets
+// enough of them to cover the longest window, 30s.
+const (
+	meterBucketWidth = 100 * time.Millisecond
+	meterBuckets     = int64(30 * time.Second / meterBucketWidth)
+)
+
+// meterBucket holds the bytes recorded in one meterBucketWidth interval,
+// numbered from the Unix epoch.
+type meterBucket struct {
+	slot  int64
 	bytes int64
 }
 
 // Record adds a byte count at the current time.
-func (m *Meter) Record(n int64) {
+func (m *Meter) Record(n int64) { m.recordAt(time.Now(), n) }
+
+func (m *Meter) recordAt(now time.Time, n int64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	now := time.Now()
 	m.total += n
-	m.samples = append(m.samples, sample{ts: now, bytes: n})
-	// keep only last 30s
-	cutoff := now.Add(-30 * time.Second)
-	for len(m.samples) > 0 && m.samples[0].ts.Before(cutoff) {
-		m.samples = m.samples[1:]
+	slot := now.UnixNano() / int64(meterBucketWidth)
+	b := &m.buckets[slot%meterBuckets]
+	if b.slot != slot {
+		*b = meterBucket{slot: slot} // last used 30s or more ago
 	}
+	b.bytes += n
+}
+
+// Seed adds n bytes to the cumulative total without counting them towards
+// the rates, so that a resumed task keeps counting from where it stopped.
+func (m *Meter) Seed(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total += n
 }
 
 // TotalBytes returns the cumulative total bytes recorded.
@@ -141,14 +253,18 @@ func (m *Meter) Rate5s() float64 { return m.rateOver(5 * time.Second) }
 // Rate30s returns the average rate in Mbps over the last 30 seconds.
 func (m *Meter) Rate30s() float64 { return m.rateOver(30 * time.Second) }
 
-func (m *Meter) rateOver(window time.Duration) float64 {
+func (m *Meter) rateOver(window time.Duration) float64 { return m.rateAt(time.Now(), window) }
+
+// rateAt returns the rate over the window buckets ending with now's.
+func (m *Meter) rateAt(now time.Time, window time.Duration) float64 {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	cutoff := time.Now().Add(-window)
+	slot := now.UnixNano() / int64(meterBucketWidth)
+	oldest := slot - int64(window/meterBucketWidth) // exclusive
 	var total int64
-	for _, s := range m.samples {
-		if s.ts.After(cutoff) {
-			total += s.bytes
+	for _, b := range m.buckets {
+		if b.slot > oldest && b.slot <= slot {
+			total += b.bytes
 		}
 	}
 	secs := window.Seconds()
@@ -2,6 +2,8 @@ package ratelimit_test
 
 import (
 	"context"
+	"math"
+	"sync"
 	"testing"
 	"time"
 
@@ -63,6 +65,22 @@ func TestTokenBucketContextCancel(t *testing.T) {
 	}
 }
 
+func TestTokenBucketWaitBeyondCapacity(t *testing.T) {
+	// 1 Mbps = 125 KB/s with the minimum 64 KB burst: a 256 KB read is
+	// more than the bucket ever holds and must still be served.
+	tb := ratelimit.New(1, 0.1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := tb.Wait(ctx, 256*1024); err != nil {
+		t.Fatalf("Wait returned error: %v", err)
+	}
+	// 64 KB from the burst, the remaining 192 KB at 125 KB/s.
+	if elapsed := time.Since(start); elapsed < 1200*time.Millisecond || elapsed > 2500*time.Millisecond {
+		t.Errorf("wait took %v, want about 1.6s", elapsed)
+	}
+}
+
 func TestTokenBucketSetRate(t *testing.T) {
 	tb := ratelimit.New(1, 1.0)
 	tb.SetRate(100) // increase to 100 Mbps
@@ -74,6 +92,193 @@ func TestTokenBucketSetRate(t *testing.T) {
 	}
 }
 
+func TestTokenBucketUnlimited(t *testing.T) {
+	tb := ratelimit.New(0, 2.0)
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		if err := tb.Wait(ctx, math.MaxInt64); err != nil {
+			t.Fatalf("Wait returned error: %v", err)
+		}
+	}
+	if !tb.TryConsume(math.MaxInt64) {
+		t.Fatal("TryConsume refused an unlimited bucket")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("unlimited waits took %v", elapsed)
+	}
+
+	// Given a rate later, the bucket limits with finite, non-NaN tokens:
+	// its 64 KB burst is there, and not a byte more.
+	tb.SetRate(1)
+	if !tb.TryConsume(64 * 1024) {
+		t.Fatal("no burst after SetRate on an unlimited bucket")
+	}
+	if tb.TryConsume(64 * 1024) {
+		t.Fatal("bucket stayed unlimited after SetRate")
+	}
+}
+
+func TestTokenBucketSetRateKeepsBurst(t *testing.T) {
+	// 10 Mbps = 1.25 MB/s with a 5x burst: 6.25 MB, pre-filled.
+	tb := ratelimit.New(10, 5.0)
+	tb.SetRate(10)
+	if !tb.TryConsume(6_000_000) {
+		t.Fatal("SetRate cut the 5x burst")
+	}
+
+	// At 10 kbps the burst multiplier gives 2.5 KB; the 64 KB floor holds.
+	tb = ratelimit.New(0.01, 2.0)
+	tb.SetRate(0.01)
+	if !tb.TryConsume(64 * 1024) {
+		t.Fatal("SetRate dropped the burst below 64 KB")
+	}
+}
+
+func TestTokenBucketWaitFollowsSetRateFromZero(t *testing.T) {
+	tb := ratelimit.New(1, 1.0)
+	tb.SetRate(0) // e.g. the start of a ramp
+	done := make(chan error, 1)
+	go func() { done <- tb.Wait(context.Background(), 128*1024) }()
+
+	select {
+	case <-done:
+		t.Fatal("Wait returned at rate 0")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tb.SetRate(100)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not notice the raised rate")
+	}
+}
+
+func TestTokenBucketCancelReturnsReservation(t *testing.T) {
+	tb := ratelimit.New(1, 1.0) // 64 KB burst, 125 KB/s
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tb.Wait(ctx, 1_000_000); err == nil {
+		t.Fatal("expected context error but got nil")
+	}
+	// The cancelled wait reserved nothing for good: the burst is still there.
+	if !tb.TryConsume(60_000) {
+		t.Fatal("cancelled Wait kept its reservation")
+	}
+}
+
+func TestTokenBucketBurstSmoothness(t *testing.T) {
+	// 0.5 Mbps = 62.5 KB/s, about 6 KB per 100ms, read 4 KB at a time.
+	const window = 100 * time.Millisecond
+	peak := func(tb *ratelimit.TokenBucket) int64 {
+		var admitted []time.Time
+		start := time.Now()
+		for time.Since(start) < 400*time.Millisecond {
+			if err := tb.Wait(context.Background(), 4096); err != nil {
+				t.Fatalf("Wait returned error: %v", err)
+			}
+			admitted = append(admitted, time.Now())
+		}
+		// The most bytes let through in any window.
+		var most int64
+		for i := range admitted {
+			var n int64
+			for _, at := range admitted[i:] {
+				if at.Sub(admitted[i]) >= window {
+					break
+				}
+				n += 4096
+			}
+			most = max(most, n)
+		}
+		return most
+	}
+
+	// New's burst is two seconds of traffic, 125 KB, all let through at once.
+	if got := peak(ratelimit.New(0.5, 2.0)); got < 100_000 {
+		t.Errorf("default burst: peak %d bytes per %s, want at least 100000", got, window)
+	}
+	// A one-read burst holds every window close to the rate.
+	if got := peak(ratelimit.NewWithBurst(0.5, 4096)); got > 16_384 {
+		t.Errorf("4 KB burst: peak %d bytes per %s, want at most 16384", got, window)
+	}
+}
+
+func TestTokenBucketSetRateKeepsFixedBurst(t *testing.T) {
+	tb := ratelimit.NewWithBurst(100, 8192)
+	tb.SetRate(200)
+	if !tb.TryConsume(8192) {
+		t.Fatal("fixed burst not pre-filled")
+	}
+	tb = ratelimit.NewWithBurst(100, 8192)
+	tb.SetRate(200)
+	if tb.TryConsume(16384) {
+		t.Fatal("SetRate grew the fixed burst")
+	}
+}
+
+func TestTokenBucketSetReadersSizesBurst(t *testing.T) {
+	// 1 Mbps with a 1x burst holds 125 KB, less than eight 64 KB reads.
+	tb := ratelimit.New(1, 1.0)
+	tb.SetReaders(8, 64<<10)
+	if !tb.TryConsume(8 << 16) {
+		t.Fatal("burst does not hold one read per reader")
+	}
+	tb = ratelimit.NewWithBurst(1, 8192)
+	tb.SetReaders(8, 64<<10)
+	if tb.TryConsume(16384) {
+		t.Fatal("SetReaders grew a fixed burst")
+	}
+}
+
+func TestTokenBucketSharedByConcurrentReaders(t *testing.T) {
+	// 40 Mbps = 5 MB/s shared by 8 readers of 64 KB, with a burst of one
+	// read each.
+	const (
+		readers   = 8
+		readBytes = 64 << 10
+		rateBps   = 5_000_000
+		run       = 1500 * time.Millisecond
+	)
+	tb := ratelimit.New(40, 0.1)
+	tb.SetReaders(readers, readBytes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), run)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		read [readers]int64
+	)
+	for i := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tb.Wait(ctx, readBytes) == nil {
+				read[i] += readBytes
+			}
+		}()
+	}
+	wg.Wait()
+
+	var total int64
+	for _, n := range read {
+		total += n
+	}
+	want := float64(readers*readBytes) + rateBps*run.Seconds()
+	if got := float64(total); math.Abs(got-want)/want > 0.15 {
+		t.Errorf("readers got %d bytes together, want about %.0f", total, want)
+	}
+	mean := total / readers
+	for i, n := range read {
+		if n < mean/2 || n > mean*2 {
+			t.Errorf("reader %d got %d bytes, want about the mean %d: %v", i, n, mean, read)
+		}
+	}
+}
+
 func TestMeterRates(t *testing.T) {
 	m := &ratelimit.Meter{}
 
@@ -102,3 +307,35 @@ func TestMeterRates(t *testing.T) {
 		t.Logf("Rate5s (%.2f) vs Rate30s (%.2f) - unexpected", rate5s, rate30s)
 	}
 }
+
+func TestMeterSeedCountsTowardsTotalOnly(t *testing.T) {
+	m := &ratelimit.Meter{}
+	m.Seed(5_000_000)
+	m.Record(1_000)
+	if got := m.TotalBytes(); got != 5_001_000 {
+		t.Errorf("TotalBytes = %d, want 5001000", got)
+	}
+	if rate := m.Rate5s(); rate > 0.01 {
+		t.Errorf("Rate5s = %f, want only the recorded bytes", rate)
+	}
+}
+
+// BenchmarkTokenBucketWaitHighRate has 64 readers per CPU share a 10 Gbps
+// bucket with the minimum 64 KB burst in 64 KB reads, the way static
+// download workers do. Each read waits for tens of microseconds; MB/s should
+// approach 1250, and -cpuprofile show little time spent in Wait.
+func BenchmarkTokenBucketWaitHighRate(b *testing.B) {
+	const chunk = 64 * 1024
+	tb := ratelimit.New(10_000, 0)
+	ctx := context.Background()
+	b.SetBytes(chunk)
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := tb.Wait(ctx, chunk); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
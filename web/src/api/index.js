@@ -20,6 +20,7 @@ export const agentsApi = {
   list: () => req('GET', '/agents'),
   get: (id) => req('GET', `/agents/${id}`),
   delete: (id) => req('DELETE', `/agents/${id}`),
+  setEnabled: (id, enabled) => req('POST', `/agents/${id}/${enabled ? 'enable' : 'disable'}`),
   provision: (data) => req('POST', '/agents/provision', data),
   listProvisionJobs: () => req('GET', '/agents/provision-jobs'),
   getProvisionJob: (id) => req('GET', `/agents/provision-jobs/${id}`),
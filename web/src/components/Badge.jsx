@@ -9,8 +9,11 @@ const variants = {
   done:         { color: 'var(--green)',  bg: 'var(--green-dim)',  pulse: false },
   failed:       { color: 'var(--red)',    bg: 'var(--red-dim)',    pulse: false },
   stopped:      { color: 'var(--text-muted)', bg: 'rgba(170,168,159,0.12)', pulse: false },
+  paused:       { color: 'var(--amber)',  bg: 'var(--amber-dim)',  pulse: false },
   success:      { color: 'var(--green)',  bg: 'var(--green-dim)',  pulse: false },
   provisioning: { color: 'var(--amber)',  bg: 'var(--amber-dim)',  pulse: true },
+  interrupted:  { color: 'var(--amber)',  bg: 'var(--amber-dim)',  pulse: false },
+  disabled:     { color: 'var(--red)',    bg: 'var(--red-dim)',    pulse: false },
   'ssh key':    { color: 'var(--blue)',   bg: 'var(--blue-dim)',   pulse: false },
   password:     { color: 'var(--purple)', bg: 'var(--purple-dim)', pulse: false },
   youtube:      { color: 'var(--red)',    bg: 'var(--red-dim)',    pulse: false },
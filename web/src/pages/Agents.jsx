@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from 'react'
-import { Plus, RefreshCw, ChevronDown, ChevronUp, Server, RotateCw, Trash2, Loader2 } from 'lucide-react'
+import { Plus, RefreshCw, ChevronDown, ChevronUp, Server, RotateCw, Trash2, Loader2, PauseCircle, PlayCircle } from 'lucide-react'
 import { agentsApi, credentialsApi } from '../api/index.js'
 import Badge from '../components/Badge.jsx'
 
@@ -9,7 +9,7 @@ function fmtDate(iso) {
 }
 
 function provisionLabel(status) {
-  const map = { pending: 'provisioning', running: 'provisioning', success: 'online', failed: 'failed' }
+  const map = { pending: 'provisioning', running: 'provisioning', success: 'online', failed: 'failed', interrupted: 'interrupted' }
   return map[status] || status
 }
 
@@ -38,7 +38,7 @@ export default function Agents() {
   const rows = [
     ...agents.map(a => ({ type: 'agent', key: a.id, agent: a, job: jobs.find(j => j.agent_id === a.id) })),
     ...jobs
-      .filter(j => !j.agent_id && !agentIPs.has(j.host_ip) && (j.status === 'failed' || j.status === 'pending' || j.status === 'running'))
+      .filter(j => !j.agent_id && !agentIPs.has(j.host_ip) && ['failed', 'pending', 'running', 'interrupted'].includes(j.status))
       .map(j => ({ type: 'provision', key: j.id, agent: null, job: j })),
   ]
 
@@ -57,6 +57,11 @@ export default function Agents() {
     finally { setDeleting(null) }
   }
 
+  const handleToggleAgent = async (agent) => {
+    try { await agentsApi.setEnabled(agent.id, !agent.enabled); await reload() }
+    catch (err) { setError(err.message) }
+  }
+
   const handleDeleteJob = async (jobId) => {
     if (!confirm('Delete this provision job?')) return
     setDeleting(jobId)
@@ -115,6 +120,7 @@ export default function Agents() {
               const isExpanded    = expandedRow === row.key
               const isFailed      = row.job?.status === 'failed'
               const isProvisioning = row.job?.status === 'running' || row.job?.status === 'pending'
+              const isInterrupted = row.job?.status === 'interrupted'
               const clickable     = !!row.job?.log
 
               return (
@@ -154,19 +160,28 @@ export default function Agents() {
                     </td>
                     <td>
                       {row.agent
-                        ? <Badge label={row.agent.status} />
+                        ? <span style={{ display: 'inline-flex', gap: 6 }}>
+                            <Badge label={row.agent.status} />
+                            {row.agent.enabled === false && <Badge label="disabled" />}
+                          </span>
                         : <Badge label={provisionLabel(row.job?.status)} />}
                     </td>
                     <td>
                       {isProvisioning ? (
                         <span style={{ display: 'flex', alignItems: 'center', gap: 6, fontSize: 12, color: 'var(--amber)' }}>
                           <Loader2 size={12} style={{ animation: 'spin 1.2s linear infinite' }} />
-                          {row.job?.current_step}
+                          {row.job?.queue_position
+                            ? `queued #${row.job.queue_position}${row.job.estimated_wait_sec ? ` · ~${Math.ceil(row.job.estimated_wait_sec / 60)}m` : ''}`
+                            : row.job?.current_step}
                         </span>
                       ) : isFailed ? (
                         <span style={{ fontSize: 12, color: 'var(--red)' }}>
                           {row.job?.failed_step || row.job?.current_step}
                         </span>
+                      ) : isInterrupted ? (
+                        <span style={{ fontSize: 12, color: 'var(--amber)' }}>
+                          {row.job?.current_step}
+                        </span>
                       ) : (
                         <span style={{ color: 'var(--text-muted)', fontSize: 12 }}>—</span>
                       )}
@@ -185,7 +200,7 @@ export default function Agents() {
                     </td>
                     <td onClick={e => e.stopPropagation()}>
                       <div style={{ display: 'flex', alignItems: 'center', gap: 6 }}>
-                        {isFailed && (
+                        {(isFailed || isInterrupted) && (
                           <button
                             onClick={() => handleRetry(row.job.id)}
                             disabled={retrying === row.job.id}
@@ -202,6 +217,20 @@ export default function Agents() {
                             Retry
                           </button>
                         )}
+                        {row.agent && (
+                          <button
+                            onClick={() => handleToggleAgent(row.agent)}
+                            title={row.agent.enabled === false ? 'Enable: assign new tasks again' : 'Disable: assign no new tasks'}
+                            style={{
+                              padding: '6px', borderRadius: 6, background: 'none', border: 'none',
+                              color: 'var(--text-muted)', cursor: 'pointer', transition: 'color 0.12s',
+                            }}
+                            onMouseEnter={e => e.currentTarget.style.color = 'var(--amber)'}
+                            onMouseLeave={e => e.currentTarget.style.color = 'var(--text-muted)'}
+                          >
+                            {row.agent.enabled === false ? <PlayCircle size={14} /> : <PauseCircle size={14} />}
+                          </button>
+                        )}
                         {(row.agent || row.job) && (
                           <button
                             onClick={() => row.agent ? handleDeleteAgent(row.agent.id) : handleDeleteJob(row.job.id)}
@@ -1,5 +1,6 @@
 import React, { useState, useEffect, useRef, useCallback } from 'react'
-import { Activity, Server, ListTodo, Zap } from 'lucide-react'
+import { useNavigate } from 'react-router-dom'
+import { Activity, Server, ListTodo, Zap, Rocket } from 'lucide-react'
 import {
   AreaChart, Area, XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer, Legend
 } from 'recharts'
@@ -51,6 +52,7 @@ const btnBase = {
 }
 
 export default function Dashboard() {
+  const navigate = useNavigate()
   const [overview, setOverview] = useState(null)
   const [history,  setHistory]  = useState([])
   const [liveData, setLiveData] = useState([])
@@ -140,6 +142,8 @@ export default function Dashboard() {
     ? [...overview.agents].sort((a, b) => b.rate_mbps - a.rate_mbps)
     : []
   const maxRate = sortedAgents[0]?.rate_mbps || 1
+  const jobs = overview?.provision_jobs
+  const topTasks = overview?.top_tasks ?? []
 
   return (
     <div style={{ padding: '28px 32px', display: 'flex', flexDirection: 'column', gap: 24 }}>
@@ -161,14 +165,14 @@ export default function Dashboard() {
       {error && <div className="error-bar">{error}</div>}
 
       {/* Stats */}
-      <div style={{ display: 'grid', gridTemplateColumns: 'repeat(4, 1fr)', gap: 14 }}>
+      <div style={{ display: 'grid', gridTemplateColumns: 'repeat(5, 1fr)', gap: 14 }}>
         <StatCard title="Total Bandwidth"
           value={overview ? overview.total_rate_mbps.toFixed(1) + ' Mbps' : null}
           icon={Activity} color="orange" />
         <StatCard title="Online Agents"
           value={overview?.online_agents}
           icon={Server} color="green"
-          sub={`${overview?.total_agents ?? 0} registered`} />
+          sub={`${overview?.total_agents ?? 0} registered${overview?.outdated_agents ? ` · ${overview.outdated_agents} outdated` : ''}`} />
         <StatCard title="Running Tasks"
           value={overview?.running_tasks}
           icon={Zap} color="yellow"
@@ -176,6 +180,10 @@ export default function Dashboard() {
         <StatCard title="Total Tasks"
           value={overview?.total_tasks}
           icon={ListTodo} color="purple" />
+        <StatCard title="Provisioning"
+          value={jobs ? jobs.pending + jobs.running + jobs.interrupted : null}
+          icon={Rocket} color="blue"
+          sub={`${jobs?.success ?? 0} deployed · ${jobs?.failed ?? 0} failed · ${overview?.credentials ?? 0} credentials`} />
       </div>
 
       {/* Bandwidth chart */}
@@ -256,6 +264,47 @@ export default function Dashboard() {
         )}
       </div>
 
+      {/* Top tasks */}
+      <div className="card" style={{ overflow: 'hidden' }}>
+        <div style={{ padding: '20px 24px 16px', borderBottom: '1px solid var(--border)' }}>
+          <h2 style={{ fontFamily: 'var(--font-serif)', fontWeight: 600, fontSize: 16, color: 'var(--text)' }}>
+            Top Tasks by Bandwidth
+          </h2>
+        </div>
+
+        {topTasks.length > 0 ? (
+          <table style={{ width: '100%', borderCollapse: 'collapse' }}>
+            <thead className="tbl-head">
+              <tr>
+                <th>Task</th>
+                <th>Agent</th>
+                <th style={{ textAlign: 'right' }}>Rate (Mbps)</th>
+              </tr>
+            </thead>
+            <tbody>
+              {topTasks.map(t => (
+                <tr key={t.task_id} className="tbl-row" style={{ cursor: 'pointer' }}
+                  onClick={() => navigate(`/tasks/${t.task_id}`)}>
+                  <td>
+                    <span style={{ fontWeight: 500 }}>{t.name || t.task_id.slice(0, 8)}</span>
+                  </td>
+                  <td>
+                    <span style={{ color: 'var(--text-dim)' }}>
+                      {t.agent_id ? (t.hostname || t.agent_id.slice(0, 8)) : `${t.agents} agents`}
+                    </span>
+                  </td>
+                  <td style={{ textAlign: 'right' }}>
+                    <span className="mono" style={{ fontWeight: 500 }}>{t.rate_mbps.toFixed(2)}</span>
+                  </td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        ) : (
+          <div className="empty">No running tasks</div>
+        )}
+      </div>
+
       {/* Agent ranking */}
       <div className="card" style={{ overflow: 'hidden' }}>
         <div style={{ padding: '20px 24px 16px', borderBottom: '1px solid var(--border)' }}>
@@ -271,7 +320,8 @@ export default function Dashboard() {
                 <th>Agent</th>
                 <th>IP Address</th>
                 <th>Status</th>
-                <th style={{ textAlign: 'right' }}>Rate (Mbps)</th>
+                <th style={{ textAlign: 'right' }} title="Measured on the agent's network interface">Measured (Mbps)</th>
+                <th style={{ textAlign: 'right' }} title="Sum of the agent's task rates">Tasks (Mbps)</th>
                 <th style={{ textAlign: 'right', width: 140 }}>Share</th>
               </tr>
             </thead>
@@ -290,8 +340,15 @@ export default function Dashboard() {
                     </td>
                     <td><Badge label={a.status} /></td>
                     <td style={{ textAlign: 'right' }}>
-                      <span className="mono" style={{ fontWeight: 500 }}>
-                        {a.rate_mbps.toFixed(2)}
+                      {a.rate_measured ? (
+                        <span className="mono" style={{ fontWeight: 500 }}>
+                          {a.rate_mbps.toFixed(2)}
+                        </span>
+                      ) : <span style={{ color: 'var(--text-muted)' }} title="Not measured on this agent">—</span>}
+                    </td>
+                    <td style={{ textAlign: 'right' }}>
+                      <span className="mono" style={{ color: 'var(--text-dim)' }}>
+                        {a.task_rate_mbps.toFixed(2)}
                       </span>
                     </td>
                     <td>
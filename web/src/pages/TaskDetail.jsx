@@ -162,7 +162,7 @@ export default function TaskDetail() {
                 <Play size={13} /> Dispatch
               </button>
             )}
-            {(task.status === 'running' || task.status === 'dispatched') && (
+            {(task.status === 'running' || task.status === 'dispatched' || task.status === 'paused') && (
               <button
                 onClick={async () => { await tasksApi.stop(id); reload() }}
                 style={{
@@ -189,7 +189,7 @@ export default function TaskDetail() {
           <Tile label="Status"><Badge label={task.status} /></Tile>
           <Tile label="Type"><Badge label={task.type} /></Tile>
           <Tile label="Target Rate">
-            <span className="mono">{task.target_rate_mbps} Mbps</span>
+            <span className="mono">{task.target_rps ? `${task.target_rps} req/s` : `${task.target_rate_mbps} Mbps`}</span>
           </Tile>
           <Tile label="Downloaded">
             <span className="mono">{fmtBytes(task.total_bytes_done)}</span>
@@ -170,7 +170,7 @@ export default function Tasks() {
                         <Play size={12} />
                       </button>
                     )}
-                    {(t.status === 'running' || t.status === 'dispatched') && (
+                    {(t.status === 'running' || t.status === 'dispatched' || t.status === 'paused') && (
                       <button onClick={() => stop(t.id)}
                         style={{
                           padding: '6px 8px', borderRadius: 6,
@@ -204,10 +204,10 @@ function CreateTaskModal({ agents, pools, metaLoaded, onClose, onSuccess }) {
   const [form, setForm] = useState({
     name: '', pool_ids: [], agent_id: '', execution_scope: 'global',
     target_rate_mbps: 10000, duration_days: 7,
-    distribution: 'flat', jitter_pct: 0,
+    distribution: 'flat', jitter_pct: 0, timezone: '',
     ramp_up_sec: 0, ramp_down_sec: 0,
-    concurrent_fragments: 1, retries: 3,
-    total_bytes_target: 0, dispatch_rate_tpm: 0,
+    concurrent_fragments: 1, retries: 3, reuse_connections: true,
+    total_bytes_target: 0, dispatch_rate_tpm: 0, warmup_sec: 0, stagger_sec: 0,
   })
   const [loading, setLoading] = useState(false)
   const [error,   setError]   = useState(null)
@@ -234,6 +234,8 @@ function CreateTaskModal({ agents, pools, metaLoaded, onClose, onSuccess }) {
         retries: +form.retries,
         total_bytes_target: +form.total_bytes_target,
         dispatch_rate_tpm: +form.dispatch_rate_tpm,
+        warmup_sec: +form.warmup_sec,
+        stagger_sec: +form.stagger_sec,
       }
       delete payload.duration_days
       await tasksApi.create(payload); onSuccess()
@@ -338,7 +340,7 @@ function CreateTaskModal({ agents, pools, metaLoaded, onClose, onSuccess }) {
             </Field>
           </div>
 
-          <div style={{ display: 'grid', gridTemplateColumns: '1fr 1fr', gap: 12 }}>
+          <div style={{ display: 'grid', gridTemplateColumns: '1fr 1fr 1fr', gap: 10 }}>
             <Field label="Target Rate (Mbps)">
               <input type="number" step="0.1" min="0" className="input" value={form.target_rate_mbps}
                 onChange={e => set('target_rate_mbps', e.target.value)} />
@@ -347,6 +349,10 @@ function CreateTaskModal({ agents, pools, metaLoaded, onClose, onSuccess }) {
               <input type="number" step="0.1" min="0" className="input" value={form.duration_days}
                 onChange={e => set('duration_days', e.target.value)} />
             </Field>
+            <Field label="Warm-up (s)">
+              <input type="number" min="0" className="input" value={form.warmup_sec}
+                onChange={e => set('warmup_sec', e.target.value)} />
+            </Field>
           </div>
 
           <div style={{ display: 'grid', gridTemplateColumns: '1fr 1fr 1fr', gap: 10 }}>
@@ -367,6 +373,29 @@ function CreateTaskModal({ agents, pools, metaLoaded, onClose, onSuccess }) {
             </Field>
           </div>
 
+          {form.distribution === 'diurnal' && (
+            <Field label="Timezone (IANA, optional)">
+              <input className="input" placeholder="Asia/Tokyo — empty follows the agent's local clock"
+                value={form.timezone} onChange={e => set('timezone', e.target.value.trim())} />
+            </Field>
+          )}
+
+          {form.pool_ids.length > 1 && (
+            <Field label="Stagger child starts (s)">
+              <input type="number" min="0" className="input" value={form.stagger_sec}
+                onChange={e => set('stagger_sec', e.target.value)} />
+            </Field>
+          )}
+
+          {selectedTypes.includes('static') && (
+            <label style={{ display: 'flex', alignItems: 'center', gap: 8, fontSize: 13, color: 'var(--text-muted)' }}>
+              <input type="checkbox" checked={form.reuse_connections}
+                onChange={e => set('reuse_connections', e.target.checked)}
+                style={{ accentColor: 'var(--accent)' }} />
+              Reuse connections (off: new TCP/TLS handshake per request, costs agent CPU)
+            </label>
+          )}
+
           {selectedTypes.includes('youtube') && (
             <div style={{ display: 'grid', gridTemplateColumns: '1fr 1fr', gap: 12 }}>
               <Field label="Concurrent Fragments">